@@ -6,4 +6,24 @@ type AgentMetadata struct {
 	// ClusterID is the name of the cluster or host where the agent is running.
 	// It may send data for other clusters in its datareadings.
 	ClusterID string `json:"cluster_id"`
+	// Cluster is the identity of the cluster where the agent is running,
+	// derived from the cluster itself when cluster identity is enabled.
+	Cluster *ClusterIdentity `json:"cluster,omitempty"`
+}
+
+// ClusterIdentity identifies a cluster independently of the name it is
+// configured with, so that the readings of a cluster can be deduplicated
+// across reinstalls of the agent.
+type ClusterIdentity struct {
+	// UID is the UID of the kube-system namespace, which is stable for the
+	// lifetime of the cluster.
+	UID string `json:"uid"`
+	// Provider is the cloud provider of the nodes, e.g. aws, gcp or azure.
+	Provider string `json:"provider,omitempty"`
+	// Region is the region of the nodes.
+	Region string `json:"region,omitempty"`
+	// KubernetesVersion is the version of the API server.
+	KubernetesVersion string `json:"kubernetes_version,omitempty"`
+	// NodeCount is the number of nodes of the cluster.
+	NodeCount int64 `json:"node_count"`
 }
@@ -0,0 +1,36 @@
+package api
+
+import "time"
+
+// Attestation is the detached signature of an upload of readings, sent by
+// the agent in the X-Preflight-Attestation header as base64 encoded JSON.
+// The backend verifies Signature over the exact bytes of Statement, then that
+// the statement matches the body of the upload.
+type Attestation struct {
+	// Statement is the JSON encoded AttestationStatement that is signed.
+	Statement []byte `json:"statement"`
+	// Signature is the signature of the statement: ECDSA or RSA PKCS #1
+	// v1.5 over its SHA-256 digest, or Ed25519 over the statement itself.
+	Signature []byte `json:"signature"`
+	// KeyID is the hex encoded SHA-256 digest of the PKIX encoded public key
+	// of the signature.
+	KeyID string `json:"key_id"`
+	// Certificates is the PEM encoded certificate chain of the key, for
+	// keyless signatures. It is empty for configured keys.
+	Certificates string `json:"certificates,omitempty"`
+}
+
+// AttestationStatement describes the upload an Attestation is for.
+type AttestationStatement struct {
+	// PayloadSHA256 is the hex encoded SHA-256 digest of the body of the
+	// upload, before its compression.
+	PayloadSHA256 string `json:"payload_sha256"`
+	// ContentType is the content type of the body of the upload.
+	ContentType string `json:"content_type"`
+	// AgentVersion and ClusterID are the ones of the AgentMetadata of the
+	// upload.
+	AgentVersion string `json:"agent_version"`
+	ClusterID    string `json:"cluster_id"`
+	// SignedAt is when the upload was signed.
+	SignedAt time.Time `json:"signed_at"`
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -17,11 +18,134 @@ type DataReadingsPost struct {
 type DataReading struct {
 	// ClusterID is optional as it can be infered from the agent
 	// token when using basic authentication.
-	ClusterID     string      `json:"cluster_id,omitempty"`
-	DataGatherer  string      `json:"data-gatherer"`
-	Timestamp     Time        `json:"timestamp"`
-	Data          interface{} `json:"data"`
-	SchemaVersion string      `json:"schema_version"`
+	ClusterID    string      `json:"cluster_id,omitempty"`
+	DataGatherer string      `json:"data-gatherer"`
+	Timestamp    Time        `json:"timestamp"`
+	Data         interface{} `json:"data"`
+	// SchemaVersion is the version of the schema of the reading.
+	SchemaVersion string `json:"schema_version"`
+	// DataGathererKind is the kind of the data gatherer, e.g. k8s-dynamic.
+	// With DataVersion, it identifies the schema of Data.
+	DataGathererKind string `json:"data_gatherer_kind,omitempty"`
+	// DataVersion is the version of the schema of Data for the kind of data
+	// gatherer. It is unset in the readings of the agents predating it,
+	// which have the first version.
+	DataVersion string `json:"data_version,omitempty"`
+	// Chunk is set when the data of a data gatherer is split across several
+	// readings uploaded separately.
+	Chunk *DataReadingChunk `json:"chunk,omitempty"`
+	// Degraded is set when the data gatherer reported that the data may be
+	// stale, e.g. because it failed to watch some resources.
+	Degraded bool `json:"degraded,omitempty"`
+	// DegradedReason describes why the data gatherer is degraded.
+	DegradedReason string `json:"degraded_reason,omitempty"`
+	// Health is the health of the data gatherer when the reading was taken.
+	Health *DataGathererHealth `json:"health,omitempty"`
+	// Error is set when the data gatherer could not be fetched, in which
+	// case the reading has no data.
+	Error string `json:"error,omitempty"`
+	// Issues are the errors and warnings of the data gatherer, e.g. a
+	// resource type it is not allowed to watch. The data may be partial when
+	// there are any.
+	Issues []*DataReadingIssue `json:"issues,omitempty"`
+}
+
+const (
+	// IssueSeverityError is the severity of the issues leaving data out,
+	// e.g. a failed fetch or a resource type the agent cannot read.
+	IssueSeverityError = "error"
+	// IssueSeverityWarning is the severity of the issues making the data
+	// stale or incomplete.
+	IssueSeverityWarning = "warning"
+)
+
+const (
+	// IssueForbidden is the code of the issues caused by the API server
+	// denying access to resources, usually missing RBAC permissions.
+	IssueForbidden = "forbidden"
+	// IssueUnauthorized is the code of the issues caused by the credentials
+	// of the agent being rejected.
+	IssueUnauthorized = "unauthorized"
+	// IssueTimeout is the code of the issues caused by timeouts.
+	IssueTimeout = "timeout"
+	// IssueTruncated is the code of the issues caused by limits leaving
+	// resources out or reducing them.
+	IssueTruncated = "truncated"
+	// IssueWatchFailed is the code of the issues caused by the watch of a
+	// resource type failing for another reason.
+	IssueWatchFailed = "watch-failed"
+	// IssueNotSynced is the code of the issues caused by resources not
+	// synced yet.
+	IssueNotSynced = "not-synced"
+	// IssueDegraded is the code of the issues of the data gatherers
+	// reporting their data may be stale without more details.
+	IssueDegraded = "degraded"
+	// IssueConfig is the code of the issues caused by the configuration of
+	// the data gatherer.
+	IssueConfig = "config"
+	// IssueFailed is the code of the other issues.
+	IssueFailed = "failed"
+)
+
+// DataReadingIssue is an error or a warning of a data gatherer.
+type DataReadingIssue struct {
+	// Severity is IssueSeverityError or IssueSeverityWarning.
+	Severity string `json:"severity"`
+	// Code classifies the issue, e.g. IssueForbidden.
+	Code string `json:"code"`
+	// ResourceType is the type of the resources affected, keyed as in the
+	// data of the k8s-dynamic data gatherer, e.g. pods.v1. It is empty when
+	// the issue affects the whole data gatherer.
+	ResourceType string `json:"resource_type,omitempty"`
+	// Message describes the issue.
+	Message string `json:"message"`
+}
+
+// DataGathererHealth describes the health of a data gatherer across the
+// cycles of the agent.
+type DataGathererHealth struct {
+	// LastSuccess is the last time the data gatherer was fetched
+	// successfully.
+	LastSuccess *Time `json:"last_success,omitempty"`
+	// Items is the number of resources returned by the last successful
+	// fetch, it is zero for the data gatherers not returning resources.
+	Items int `json:"items"`
+	// Errors is the number of failed fetches since the agent started, and
+	// ConsecutiveErrors the number of failed fetches since the last success.
+	Errors            int `json:"errors"`
+	ConsecutiveErrors int `json:"consecutive_errors"`
+	// LastError is the error of the last failed fetch.
+	LastError string `json:"last_error,omitempty"`
+	// Degraded is the reason the data of the data gatherer may be stale, as
+	// reported on the last successful fetch.
+	Degraded string `json:"degraded,omitempty"`
+}
+
+// Healthy returns an error if the data gatherer has never been fetched
+// successfully, if its last maxConsecutiveErrors fetches failed or if its data
+// may be stale. A single failed fetch is enough if maxConsecutiveErrors is not
+// positive.
+func (h *DataGathererHealth) Healthy(maxConsecutiveErrors int) error {
+	switch {
+	case h.ConsecutiveErrors > 0 && (h.LastSuccess == nil || h.ConsecutiveErrors >= maxConsecutiveErrors):
+		return fmt.Errorf("%d consecutive error(s): %s", h.ConsecutiveErrors, h.LastError)
+	case h.LastSuccess == nil:
+		return fmt.Errorf("not fetched yet")
+	case h.Degraded != "":
+		return fmt.Errorf("degraded: %s", h.Degraded)
+	}
+	return nil
+}
+
+// DataReadingChunk identifies one of the readings a data gatherer's data has
+// been split into.
+type DataReadingChunk struct {
+	// ID is shared by all the chunks of the same Fetch.
+	ID string `json:"id"`
+	// Index is the position of the chunk, starting at 0.
+	Index int `json:"index"`
+	// Last is set on the final chunk, which may contain no items.
+	Last bool `json:"last"`
 }
 
 // GatheredResource wraps the raw k8s resource that is sent to the jetstack secure backend
@@ -30,6 +154,30 @@ type GatheredResource struct {
 	// should be of type unstructured.Unstructured, raw Object
 	Resource  interface{}
 	DeletedAt Time
+	// Provenance describes how the resource was gathered, it is only set
+	// when enabled in the agent configuration.
+	Provenance *Provenance
+}
+
+// Provenance describes where a GatheredResource comes from and how it was
+// processed before being sent.
+type Provenance struct {
+	// DataGatherer is the name of the data gatherer in the agent configuration.
+	DataGatherer string `json:"data_gatherer"`
+	// DataGathererKind is the kind of the data gatherer, e.g. k8s-dynamic.
+	DataGathererKind string `json:"data_gatherer_kind,omitempty"`
+	// AgentVersion is the version of the agent that gathered the resource.
+	AgentVersion string `json:"agent_version"`
+	ClusterID    string `json:"cluster_id,omitempty"`
+	// GatheredAt is the time at which the data gatherer was fetched.
+	GatheredAt Time `json:"gathered_at"`
+	// KubeconfigContext is the kubeconfig context used to read the resource,
+	// empty when running in cluster.
+	KubeconfigContext string `json:"kubeconfig_context,omitempty"`
+	// AnonymizationProfile is the name of the profile applied to the resource.
+	AnonymizationProfile string `json:"anonymization_profile,omitempty"`
+	// HashedIdentifiers are the identifiers replaced with their hashes.
+	HashedIdentifiers []string `json:"hashed_identifiers,omitempty"`
 }
 
 func (v GatheredResource) MarshalJSON() ([]byte, error) {
@@ -39,11 +187,13 @@ func (v GatheredResource) MarshalJSON() ([]byte, error) {
 	}
 
 	data := struct {
-		Resource  interface{} `json:"resource"`
-		DeletedAt string      `json:"deleted_at,omitempty"`
+		Resource   interface{} `json:"resource"`
+		DeletedAt  string      `json:"deleted_at,omitempty"`
+		Provenance *Provenance `json:"provenance,omitempty"`
 	}{
-		Resource:  v.Resource,
-		DeletedAt: dateString,
+		Resource:   v.Resource,
+		DeletedAt:  dateString,
+		Provenance: v.Provenance,
 	}
 
 	return json.Marshal(data)
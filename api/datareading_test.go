@@ -34,3 +34,22 @@ func TestJSONGatheredResourceSetsTimeWhenPresent(t *testing.T) {
 		t.Fatalf("unexpected json \ngot  %s\nwant %s", string(bytes), expected)
 	}
 }
+
+func TestJSONGatheredResourceSetsProvenanceWhenPresent(t *testing.T) {
+	var resource GatheredResource
+	resource.Provenance = &Provenance{
+		DataGatherer: "k8s/pods",
+		AgentVersion: "v0.1.0",
+		GatheredAt:   Time{time.Date(2021, 3, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	bytes, err := json.Marshal(resource)
+	if err != nil {
+		t.Fatalf("failed to marshal %s", err)
+	}
+
+	expected := `{"resource":null,"provenance":{"data_gatherer":"k8s/pods","agent_version":"v0.1.0","gathered_at":"2021-03-29T00:00:00Z"}}`
+
+	if string(bytes) != expected {
+		t.Fatalf("unexpected json \ngot  %s\nwant %s", string(bytes), expected)
+	}
+}
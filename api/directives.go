@@ -0,0 +1,44 @@
+package api
+
+// DataReadingsResponse is the body of the response of the backend to a
+// DataReadingsPost. Backends that do not send directives reply with an empty
+// body.
+type DataReadingsResponse struct {
+	// Directives are instructions for the agent, applied at runtime.
+	Directives *Directives `json:"directives,omitempty"`
+	// ReceiptID identifies the upload in the backend, e.g. to find it when
+	// investigating missing data.
+	ReceiptID string `json:"receipt_id,omitempty"`
+}
+
+// Directives let the backend change what the agent gathers without a change
+// of its configuration.
+type Directives struct {
+	// NextPeriod is how long the agent waits before its next cycle, e.g.
+	// "5m". It only applies to the next cycle.
+	NextPeriod string `json:"next_period,omitempty"`
+	// DataGatherers are the names of the data gatherers to fetch on the next
+	// cycle, whatever their schedule.
+	DataGatherers []string `json:"data_gatherers,omitempty"`
+	// Resources are the resource types to gather in addition to the
+	// configured ones.
+	Resources []GroupVersionResource `json:"resources,omitempty"`
+}
+
+// Merge adds the directives of a later response to d. The later period
+// replaces the earlier one.
+func (d *Directives) Merge(later *Directives) {
+	if later.NextPeriod != "" {
+		d.NextPeriod = later.NextPeriod
+	}
+	d.DataGatherers = append(d.DataGatherers, later.DataGatherers...)
+	d.Resources = append(d.Resources, later.Resources...)
+}
+
+// GroupVersionResource identifies a Kubernetes resource type, e.g. the
+// version v1 of the deployments of the apps group.
+type GroupVersionResource struct {
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+}
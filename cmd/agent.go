@@ -1,22 +1,32 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"time"
 
 	"github.com/jetstack/preflight/pkg/agent"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/logs"
 	"github.com/jetstack/preflight/pkg/permissions"
 	"github.com/spf13/cobra"
 )
 
+var (
+	logLevel  string
+	logFormat string
+)
+
 var agentCmd = &cobra.Command{
 	Use:   "agent",
 	Short: "start the preflight agent",
 	Long: `The agent will periodically gather data for the configured data
 	gatherers and send it to a remote backend for evaluation`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return logs.Setup(logLevel, logFormat)
+	},
 	Run: agent.Run,
 }
 
@@ -31,30 +41,167 @@ var agentInfoCmd = &cobra.Command{
 	},
 }
 
+var (
+	rbacConfigFilePath string
+	rbacServiceAccount string
+)
+
 var agentRBACCmd = &cobra.Command{
 	Use:   "rbac",
 	Short: "print the agent's minimal RBAC manifest",
-	Long:  `Print RBAC string by reading GVRs`,
+	Long: `Print the minimal ClusterRoles, Roles and bindings granting the
+	agent's service account the permissions to read the resources of the data
+	gatherers of the configuration. The resources of the data gatherers with
+	include-namespaces are only granted in these namespaces`,
 	Run: func(cmd *cobra.Command, args []string) {
+		path := agent.ConfigFilePath
+		if rbacConfigFilePath != "" {
+			path = rbacConfigFilePath
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			logs.Log.Fatalf("Failed to read config file: %s", err)
+		}
+		config, err := agent.ParseConfig(b)
+		if err != nil {
+			logs.Log.Fatalf("Failed to parse config file: %s", err)
+		}
 
+		out, err := permissions.GenerateFullManifestFor(config.DataGatherers, rbacServiceAccount)
+		if err != nil {
+			logs.Log.Fatalf("%s", err)
+		}
+		fmt.Print(out)
+	},
+}
+
+var (
+	validateConfigOffline        bool
+	validateConfigServiceAccount string
+	validateConfigKubeconfig     string
+)
+
+var agentValidateConfigCmd = &cobra.Command{
+	Use:   "validate-config",
+	Short: "validate the agent configuration against the schema and the cluster",
+	Long: `Validate the agent configuration: unknown fields and invalid values,
+	the resource types of the data gatherers against the discovery API of the
+	cluster, and the RBAC of the agent's service account to list and watch them`,
+	Run: func(cmd *cobra.Command, args []string) {
 		b, err := ioutil.ReadFile(agent.ConfigFilePath)
 		if err != nil {
-			log.Fatalf("Failed to read config file: %s", err)
+			logs.Log.Fatalf("Failed to read config file: %s", err)
+		}
+
+		valid := true
+		if err := agent.ValidateConfigSchema(b); err != nil {
+			fmt.Printf("The configuration does not match the schema: %s\n", err)
+			valid = false
 		}
 		config, err := agent.ParseConfig(b)
 		if err != nil {
-			log.Fatalf("Failed to parse config file: %s", err)
+			fmt.Printf("The configuration is invalid: %s\n", err)
+			os.Exit(1)
 		}
 
-		out := permissions.GenerateFullManifest(config.DataGatherers)
-		fmt.Print(out)
+		if !validateConfigOffline {
+			requirements := permissions.ResourceRequirements(config.DataGatherers)
+
+			discoveryClient, err := k8s.NewDiscoveryClient(validateConfigKubeconfig)
+			if err != nil {
+				logs.Log.Fatalf("Failed to create the discovery client: %s", err)
+			}
+			problems, err := permissions.CheckResources(&discoveryClient, requirements)
+			if err != nil {
+				logs.Log.Fatalf("%s", err)
+			}
+
+			clientset, err := k8s.NewClientset(validateConfigKubeconfig)
+			if err != nil {
+				logs.Log.Fatalf("Failed to create the Kubernetes client: %s", err)
+			}
+			accessProblems, err := permissions.CheckAccess(context.Background(), clientset, validateConfigServiceAccount, requirements)
+			if err != nil {
+				logs.Log.Fatalf("%s", err)
+			}
+			problems = append(problems, accessProblems...)
+
+			for _, problem := range problems {
+				fmt.Println(problem)
+				valid = false
+			}
+		}
+
+		if !valid {
+			os.Exit(1)
+		}
+		fmt.Println("The configuration is valid")
 	},
 }
 
+var agentUploadCmd = &cobra.Command{
+	Use:   "upload",
+	Short: "upload a readings bundle to the backend",
+	Long: `Upload a readings bundle previously written by a file output, or
+	with --output-path, to the backend configured in the agent configuration`,
+	Run: agent.Upload,
+}
+
 func init() {
 	rootCmd.AddCommand(agentCmd)
 	agentCmd.AddCommand(agentInfoCmd)
 	agentCmd.AddCommand(agentRBACCmd)
+	agentRBACCmd.Flags().StringVarP(
+		&rbacConfigFilePath,
+		"config",
+		"",
+		"",
+		"Config file location, overrides --agent-config-file.",
+	)
+	agentRBACCmd.Flags().StringVarP(
+		&rbacServiceAccount,
+		"service-account",
+		"",
+		permissions.DefaultServiceAccount,
+		"Service account of the agent the permissions are granted to, in the namespace/name format.",
+	)
+	agentCmd.AddCommand(agentUploadCmd)
+	agentCmd.AddCommand(agentValidateConfigCmd)
+	agentValidateConfigCmd.Flags().BoolVarP(
+		&validateConfigOffline,
+		"offline",
+		"",
+		false,
+		"Only validate the configuration against the schema, without checking the resources and the RBAC in the cluster.",
+	)
+	agentValidateConfigCmd.Flags().StringVarP(
+		&validateConfigServiceAccount,
+		"service-account",
+		"",
+		permissions.DefaultServiceAccount,
+		"Service account of the agent whose RBAC is checked, in the namespace/name format. The identity of the kubeconfig is checked if empty.",
+	)
+	agentValidateConfigCmd.Flags().StringVarP(
+		&validateConfigKubeconfig,
+		"kubeconfig",
+		"",
+		"",
+		"Kubeconfig of the cluster, the default loading rules are used if empty.",
+	)
+	agentUploadCmd.Flags().StringVarP(
+		&agent.UploadFromFile,
+		"from-file",
+		"f",
+		"",
+		"Readings bundle to upload.",
+	)
+	agentUploadCmd.Flags().StringVarP(
+		&agent.UploadEncryptionKeyFile,
+		"encryption-key-file",
+		"",
+		"",
+		"Key of the encryption of the file output, to upload an encrypted readings bundle.",
+	)
 	agentCmd.PersistentFlags().StringVarP(
 		&agent.ConfigFilePath,
 		"agent-config-file",
@@ -81,7 +228,7 @@ func init() {
 		"one-shot",
 		"",
 		false,
-		"Runs agent a single time if true, or continously if false",
+		"Runs agent a single time if true, or continously if false. A single run exits with code 2 if a data gatherer failed.",
 	)
 	agentCmd.PersistentFlags().StringVarP(
 		&agent.OutputPath,
@@ -90,6 +237,34 @@ func init() {
 		"",
 		"Output file path, if used, it will write data to a local file instead of uploading to the preflight server",
 	)
+	agentCmd.PersistentFlags().StringSliceVarP(
+		&agent.OutputNames,
+		"outputs",
+		"",
+		nil,
+		"Comma separated names of the outputs to use for this run, all the configured outputs are used if empty.",
+	)
+	agentCmd.PersistentFlags().StringSliceVarP(
+		&agent.GathererNames,
+		"gatherer",
+		"",
+		nil,
+		"Comma separated names of the data gatherers to run, all the configured data gatherers are run if empty.",
+	)
+	agentCmd.PersistentFlags().StringSliceVarP(
+		&agent.SkipGathererNames,
+		"skip-gatherer",
+		"",
+		nil,
+		"Comma separated names of the data gatherers not to run.",
+	)
+	agentCmd.PersistentFlags().BoolVarP(
+		&agent.DryRun,
+		"dry-run",
+		"",
+		false,
+		"Runs the data gatherers once and prints the payload that would be uploaded to the standard output, without contacting the backend.",
+	)
 	agentCmd.PersistentFlags().StringVarP(
 		&agent.InputPath,
 		"input-path",
@@ -117,4 +292,46 @@ func init() {
 		os.Getenv("API_TOKEN"),
 		"Token used for authentication when API tokens are in use on the backend",
 	)
+	agentCmd.PersistentFlags().StringVarP(
+		&agent.APITokenPath,
+		"api-token-file",
+		"",
+		"",
+		"File containing the API token, reloaded when it changes. Overrides --api-token.",
+	)
+	agentCmd.PersistentFlags().StringVarP(
+		&agent.DualWriteReportPath,
+		"dual-write-report",
+		"",
+		"",
+		"File path where dual-write comparison reports are appended as JSON lines. The file is rotated to <path>.1 once it reaches 10 MiB.",
+	)
+	agentCmd.PersistentFlags().StringVarP(
+		&agent.HealthAddress,
+		"health-address",
+		"",
+		"",
+		"Address to serve the /healthz and /readyz endpoints on, e.g. :8081. They are disabled if empty.",
+	)
+	agentCmd.PersistentFlags().StringVarP(
+		&logLevel,
+		"log-level",
+		"",
+		"info",
+		"Level of the logs: debug, info, warn or error.",
+	)
+	agentCmd.PersistentFlags().StringVarP(
+		&logFormat,
+		"log-format",
+		"",
+		logs.FormatText,
+		"Format of the logs: text or json, which writes an object per line.",
+	)
+	agentCmd.PersistentFlags().StringVarP(
+		&agent.MetricsAddress,
+		"metrics-address",
+		"",
+		"",
+		"Address to serve the Prometheus /metrics endpoint on, e.g. :8081. It is disabled if empty.",
+	)
 }
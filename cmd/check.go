@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"github.com/jetstack/preflight/pkg/check"
+	"github.com/spf13/cobra"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check bundle.json",
+	Short: "evaluate the checks of the policy packages against a readings bundle",
+	Long: `Evaluate the rules of the built-in packages, and of the packages of
+--packages-dir and --packages-oci, against a readings bundle written by a file
+output, or with --output-path, and print the results of the rules. It exits
+with 1 when a rule fails, and with 2 when the checks cannot run. The clusters
+without connectivity to the backend get basic checks this way.`,
+	Args: cobra.ExactArgs(1),
+	Run:  check.Check,
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringVarP(
+		&check.EncryptionKeyFile,
+		"encryption-key-file",
+		"",
+		"",
+		"Key of the encryption of the file output, to check an encrypted readings bundle.",
+	)
+	checkCmd.Flags().StringSliceVarP(
+		&check.PackageIDs,
+		"package",
+		"",
+		nil,
+		"Comma separated IDs of the packages to evaluate, all the packages are evaluated if empty.",
+	)
+	checkCmd.Flags().StringSliceVarP(
+		&check.PackageDirs,
+		"packages-dir",
+		"",
+		nil,
+		"Directories of packages to evaluate in addition to the built-in ones, one package per YAML file.",
+	)
+	checkCmd.Flags().StringSliceVarP(
+		&check.PackageRefs,
+		"packages-oci",
+		"",
+		nil,
+		"OCI artifacts of packages to evaluate in addition to the built-in ones, pinned by digest, e.g. ghcr.io/example/policies@sha256:<digest>.",
+	)
+	checkCmd.Flags().StringVarP(
+		&check.OutputFormat,
+		"output",
+		"o",
+		"text",
+		"Format of the results, text or json.",
+	)
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/jetstack/preflight/pkg/diff"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff a.json b.json",
+	Short: "compare two readings bundles",
+	Long: `Compare two readings bundles written by a file output, or with
+--output-path, and print the resources added, removed and changed from the
+first to the second, by resource type and namespace. It exits with 1 when the
+bundles differ, and with 2 when they cannot be read.`,
+	Args: cobra.ExactArgs(2),
+	Run:  diff.Diff,
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringVarP(
+		&diff.EncryptionKeyFile,
+		"encryption-key-file",
+		"",
+		"",
+		"Key of the encryption of the file output, to compare encrypted readings bundles.",
+	)
+}
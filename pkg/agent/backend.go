@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+// Backend selects a backend registered in pkg/client the readings are posted
+// to, instead of the Jetstack Secure backend at Server.
+type Backend struct {
+	Kind   string `yaml:"kind"`
+	Config client.BackendConfig
+}
+
+// UnmarshalYAML unmarshals a backend resolving the type of its configuration
+// according to Kind.
+func (b *Backend) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		Kind      string      `yaml:"kind"`
+		RawConfig interface{} `yaml:"config"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	b.Kind = aux.Kind
+	cfg, err := client.NewBackendConfig(b.Kind)
+	if err != nil {
+		return fmt.Errorf("cannot parse backend configuration: %v", err)
+	}
+
+	err = reMarshal(aux.RawConfig, cfg)
+	if err != nil {
+		return err
+	}
+
+	b.Config = cfg
+
+	return nil
+}
+
+func (b *Backend) validate() error {
+	if b.Config == nil {
+		return fmt.Errorf("backend.kind is required")
+	}
+	return b.Config.Validate()
+}
+
+// newClient builds the client posting to the backend.
+func (b *Backend) newClient(agentMetadata *api.AgentMetadata) (client.Client, error) {
+	poster, err := b.Config.NewBackend(agentMetadata)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewPosterClient(poster), nil
+}
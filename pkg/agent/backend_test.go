@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+func TestBackendConfigLoad(t *testing.T) {
+	for name, configFileContents := range map[string]string{
+		"backend": `
+      period: 1h
+      cluster_id: "example-cluster"
+      backend:
+        kind: venafi-tpp
+        config:
+          url: https://tpp.example.com
+          access-token-file: /etc/preflight/tpp/token
+`,
+		"shorthand": `
+      period: 1h
+      cluster_id: "example-cluster"
+      venafi-tpp:
+        url: https://tpp.example.com
+        access-token-file: /etc/preflight/tpp/token
+`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			config, err := ParseConfig([]byte(configFileContents))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.Backend == nil || config.Backend.Kind != client.VenafiTPPBackend {
+				t.Fatalf("unexpected backend: %+v", config.Backend)
+			}
+			tpp, ok := config.Backend.Config.(*client.VenafiTPPConfig)
+			if !ok || tpp.URL != "https://tpp.example.com" || tpp.AccessTokenFile != "/etc/preflight/tpp/token" {
+				t.Errorf("unexpected backend config: %+v", config.Backend.Config)
+			}
+		})
+	}
+}
+
+func TestBackendConfigInvalid(t *testing.T) {
+	for name, configFileContents := range map[string]string{
+		"unregistered": `
+      period: 1h
+      cluster_id: "example-cluster"
+      backend:
+        kind: foo
+`,
+		"invalid config": `
+      period: 1h
+      cluster_id: "example-cluster"
+      backend:
+        kind: venafi-tpp
+        config:
+          url: https://tpp.example.com
+`,
+		"both": `
+      period: 1h
+      cluster_id: "example-cluster"
+      backend:
+        kind: venafi-tpp
+        config:
+          url: https://tpp.example.com
+          access-token-file: /etc/preflight/tpp/token
+      venafi-tpp:
+        url: https://tpp.example.com
+        access-token-file: /etc/preflight/tpp/token
+`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseConfig([]byte(configFileContents)); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
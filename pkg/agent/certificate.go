@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/client"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// defaultSecretRefreshInterval is how often the Secret of the client
+// certificate is read again unless configured.
+const defaultSecretRefreshInterval = time.Minute
+
+var secretsGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// ClientCertificate is the certificate the agent authenticates to the
+// backend with, instead of or in addition to its credentials. It is read
+// from files or from a kubernetes.io/tls Secret, and reloaded when rotated.
+type ClientCertificate struct {
+	// CertFile and KeyFile are the paths of the PEM encoded certificate and
+	// key. They are read again when they are modified.
+	CertFile string `yaml:"cert-file,omitempty"`
+	KeyFile  string `yaml:"key-file,omitempty"`
+	// Secret is the namespace/name of a kubernetes.io/tls Secret holding the
+	// certificate and key.
+	Secret string `yaml:"secret,omitempty"`
+	// RefreshInterval is how often the Secret is read again. Defaults to 1m.
+	RefreshInterval time.Duration `yaml:"refresh-interval,omitempty"`
+}
+
+func (c *ClientCertificate) validate() error {
+	files := c.CertFile != "" || c.KeyFile != ""
+	switch {
+	case files && c.Secret != "":
+		return fmt.Errorf("client-certificate cannot use both files and a secret")
+	case files && (c.CertFile == "" || c.KeyFile == ""):
+		return fmt.Errorf("client-certificate.cert-file and client-certificate.key-file must be set together")
+	case !files && c.Secret == "":
+		return fmt.Errorf("client-certificate requires cert-file and key-file, or secret")
+	}
+	if c.Secret != "" {
+		if parts := strings.Split(c.Secret, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("client-certificate.secret must be namespace/name, got %q", c.Secret)
+		}
+	}
+	if c.RefreshInterval < 0 {
+		return fmt.Errorf("client-certificate.refresh-interval cannot be negative")
+	}
+	return nil
+}
+
+// setClientCertificate makes the client authenticate with the certificate.
+// The certificate is loaded once to report a misconfiguration on start.
+func setClientCertificate(c *ClientCertificate, preflightClient client.Client) error {
+	certificateClient, ok := preflightClient.(client.CertificateClient)
+	if !ok {
+		return fmt.Errorf("the client does not support client certificates")
+	}
+
+	var getCertificate func() (*tls.Certificate, error)
+	if c.Secret != "" {
+		cl, err := k8s.NewDynamicClient("")
+		if err != nil {
+			return err
+		}
+		getCertificate = newSecretCertificate(cl, c.Secret, c.RefreshInterval).get
+	} else {
+		getCertificate = (&fileCertificate{certFile: c.CertFile, keyFile: c.KeyFile}).get
+	}
+	if _, err := getCertificate(); err != nil {
+		return err
+	}
+
+	certificateClient.SetClientCertificate(getCertificate)
+	return nil
+}
+
+// fileCertificate loads the certificate from files, and loads it again when
+// either file is modified. The previous certificate is kept if the files
+// cannot be loaded, e.g. while they are being replaced.
+type fileCertificate struct {
+	certFile, keyFile string
+
+	mu                      sync.Mutex
+	cert                    *tls.Certificate
+	certModTime, keyModTime time.Time
+}
+
+func (f *fileCertificate) get() (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	certInfo, certErr := os.Stat(f.certFile)
+	keyInfo, keyErr := os.Stat(f.keyFile)
+	if certErr == nil && keyErr == nil && f.cert != nil &&
+		certInfo.ModTime().Equal(f.certModTime) && keyInfo.ModTime().Equal(f.keyModTime) {
+		return f.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		if f.cert != nil {
+			logs.Log.Warnf("failed to reload the client certificate, using the previous one: %v", err)
+			metrics.ObserveCredentialReload("client certificate", err)
+			return f.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+	}
+	if f.cert != nil {
+		logs.Log.Infof("Reloaded the client certificate from %s", f.certFile)
+		metrics.ObserveCredentialReload("client certificate", nil)
+	}
+	f.cert = &cert
+	if certErr == nil && keyErr == nil {
+		f.certModTime, f.keyModTime = certInfo.ModTime(), keyInfo.ModTime()
+	}
+	return f.cert, nil
+}
+
+// secretCertificate reads the certificate from a kubernetes.io/tls Secret,
+// again once the refresh interval has passed. The previous certificate is
+// kept if the Secret cannot be read.
+type secretCertificate struct {
+	client          dynamic.Interface
+	namespace, name string
+	refreshInterval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	data    string
+	fetched time.Time
+}
+
+func newSecretCertificate(cl dynamic.Interface, secret string, refreshInterval time.Duration) *secretCertificate {
+	// the reference has already been validated when parsing the config
+	parts := strings.SplitN(secret, "/", 2)
+	if refreshInterval == 0 {
+		refreshInterval = defaultSecretRefreshInterval
+	}
+	return &secretCertificate{
+		client:          cl,
+		namespace:       parts[0],
+		name:            parts[1],
+		refreshInterval: refreshInterval,
+	}
+}
+
+func (s *secretCertificate) get() (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cert != nil && time.Since(s.fetched) < s.refreshInterval {
+		return s.cert, nil
+	}
+
+	cert, data, err := s.fetch()
+	if err != nil {
+		if s.cert != nil {
+			logs.Log.Warnf("failed to reload the client certificate, using the previous one: %v", err)
+			metrics.ObserveCredentialReload("client certificate", err)
+			// the Secret is retried on the next interval
+			s.fetched = time.Now()
+			return s.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+	}
+	if s.cert != nil && data != s.data {
+		logs.Log.Infof("Reloaded the client certificate from the secret %s/%s", s.namespace, s.name)
+		metrics.ObserveCredentialReload("client certificate", nil)
+	}
+	s.cert, s.data, s.fetched = cert, data, time.Now()
+	return s.cert, nil
+}
+
+// fetch reads the certificate of the Secret, and returns it with the data
+// it was parsed from.
+func (s *secretCertificate) fetch() (*tls.Certificate, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	secret, err := s.client.Resource(secretsGVR).Namespace(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var pem [2][]byte
+	for i, key := range []string{"tls.crt", "tls.key"} {
+		value, _, _ := unstructured.NestedString(secret.Object, "data", key)
+		if value == "" {
+			return nil, "", fmt.Errorf("the secret %s/%s has no %s", s.namespace, s.name, key)
+		}
+		if pem[i], err = base64.StdEncoding.DecodeString(value); err != nil {
+			return nil, "", fmt.Errorf("the %s of the secret %s/%s is not valid base64: %v", key, s.namespace, s.name, err)
+		}
+	}
+	cert, err := tls.X509KeyPair(pem[0], pem[1])
+	if err != nil {
+		return nil, "", err
+	}
+	return &cert, string(pem[0]) + string(pem[1]), nil
+}
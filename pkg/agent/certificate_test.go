@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+// getKeyPairPEM returns a self-signed certificate and its key.
+func getKeyPairPEM(t *testing.T, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func commonName(t *testing.T, get func() ([]byte, error)) string {
+	der, err := get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return cert.Subject.CommonName
+}
+
+func TestFileCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	f := &fileCertificate{certFile: filepath.Join(dir, "tls.crt"), keyFile: filepath.Join(dir, "tls.key")}
+
+	if _, err := f.get(); err == nil {
+		t.Fatalf("expected error for missing files")
+	}
+
+	write := func(name string, modTime time.Time) {
+		certPEM, keyPEM := getKeyPairPEM(t, name)
+		for path, data := range map[string][]byte{f.certFile: certPEM, f.keyFile: keyPEM} {
+			if err := ioutil.WriteFile(path, data, 0600); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	get := func() ([]byte, error) {
+		cert, err := f.get()
+		if err != nil {
+			return nil, err
+		}
+		return cert.Certificate[0], nil
+	}
+
+	now := time.Now()
+	write("first", now)
+	if name := commonName(t, get); name != "first" {
+		t.Errorf("expected the first certificate, got %q", name)
+	}
+
+	write("second", now.Add(time.Minute))
+	if name := commonName(t, get); name != "second" {
+		t.Errorf("expected the rotated certificate, got %q", name)
+	}
+
+	// a partially written certificate keeps the previous one
+	if err := ioutil.WriteFile(f.certFile, []byte("partial"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name := commonName(t, get); name != "second" {
+		t.Errorf("expected the previous certificate, got %q", name)
+	}
+}
+
+func TestSecretCertificate(t *testing.T) {
+	secret := func(name string) *unstructured.Unstructured {
+		certPEM, keyPEM := getKeyPairPEM(t, name)
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": "agent-tls", "namespace": "preflight"},
+			"type":       "kubernetes.io/tls",
+			"data": map[string]interface{}{
+				"tls.crt": base64.StdEncoding.EncodeToString(certPEM),
+				"tls.key": base64.StdEncoding.EncodeToString(keyPEM),
+			},
+		}}
+	}
+
+	cl := fake.NewSimpleDynamicClient(runtime.NewScheme(), secret("first"))
+	s := newSecretCertificate(cl, "preflight/agent-tls", time.Hour)
+	get := func() ([]byte, error) {
+		cert, err := s.get()
+		if err != nil {
+			return nil, err
+		}
+		return cert.Certificate[0], nil
+	}
+	if name := commonName(t, get); name != "first" {
+		t.Errorf("expected the first certificate, got %q", name)
+	}
+
+	_, err := cl.Resource(secretsGVR).Namespace("preflight").Update(context.Background(), secret("second"), metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name := commonName(t, get); name != "first" {
+		t.Errorf("expected the certificate to be cached until the refresh, got %q", name)
+	}
+	s.fetched = time.Now().Add(-2 * time.Hour)
+	if name := commonName(t, get); name != "second" {
+		t.Errorf("expected the rotated certificate, got %q", name)
+	}
+
+	if _, err := newSecretCertificate(cl, "preflight/missing", 0).get(); err == nil {
+		t.Errorf("expected error for a missing secret")
+	}
+}
+
+func TestValidateClientCertificate(t *testing.T) {
+	for _, c := range []ClientCertificate{
+		{},
+		{CertFile: "tls.crt"},
+		{CertFile: "tls.crt", KeyFile: "tls.key", Secret: "preflight/agent-tls"},
+		{Secret: "agent-tls"},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+}
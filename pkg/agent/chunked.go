@@ -0,0 +1,323 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/metrics"
+	"github.com/jetstack/preflight/pkg/output"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// postChunks posts the readings in uploads of at most config.UploadChunkSize
+// resources, see chunkReadings, stopping at the first upload failing. The
+// readings have been through the same pipeline whether they are chunked or
+// not, chunking only splits the uploads.
+func postChunks(config Config, readings []*api.DataReading, post func([]*api.DataReading) error) error {
+	for _, upload := range chunkReadings(readings, config.UploadChunkSize) {
+		if err := post(upload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkReadings splits the readings into uploads of at most size resources.
+// The readings are kept whole and grouped when they fit, the readings of the
+// data gatherers with more resources are split into chunks uploaded on their
+// own. All the readings are uploaded at once if size is zero.
+func chunkReadings(readings []*api.DataReading, size int) [][]*api.DataReading {
+	if size <= 0 || len(readings) == 0 {
+		return [][]*api.DataReading{readings}
+	}
+
+	var uploads [][]*api.DataReading
+	var current []*api.DataReading
+	count := 0
+	flush := func() {
+		if len(current) > 0 {
+			uploads = append(uploads, current)
+		}
+		current, count = nil, 0
+	}
+	for _, reading := range readings {
+		items := countGatheredResources(reading.Data)
+		if items > size {
+			flush()
+			for _, chunk := range chunkReading(reading, size) {
+				uploads = append(uploads, []*api.DataReading{chunk})
+			}
+			continue
+		}
+		if count+items > size {
+			flush()
+		}
+		current = append(current, reading)
+		count += items
+	}
+	flush()
+	return uploads
+}
+
+// chunkedItem is a gathered resource of a reading being chunked, with the
+// resource type it is grouped under, if any.
+type chunkedItem struct {
+	resourceType string
+	item         *api.GatheredResource
+}
+
+// chunkReading splits the resources of the reading into readings of at most
+// size resources, keeping them grouped by resource type if they are. Each
+// reading carries the chunk it is and the rest of the data, the last one also
+// has the health and the issues of the reading.
+func chunkReading(reading *api.DataReading, size int) []*api.DataReading {
+	data := reading.Data.(map[string]interface{})
+
+	var items []chunkedItem
+	if list, ok := data["items"].([]*api.GatheredResource); ok {
+		for _, item := range list {
+			items = append(items, chunkedItem{item: item})
+		}
+	}
+	resources, grouped := data["resources"].(map[string]map[string]interface{})
+	resourceTypes := make([]string, 0, len(resources))
+	for resourceType := range resources {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+	for _, resourceType := range resourceTypes {
+		list, _ := resources[resourceType]["items"].([]*api.GatheredResource)
+		for _, item := range list {
+			items = append(items, chunkedItem{resourceType: resourceType, item: item})
+		}
+	}
+
+	id := fmt.Sprintf("%x", time.Now().UnixNano())
+	var chunks []*api.DataReading
+	for index := 0; index*size < len(items); index++ {
+		end := (index + 1) * size
+		if end > len(items) {
+			end = len(items)
+		}
+		last := end == len(items)
+
+		chunkData := map[string]interface{}{}
+		if grouped {
+			chunkResources := map[string]map[string]interface{}{}
+			if last {
+				// the last chunk lists all the resource types gathered
+				for _, resourceType := range resourceTypes {
+					chunkResources[resourceType] = map[string]interface{}{"items": []*api.GatheredResource{}}
+				}
+			}
+			chunkData["resources"] = chunkResources
+		}
+		if _, ok := data["items"]; ok || !grouped {
+			chunkData["items"] = []*api.GatheredResource{}
+		}
+		for _, i := range items[index*size : end] {
+			if i.resourceType == "" {
+				chunkData["items"] = append(chunkData["items"].([]*api.GatheredResource), i.item)
+				continue
+			}
+			chunkResources := chunkData["resources"].(map[string]map[string]interface{})
+			if _, ok := chunkResources[i.resourceType]; !ok {
+				chunkResources[i.resourceType] = map[string]interface{}{"items": []*api.GatheredResource{}}
+			}
+			chunkResources[i.resourceType]["items"] = append(chunkResources[i.resourceType]["items"].([]*api.GatheredResource), i.item)
+		}
+
+		// the rest of the data, such as whether the resources are only the
+		// changes since the previous reading, applies to every chunk
+		for key, value := range data {
+			if key != "items" && key != "resources" {
+				chunkData[key] = value
+			}
+		}
+
+		chunk := *reading
+		chunk.Data = chunkData
+		chunk.Chunk = &api.DataReadingChunk{ID: id, Index: index, Last: last}
+		if !last {
+			chunk.Degraded = false
+			chunk.DegradedReason = ""
+			chunk.Health = nil
+			chunk.Issues = nil
+		}
+		chunks = append(chunks, &chunk)
+	}
+	return chunks
+}
+
+// streamsUploads reports whether the resources of the streaming data
+// gatherers can be uploaded as they are fetched, see streamChunks. They can
+// only be if nothing but the upload needs the readings as a whole.
+func streamsUploads(config Config, secondaryClient client.Client, outputs map[string]output.Output, watcher *watcher) bool {
+	return config.UploadChunkSize > 0 && InputPath == "" && OutputPath == "" && !config.skipUpload &&
+		secondaryClient == nil && len(outputs) == 0 && watcher == nil &&
+		config.snapshot == nil && config.ExpirySummary == nil
+}
+
+// splitStreaming separates the data gatherers able to stream their resources
+// from the other ones.
+func splitStreaming(dataGatherers map[string]datagatherer.DataGatherer) (gathered, streamed map[string]datagatherer.DataGatherer) {
+	gathered = map[string]datagatherer.DataGatherer{}
+	streamed = map[string]datagatherer.DataGatherer{}
+	for name, dg := range dataGatherers {
+		if s, ok := dg.(datagatherer.StreamingDataGatherer); ok && s.Streamable() {
+			streamed[name] = dg
+			continue
+		}
+		gathered[name] = dg
+	}
+	return gathered, streamed
+}
+
+// streamChunks fetches the streaming data gatherers one after the other and
+// posts their resources in chunks of at most config.UploadChunkSize resources
+// as they are fetched, so that only a chunk is held in memory at any time.
+// The resources go through the same anonymization, hashing and provenance as
+// the gathered ones. It stops at the first upload failing, the data gatherers
+// failing are reported to the backend as they are by gatherData.
+func streamChunks(ctx context.Context, config Config, dataGatherers map[string]datagatherer.DataGatherer, health *healthTracker, post func([]*api.DataReading) error) error {
+	// the profile has already been validated when parsing the config
+	profile, err := k8s.GetAnonymizationProfile(config.AnonymizationProfile)
+	if err != nil {
+		logs.FromContext(ctx).Fatalf("failed to load anonymization profile: %v", err)
+	}
+	kinds := dataGathererKinds(config)
+
+	for _, name := range sortedNames(dataGatherers) {
+		dg := dataGatherers[name].(datagatherer.StreamingDataGatherer)
+		if err := streamReading(ctx, config, name, kinds[name], dg, profile, health, post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamReading posts the resources of the data gatherer in chunks as they
+// are fetched. The last chunk has the rest of the data, the health and the
+// issues of the reading. The time spent posting does not count towards the
+// fetch timeout.
+func streamReading(ctx context.Context, config Config, name, kind string, dg datagatherer.StreamingDataGatherer, profile *k8s.AnonymizationProfile, health *healthTracker, post func([]*api.DataReading) error) error {
+	log := logs.FromContext(ctx).WithField(logs.DataGathererField, name)
+	_, span := tracing.Start(ctx, "fetch")
+	defer span.End()
+	span.SetAttributes(attribute.String("data_gatherer", name), attribute.Bool("chunked", true))
+
+	start := time.Now()
+	readingName, clusterID := readingIdentity(config, name)
+	id := fmt.Sprintf("%x", start.UnixNano())
+	index, items := 0, 0
+	var page []*api.GatheredResource
+	var uploading time.Duration
+	var uploadErr error
+
+	newChunk := func(data map[string]interface{}, last bool) *api.DataReading {
+		return &api.DataReading{
+			ClusterID:     clusterID,
+			DataGatherer:  readingName,
+			Timestamp:     api.Time{Time: time.Now()},
+			Data:          data,
+			SchemaVersion: schemaVersion,
+			Chunk:         &api.DataReadingChunk{ID: id, Index: index, Last: last},
+		}
+	}
+	send := func(reading *api.DataReading) error {
+		stampDataVersion(reading, kind)
+		posting := time.Now()
+		uploadErr = post([]*api.DataReading{reading})
+		uploading += time.Since(posting)
+		index++
+		return uploadErr
+	}
+
+	hasher, err := config.hashers.forCluster(clusterID)
+	var provenance *api.Provenance
+	if err == nil && config.Provenance {
+		provenance = newProvenance(config, name, kind, dg, profile, start)
+	}
+	timeout := config.fetchTimeout()
+	var rest map[string]interface{}
+	if err == nil {
+		rest, err = dg.FetchStream(func(item *api.GatheredResource) error {
+			if timeout > 0 && time.Since(start)-uploading > timeout {
+				return &fetchTimeoutError{timeout: timeout}
+			}
+			if profile.Name != k8s.AnonymizationProfileNone {
+				anonymized, err := profile.AnonymizeResource(item)
+				if err != nil {
+					return err
+				}
+				item = anonymized
+			}
+			if hasher != nil {
+				item = hasher.HashResource(item)
+			}
+			if provenance != nil {
+				// the resources may be held by the cache of the data
+				// gatherer, copies of them are annotated
+				annotated := *item
+				annotated.Provenance = provenance
+				item = &annotated
+			}
+			items++
+			page = append(page, item)
+			if len(page) < config.UploadChunkSize {
+				return nil
+			}
+			chunk := newChunk(map[string]interface{}{"items": page}, false)
+			page = nil
+			return send(chunk)
+		})
+	}
+	duration := time.Since(start) - uploading
+	span.SetAttributes(attribute.Int("chunks", index))
+	if uploadErr != nil {
+		tracing.RecordError(span, uploadErr)
+		return uploadErr
+	}
+	if err != nil {
+		tracing.RecordError(span, err)
+		h := health.failure(name, err)
+		metrics.ObserveFetch(name, duration, 0, -1, err)
+		// the chunks already posted are incomplete, the failed reading
+		// ends them and tells the backend why
+		reading := failedReading(config, name, kind, dg, err, h)
+		if index > 0 {
+			reading.Chunk = &api.DataReadingChunk{ID: id, Index: index, Last: true}
+		}
+		if err := post([]*api.DataReading{reading}); err != nil {
+			return err
+		}
+		if StrictMode {
+			log.Fatalf("halting datagathering in strict mode due to error in datagatherer %q: %v", name, err)
+		}
+		log.Errorf("failed to gather data from %q datagatherer: %v", name, err)
+		return nil
+	}
+
+	if page == nil {
+		page = []*api.GatheredResource{}
+	}
+	rest["items"] = page
+	reading := newChunk(rest, true)
+	markDegraded(log, name, dg, reading)
+	reading.Issues = gathererIssues(dg)
+	reading.Health = health.success(name, items, reading.DegradedReason)
+	metrics.ObserveFetch(name, duration, items, -1, nil)
+	if err := send(reading); err != nil {
+		return err
+	}
+	log.Infof("successfully gathered data from %q datagatherer in %d chunk(s)", name, index)
+	return nil
+}
@@ -0,0 +1,258 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+func gatheredItems(prefix string, n int) []*api.GatheredResource {
+	items := []*api.GatheredResource{}
+	for i := 0; i < n; i++ {
+		items = append(items, &api.GatheredResource{Resource: fmt.Sprintf("%s-%d", prefix, i)})
+	}
+	return items
+}
+
+func TestChunkReadings(t *testing.T) {
+	small := &api.DataReading{DataGatherer: "small", Data: map[string]interface{}{"items": gatheredItems("small", 1)}}
+	other := &api.DataReading{DataGatherer: "other", Data: map[string]interface{}{"items": gatheredItems("other", 1)}}
+	failed := &api.DataReading{DataGatherer: "failed", Error: "connection refused"}
+	large := &api.DataReading{DataGatherer: "large", Data: map[string]interface{}{"items": gatheredItems("large", 5)}}
+
+	tests := map[string]struct {
+		readings []*api.DataReading
+		size     int
+		expected [][]string
+	}{
+		"disabled": {
+			readings: []*api.DataReading{small, large},
+			expected: [][]string{{"small", "large"}},
+		},
+		"fitting readings are grouped": {
+			readings: []*api.DataReading{small, other, failed},
+			size:     2,
+			expected: [][]string{{"small", "other", "failed"}},
+		},
+		"grouped readings do not exceed the size": {
+			readings: []*api.DataReading{small, other},
+			size:     1,
+			expected: [][]string{{"small"}, {"other"}},
+		},
+		"large readings are chunked on their own": {
+			readings: []*api.DataReading{small, large, other},
+			size:     2,
+			expected: [][]string{{"small"}, {"large"}, {"large"}, {"large"}, {"other"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			uploads := chunkReadings(tc.readings, tc.size)
+			if len(uploads) != len(tc.expected) {
+				t.Fatalf("unexpected number of uploads: got=%d want=%d", len(uploads), len(tc.expected))
+			}
+			for i, upload := range uploads {
+				var names []string
+				for _, reading := range upload {
+					names = append(names, reading.DataGatherer)
+				}
+				if fmt.Sprint(names) != fmt.Sprint(tc.expected[i]) {
+					t.Errorf("unexpected readings in upload %d: got=%v want=%v", i, names, tc.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChunkReading(t *testing.T) {
+	health := &api.DataGathererHealth{Items: 5}
+	issues := []*api.DataReadingIssue{{Severity: api.IssueSeverityWarning, Code: api.IssueNotSynced}}
+
+	tests := map[string]struct {
+		data          map[string]interface{}
+		expectedSizes []int
+	}{
+		"partial last chunk": {
+			data:          map[string]interface{}{"items": gatheredItems("item", 5), "status": "synced"},
+			expectedSizes: []int{2, 2, 1},
+		},
+		"delta": {
+			data:          map[string]interface{}{"items": gatheredItems("item", 3), "delta": true, "status": "synced"},
+			expectedSizes: []int{2, 1},
+		},
+		"full last chunk": {
+			data:          map[string]interface{}{"items": gatheredItems("item", 4), "status": "synced"},
+			expectedSizes: []int{2, 2},
+		},
+		"grouped by resource type": {
+			data: map[string]interface{}{
+				"resources": map[string]map[string]interface{}{
+					"pods.v1":     {"items": gatheredItems("pod", 3)},
+					"services.v1": {"items": gatheredItems("service", 1)},
+					"secrets.v1":  {"items": []*api.GatheredResource{}},
+				},
+				"status": "synced",
+			},
+			expectedSizes: []int{2, 2},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reading := &api.DataReading{
+				ClusterID:     "cluster",
+				DataGatherer:  "k8s/pods",
+				SchemaVersion: schemaVersion,
+				Data:          tc.data,
+				Degraded:      true,
+				Health:        health,
+				Issues:        issues,
+			}
+			chunks := chunkReading(reading, 2)
+			if len(chunks) != len(tc.expectedSizes) {
+				t.Fatalf("unexpected number of chunks: got=%d want=%d", len(chunks), len(tc.expectedSizes))
+			}
+
+			for i, chunk := range chunks {
+				last := i == len(chunks)-1
+				if size := countGatheredResources(chunk.Data); size != tc.expectedSizes[i] {
+					t.Errorf("unexpected size of chunk %d: got=%d want=%d", i, size, tc.expectedSizes[i])
+				}
+				if chunk.Chunk.Index != i || chunk.Chunk.ID != chunks[0].Chunk.ID || chunk.Chunk.Last != last {
+					t.Errorf("unexpected chunk %d: %+v", i, chunk.Chunk)
+				}
+				if chunk.ClusterID != "cluster" || chunk.DataGatherer != "k8s/pods" || chunk.SchemaVersion != schemaVersion {
+					t.Errorf("expected chunk %d to identify the data gatherer, got %+v", i, chunk)
+				}
+				// the rest of the data is sent with every chunk, so that
+				// the chunks of a delta are not taken for full snapshots
+				data := chunk.Data.(map[string]interface{})
+				for key, value := range tc.data {
+					if key != "items" && key != "resources" && data[key] != value {
+						t.Errorf("expected chunk %d to have %s=%v, got %v", i, key, value, data[key])
+					}
+				}
+				// the health and issues are sent once
+				if (chunk.Health != nil) != last || (len(chunk.Issues) > 0) != last || chunk.Degraded != last {
+					t.Errorf("unexpected health or issues in chunk %d: %+v", i, chunk)
+				}
+			}
+
+			if resources, ok := tc.data["resources"].(map[string]map[string]interface{}); ok {
+				last := chunks[len(chunks)-1].Data.(map[string]interface{})["resources"].(map[string]map[string]interface{})
+				for resourceType := range resources {
+					if _, ok := last[resourceType]; !ok {
+						t.Errorf("expected the last chunk to list the %q resource type", resourceType)
+					}
+				}
+			}
+		})
+	}
+}
+
+// streamingDataGatherer streams its items, failing after them if err is set.
+type streamingDataGatherer struct {
+	dummyDataGatherer
+	items []*api.GatheredResource
+	err   error
+}
+
+func (g *streamingDataGatherer) Streamable() bool { return true }
+
+func (g *streamingDataGatherer) FetchStream(fn func(*api.GatheredResource) error) (map[string]interface{}, error) {
+	for _, item := range g.items {
+		if err := fn(item); err != nil {
+			return nil, err
+		}
+	}
+	if g.err != nil {
+		return nil, g.err
+	}
+	return map[string]interface{}{"status": "synced"}, nil
+}
+
+func TestStreamChunks(t *testing.T) {
+	tests := map[string]struct {
+		dg            *streamingDataGatherer
+		expectedSizes []int
+		expectedErr   string
+	}{
+		"no items": {
+			dg:            &streamingDataGatherer{},
+			expectedSizes: []int{0},
+		},
+		"partial last chunk": {
+			dg:            &streamingDataGatherer{items: gatheredItems("item", 5)},
+			expectedSizes: []int{2, 2, 1},
+		},
+		"empty last chunk": {
+			dg:            &streamingDataGatherer{items: gatheredItems("item", 4)},
+			expectedSizes: []int{2, 2, 0},
+		},
+		"failing fetch": {
+			dg:            &streamingDataGatherer{items: gatheredItems("item", 3), err: fmt.Errorf("connection refused")},
+			expectedSizes: []int{2, 0},
+			expectedErr:   "connection refused",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := Config{UploadChunkSize: 2}
+			dataGatherers := map[string]datagatherer.DataGatherer{"k8s/pods": tc.dg}
+			var chunks []*api.DataReading
+			err := streamChunks(context.Background(), config, dataGatherers, newHealthTracker([]string{"k8s/pods"}), func(readings []*api.DataReading) error {
+				chunks = append(chunks, readings...)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(chunks) != len(tc.expectedSizes) {
+				t.Fatalf("unexpected number of chunks: got=%d want=%d", len(chunks), len(tc.expectedSizes))
+			}
+
+			for i, chunk := range chunks {
+				last := i == len(chunks)-1
+				if size := countGatheredResources(chunk.Data); size != tc.expectedSizes[i] {
+					t.Errorf("unexpected size of chunk %d: got=%d want=%d", i, size, tc.expectedSizes[i])
+				}
+				if chunk.Chunk.Index != i || chunk.Chunk.ID != chunks[0].Chunk.ID || chunk.Chunk.Last != last {
+					t.Errorf("unexpected chunk %d: %+v", i, chunk.Chunk)
+				}
+				if chunk.DataGatherer != "k8s/pods" || chunk.SchemaVersion != schemaVersion {
+					t.Errorf("expected chunk %d to identify the data gatherer, got %+v", i, chunk)
+				}
+				// the health is only known once all the resources are read
+				if (chunk.Health != nil) != last {
+					t.Errorf("unexpected health in chunk %d: %+v", i, chunk.Health)
+				}
+			}
+
+			last := chunks[len(chunks)-1]
+			if last.Error != tc.expectedErr {
+				t.Errorf("unexpected error in the last chunk: got=%q want=%q", last.Error, tc.expectedErr)
+			}
+			if tc.expectedErr == "" && last.Data.(map[string]interface{})["status"] != "synced" {
+				t.Errorf("expected the last chunk to have the rest of the data, got %+v", last.Data)
+			}
+		})
+	}
+}
+
+func TestSplitStreaming(t *testing.T) {
+	gathered, streamed := splitStreaming(map[string]datagatherer.DataGatherer{
+		"k8s/pods":  &streamingDataGatherer{},
+		"discovery": &dummyDataGatherer{},
+	})
+	if _, ok := streamed["k8s/pods"]; !ok || len(streamed) != 1 {
+		t.Errorf("unexpected streamed data gatherers: %v", streamed)
+	}
+	if _, ok := gathered["discovery"]; !ok || len(gathered) != 1 {
+		t.Errorf("unexpected gathered data gatherers: %v", gathered)
+	}
+}
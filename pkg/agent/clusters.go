@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// clusterKinds are the kinds of data gatherer that can read from several
+// clusters, as their configuration accepts a kubeconfig and a context.
+var clusterKinds = map[string]bool{
+	"k8s":                        true,
+	"k8s-dynamic":                true,
+	"k8s-discovery":              true,
+	"k8s-owners":                 true,
+	"k8s-tls-secrets":            true,
+	"k8s-configmap-certs":        true,
+	"k8s-ingress-tls":            true,
+	"k8s-images":                 true,
+	"k8s-nodes":                  true,
+	"k8s-events":                 true,
+	"k8s-openshift":              true,
+	"k8s-admission":              true,
+	"k8s-rbac":                   true,
+	"k8s-service-account-issuer": true,
+	"cert-manager":               true,
+	"cert-manager-acme":          true,
+	"istio-mesh":                 true,
+}
+
+// Cluster is a cluster a data gatherer reads from.
+type Cluster struct {
+	// ID identifies the cluster, it is set as the cluster ID of the readings
+	// of the cluster.
+	ID string `yaml:"id"`
+	// KubeConfigPath is the path to the kubeconfig file for the cluster. If
+	// empty, the kubeconfig of the data gatherer configuration is used.
+	KubeConfigPath string `yaml:"kubeconfig,omitempty"`
+	// Context is the kubeconfig context for the cluster. If empty, the
+	// current context of the kubeconfig is used.
+	Context string `yaml:"context,omitempty"`
+}
+
+// rawConfig returns a copy of the raw data gatherer configuration with the
+// kubeconfig and context of the cluster.
+func (c Cluster) rawConfig(rawConfig interface{}) interface{} {
+	config := map[interface{}]interface{}{}
+	if m, ok := rawConfig.(map[interface{}]interface{}); ok {
+		for k, v := range m {
+			config[k] = v
+		}
+	}
+	if c.KubeConfigPath != "" {
+		config["kubeconfig"] = c.KubeConfigPath
+	}
+	if c.Context != "" {
+		config["kubeconfig-context"] = c.Context
+	}
+	return config
+}
+
+// validateClusters validates the clusters of a data gatherer.
+func (dg DataGatherer) validateClusters() error {
+	if len(dg.Clusters) == 0 {
+		return nil
+	}
+
+	var result *multierror.Error
+	if !clusterKinds[dg.Kind] {
+		result = multierror.Append(result, fmt.Errorf("datagatherer %q of kind %q cannot read from several clusters", dg.Name, dg.Kind))
+	}
+	ids := map[string]bool{}
+	for i, cluster := range dg.Clusters {
+		switch {
+		case cluster.ID == "":
+			result = multierror.Append(result, fmt.Errorf("cluster %d/%d of datagatherer %q is missing an id", i+1, len(dg.Clusters), dg.Name))
+		case ids[cluster.ID]:
+			result = multierror.Append(result, fmt.Errorf("cluster id %q is used more than once in datagatherer %q", cluster.ID, dg.Name))
+		case strings.Contains(cluster.ID, clusterSeparator):
+			result = multierror.Append(result, fmt.Errorf("cluster id %q of datagatherer %q cannot contain %q", cluster.ID, dg.Name, clusterSeparator))
+		}
+		ids[cluster.ID] = true
+	}
+	return result.ErrorOrNil()
+}
+
+// clusterSeparator separates the name of a data gatherer from the cluster
+// ID in the names of the expanded data gatherers.
+const clusterSeparator = "@"
+
+// expandClusters replaces every data gatherer reading from several clusters
+// with one data gatherer per cluster, named <name>@<cluster-id>.
+func expandClusters(dataGatherers []DataGatherer) []DataGatherer {
+	var expanded []DataGatherer
+	for _, dg := range dataGatherers {
+		if len(dg.Clusters) == 0 {
+			expanded = append(expanded, dg)
+			continue
+		}
+		for i, cluster := range dg.Clusters {
+			expanded = append(expanded, DataGatherer{
+				Kind:             dg.Kind,
+				Name:             dg.Name + clusterSeparator + cluster.ID,
+				DataPath:         dg.DataPath,
+				Schedule:         dg.Schedule,
+				ExpectItems:      dg.ExpectItems,
+				Config:           dg.clusterConfigs[i],
+				ClusterID:        cluster.ID,
+				AddedByDirective: dg.AddedByDirective,
+			})
+		}
+	}
+	return expanded
+}
+
+// readingIdentity returns the data gatherer name and cluster ID to set on the
+// readings of the data gatherer with the given name, so that the readings of
+// an expanded data gatherer keep the name of the configured data gatherer.
+func readingIdentity(config Config, name string) (string, string) {
+	for _, dg := range config.DataGatherers {
+		if dg.Name == name && dg.ClusterID != "" {
+			return strings.TrimSuffix(name, clusterSeparator+dg.ClusterID), dg.ClusterID
+		}
+	}
+	return name, config.ClusterID
+}
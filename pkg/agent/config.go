@@ -3,16 +3,29 @@ package agent
 import (
 	"fmt"
 	"net/url"
+	"os"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/jetstack/preflight/pkg/client"
 	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/acm"
 	"github.com/jetstack/preflight/pkg/datagatherer/aks"
+	"github.com/jetstack/preflight/pkg/datagatherer/certmanager"
 	"github.com/jetstack/preflight/pkg/datagatherer/eks"
+	"github.com/jetstack/preflight/pkg/datagatherer/exec"
 	"github.com/jetstack/preflight/pkg/datagatherer/gke"
+	"github.com/jetstack/preflight/pkg/datagatherer/hostcerts"
+	"github.com/jetstack/preflight/pkg/datagatherer/httpendpoint"
+	"github.com/jetstack/preflight/pkg/datagatherer/istio"
 	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
 	"github.com/jetstack/preflight/pkg/datagatherer/local"
+	"github.com/jetstack/preflight/pkg/datagatherer/plugin"
+	"github.com/jetstack/preflight/pkg/datagatherer/prometheus"
 	"github.com/jetstack/preflight/pkg/datagatherer/versionchecker"
+	"github.com/jetstack/preflight/pkg/output"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"github.com/jetstack/preflight/pkg/transport"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 )
@@ -21,6 +34,9 @@ import (
 type Config struct {
 	Schedule string        `yaml:"schedule"`
 	Period   time.Duration `yaml:"period"`
+	// Jitter is the maximum random delay added to the period on every cycle,
+	// so that the agents started together do not upload at the same time.
+	Jitter time.Duration `yaml:"jitter,omitempty"`
 	// Deprecated: Endpoint is being replaced with Server.
 	Endpoint Endpoint `yaml:"endpoint"`
 	// Server is the base url for the Preflight server.
@@ -28,13 +44,127 @@ type Config struct {
 	Server string `yaml:"server"`
 	// OrganizationID within Preflight that will receive the data.
 	OrganizationID string `yaml:"organization_id"`
-	// ClusterID is the cluster that the agent is scanning.
-	ClusterID     string         `yaml:"cluster_id"`
-	DataGatherers []DataGatherer `yaml:"data-gatherers"`
+	// ClusterID is the cluster that the agent is scanning. It defaults to
+	// the UID of the kube-system namespace when ClusterIdentity is set.
+	ClusterID string `yaml:"cluster_id"`
+	// ClusterIdentity derives the identity of the cluster, e.g. its provider
+	// and Kubernetes version, and attaches it to every upload.
+	ClusterIdentity *ClusterIdentity `yaml:"cluster-identity,omitempty"`
+	DataGatherers   []DataGatherer   `yaml:"data-gatherers"`
 	// InputPath replaces DataGatherers with input data file
 	InputPath string `yaml:"input-path"`
 	// OutputPath replaces Server with output data file
 	OutputPath string `yaml:"output-path"`
+	// DualWrite sends a copy of every upload to a secondary backend and
+	// compares the results.
+	DualWrite *DualWrite `yaml:"dual-write,omitempty"`
+	// Spool queues the uploads failing after all their retries on disk, to
+	// send them once the backend is reachable again.
+	Spool *Spool `yaml:"spool,omitempty"`
+	// Directives lets the backend adjust the period, the data gatherers
+	// fetched and the resource types gathered at runtime.
+	Directives *Directives `yaml:"directives,omitempty"`
+	// MemoryBudget bounds the memory used by the caches of the data
+	// gatherers.
+	MemoryBudget *MemoryBudget `yaml:"memory-budget,omitempty"`
+	// Health configures the readiness and liveness endpoints, and the
+	// graceful shutdown of the agent.
+	Health *Health `yaml:"health,omitempty"`
+	// RemoteConfig fetches the data gatherers and the period from the
+	// backend, and keeps them in sync with it.
+	RemoteConfig *RemoteConfig `yaml:"remote-config,omitempty"`
+	// AnonymizationProfile is the name of the built-in anonymization profile
+	// applied to all the gathered resources: none, standard or strict.
+	AnonymizationProfile string `yaml:"anonymization-profile,omitempty"`
+	// HashIdentifiers replaces identifiers of the gathered resources, such
+	// as namespaces, Secret names and hostnames, with keyed hashes.
+	HashIdentifiers *HashIdentifiers `yaml:"hash-identifiers,omitempty"`
+	// Outputs are additional destinations the readings are written to, such
+	// as files or the standard output, or the backend itself.
+	Outputs []Output `yaml:"outputs,omitempty"`
+	// Watches are watch expressions evaluated on every cycle to report
+	// changes of specific values between cycles.
+	Watches []Watch `yaml:"watches,omitempty"`
+	// Provenance stamps every gathered resource with metadata describing
+	// how it was gathered.
+	Provenance bool `yaml:"provenance,omitempty"`
+	// ExpirySummary, if set, adds the counts of the certificates expiring
+	// soon of each cluster to the readings.
+	ExpirySummary *ExpirySummary `yaml:"expiry-summary,omitempty"`
+	// DataGathererResources, if set, adds the data gatherers of the
+	// DataGatherer custom resources of a namespace to the ones of the
+	// configuration, and reloads them when the resources change.
+	DataGathererResources *DataGathererResources `yaml:"data-gatherer-resources,omitempty"`
+	// SnapshotEndpoint, if set, serves the last reading of every data
+	// gatherer to the requests with a bearer token.
+	SnapshotEndpoint *SnapshotEndpoint `yaml:"snapshot-endpoint,omitempty"`
+	// UploadStatus, if set, records the outcome of every upload and the
+	// receipt of the last successful one in a ConfigMap.
+	UploadStatus *UploadStatus `yaml:"upload-status,omitempty"`
+	// UploadChunkSize is the maximum number of resources per upload, the
+	// readings of the data gatherers with more resources are split into
+	// chunks. The data gatherers able to stream their resources are uploaded
+	// chunk by chunk as they are fetched when nothing else needs their
+	// readings. Chunked uploads are disabled when it is zero.
+	UploadChunkSize int `yaml:"upload-chunk-size,omitempty"`
+	// FetchConcurrency is the number of data gatherers fetched at the same
+	// time. Defaults to 4.
+	FetchConcurrency int `yaml:"fetch-concurrency,omitempty"`
+	// FetchTimeout is how long a data gatherer is waited for on every
+	// cycle, it fails once exceeded and the readings of the other data
+	// gatherers are sent without it. Defaults to 5m.
+	FetchTimeout time.Duration `yaml:"fetch-timeout,omitempty"`
+	// KubernetesClient are the default rate limits and page size of the
+	// requests made by the data gatherers to the Kubernetes API.
+	KubernetesClient k8s.ClientOptions `yaml:"kubernetes-client,omitempty"`
+	// Tracing, if set, exports spans of every cycle to an OpenTelemetry
+	// collector.
+	Tracing *tracing.Config `yaml:"tracing,omitempty"`
+	// Transport is the proxy and the certificate authorities of the
+	// outbound HTTP clients, set with the proxy and ca-bundle keys.
+	Transport transport.Config `yaml:",inline"`
+	// ClientCertificate authenticates the agent to the backend with a client
+	// certificate, in addition to its credentials if any.
+	ClientCertificate *ClientCertificate `yaml:"client-certificate,omitempty"`
+	// TokenAuth authenticates the agent to the backend with the tokens of an
+	// OAuth2 authorization server, with the client credentials flow or by
+	// exchanging its ServiceAccount token.
+	TokenAuth *TokenAuth `yaml:"token-auth,omitempty"`
+	// Signing signs every upload to the backend with a detached attestation
+	// including the agent version and cluster ID.
+	Signing *Signing `yaml:"signing,omitempty"`
+	// Backend posts the readings to a registered backend, e.g. venafi-tpp,
+	// instead of the Jetstack Secure backend at Server.
+	Backend *Backend `yaml:"backend,omitempty"`
+	// VenafiTPP is a shorthand for a venafi-tpp Backend, sending the readings
+	// to an on-prem Venafi Trust Protection Platform.
+	VenafiTPP *client.VenafiTPPConfig `yaml:"venafi-tpp,omitempty"`
+	// Compression is the content encoding of the uploads to the backend:
+	// gzip, the default, zstd or none to disable it. The backend can ask for
+	// another encoding.
+	Compression string `yaml:"compression,omitempty"`
+	// UploadFormat is the encoding of the readings uploaded to the backend:
+	// json, the default, or cbor. The backend can ask for another format.
+	UploadFormat string `yaml:"upload-format,omitempty"`
+
+	// skipUpload is set when the outputs selected for the run do not include
+	// the backend.
+	skipUpload bool
+	// identity is the identity of the cluster, it is nil unless
+	// ClusterIdentity is set.
+	identity *clusterIdentity
+	// status records the outcome of the uploads, it is nil unless
+	// UploadStatus is set.
+	status *uploadStatus
+	// resources watches the DataGatherer resources, it is nil unless
+	// DataGathererResources is set.
+	resources *gathererResources
+	// snapshot keeps the readings served by the snapshot endpoint, it is nil
+	// unless SnapshotEndpoint is set.
+	snapshot *readingsSnapshot
+	// hashers hash the identifiers of the gathered resources, it is nil
+	// unless HashIdentifiers is set.
+	hashers *identifierHashers
 }
 
 type Endpoint struct {
@@ -47,10 +177,45 @@ type DataGatherer struct {
 	Kind     string `yaml:"kind"`
 	Name     string `yaml:"name"`
 	DataPath string `yaml:"data_path"`
-	Config   datagatherer.Config
+	// Schedule is when the data gatherer is fetched, as an interval, e.g.
+	// 1h, or a cron expression, e.g. "0 * * * *". It is checked on every
+	// cycle of the agent, so it cannot be more frequent than the period. If
+	// empty, the data gatherer is fetched on every cycle.
+	Schedule string `yaml:"schedule,omitempty"`
+	// ExpectItems makes a one-shot run fail if the data gatherer returns no
+	// resources.
+	ExpectItems bool `yaml:"expect-items,omitempty"`
+	Config      datagatherer.Config
+	// Clusters are the clusters the data gatherer reads from. A data
+	// gatherer is run for each of them, using Config with the kubeconfig
+	// and context of the cluster. If empty, a single data gatherer is run
+	// with Config as is.
+	Clusters []Cluster `yaml:"clusters,omitempty"`
+	// ClusterID is set on the data gatherers reading from one of the
+	// Clusters, once they have been expanded.
+	ClusterID string `yaml:"-"`
+	// AddedByDirective is set on the data gatherers added by a directive of
+	// the backend, which are kept when the configuration is reloaded.
+	AddedByDirective bool `yaml:"-"`
+
+	// clusterConfigs are the configurations for each of the Clusters.
+	clusterConfigs []datagatherer.Config
+}
+
+// backendOutputKind is the kind of the output selecting the upload to the
+// backend, which has no configuration.
+const backendOutputKind = "backend"
+
+// Output is the configuration of an additional destination for the readings.
+// The readings are uploaded to the backend in addition to the outputs, unless
+// a backend output is configured and not selected for the run.
+type Output struct {
+	Kind   string `yaml:"kind"`
+	Name   string `yaml:"name"`
+	Config output.Config
 }
 
-func reMarshal(rawConfig interface{}, config datagatherer.Config) error {
+func reMarshal(rawConfig interface{}, config interface{}) error {
 	bb, err := yaml.Marshal(rawConfig)
 	if err != nil {
 		return nil
@@ -67,10 +232,13 @@ func reMarshal(rawConfig interface{}, config datagatherer.Config) error {
 // UnmarshalYAML unmarshals a dataGatherer resolving the type according to Kind.
 func (dg *DataGatherer) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	aux := struct {
-		Kind      string      `yaml:"kind"`
-		Name      string      `yaml:"name"`
-		DataPath  string      `yaml:"data-path,omitempty"`
-		RawConfig interface{} `yaml:"config"`
+		Kind        string      `yaml:"kind"`
+		Name        string      `yaml:"name"`
+		DataPath    string      `yaml:"data-path,omitempty"`
+		Schedule    string      `yaml:"schedule,omitempty"`
+		ExpectItems bool        `yaml:"expect-items,omitempty"`
+		RawConfig   interface{} `yaml:"config"`
+		Clusters    []Cluster   `yaml:"clusters"`
 	}{}
 	err := unmarshal(&aux)
 	if err != nil {
@@ -80,44 +248,182 @@ func (dg *DataGatherer) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	dg.Kind = aux.Kind
 	dg.Name = aux.Name
 	dg.DataPath = aux.DataPath
+	dg.Schedule = aux.Schedule
+	dg.ExpectItems = aux.ExpectItems
+
+	cfg, err := newDataGathererConfig(dg.Kind)
+	if err != nil {
+		return err
+	}
+
+	// we encode aux.RawConfig, which is just a map of reflect.Values, into yaml and decode it again to the right type.
+	err = reMarshal(aux.RawConfig, cfg)
+	if err != nil {
+		return err
+	}
+
+	dg.Config = cfg
 
+	dg.Clusters = aux.Clusters
+	dg.clusterConfigs = nil
+	for _, cluster := range aux.Clusters {
+		clusterCfg, err := newDataGathererConfig(dg.Kind)
+		if err != nil {
+			return err
+		}
+		err = reMarshal(cluster.rawConfig(aux.RawConfig), clusterCfg)
+		if err != nil {
+			return err
+		}
+		dg.clusterConfigs = append(dg.clusterConfigs, clusterCfg)
+	}
+
+	return nil
+}
+
+// newDataGathererConfig returns an empty configuration for the kind of data
+// gatherer.
+func newDataGathererConfig(kind string) (datagatherer.Config, error) {
 	var cfg datagatherer.Config
 
-	switch dg.Kind {
+	switch kind {
 	case "gke":
 		cfg = &gke.Config{}
 	case "eks":
 		cfg = &eks.Config{}
 	case "aks":
 		cfg = &aks.Config{}
+	case "aws-acm":
+		cfg = &acm.Config{}
+	case "http":
+		cfg = &httpendpoint.Config{}
+	case "exec":
+		cfg = &exec.Config{}
+	case "prometheus":
+		cfg = &prometheus.Config{}
 	case "k8s":
 		cfg = &k8s.ConfigDynamic{}
 	case "k8s-dynamic":
 		cfg = &k8s.ConfigDynamic{}
 	case "k8s-discovery":
 		cfg = &k8s.ConfigDiscovery{}
+	case "k8s-owners":
+		cfg = &k8s.ConfigOwners{}
+	case "k8s-tls-secrets":
+		cfg = &k8s.ConfigTLSSecrets{}
+	case "k8s-configmap-certs":
+		cfg = &k8s.ConfigConfigMapCerts{}
+	case "k8s-ingress-tls":
+		cfg = &k8s.ConfigIngressTLS{}
+	case "k8s-tls-probe":
+		cfg = &k8s.ConfigTLSProbe{}
+	case "k8s-images":
+		cfg = &k8s.ConfigImages{}
+	case "k8s-nodes":
+		cfg = &k8s.ConfigNodes{}
+	case "k8s-events":
+		cfg = &k8s.ConfigEvents{}
+	case "k8s-admission":
+		cfg = &k8s.ConfigAdmission{}
+	case "k8s-rbac":
+		cfg = &k8s.ConfigRBAC{}
+	case "k8s-openshift":
+		cfg = &k8s.ConfigOpenShift{}
+	case "k8s-service-account-issuer":
+		cfg = &k8s.ConfigServiceAccountIssuer{}
+	case "cert-manager":
+		cfg = &certmanager.Config{}
+	case "cert-manager-acme":
+		cfg = &certmanager.ACMEConfig{}
+	case "istio-mesh":
+		cfg = &istio.MeshConfig{}
 	case "local":
 		cfg = &local.Config{}
+	case "host-certs":
+		cfg = &hostcerts.Config{}
 	case "version-checker":
 		cfg = &versionchecker.Config{}
 	// dummy dataGatherer is just used for testing
 	case "dummy":
 		cfg = &dummyConfig{}
+	case "plugin":
+		cfg = &plugin.Config{}
 	default:
-		return fmt.Errorf("cannot parse data-gatherer configuration, kind %q is not supported", dg.Kind)
+		// the kinds registered by the packages built into the agent
+		if cfg, ok := datagatherer.NewConfig(kind); ok {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("cannot parse data-gatherer configuration, kind %q is not supported", kind)
+	}
+
+	return cfg, nil
+}
+
+// UnmarshalYAML unmarshals an output resolving the type according to Kind.
+func (o *Output) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		Kind      string      `yaml:"kind"`
+		Name      string      `yaml:"name"`
+		RawConfig interface{} `yaml:"config"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	o.Kind = aux.Kind
+	o.Name = aux.Name
+
+	if o.Kind == backendOutputKind {
+		return nil
+	}
+	cfg, err := newOutputConfig(o.Kind)
+	if err != nil {
+		return err
 	}
 
-	// we encode aux.RawConfig, which is just a map of reflect.Values, into yaml and decode it again to the right type.
 	err = reMarshal(aux.RawConfig, cfg)
 	if err != nil {
 		return err
 	}
 
-	dg.Config = cfg
+	o.Config = cfg
 
 	return nil
 }
 
+// newOutputConfig returns an empty configuration for the kind of output.
+func newOutputConfig(kind string) (output.Config, error) {
+	var cfg output.Config
+
+	switch kind {
+	case "exec":
+		cfg = &output.ExecConfig{}
+	case "file":
+		cfg = &output.FileConfig{}
+	case "stdout":
+		cfg = &output.StdoutConfig{}
+	case "s3":
+		cfg = &output.S3Config{}
+	case "gcs":
+		cfg = &output.GCSConfig{}
+	case "azure-blob":
+		cfg = &output.AzureBlobConfig{}
+	case "kafka":
+		cfg = &output.KafkaConfig{}
+	case "kafka-rest-proxy":
+		cfg = &output.KafkaRESTProxyConfig{}
+	case "nats":
+		cfg = &output.NATSConfig{}
+	case "webhook":
+		cfg = &output.WebhookConfig{}
+	default:
+		return nil, fmt.Errorf("cannot parse output configuration, kind %q is not supported", kind)
+	}
+
+	return cfg, nil
+}
+
 // Dump generates a YAML string of the Config object
 func (c *Config) Dump() (string, error) {
 	d, err := yaml.Marshal(&c)
@@ -129,39 +435,253 @@ func (c *Config) Dump() (string, error) {
 	return string(d), nil
 }
 
+// fetchConcurrency returns the number of data gatherers fetched at the same
+// time.
+func (c *Config) fetchConcurrency() int {
+	if c.FetchConcurrency == 0 {
+		return defaultFetchConcurrency
+	}
+	return c.FetchConcurrency
+}
+
+// fetchTimeout returns how long a data gatherer is waited for.
+func (c *Config) fetchTimeout() time.Duration {
+	if c.FetchTimeout == 0 {
+		return defaultFetchTimeout
+	}
+	return c.FetchTimeout
+}
+
 func (c *Config) validate() error {
 	var result *multierror.Error
 
-	if c.OrganizationID == "" {
+	// the organization is only required by the Jetstack Secure backend
+	if c.OrganizationID == "" && c.Backend == nil && c.VenafiTPP == nil {
 		result = multierror.Append(result, fmt.Errorf("organization_id is required"))
 	}
-	if c.ClusterID == "" {
+	if c.ClusterID == "" && c.ClusterIdentity == nil {
 		result = multierror.Append(result, fmt.Errorf("cluster_id is required"))
 	}
+	if c.ClusterIdentity != nil {
+		if err := c.ClusterIdentity.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Server != "" && !isValidServerURL(c.Server) {
+		result = multierror.Append(result, fmt.Errorf("server is not a valid URL"))
+	}
 
-	if c.Server != "" {
-		if url, err := url.Parse(c.Server); err != nil || url.Hostname() == "" {
-			result = multierror.Append(result, fmt.Errorf("server is not a valid URL"))
+	if c.DualWrite != nil {
+		if err := c.DualWrite.validate(); err != nil {
+			result = multierror.Append(result, err)
 		}
 	}
 
-	for i, v := range c.DataGatherers {
-		if v.Kind == "" {
-			result = multierror.Append(result, fmt.Errorf("datagatherer %d/%d is missing a kind", i+1, len(c.DataGatherers)))
+	if c.Spool != nil {
+		if err := c.Spool.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Directives != nil {
+		if err := c.Directives.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.ExpirySummary != nil {
+		if err := c.ExpirySummary.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.UploadStatus != nil {
+		if err := c.UploadStatus.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.SnapshotEndpoint != nil {
+		if err := c.SnapshotEndpoint.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Health != nil {
+		if err := c.Health.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.MemoryBudget != nil {
+		if err := c.MemoryBudget.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if _, err := k8s.GetAnonymizationProfile(c.AnonymizationProfile); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if c.HashIdentifiers != nil {
+		if err := c.HashIdentifiers.validate(); err != nil {
+			result = multierror.Append(result, err)
 		}
+	}
+
+	if err := validateDataGatherers(c.DataGatherers); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if c.RemoteConfig != nil {
+		if err := c.RemoteConfig.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+		if c.Backend != nil || c.VenafiTPP != nil {
+			result = multierror.Append(result, fmt.Errorf("remote-config is only served by the Jetstack Secure backend"))
+		}
+	}
+
+	outputNames := map[string]bool{}
+	for i, v := range c.Outputs {
 		if v.Name == "" {
-			result = multierror.Append(result, fmt.Errorf("datagatherer %d/%d is missing a name", i+1, len(c.DataGatherers)))
+			result = multierror.Append(result, fmt.Errorf("output %d/%d is missing a name", i+1, len(c.Outputs)))
+		} else if outputNames[v.Name] {
+			result = multierror.Append(result, fmt.Errorf("output name %q is used more than once", v.Name))
+		}
+		outputNames[v.Name] = true
+	}
+
+	if c.Jitter < 0 {
+		result = multierror.Append(result, fmt.Errorf("jitter cannot be negative"))
+	}
+
+	if c.UploadChunkSize < 0 {
+		result = multierror.Append(result, fmt.Errorf("upload-chunk-size cannot be negative"))
+	}
+
+	if c.FetchConcurrency < 0 {
+		result = multierror.Append(result, fmt.Errorf("fetch-concurrency cannot be negative"))
+	}
+
+	if c.FetchTimeout < 0 {
+		result = multierror.Append(result, fmt.Errorf("fetch-timeout cannot be negative"))
+	}
+
+	if err := c.KubernetesClient.Validate(); err != nil {
+		result = multierror.Append(result, errors.Wrap(err, "kubernetes-client"))
+	}
+
+	if c.Tracing != nil {
+		if err := c.Tracing.Validate(); err != nil {
+			result = multierror.Append(result, errors.Wrap(err, "tracing"))
+		}
+	}
+
+	if err := c.Transport.Validate(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if c.ClientCertificate != nil {
+		if err := c.ClientCertificate.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.TokenAuth != nil {
+		if err := c.TokenAuth.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Signing != nil {
+		if err := c.Signing.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Backend != nil {
+		if err := c.Backend.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.VenafiTPP != nil {
+		if err := c.VenafiTPP.Validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+		if c.Backend != nil {
+			result = multierror.Append(result, fmt.Errorf("venafi-tpp cannot be used with backend"))
+		}
+	}
+
+	if (c.Backend != nil || c.VenafiTPP != nil) && c.TokenAuth != nil {
+		result = multierror.Append(result, fmt.Errorf("token-auth is only used by the Jetstack Secure backend"))
+	}
+
+	if _, err := client.ParseCompression(c.Compression); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if _, err := client.ParseFormat(c.UploadFormat); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	watchNames := map[string]bool{}
+	for i, v := range c.Watches {
+		if v.Name == "" {
+			result = multierror.Append(result, fmt.Errorf("watch %d/%d is missing a name", i+1, len(c.Watches)))
+		} else if watchNames[v.Name] {
+			result = multierror.Append(result, fmt.Errorf("watch name %q is used more than once", v.Name))
+		}
+		watchNames[v.Name] = true
+		if err := v.validate(); err != nil {
+			result = multierror.Append(result, err)
 		}
 	}
 
 	return result.ErrorOrNil()
 }
 
-// ParseConfig reads config into a struct used to configure running agents
+// validateDataGatherers validates the data gatherers of the configuration.
+func validateDataGatherers(dataGatherers []DataGatherer) error {
+	var result *multierror.Error
+	for i, v := range dataGatherers {
+		if v.Kind == "" {
+			result = multierror.Append(result, fmt.Errorf("datagatherer %d/%d is missing a kind", i+1, len(dataGatherers)))
+		}
+		if v.Name == "" {
+			result = multierror.Append(result, fmt.Errorf("datagatherer %d/%d is missing a name", i+1, len(dataGatherers)))
+		}
+		if err := v.validateClusters(); err != nil {
+			result = multierror.Append(result, err)
+		}
+		if v.Schedule != "" {
+			if _, err := parseSchedule(v.Schedule); err != nil {
+				result = multierror.Append(result, fmt.Errorf("datagatherer %q has an invalid schedule: %v", v.Name, err))
+			}
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+func isValidServerURL(server string) bool {
+	url, err := url.Parse(server)
+	return err == nil && url.Hostname() != ""
+}
+
+// ParseConfig reads config into a struct used to configure running agents.
+// The references to environment variables in the config are expanded first.
 func ParseConfig(data []byte) (Config, error) {
 	var config Config
 
-	err := yaml.Unmarshal(data, &config)
+	data, err := expandTemplate(data, os.LookupEnv)
+	if err != nil {
+		return config, err
+	}
+
+	err = yaml.Unmarshal(data, &config)
 	if err != nil {
 		return config, err
 	}
@@ -178,5 +698,9 @@ func ParseConfig(data []byte) (Config, error) {
 		return config, err
 	}
 
+	if config.VenafiTPP != nil {
+		config.Backend = &Backend{Kind: client.VenafiTPPBackend, Config: config.VenafiTPP}
+	}
+
 	return config, nil
 }
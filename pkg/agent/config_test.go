@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
 	"github.com/kylelemons/godebug/diff"
 	"gopkg.in/d4l3k/messagediff.v1"
 )
@@ -208,3 +209,88 @@ func TestInvalidDataGathered(t *testing.T) {
 		t.Errorf("\ngot=\n%v\nwant=\n%s\ndiff=\n%s", got, want, diff.Diff(got, want))
 	}
 }
+
+func TestClusterDataGatherersExpand(t *testing.T) {
+	configFileContents := `
+      server: "http://localhost:8080"
+      period: 1h
+      organization_id: "example"
+      cluster_id: "hub"
+      data-gatherers:
+      - name: k8s/pods
+        kind: k8s-dynamic
+        config:
+          kubeconfig: /etc/kubeconfig
+          resource-type:
+            version: v1
+            resource: pods
+        clusters:
+        - id: spoke-1
+          context: spoke-1
+        - id: spoke-2
+          kubeconfig: /etc/spoke-2/kubeconfig
+`
+
+	config, err := ParseConfig([]byte(configFileContents))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config.DataGatherers = expandClusters(config.DataGatherers)
+	if len(config.DataGatherers) != 2 {
+		t.Fatalf("expected 2 data gatherers, got %d", len(config.DataGatherers))
+	}
+
+	expected := []struct {
+		name, kubeconfig, context, readingName, readingCluster string
+	}{
+		{"k8s/pods@spoke-1", "/etc/kubeconfig", "spoke-1", "k8s/pods", "spoke-1"},
+		{"k8s/pods@spoke-2", "/etc/spoke-2/kubeconfig", "", "k8s/pods", "spoke-2"},
+	}
+	for i, e := range expected {
+		dg := config.DataGatherers[i]
+		if dg.Name != e.name {
+			t.Errorf("expected data gatherer %d to be named %q, got %q", i, e.name, dg.Name)
+		}
+		cfg := dg.Config.(*k8s.ConfigDynamic)
+		if cfg.KubeConfigPath != e.kubeconfig || cfg.KubeConfigContext != e.context {
+			t.Errorf("expected data gatherer %q to use %q/%q, got %q/%q", dg.Name, e.kubeconfig, e.context, cfg.KubeConfigPath, cfg.KubeConfigContext)
+		}
+		if cfg.GroupVersionResource.Resource != "pods" {
+			t.Errorf("expected data gatherer %q to gather pods, got %q", dg.Name, cfg.GroupVersionResource.Resource)
+		}
+		if name, clusterID := readingIdentity(config, dg.Name); name != e.readingName || clusterID != e.readingCluster {
+			t.Errorf("expected readings of %q to be %q/%q, got %q/%q", dg.Name, e.readingName, e.readingCluster, name, clusterID)
+		}
+	}
+}
+
+func TestClusterDataGatherersInvalid(t *testing.T) {
+	configFileContents := `
+      server: "http://localhost:8080"
+      period: 1h
+      organization_id: "example"
+      cluster_id: "hub"
+      data-gatherers:
+      - name: d1
+        kind: dummy
+        clusters:
+        - id: spoke-1
+        - id: spoke-1
+        - context: spoke-2
+`
+
+	_, err := ParseConfig([]byte(configFileContents))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, expected := range []string{
+		`datagatherer "d1" of kind "dummy" cannot read from several clusters`,
+		`cluster id "spoke-1" is used more than once in datagatherer "d1"`,
+		`cluster 3/3 of datagatherer "d1" is missing an id`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got: %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/metrics"
+)
+
+// credentialFile is a credential of the agent read from a file, e.g. mounted
+// from a Secret, and applied to the client again when the file changes.
+type credentialFile struct {
+	// name identifies the credential in the logs and the metrics.
+	name  string
+	path  string
+	apply func(data []byte) error
+	data  []byte
+}
+
+// credentialWatcher reloads the credential files when they are modified, so
+// that rotated credentials are used without restarting the agent. The
+// previous credential is kept if a file cannot be loaded, e.g. while it is
+// being replaced.
+type credentialWatcher struct {
+	mu    sync.Mutex
+	files []*credentialFile
+}
+
+// add watches the file, whose current content data has already been applied.
+func (w *credentialWatcher) add(name, path string, data []byte, apply func(data []byte) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.files = append(w.files, &credentialFile{name: name, path: path, apply: apply, data: data})
+}
+
+// reload applies the files whose content changed.
+func (w *credentialWatcher) reload() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, f := range w.files {
+		data, err := ioutil.ReadFile(f.path)
+		if err == nil && bytes.Equal(data, f.data) {
+			continue
+		}
+		if err == nil {
+			err = f.apply(data)
+		}
+		metrics.ObserveCredentialReload(f.name, err)
+		if err != nil {
+			logs.Log.Warnf("failed to reload the %s from %s, using the previous one: %v", f.name, f.path, err)
+			continue
+		}
+		f.data = data
+		logs.Log.Infof("Reloaded the %s from %s", f.name, f.path)
+	}
+}
+
+// run watches the directories of the files until stopCh is closed.
+func (w *credentialWatcher) run(stopCh <-chan struct{}) error {
+	w.mu.Lock()
+	dirs := map[string]bool{}
+	for _, f := range w.files {
+		dirs[filepath.Dir(f.path)] = true
+	}
+	w.mu.Unlock()
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %v", err)
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("failed to watch %q: %v", dir, err)
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// mounted Secrets are replaced through symlinks, so all the
+				// files are read again
+				w.reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logs.Log.Errorf("error watching credential files: %v", err)
+			}
+		}
+	}()
+	return nil
+}
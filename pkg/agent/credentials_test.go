@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCredentialWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(path, []byte("first"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var applied []string
+	w := &credentialWatcher{}
+	w.add("API token", path, []byte("first"), func(data []byte) error {
+		if len(data) == 0 {
+			return fmt.Errorf("empty")
+		}
+		applied = append(applied, string(data))
+		return nil
+	})
+
+	// unchanged files are not applied again
+	w.reload()
+	if len(applied) != 0 {
+		t.Fatalf("unexpected reloads: %v", applied)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("second"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.reload()
+	// the previous credential is kept when the file is invalid
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.reload()
+	if err := ioutil.WriteFile(path, []byte("second"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.reload()
+
+	if len(applied) != 1 || applied[0] != "second" {
+		t.Errorf("unexpected reloads: %v", applied)
+	}
+}
+
+func TestCredentialWatcherRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := ioutil.WriteFile(path, []byte("first"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	applied := make(chan string, 10)
+	w := &credentialWatcher{}
+	w.add("credentials file", path, []byte("first"), func(data []byte) error {
+		applied <- string(data)
+		return nil
+	})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := w.run(stopCh); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("second"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case data := <-applied:
+		if data != "second" {
+			t.Errorf("unexpected credential: %s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the credential to be reloaded")
+	}
+}
@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// defaultDirectivesMinPeriod and maxDirectivesPeriod bound the period the
+// backend can ask for.
+const (
+	defaultDirectivesMinPeriod = 30 * time.Second
+	maxDirectivesPeriod        = 24 * time.Hour
+)
+
+// defaultDirectivesMaxResources bounds the number of resource types the
+// backend can add unless configured.
+const defaultDirectivesMaxResources = 10
+
+// Directives configures how the agent applies the directives the backend
+// sends in response to the uploads of readings. Directives are ignored
+// unless configured.
+type Directives struct {
+	// MinPeriod is the shortest period the backend can ask for. Defaults to
+	// 30s.
+	MinPeriod time.Duration `yaml:"min-period"`
+	// Resources allows the backend to add resource types to gather.
+	Resources bool `yaml:"resources"`
+	// MaxResources is the maximum number of resource types the backend can
+	// add. Defaults to 10.
+	MaxResources int `yaml:"max-resources"`
+	// Schedule is the schedule of the data gatherers of the resource types
+	// added, as for the configured data gatherers. If empty, they are
+	// fetched on every cycle.
+	Schedule string `yaml:"schedule,omitempty"`
+	// Clusters are the clusters the resource types added are gathered from,
+	// as for the configured data gatherers. If empty, they are gathered from
+	// the cluster of the agent.
+	Clusters []Cluster `yaml:"clusters,omitempty"`
+}
+
+func (d *Directives) validate() error {
+	if d.MinPeriod < 0 {
+		return fmt.Errorf("directives.min-period cannot be negative")
+	}
+	if d.MaxResources < 0 {
+		return fmt.Errorf("directives.max-resources cannot be negative")
+	}
+	if d.Schedule != "" {
+		if _, err := parseSchedule(d.Schedule); err != nil {
+			return fmt.Errorf("directives.schedule is invalid: %v", err)
+		}
+	}
+	if err := (DataGatherer{Kind: directivesKind, Name: "directives", Clusters: d.Clusters}).validateClusters(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// directivesKind is the kind of the data gatherers of the resource types
+// added by the backend.
+const directivesKind = "k8s-dynamic"
+
+// directiveApplier applies the directives of the backend to the running
+// agent.
+type directiveApplier struct {
+	minPeriod    time.Duration
+	resources    bool
+	maxResources int
+	schedule     string
+	clusters     []Cluster
+	// gathered are the resource types already gathered, by a configured data
+	// gatherer or one added by a directive.
+	gathered map[schema.GroupVersionResource]bool
+	added    int
+}
+
+func newDirectiveApplier(config Config) *directiveApplier {
+	if config.Directives == nil {
+		return nil
+	}
+	a := &directiveApplier{
+		minPeriod:    config.Directives.MinPeriod,
+		resources:    config.Directives.Resources,
+		maxResources: config.Directives.MaxResources,
+		schedule:     config.Directives.Schedule,
+		clusters:     config.Directives.Clusters,
+		gathered:     map[schema.GroupVersionResource]bool{},
+	}
+	if a.minPeriod == 0 {
+		a.minPeriod = defaultDirectivesMinPeriod
+	}
+	if a.maxResources == 0 {
+		a.maxResources = defaultDirectivesMaxResources
+	}
+	for _, dg := range config.DataGatherers {
+		if dynamicConfig, ok := dg.Config.(*k8s.ConfigDynamic); ok {
+			a.gathered[dynamicConfig.GroupVersionResource] = true
+			for _, gvr := range dynamicConfig.GroupVersionResources {
+				a.gathered[gvr] = true
+			}
+		}
+	}
+	return a
+}
+
+// apply applies the directives and returns the period of the next cycle, or
+// zero to keep the configured period. The data gatherers of the resource
+// types added are started with the running ones, and added to the data
+// gatherers of the configuration.
+func (a *directiveApplier) apply(d *api.Directives, config *Config, running *runningGatherers, scheduler *scheduler) time.Duration {
+	if a == nil || d == nil {
+		return 0
+	}
+
+	var period time.Duration
+	if d.NextPeriod != "" {
+		var err error
+		period, err = time.ParseDuration(d.NextPeriod)
+		switch {
+		case err != nil:
+			logs.Log.Warnf("ignoring the invalid period %q requested by the backend", d.NextPeriod)
+			period = 0
+		case period < a.minPeriod:
+			period = a.minPeriod
+		case period > maxDirectivesPeriod:
+			period = maxDirectivesPeriod
+		}
+		if period > 0 {
+			logs.Log.Infof("The backend requested the next cycle in %s", period)
+		}
+	}
+
+	for _, r := range d.Resources {
+		gvr := schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+		if err := a.addResource(gvr, config, running, scheduler); err != nil {
+			logs.Log.Warnf("ignoring the resource type %q requested by the backend: %v", gvr, err)
+		}
+	}
+
+	var requested []string
+	for _, name := range d.DataGatherers {
+		if _, ok := running.gatherers[name]; !ok {
+			logs.Log.Warnf("ignoring the unknown data gatherer %q requested by the backend", name)
+			continue
+		}
+		requested = append(requested, name)
+	}
+	if len(requested) > 0 {
+		logs.Log.Infof("The backend requested data gatherers on the next cycle: %v", requested)
+		scheduler.request(requested)
+	}
+
+	return period
+}
+
+// addResource starts a k8s-dynamic data gatherer for the resource type,
+// named after it as in the example configurations, e.g.
+// k8s/certificates.v1.cert-manager.io. It is built and started as the
+// configured data gatherers are, once per cluster and with the schedule of
+// the directives.
+func (a *directiveApplier) addResource(gvr schema.GroupVersionResource, config *Config, running *runningGatherers, scheduler *scheduler) error {
+	if !a.resources {
+		return fmt.Errorf("adding resource types is not enabled")
+	}
+	if gvr.Version == "" || gvr.Resource == "" {
+		return fmt.Errorf("version and resource are required")
+	}
+	if a.gathered[gvr] {
+		return nil
+	}
+	if a.added >= a.maxResources {
+		return fmt.Errorf("the maximum of %d resource types added is reached", a.maxResources)
+	}
+
+	dgConfigs, err := a.dataGathererConfigs(gvr)
+	if err != nil {
+		return err
+	}
+	for _, dgConfig := range dgConfigs {
+		if _, ok := running.gatherers[dgConfig.Name]; ok {
+			return fmt.Errorf("a data gatherer named %q already exists", dgConfig.Name)
+		}
+	}
+
+	// the data gatherers are instantiated before any is started, so that
+	// none is added if one of them fails
+	pending := running.pending()
+	starts := map[string]func(){}
+	for _, dgConfig := range dgConfigs {
+		_, start, err := pending.add(dgConfig)
+		if err != nil {
+			for name := range pending.cancels {
+				pending.cancels[name]()
+			}
+			return err
+		}
+		starts[dgConfig.Name] = start
+	}
+
+	for _, dgConfig := range dgConfigs {
+		// as on boot, the first fetch may be incomplete if the sync is slow
+		starts[dgConfig.Name]()
+		running.gatherers[dgConfig.Name] = pending.gatherers[dgConfig.Name]
+		running.cancels[dgConfig.Name] = pending.cancels[dgConfig.Name]
+		scheduler.add(dgConfig)
+		config.DataGatherers = append(config.DataGatherers, dgConfig)
+		logs.Log.WithFields(logrus.Fields{logs.DataGathererField: dgConfig.Name, logs.ResourceField: k8s.ResourceTypeKey(gvr)}).
+			Infof("The backend added the data gatherer %q", dgConfig.Name)
+	}
+	a.gathered[gvr] = true
+	a.added++
+	return nil
+}
+
+// dataGathererConfigs returns the configurations of the data gatherers of a
+// resource type added by the backend. The configuration is parsed as the one
+// of a configured data gatherer, and expanded into one data gatherer per
+// cluster of the directives.
+func (a *directiveApplier) dataGathererConfigs(gvr schema.GroupVersionResource) ([]DataGatherer, error) {
+	name := fmt.Sprintf("k8s/%s.%s", gvr.Resource, gvr.Version)
+	if gvr.Group != "" {
+		name += "." + gvr.Group
+	}
+	raw := map[string]interface{}{
+		"kind":     directivesKind,
+		"name":     name,
+		"schedule": a.schedule,
+		"config": map[string]interface{}{
+			"resource-type": map[string]string{
+				"group":    gvr.Group,
+				"version":  gvr.Version,
+				"resource": gvr.Resource,
+			},
+		},
+		"clusters": a.clusters,
+	}
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var dgConfig DataGatherer
+	if err := yaml.Unmarshal(data, &dgConfig); err != nil {
+		return nil, err
+	}
+	dgConfig.AddedByDirective = true
+	return expandClusters([]DataGatherer{dgConfig}), nil
+}
+
+// addedByDirectives returns the data gatherers of the previous configuration
+// added by directives, which are not in the next one. They last until the
+// agent restarts, so they are kept when the configuration is reloaded.
+func addedByDirectives(previous, next []DataGatherer) []DataGatherer {
+	names := map[string]bool{}
+	for _, dg := range next {
+		names[dg.Name] = true
+	}
+	var kept []DataGatherer
+	for _, dg := range previous {
+		if dg.AddedByDirective && !names[dg.Name] {
+			kept = append(kept, dg)
+		}
+	}
+	return kept
+}
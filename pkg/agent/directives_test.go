@@ -0,0 +1,219 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"gopkg.in/d4l3k/messagediff.v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestApplyDirectives(t *testing.T) {
+	config := Config{
+		Directives: &Directives{Resources: true, MaxResources: 1},
+		DataGatherers: []DataGatherer{
+			{
+				Name: "k8s/pods",
+				Config: &k8s.ConfigDynamic{
+					GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+				},
+			},
+			{Name: "k8s/secrets", Schedule: "1h"},
+		},
+	}
+	dataGatherers := map[string]datagatherer.DataGatherer{
+		"k8s/pods":    &dummyDataGatherer{},
+		"k8s/secrets": &dummyDataGatherer{},
+	}
+	running := newRunningGatherers(context.Background(), dataGatherers)
+	scheduler := newScheduler(config.DataGatherers)
+	applier := newDirectiveApplier(config)
+	now := time.Now()
+	scheduler.due(dataGatherers, now)
+
+	for _, test := range []struct {
+		period   string
+		expected time.Duration
+	}{
+		{period: "", expected: 0},
+		{period: "invalid", expected: 0},
+		{period: "5m", expected: 5 * time.Minute},
+		{period: "1s", expected: defaultDirectivesMinPeriod},
+		{period: "1000h", expected: maxDirectivesPeriod},
+	} {
+		if p := applier.apply(&api.Directives{NextPeriod: test.period}, &config, running, scheduler); p != test.expected {
+			t.Errorf("period %q: expected %s, got %s", test.period, test.expected, p)
+		}
+	}
+
+	applier.apply(&api.Directives{
+		DataGatherers: []string{"k8s/secrets", "unknown"},
+		// already gathered by k8s/pods
+		Resources: []api.GroupVersionResource{{Version: "v1", Resource: "pods"}},
+	}, &config, running, scheduler)
+	if len(dataGatherers) != 2 {
+		t.Errorf("expected no data gatherer to be added, got %d", len(dataGatherers))
+	}
+	if due := scheduler.due(dataGatherers, now.Add(time.Minute)); len(due) != 2 {
+		t.Errorf("expected the requested data gatherer to be due, got %d due", len(due))
+	}
+	if due := scheduler.due(dataGatherers, now.Add(2*time.Minute)); len(due) != 1 {
+		t.Errorf("expected the request to only apply to one cycle, got %d due", len(due))
+	}
+
+	applier.added = applier.maxResources
+	err := applier.addResource(schema.GroupVersionResource{Version: "v1", Resource: "services"}, &config, running, scheduler)
+	if err == nil {
+		t.Errorf("expected error beyond the maximum of resource types")
+	}
+}
+
+func TestApplyDirectivesAddResource(t *testing.T) {
+	// the cluster is not reachable, the data gatherer is started all the
+	// same and fails its initial sync
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	err := ioutil.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: prod
+  context:
+    cluster: prod
+current-context: prod
+`), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := Config{Directives: &Directives{
+		Resources: true,
+		Schedule:  "1h",
+		Clusters:  []Cluster{{ID: "prod", KubeConfigPath: kubeconfig}},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	running := newRunningGatherers(ctx, map[string]datagatherer.DataGatherer{})
+	running.syncPeriod = 10 * time.Millisecond
+	scheduler := newScheduler(nil)
+	applier := newDirectiveApplier(config)
+
+	applier.apply(&api.Directives{
+		Resources: []api.GroupVersionResource{{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}},
+	}, &config, running, scheduler)
+
+	name := "k8s/certificates.v1.cert-manager.io@prod"
+	if _, ok := running.gatherers[name].(*k8s.DataGathererDynamic); !ok {
+		t.Fatalf("expected a k8s-dynamic data gatherer %q to be running, got %v", name, running.gatherers)
+	}
+	if _, ok := running.cancels[name]; !ok {
+		t.Errorf("expected the data gatherer to be stopped with the agent")
+	}
+	if len(config.DataGatherers) != 1 {
+		t.Fatalf("expected the data gatherer to be added to the configuration, got %+v", config.DataGatherers)
+	}
+	dg := config.DataGatherers[0]
+	if dg.Name != name || dg.ClusterID != "prod" || dg.Schedule != "1h" || !dg.AddedByDirective {
+		t.Errorf("expected the data gatherer to be configured as the configured ones, got %+v", dg)
+	}
+	if dgConfig, ok := dg.Config.(*k8s.ConfigDynamic); !ok || dgConfig.KubeConfigPath != kubeconfig {
+		t.Errorf("expected the data gatherer to gather the cluster of the directives, got %+v", dg.Config)
+	}
+
+	now := time.Now()
+	if due := scheduler.due(running.gatherers, now); len(due) != 1 {
+		t.Errorf("expected the data gatherer to be due on the next cycle, got %d due", len(due))
+	}
+	if due := scheduler.due(running.gatherers, now.Add(time.Minute)); len(due) != 0 {
+		t.Errorf("expected the data gatherer to follow the schedule of the directives, got %d due", len(due))
+	}
+}
+
+func TestApplyDirectivesDisabled(t *testing.T) {
+	applier := newDirectiveApplier(Config{})
+	if p := applier.apply(&api.Directives{NextPeriod: "5m"}, nil, nil, nil); p != 0 {
+		t.Errorf("expected the directives to be ignored, got %s", p)
+	}
+
+	applier = newDirectiveApplier(Config{Directives: &Directives{}})
+	err := applier.addResource(schema.GroupVersionResource{Version: "v1", Resource: "services"}, nil, nil, nil)
+	if err == nil || err.Error() != "adding resource types is not enabled" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDirectiveDataGathererConfigs(t *testing.T) {
+	applier := newDirectiveApplier(Config{Directives: &Directives{
+		Resources: true,
+		Schedule:  "1h",
+		Clusters:  []Cluster{{ID: "prod", Context: "prod-context"}, {ID: "staging", KubeConfigPath: "/etc/staging"}},
+	}})
+	gvr := schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+	dgConfigs, err := applier.dataGathererConfigs(gvr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []DataGatherer{
+		{
+			Kind:             "k8s-dynamic",
+			Name:             "k8s/certificates.v1.cert-manager.io@prod",
+			Schedule:         "1h",
+			Config:           &k8s.ConfigDynamic{GroupVersionResource: gvr, KubeConfigContext: "prod-context"},
+			ClusterID:        "prod",
+			AddedByDirective: true,
+		},
+		{
+			Kind:             "k8s-dynamic",
+			Name:             "k8s/certificates.v1.cert-manager.io@staging",
+			Schedule:         "1h",
+			Config:           &k8s.ConfigDynamic{GroupVersionResource: gvr, KubeConfigPath: "/etc/staging"},
+			ClusterID:        "staging",
+			AddedByDirective: true,
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, dgConfigs); !equal {
+		t.Errorf("unexpected data gatherers:\n%s", diff)
+	}
+}
+
+func TestAddedByDirectives(t *testing.T) {
+	previous := []DataGatherer{
+		{Name: "k8s/pods"},
+		{Name: "k8s/certificates.v1.cert-manager.io", AddedByDirective: true},
+		{Name: "k8s/services.v1", AddedByDirective: true},
+	}
+	// the services are configured since
+	next := []DataGatherer{{Name: "k8s/services.v1"}}
+
+	kept := addedByDirectives(previous, next)
+	if len(kept) != 1 || kept[0].Name != "k8s/certificates.v1.cert-manager.io" {
+		t.Errorf("expected the data gatherers added by directives to be kept, got %+v", kept)
+	}
+}
+
+func TestValidateDirectives(t *testing.T) {
+	tests := map[string]struct {
+		directives  Directives
+		expectedErr bool
+	}{
+		"valid":                 {directives: Directives{Schedule: "@hourly", Clusters: []Cluster{{ID: "prod"}}}},
+		"invalid schedule":      {directives: Directives{Schedule: "sometimes"}, expectedErr: true},
+		"cluster without an id": {directives: Directives{Clusters: []Cluster{{Context: "prod"}}}, expectedErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := test.directives.validate(); (err != nil) != test.expectedErr {
+				t.Errorf("expected error %t, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
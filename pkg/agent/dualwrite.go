@@ -0,0 +1,263 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+	"github.com/jetstack/preflight/pkg/logs"
+)
+
+// DualWrite configures a secondary backend that receives a copy of every
+// upload. It is intended to de-risk migrations between backends, e.g. from a
+// self-hosted receiver to the SaaS backend, by comparing what each destination
+// was sent and whether it accepted it.
+type DualWrite struct {
+	// Server is the base url of the secondary backend.
+	Server string `yaml:"server"`
+	// OrganizationID within the secondary backend. Defaults to the
+	// organization_id of the primary backend.
+	OrganizationID string `yaml:"organization_id"`
+	// ClusterID in the secondary backend. Defaults to the cluster_id of the
+	// primary backend.
+	ClusterID string `yaml:"cluster_id"`
+	// EndpointPath overrides the path used when no organization is set.
+	EndpointPath string `yaml:"endpoint_path"`
+	// CredentialsPath is the path to an OAuth2 credentials file for the
+	// secondary backend.
+	CredentialsPath string `yaml:"credentials-file"`
+	// APITokenPath is the path to a file containing an API token for the
+	// secondary backend.
+	APITokenPath string `yaml:"api-token-file"`
+}
+
+func (d *DualWrite) validate() error {
+	if d.Server == "" {
+		return fmt.Errorf("dual-write.server is required")
+	}
+	if !isValidServerURL(d.Server) {
+		return fmt.Errorf("dual-write.server is not a valid URL")
+	}
+	if d.CredentialsPath != "" && d.APITokenPath != "" {
+		return fmt.Errorf("dual-write cannot use both credentials-file and api-token-file")
+	}
+	return nil
+}
+
+// destinationConfig returns a copy of the primary config pointing to the
+// secondary backend, so postData can be reused for both destinations.
+func (d *DualWrite) destinationConfig(primary Config) Config {
+	secondary := primary
+	secondary.Server = d.Server
+	secondary.Endpoint = Endpoint{Path: d.EndpointPath}
+	secondary.Backend = nil
+	secondary.VenafiTPP = nil
+	// the status records the uploads to the primary backend only
+	secondary.status = nil
+	if d.OrganizationID != "" {
+		secondary.OrganizationID = d.OrganizationID
+	}
+	if d.ClusterID != "" {
+		secondary.ClusterID = d.ClusterID
+	}
+	return secondary
+}
+
+// newClient builds the client used to post to the secondary backend.
+func (d *DualWrite) newClient(agentMetadata *api.AgentMetadata) (client.Client, error) {
+	switch {
+	case d.CredentialsPath != "":
+		b, err := ioutil.ReadFile(d.CredentialsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load dual-write credentials from file %s: %v", d.CredentialsPath, err)
+		}
+		credentials, err := client.ParseCredentials(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dual-write credentials file: %v", err)
+		}
+		return client.NewOAuthClient(agentMetadata, credentials, d.Server)
+	case d.APITokenPath != "":
+		b, err := ioutil.ReadFile(d.APITokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load dual-write api token from file %s: %v", d.APITokenPath, err)
+		}
+		return client.NewAPITokenClient(agentMetadata, strings.TrimSpace(string(b)), d.Server)
+	default:
+		return client.NewUnauthenticatedClient(agentMetadata, d.Server)
+	}
+}
+
+// destinationResult is the outcome of posting readings to one destination.
+type destinationResult struct {
+	Name        string
+	Server      string
+	PayloadHash string
+	Duration    time.Duration
+	Err         error
+}
+
+// dualWriteStats accumulates comparison results across agent cycles.
+type dualWriteStats struct {
+	Cycles            int
+	Divergences       int
+	PrimaryFailures   int
+	SecondaryFailures int
+	HashMismatches    int
+}
+
+// dualWriteReport is the comparison of the primary and secondary destinations
+// for a single cycle.
+type dualWriteReport struct {
+	Primary     destinationResult
+	Secondary   destinationResult
+	Differences []string
+}
+
+// Divergent returns true if the destinations did not receive and accept the
+// same payload.
+func (r *dualWriteReport) Divergent() bool {
+	return len(r.Differences) > 0
+}
+
+func compareDestinations(primary, secondary destinationResult) *dualWriteReport {
+	report := &dualWriteReport{Primary: primary, Secondary: secondary}
+
+	if primary.PayloadHash != secondary.PayloadHash {
+		report.Differences = append(report.Differences, fmt.Sprintf("payload hash differs: %s != %s", primary.PayloadHash, secondary.PayloadHash))
+	}
+	if (primary.Err == nil) != (secondary.Err == nil) {
+		report.Differences = append(report.Differences, fmt.Sprintf("upload outcome differs: %s=%s, %s=%s", primary.Name, outcome(primary.Err), secondary.Name, outcome(secondary.Err)))
+	}
+
+	return report
+}
+
+func (s *dualWriteStats) record(report *dualWriteReport) {
+	s.Cycles++
+	if report.Divergent() {
+		s.Divergences++
+	}
+	if report.Primary.Err != nil {
+		s.PrimaryFailures++
+	}
+	if report.Secondary.Err != nil {
+		s.SecondaryFailures++
+	}
+	if report.Primary.PayloadHash != report.Secondary.PayloadHash {
+		s.HashMismatches++
+	}
+}
+
+func (s *dualWriteStats) String() string {
+	return fmt.Sprintf("%d/%d cycles diverged (primary failures: %d, secondary failures: %d, hash mismatches: %d)",
+		s.Divergences, s.Cycles, s.PrimaryFailures, s.SecondaryFailures, s.HashMismatches)
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "ok"
+}
+
+// postToDestination posts readings to a destination with post and records
+// the result. The payload hash is the sum of the bodies the destination was
+// sent, as the client encoded them, so that destinations sent different
+// payloads, e.g. in different formats, are told apart.
+func postToDestination(ctx context.Context, name string, config Config, post func(ctx context.Context) error) destinationResult {
+	result := destinationResult{Name: name, Server: config.Server}
+
+	recorder := client.NewPayloadRecorder()
+	start := time.Now()
+	result.Err = post(client.WithPayloadRecorder(ctx, recorder))
+	result.Duration = time.Since(start)
+	result.PayloadHash = recorder.Sum()
+
+	return result
+}
+
+// logDualWriteReport prints the per-cycle comparison and the running totals.
+// If DualWriteReportPath is set the report is also appended to that file as
+// a JSON line, see appendDualWriteReport.
+func logDualWriteReport(report *dualWriteReport, stats *dualWriteStats) {
+	for _, r := range []destinationResult{report.Primary, report.Secondary} {
+		logs.Log.Infof("dual-write %s destination %s: %s in %s (payload sha256 %s)", r.Name, r.Server, outcome(r.Err), r.Duration.Round(time.Millisecond), r.PayloadHash)
+		if r.Err != nil {
+			logs.Log.Errorf("dual-write %s destination error: %v", r.Name, r.Err)
+		}
+	}
+
+	if report.Divergent() {
+		logs.Log.Warnf("dual-write destinations diverged: %s", strings.Join(report.Differences, "; "))
+	} else {
+		logs.Log.Infof("dual-write destinations are consistent")
+	}
+	logs.Log.Infof("dual-write totals: %s", stats)
+
+	if DualWriteReportPath == "" {
+		return
+	}
+
+	entry := struct {
+		Timestamp   api.Time `json:"timestamp"`
+		Primary     string   `json:"primary"`
+		Secondary   string   `json:"secondary"`
+		Hash        string   `json:"primary_payload_sha256"`
+		OtherHash   string   `json:"secondary_payload_sha256"`
+		Divergent   bool     `json:"divergent"`
+		Differences []string `json:"differences,omitempty"`
+	}{
+		Timestamp:   api.Time{Time: time.Now()},
+		Primary:     outcome(report.Primary.Err),
+		Secondary:   outcome(report.Secondary.Err),
+		Hash:        report.Primary.PayloadHash,
+		OtherHash:   report.Secondary.PayloadHash,
+		Divergent:   report.Divergent(),
+		Differences: report.Differences,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		logs.Log.Errorf("failed to marshal dual-write report: %v", err)
+		return
+	}
+
+	if err := appendDualWriteReport(DualWriteReportPath, append(data, '\n'), maxDualWriteReportBytes); err != nil {
+		logs.Log.Errorf("failed to write dual-write report: %v", err)
+	}
+}
+
+// maxDualWriteReportBytes is the size past which the dual-write report file
+// is rotated.
+const maxDualWriteReportBytes = 10 * 1024 * 1024
+
+// appendDualWriteReport appends line to the report file at path. The file is
+// only readable by the agent, the reports describe the uploads. Once the file
+// would grow past maxBytes it is renamed with a .1 suffix, replacing the
+// previous one, so that at most two files are kept.
+func appendDualWriteReport(path string, line []byte, maxBytes int64) error {
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > maxBytes {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate the file: %v", err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the file: %v", err)
+	}
+	defer f.Close()
+	// the file may have been created with wider permissions by an earlier
+	// version of the agent
+	if err := f.Chmod(0600); err != nil {
+		return fmt.Errorf("failed to restrict the permissions of the file: %v", err)
+	}
+
+	_, err = f.Write(line)
+	return err
+}
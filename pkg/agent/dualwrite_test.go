@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+func TestDualWriteConfigLoad(t *testing.T) {
+	loadedConfig, err := ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "example"
+      cluster_id: "example-cluster"
+      dual-write:
+        server: "https://platform.jetstack.io"
+        organization_id: "other"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if loadedConfig.DualWrite == nil {
+		t.Fatalf("expected dual-write to be configured")
+	}
+
+	secondary := loadedConfig.DualWrite.destinationConfig(loadedConfig)
+	if got, want := secondary.Server, "https://platform.jetstack.io"; got != want {
+		t.Errorf("unexpected server: got=%q want=%q", got, want)
+	}
+	if got, want := secondary.OrganizationID, "other"; got != want {
+		t.Errorf("unexpected organization_id: got=%q want=%q", got, want)
+	}
+	if got, want := secondary.ClusterID, "example-cluster"; got != want {
+		t.Errorf("cluster_id should default to the primary one: got=%q want=%q", got, want)
+	}
+}
+
+func TestDualWriteInvalidServer(t *testing.T) {
+	_, err := ParseConfig([]byte(`
+      organization_id: "example"
+      cluster_id: "example-cluster"
+      dual-write:
+        server: "not a url"
+`))
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
+
+func TestCompareDestinations(t *testing.T) {
+	tests := map[string]struct {
+		primary   destinationResult
+		secondary destinationResult
+		divergent bool
+	}{
+		"same hash and both succeeded": {
+			primary:   destinationResult{Name: "primary", PayloadHash: "a"},
+			secondary: destinationResult{Name: "secondary", PayloadHash: "a"},
+		},
+		"same hash, secondary failed": {
+			primary:   destinationResult{Name: "primary", PayloadHash: "a"},
+			secondary: destinationResult{Name: "secondary", PayloadHash: "a", Err: fmt.Errorf("boom")},
+			divergent: true,
+		},
+		"different hash": {
+			primary:   destinationResult{Name: "primary", PayloadHash: "a"},
+			secondary: destinationResult{Name: "secondary", PayloadHash: "b"},
+			divergent: true,
+		},
+		"both failed": {
+			primary:   destinationResult{Name: "primary", PayloadHash: "a", Err: fmt.Errorf("boom")},
+			secondary: destinationResult{Name: "secondary", PayloadHash: "a", Err: fmt.Errorf("boom")},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			stats := &dualWriteStats{}
+			report := compareDestinations(tc.primary, tc.secondary)
+			stats.record(report)
+
+			if got := report.Divergent(); got != tc.divergent {
+				t.Errorf("unexpected divergence: got=%t want=%t (%v)", got, tc.divergent, report.Differences)
+			}
+			if tc.divergent && stats.Divergences != 1 {
+				t.Errorf("expected divergence to be recorded, got %s", stats)
+			}
+		})
+	}
+}
+
+func TestPostToDestination(t *testing.T) {
+	// the backend records the sum of the bodies it received
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		received = append(received, hex.EncodeToString(sum[:]))
+	}))
+	defer server.Close()
+
+	readings := []*api.DataReading{{ClusterID: "cluster", DataGatherer: "k8s/pods", Data: map[string]interface{}{"items": []interface{}{}}}}
+	newClient := func(version string, format client.Format) client.Client {
+		c, err := client.NewUnauthenticatedClient(&api.AgentMetadata{Version: version}, server.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.SetCompression(client.CompressionNone)
+		c.SetFormat(format)
+		return c
+	}
+
+	tests := map[string]struct {
+		secondary  client.Client
+		mismatched bool
+	}{
+		"same payload": {
+			secondary: newClient("v1", client.FormatJSON),
+		},
+		"different agent metadata": {
+			secondary:  newClient("v2", client.FormatJSON),
+			mismatched: true,
+		},
+		"different format": {
+			secondary:  newClient("v1", client.FormatCBOR),
+			mismatched: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			received = nil
+			config := Config{Server: server.URL, OrganizationID: "org", ClusterID: "cluster"}
+			ctx := client.WithDataGatherTime(context.Background(), time.Now())
+			post := func(c client.Client) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					return postData(ctx, config, c, readings)
+				}
+			}
+
+			primary := postToDestination(ctx, "primary", config, post(newClient("v1", client.FormatJSON)))
+			secondary := postToDestination(ctx, "secondary", config, post(tc.secondary))
+			if primary.Err != nil || secondary.Err != nil {
+				t.Fatalf("unexpected errors: %v, %v", primary.Err, secondary.Err)
+			}
+			if len(received) != 2 || primary.PayloadHash != received[0] || secondary.PayloadHash != received[1] {
+				t.Fatalf("expected the hashes of the bodies received, got %q and %q, received %q", primary.PayloadHash, secondary.PayloadHash, received)
+			}
+
+			stats := &dualWriteStats{}
+			stats.record(compareDestinations(primary, secondary))
+			if mismatched := stats.HashMismatches == 1; mismatched != tc.mismatched {
+				t.Errorf("unexpected hash mismatch: got=%t want=%t (%s)", mismatched, tc.mismatched, stats)
+			}
+		})
+	}
+}
+
+func TestAppendDualWriteReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dual-write")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.jsonl")
+	// an earlier version of the agent created the file world-readable
+	if err := ioutil.WriteFile(path, []byte("first\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, line := range []string{"second\n", "third\n"} {
+		if err := appendDualWriteReport(path, []byte(line), 16); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for file, want := range map[string]string{path: "third\n", path + ".1": "first\nsecond\n"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(data) != want {
+			t.Errorf("unexpected content of %s: got %q, want %q", file, data, want)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the report to only be readable by the agent, got %v", info.Mode().Perm())
+	}
+}
@@ -44,6 +44,11 @@ func (g *dummyDataGatherer) Delete() error {
 	return nil
 }
 
+func (g *dummyDataGatherer) Healthy() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
 func (c *dummyDataGatherer) Fetch() (interface{}, error) {
 	var err error
 	if c.attemptNumber < c.FailedAttempts {
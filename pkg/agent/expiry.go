@@ -0,0 +1,240 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer/certmanager"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+// expirySummaryDataGatherer is the name of the readings of the expiry
+// summary.
+const expirySummaryDataGatherer = "expiry-summary"
+
+// defaultExpiryThresholds are the periods the certificates are counted as
+// expiring within, unless configured: 7, 30 and 90 days.
+var defaultExpiryThresholds = []time.Duration{7 * 24 * time.Hour, 30 * 24 * time.Hour, 90 * 24 * time.Hour}
+
+// ExpirySummary configures the summary of the expiry of the certificates
+// gathered, computed by the agent for each cluster and uploaded with the
+// readings.
+type ExpirySummary struct {
+	// Thresholds are the periods the certificates are counted as expiring
+	// within. Defaults to 7, 30 and 90 days.
+	Thresholds []time.Duration `yaml:"thresholds,omitempty"`
+	// SummaryOnly drops the readings the certificates are read from, so that
+	// only the summary is uploaded.
+	SummaryOnly bool `yaml:"summary-only,omitempty"`
+}
+
+func (e *ExpirySummary) validate() error {
+	for _, threshold := range e.Thresholds {
+		if threshold <= 0 {
+			return fmt.Errorf("expiry-summary.thresholds must be positive, got %s", threshold)
+		}
+	}
+	return nil
+}
+
+// expiryCounts counts certificates by expiry.
+type expiryCounts struct {
+	Total   int `json:"total"`
+	Expired int `json:"expired"`
+	// ExpiringWithin is the number of certificates not expired yet expiring
+	// within each threshold, e.g. 30d. It includes the ones expiring within
+	// the shorter thresholds.
+	ExpiringWithin map[string]int `json:"expiringWithin"`
+}
+
+// expirySource is the summary of the certificates of a data gatherer.
+type expirySource struct {
+	DataGatherer string `json:"dataGatherer"`
+	expiryCounts
+	Issuers    map[string]*expiryCounts `json:"issuers"`
+	Namespaces map[string]*expiryCounts `json:"namespaces"`
+}
+
+// expiryReport is the data of the expiry summary of a cluster.
+type expiryReport struct {
+	Thresholds []string        `json:"thresholds"`
+	Sources    []*expirySource `json:"sources"`
+}
+
+// expiringCertificate is a certificate counted in the summary.
+type expiringCertificate struct {
+	namespace, issuer string
+	notAfter          time.Time
+}
+
+// summarize adds a reading with the expiry summary of each cluster to the
+// readings, computed from the readings of the data gatherers emitting
+// certificates. With SummaryOnly, these readings are removed.
+func (e *ExpirySummary) summarize(readings []*api.DataReading, now time.Time) []*api.DataReading {
+	thresholds := append([]time.Duration(nil), e.Thresholds...)
+	if len(thresholds) == 0 {
+		thresholds = defaultExpiryThresholds
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] < thresholds[j] })
+	labels := make([]string, len(thresholds))
+	for i, threshold := range thresholds {
+		labels[i] = thresholdLabel(threshold)
+	}
+
+	var clusters []string
+	reports := map[string]*expiryReport{}
+	kept := make([]*api.DataReading, 0, len(readings))
+	for _, reading := range readings {
+		certificates, ok := expiringCertificates(reading.Data)
+		if !ok {
+			kept = append(kept, reading)
+			continue
+		}
+		if !e.SummaryOnly {
+			kept = append(kept, reading)
+		}
+
+		source := &expirySource{
+			DataGatherer: reading.DataGatherer,
+			expiryCounts: newExpiryCounts(labels),
+			Issuers:      map[string]*expiryCounts{},
+			Namespaces:   map[string]*expiryCounts{},
+		}
+		for _, c := range certificates {
+			source.count(c, now, thresholds, labels)
+		}
+
+		report, ok := reports[reading.ClusterID]
+		if !ok {
+			report = &expiryReport{Thresholds: labels}
+			reports[reading.ClusterID] = report
+			clusters = append(clusters, reading.ClusterID)
+		}
+		report.Sources = append(report.Sources, source)
+	}
+
+	for _, clusterID := range clusters {
+		reading := &api.DataReading{
+			ClusterID:     clusterID,
+			DataGatherer:  expirySummaryDataGatherer,
+			Timestamp:     api.Time{Time: now},
+			Data:          reports[clusterID],
+			SchemaVersion: schemaVersion,
+		}
+		stampDataVersion(reading, expirySummaryDataGatherer)
+		kept = append(kept, reading)
+	}
+	return kept
+}
+
+func newExpiryCounts(labels []string) expiryCounts {
+	counts := expiryCounts{ExpiringWithin: map[string]int{}}
+	for _, label := range labels {
+		counts.ExpiringWithin[label] = 0
+	}
+	return counts
+}
+
+// count adds the certificate to the counts of the source, of its issuer and
+// of its namespace.
+func (s *expirySource) count(c expiringCertificate, now time.Time, thresholds []time.Duration, labels []string) {
+	all := []*expiryCounts{&s.expiryCounts}
+	for _, group := range []struct {
+		counts map[string]*expiryCounts
+		key    string
+	}{
+		{s.Issuers, c.issuer},
+		{s.Namespaces, c.namespace},
+	} {
+		if group.key == "" {
+			continue
+		}
+		counts, ok := group.counts[group.key]
+		if !ok {
+			newCounts := newExpiryCounts(labels)
+			counts = &newCounts
+			group.counts[group.key] = counts
+		}
+		all = append(all, counts)
+	}
+
+	remaining := c.notAfter.Sub(now)
+	for _, counts := range all {
+		counts.Total++
+		if remaining <= 0 {
+			counts.Expired++
+			continue
+		}
+		for i, threshold := range thresholds {
+			if remaining <= threshold {
+				counts.ExpiringWithin[labels[i]]++
+			}
+		}
+	}
+}
+
+// thresholdLabel formats a threshold in days, e.g. 30d, or as a duration
+// when it is not a whole number of days.
+func thresholdLabel(threshold time.Duration) string {
+	day := 24 * time.Hour
+	if threshold%day == 0 {
+		return fmt.Sprintf("%dd", threshold/day)
+	}
+	return threshold.String()
+}
+
+// expiringCertificates returns the certificates in the data of the
+// k8s-tls-secrets and cert-manager data gatherers, with the issuer they are
+// grouped by. ok is false for the data of the other data gatherers.
+func expiringCertificates(data interface{}) (certificates []expiringCertificate, ok bool) {
+	switch data := data.(type) {
+	case *certmanager.Summary:
+		for _, c := range data.Certificates {
+			// the Certificates not issued yet have no expiry
+			notAfter, err := time.Parse(time.RFC3339, c.NotAfter)
+			if err != nil {
+				continue
+			}
+			certificates = append(certificates, expiringCertificate{
+				namespace: c.Namespace,
+				issuer:    certManagerIssuer(c.Namespace, c.IssuerRef),
+				notAfter:  notAfter,
+			})
+		}
+		return certificates, true
+	case map[string]interface{}:
+		secrets, ok := data["secrets"].([]*k8s.TLSSecret)
+		if !ok {
+			return nil, false
+		}
+		for _, secret := range secrets {
+			if len(secret.Certificates) == 0 {
+				continue
+			}
+			// the leaf certificate comes first
+			leaf := secret.Certificates[0]
+			certificates = append(certificates, expiringCertificate{
+				namespace: secret.Namespace,
+				issuer:    leaf.Issuer,
+				notAfter:  leaf.NotAfter,
+			})
+		}
+		return certificates, true
+	}
+	return nil, false
+}
+
+// certManagerIssuer identifies the issuer of a cert-manager Certificate, e.g.
+// ClusterIssuer/letsencrypt or Issuer/default/ca.
+func certManagerIssuer(namespace string, ref certmanager.IssuerReference) string {
+	kind := ref.Kind
+	if kind == "" {
+		kind = "Issuer"
+	}
+	if kind == "Issuer" && (ref.Group == "" || ref.Group == "cert-manager.io") {
+		return fmt.Sprintf("%s/%s/%s", kind, namespace, ref.Name)
+	}
+	return fmt.Sprintf("%s/%s", kind, ref.Name)
+}
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+	"github.com/jetstack/preflight/pkg/datagatherer/certmanager"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+func TestExpirySummary(t *testing.T) {
+	now := time.Date(2021, 3, 16, 18, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tlsSecret := func(namespace string, notAfter time.Time) *k8s.TLSSecret {
+		return &k8s.TLSSecret{
+			Namespace: namespace,
+			Certificates: []*certinfo.Certificate{
+				{Issuer: "CN=example-ca", NotAfter: notAfter},
+				{Issuer: "CN=root", NotAfter: now.Add(1000 * day)},
+			},
+		}
+	}
+
+	readings := []*api.DataReading{
+		{
+			ClusterID:    "a",
+			DataGatherer: "k8s/tls-secrets",
+			Data: map[string]interface{}{
+				"secrets": []*k8s.TLSSecret{
+					tlsSecret("default", now.Add(-day)),
+					tlsSecret("default", now.Add(3*day)),
+					tlsSecret("web", now.Add(20*day)),
+					{Namespace: "web", Error: "invalid certificate"},
+				},
+			},
+		},
+		{
+			ClusterID:    "a",
+			DataGatherer: "cert-manager",
+			Data: &certmanager.Summary{
+				Certificates: []*certmanager.CertificateSummary{
+					{Namespace: "web", IssuerRef: certmanager.IssuerReference{Name: "letsencrypt", Kind: "ClusterIssuer"}, NotAfter: now.Add(60 * day).Format(time.RFC3339)},
+					{Namespace: "web", IssuerRef: certmanager.IssuerReference{Name: "ca"}, NotAfter: now.Add(5 * day).Format(time.RFC3339)},
+					// not issued yet
+					{Namespace: "web", IssuerRef: certmanager.IssuerReference{Name: "ca"}},
+				},
+			},
+		},
+		{ClusterID: "a", DataGatherer: "k8s/pods", Data: map[string]interface{}{"items": []*api.GatheredResource{}}},
+		{ClusterID: "b", DataGatherer: "k8s/tls-secrets", Data: map[string]interface{}{"secrets": []*k8s.TLSSecret{}}},
+	}
+
+	summary := &ExpirySummary{}
+	got := summary.summarize(readings, now)
+	if len(got) != 6 {
+		t.Fatalf("expected the summaries of the 2 clusters to be added to the readings, got %d readings", len(got))
+	}
+	if got[4].ClusterID != "a" || got[4].DataGatherer != expirySummaryDataGatherer || got[5].ClusterID != "b" {
+		t.Fatalf("unexpected summary readings: %+v, %+v", got[4], got[5])
+	}
+
+	counts := func(total, expired, within7, within30, within90 int) expiryCounts {
+		return expiryCounts{
+			Total:          total,
+			Expired:        expired,
+			ExpiringWithin: map[string]int{"7d": within7, "30d": within30, "90d": within90},
+		}
+	}
+	ptr := func(c expiryCounts) *expiryCounts { return &c }
+	expected := &expiryReport{
+		Thresholds: []string{"7d", "30d", "90d"},
+		Sources: []*expirySource{
+			{
+				DataGatherer: "k8s/tls-secrets",
+				expiryCounts: counts(3, 1, 1, 2, 2),
+				Issuers: map[string]*expiryCounts{
+					"CN=example-ca": ptr(counts(3, 1, 1, 2, 2)),
+				},
+				Namespaces: map[string]*expiryCounts{
+					"default": ptr(counts(2, 1, 1, 1, 1)),
+					"web":     ptr(counts(1, 0, 0, 1, 1)),
+				},
+			},
+			{
+				DataGatherer: "cert-manager",
+				expiryCounts: counts(2, 0, 1, 1, 2),
+				Issuers: map[string]*expiryCounts{
+					"ClusterIssuer/letsencrypt": ptr(counts(1, 0, 0, 0, 1)),
+					"Issuer/web/ca":             ptr(counts(1, 0, 1, 1, 1)),
+				},
+				Namespaces: map[string]*expiryCounts{
+					"web": ptr(counts(2, 0, 1, 1, 2)),
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(expected, got[4].Data) {
+		diff, _ := messagediff.PrettyDiff(expected, got[4].Data)
+		t.Errorf("unexpected summary:\n%s", diff)
+	}
+
+	summary = &ExpirySummary{Thresholds: []time.Duration{36 * time.Hour}, SummaryOnly: true}
+	got = summary.summarize(readings, now)
+	if len(got) != 3 || got[0].DataGatherer != "k8s/pods" {
+		t.Fatalf("expected the readings of the certificates to be dropped, got %d readings", len(got))
+	}
+	if labels := got[1].Data.(*expiryReport).Thresholds; len(labels) != 1 || labels[0] != "36h0m0s" {
+		t.Errorf("unexpected thresholds: %v", labels)
+	}
+}
@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// defaultFetchConcurrency and defaultFetchTimeout apply unless configured.
+const (
+	defaultFetchConcurrency = 4
+	defaultFetchTimeout     = 5 * time.Minute
+)
+
+// fetchResult is the outcome of the fetch of a data gatherer.
+type fetchResult struct {
+	data       interface{}
+	err        error
+	gatheredAt time.Time
+	duration   time.Duration
+}
+
+// inFlightFetches are the names of the data gatherers of an agent being
+// fetched. A data gatherer whose fetch timed out stays in it until the fetch
+// returns, and is not fetched again until then, as the data gatherers are not
+// safe to fetch concurrently.
+type inFlightFetches struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func newInFlightFetches() *inFlightFetches {
+	return &inFlightFetches{names: map[string]bool{}}
+}
+
+// start records that the data gatherer is being fetched, it returns false if
+// its previous fetch has not returned yet.
+func (f *inFlightFetches) start(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.names[name] {
+		return false
+	}
+	f.names[name] = true
+	return true
+}
+
+// done records that the fetch of the data gatherer returned.
+func (f *inFlightFetches) done(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.names, name)
+}
+
+// fetchTimeoutError is the error of a fetch that did not complete in time.
+type fetchTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *fetchTimeoutError) Error() string {
+	return fmt.Sprintf("the fetch timed out after %s", e.timeout)
+}
+
+// fetchAll fetches the data gatherers, up to concurrency at a time. A fetch
+// taking longer than timeout fails, without waiting for it to return. A
+// zero timeout waits for as long as the fetches take. inFlight are the fetches
+// of the agent which have not returned yet.
+func fetchAll(ctx context.Context, dataGatherers map[string]datagatherer.DataGatherer, inFlight *inFlightFetches, concurrency int, timeout time.Duration) map[string]fetchResult {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	names := sortedNames(dataGatherers)
+
+	var mu sync.Mutex
+	results := make(map[string]fetchResult, len(names))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+	for _, name := range names {
+		name, dg := name, dataGatherers[name]
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			_, span := tracing.Start(ctx, "fetch")
+			span.SetAttributes(attribute.String("data_gatherer", name))
+			result := fetchResult{gatheredAt: time.Now()}
+			result.data, result.err = fetchWithTimeout(ctx, name, dg, inFlight, timeout)
+			result.duration = time.Since(result.gatheredAt)
+			tracing.RecordError(span, result.err)
+			span.End()
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// fetchWithTimeout fetches the data gatherer, failing if it takes longer
+// than timeout. The fetch of the data gatherers supporting it is cancelled,
+// the other ones keep running in the background until they return, and the
+// data gatherer is not fetched again until then.
+func fetchWithTimeout(ctx context.Context, name string, dg datagatherer.DataGatherer, inFlight *inFlightFetches, timeout time.Duration) (interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	fetch := dg.Fetch
+	if fetcher, ok := dg.(datagatherer.ContextFetcher); ok {
+		fetch = func() (interface{}, error) {
+			return fetcher.FetchContext(ctx)
+		}
+	}
+	if timeout <= 0 {
+		return fetch()
+	}
+	if !inFlight.start(name) {
+		return nil, fmt.Errorf("the previous fetch has not returned yet")
+	}
+
+	type result struct {
+		data interface{}
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		data, err := fetch()
+		inFlight.done(name)
+		done <- result{data, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.data, r.err
+	case <-timer.C:
+		return nil, &fetchTimeoutError{timeout: timeout}
+	}
+}
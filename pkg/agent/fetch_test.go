@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// blockingDataGatherer blocks its fetches until release is closed, and
+// records the number of concurrent fetches.
+type blockingDataGatherer struct {
+	dummyDataGatherer
+	release <-chan struct{}
+	tracker *concurrencyTracker
+}
+
+func (g *blockingDataGatherer) Fetch() (interface{}, error) {
+	g.tracker.enter()
+	defer g.tracker.leave()
+	<-g.release
+	return "data", nil
+}
+
+type concurrencyTracker struct {
+	mu            sync.Mutex
+	current, peak int
+}
+
+func (c *concurrencyTracker) enter() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current++
+	if c.current > c.peak {
+		c.peak = c.current
+	}
+}
+
+func (c *concurrencyTracker) leave() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current--
+}
+
+func TestFetchAllConcurrency(t *testing.T) {
+	release := make(chan struct{})
+	tracker := &concurrencyTracker{}
+	dataGatherers := map[string]datagatherer.DataGatherer{}
+	for i := 0; i < 5; i++ {
+		dataGatherers[fmt.Sprintf("dg-%d", i)] = &blockingDataGatherer{release: release, tracker: tracker}
+	}
+	dataGatherers["failing"] = &dummyDataGatherer{AlwaysFail: true}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	results := fetchAll(context.Background(), dataGatherers, newInFlightFetches(), 2, 0)
+
+	if tracker.peak != 2 {
+		t.Errorf("expected 2 concurrent fetches, got %d", tracker.peak)
+	}
+	if len(results) != 6 {
+		t.Fatalf("expected 6 results, got %d", len(results))
+	}
+	for name, result := range results {
+		if name == "failing" {
+			if result.err == nil {
+				t.Errorf("expected the failing data gatherer to fail")
+			}
+			continue
+		}
+		if result.err != nil || result.data != "data" {
+			t.Errorf("unexpected result of %s: %+v", name, result)
+		}
+	}
+}
+
+func TestFetchWithTimeout(t *testing.T) {
+	release := make(chan struct{})
+	dg := &blockingDataGatherer{release: release, tracker: &concurrencyTracker{}}
+	inFlight := newInFlightFetches()
+
+	_, err := fetchWithTimeout(context.Background(), "stuck", dg, inFlight, 10*time.Millisecond)
+	if _, ok := err.(*fetchTimeoutError); !ok || err.Error() != "the fetch timed out after 10ms" {
+		t.Fatalf("expected the fetch to time out, got %v", err)
+	}
+	_, err = fetchWithTimeout(context.Background(), "stuck", dg, inFlight, 10*time.Millisecond)
+	if err == nil || err.Error() != "the previous fetch has not returned yet" {
+		t.Fatalf("expected the data gatherer not to be fetched again, got %v", err)
+	}
+	// the fetches of another agent are tracked separately
+	released := make(chan struct{})
+	close(released)
+	other := &blockingDataGatherer{release: released, tracker: &concurrencyTracker{}}
+	if _, err := fetchWithTimeout(context.Background(), "stuck", other, newInFlightFetches(), time.Second); err != nil {
+		t.Fatalf("expected the data gatherer of another agent to be fetched, got %v", err)
+	}
+
+	close(release)
+	// the data gatherer can be fetched again once its fetch returned
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, err := fetchWithTimeout(context.Background(), "stuck", dg, inFlight, time.Second)
+		if err == nil && data == "data" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the data gatherer to be fetched again, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// contextDataGatherer blocks its fetches until their context is done.
+type contextDataGatherer struct {
+	dummyDataGatherer
+	cancelled chan struct{}
+}
+
+func (g *contextDataGatherer) FetchContext(ctx context.Context) (interface{}, error) {
+	<-ctx.Done()
+	close(g.cancelled)
+	return nil, ctx.Err()
+}
+
+func TestFetchWithTimeoutCancels(t *testing.T) {
+	dg := &contextDataGatherer{cancelled: make(chan struct{})}
+	if _, err := fetchWithTimeout(context.Background(), "hung", dg, newInFlightFetches(), 10*time.Millisecond); err == nil {
+		t.Fatalf("expected the fetch to time out")
+	}
+	select {
+	case <-dg.cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the fetch to be cancelled once timed out")
+	}
+}
@@ -0,0 +1,308 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/logs"
+	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+// gathererResourcesSyncTimeout bounds the initial list of the DataGatherer
+// resources when the agent starts.
+const gathererResourcesSyncTimeout = 30 * time.Second
+
+// syncedCondition is the type of the condition of the DataGatherer resources
+// reporting whether the agent runs them.
+const syncedCondition = "Synced"
+
+var dataGathererResourcesGVR = schema.GroupVersionResource{Group: "preflight.jetstack.io", Version: "v1alpha1", Resource: "datagatherers"}
+
+// DataGathererResources reads data gatherers from the DataGatherer custom
+// resources of a namespace, in addition to the ones of the configuration
+// file. The resources are watched, and the data gatherers reloaded when they
+// change.
+type DataGathererResources struct {
+	// KubeConfigPath is the kubeconfig of the cluster, the in-cluster
+	// configuration is used if it is empty.
+	KubeConfigPath string `yaml:"kubeconfig,omitempty"`
+	// Namespace is the namespace of the resources. Defaults to the namespace
+	// of the agent.
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// gathererResources watches the DataGatherer resources, and reports whether
+// their data gatherers run in their status.
+type gathererResources struct {
+	client    dynamic.Interface
+	namespace string
+	informer  k8scache.SharedIndexInformer
+	// changed is notified on every event of the informer.
+	changed chan struct{}
+
+	mu sync.Mutex
+	// applied are the generations of the resources last applied, by name.
+	applied map[string]int64
+	// results are the outcome of the resources last applied.
+	results []gathererResourceResult
+}
+
+// gathererResourceResult is the outcome of a DataGatherer resource.
+type gathererResourceResult struct {
+	// resource is the name of the resource, and generation the generation
+	// of its spec applied.
+	resource   string
+	generation int64
+	// name is the name of the data gatherer of the resource.
+	name string
+	// err is why the data gatherer of the resource is not run.
+	err error
+}
+
+func newGathererResources(cfg *DataGathererResources) (*gathererResources, error) {
+	namespace := cfg.Namespace
+	if namespace == "" {
+		var ok bool
+		if namespace, ok = templateFuncs["namespace"](os.LookupEnv); !ok || namespace == "" {
+			return nil, fmt.Errorf("data-gatherer-resources.namespace must be set when the agent does not run in a Pod")
+		}
+	}
+	cl, err := k8s.NewDynamicClient(cfg.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return newGathererResourcesWithClient(cl, namespace), nil
+}
+
+func newGathererResourcesWithClient(cl dynamic.Interface, namespace string) *gathererResources {
+	r := &gathererResources{
+		client:    cl,
+		namespace: namespace,
+		changed:   make(chan struct{}, 1),
+		applied:   map[string]int64{},
+	}
+	r.informer = dynamicinformer.NewFilteredDynamicInformer(
+		cl,
+		dataGathererResourcesGVR,
+		namespace,
+		60*time.Second,
+		k8scache.Indexers{},
+		nil,
+	).Informer()
+	notify := func() { notifyReload(r.changed) }
+	r.informer.AddEventHandler(k8scache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+	return r
+}
+
+// start runs the informer of the resources until ctx is done, and waits for
+// its initial list.
+func (r *gathererResources) start(ctx context.Context) error {
+	go r.informer.Run(ctx.Done())
+	syncCtx, cancel := context.WithTimeout(ctx, gathererResourcesSyncTimeout)
+	defer cancel()
+	if !k8scache.WaitForCacheSync(syncCtx.Done(), r.informer.HasSynced) {
+		return fmt.Errorf("failed to list the DataGatherer resources of the namespace %q, is the CustomResourceDefinition installed?", r.namespace)
+	}
+	return nil
+}
+
+// apply adds the data gatherers of the resources to the config. The
+// resources which are invalid, or whose data gatherer is already configured,
+// are skipped and reported in their status.
+func (r *gathererResources) apply(config *Config) {
+	if r == nil {
+		return
+	}
+	names := map[string]bool{}
+	for _, dg := range config.DataGatherers {
+		names[dg.Name] = true
+	}
+
+	resources := r.list()
+	applied := map[string]int64{}
+	results := make([]gathererResourceResult, 0, len(resources))
+	for _, resource := range resources {
+		applied[resource.GetName()] = resource.GetGeneration()
+		dg, err := dataGathererFromResource(resource)
+		if err == nil && names[dg.Name] {
+			err = fmt.Errorf("the data gatherer %q is already configured", dg.Name)
+		}
+		if err != nil {
+			logs.Log.Warnf("Skipping the DataGatherer %s/%s: %v", r.namespace, resource.GetName(), err)
+		} else {
+			names[dg.Name] = true
+			config.DataGatherers = append(config.DataGatherers, dg)
+		}
+		results = append(results, gathererResourceResult{
+			resource:   resource.GetName(),
+			generation: resource.GetGeneration(),
+			name:       dg.Name,
+			err:        err,
+		})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.applied = applied
+	r.results = results
+}
+
+// list returns the resources in the store of the informer, by name.
+func (r *gathererResources) list() []*unstructured.Unstructured {
+	var resources []*unstructured.Unstructured
+	for _, obj := range r.informer.GetStore().List() {
+		if resource, ok := obj.(*unstructured.Unstructured); ok {
+			resources = append(resources, resource)
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].GetName() < resources[j].GetName() })
+	return resources
+}
+
+// outdated returns whether a resource was added, removed or had its spec
+// changed since the resources were last applied. The updates of the status
+// do not change the generation of the resources.
+func (r *gathererResources) outdated() bool {
+	current := map[string]int64{}
+	for _, resource := range r.list() {
+		current[resource.GetName()] = resource.GetGeneration()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !reflect.DeepEqual(current, r.applied)
+}
+
+// sync notifies the agent to reload its configuration when the resources
+// change, until ctx is done.
+func (r *gathererResources) sync(ctx context.Context, reloads chan<- struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.changed:
+		}
+		if r.outdated() {
+			logs.Log.Infof("The DataGatherer resources changed, reloading them")
+			notifyReload(reloads)
+		}
+	}
+}
+
+// report sets the Synced condition of the resources last applied. reloadErr
+// is the error of the reload of the configuration, if it failed.
+func (r *gathererResources) report(ctx context.Context, reloadErr error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	results := r.results
+	r.mu.Unlock()
+
+	now := time.Now()
+	for _, result := range results {
+		condition := map[string]interface{}{
+			"type":               syncedCondition,
+			"status":             string(metav1.ConditionTrue),
+			"reason":             "Running",
+			"observedGeneration": result.generation,
+			"message":            fmt.Sprintf("The data gatherer %q is running", result.name),
+		}
+		switch {
+		case result.err != nil:
+			condition["status"] = string(metav1.ConditionFalse)
+			condition["reason"] = "InvalidSpec"
+			condition["message"] = result.err.Error()
+		case reloadErr != nil:
+			condition["status"] = string(metav1.ConditionFalse)
+			condition["reason"] = "ReloadFailed"
+			condition["message"] = fmt.Sprintf("The configuration of the agent could not be reloaded: %v", reloadErr)
+		}
+		if err := r.setCondition(ctx, result.resource, condition, now); err != nil {
+			logs.Log.Warnf("Failed to update the status of the DataGatherer %s/%s: %v", r.namespace, result.resource, err)
+		}
+	}
+}
+
+// setCondition replaces the Synced condition in the status of the resource,
+// unless it is unchanged or the resource was deleted. The last transition
+// time is only changed with the status of the condition.
+func (r *gathererResources) setCondition(ctx context.Context, name string, condition map[string]interface{}, now time.Time) error {
+	obj, exists, err := r.informer.GetStore().GetByKey(r.namespace + "/" + name)
+	if err != nil || !exists {
+		return err
+	}
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil
+	}
+	resource = resource.DeepCopy()
+	conditions, _, _ := unstructured.NestedSlice(resource.Object, "status", "conditions")
+	kept := make([]interface{}, 0, len(conditions)+1)
+	condition["lastTransitionTime"] = now.UTC().Format(time.RFC3339)
+	for _, c := range conditions {
+		existing, ok := c.(map[string]interface{})
+		if !ok || existing["type"] != syncedCondition {
+			kept = append(kept, c)
+			continue
+		}
+		if existing["status"] == condition["status"] {
+			condition["lastTransitionTime"] = existing["lastTransitionTime"]
+		}
+		if reflect.DeepEqual(existing, condition) {
+			return nil
+		}
+	}
+	kept = append(kept, condition)
+	if err := unstructured.SetNestedSlice(resource.Object, kept, "status", "conditions"); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err = r.client.Resource(dataGathererResourcesGVR).Namespace(r.namespace).UpdateStatus(ctx, resource, metav1.UpdateOptions{})
+	return err
+}
+
+// dataGathererFromResource reads the data gatherer in the spec of a
+// DataGatherer resource. The spec has the fields of a data gatherer of the
+// configuration file, its name defaults to the name of the resource.
+func dataGathererFromResource(resource *unstructured.Unstructured) (DataGatherer, error) {
+	var dg DataGatherer
+	spec, ok, err := unstructured.NestedMap(resource.Object, "spec")
+	if err != nil || !ok {
+		return dg, fmt.Errorf("the resource has no spec")
+	}
+	if name, _ := spec["name"].(string); name == "" {
+		spec["name"] = resource.GetName()
+	}
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return dg, err
+	}
+	if err := yaml.Unmarshal(data, &dg); err != nil {
+		return dg, fmt.Errorf("invalid spec: %v", err)
+	}
+	if err := validateDataGatherers([]DataGatherer{dg}); err != nil {
+		return dg, fmt.Errorf("invalid spec: %v", err)
+	}
+	if dg.DataPath != "" {
+		return dg, fmt.Errorf("invalid spec: data-path is not supported")
+	}
+	return dg, nil
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func getDataGathererResource(name string, generation int64, spec map[string]interface{}) *unstructured.Unstructured {
+	resource := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "preflight.jetstack.io/v1alpha1",
+		"kind":       "DataGatherer",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "jetstack-secure",
+		},
+	}}
+	resource.SetGeneration(generation)
+	if spec != nil {
+		resource.Object["spec"] = spec
+	}
+	return resource
+}
+
+func TestGathererResources(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{dataGathererResourcesGVR: "DataGathererList"},
+		getDataGathererResource("pods", 1, map[string]interface{}{"kind": "dummy"}),
+		getDataGathererResource("nodes", 2, map[string]interface{}{"kind": "dummy", "name": "k8s/nodes", "schedule": "1h"}),
+		getDataGathererResource("duplicate", 1, map[string]interface{}{"kind": "dummy", "name": "local"}),
+		getDataGathererResource("unknown", 1, map[string]interface{}{"kind": "unknown"}),
+		getDataGathererResource("empty", 1, nil),
+	)
+	resources := newGathererResourcesWithClient(cl, "jetstack-secure")
+	if err := resources.start(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := Config{DataGatherers: []DataGatherer{{Kind: "dummy", Name: "local", Config: &dummyConfig{}}}}
+	resources.apply(&config)
+	if names := dataGathererNames(config.DataGatherers); fmt.Sprint(names) != "[local k8s/nodes pods]" {
+		t.Fatalf("unexpected data gatherers: %v", names)
+	}
+	if config.DataGatherers[1].Schedule != "1h" {
+		t.Errorf("expected the schedule of the spec, got %q", config.DataGatherers[1].Schedule)
+	}
+	if resources.outdated() {
+		t.Errorf("expected the resources to be up to date")
+	}
+
+	resources.report(ctx, nil)
+	expected := map[string]struct {
+		status, reason string
+	}{
+		"pods":      {"True", "Running"},
+		"nodes":     {"True", "Running"},
+		"duplicate": {"False", "InvalidSpec"},
+		"unknown":   {"False", "InvalidSpec"},
+		"empty":     {"False", "InvalidSpec"},
+	}
+	for name, want := range expected {
+		resource, err := cl.Resource(dataGathererResourcesGVR).Namespace("jetstack-secure").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		conditions, _, _ := unstructured.NestedSlice(resource.Object, "status", "conditions")
+		if len(conditions) != 1 {
+			t.Fatalf("expected the Synced condition of %q, got %v", name, conditions)
+		}
+		condition := conditions[0].(map[string]interface{})
+		if condition["type"] != syncedCondition || condition["status"] != want.status || condition["reason"] != want.reason {
+			t.Errorf("unexpected condition of %q: %v", name, condition)
+		}
+		if condition["observedGeneration"] != resource.GetGeneration() {
+			t.Errorf("expected the observed generation of %q to be %d, got %v", name, resource.GetGeneration(), condition["observedGeneration"])
+		}
+	}
+
+	// a change of the spec bumps the generation
+	updated := getDataGathererResource("pods", 2, map[string]interface{}{"kind": "dummy", "schedule": "2h"})
+	if _, err := cl.Resource(dataGathererResourcesGVR).Namespace("jetstack-secure").Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for !resources.outdated() {
+		select {
+		case <-resources.changed:
+		case <-timeout:
+			t.Fatalf("expected the change of the spec to be noticed")
+		}
+	}
+}
+
+func TestDataGathererFromResource(t *testing.T) {
+	tests := map[string]struct {
+		spec         map[string]interface{}
+		expectedName string
+		expectErr    bool
+	}{
+		"name of the resource": {
+			spec:         map[string]interface{}{"kind": "dummy"},
+			expectedName: "resource",
+		},
+		"name of the spec": {
+			spec:         map[string]interface{}{"kind": "dummy", "name": "k8s/pods"},
+			expectedName: "k8s/pods",
+		},
+		"config": {
+			spec:         map[string]interface{}{"kind": "dummy", "config": map[string]interface{}{"always-fail": true}},
+			expectedName: "resource",
+		},
+		"unknown kind": {
+			spec:      map[string]interface{}{"kind": "unknown"},
+			expectErr: true,
+		},
+		"invalid schedule": {
+			spec:      map[string]interface{}{"kind": "dummy", "schedule": "often"},
+			expectErr: true,
+		},
+		"data path": {
+			spec:      map[string]interface{}{"kind": "dummy", "data-path": "/tmp/data.json"},
+			expectErr: true,
+		},
+		"no spec": {
+			expectErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dg, err := dataGathererFromResource(getDataGathererResource("resource", 1, test.spec))
+			if test.expectErr != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && dg.Name != test.expectedName {
+				t.Errorf("expected name %q, got %q", test.expectedName, dg.Name)
+			}
+		})
+	}
+}
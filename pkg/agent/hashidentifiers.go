@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+// minSaltLength is the minimum length of the salt, so that the hashes of
+// short identifiers cannot be guessed.
+const minSaltLength = 16
+
+// HashIdentifiers replaces identifiers of the gathered resources, such as
+// namespaces, with a keyed hash before they leave the agent.
+type HashIdentifiers struct {
+	// Identifiers are the identifiers hashed: namespaces, secret-names and
+	// hostnames.
+	Identifiers []string `yaml:"identifiers"`
+	// SaltFile is the path of the file holding the salt the hashes are keyed
+	// with, usually mounted from a Secret. The key of each cluster is derived
+	// from the salt and its cluster ID.
+	SaltFile string `yaml:"salt-file"`
+}
+
+func (h *HashIdentifiers) validate() error {
+	if err := k8s.ValidateHashedIdentifiers(h.Identifiers); err != nil {
+		return fmt.Errorf("hash-identifiers.identifiers is invalid: %v", err)
+	}
+	if h.SaltFile == "" {
+		return fmt.Errorf("hash-identifiers.salt-file is required")
+	}
+	return nil
+}
+
+// identifierHashers hash the identifiers of the gathered resources with the
+// key of their cluster.
+type identifierHashers struct {
+	salt        []byte
+	identifiers []string
+}
+
+// newIdentifierHashers loads the salt once, to report a misconfiguration on
+// start. It returns nil if no identifier is hashed.
+func newIdentifierHashers(h *HashIdentifiers) (*identifierHashers, error) {
+	if h == nil {
+		return nil, nil
+	}
+	salt, err := ioutil.ReadFile(h.SaltFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the salt: %v", err)
+	}
+	salt = bytes.TrimSpace(salt)
+	if len(salt) < minSaltLength {
+		return nil, fmt.Errorf("the salt must be at least %d bytes long, got %d", minSaltLength, len(salt))
+	}
+	return &identifierHashers{salt: salt, identifiers: h.Identifiers}, nil
+}
+
+// forCluster returns the hasher of the identifiers of a cluster, or nil if no
+// identifier is hashed.
+func (h *identifierHashers) forCluster(clusterID string) (*k8s.IdentifierHasher, error) {
+	if h == nil {
+		return nil, nil
+	}
+	return k8s.NewIdentifierHasher(h.salt, clusterID, h.identifiers)
+}
+
+// hashData replaces the gathered resources of a data gatherer's Fetch with
+// their hashed copies.
+func (h *identifierHashers) hashData(clusterID string, data interface{}) error {
+	hasher, err := h.forCluster(clusterID)
+	if err != nil || hasher == nil {
+		return err
+	}
+	hasher.HashData(data)
+	return nil
+}
+
+// hashedIdentifiers returns the identifiers hashed, for the provenance of the
+// resources.
+func (h *identifierHashers) hashedIdentifiers() []string {
+	if h == nil {
+		return nil
+	}
+	return h.identifiers
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestHashIdentifiersValidate(t *testing.T) {
+	tests := map[string]struct {
+		config  HashIdentifiers
+		wantErr bool
+	}{
+		"valid":              {config: HashIdentifiers{Identifiers: []string{k8s.HashedNamespaces}, SaltFile: "/etc/salt"}},
+		"missing salt":       {config: HashIdentifiers{Identifiers: []string{k8s.HashedNamespaces}}, wantErr: true},
+		"no identifiers":     {config: HashIdentifiers{SaltFile: "/etc/salt"}, wantErr: true},
+		"unknown identifier": {config: HashIdentifiers{Identifiers: []string{"pod-names"}, SaltFile: "/etc/salt"}, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.config.validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewIdentifierHashers(t *testing.T) {
+	dir := t.TempDir()
+	short := filepath.Join(dir, "short")
+	if err := ioutil.WriteFile(short, []byte("salt\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := newIdentifierHashers(&HashIdentifiers{Identifiers: []string{k8s.HashedNamespaces}, SaltFile: short}); err == nil {
+		t.Errorf("expected an error for a short salt")
+	}
+	if _, err := newIdentifierHashers(&HashIdentifiers{Identifiers: []string{k8s.HashedNamespaces}, SaltFile: filepath.Join(dir, "missing")}); err == nil {
+		t.Errorf("expected an error for a missing salt")
+	}
+
+	hashers, err := newIdentifierHashers(nil)
+	if err != nil || hashers != nil {
+		t.Fatalf("expected no hashers, got %v, %v", hashers, err)
+	}
+	// nothing is hashed without hashers
+	if err := hashers.hashData("cluster", map[string]interface{}{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	salt := filepath.Join(dir, "salt")
+	if err := ioutil.WriteFile(salt, []byte("0123456789abcdef\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hashers, err = newIdentifierHashers(&HashIdentifiers{Identifiers: []string{k8s.HashedNamespaces}, SaltFile: salt})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cached := &api.GatheredResource{Resource: &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "shop"},
+	}}}
+	data := map[string]interface{}{"items": []*api.GatheredResource{cached}}
+	if err := hashers.hashData("cluster", data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hasher, err := hashers.forCluster("cluster")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hashed := data["items"].([]*api.GatheredResource)[0].Resource.(*unstructured.Unstructured)
+	if hashed.GetNamespace() != hasher.Hash("shop") {
+		t.Errorf("expected the namespace to be hashed, got %q", hashed.GetNamespace())
+	}
+	// the cached resource is left as is
+	if namespace := cached.Resource.(*unstructured.Unstructured).GetNamespace(); namespace != "shop" {
+		t.Errorf("expected the cached resource not to be modified, got %q", namespace)
+	}
+}
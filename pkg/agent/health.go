@@ -0,0 +1,419 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/metrics"
+)
+
+const (
+	// defaultMaxUploadPeriods is the number of periods the agent stays ready
+	// for without a successful upload, unless configured.
+	defaultMaxUploadPeriods = 3
+	// defaultMaxConsecutiveErrors is the number of consecutive failed fetches
+	// of a data gatherer before the agent is not ready, unless configured.
+	defaultMaxConsecutiveErrors = 3
+	// defaultShutdownTimeout bounds the graceful shutdown of the agent,
+	// unless configured.
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+// Health configures the readiness and liveness of the agent, and its
+// graceful shutdown.
+type Health struct {
+	// MaxUploadPeriods is the number of periods the agent stays ready for
+	// without a successful upload, and the number of periods the cycles of
+	// the agent can be late by before it is not live anymore. Defaults to 3.
+	MaxUploadPeriods int `yaml:"max-upload-periods,omitempty"`
+	// MaxConsecutiveErrors is the number of consecutive failed fetches of a
+	// data gatherer before the agent is not ready, so that a transient error
+	// does not take the agent out of service. Defaults to 3.
+	MaxConsecutiveErrors int `yaml:"max-consecutive-errors,omitempty"`
+	// ShutdownTimeout is how long the agent waits for the current cycle and
+	// the pending uploads on SIGTERM before exiting. It should be shorter
+	// than the termination grace period of the Pod. Defaults to 30s.
+	ShutdownTimeout time.Duration `yaml:"shutdown-timeout,omitempty"`
+}
+
+func (h *Health) validate() error {
+	if h.MaxUploadPeriods < 0 {
+		return fmt.Errorf("health.max-upload-periods cannot be negative")
+	}
+	if h.MaxConsecutiveErrors < 0 {
+		return fmt.Errorf("health.max-consecutive-errors cannot be negative")
+	}
+	if h.ShutdownTimeout < 0 {
+		return fmt.Errorf("health.shutdown-timeout cannot be negative")
+	}
+	return nil
+}
+
+func (h *Health) maxUploadPeriods() int {
+	if h == nil || h.MaxUploadPeriods == 0 {
+		return defaultMaxUploadPeriods
+	}
+	return h.MaxUploadPeriods
+}
+
+func (h *Health) maxConsecutiveErrors() int {
+	if h == nil || h.MaxConsecutiveErrors == 0 {
+		return defaultMaxConsecutiveErrors
+	}
+	return h.MaxConsecutiveErrors
+}
+
+func (h *Health) shutdownTimeout() time.Duration {
+	if h == nil || h.ShutdownTimeout == 0 {
+		return defaultShutdownTimeout
+	}
+	return h.ShutdownTimeout
+}
+
+// healthTracker records the health of every data gatherer as it is fetched
+// by the agent, and the progress of the agent itself. It is safe to use a nil
+// healthTracker, which records nothing.
+type healthTracker struct {
+	mu        sync.Mutex
+	gatherers map[string]*api.DataGathererHealth
+
+	// started is when the agent started, synced is set once the initial
+	// sync of the data gatherers completed.
+	started time.Time
+	synced  bool
+	// lastCycle is when the last cycle completed, and period the time
+	// waited until the next one.
+	lastCycle time.Time
+	period    time.Duration
+	// lastUpload is the last successful upload, it is only checked when
+	// maxUploadPeriods is set.
+	lastUpload       time.Time
+	maxUploadPeriods int
+	// maxCyclePeriods is the number of periods a cycle can be late by.
+	maxCyclePeriods int
+	// maxConsecutiveErrors is the number of consecutive failed fetches
+	// before a data gatherer is unhealthy.
+	maxConsecutiveErrors int
+}
+
+func newHealthTracker(names []string) *healthTracker {
+	t := &healthTracker{
+		gatherers:            map[string]*api.DataGathererHealth{},
+		started:              time.Now(),
+		maxCyclePeriods:      defaultMaxUploadPeriods,
+		maxConsecutiveErrors: defaultMaxConsecutiveErrors,
+	}
+	for _, name := range names {
+		t.gatherers[name] = &api.DataGathererHealth{}
+	}
+	return t
+}
+
+// success records a successful fetch of the data gatherer, and returns a
+// copy of its health to be embedded in the reading.
+func (t *healthTracker) success(name string, items int, degraded string) *api.DataGathererHealth {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.healthOf(name)
+	h.LastSuccess = &api.Time{Time: time.Now()}
+	h.Items = items
+	h.ConsecutiveErrors = 0
+	h.LastError = ""
+	h.Degraded = degraded
+	health := *h
+	return &health
+}
+
+// failure records a failed fetch of the data gatherer, and returns a copy of
+// its health.
+func (t *healthTracker) failure(name string, err error) *api.DataGathererHealth {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.healthOf(name)
+	h.Errors++
+	h.ConsecutiveErrors++
+	h.LastError = err.Error()
+	health := *h
+	return &health
+}
+
+// remove forgets the data gatherer, e.g. once removed from the configuration.
+func (t *healthTracker) remove(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.gatherers, name)
+}
+
+// configure sets the number of periods the agent stays ready for without a
+// successful upload, the number of periods a cycle can be late by and the
+// number of consecutive failed fetches a data gatherer stays healthy for.
+// expectUploads is false when the readings are not uploaded, e.g. written to
+// a file instead.
+func (t *healthTracker) configure(config *Health, expectUploads bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxCyclePeriods = config.maxUploadPeriods()
+	t.maxConsecutiveErrors = config.maxConsecutiveErrors()
+	t.maxUploadPeriods = 0
+	if expectUploads {
+		t.maxUploadPeriods = config.maxUploadPeriods()
+	}
+}
+
+// setSynced records that the initial sync of the data gatherers completed.
+func (t *healthTracker) setSynced() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.synced = true
+}
+
+// cycled records that a cycle completed, and that the next one starts after
+// period.
+func (t *healthTracker) cycled(now time.Time, period time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastCycle = now
+	t.period = period
+}
+
+// uploaded records a successful upload of the readings.
+func (t *healthTracker) uploaded(now time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastUpload = now
+}
+
+// healthOf returns the health of the data gatherer, mu must be held.
+func (t *healthTracker) healthOf(name string) *api.DataGathererHealth {
+	h, ok := t.gatherers[name]
+	if !ok {
+		h = &api.DataGathererHealth{}
+		t.gatherers[name] = h
+	}
+	return h
+}
+
+// snapshot returns a copy of the health of all the data gatherers.
+func (t *healthTracker) snapshot() map[string]*api.DataGathererHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	gatherers := map[string]*api.DataGathererHealth{}
+	for name, h := range t.gatherers {
+		health := *h
+		gatherers[name] = &health
+	}
+	return gatherers
+}
+
+// Healthy returns an error listing the unhealthy data gatherers, or nil if
+// they are all healthy. A data gatherer stays healthy until
+// maxConsecutiveErrors of its fetches in a row failed.
+func (t *healthTracker) Healthy() error {
+	t.mu.Lock()
+	maxConsecutiveErrors := t.maxConsecutiveErrors
+	t.mu.Unlock()
+	var problems []string
+	for name, h := range t.snapshot() {
+		if err := h.Healthy(maxConsecutiveErrors); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("%s", strings.Join(problems, ", "))
+}
+
+// Ready returns an error if the agent is not ready at now: the initial sync
+// of the data gatherers has not completed, no upload succeeded for
+// maxUploadPeriods periods, or a data gatherer is unhealthy.
+func (t *healthTracker) Ready(now time.Time) error {
+	t.mu.Lock()
+	synced, period, maxPeriods := t.synced, t.period, t.maxUploadPeriods
+	lastUpload := t.lastUpload
+	if lastUpload.IsZero() {
+		lastUpload = t.started
+	}
+	t.mu.Unlock()
+
+	if !synced {
+		return fmt.Errorf("the initial sync of the data gatherers has not completed")
+	}
+	// the period is only known once the first cycle completed
+	if maxPeriods > 0 && period > 0 {
+		if since := now.Sub(lastUpload); since > time.Duration(maxPeriods)*period {
+			return fmt.Errorf("no successful upload for %s", since.Round(time.Second))
+		}
+	}
+	return t.Healthy()
+}
+
+// Live returns an error if the loop of the agent is stuck at now, i.e. no
+// cycle completed for maxCyclePeriods periods plus the time the uploads are
+// retried for.
+func (t *healthTracker) Live(now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lastCycle.IsZero() {
+		// the agent only fails to boot by exiting
+		return nil
+	}
+	if since := now.Sub(t.lastCycle); since > time.Duration(t.maxCyclePeriods)*t.period+BackoffMaxTime {
+		return fmt.Errorf("no cycle completed for %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+// checkOneShot returns an error listing the data gatherers that failed their
+// last fetch, and the data gatherers expected to return resources that
+// returned none.
+func (t *healthTracker) checkOneShot(expectItems map[string]bool) error {
+	var problems []string
+	for name, h := range t.snapshot() {
+		switch {
+		case h.ConsecutiveErrors > 0:
+			problems = append(problems, fmt.Sprintf("%s: %s", name, h.LastError))
+		case h.LastSuccess == nil:
+			problems = append(problems, fmt.Sprintf("%s: not fetched", name))
+		case expectItems[name] && h.Items == 0:
+			problems = append(problems, fmt.Sprintf("%s: no resources returned", name))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("%d data gatherer(s) failed: %s", len(problems), strings.Join(problems, ", "))
+}
+
+// healthResponse is the body of the readiness endpoint.
+type healthResponse struct {
+	Ready         bool                               `json:"ready"`
+	Error         string                             `json:"error,omitempty"`
+	LastUpload    *api.Time                          `json:"last_upload,omitempty"`
+	DataGatherers map[string]*api.DataGathererHealth `json:"data_gatherers"`
+}
+
+// handler returns the handler of the liveness and readiness endpoints.
+func (t *healthTracker) handler() http.Handler {
+	mux := http.NewServeMux()
+	t.register(mux)
+	return mux
+}
+
+// register adds the liveness and readiness endpoints to the mux. /livez, and
+// /healthz as an alias, succeed as long as the loop of the agent completes
+// its cycles. /readyz only succeeds once the initial sync completed, the
+// uploads succeed and all the data gatherers are healthy, and reports their
+// health.
+func (t *healthTracker) register(mux *http.ServeMux) {
+	live := func(w http.ResponseWriter, r *http.Request) {
+		if err := t.Live(time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	}
+	mux.HandleFunc("/healthz", live)
+	mux.HandleFunc("/livez", live)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		response := healthResponse{
+			Ready:         true,
+			DataGatherers: t.snapshot(),
+		}
+		if err := t.Ready(time.Now()); err != nil {
+			response.Ready = false
+			response.Error = err.Error()
+		}
+		t.mu.Lock()
+		if !t.lastUpload.IsZero() {
+			response.LastUpload = &api.Time{Time: t.lastUpload}
+		}
+		t.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !response.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logs.Log.Errorf("failed to write readiness response: %v", err)
+		}
+	})
+}
+
+// serveEndpoints serves the health endpoints on HealthAddress, the metrics
+// on MetricsAddress and the snapshot on its address in the background, if
+// they are set. They share a listener when their addresses are the same. It
+// returns the function shutting the servers down.
+func serveEndpoints(t *healthTracker, snapshot *readingsSnapshot) func(ctx context.Context) {
+	muxes := map[string]*http.ServeMux{}
+	muxFor := func(address string) *http.ServeMux {
+		if _, ok := muxes[address]; !ok {
+			muxes[address] = http.NewServeMux()
+		}
+		return muxes[address]
+	}
+	if HealthAddress != "" {
+		t.register(muxFor(HealthAddress))
+	}
+	if MetricsAddress != "" {
+		muxFor(MetricsAddress).Handle("/metrics", metrics.Handler())
+	}
+	if snapshot != nil {
+		snapshot.register(muxFor(snapshot.address))
+	}
+
+	var servers []*http.Server
+	for address, mux := range muxes {
+		server := &http.Server{
+			Addr:    address,
+			Handler: mux,
+		}
+		servers = append(servers, server)
+		go func() {
+			logs.Log.Infof("serving endpoints on %s", server.Addr)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logs.Log.Fatalf("failed to serve endpoints on %s: %v", server.Addr, err)
+			}
+		}()
+	}
+
+	return func(ctx context.Context) {
+		for _, server := range servers {
+			if err := server.Shutdown(ctx); err != nil {
+				logs.Log.Warnf("failed to shut down the endpoints on %s: %v", server.Addr, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthTracker(t *testing.T) {
+	health := newHealthTracker([]string{"a", "b"})
+	if err := health.Healthy(); err == nil {
+		t.Fatalf("expected the data gatherers not fetched yet to be unhealthy")
+	}
+
+	health.success("a", 3, "")
+	health.failure("b", fmt.Errorf("boom"))
+	health.failure("b", fmt.Errorf("boom again"))
+	snapshot := health.snapshot()
+	if h := snapshot["a"]; h.Items != 3 || h.LastSuccess == nil || h.Healthy(defaultMaxConsecutiveErrors) != nil {
+		t.Errorf("unexpected health of a: %+v", h)
+	}
+	if h := snapshot["b"]; h.Errors != 2 || h.ConsecutiveErrors != 2 || h.LastError != "boom again" || h.Healthy(defaultMaxConsecutiveErrors) == nil {
+		t.Errorf("unexpected health of b: %+v", h)
+	}
+
+	reported := health.success("b", 1, "pods: not synced")
+	if reported.Errors != 2 || reported.ConsecutiveErrors != 0 || reported.Degraded != "pods: not synced" {
+		t.Errorf("unexpected health of b: %+v", reported)
+	}
+	if err := health.Healthy(); err == nil || err.Error() != "b: degraded: pods: not synced" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	health.success("b", 1, "")
+	if err := health.Healthy(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// a data gatherer fetched successfully before stays healthy until
+	// max-consecutive-errors fetches in a row failed
+	health.configure(&Health{MaxConsecutiveErrors: 2}, false)
+	health.failure("b", fmt.Errorf("transient"))
+	if err := health.Healthy(); err != nil {
+		t.Errorf("expected a single failed fetch to be tolerated, got %v", err)
+	}
+	health.failure("b", fmt.Errorf("boom"))
+	if err := health.Healthy(); err == nil || err.Error() != "b: 2 consecutive error(s): boom" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	var nilTracker *healthTracker
+	if h := nilTracker.success("a", 1, ""); h != nil {
+		t.Errorf("expected a nil tracker to record nothing")
+	}
+	nilTracker.failure("a", fmt.Errorf("boom"))
+}
+
+func TestCheckOneShot(t *testing.T) {
+	health := newHealthTracker([]string{"pods", "secrets", "discovery", "nodes"})
+	health.success("pods", 0, "")
+	health.success("secrets", 0, "")
+	health.success("discovery", 0, "")
+	health.failure("nodes", fmt.Errorf("forbidden"))
+
+	err := health.checkOneShot(map[string]bool{"secrets": true})
+	expected := "2 data gatherer(s) failed: nodes: forbidden, secrets: no resources returned"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+
+	health.success("nodes", 1, "")
+	health.success("secrets", 1, "")
+	if err := health.checkOneShot(map[string]bool{"secrets": true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHealthReadyAndLive(t *testing.T) {
+	health := newHealthTracker([]string{"a"})
+	health.configure(&Health{MaxUploadPeriods: 2}, true)
+	health.success("a", 1, "")
+	start := health.started
+
+	if err := health.Ready(start); err == nil || err.Error() != "the initial sync of the data gatherers has not completed" {
+		t.Errorf("expected the agent not to be ready before the initial sync, got %v", err)
+	}
+	health.setSynced()
+	if err := health.Ready(start.Add(time.Hour)); err != nil {
+		t.Errorf("expected the agent to be ready before the first cycle, got %v", err)
+	}
+	if err := health.Live(start.Add(time.Hour)); err != nil {
+		t.Errorf("expected the agent to be live before the first cycle, got %v", err)
+	}
+
+	health.cycled(start, time.Minute)
+	if err := health.Ready(start.Add(2 * time.Minute)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := health.Ready(start.Add(3 * time.Minute)); err == nil || err.Error() != "no successful upload for 3m0s" {
+		t.Errorf("expected the agent not to be ready without uploads, got %v", err)
+	}
+	health.uploaded(start.Add(3 * time.Minute))
+	if err := health.Ready(start.Add(4 * time.Minute)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	backoffMaxTime := BackoffMaxTime
+	defer func() { BackoffMaxTime = backoffMaxTime }()
+	BackoffMaxTime = time.Minute
+	if err := health.Live(start.Add(3 * time.Minute)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := health.Live(start.Add(4 * time.Minute)); err == nil || err.Error() != "no cycle completed for 4m0s" {
+		t.Errorf("expected the agent not to be live once its cycles are late, got %v", err)
+	}
+
+	// the uploads are not checked when the readings are not uploaded
+	health.configure(nil, false)
+	if err := health.Ready(start.Add(time.Hour)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	health := newHealthTracker([]string{"a"})
+	health.setSynced()
+	server := httptest.NewServer(health.handler())
+	defer server.Close()
+
+	get := func(path string) (int, healthResponse) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		var body healthResponse
+		if path == "/readyz" {
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return resp.StatusCode, body
+	}
+
+	for _, path := range []string{"/healthz", "/livez"} {
+		if code, _ := get(path); code != http.StatusOK {
+			t.Errorf("expected %s to succeed, got %d", path, code)
+		}
+	}
+
+	code, body := get("/readyz")
+	if code != http.StatusServiceUnavailable || body.Ready || body.Error != "a: not fetched yet" {
+		t.Errorf("expected /readyz to fail before the first fetch, got %d %+v", code, body)
+	}
+
+	health.success("a", 2, "")
+	code, body = get("/readyz")
+	if code != http.StatusOK || !body.Ready || body.DataGatherers["a"].Items != 2 {
+		t.Errorf("expected /readyz to succeed, got %d %+v", code, body)
+	}
+}
@@ -0,0 +1,230 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/logs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// defaultIdentityRefreshPeriod is how often the cluster identity is derived
+// again, as the node count and the Kubernetes version change over time.
+const defaultIdentityRefreshPeriod = time.Hour
+
+var (
+	namespacesGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	nodesGVR      = schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+)
+
+// regionLabels are the node labels of the region, the deprecated one last.
+var regionLabels = []string{
+	"topology.kubernetes.io/region",
+	"failure-domain.beta.kubernetes.io/region",
+}
+
+// providerIDSchemes maps the schemes of the provider IDs of the nodes to
+// the name of their provider.
+var providerIDSchemes = map[string]string{
+	"aws":          "aws",
+	"gce":          "gcp",
+	"azure":        "azure",
+	"openstack":    "openstack",
+	"vsphere":      "vsphere",
+	"digitalocean": "digitalocean",
+	"ibm":          "ibm",
+	"kind":         "kind",
+}
+
+// ClusterIdentity derives the identity of the cluster the agent runs in and
+// attaches it to the metadata of every upload. The ID of the cluster defaults
+// to the UID of the kube-system namespace, which does not change when the
+// agent is reinstalled.
+type ClusterIdentity struct {
+	// KubeConfigPath is the kubeconfig of the cluster, the in-cluster
+	// configuration is used if it is empty.
+	KubeConfigPath string `yaml:"kubeconfig,omitempty"`
+	// Provider and Region override the provider and region derived from
+	// the nodes.
+	Provider string `yaml:"provider,omitempty"`
+	Region   string `yaml:"region,omitempty"`
+	// RefreshPeriod is how often the identity is derived again. Defaults to
+	// 1h.
+	RefreshPeriod time.Duration `yaml:"refresh-period,omitempty"`
+}
+
+func (c *ClusterIdentity) validate() error {
+	if c.RefreshPeriod < 0 {
+		return fmt.Errorf("cluster-identity.refresh-period cannot be negative")
+	}
+	return nil
+}
+
+// clusterIdentity keeps the identity of the cluster of the metadata of the
+// clients up to date. A nil clusterIdentity does nothing.
+type clusterIdentity struct {
+	cfg           *ClusterIdentity
+	client        dynamic.Interface
+	serverVersion func() (string, error)
+	refreshPeriod time.Duration
+
+	mu        sync.Mutex
+	current   *api.ClusterIdentity
+	refreshed time.Time
+	metadata  []*api.AgentMetadata
+}
+
+// newClusterIdentity derives the identity of the cluster of the
+// configuration.
+func newClusterIdentity(ctx context.Context, cfg *ClusterIdentity) (*clusterIdentity, error) {
+	cl, err := k8s.NewDynamicClient(cfg.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := k8s.NewDiscoveryClient(cfg.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	c := &clusterIdentity{
+		cfg:    cfg,
+		client: cl,
+		serverVersion: func() (string, error) {
+			info, err := discoveryClient.ServerVersion()
+			if err != nil {
+				return "", err
+			}
+			return info.GitVersion, nil
+		},
+		refreshPeriod: cfg.RefreshPeriod,
+	}
+	if c.refreshPeriod == 0 {
+		c.refreshPeriod = defaultIdentityRefreshPeriod
+	}
+	if err := c.refresh(ctx, time.Now()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// get returns the current identity.
+func (c *clusterIdentity) get() *api.ClusterIdentity {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
+// attach sets the identity on the metadata, and again whenever it is
+// refreshed.
+func (c *clusterIdentity) attach(metadata *api.AgentMetadata) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metadata.Cluster = c.current
+	c.metadata = append(c.metadata, metadata)
+}
+
+// refreshIfDue derives the identity again once the refresh period has
+// passed. The previous identity is kept if it cannot be derived.
+func (c *clusterIdentity) refreshIfDue(ctx context.Context, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	due := now.Sub(c.refreshed) >= c.refreshPeriod
+	c.mu.Unlock()
+	if !due {
+		return
+	}
+	if err := c.refresh(ctx, now); err != nil {
+		logs.Log.Warnf("failed to refresh the cluster identity, using the previous one: %v", err)
+	}
+}
+
+// refresh derives the identity and sets it on the attached metadata.
+func (c *clusterIdentity) refresh(ctx context.Context, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	identity, err := c.derive(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshed = now
+	if err != nil {
+		return err
+	}
+	// the metadata is only read by the uploads of the cycles, which do not
+	// run concurrently with the refresh
+	c.current = identity
+	for _, m := range c.metadata {
+		m.Cluster = identity
+	}
+	return nil
+}
+
+// derive reads the identity from the cluster.
+func (c *clusterIdentity) derive(ctx context.Context) (*api.ClusterIdentity, error) {
+	namespace, err := c.client.Resource(namespacesGVR).Get(ctx, "kube-system", metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the kube-system namespace: %v", err)
+	}
+	identity := &api.ClusterIdentity{
+		UID:      string(namespace.GetUID()),
+		Provider: c.cfg.Provider,
+		Region:   c.cfg.Region,
+	}
+
+	// a single node is listed, the count comes from the remaining items
+	nodes, err := c.client.Resource(nodesGVR).List(ctx, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the nodes: %v", err)
+	}
+	identity.NodeCount = int64(len(nodes.Items))
+	if remaining := nodes.GetRemainingItemCount(); remaining != nil {
+		identity.NodeCount += *remaining
+	}
+	if len(nodes.Items) > 0 {
+		node := nodes.Items[0]
+		if identity.Provider == "" {
+			providerID, _, _ := unstructured.NestedString(node.Object, "spec", "providerID")
+			identity.Provider = providerFromID(providerID)
+		}
+		if identity.Region == "" {
+			labels := node.GetLabels()
+			for _, label := range regionLabels {
+				if region := labels[label]; region != "" {
+					identity.Region = region
+					break
+				}
+			}
+		}
+	}
+
+	if identity.KubernetesVersion, err = c.serverVersion(); err != nil {
+		return nil, fmt.Errorf("failed to read the Kubernetes version: %v", err)
+	}
+	return identity, nil
+}
+
+// providerFromID returns the provider of a node provider ID, e.g.
+// aws:///eu-west-1a/i-0123, or an empty string if it is unknown.
+func providerFromID(providerID string) string {
+	i := strings.Index(providerID, "://")
+	if i < 0 {
+		return ""
+	}
+	return providerIDSchemes[providerID[:i]]
+}
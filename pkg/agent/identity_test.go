@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func node(name, providerID, region string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Node",
+		"metadata": map[string]interface{}{
+			"name":   name,
+			"labels": map[string]interface{}{"topology.kubernetes.io/region": region},
+		},
+		"spec": map[string]interface{}{"providerID": providerID},
+	}}
+}
+
+func TestClusterIdentity(t *testing.T) {
+	namespace := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": "kube-system", "uid": "0c5d6a3e-uid"},
+	}}
+	cl := fake.NewSimpleDynamicClient(runtime.NewScheme(), namespace,
+		node("node-1", "aws:///eu-west-1a/i-0123", "eu-west-1"),
+		node("node-2", "aws:///eu-west-1b/i-0456", "eu-west-1"))
+
+	version := "v1.20.1"
+	c := &clusterIdentity{
+		cfg:           &ClusterIdentity{},
+		client:        cl,
+		serverVersion: func() (string, error) { return version, nil },
+		refreshPeriod: time.Hour,
+	}
+	now := time.Now()
+	if err := c.refresh(context.Background(), now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := api.ClusterIdentity{
+		UID:               "0c5d6a3e-uid",
+		Provider:          "aws",
+		Region:            "eu-west-1",
+		KubernetesVersion: "v1.20.1",
+		NodeCount:         2,
+	}
+	if got := c.get(); got == nil || *got != expected {
+		t.Fatalf("unexpected identity: got=%+v want=%+v", got, expected)
+	}
+
+	metadata := &api.AgentMetadata{ClusterID: "cluster"}
+	c.attach(metadata)
+	if metadata.Cluster == nil || metadata.Cluster.UID != expected.UID {
+		t.Fatalf("expected the identity to be attached, got %+v", metadata.Cluster)
+	}
+
+	// the identity is refreshed once the period has passed
+	version = "v1.21.0"
+	c.refreshIfDue(context.Background(), now.Add(time.Minute))
+	if metadata.Cluster.KubernetesVersion != "v1.20.1" {
+		t.Errorf("expected the identity to be refreshed after the period only")
+	}
+	c.refreshIfDue(context.Background(), now.Add(2*time.Hour))
+	if metadata.Cluster.KubernetesVersion != "v1.21.0" {
+		t.Errorf("expected the refreshed identity to be attached, got %q", metadata.Cluster.KubernetesVersion)
+	}
+
+	// the previous identity is kept when it cannot be derived
+	c.serverVersion = func() (string, error) { return "", fmt.Errorf("unreachable") }
+	c.refreshIfDue(context.Background(), now.Add(4*time.Hour))
+	if metadata.Cluster == nil || metadata.Cluster.KubernetesVersion != "v1.21.0" {
+		t.Errorf("expected the previous identity to be kept, got %+v", metadata.Cluster)
+	}
+
+	if err := cl.Resource(namespacesGVR).Delete(context.Background(), "kube-system", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.refresh(context.Background(), now); err == nil {
+		t.Errorf("expected error without the kube-system namespace")
+	}
+}
+
+func TestProviderFromID(t *testing.T) {
+	for providerID, expected := range map[string]string{
+		"aws:///eu-west-1a/i-0123":                       "aws",
+		"gce://project/europe-west1-b/gke-node":          "gcp",
+		"azure:///subscriptions/id/resourceGroups/nodes": "azure",
+		"kind://docker/kind/kind-control-plane":          "kind",
+		"unknown://node":                                 "",
+		"":                                               "",
+	} {
+		if got := providerFromID(providerID); got != expected {
+			t.Errorf("unexpected provider of %q: got=%q want=%q", providerID, got, expected)
+		}
+	}
+}
+
+func TestValidateClusterIdentity(t *testing.T) {
+	if err := (&ClusterIdentity{RefreshPeriod: -time.Minute}).validate(); err == nil {
+		t.Errorf("expected error for negative refresh-period")
+	}
+}
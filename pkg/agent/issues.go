@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	dgerror "github.com/jetstack/preflight/pkg/datagatherer/error"
+)
+
+// gathererIssues returns the issues of the data gatherer, or its degradation
+// if it does not detail its issues.
+func gathererIssues(dg datagatherer.DataGatherer) []*api.DataReadingIssue {
+	if reporter, ok := dg.(datagatherer.IssueReporter); ok {
+		return reporter.Issues()
+	}
+	if err := dg.Healthy(); err != nil {
+		return []*api.DataReadingIssue{{
+			Severity: api.IssueSeverityWarning,
+			Code:     api.IssueDegraded,
+			Message:  err.Error(),
+		}}
+	}
+	return nil
+}
+
+// fetchIssue describes the error of a failed fetch.
+func fetchIssue(err error) *api.DataReadingIssue {
+	var code string
+	switch err.(type) {
+	case *fetchTimeoutError:
+		code = api.IssueTimeout
+	case *dgerror.ConfigError:
+		code = api.IssueConfig
+	default:
+		code = datagatherer.IssueCode(err, api.IssueFailed)
+	}
+	return &api.DataReadingIssue{
+		Severity: api.IssueSeverityError,
+		Code:     code,
+		Message:  err.Error(),
+	}
+}
+
+// failedReading is the reading of a data gatherer whose fetch failed, so
+// that the backend is told why its data is missing rather than left to guess.
+func failedReading(config Config, name, kind string, dg datagatherer.DataGatherer, err error, health *api.DataGathererHealth) *api.DataReading {
+	readingName, clusterID := readingIdentity(config, name)
+	reading := &api.DataReading{
+		ClusterID:     clusterID,
+		DataGatherer:  readingName,
+		Timestamp:     api.Time{Time: time.Now()},
+		SchemaVersion: schemaVersion,
+		Health:        health,
+		Error:         err.Error(),
+		// the issues of the data gatherer may explain the failure, e.g. a
+		// resource type it is not allowed to watch
+		Issues: append([]*api.DataReadingIssue{fetchIssue(err)}, gathererIssues(dg)...),
+	}
+	stampDataVersion(reading, kind)
+	return reading
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	dgerror "github.com/jetstack/preflight/pkg/datagatherer/error"
+)
+
+type degradedDataGatherer struct {
+	dummyDataGatherer
+	degraded error
+}
+
+func (g *degradedDataGatherer) Healthy() error {
+	return g.degraded
+}
+
+type issuesDataGatherer struct {
+	degradedDataGatherer
+	issues []*api.DataReadingIssue
+}
+
+func (g *issuesDataGatherer) Issues() []*api.DataReadingIssue {
+	return g.issues
+}
+
+func TestGathererIssues(t *testing.T) {
+	notSynced := &api.DataReadingIssue{Severity: api.IssueSeverityWarning, Code: api.IssueNotSynced, ResourceType: "pods.v1", Message: "not synced"}
+	tests := map[string]struct {
+		dg       datagatherer.DataGatherer
+		expected []*api.DataReadingIssue
+	}{
+		"healthy": {dg: &dummyDataGatherer{}},
+		"degraded": {
+			dg:       &degradedDataGatherer{degraded: fmt.Errorf("pods.v1: not synced")},
+			expected: []*api.DataReadingIssue{{Severity: api.IssueSeverityWarning, Code: api.IssueDegraded, Message: "pods.v1: not synced"}},
+		},
+		"detailed": {
+			dg:       &issuesDataGatherer{degradedDataGatherer: degradedDataGatherer{degraded: fmt.Errorf("pods.v1: not synced")}, issues: []*api.DataReadingIssue{notSynced}},
+			expected: []*api.DataReadingIssue{notSynced},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff, equal := messagediff.PrettyDiff(test.expected, gathererIssues(test.dg)); !equal {
+				t.Errorf("unexpected issues:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFailedReading(t *testing.T) {
+	config := Config{ClusterID: "cluster", DataGatherers: []DataGatherer{{Name: "k8s/pods", Kind: "k8s-dynamic"}}}
+	dg := &degradedDataGatherer{degraded: fmt.Errorf("pods.v1: not synced")}
+
+	tests := map[string]struct {
+		err  error
+		code string
+	}{
+		"timeout": {err: &fetchTimeoutError{timeout: time.Minute}, code: api.IssueTimeout},
+		"config":  {err: &dgerror.ConfigError{Err: "the CRD is not installed"}, code: api.IssueConfig},
+		"other":   {err: fmt.Errorf("connection refused"), code: api.IssueFailed},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			reading := failedReading(config, "k8s/pods", "k8s-dynamic", dg, test.err, nil)
+			if reading.DataGatherer != "k8s/pods" || reading.ClusterID != "cluster" || reading.Data != nil || reading.Error != test.err.Error() {
+				t.Errorf("unexpected reading: %+v", reading)
+			}
+			expected := []*api.DataReadingIssue{
+				{Severity: api.IssueSeverityError, Code: test.code, Message: test.err.Error()},
+				{Severity: api.IssueSeverityWarning, Code: api.IssueDegraded, Message: "pods.v1: not synced"},
+			}
+			if diff, equal := messagediff.PrettyDiff(expected, reading.Issues); !equal {
+				t.Errorf("unexpected issues:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/metrics"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// MemoryBudget bounds the memory used by the caches of the data gatherers.
+// The agent acts on the caches exceeding the budget, rather than letting its
+// Pod run out of memory.
+type MemoryBudget struct {
+	// MaxCacheSize is the budget of the caches of all the data gatherers,
+	// e.g. 512Mi. It should be well below the memory limit of the Pod, as
+	// the informers hold a copy of the resources too.
+	MaxCacheSize string `yaml:"max-cache-size"`
+	// MetadataOnly switches the k8s-dynamic data gatherers with the largest
+	// caches to metadata only mode, one per cycle, while the caches exceed
+	// the budget.
+	MetadataOnly bool `yaml:"metadata-only"`
+	// MinPeriod, if set, halves the period on every cycle the caches exceed
+	// the budget, down to MinPeriod, so that the incremental data gatherers
+	// upload smaller deltas more often.
+	MinPeriod time.Duration `yaml:"min-period"`
+}
+
+func (m *MemoryBudget) validate() error {
+	if m.MaxCacheSize == "" {
+		return fmt.Errorf("memory-budget.max-cache-size is required")
+	}
+	if _, err := resource.ParseQuantity(m.MaxCacheSize); err != nil {
+		return fmt.Errorf("memory-budget.max-cache-size is invalid: %v", err)
+	}
+	if m.MinPeriod < 0 {
+		return fmt.Errorf("memory-budget.min-period cannot be negative")
+	}
+	return nil
+}
+
+// cacheSizes returns the approximate size of the cache of each data gatherer
+// reporting it, and records them in the metrics.
+func cacheSizes(dataGatherers map[string]datagatherer.DataGatherer) map[string]int64 {
+	sizes := map[string]int64{}
+	for name, dg := range dataGatherers {
+		if reporter, ok := dg.(datagatherer.CacheSizeReporter); ok {
+			sizes[name] = reporter.CacheBytes()
+		}
+	}
+	metrics.ObserveCacheSizes(sizes)
+	return sizes
+}
+
+// memoryBudget enforces the memory budget of the running agent. It is safe to
+// use a nil memoryBudget, which enforces nothing.
+type memoryBudget struct {
+	maxBytes     int64
+	metadataOnly bool
+	minPeriod    time.Duration
+	// reduced are the configurations of the data gatherers switched to
+	// metadata only mode. A data gatherer whose configuration is reloaded
+	// with changes is restarted as configured, and can be switched again.
+	reduced map[string]*k8s.ConfigDynamic
+	// period is the shortened period, zero while within the budget.
+	period time.Duration
+}
+
+func newMemoryBudget(config *MemoryBudget) *memoryBudget {
+	if config == nil {
+		return nil
+	}
+	// the size has already been validated when parsing the config
+	maxSize, _ := resource.ParseQuantity(config.MaxCacheSize)
+	return &memoryBudget{
+		maxBytes:     maxSize.Value(),
+		metadataOnly: config.MetadataOnly,
+		minPeriod:    config.MinPeriod,
+		reduced:      map[string]*k8s.ConfigDynamic{},
+	}
+}
+
+// enforce checks the sizes of the caches against the budget and acts on them
+// if they exceed it. It returns the period of the next cycle, or zero to keep
+// the configured period.
+func (b *memoryBudget) enforce(sizes map[string]int64, dgConfigs []DataGatherer, running *runningGatherers, period time.Duration) time.Duration {
+	if b == nil {
+		return 0
+	}
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	if total <= b.maxBytes {
+		if b.period > 0 {
+			logs.Log.Infof("The caches are back within the memory budget, using the period %s", period)
+		}
+		b.period = 0
+		return 0
+	}
+	logs.Log.Warnf("The caches use about %d bytes, exceeding the memory budget of %d bytes", total, b.maxBytes)
+
+	if b.metadataOnly {
+		if dgConfig, ok := b.heaviestFullGatherer(sizes, dgConfigs); ok {
+			b.switchToMetadataOnly(dgConfig, running)
+		}
+	}
+
+	if b.minPeriod > 0 && (b.period == 0 || b.period > b.minPeriod) {
+		if b.period == 0 {
+			b.period = period
+		}
+		b.period /= 2
+		if b.period < b.minPeriod {
+			b.period = b.minPeriod
+		}
+		metrics.MemoryBudgetActions.WithLabelValues("shorter-period").Inc()
+		logs.Log.Warnf("Shortened the period to %s to upload smaller deltas", b.period)
+	}
+	return b.period
+}
+
+// heaviestFullGatherer returns the k8s-dynamic data gatherer with the largest
+// cache among the ones not in metadata only mode yet.
+func (b *memoryBudget) heaviestFullGatherer(sizes map[string]int64, dgConfigs []DataGatherer) (DataGatherer, bool) {
+	var heaviest DataGatherer
+	var heaviestSize int64 = -1
+	for _, dgConfig := range dgConfigs {
+		dynamicConfig, ok := dgConfig.Config.(*k8s.ConfigDynamic)
+		if !ok || dynamicConfig.MetadataOnly {
+			continue
+		}
+		if reduced, ok := b.reduced[dgConfig.Name]; ok && reflect.DeepEqual(reduced, dynamicConfig) {
+			continue
+		}
+		if size, ok := sizes[dgConfig.Name]; ok && size > heaviestSize {
+			heaviest, heaviestSize = dgConfig, size
+		}
+	}
+	return heaviest, heaviestSize >= 0
+}
+
+// switchToMetadataOnly restarts the data gatherer in metadata only mode. The
+// configuration of the agent is left as is, so that the data gatherer is
+// restarted as configured if its configuration is reloaded.
+func (b *memoryBudget) switchToMetadataOnly(dgConfig DataGatherer, running *runningGatherers) {
+	log := logs.Log.WithField(logs.DataGathererField, dgConfig.Name)
+	configured := dgConfig.Config.(*k8s.ConfigDynamic)
+	dynamicConfig := *configured
+	dynamicConfig.MetadataOnly = true
+	dgConfig.Config = &dynamicConfig
+	if err := running.replace(dgConfig); err != nil {
+		log.Errorf("failed to switch data gatherer %q to metadata only mode: %v", dgConfig.Name, err)
+		return
+	}
+	b.reduced[dgConfig.Name] = configured
+	metrics.MemoryBudgetActions.WithLabelValues("metadata-only").Inc()
+	log.Warnf("Switched data gatherer %q to metadata only mode to reduce its cache", dgConfig.Name)
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type sizedDataGatherer struct {
+	dummyDataGatherer
+	size int64
+}
+
+func (g *sizedDataGatherer) CacheBytes() int64 {
+	return g.size
+}
+
+func TestCacheSizes(t *testing.T) {
+	sizes := cacheSizes(map[string]datagatherer.DataGatherer{
+		"pods":    &sizedDataGatherer{size: 100},
+		"secrets": &sizedDataGatherer{size: 50},
+		"dummy":   &dummyDataGatherer{},
+	})
+	if len(sizes) != 2 || sizes["pods"] != 100 || sizes["secrets"] != 50 {
+		t.Errorf("unexpected sizes: %v", sizes)
+	}
+}
+
+func TestMemoryBudgetValidate(t *testing.T) {
+	tests := map[string]struct {
+		budget  MemoryBudget
+		wantErr bool
+	}{
+		"valid":             {budget: MemoryBudget{MaxCacheSize: "512Mi", MetadataOnly: true}},
+		"missing size":      {budget: MemoryBudget{MetadataOnly: true}, wantErr: true},
+		"invalid size":      {budget: MemoryBudget{MaxCacheSize: "lots"}, wantErr: true},
+		"negative period":   {budget: MemoryBudget{MaxCacheSize: "1Gi", MinPeriod: -time.Minute}, wantErr: true},
+		"accounting period": {budget: MemoryBudget{MaxCacheSize: "1Gi", MinPeriod: time.Minute}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.budget.validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error: %v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMemoryBudgetShortensPeriod(t *testing.T) {
+	budget := newMemoryBudget(&MemoryBudget{MaxCacheSize: "1Ki", MinPeriod: time.Minute})
+	over := map[string]int64{"pods": 1000, "secrets": 1000}
+
+	for _, expected := range []time.Duration{2 * time.Minute, time.Minute, time.Minute} {
+		if period := budget.enforce(over, nil, nil, 4*time.Minute); period != expected {
+			t.Errorf("expected the period %s, got %s", expected, period)
+		}
+	}
+	if period := budget.enforce(map[string]int64{"pods": 1000}, nil, nil, 4*time.Minute); period != 0 {
+		t.Errorf("expected the configured period within the budget, got %s", period)
+	}
+
+	var nilBudget *memoryBudget
+	if period := nilBudget.enforce(over, nil, nil, time.Minute); period != 0 {
+		t.Errorf("expected a nil budget to enforce nothing, got %s", period)
+	}
+}
+
+func TestHeaviestFullGatherer(t *testing.T) {
+	pods := &k8s.ConfigDynamic{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "pods"}}
+	secrets := &k8s.ConfigDynamic{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "secrets"}}
+	nodes := &k8s.ConfigDynamic{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, MetadataOnly: true}
+	dgConfigs := []DataGatherer{
+		{Name: "pods", Kind: "k8s-dynamic", Config: pods},
+		{Name: "secrets", Kind: "k8s-dynamic", Config: secrets},
+		{Name: "nodes", Kind: "k8s-dynamic", Config: nodes},
+		{Name: "dummy", Kind: "dummy", Config: &dummyConfig{}},
+	}
+	sizes := map[string]int64{"pods": 100, "secrets": 300, "nodes": 500, "dummy": 1000}
+
+	budget := newMemoryBudget(&MemoryBudget{MaxCacheSize: "1Ki", MetadataOnly: true})
+	if dg, ok := budget.heaviestFullGatherer(sizes, dgConfigs); !ok || dg.Name != "secrets" {
+		t.Errorf("expected secrets to be switched first, got %q", dg.Name)
+	}
+
+	budget.reduced["secrets"] = secrets
+	if dg, ok := budget.heaviestFullGatherer(sizes, dgConfigs); !ok || dg.Name != "pods" {
+		t.Errorf("expected pods to be switched next, got %q", dg.Name)
+	}
+
+	// a data gatherer reloaded with changes is restarted as configured
+	changed := *secrets
+	changed.IncludeNamespaces = []string{"default"}
+	dgConfigs[1].Config = &changed
+	if dg, ok := budget.heaviestFullGatherer(sizes, dgConfigs); !ok || dg.Name != "secrets" {
+		t.Errorf("expected the reloaded secrets to be switched again, got %q", dg.Name)
+	}
+
+	budget.reduced["pods"] = pods
+	budget.reduced["secrets"] = &changed
+	if dg, ok := budget.heaviestFullGatherer(sizes, dgConfigs); ok {
+		t.Errorf("expected no data gatherer left to switch, got %q", dg.Name)
+	}
+}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/logs"
+)
+
+// defaultDataVersion is the version of the schema of the data of the kinds of
+// data gatherers not in dataVersions, and of the readings without a version.
+const defaultDataVersion = "v1"
+
+// dataVersions are the versions of the schema of the data of the kinds of
+// data gatherers whose data changed since the data was versioned. A kind
+// must have the migrations from its previous versions in dataMigrations, so
+// that the readings spooled by a previous version of the agent are
+// up-converted before they are uploaded.
+var dataVersions = map[string]string{}
+
+// dataMigrations are the migrations of the data of each kind of data
+// gatherer, from each version of its schema to the next.
+var dataMigrations = map[string][]dataMigration{}
+
+// dataMigration up-converts the data of a kind of data gatherer from a
+// version of its schema to the next one. The data is decoded from JSON.
+type dataMigration struct {
+	from, to string
+	migrate  func(data interface{}) (interface{}, error)
+}
+
+// dataVersion returns the current version of the schema of the data of the
+// kind of data gatherer.
+func dataVersion(kind string) string {
+	if version, ok := dataVersions[kind]; ok {
+		return version
+	}
+	return defaultDataVersion
+}
+
+// stampDataVersion sets the kind of the data gatherer of the reading, and the
+// current version of the schema of its data.
+func stampDataVersion(reading *api.DataReading, kind string) {
+	reading.DataGathererKind = kind
+	reading.DataVersion = dataVersion(kind)
+}
+
+// migrateReadings up-converts the data of the readings written by a previous
+// version of the agent, e.g. spooled, to the current version of the schema
+// of their kind. The readings which cannot be migrated are left as they are,
+// with the version of their data, so that the backend can still parse them.
+func migrateReadings(readings []*api.DataReading) {
+	for _, reading := range readings {
+		if err := migrateReading(reading, dataVersions, dataMigrations); err != nil {
+			logs.Log.Warnf("Sending the data of %q as version %s: %v", reading.DataGatherer, reading.DataVersion, err)
+		}
+	}
+}
+
+func migrateReading(reading *api.DataReading, versions map[string]string, migrations map[string][]dataMigration) error {
+	// the readings of the agents predating the versioning have no kind, and
+	// the first version of the data
+	if reading.DataGathererKind == "" {
+		return nil
+	}
+	current := defaultDataVersion
+	if version, ok := versions[reading.DataGathererKind]; ok {
+		current = version
+	}
+	version := reading.DataVersion
+	if version == "" {
+		version = defaultDataVersion
+	}
+
+	data := reading.Data
+	for steps := 0; version != current; steps++ {
+		migration, ok := findMigration(migrations[reading.DataGathererKind], version)
+		// a newer version, e.g. spooled before a downgrade, has no migration
+		if !ok || steps == len(migrations[reading.DataGathererKind]) {
+			return fmt.Errorf("no migration of the %s data from version %s to %s", reading.DataGathererKind, version, current)
+		}
+		migrated, err := migration.migrate(data)
+		if err != nil {
+			return fmt.Errorf("failed to migrate the %s data from version %s to %s: %v", reading.DataGathererKind, migration.from, migration.to, err)
+		}
+		data, version = migrated, migration.to
+	}
+	// the reading is only modified once all the migrations succeeded
+	reading.Data, reading.DataVersion = data, version
+	return nil
+}
+
+func findMigration(migrations []dataMigration, from string) (dataMigration, bool) {
+	for _, m := range migrations {
+		if m.from == from {
+			return m, true
+		}
+	}
+	return dataMigration{}, false
+}
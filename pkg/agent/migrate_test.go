@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestMigrateReading(t *testing.T) {
+	versions := map[string]string{"k8s-nodes": "v3"}
+	migrations := map[string][]dataMigration{
+		"k8s-nodes": {
+			{from: "v2", to: "v3", migrate: func(data interface{}) (interface{}, error) {
+				m := data.(map[string]interface{})
+				return map[string]interface{}{"nodes": m["items"], "migrated": m["migrated"].(string) + ",v3"}, nil
+			}},
+			{from: "v1", to: "v2", migrate: func(data interface{}) (interface{}, error) {
+				m := data.(map[string]interface{})
+				if m["invalid"] != nil {
+					return nil, fmt.Errorf("invalid data")
+				}
+				return map[string]interface{}{"items": m["nodes"], "migrated": "v2"}, nil
+			}},
+		},
+	}
+
+	tests := map[string]struct {
+		reading         *api.DataReading
+		expectedData    interface{}
+		expectedVersion string
+		expectErr       bool
+	}{
+		"migrates through each version": {
+			reading:         &api.DataReading{DataGathererKind: "k8s-nodes", DataVersion: "v1", Data: map[string]interface{}{"nodes": "a"}},
+			expectedData:    map[string]interface{}{"nodes": "a", "migrated": "v2,v3"},
+			expectedVersion: "v3",
+		},
+		"a missing version is the first one": {
+			reading:         &api.DataReading{DataGathererKind: "k8s-nodes", Data: map[string]interface{}{"nodes": "a"}},
+			expectedData:    map[string]interface{}{"nodes": "a", "migrated": "v2,v3"},
+			expectedVersion: "v3",
+		},
+		"current version": {
+			reading:         &api.DataReading{DataGathererKind: "k8s-nodes", DataVersion: "v3", Data: "data"},
+			expectedData:    "data",
+			expectedVersion: "v3",
+		},
+		"reading without kind": {
+			reading:         &api.DataReading{Data: "data"},
+			expectedData:    "data",
+			expectedVersion: "",
+		},
+		"newer version": {
+			reading:         &api.DataReading{DataGathererKind: "k8s-nodes", DataVersion: "v4", Data: "data"},
+			expectedData:    "data",
+			expectedVersion: "v4",
+			expectErr:       true,
+		},
+		"failed migration leaves the reading as is": {
+			reading:         &api.DataReading{DataGathererKind: "k8s-nodes", DataVersion: "v1", Data: map[string]interface{}{"invalid": true}},
+			expectedData:    map[string]interface{}{"invalid": true},
+			expectedVersion: "v1",
+			expectErr:       true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := migrateReading(test.reading, versions, migrations)
+			if test.expectErr != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(test.reading.Data, test.expectedData) {
+				t.Errorf("expected data %v, got %v", test.expectedData, test.reading.Data)
+			}
+			if test.reading.DataVersion != test.expectedVersion {
+				t.Errorf("expected version %q, got %q", test.expectedVersion, test.reading.DataVersion)
+			}
+		})
+	}
+}
+
+func TestMigrateReadingCycle(t *testing.T) {
+	migrations := map[string][]dataMigration{
+		"local": {
+			{from: "v1", to: "v2", migrate: func(data interface{}) (interface{}, error) { return data, nil }},
+			{from: "v2", to: "v1", migrate: func(data interface{}) (interface{}, error) { return data, nil }},
+		},
+	}
+	reading := &api.DataReading{DataGathererKind: "local", DataVersion: "v1"}
+	if err := migrateReading(reading, map[string]string{"local": "v3"}, migrations); err == nil {
+		t.Errorf("expected an error")
+	}
+}
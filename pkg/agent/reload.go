@@ -0,0 +1,374 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/metrics"
+)
+
+// runningGatherers are the data gatherers of the agent, each with a context
+// of its own so that it can be stopped when removed from the configuration.
+type runningGatherers struct {
+	ctx        context.Context
+	gatherers  map[string]datagatherer.DataGatherer
+	cancels    map[string]context.CancelFunc
+	syncPeriod time.Duration
+}
+
+func newRunningGatherers(ctx context.Context, gatherers map[string]datagatherer.DataGatherer) *runningGatherers {
+	return &runningGatherers{
+		ctx:        ctx,
+		gatherers:  gatherers,
+		cancels:    map[string]context.CancelFunc{},
+		syncPeriod: 5 * time.Second,
+	}
+}
+
+// add instantiates the data gatherer, and returns it along with the function
+// starting it and waiting for its initial sync. It is not fetched until the
+// function is called.
+func (r *runningGatherers) add(dgConfig DataGatherer) (datagatherer.DataGatherer, func(), error) {
+	ctx, cancel := context.WithCancel(r.ctx)
+	dg, err := dgConfig.Config.NewDataGatherer(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	start := func() {
+		log := logs.Log.WithField(logs.DataGathererField, dgConfig.Name)
+		log.Infof("starting %q datagatherer", dgConfig.Name)
+
+		// start the data gatherers and wait for the cache sync
+		if err := dg.Run(ctx.Done()); err != nil {
+			log.Errorf("failed to start %q data gatherer %q: %v", dgConfig.Kind, dgConfig.Name, err)
+		}
+
+		// bootCtx is a context with a timeout to allow the informer 5
+		// seconds to perform an initial sync. It may fail, and that's fine
+		// too, it will backoff and retry of its own accord. Initial boot
+		// will only be delayed by a max of 5 seconds.
+		bootCtx, cancel := context.WithTimeout(ctx, r.syncPeriod)
+		defer cancel()
+
+		// wait for the informer to complete an initial sync, we do this to
+		// attempt to have an initial set of data for the first upload of
+		// the run.
+		if err := dg.WaitForCacheSync(bootCtx.Done()); err != nil {
+			// log sync failure, this might recover in future
+			log.Errorf("failed to complete initial sync of %q data gatherer %q: %v", dgConfig.Kind, dgConfig.Name, err)
+		}
+	}
+	r.gatherers[dgConfig.Name] = dg
+	r.cancels[dgConfig.Name] = cancel
+	return dg, start, nil
+}
+
+// pending returns running gatherers sharing the context of r, for data
+// gatherers instantiated before they are started and moved to r.
+func (r *runningGatherers) pending() *runningGatherers {
+	return &runningGatherers{
+		ctx:        r.ctx,
+		gatherers:  map[string]datagatherer.DataGatherer{},
+		cancels:    map[string]context.CancelFunc{},
+		syncPeriod: r.syncPeriod,
+	}
+}
+
+// stop stops the data gatherer and clears its cache.
+func (r *runningGatherers) stop(name string) {
+	dg, ok := r.gatherers[name]
+	if !ok {
+		return
+	}
+	if cancel, ok := r.cancels[name]; ok {
+		cancel()
+	}
+	log := logs.Log.WithField(logs.DataGathererField, name)
+	if err := dg.Delete(); err != nil {
+		log.Errorf("failed to clear the cache of data gatherer %q: %v", name, err)
+	}
+	delete(r.gatherers, name)
+	delete(r.cancels, name)
+	log.Infof("stopped %q datagatherer", name)
+}
+
+// replace restarts the data gatherer with a new configuration. The new data
+// gatherer is instantiated before the previous one is stopped, which is kept
+// if it fails.
+func (r *runningGatherers) replace(dgConfig DataGatherer) error {
+	pending := r.pending()
+	dg, start, err := pending.add(dgConfig)
+	if err != nil {
+		return err
+	}
+	r.stop(dgConfig.Name)
+	start()
+	r.gatherers[dgConfig.Name] = dg
+	r.cancels[dgConfig.Name] = pending.cancels[dgConfig.Name]
+	return nil
+}
+
+// gathererChanges are the differences between the data gatherers of two
+// configurations.
+type gathererChanges struct {
+	// added are the data gatherers to start, including the changed ones.
+	added []DataGatherer
+	// removed are the names of the data gatherers to stop, including the
+	// changed ones.
+	removed []string
+	// namespaced are the data gatherers whose included namespaces are the
+	// only change, they are changed without a restart when possible.
+	namespaced []DataGatherer
+}
+
+// namespaceSetter is implemented by the data gatherers whose included
+// namespaces can be changed while they run.
+type namespaceSetter interface {
+	SetNamespaces(namespaces []string) error
+}
+
+// diffDataGatherers returns the data gatherers to start and stop to go from
+// the previous configuration to the next one. A data gatherer is restarted
+// when its kind or its config changes, the changes of its schedule are
+// applied by the scheduler. The changes of the included namespaces of a
+// data gatherer with included namespaces are returned apart, as its
+// namespaces can be changed without restarting it.
+func diffDataGatherers(previous, next []DataGatherer) gathererChanges {
+	var changes gathererChanges
+	previousByName := map[string]DataGatherer{}
+	for _, dg := range previous {
+		previousByName[dg.Name] = dg
+	}
+	nextNames := map[string]bool{}
+	for _, dg := range next {
+		nextNames[dg.Name] = true
+		p, ok := previousByName[dg.Name]
+		if ok && p.Kind == dg.Kind && p.DataPath == dg.DataPath && reflect.DeepEqual(p.Config, dg.Config) {
+			continue
+		}
+		if ok && p.Kind == dg.Kind && p.DataPath == dg.DataPath && onlyNamespacesChanged(p.Config, dg.Config) {
+			changes.namespaced = append(changes.namespaced, dg)
+			continue
+		}
+		if ok {
+			changes.removed = append(changes.removed, dg.Name)
+		}
+		changes.added = append(changes.added, dg)
+	}
+	for _, dg := range previous {
+		if !nextNames[dg.Name] {
+			changes.removed = append(changes.removed, dg.Name)
+		}
+	}
+	return changes
+}
+
+// onlyNamespacesChanged returns whether the configs are the ones of dynamic
+// data gatherers with included namespaces which only differ by these.
+func onlyNamespacesChanged(previous, next datagatherer.Config) bool {
+	p, ok := previous.(*k8s.ConfigDynamic)
+	if !ok || len(p.IncludeNamespaces) == 0 {
+		return false
+	}
+	n, ok := next.(*k8s.ConfigDynamic)
+	if !ok || len(n.IncludeNamespaces) == 0 {
+		return false
+	}
+	withPreviousNamespaces := *n
+	withPreviousNamespaces.IncludeNamespaces = p.IncludeNamespaces
+	return reflect.DeepEqual(p, &withPreviousNamespaces)
+}
+
+// reloadConfig reads the configuration file again and applies the changes of
+// its data gatherers, their schedules and the period to the running agent.
+// The caches of the unchanged data gatherers are kept. The configuration is
+// left as is if the new one is invalid, or one of its data gatherers cannot be
+// instantiated.
+func reloadConfig(path string, config *Config, running *runningGatherers, scheduler *scheduler, health *healthTracker) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the config file: %v", err)
+	}
+	next, err := ParseConfig(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse the config file: %v", err)
+	}
+	if next.RemoteConfig != nil {
+		if err := next.RemoteConfig.applyCached(&next); err != nil {
+			return err
+		}
+	}
+	// the DataGatherer resources are watched since the agent started
+	config.resources.apply(&next)
+	// the data gatherers selected on the command line stay the only ones run
+	next.DataGatherers, err = selectDataGatherers(next.DataGatherers, GathererNames, SkipGathererNames)
+	if err != nil {
+		return err
+	}
+	next.DataGatherers = expandClusters(next.DataGatherers)
+	next.DataGatherers = append(next.DataGatherers, addedByDirectives(config.DataGatherers, next.DataGatherers)...)
+
+	changes := diffDataGatherers(config.DataGatherers, next.DataGatherers)
+	// the data gatherers which cannot change their namespaces are restarted
+	var namespaced []DataGatherer
+	for _, dgConfig := range changes.namespaced {
+		if _, ok := running.gatherers[dgConfig.Name].(namespaceSetter); ok {
+			namespaced = append(namespaced, dgConfig)
+			continue
+		}
+		changes.removed = append(changes.removed, dgConfig.Name)
+		changes.added = append(changes.added, dgConfig)
+	}
+	for _, dgConfig := range changes.added {
+		if dgConfig.DataPath != "" {
+			return fmt.Errorf("data gatherer %q: data_path is not supported", dgConfig.Name)
+		}
+	}
+
+	// the data gatherers are instantiated before any is stopped, so that
+	// the previous ones are kept if one of them fails
+	pending := running.pending()
+	starts := map[string]func(){}
+	for _, dgConfig := range changes.added {
+		_, start, err := pending.add(dgConfig)
+		if err != nil {
+			for name := range pending.cancels {
+				pending.cancels[name]()
+			}
+			return fmt.Errorf("failed to instantiate %q data gatherer %q: %v", dgConfig.Kind, dgConfig.Name, err)
+		}
+		starts[dgConfig.Name] = start
+	}
+
+	for _, name := range changes.removed {
+		running.stop(name)
+		health.remove(name)
+		config.snapshot.remove(readingIdentity(*config, name))
+	}
+	for name, dg := range pending.gatherers {
+		starts[name]()
+		running.gatherers[name] = dg
+		running.cancels[name] = pending.cancels[name]
+	}
+	for _, dgConfig := range namespaced {
+		setter := running.gatherers[dgConfig.Name].(namespaceSetter)
+		if err := setter.SetNamespaces(dgConfig.Config.(*k8s.ConfigDynamic).IncludeNamespaces); err != nil {
+			logs.Log.Warnf("failed to change the namespaces of data gatherer %q, restarting it: %v", dgConfig.Name, err)
+			if err := running.replace(dgConfig); err != nil {
+				return fmt.Errorf("failed to restart data gatherer %q: %v", dgConfig.Name, err)
+			}
+		}
+	}
+	scheduler.update(config.DataGatherers, next.DataGatherers)
+
+	// the period of the flag takes precedence over the one of the config
+	if Period == config.Period && next.Period > 0 {
+		Period = next.Period
+	}
+	if restartRequired(*config, next) {
+		logs.Log.Warnf("The configuration changed beyond the data gatherers and the period, restart the agent to apply it")
+	}
+	config.DataGatherers = next.DataGatherers
+	config.Period = next.Period
+
+	logs.Log.Infof("Reloaded the config from %s: %d data gatherers started, %d stopped, %d with changed namespaces", path, len(changes.added), len(changes.removed), len(namespaced))
+	return nil
+}
+
+// restartRequired returns whether the configurations differ by settings
+// which are only applied when the agent starts.
+func restartRequired(previous, next Config) bool {
+	previous.DataGatherers, next.DataGatherers = nil, nil
+	previous.Period, next.Period = 0, 0
+	if next.ClusterID == "" {
+		// it defaults to the UID of the cluster
+		next.ClusterID = previous.ClusterID
+	}
+	a, errA := previous.Dump()
+	b, errB := next.Dump()
+	return errA != nil || errB != nil || a != b
+}
+
+// notifyReload asks the agent to reload its configuration. The
+// notifications are coalesced until the agent reloads it.
+func notifyReload(reloads chan<- struct{}) {
+	select {
+	case reloads <- struct{}{}:
+	default:
+	}
+}
+
+// watchConfig notifies reloads when the configuration file changes or the
+// agent receives SIGHUP, until stopCh is closed.
+func watchConfig(path string, reloads chan<- struct{}, stopCh <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %v", err)
+	}
+	// a mounted ConfigMap is replaced through symlinks, so its directory is
+	// watched rather than the file
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %q: %v", filepath.Dir(path), err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer watcher.Close()
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-signals:
+				notifyReload(reloads)
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				notifyReload(reloads)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logs.Log.Errorf("error watching the config file: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// waitAndReload waits for the period, reloading the configuration whenever
+// reloads receives a value. A nil reloads only waits. It returns early when
+// stopCh is closed.
+func waitAndReload(period time.Duration, reloads <-chan struct{}, reload func() error, stopCh <-chan struct{}) {
+	timer := time.NewTimer(period)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return
+		case <-stopCh:
+			return
+		case <-reloads:
+			err := reload()
+			metrics.ObserveConfigReload(err)
+			if err != nil {
+				logs.Log.Warnf("failed to reload the config, using the previous one: %v", err)
+			}
+		}
+	}
+}
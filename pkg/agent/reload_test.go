@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+func TestDiffDataGatherers(t *testing.T) {
+	previous := []DataGatherer{
+		{Name: "unchanged", Kind: "dummy", Config: &dummyConfig{}},
+		{Name: "schedule", Kind: "dummy", Config: &dummyConfig{}},
+		{Name: "changed", Kind: "dummy", Config: &dummyConfig{FailedAttempts: 1}},
+		{Name: "removed", Kind: "dummy", Config: &dummyConfig{}},
+		{Name: "namespaces", Kind: "k8s-dynamic", Config: &k8s.ConfigDynamic{IncludeNamespaces: []string{"a"}}},
+	}
+	next := []DataGatherer{
+		{Name: "unchanged", Kind: "dummy", Config: &dummyConfig{}},
+		{Name: "schedule", Kind: "dummy", Config: &dummyConfig{}, Schedule: "1h"},
+		{Name: "changed", Kind: "dummy", Config: &dummyConfig{FailedAttempts: 2}},
+		{Name: "added", Kind: "dummy", Config: &dummyConfig{}},
+		{Name: "namespaces", Kind: "k8s-dynamic", Config: &k8s.ConfigDynamic{IncludeNamespaces: []string{"a", "b"}}},
+	}
+
+	changes := diffDataGatherers(previous, next)
+	var added []string
+	for _, dg := range changes.added {
+		added = append(added, dg.Name)
+	}
+	if expected := []string{"changed", "added"}; !reflect.DeepEqual(added, expected) {
+		t.Errorf("expected %v to be added, got %v", expected, added)
+	}
+	if expected := []string{"changed", "removed"}; !reflect.DeepEqual(changes.removed, expected) {
+		t.Errorf("expected %v to be removed, got %v", expected, changes.removed)
+	}
+	if len(changes.namespaced) != 1 || changes.namespaced[0].Name != "namespaces" {
+		t.Errorf("expected the namespaces of %q to be changed, got %v", "namespaces", changes.namespaced)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	write := func(data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	write(`
+organization_id: example
+cluster_id: example
+period: 1m
+data-gatherers:
+- name: unchanged
+  kind: dummy
+- name: changed
+  kind: dummy
+- name: removed
+  kind: dummy
+`)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config, err := ParseConfig(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	running := newRunningGatherers(ctx, map[string]datagatherer.DataGatherer{})
+	for _, dgConfig := range config.DataGatherers {
+		_, start, err := running.add(dgConfig)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		start()
+	}
+	unchanged := running.gatherers["unchanged"]
+	changed := running.gatherers["changed"]
+	scheduler := newScheduler(config.DataGatherers)
+	health := newHealthTracker([]string{"unchanged", "changed", "removed"})
+
+	defer func(period time.Duration) { Period = period }(Period)
+	Period = config.Period
+
+	write(`
+organization_id: example
+cluster_id: example
+period: 5m
+data-gatherers:
+- name: unchanged
+  kind: dummy
+- name: changed
+  kind: dummy
+  config:
+    failed-attempts: 1
+- name: added
+  kind: dummy
+`)
+	if err := reloadConfig(path, &config, running, scheduler, health); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if running.gatherers["unchanged"] != unchanged {
+		t.Errorf("expected the unchanged data gatherer to be kept")
+	}
+	if running.gatherers["changed"] == changed {
+		t.Errorf("expected the changed data gatherer to be restarted")
+	}
+	if _, ok := running.gatherers["added"]; !ok {
+		t.Errorf("expected the added data gatherer to be started")
+	}
+	if _, ok := running.gatherers["removed"]; ok {
+		t.Errorf("expected the removed data gatherer to be stopped")
+	}
+	if _, ok := health.snapshot()["removed"]; ok {
+		t.Errorf("expected the health of the removed data gatherer to be forgotten")
+	}
+	if len(config.DataGatherers) != 3 || Period != 5*time.Minute {
+		t.Errorf("expected the config to be updated, got %d data gatherers and period %s", len(config.DataGatherers), Period)
+	}
+
+	// an invalid config is not applied
+	write(`data-gatherers: [`)
+	if err := reloadConfig(path, &config, running, scheduler, health); err == nil {
+		t.Errorf("expected an invalid config to fail")
+	}
+	if len(running.gatherers) != 3 {
+		t.Errorf("expected the data gatherers to be kept, got %d", len(running.gatherers))
+	}
+}
@@ -0,0 +1,215 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/version"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// defaultRemoteConfigSyncPeriod is how often the remote configuration is
+	// fetched again.
+	defaultRemoteConfigSyncPeriod = 5 * time.Minute
+	// maxRemoteConfigSize bounds the remote configuration read from the
+	// backend.
+	maxRemoteConfigSize = 1 << 20
+)
+
+// RemoteConfig fetches the data gatherers and the period of the agent from
+// the backend, so that they are managed centrally instead of in the
+// ConfigMap of every cluster. The last configuration fetched is cached on
+// disk, and used when the backend cannot be reached on start.
+type RemoteConfig struct {
+	// CachePath is the file the last remote configuration is cached in,
+	// e.g. on a persistent volume.
+	CachePath string `yaml:"cache-path"`
+	// SyncPeriod is how often the remote configuration is fetched again.
+	// Defaults to 5m.
+	SyncPeriod time.Duration `yaml:"sync-period,omitempty"`
+}
+
+func (r *RemoteConfig) validate() error {
+	if r.CachePath == "" {
+		return fmt.Errorf("remote-config.cache-path is required")
+	}
+	if r.SyncPeriod < 0 {
+		return fmt.Errorf("remote-config.sync-period cannot be negative")
+	}
+	return nil
+}
+
+// remoteConfigDocument is the configuration served by the backend.
+type remoteConfigDocument struct {
+	Period        time.Duration  `yaml:"period,omitempty"`
+	DataGatherers []DataGatherer `yaml:"data-gatherers"`
+}
+
+// parseRemoteConfig parses and validates the configuration served by the
+// backend, in YAML or JSON. The references to environment variables are
+// expanded as in the local configuration.
+func parseRemoteConfig(data []byte) (remoteConfigDocument, error) {
+	var doc remoteConfigDocument
+	data, err := expandTemplate(data, os.LookupEnv)
+	if err != nil {
+		return doc, err
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return doc, err
+	}
+	if doc.Period < 0 {
+		return doc, fmt.Errorf("period cannot be negative")
+	}
+	return doc, validateDataGatherers(doc.DataGatherers)
+}
+
+// apply adds the data gatherers of the remote configuration to the ones of
+// the local configuration, and sets its period if it has one.
+func (r *RemoteConfig) apply(config *Config, data []byte) error {
+	doc, err := parseRemoteConfig(data)
+	if err != nil {
+		return fmt.Errorf("invalid remote configuration: %v", err)
+	}
+	names := map[string]bool{}
+	for _, dg := range config.DataGatherers {
+		names[dg.Name] = true
+	}
+	for _, dg := range doc.DataGatherers {
+		if names[dg.Name] {
+			return fmt.Errorf("invalid remote configuration: the data gatherer %q is already in the local configuration", dg.Name)
+		}
+	}
+	config.DataGatherers = append(config.DataGatherers, doc.DataGatherers...)
+	if doc.Period > 0 {
+		config.Period = doc.Period
+	}
+	return nil
+}
+
+// load fetches the remote configuration and applies it to the config,
+// falling back to the cached one if the backend cannot be reached.
+func (r *RemoteConfig) load(config *Config, preflightClient client.Client) error {
+	data, err := fetchRemoteConfig(preflightClient, config.OrganizationID, config.ClusterID)
+	if err == nil {
+		if _, err = parseRemoteConfig(data); err == nil {
+			if err := r.writeCache(data); err != nil {
+				logs.Log.Errorf("failed to cache the remote configuration: %v", err)
+			}
+		}
+	}
+	if err != nil {
+		logs.Log.Warnf("failed to fetch the remote configuration, using the cached one: %v", err)
+		if data, err = r.readCache(); err != nil {
+			return fmt.Errorf("failed to read the cached remote configuration: %v", err)
+		}
+	}
+	return r.apply(config, data)
+}
+
+// applyCached applies the cached remote configuration to the config, as
+// when the local configuration is reloaded.
+func (r *RemoteConfig) applyCached(config *Config) error {
+	data, err := r.readCache()
+	if err != nil {
+		return fmt.Errorf("failed to read the cached remote configuration: %v", err)
+	}
+	return r.apply(config, data)
+}
+
+// sync fetches the remote configuration every sync period until ctx is done.
+// When it changes, it is cached and the agent is notified to reload it.
+func (r *RemoteConfig) sync(ctx context.Context, config Config, preflightClient client.Client, reloads chan<- struct{}) {
+	period := r.SyncPeriod
+	if period == 0 {
+		period = defaultRemoteConfigSyncPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := fetchRemoteConfig(preflightClient, config.OrganizationID, config.ClusterID)
+		if err != nil {
+			logs.Log.Errorf("failed to fetch the remote configuration: %v", err)
+			continue
+		}
+		if cached, err := r.readCache(); err == nil && bytes.Equal(cached, data) {
+			continue
+		}
+		if _, err := parseRemoteConfig(data); err != nil {
+			logs.Log.Warnf("ignoring the invalid remote configuration: %v", err)
+			continue
+		}
+		if err := r.writeCache(data); err != nil {
+			logs.Log.Errorf("failed to cache the remote configuration: %v", err)
+			continue
+		}
+		logs.Log.Infof("The remote configuration changed, reloading it")
+		notifyReload(reloads)
+	}
+}
+
+func (r *RemoteConfig) readCache() ([]byte, error) {
+	return ioutil.ReadFile(r.CachePath)
+}
+
+// writeCache replaces the cache atomically, so that a partial configuration
+// is never read.
+func (r *RemoteConfig) writeCache(data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(r.CachePath), ".remote-config")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.CachePath)
+}
+
+// fetchRemoteConfig fetches the configuration of the cluster from the
+// backend. The version of the agent is sent so that the backend only serves
+// the data gatherers it supports.
+func fetchRemoteConfig(preflightClient client.Client, orgID, clusterID string) ([]byte, error) {
+	body, err := json.Marshal(api.AgentMetadata{
+		Version:   version.PreflightVersion,
+		ClusterID: clusterID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	res, err := preflightClient.Post(path.Join("/api/v1/org", orgID, "agentconfig", clusterID), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxRemoteConfigSize))
+	if err != nil {
+		return nil, err
+	}
+	if code := res.StatusCode; code < 200 || code >= 300 {
+		return nil, fmt.Errorf("received response with status code %d. Body: %s", code, data)
+	}
+	return data, nil
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+func TestRemoteConfigLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote-config")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/org/example/agentconfig/my-cluster" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`
+period: 10m
+data-gatherers:
+- name: remote
+  kind: dummy
+`))
+	}))
+	defer server.Close()
+
+	preflightClient, err := client.NewUnauthenticatedClient(&api.AgentMetadata{}, server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	remote := &RemoteConfig{CachePath: filepath.Join(dir, "cache.yaml")}
+	newConfig := func() Config {
+		return Config{
+			OrganizationID: "example",
+			ClusterID:      "my-cluster",
+			Period:         time.Minute,
+			DataGatherers:  []DataGatherer{{Name: "local", Kind: "dummy", Config: &dummyConfig{}}},
+		}
+	}
+
+	config := newConfig()
+	if err := remote.load(&config, preflightClient); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(config.DataGatherers) != 2 || config.DataGatherers[1].Name != "remote" || config.Period != 10*time.Minute {
+		t.Fatalf("expected the remote configuration to be applied, got %+v", config)
+	}
+	if _, err := os.Stat(remote.CachePath); err != nil {
+		t.Fatalf("expected the remote configuration to be cached: %v", err)
+	}
+
+	// the cached configuration is used when the backend fails
+	status = http.StatusInternalServerError
+	config = newConfig()
+	if err := remote.load(&config, preflightClient); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(config.DataGatherers) != 2 {
+		t.Fatalf("expected the cached configuration to be applied, got %d data gatherers", len(config.DataGatherers))
+	}
+
+	os.Remove(remote.CachePath)
+	config = newConfig()
+	if err := remote.load(&config, preflightClient); err == nil {
+		t.Fatalf("expected an error without the backend or a cache")
+	}
+}
+
+func TestRemoteConfigApply(t *testing.T) {
+	tests := map[string]struct {
+		data        string
+		expectedErr string
+	}{
+		"valid": {
+			data: `{"data-gatherers": [{"name": "remote", "kind": "dummy"}]}`,
+		},
+		"duplicate name": {
+			data:        `{"data-gatherers": [{"name": "local", "kind": "dummy"}]}`,
+			expectedErr: `the data gatherer "local" is already in the local configuration`,
+		},
+		"missing kind": {
+			data:        `{"data-gatherers": [{"name": "remote"}]}`,
+			expectedErr: "invalid remote configuration",
+		},
+		"invalid schedule": {
+			data:        `{"data-gatherers": [{"name": "remote", "kind": "dummy", "schedule": "never"}]}`,
+			expectedErr: `datagatherer "remote" has an invalid schedule`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := Config{DataGatherers: []DataGatherer{{Name: "local", Kind: "dummy"}}}
+			err := (&RemoteConfig{}).apply(&config, []byte(test.data))
+			if test.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+				t.Fatalf("expected error containing %q, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
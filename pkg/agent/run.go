@@ -6,11 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/url"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -19,8 +22,16 @@ import (
 	"github.com/jetstack/preflight/pkg/client"
 	"github.com/jetstack/preflight/pkg/datagatherer"
 	dgerror "github.com/jetstack/preflight/pkg/datagatherer/error"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/metrics"
+	"github.com/jetstack/preflight/pkg/output"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"github.com/jetstack/preflight/pkg/transport"
 	"github.com/jetstack/preflight/pkg/version"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // ConfigFilePath is where the agent will try to load the configuration from
@@ -32,6 +43,10 @@ var Period time.Duration
 // OneShot flag causes agent to run once
 var OneShot bool
 
+// exitGatherFailed is the exit code of a one-shot run where some data
+// gatherers failed, or returned no resources while expected to.
+const exitGatherFailed = 2
+
 // CredentialsPath is where the agent will try to loads the credentials. (Experimental)
 var CredentialsPath string
 
@@ -50,6 +65,37 @@ var StrictMode bool
 // APIToken is an authentication token used for the backend API as an alternative to oauth flows.
 var APIToken string
 
+// APITokenPath is the file the API token is read from instead of APIToken, and reloaded from when rotated
+var APITokenPath string
+
+// DualWriteReportPath is where the dual-write comparison reports are appended, if specified
+var DualWriteReportPath string
+
+// HealthAddress is the address the liveness and readiness endpoints are served on, if specified
+var HealthAddress string
+
+// MetricsAddress is the address the Prometheus metrics are served on, if specified
+var MetricsAddress string
+
+// OutputNames are the names of the outputs used for this run, all the outputs are used if empty
+var OutputNames []string
+
+// GathererNames are the names of the data gatherers run, all the data gatherers are run if empty
+var GathererNames []string
+
+// SkipGathererNames are the names of the data gatherers not run
+var SkipGathererNames []string
+
+// DryRun runs the data gatherers once and prints the payload to the standard output instead of uploading it
+var DryRun bool
+
+// dryRunOutput is the name of the output printing the payload of a dry run.
+const dryRunOutput = "dry-run"
+
+// cycles is the number of cycles of the agent, identifying the cycle of the
+// logs.
+var cycles int64
+
 // schema version of the data sent by the agent.
 // The new default version is v2.
 // In v2 the agent posts data readings using api.gathereredResources
@@ -62,9 +108,137 @@ const schemaVersion string = "v2.0.0"
 func Run(cmd *cobra.Command, args []string) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	config, preflightClient := getConfiguration()
+	// the agent shuts down gracefully on SIGTERM or SIGINT, the current cycle
+	// completes and the pending uploads are sent before it exits
+	shutdown, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+	config, preflightClient, credentialFiles := getConfiguration()
+
+	if err := credentialFiles.run(ctx.Done()); err != nil {
+		logs.Log.Fatalf("failed to watch the credential files: %v", err)
+	}
+
+	if DryRun {
+		// the readings are printed instead of being uploaded or written
+		OneShot = true
+		OutputPath, config.OutputPath = "", ""
+		logs.Log.Infof("Dry run, the payload will be printed instead of being uploaded")
+	}
+
+	if Period == 0 && config.Period == 0 && !OneShot {
+		logs.Log.Fatalf("Failed to load period, must be set as flag or in config")
+	}
+
+	if config.Tracing != nil {
+		err := tracing.Setup(config.Tracing, map[string]string{
+			"service.version":      version.PreflightVersion,
+			"preflight.cluster_id": config.ClusterID,
+		})
+		if err != nil {
+			logs.Log.Fatalf("failed to set up tracing: %v", err)
+		}
+		logs.Log.Infof("Tracing enabled, spans will be exported to: %s", config.Tracing.Endpoint)
+	}
+
+	selectedGatherers, err := selectDataGatherers(config.DataGatherers, GathererNames, SkipGathererNames)
+	if err != nil {
+		logs.Log.Fatalf("%v", err)
+	}
+	config.DataGatherers = selectedGatherers
+	if len(GathererNames) > 0 || len(SkipGathererNames) > 0 {
+		logs.Log.Infof("Running the selected data gatherers only: %s", strings.Join(dataGathererNames(config.DataGatherers), ", "))
+	}
+
+	// data gatherers reading from several clusters are run once per cluster
+	config.DataGatherers = expandClusters(config.DataGatherers)
+
+	// the data gatherers configuring their own client options override them
+	k8s.SetDefaultClientOptions(config.KubernetesClient)
+
+	var secondaryClient client.Client
+	if config.DualWrite != nil && !DryRun {
+		var err error
+		secondaryMetadata := &api.AgentMetadata{
+			Version:   version.PreflightVersion,
+			ClusterID: config.ClusterID,
+		}
+		config.identity.attach(secondaryMetadata)
+		secondaryClient, err = config.DualWrite.newClient(secondaryMetadata)
+		if err != nil {
+			logs.Log.Fatalf("failed to create dual-write client: %v", err)
+		}
+		setUploadEncoding(config, secondaryClient)
+		logs.Log.Infof("Dual-write enabled, readings will also be sent to: %s", config.DualWrite.Server)
+	}
+	stats := &dualWriteStats{}
+
+	var uploads *spool
+	if config.Spool != nil && !DryRun {
+		uploads, err = newSpool(config.Spool)
+		if err != nil {
+			logs.Log.Fatalf("failed to create spool: %v", err)
+		}
+		logs.Log.Infof("Spool enabled, failed uploads will be queued in: %s", config.Spool.Directory)
+	}
+
+	selectedOutputs, err := selectOutputs(config.Outputs, OutputNames)
+	if err != nil {
+		logs.Log.Fatalf("%v", err)
+	}
+	config.skipUpload = !uploadsToBackend(config.Outputs, selectedOutputs)
+	if config.skipUpload && !DryRun {
+		logs.Log.Warnf("The backend output is not selected, readings will not be uploaded")
+	}
+
+	outputs := map[string]output.Output{}
+	if DryRun {
+		selectedOutputs = nil
+		config.skipUpload = true
+		outputs[dryRunOutput], err = (&output.StdoutConfig{Pretty: true}).NewOutput()
+		if err != nil {
+			logs.Log.Fatalf("%v", err)
+		}
+	}
+	for _, o := range selectedOutputs {
+		if o.Kind == backendOutputKind {
+			continue
+		}
+		out, err := o.Config.NewOutput()
+		if err != nil {
+			logs.Log.Fatalf("failed to instantiate %q output %q: %v", o.Kind, o.Name, err)
+		}
+		outputs[o.Name] = out
+	}
+
+	watcher := newWatcher(config)
+
+	var names []string
+	for _, dgConfig := range config.DataGatherers {
+		names = append(names, dgConfig.Name)
+	}
+	health := newHealthTracker(names)
+	health.configure(config.Health, !config.skipUpload && OutputPath == "" && config.OutputPath == "")
+	inFlight := newInFlightFetches()
+	if config.SnapshotEndpoint != nil {
+		config.snapshot = newReadingsSnapshot(config.SnapshotEndpoint)
+	}
+	config.hashers, err = newIdentifierHashers(config.HashIdentifiers)
+	if err != nil {
+		logs.Log.Fatalf("failed to set up the hashing of identifiers: %v", err)
+	}
+	stopEndpoints := serveEndpoints(health, config.snapshot)
+
+	go func() {
+		<-shutdown.Done()
+		timeout := config.Health.shutdownTimeout()
+		logs.Log.Infof("Shutting down, waiting up to %s for the current cycle and the pending uploads", timeout)
+		time.Sleep(timeout)
+		logs.Log.Errorf("graceful shutdown timed out after %s, exiting", timeout)
+		os.Exit(1)
+	}()
 
 	dataGatherers := map[string]datagatherer.DataGatherer{}
+	running := newRunningGatherers(ctx, dataGatherers)
 	var wg sync.WaitGroup
 
 	// load datagatherer config and boot each one
@@ -72,47 +246,24 @@ func Run(cmd *cobra.Command, args []string) {
 		kind := dgConfig.Kind
 		if dgConfig.DataPath != "" {
 			kind = "local"
-			log.Fatalf("running data gatherer %s of type %s as Local, data-path override present: %s", dgConfig.Name, dgConfig.Kind, dgConfig.DataPath)
+			logs.Log.Fatalf("running data gatherer %s of type %s as Local, data-path override present: %s", dgConfig.Name, dgConfig.Kind, dgConfig.DataPath)
 		}
 
-		newDg, err := dgConfig.Config.NewDataGatherer(ctx)
+		_, start, err := running.add(dgConfig)
 		if err != nil {
-			log.Fatalf("failed to instantiate %q data gatherer  %q: %v", kind, dgConfig.Name, err)
+			logs.Log.Fatalf("failed to instantiate %q data gatherer  %q: %v", kind, dgConfig.Name, err)
 		}
 
 		wg.Add(1)
 
 		go func() {
-			log.Printf("starting %q datagatherer", dgConfig.Name)
-
-			// start the data gatherers and wait for the cache sync
-			if err := newDg.Run(ctx.Done()); err != nil {
-				log.Printf("failed to start %q data gatherer %q: %v", kind, dgConfig.Name, err)
-			}
-
-			// bootCtx is a context with a timeout to allow the informer 5
-			// seconds to perform an initial sync. It may fail, and that's fine
-			// too, it will backoff and retry of its own accord. Initial boot
-			// will only be delayed by a max of 5 seconds.
-			bootCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-			defer cancel()
-
-			// wait for the informer to complete an initial sync, we do this to
-			// attempt to have an initial set of data for the first upload of
-			// the run.
-			if err := newDg.WaitForCacheSync(bootCtx.Done()); err != nil {
-				// log sync failure, this might recover in future
-				log.Printf("failed to complete initial sync of %q data gatherer %q: %v", kind, dgConfig.Name, err)
-			}
-
 			// regardless of success, this dataGatherers has been given a
 			// chance to sync its cache and we will now continue as normal. We
 			// assume at the informers will either recover or the log messages
 			// above will help operators correct the issue.
-			wg.Done()
+			defer wg.Done()
+			start()
 		}()
-
-		dataGatherers[dgConfig.Name] = newDg
 	}
 
 	// wait for initial sync period to complete. if unsuccessful, then crash
@@ -120,15 +271,44 @@ func Run(cmd *cobra.Command, args []string) {
 	c := make(chan struct{})
 	go func() {
 		defer close(c)
-		log.Printf("waiting for datagatherers to complete inital syncs")
+		logs.Log.Infof("waiting for datagatherers to complete inital syncs")
 		wg.Wait()
 	}()
 	select {
 	case <-c:
-		log.Printf("datagatherers inital sync completed")
+		logs.Log.Infof("datagatherers inital sync completed")
 	case <-time.After(60 * time.Second):
-		log.Fatalf("datagatherers inital sync failed due to timeout of 60 seconds")
+		logs.Log.Fatalf("datagatherers inital sync failed due to timeout of 60 seconds")
+	case <-shutdown.Done():
+		logs.Log.Infof("Shut down before the initial sync completed")
+		return
+	}
+	health.setSynced()
+
+	scheduler := newScheduler(config.DataGatherers)
+	directives := newDirectiveApplier(config)
+	budget := newMemoryBudget(config.MemoryBudget)
+
+	// the data gatherers and the period are reloaded when the config file
+	// or the remote configuration changes, or on SIGHUP
+	reloads := make(chan struct{}, 1)
+	if !OneShot {
+		if err := watchConfig(ConfigFilePath, reloads, ctx.Done()); err != nil {
+			logs.Log.Warnf("failed to watch the config file, it will not be reloaded: %v", err)
+		}
+		if config.RemoteConfig != nil {
+			go config.RemoteConfig.sync(ctx, config, preflightClient, reloads)
+		}
+		if config.resources != nil {
+			go config.resources.sync(ctx, reloads)
+		}
 	}
+	reload := func() error {
+		err := reloadConfig(ConfigFilePath, &config, running, scheduler, health)
+		config.resources.report(ctx, err)
+		return err
+	}
+	config.resources.report(ctx, nil)
 
 	// begin the datagathering loop, periodically sending data to the
 	// configured output using data in datagatherer caches or refreshing from
@@ -136,25 +316,91 @@ func Run(cmd *cobra.Command, args []string) {
 	for {
 		// if period is set in the config, then use that if not already set
 		if Period == 0 && config.Period > 0 {
-			log.Printf("Using period from config %s", config.Period)
+			logs.Log.Infof("Using period from config %s", config.Period)
 			Period = config.Period
 		}
 
-		gatherAndOutputData(config, preflightClient, dataGatherers)
+		config.identity.refreshIfDue(ctx, time.Now())
+
+		due := scheduler.due(dataGatherers, time.Now())
+		// nothing is uploaded on the cycles where no data gatherer is due
+		if len(due) > 0 || len(dataGatherers) == 0 {
+			gatherAndOutputData(config, preflightClient, due, secondaryClient, stats, outputs, watcher, health, inFlight, uploads)
+		} else {
+			// the uploads are up to date when there is nothing to upload
+			health.uploaded(time.Now())
+		}
 
 		if OneShot {
+			// the data gatherers are not fetched when reading from a file
+			if err := health.checkOneShot(expectedItems(config)); err != nil && InputPath == "" {
+				logs.Log.Errorf("one-shot run failed: %v", err)
+				os.Exit(exitGatherFailed)
+			}
+			break
+		}
+
+		period := Period
+		if p := directives.apply(takeDirectives(preflightClient), &config, running, scheduler); p > 0 {
+			period = p
+		}
+		if p := budget.enforce(cacheSizes(dataGatherers), config.DataGatherers, running, period); p > 0 && p < period {
+			period = p
+		}
+		// only the primary backend directs the agent
+		takeDirectives(secondaryClient)
+
+		health.cycled(time.Now(), period+config.Jitter)
+		waitAndReload(withJitter(period, config.Jitter), reloads, reload, shutdown.Done())
+		if shutdown.Err() != nil {
 			break
 		}
+	}
+
+	if shutdown.Err() != nil {
+		flushUploads(config, preflightClient, uploads, health)
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer stopCancel()
+		stopEndpoints(stopCtx)
+		if err := tracing.Shutdown(stopCtx); err != nil {
+			logs.Log.Warnf("failed to export the pending spans: %v", err)
+		}
+		logs.Log.Infof("Shut down gracefully")
+	}
+}
+
+// flushUploads sends the uploads queued in the spool before the agent exits,
+// they are otherwise only sent on the next start if the spool is persistent.
+func flushUploads(config Config, preflightClient client.Client, uploads *spool, health *healthTracker) {
+	if OutputPath != "" || config.skipUpload {
+		return
+	}
+	sent, err := uploads.drain(func(readings []*api.DataReading) error {
+		return postData(context.Background(), config, preflightClient, readings)
+	})
+	if sent > 0 {
+		health.uploaded(time.Now())
+		logs.Log.Infof("Sent %d spooled upload(s) before shutting down", sent)
+	}
+	if err != nil {
+		logs.Log.Errorf("failed to send spooled uploads before shutting down, they are kept in the spool: %v", err)
+	}
+}
 
-		time.Sleep(Period)
+// takeDirectives returns the directives the backend sent to the client since
+// the previous cycle, if the client supports them.
+func takeDirectives(c client.Client) *api.Directives {
+	if directivesClient, ok := c.(client.DirectivesClient); ok {
+		return directivesClient.Directives()
 	}
+	return nil
 }
 
-func getConfiguration() (Config, client.Client) {
-	log.Printf("Preflight agent version: %s (%s)", version.PreflightVersion, version.Commit)
+func getConfiguration() (Config, client.Client, *credentialWatcher) {
+	logs.Log.Infof("Preflight agent version: %s (%s)", version.PreflightVersion, version.Commit)
 	file, err := os.Open(ConfigFilePath)
 	if err != nil {
-		log.Fatalf("Failed to load config file for agent from: %s", ConfigFilePath)
+		logs.Log.Fatalf("Failed to load config file for agent from: %s", ConfigFilePath)
 	}
 	defer file.Close()
 
@@ -162,43 +408,65 @@ func getConfiguration() (Config, client.Client) {
 
 	config, err := ParseConfig(b)
 	if err != nil {
-		log.Fatalf("Failed to parse config file: %s", err)
+		logs.Log.Fatalf("Failed to parse config file: %s", err)
 	}
 
 	baseURL := config.Server
 	if baseURL == "" {
-		log.Printf("Using deprecated Endpoint configuration. User Server instead.")
+		logs.Log.Warnf("Using deprecated Endpoint configuration. User Server instead.")
 		baseURL = fmt.Sprintf("%s://%s", config.Endpoint.Protocol, config.Endpoint.Host)
 		_, err = url.Parse(baseURL)
 		if err != nil {
-			log.Fatalf("Failed to build URL: %s", err)
+			logs.Log.Fatalf("Failed to build URL: %s", err)
 		}
 	}
 
-	if Period == 0 && config.Period == 0 && !OneShot {
-		log.Fatalf("Failed to load period, must be set as flag or in config")
-	}
-
 	dump, err := config.Dump()
 	if err != nil {
-		log.Fatalf("Failed to dump config: %s", err)
+		logs.Log.Fatalf("Failed to dump config: %s", err)
 	}
 
-	log.Printf("Loaded config: \n%s", dump)
+	logs.Log.Infof("Loaded config: \n%s", dump)
+
+	// the clients created from here on use the proxy and CA bundle
+	if err := transport.Setup(config.Transport); err != nil {
+		logs.Log.Fatalf("Failed to configure the HTTP clients: %s", err)
+	}
 
 	var credentials *client.Credentials
+	var credentialsData []byte
 	if CredentialsPath != "" {
-		file, err = os.Open(CredentialsPath)
+		credentialsData, err = ioutil.ReadFile(CredentialsPath)
 		if err != nil {
-			log.Fatalf("Failed to load credentials from file %s", CredentialsPath)
+			logs.Log.Fatalf("Failed to load credentials from file %s", CredentialsPath)
 		}
-		defer file.Close()
 
-		b, err = ioutil.ReadAll(file)
+		credentials, err = client.ParseCredentials(credentialsData)
+		if err != nil {
+			logs.Log.Fatalf("Failed to parse credentials file: %s", err)
+		}
+	}
 
-		credentials, err = client.ParseCredentials(b)
+	apiToken := APIToken
+	var apiTokenData []byte
+	if APITokenPath != "" {
+		apiTokenData, err = ioutil.ReadFile(APITokenPath)
 		if err != nil {
-			log.Fatalf("Failed to parse credentials file: %s", err)
+			logs.Log.Fatalf("Failed to load API token from file %s", APITokenPath)
+		}
+		apiToken = strings.TrimSpace(string(apiTokenData))
+	}
+
+	if config.ClusterIdentity != nil {
+		config.identity, err = newClusterIdentity(context.Background(), config.ClusterIdentity)
+		switch {
+		case err != nil && config.ClusterID == "":
+			logs.Log.Fatalf("Failed to derive the cluster identity, cluster_id must be set: %s", err)
+		case err != nil:
+			logs.Log.Warnf("Failed to derive the cluster identity, the uploads will only have the cluster_id: %s", err)
+		case config.ClusterID == "":
+			config.ClusterID = config.identity.get().UID
+			logs.Log.Infof("Using the cluster UID %s as the cluster_id", config.ClusterID)
 		}
 	}
 
@@ -206,29 +474,125 @@ func getConfiguration() (Config, client.Client) {
 		Version:   version.PreflightVersion,
 		ClusterID: config.ClusterID,
 	}
+	config.identity.attach(agentMetadata)
+
+	// the credentials read from files are reloaded when rotated
+	credentialFiles := &credentialWatcher{}
 
 	var preflightClient client.Client
 	switch {
+	case config.Backend != nil:
+		logs.Log.Infof("The %s backend was configured, readings will be sent to it instead.", config.Backend.Kind)
+		preflightClient, err = config.Backend.newClient(agentMetadata)
+	case config.TokenAuth != nil:
+		logs.Log.Infof("Token authentication was configured, using the %s flow.", config.TokenAuth.Mode)
+		preflightClient, err = config.TokenAuth.newClient(agentMetadata, baseURL, credentialFiles)
 	case credentials != nil:
-		log.Println("A credentials file was specified, using oauth authentication.")
-		preflightClient, err = client.NewOAuthClient(agentMetadata, credentials, baseURL)
-	case APIToken != "":
-		log.Println("An API token was specified, using API token authentication.")
-		preflightClient, err = client.NewAPITokenClient(agentMetadata, APIToken, baseURL)
+		logs.Log.Info("A credentials file was specified, using oauth authentication.")
+		var oauthClient *client.OAuthClient
+		oauthClient, err = client.NewOAuthClient(agentMetadata, credentials, baseURL)
+		if err == nil {
+			credentialFiles.add("credentials file", CredentialsPath, credentialsData, func(data []byte) error {
+				credentials, err := client.ParseCredentials(data)
+				if err != nil {
+					return err
+				}
+				return oauthClient.SetCredentials(credentials)
+			})
+		}
+		preflightClient = oauthClient
+	case apiToken != "":
+		logs.Log.Info("An API token was specified, using API token authentication.")
+		var apiTokenClient *client.APITokenClient
+		apiTokenClient, err = client.NewAPITokenClient(agentMetadata, apiToken, baseURL)
+		if err == nil && APITokenPath != "" {
+			credentialFiles.add("API token", APITokenPath, apiTokenData, func(data []byte) error {
+				apiToken := strings.TrimSpace(string(data))
+				if apiToken == "" {
+					return fmt.Errorf("the API token is empty")
+				}
+				apiTokenClient.SetAPIToken(apiToken)
+				return nil
+			})
+		}
+		preflightClient = apiTokenClient
 	default:
-		log.Println("No credentials were specified, using with no authentication.")
+		logs.Log.Info("No credentials were specified, using with no authentication.")
 		preflightClient, err = client.NewUnauthenticatedClient(agentMetadata, baseURL)
 	}
 
 	if err != nil {
-		log.Fatalf("failed to create client: %v", err)
+		logs.Log.Fatalf("failed to create client: %v", err)
+	}
+	if config.ClientCertificate != nil {
+		if err := setClientCertificate(config.ClientCertificate, preflightClient); err != nil {
+			logs.Log.Fatalf("failed to set the client certificate: %v", err)
+		}
+		logs.Log.Info("A client certificate was specified, using mTLS authentication.")
+	}
+	if config.Signing != nil {
+		if err := config.Signing.setSigner(preflightClient); err != nil {
+			logs.Log.Fatalf("failed to set up the signing of uploads: %v", err)
+		}
+		logs.Log.Info("Signing was configured, uploads will be signed.")
+	}
+	setUploadEncoding(config, preflightClient)
+	if config.UploadStatus != nil {
+		config.status, err = newUploadStatus(config.UploadStatus)
+		if err != nil {
+			logs.Log.Fatalf("Failed to set up the upload status: %s", err)
+		}
+	}
+
+	if config.RemoteConfig != nil {
+		if err := config.RemoteConfig.load(&config, preflightClient); err != nil {
+			logs.Log.Fatalf("Failed to load the remote configuration: %s", err)
+		}
+		logs.Log.Infof("Loaded the remote configuration, %d data gatherers configured", len(config.DataGatherers))
+	}
+	if config.DataGathererResources != nil {
+		config.resources, err = newGathererResources(config.DataGathererResources)
+		if err != nil {
+			logs.Log.Fatalf("Failed to set up the DataGatherer resources: %s", err)
+		}
+		if err := config.resources.start(context.Background()); err != nil {
+			logs.Log.Fatalf("Failed to load the DataGatherer resources: %s", err)
+		}
+		config.resources.apply(&config)
+		logs.Log.Infof("Loaded the DataGatherer resources, %d data gatherers configured", len(config.DataGatherers))
 	}
 
-	return config, preflightClient
+	return config, preflightClient, credentialFiles
 }
 
-func gatherAndOutputData(config Config, preflightClient client.Client, dataGatherers map[string]datagatherer.DataGatherer) {
+// setUploadEncoding sets the compression and the format of the uploads of
+// the client, if the client supports them. They have been validated with the
+// config.
+func setUploadEncoding(config Config, preflightClient client.Client) {
+	compression, _ := client.ParseCompression(config.Compression)
+	if c, ok := preflightClient.(client.CompressionClient); ok {
+		c.SetCompression(compression)
+	}
+	format, _ := client.ParseFormat(config.UploadFormat)
+	if c, ok := preflightClient.(client.FormatClient); ok {
+		c.SetFormat(format)
+	}
+}
+
+func gatherAndOutputData(config Config, preflightClient client.Client, dataGatherers map[string]datagatherer.DataGatherer, secondaryClient client.Client, stats *dualWriteStats, outputs map[string]output.Output, watcher *watcher, health *healthTracker, inFlight *inFlightFetches, uploads *spool) {
+	cycle := atomic.AddInt64(&cycles, 1)
+	ctx := logs.WithFields(context.Background(), logrus.Fields{logs.CycleField: cycle})
+	log := logs.FromContext(ctx)
+	ctx, span := tracing.Start(ctx, "cycle")
+	defer func() {
+		span.End()
+		if err := tracing.Flush(context.Background()); err != nil {
+			log.Errorf("failed to export spans: %v", err)
+		}
+	}()
+
 	var readings []*api.DataReading
+	var streamed map[string]datagatherer.DataGatherer
 
 	// Input/OutputPath flag overwrites agent.yaml configuration
 	if InputPath == "" {
@@ -238,8 +602,22 @@ func gatherAndOutputData(config Config, preflightClient client.Client, dataGathe
 		OutputPath = config.OutputPath
 	}
 
+	if OutputPath == "" && !config.skipUpload {
+		// the queued uploads are sent before the readings of this cycle
+		sent, err := uploads.drain(func(readings []*api.DataReading) error {
+			return postData(ctx, config, preflightClient, readings)
+		})
+		if sent > 0 {
+			health.uploaded(time.Now())
+			log.Infof("Sent %d spooled upload(s)", sent)
+		}
+		if err != nil {
+			log.Errorf("failed to send spooled uploads: %v", err)
+		}
+	}
+
 	if InputPath != "" {
-		log.Printf("Reading data from local file: %s", InputPath)
+		log.Infof("Reading data from local file: %s", InputPath)
 		data, err := ioutil.ReadFile(InputPath)
 		if err != nil {
 			log.Fatalf("failed to read local data file: %s", err)
@@ -248,9 +626,26 @@ func gatherAndOutputData(config Config, preflightClient client.Client, dataGathe
 		if err != nil {
 			log.Fatalf("failed to unmarshal local data file: %s", err)
 		}
+		migrateReadings(readings)
 	} else {
-		readings = gatherData(config, dataGatherers)
+		gathered := dataGatherers
+		if streamsUploads(config, secondaryClient, outputs, watcher) {
+			// the streaming data gatherers are fetched as their chunks
+			// are uploaded, after the readings of the other ones
+			gathered, streamed = splitStreaming(dataGatherers)
+		}
+		readings = gatherData(ctx, config, gathered, health, inFlight)
+		if config.ExpirySummary != nil {
+			readings = config.ExpirySummary.summarize(readings, time.Now())
+		}
+	}
+
+	if events := watcher.observe(readings); len(events) > 0 {
+		watcher.notify(events)
 	}
+	config.snapshot.update(readings)
+
+	writeOutputs(config, readings, outputs)
 
 	if OutputPath != "" {
 		data, err := json.MarshalIndent(readings, "", "  ")
@@ -258,52 +653,304 @@ func gatherAndOutputData(config Config, preflightClient client.Client, dataGathe
 		if err != nil {
 			log.Fatalf("failed to output to local file: %s", err)
 		}
-		log.Printf("Data saved to local file: %s", OutputPath)
-	} else {
-		backOff := backoff.NewExponentialBackOff()
-		backOff.InitialInterval = 30 * time.Second
-		backOff.MaxInterval = 3 * time.Minute
-		backOff.MaxElapsedTime = BackoffMaxTime
-		post := func() error {
-			return postData(config, preflightClient, readings)
-		}
-		err := backoff.RetryNotify(post, backOff, func(err error, t time.Duration) {
-			log.Printf("retrying in %v after error: %s", t, err)
+		log.Infof("Data saved to local file: %s", OutputPath)
+	} else if config.skipUpload {
+		// the readings were delivered to the other outputs
+	} else if secondaryClient != nil {
+		// both destinations are sent the same gather time, so that their
+		// payloads only differ if the readings or their encoding do
+		ctx := client.WithDataGatherTime(ctx, time.Now())
+		primary := postToDestination(ctx, "primary", config, func(ctx context.Context) error {
+			return postChunks(config, readings, func(readings []*api.DataReading) error {
+				return uploads.upload(readings, func(readings []*api.DataReading) error {
+					return postAndRecord(ctx, config, preflightClient, readings, health)
+				})
+			})
+		})
+		secondaryConfig := config.DualWrite.destinationConfig(config)
+		secondary := postToDestination(ctx, "secondary", secondaryConfig, func(ctx context.Context) error {
+			return postChunks(secondaryConfig, readings, func(readings []*api.DataReading) error {
+				return postDataWithRetry(ctx, secondaryConfig, secondaryClient, readings)
+			})
 		})
+
+		report := compareDestinations(primary, secondary)
+		stats.record(report)
+		logDualWriteReport(report, stats)
+
+		// only the primary destination is allowed to halt the agent
+		if primary.Err != nil {
+			log.Fatalf("%v", primary.Err)
+		}
+	} else {
+		// once an upload is spooled, the following ones are spooled too so
+		// that they are sent in order
+		post := func(readings []*api.DataReading) error {
+			return uploads.upload(readings, func(readings []*api.DataReading) error {
+				return postAndRecord(ctx, config, preflightClient, readings, health)
+			})
+		}
+		err := postChunks(config, readings, post)
+		if err == nil {
+			err = streamChunks(ctx, config, streamed, health, post)
+		}
 		if err != nil {
 			log.Fatalf("%v", err)
 		}
+	}
+
+	commitDeltas(config, dataGatherers, readings)
+}
 
+// commitDeltas commits the changes returned by the data gatherers whose
+// readings were delivered, i.e. uploaded, spooled or written out. The changes
+// of the other data gatherers are returned again by their next Fetch.
+func commitDeltas(config Config, dataGatherers map[string]datagatherer.DataGatherer, readings []*api.DataReading) {
+	delivered := map[readingSource]bool{}
+	for _, reading := range readings {
+		if reading.Error == "" {
+			delivered[readingSource{dataGatherer: reading.DataGatherer, clusterID: reading.ClusterID}] = true
+		}
 	}
+	for name, dg := range dataGatherers {
+		committer, ok := dg.(datagatherer.DeltaCommitter)
+		if !ok {
+			continue
+		}
+		dataGatherer, clusterID := readingIdentity(config, name)
+		if delivered[readingSource{dataGatherer: dataGatherer, clusterID: clusterID}] {
+			committer.Commit()
+		}
+	}
+}
+
+// writeOutputs writes the readings to all the additional outputs. Failing
+// outputs do not prevent the readings from being sent to the backend.
+func writeOutputs(config Config, readings []*api.DataReading, outputs map[string]output.Output) {
+	if len(outputs) == 0 {
+		return
+	}
+
+	payload := &api.DataReadingsPost{
+		AgentMetadata: &api.AgentMetadata{
+			Version:   version.PreflightVersion,
+			ClusterID: config.ClusterID,
+			Cluster:   config.identity.get(),
+		},
+		DataGatherTime: time.Now().UTC(),
+		DataReadings:   readings,
+	}
+
+	for name, out := range outputs {
+		if err := out.Write(payload); err != nil {
+			logs.Log.Errorf("failed to write readings to %q output: %v", name, err)
+			continue
+		}
+		logs.Log.Infof("readings written to %q output", name)
+	}
+}
+
+// selectOutputs returns the outputs with the given names, in the order of the
+// configuration, or all the outputs if no names are given.
+func selectOutputs(outputs []Output, names []string) ([]Output, error) {
+	if len(names) == 0 {
+		return outputs, nil
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var selected []Output
+	for _, o := range outputs {
+		if wanted[o.Name] {
+			selected = append(selected, o)
+			delete(wanted, o.Name)
+		}
+	}
+	if len(wanted) > 0 {
+		var unknown []string
+		for name := range wanted {
+			unknown = append(unknown, name)
+		}
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown output(s) selected: %s", strings.Join(unknown, ", "))
+	}
+	return selected, nil
 }
 
-func gatherData(config Config, dataGatherers map[string]datagatherer.DataGatherer) []*api.DataReading {
+// selectDataGatherers returns the data gatherers with the given names, or all
+// the data gatherers if no names are given, except the skipped ones. The
+// order of the configuration is kept.
+func selectDataGatherers(dataGatherers []DataGatherer, names, skipped []string) ([]DataGatherer, error) {
+	known := map[string]bool{}
+	for _, dg := range dataGatherers {
+		known[dg.Name] = true
+	}
+	var unknown []string
+	for _, name := range append(append([]string{}, names...), skipped...) {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown data gatherer(s) selected: %s", strings.Join(unknown, ", "))
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	skip := map[string]bool{}
+	for _, name := range skipped {
+		skip[name] = true
+	}
+	var selected []DataGatherer
+	for _, dg := range dataGatherers {
+		if (len(names) == 0 || wanted[dg.Name]) && !skip[dg.Name] {
+			selected = append(selected, dg)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no data gatherer selected")
+	}
+	return selected, nil
+}
+
+func dataGathererNames(dataGatherers []DataGatherer) []string {
+	var names []string
+	for _, dg := range dataGatherers {
+		names = append(names, dg.Name)
+	}
+	return names
+}
+
+// uploadsToBackend returns whether the readings are uploaded to the backend.
+// They always are when no backend output is configured, otherwise only if a
+// backend output is selected.
+func uploadsToBackend(outputs, selected []Output) bool {
+	hasBackend := func(outputs []Output) bool {
+		for _, o := range outputs {
+			if o.Kind == backendOutputKind {
+				return true
+			}
+		}
+		return false
+	}
+	return !hasBackend(outputs) || hasBackend(selected)
+}
+
+// postDataWithRetry posts the readings, retrying with an exponential backoff
+// for up to BackoffMaxTime. The intervals are randomized by up to 50% so that
+// the agents do not all retry at once after an outage of the backend.
+func postDataWithRetry(ctx context.Context, config Config, preflightClient client.Client, readings []*api.DataReading) error {
+	ctx, span := tracing.Start(ctx, "upload")
+	defer span.End()
+	span.SetAttributes(attribute.String("server", config.Server))
+	attempts := 0
+
+	backOff := backoff.NewExponentialBackOff()
+	backOff.InitialInterval = 30 * time.Second
+	backOff.MaxInterval = 3 * time.Minute
+	backOff.MaxElapsedTime = BackoffMaxTime
+	backOff.RandomizationFactor = 0.5
+	post := func() error {
+		attempts++
+		return postData(ctx, config, preflightClient, readings)
+	}
+	err := backoff.RetryNotify(post, backOff, func(err error, t time.Duration) {
+		logs.FromContext(ctx).Warnf("retrying in %v after error: %s", t, err)
+	})
+	span.SetAttributes(attribute.Int("attempts", attempts))
+	tracing.RecordError(span, err)
+	return err
+}
+
+// postAndRecord posts the readings with retries, and records the successful
+// uploads for the readiness of the agent.
+func postAndRecord(ctx context.Context, config Config, preflightClient client.Client, readings []*api.DataReading, health *healthTracker) error {
+	err := postDataWithRetry(ctx, config, preflightClient, readings)
+	if err == nil {
+		health.uploaded(time.Now())
+	}
+	return err
+}
+
+func gatherData(ctx context.Context, config Config, dataGatherers map[string]datagatherer.DataGatherer, health *healthTracker, inFlight *inFlightFetches) []*api.DataReading {
 	readings := []*api.DataReading{}
 
+	// the profile has already been validated when parsing the config
+	profile, err := k8s.GetAnonymizationProfile(config.AnonymizationProfile)
+	if err != nil {
+		logs.FromContext(ctx).Fatalf("failed to load anonymization profile: %v", err)
+	}
+
+	kinds := dataGathererKinds(config)
+
+	results := fetchAll(ctx, dataGatherers, inFlight, config.fetchConcurrency(), config.fetchTimeout())
+
 	var dgError *multierror.Error
-	for k, dg := range dataGatherers {
-		dgData, err := dg.Fetch()
+	for _, k := range sortedNames(dataGatherers) {
+		dg, result := dataGatherers[k], results[k]
+		log := logs.FromContext(ctx).WithField(logs.DataGathererField, k)
+		dgData, err := result.data, result.err
+		if err == nil {
+			err = profile.AnonymizeData(dgData)
+		}
+		if err == nil {
+			_, clusterID := readingIdentity(config, k)
+			err = config.hashers.hashData(clusterID, dgData)
+		}
+		if err == nil && config.Provenance {
+			provenance := newProvenance(config, k, kinds[k], dg, profile, result.gatheredAt)
+			// the resources may be held by the cache of the data gatherer,
+			// copies of them are annotated
+			err = k8s.ReplaceGatheredResources(dgData, func(item *api.GatheredResource) (*api.GatheredResource, error) {
+				annotated := *item
+				annotated.Provenance = provenance
+				return &annotated, nil
+			})
+		}
 		if err != nil {
+			h := health.failure(k, err)
+			metrics.ObserveFetch(k, result.duration, 0, -1, err)
+			readings = append(readings, failedReading(config, k, kinds[k], dg, err, h))
 			if _, ok := err.(*dgerror.ConfigError); ok {
 				if StrictMode {
 					dgError = multierror.Append(dgError, fmt.Errorf("%s: %v", k, err))
 				} else {
-					log.Printf("config error in %q datagatherer: %v", k, err)
+					log.Errorf("config error in %q datagatherer: %v", k, err)
 				}
 			} else {
+				// the readings of the other data gatherers are still sent
+				log.Errorf("failed to gather data from %q datagatherer: %v", k, err)
 				dgError = multierror.Append(dgError, fmt.Errorf("error in datagatherer %q: %v", k, err))
 			}
 			continue
 		} else {
-			log.Printf("successfully gathered data from %q datagatherer", k)
+			log.Infof("successfully gathered data from %q datagatherer", k)
 
-			readings = append(readings, &api.DataReading{
-				ClusterID:     config.ClusterID,
-				DataGatherer:  k,
+			name, clusterID := readingIdentity(config, k)
+			reading := &api.DataReading{
+				ClusterID:     clusterID,
+				DataGatherer:  name,
 				Timestamp:     api.Time{Time: time.Now()},
 				Data:          dgData,
 				SchemaVersion: schemaVersion,
-			})
+			}
+			stampDataVersion(reading, kinds[k])
+			markDegraded(log, k, dg, reading)
+			reading.Issues = gathererIssues(dg)
+			items := countGatheredResources(dgData)
+			reading.Health = health.success(k, items, reading.DegradedReason)
+			size := int64(-1)
+			if MetricsAddress != "" {
+				// encoding the data is only worth it if the metrics are served
+				size = jsonSize(dgData)
+			}
+			metrics.ObserveFetch(k, result.duration, items, size, nil)
+			readings = append(readings, reading)
 		}
 	}
 
@@ -320,19 +967,127 @@ func gatherData(config Config, dataGatherers map[string]datagatherer.DataGathere
 	}
 
 	if StrictMode && dgError.ErrorOrNil() != nil {
-		log.Fatalf("halting datagathering in strict mode due to error: %s", dgError.ErrorOrNil())
+		logs.FromContext(ctx).Fatalf("halting datagathering in strict mode due to error: %s", dgError.ErrorOrNil())
 	}
 
 	return readings
 }
 
-func postData(config Config, preflightClient client.Client, readings []*api.DataReading) error {
+// sortedNames returns the names of the data gatherers in order, so that the
+// readings are always in the same order.
+func sortedNames(dataGatherers map[string]datagatherer.DataGatherer) []string {
+	names := make([]string, 0, len(dataGatherers))
+	for name := range dataGatherers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// markDegraded flags the reading if the data gatherer reports that its data
+// may be stale.
+func markDegraded(log *logrus.Entry, name string, dg datagatherer.DataGatherer, reading *api.DataReading) {
+	if err := dg.Healthy(); err != nil {
+		log.Warnf("data from %q datagatherer may be stale: %v", name, err)
+		reading.Degraded = true
+		reading.DegradedReason = err.Error()
+	}
+}
+
+// expectedItems returns the names of the data gatherers expected to return
+// resources.
+func expectedItems(config Config) map[string]bool {
+	expected := map[string]bool{}
+	for _, dg := range config.DataGatherers {
+		if dg.ExpectItems {
+			expected[dg.Name] = true
+		}
+	}
+	return expected
+}
+
+// countGatheredResources returns the number of resources in the output of a
+// data gatherer's Fetch.
+func countGatheredResources(data interface{}) int {
+	count := 0
+	_ = k8s.VisitGatheredResources(data, func(*api.GatheredResource) error {
+		count++
+		return nil
+	})
+	return count
+}
+
+// jsonSize returns the size of the data encoded as JSON, or -1 if it cannot
+// be encoded.
+func jsonSize(data interface{}) int64 {
+	w := &countingWriter{}
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		return -1
+	}
+	return w.n
+}
+
+// countingWriter counts the bytes written to it and discards them.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// dataGathererKinds maps the name of each data gatherer to its kind.
+func dataGathererKinds(config Config) map[string]string {
+	kinds := map[string]string{}
+	for _, dgConfig := range config.DataGatherers {
+		kinds[dgConfig.Name] = dgConfig.Kind
+	}
+	return kinds
+}
+
+// newProvenance describes how the resources of a data gatherer were gathered.
+func newProvenance(config Config, name, kind string, dg datagatherer.DataGatherer, profile *k8s.AnonymizationProfile, gatheredAt time.Time) *api.Provenance {
+	name, clusterID := readingIdentity(config, name)
+	provenance := &api.Provenance{
+		DataGatherer:         name,
+		DataGathererKind:     kind,
+		AgentVersion:         version.PreflightVersion,
+		ClusterID:            clusterID,
+		GatheredAt:           api.Time{Time: gatheredAt},
+		AnonymizationProfile: profile.Name,
+		HashedIdentifiers:    config.hashers.hashedIdentifiers(),
+	}
+	if p, ok := dg.(datagatherer.KubeconfigContextProvider); ok {
+		provenance.KubeconfigContext = p.KubeconfigContext()
+	}
+	return provenance
+}
+
+func postData(ctx context.Context, config Config, preflightClient client.Client, readings []*api.DataReading) (err error) {
+	metrics.UploadAttempts.Inc()
+	defer func() {
+		if err != nil {
+			metrics.UploadFailures.Inc()
+		}
+		config.status.record(ctx, preflightClient, readings, err)
+	}()
+
 	baseURL := config.Server
+	log := logs.FromContext(ctx)
 
-	log.Println("Running Agent...")
-	log.Println("Posting data to:", baseURL)
-	if config.OrganizationID == "" {
+	log.Info("Running Agent...")
+	if config.Backend != nil {
+		log.Infof("Posting data to the %s backend", config.Backend.Kind)
+	} else {
+		log.Infof("Posting data to: %s", baseURL)
+	}
+	// the legacy endpoint is only used by the Jetstack Secure backend
+	if config.OrganizationID == "" && config.Backend == nil {
+		_, span := tracing.Start(ctx, "marshal")
 		data, err := json.Marshal(readings)
+		span.SetAttributes(attribute.Int("size_bytes", len(data)))
+		span.End()
 		if err != nil {
 			log.Fatalf("Cannot marshal readings: %+v", err)
 		}
@@ -345,6 +1100,7 @@ func postData(config Config, preflightClient client.Client, readings []*api.Data
 		if err != nil {
 			return fmt.Errorf("Failed to post data: %+v", err)
 		}
+		client.RecordPayload(ctx, data)
 		if code := res.StatusCode; code < 200 || code >= 300 {
 			errorContent := ""
 			body, _ := ioutil.ReadAll(res.Body)
@@ -353,9 +1109,9 @@ func postData(config Config, preflightClient client.Client, readings []*api.Data
 			}
 			defer res.Body.Close()
 
-			return fmt.Errorf("Received response with status code %d. Body: %s", code, errorContent)
+			return &client.UploadError{StatusCode: code, Body: errorContent}
 		}
-		log.Println("Data sent successfully.")
+		log.Info("Data sent successfully.")
 		return err
 	}
 
@@ -363,11 +1119,15 @@ func postData(config Config, preflightClient client.Client, readings []*api.Data
 		return fmt.Errorf("Post to server failed: missing clusterID from agent configuration")
 	}
 
-	err := preflightClient.PostDataReadings(config.OrganizationID, config.ClusterID, readings)
+	if contextClient, ok := preflightClient.(client.ContextClient); ok {
+		err = contextClient.PostDataReadingsWithContext(ctx, config.OrganizationID, config.ClusterID, readings)
+	} else {
+		err = preflightClient.PostDataReadings(config.OrganizationID, config.ClusterID, readings)
+	}
 	if err != nil {
-		return fmt.Errorf("Post to server failed: %+v", err)
+		return fmt.Errorf("Post to server failed: %w", err)
 	}
-	log.Println("Data sent successfully.")
+	log.Info("Data sent successfully.")
 
 	return nil
 }
@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+func TestSelectOutputs(t *testing.T) {
+	outputs := []Output{
+		{Kind: "backend", Name: "backend"},
+		{Kind: "file", Name: "bundle"},
+		{Kind: "stdout", Name: "stdout"},
+	}
+
+	tests := map[string]struct {
+		outputs        []Output
+		names          []string
+		expected       []string
+		expectedUpload bool
+		expectedErr    string
+	}{
+		"all the outputs by default": {
+			outputs:        outputs,
+			expected:       []string{"backend", "bundle", "stdout"},
+			expectedUpload: true,
+		},
+		"backend not selected": {
+			outputs:  outputs,
+			names:    []string{"stdout", "bundle"},
+			expected: []string{"bundle", "stdout"},
+		},
+		"no backend output configured": {
+			outputs:        outputs[1:],
+			names:          []string{"bundle"},
+			expected:       []string{"bundle"},
+			expectedUpload: true,
+		},
+		"unknown output": {
+			outputs:     outputs,
+			names:       []string{"bundle", "s3", "kafka"},
+			expectedErr: "unknown output(s) selected: kafka, s3",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			selected, err := selectOutputs(test.outputs, test.names)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var names []string
+			for _, o := range selected {
+				names = append(names, o.Name)
+			}
+			if len(names) != len(test.expected) {
+				t.Fatalf("expected outputs %v, got %v", test.expected, names)
+			}
+			for i := range names {
+				if names[i] != test.expected[i] {
+					t.Fatalf("expected outputs %v, got %v", test.expected, names)
+				}
+			}
+			if upload := uploadsToBackend(test.outputs, selected); upload != test.expectedUpload {
+				t.Errorf("expected upload to be %t, got %t", test.expectedUpload, upload)
+			}
+		})
+	}
+}
+
+func TestSelectDataGatherers(t *testing.T) {
+	dataGatherers := []DataGatherer{
+		{Kind: "k8s-dynamic", Name: "k8s/pods"},
+		{Kind: "k8s-dynamic", Name: "k8s/secrets"},
+		{Kind: "gke", Name: "gke"},
+	}
+
+	tests := map[string]struct {
+		names       []string
+		skipped     []string
+		expected    []string
+		expectedErr string
+	}{
+		"all the data gatherers by default": {
+			expected: []string{"k8s/pods", "k8s/secrets", "gke"},
+		},
+		"selected data gatherers": {
+			names:    []string{"gke", "k8s/pods"},
+			expected: []string{"k8s/pods", "gke"},
+		},
+		"skipped data gatherers": {
+			skipped:  []string{"k8s/secrets"},
+			expected: []string{"k8s/pods", "gke"},
+		},
+		"selected and skipped data gatherers": {
+			names:    []string{"gke", "k8s/pods"},
+			skipped:  []string{"gke"},
+			expected: []string{"k8s/pods"},
+		},
+		"unknown data gatherer": {
+			names:       []string{"k8s/pods", "k8s/nodes"},
+			skipped:     []string{"aks"},
+			expectedErr: "unknown data gatherer(s) selected: aks, k8s/nodes",
+		},
+		"no data gatherer left": {
+			skipped:     []string{"k8s/pods", "k8s/secrets", "gke"},
+			expectedErr: "no data gatherer selected",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			selected, err := selectDataGatherers(dataGatherers, test.names, test.skipped)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if names := dataGathererNames(selected); strings.Join(names, ",") != strings.Join(test.expected, ",") {
+				t.Fatalf("expected data gatherers %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+type committingDataGatherer struct {
+	dummyDataGatherer
+	committed bool
+}
+
+func (g *committingDataGatherer) Commit() {
+	g.committed = true
+}
+
+func TestCommitDeltas(t *testing.T) {
+	config := Config{
+		ClusterID: "hub",
+		DataGatherers: []DataGatherer{
+			{Name: "k8s/pods"},
+			{Name: "k8s/secrets@spoke-1", ClusterID: "spoke-1"},
+			{Name: "k8s/secrets@spoke-2", ClusterID: "spoke-2"},
+			{Name: "k8s/nodes"},
+		},
+	}
+	gatherers := map[string]*committingDataGatherer{}
+	dataGatherers := map[string]datagatherer.DataGatherer{}
+	for _, dg := range config.DataGatherers {
+		gatherers[dg.Name] = &committingDataGatherer{}
+		dataGatherers[dg.Name] = gatherers[dg.Name]
+	}
+	readings := []*api.DataReading{
+		{ClusterID: "hub", DataGatherer: "k8s/pods"},
+		{ClusterID: "spoke-1", DataGatherer: "k8s/secrets"},
+		{ClusterID: "spoke-2", DataGatherer: "k8s/secrets", Error: "connection refused"},
+	}
+
+	commitDeltas(config, dataGatherers, readings)
+
+	// only the data gatherers whose readings were delivered are committed
+	expected := map[string]bool{"k8s/pods": true, "k8s/secrets@spoke-1": true}
+	for name, dg := range gatherers {
+		if dg.committed != expected[name] {
+			t.Errorf("unexpected commit of %q: got=%t want=%t", name, dg.committed, expected[name])
+		}
+	}
+}
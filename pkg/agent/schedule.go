@@ -0,0 +1,304 @@
+package agent
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// schedule decides when a data gatherer is fetched next.
+type schedule interface {
+	// next returns the first time the data gatherer is due after t.
+	next(t time.Time) time.Time
+}
+
+// parseSchedule parses the schedule of a data gatherer, which is either an
+// interval, e.g. 1h, or a cron expression, e.g. "0 * * * *".
+func parseSchedule(s string) (schedule, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("interval %q must be positive", s)
+		}
+		return intervalSchedule(d), nil
+	}
+	cron, err := parseCron(s)
+	if err != nil {
+		return nil, err
+	}
+	if cron.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", s)
+	}
+	return cron, nil
+}
+
+// intervalSchedule is due every interval.
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// cronDescriptors are the shorthands supported in place of cron expressions.
+var cronDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// cronSchedule is a standard five fields cron expression: minute, hour, day
+// of month, month and day of week. Each field is a set of the values it
+// matches.
+type cronSchedule struct {
+	minutes, hours, daysOfMonth, months, daysOfWeek map[int]bool
+	// anyDayOfMonth and anyDayOfWeek are set when the field is a *, as a
+	// day matches if either day field matches when both are restricted.
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// parseCron parses a cron expression. Fields can be *, values, ranges and
+// lists of them, with an optional step, e.g. "*/15 9-17 * * 1-5".
+func parseCron(s string) (*cronSchedule, error) {
+	if expr, ok := cronDescriptors[s]; ok {
+		s = expr
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q is neither an interval nor a cron expression with 5 fields", s)
+	}
+
+	bounds := []struct {
+		name     string
+		min, max int
+	}{
+		{"minute", 0, 59},
+		{"hour", 0, 23},
+		{"day of month", 1, 31},
+		{"month", 1, 12},
+		{"day of week", 0, 7},
+	}
+	sets := make([]map[int]bool, 5)
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in cron expression %q: %v", bounds[i].name, s, err)
+		}
+		sets[i] = set
+	}
+	// 7 is an alias for Sunday
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+
+	return &cronSchedule{
+		minutes:       sets[0],
+		hours:         sets[1],
+		daysOfMonth:   sets[2],
+		months:        sets[3],
+		daysOfWeek:    sets[4],
+		anyDayOfMonth: fields[2] == "*",
+		anyDayOfWeek:  fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			if high, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", bounds[1])
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			low, high = value, value
+			if step > 1 {
+				// a value with a step starts a range, as in 5/15
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// maxCronSearch bounds the search of the next matching time, expressions as
+// "0 0 31 2 *" never match.
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+func (s *cronSchedule) next(t time.Time) time.Time {
+	// the next whole minute after t
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxCronSearch)
+	for t.Before(limit) {
+		switch {
+		case !s.months[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hours[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.daysOfMonth[t.Day()]
+	dayOfWeek := s.daysOfWeek[int(t.Weekday())]
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// scheduler selects the data gatherers due on each cycle of the agent. The
+// data gatherers without a schedule are due on every cycle.
+type scheduler struct {
+	schedules map[string]schedule
+
+	mu   sync.Mutex
+	next map[string]time.Time
+	// requested are the data gatherers due on the next cycle whatever their
+	// schedule, as asked by the backend.
+	requested map[string]bool
+}
+
+// newScheduler returns a scheduler for the data gatherers, their schedules
+// have already been validated when parsing the config.
+func newScheduler(dataGatherers []DataGatherer) *scheduler {
+	s := &scheduler{
+		schedules: map[string]schedule{},
+		next:      map[string]time.Time{},
+		requested: map[string]bool{},
+	}
+	for _, dg := range dataGatherers {
+		if dg.Schedule == "" {
+			continue
+		}
+		if sched, err := parseSchedule(dg.Schedule); err == nil {
+			s.schedules[dg.Name] = sched
+		}
+	}
+	return s
+}
+
+// due returns the data gatherers due at now, and schedules their next fetch.
+// Every data gatherer is due on the first cycle.
+func (s *scheduler) due(dataGatherers map[string]datagatherer.DataGatherer, now time.Time) map[string]datagatherer.DataGatherer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := map[string]datagatherer.DataGatherer{}
+	for name, dg := range dataGatherers {
+		sched, ok := s.schedules[name]
+		if !ok {
+			due[name] = dg
+			continue
+		}
+		if next, ok := s.next[name]; ok && now.Before(next) && !s.requested[name] {
+			continue
+		}
+		due[name] = dg
+		s.next[name] = sched.next(now)
+	}
+	s.requested = map[string]bool{}
+	return due
+}
+
+// update replaces the schedules of the previous data gatherers with the ones
+// of the reloaded configuration. The data gatherers whose schedule did not
+// change keep their next fetch, the other ones are due on the next cycle.
+func (s *scheduler) update(previous, dataGatherers []DataGatherer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previousSchedules := map[string]string{}
+	for _, dg := range previous {
+		previousSchedules[dg.Name] = dg.Schedule
+	}
+	s.schedules = map[string]schedule{}
+	next := map[string]time.Time{}
+	for _, dg := range dataGatherers {
+		if dg.Schedule == "" {
+			continue
+		}
+		sched, err := parseSchedule(dg.Schedule)
+		if err != nil {
+			continue
+		}
+		s.schedules[dg.Name] = sched
+		if t, ok := s.next[dg.Name]; ok && previousSchedules[dg.Name] == dg.Schedule {
+			next[dg.Name] = t
+		}
+	}
+	s.next = next
+}
+
+// add schedules a data gatherer started while the agent runs, it is due on
+// the next cycle.
+func (s *scheduler) add(dg DataGatherer) {
+	if dg.Schedule == "" {
+		return
+	}
+	sched, err := parseSchedule(dg.Schedule)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules[dg.Name] = sched
+}
+
+// request makes the data gatherers due on the next cycle.
+func (s *scheduler) request(names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range names {
+		s.requested[name] = true
+	}
+}
+
+// jitterRand is seeded so that every agent picks different delays.
+var jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// withJitter adds a random delay of up to jitter to the period, so that the
+// agents started together do not all upload at the same time.
+func withJitter(period, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return period
+	}
+	return period + time.Duration(jitterRand.Int63n(int64(jitter)))
+}
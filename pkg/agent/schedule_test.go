@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+func TestParseSchedule(t *testing.T) {
+	start := time.Date(2021, time.March, 16, 18, 22, 15, 0, time.UTC)
+	tests := map[string]struct {
+		schedule     string
+		expectedNext time.Time
+		expectedErr  string
+	}{
+		"interval": {
+			schedule:     "1h",
+			expectedNext: start.Add(time.Hour),
+		},
+		"every minute": {
+			schedule:     "* * * * *",
+			expectedNext: time.Date(2021, time.March, 16, 18, 23, 0, 0, time.UTC),
+		},
+		"hourly": {
+			schedule:     "@hourly",
+			expectedNext: time.Date(2021, time.March, 16, 19, 0, 0, 0, time.UTC),
+		},
+		"steps and ranges": {
+			schedule:     "*/15 9-17 * * *",
+			expectedNext: time.Date(2021, time.March, 17, 9, 0, 0, 0, time.UTC),
+		},
+		"day of week": {
+			// 2021-03-16 is a Tuesday
+			schedule:     "30 2 * * 0",
+			expectedNext: time.Date(2021, time.March, 21, 2, 30, 0, 0, time.UTC),
+		},
+		"sunday as 7": {
+			schedule:     "30 2 * * 7",
+			expectedNext: time.Date(2021, time.March, 21, 2, 30, 0, 0, time.UTC),
+		},
+		"day of month or day of week": {
+			schedule:     "0 0 1 * 3",
+			expectedNext: time.Date(2021, time.March, 17, 0, 0, 0, 0, time.UTC),
+		},
+		"list and next month": {
+			schedule:     "0 0 1,15 * *",
+			expectedNext: time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC),
+		},
+		"negative interval": {
+			schedule:    "-1h",
+			expectedErr: `interval "-1h" must be positive`,
+		},
+		"wrong number of fields": {
+			schedule:    "* * *",
+			expectedErr: `"* * *" is neither an interval nor a cron expression with 5 fields`,
+		},
+		"out of range": {
+			schedule:    "60 * * * *",
+			expectedErr: `invalid minute in cron expression "60 * * * *": "60" is out of range 0-59`,
+		},
+		"invalid step": {
+			schedule:    "*/0 * * * *",
+			expectedErr: `invalid minute in cron expression "*/0 * * * *": invalid step "0"`,
+		},
+		"never matches": {
+			schedule:    "0 0 31 2 *",
+			expectedErr: `cron expression "0 0 31 2 *" never matches`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sched, err := parseSchedule(test.schedule)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if next := sched.next(start); !next.Equal(test.expectedNext) {
+				t.Errorf("expected next %s, got %s", test.expectedNext, next)
+			}
+		})
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	s := newScheduler([]DataGatherer{
+		{Name: "secrets", Schedule: "1h"},
+		{Name: "pods"},
+	})
+	dataGatherers := map[string]datagatherer.DataGatherer{
+		"secrets": &dummyDataGatherer{},
+		"pods":    &dummyDataGatherer{},
+	}
+
+	now := time.Now()
+	for i, test := range []struct {
+		at       time.Time
+		expected []string
+	}{
+		{at: now, expected: []string{"secrets", "pods"}},
+		{at: now.Add(time.Minute), expected: []string{"pods"}},
+		{at: now.Add(time.Hour), expected: []string{"secrets", "pods"}},
+		{at: now.Add(time.Hour + time.Minute), expected: []string{"pods"}},
+	} {
+		due := s.due(dataGatherers, test.at)
+		if len(due) != len(test.expected) {
+			t.Errorf("cycle %d: expected %v to be due, got %d data gatherers", i, test.expected, len(due))
+		}
+		for _, name := range test.expected {
+			if _, ok := due[name]; !ok {
+				t.Errorf("cycle %d: expected %q to be due", i, name)
+			}
+		}
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	if d := withJitter(time.Minute, 0); d != time.Minute {
+		t.Errorf("expected no jitter, got %s", d)
+	}
+	for i := 0; i < 100; i++ {
+		if d := withJitter(time.Minute, 10*time.Second); d < time.Minute || d >= time.Minute+10*time.Second {
+			t.Fatalf("expected a period between 1m and 1m10s, got %s", d)
+		}
+	}
+}
+
+func TestSchedulerUpdate(t *testing.T) {
+	previous := []DataGatherer{
+		{Name: "secrets", Schedule: "1h"},
+		{Name: "pods", Schedule: "1h"},
+	}
+	s := newScheduler(previous)
+	dataGatherers := map[string]datagatherer.DataGatherer{
+		"secrets": &dummyDataGatherer{},
+		"pods":    &dummyDataGatherer{},
+	}
+	now := time.Now()
+	s.due(dataGatherers, now)
+
+	// the schedule of secrets is unchanged, the one of pods changed
+	s.update(previous, []DataGatherer{
+		{Name: "secrets", Schedule: "1h"},
+		{Name: "pods", Schedule: "2h"},
+	})
+	due := s.due(dataGatherers, now.Add(time.Minute))
+	if _, ok := due["secrets"]; ok {
+		t.Errorf("expected secrets to keep its next fetch")
+	}
+	if _, ok := due["pods"]; !ok {
+		t.Errorf("expected pods to be due after its schedule changed")
+	}
+	if due := s.due(dataGatherers, now.Add(time.Hour+time.Minute)); len(due) != 1 {
+		t.Errorf("expected only secrets to be due, got %d data gatherers", len(due))
+	}
+}
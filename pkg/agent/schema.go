@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/jetstack/preflight/pkg/client"
+	"gopkg.in/yaml.v2"
+)
+
+// kindConfig is a data gatherer, output or backend of the configuration,
+// whose config is decoded according to its kind.
+type kindConfig struct {
+	Kind      string      `yaml:"kind"`
+	Name      string      `yaml:"name"`
+	RawConfig interface{} `yaml:"config"`
+}
+
+// ValidateConfigSchema checks the configuration against the schema of the
+// agent configuration, and of the configurations of the kinds of its data
+// gatherers, outputs and backend. Unknown fields, e.g. misspelled ones, and
+// values of the wrong type are reported, while ParseConfig ignores them.
+func ValidateConfigSchema(data []byte) error {
+	var result *multierror.Error
+
+	data, err := expandTemplate(data, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+
+	// the configurations of the kinds are checked below, as ParseConfig
+	// decodes them leniently
+	if err := yaml.UnmarshalStrict(data, &Config{}); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	raw := struct {
+		DataGatherers []kindConfig `yaml:"data-gatherers"`
+		Outputs       []kindConfig `yaml:"outputs"`
+		Backend       *kindConfig  `yaml:"backend"`
+	}{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return multierror.Append(result, err).ErrorOrNil()
+	}
+
+	for i, dg := range raw.DataGatherers {
+		cfg, err := newDataGathererConfig(dg.Kind)
+		if err != nil {
+			// already reported when decoding the configuration
+			continue
+		}
+		if err := strictReMarshal(dg.RawConfig, cfg); err != nil {
+			result = multierror.Append(result, fmt.Errorf("datagatherer %d/%d %q: %v", i+1, len(raw.DataGatherers), dg.Name, err))
+		}
+	}
+
+	for i, o := range raw.Outputs {
+		if o.Kind == backendOutputKind {
+			continue
+		}
+		cfg, err := newOutputConfig(o.Kind)
+		if err != nil {
+			continue
+		}
+		if err := strictReMarshal(o.RawConfig, cfg); err != nil {
+			result = multierror.Append(result, fmt.Errorf("output %d/%d %q: %v", i+1, len(raw.Outputs), o.Name, err))
+		}
+	}
+
+	if raw.Backend != nil {
+		if cfg, err := client.NewBackendConfig(raw.Backend.Kind); err == nil {
+			if err := strictReMarshal(raw.Backend.RawConfig, cfg); err != nil {
+				result = multierror.Append(result, fmt.Errorf("backend %q: %v", raw.Backend.Kind, err))
+			}
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// strictReMarshal is reMarshal, failing on the fields config does not have.
+func strictReMarshal(rawConfig interface{}, config interface{}) error {
+	if rawConfig == nil {
+		return nil
+	}
+	bb, err := yaml.Marshal(rawConfig)
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(bb, config)
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigSchema(t *testing.T) {
+	valid := `
+server: "http://localhost:8080"
+period: 1h
+organization_id: "example"
+cluster_id: "example-cluster"
+data-gatherers:
+- kind: "k8s-dynamic"
+  name: "k8s/pods"
+  config:
+    resource-type:
+      version: v1
+      resource: pods
+outputs:
+- kind: "file"
+  name: "bundle"
+  config:
+    path: readings.json
+- kind: "backend"
+  name: "backend"
+`
+	if err := ValidateConfigSchema([]byte(valid)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invalid := `
+server: "http://localhost:8080"
+organisation_id: "example"
+cluster_id: "example-cluster"
+data-gatherers:
+- kind: "k8s-dynamic"
+  name: "k8s/pods"
+  config:
+    resource-type:
+      version: v1
+      resource: pods
+    include-namespace: [default]
+outputs:
+- kind: "file"
+  name: "bundle"
+  config:
+    path: readings.json
+    max-backup: 3
+`
+	err := ValidateConfigSchema([]byte(invalid))
+	if err == nil {
+		t.Fatalf("expected the unknown fields to be reported")
+	}
+	for _, field := range []string{"organisation_id", "include-namespace", "max-backup"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("expected the unknown field %q to be reported, got: %v", field, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+// Signing signs every upload of readings with a detached attestation, so
+// that the backend can verify their integrity and origin.
+type Signing struct {
+	// PrivateKeyFile is the path of the PEM encoded ECDSA, RSA or Ed25519
+	// private key the uploads are signed with.
+	PrivateKeyFile string `yaml:"private-key-file,omitempty"`
+	// Keyless signs the uploads with ephemeral keys certified by Fulcio for
+	// the identity of an OIDC token, instead of a private key.
+	Keyless *Keyless `yaml:"keyless,omitempty"`
+}
+
+// Keyless is the configuration of keyless signing.
+type Keyless struct {
+	// FulcioURL is the URL of the Fulcio certificate authority. Defaults to
+	// the public Sigstore instance.
+	FulcioURL string `yaml:"fulcio-url,omitempty"`
+	// IdentityTokenFile is the path of the OIDC token, usually a projected
+	// ServiceAccount token with the sigstore audience. Defaults to the
+	// ServiceAccount token of the pod.
+	IdentityTokenFile string `yaml:"identity-token-file,omitempty"`
+}
+
+func (s *Signing) validate() error {
+	switch {
+	case s.PrivateKeyFile != "" && s.Keyless != nil:
+		return fmt.Errorf("signing cannot use both private-key-file and keyless")
+	case s.PrivateKeyFile == "" && s.Keyless == nil:
+		return fmt.Errorf("signing requires private-key-file or keyless")
+	}
+	if s.Keyless != nil && s.Keyless.FulcioURL != "" && !isValidServerURL(s.Keyless.FulcioURL) {
+		return fmt.Errorf("signing.keyless.fulcio-url is not a valid URL")
+	}
+	return nil
+}
+
+// setSigner makes the client sign its uploads. The private key is loaded
+// once to report a misconfiguration on start.
+func (s *Signing) setSigner(preflightClient client.Client) error {
+	signingClient, ok := preflightClient.(client.SigningClient)
+	if !ok {
+		return fmt.Errorf("the client does not support signing")
+	}
+
+	if s.Keyless != nil {
+		identityTokenFile := s.Keyless.IdentityTokenFile
+		if identityTokenFile == "" {
+			identityTokenFile = defaultSubjectTokenFile
+		}
+		signingClient.SetSigner(client.NewKeylessSigner(s.Keyless.FulcioURL, identityTokenFile))
+		return nil
+	}
+
+	keyPEM, err := ioutil.ReadFile(s.PrivateKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the signing key: %v", err)
+	}
+	signer, err := client.NewKeySigner(keyPEM)
+	if err != nil {
+		return err
+	}
+	signingClient.SetSigner(signer)
+	return nil
+}
@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/logs"
+)
+
+// snapshotPath is the path the snapshot is served on, the reading of a data
+// gatherer is served on snapshotPath/<name>.
+const snapshotPath = "/snapshot"
+
+// SnapshotEndpoint serves the last reading of every data gatherer, as sent
+// to the backend, so that the tools in the cluster can read the same data as
+// the backend without access to it.
+type SnapshotEndpoint struct {
+	// Address is the address the snapshot is served on, e.g. :8082. It can
+	// be the address of the health or metrics endpoints.
+	Address string `yaml:"address"`
+	// TokenPath is the file of the bearer token the requests must have. It
+	// is read again on every request, so that it can be rotated, e.g. when
+	// mounted from a Secret.
+	TokenPath string `yaml:"token-file"`
+}
+
+func (s *SnapshotEndpoint) validate() error {
+	if s.Address == "" {
+		return fmt.Errorf("snapshot-endpoint.address is required")
+	}
+	if s.TokenPath == "" {
+		return fmt.Errorf("snapshot-endpoint.token-file is required")
+	}
+	return nil
+}
+
+// snapshotResponse is the body of the snapshot endpoint.
+type snapshotResponse struct {
+	Readings []*api.DataReading `json:"readings"`
+}
+
+// readingsSnapshot keeps the last reading of every data gatherer, for each
+// cluster it reads from. It is safe to use a nil readingsSnapshot, which keeps
+// nothing.
+type readingsSnapshot struct {
+	address   string
+	tokenPath string
+
+	mu       sync.RWMutex
+	readings map[readingSource]*api.DataReading
+}
+
+func newReadingsSnapshot(cfg *SnapshotEndpoint) *readingsSnapshot {
+	return &readingsSnapshot{
+		address:   cfg.Address,
+		tokenPath: cfg.TokenPath,
+		readings:  map[readingSource]*api.DataReading{},
+	}
+}
+
+// update keeps the readings of a cycle. The readings of the data gatherers
+// not fetched in the cycle, e.g. because of their schedule, are kept.
+func (s *readingsSnapshot) update(readings []*api.DataReading) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, reading := range readings {
+		s.readings[readingSource{dataGatherer: reading.DataGatherer, clusterID: reading.ClusterID}] = reading
+	}
+}
+
+// remove forgets the reading of a data gatherer in a cluster, e.g. removed on
+// reload.
+func (s *readingsSnapshot) remove(name, clusterID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.readings, readingSource{dataGatherer: name, clusterID: clusterID})
+}
+
+// register adds the snapshot endpoints to the mux. /snapshot serves the
+// readings of all the data gatherers, and /snapshot/<name> the reading of a
+// data gatherer, e.g. /snapshot/k8s/pods, or /snapshot/<name>@<cluster-id>
+// the reading of a data gatherer in one of the clusters it reads from.
+func (s *readingsSnapshot) register(mux *http.ServeMux) {
+	mux.HandleFunc(snapshotPath, s.serve)
+	mux.HandleFunc(snapshotPath+"/", s.serve)
+}
+
+func (s *readingsSnapshot) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.authorize(r); err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var body interface{}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, snapshotPath), "/")
+	s.mu.RLock()
+	if name == "" {
+		response := snapshotResponse{Readings: make([]*api.DataReading, 0, len(s.readings))}
+		for _, reading := range s.readings {
+			response.Readings = append(response.Readings, reading)
+		}
+		sort.Slice(response.Readings, func(i, j int) bool {
+			a, b := response.Readings[i], response.Readings[j]
+			if a.DataGatherer != b.DataGatherer {
+				return a.DataGatherer < b.DataGatherer
+			}
+			return a.ClusterID < b.ClusterID
+		})
+		body = response
+	} else {
+		matches := s.find(name)
+		if len(matches) > 1 {
+			s.mu.RUnlock()
+			http.Error(w, fmt.Sprintf("the data gatherer %q reads from several clusters, use %s/%s%s<cluster-id>", name, snapshotPath, name, clusterSeparator), http.StatusConflict)
+			return
+		}
+		if len(matches) == 1 {
+			body = matches[0]
+		}
+	}
+	s.mu.RUnlock()
+
+	if body == nil {
+		http.Error(w, fmt.Sprintf("no reading of the data gatherer %q", name), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logs.Log.Errorf("failed to write the snapshot response: %v", err)
+	}
+}
+
+// find returns the readings of the data gatherer with the name, in every
+// cluster, or in a single cluster if the name is <name>@<cluster-id>.
+func (s *readingsSnapshot) find(name string) []*api.DataReading {
+	var matches []*api.DataReading
+	for source, reading := range s.readings {
+		if source.dataGatherer == name || source.dataGatherer+clusterSeparator+source.clusterID == name {
+			matches = append(matches, reading)
+		}
+	}
+	return matches
+}
+
+// authorize checks the bearer token of the request against the token file.
+func (s *readingsSnapshot) authorize(r *http.Request) error {
+	data, err := ioutil.ReadFile(s.tokenPath)
+	if err != nil {
+		logs.Log.Errorf("failed to read the token of the snapshot endpoint: %v", err)
+		return fmt.Errorf("unauthorized")
+	}
+	token := strings.TrimSpace(string(data))
+	authorization := r.Header.Get("Authorization")
+	if token == "" || !strings.HasPrefix(authorization, "Bearer ") {
+		return fmt.Errorf("unauthorized")
+	}
+	bearer := strings.TrimPrefix(authorization, "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+		return fmt.Errorf("unauthorized")
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestReadingsSnapshot(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenPath, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snapshot := newReadingsSnapshot(&SnapshotEndpoint{Address: ":8082", TokenPath: tokenPath})
+	snapshot.update([]*api.DataReading{
+		{DataGatherer: "k8s/pods", Data: "pods-1"},
+		{DataGatherer: "k8s/nodes", Data: "nodes-1"},
+	})
+	// the readings of the data gatherers not fetched in a cycle are kept
+	snapshot.update([]*api.DataReading{{DataGatherer: "k8s/pods", Data: "pods-2"}})
+	// the readings of a data gatherer are kept for each cluster
+	snapshot.update([]*api.DataReading{
+		{DataGatherer: "k8s/certs", ClusterID: "spoke-2", Data: "certs-2"},
+		{DataGatherer: "k8s/certs", ClusterID: "spoke-1", Data: "certs-1"},
+		{DataGatherer: "k8s/certs", ClusterID: "spoke-3", Data: "certs-3"},
+	})
+	snapshot.remove("k8s/certs", "spoke-3")
+
+	mux := http.NewServeMux()
+	snapshot.register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := map[string]struct {
+		method         string
+		path           string
+		authorization  string
+		expectedStatus int
+		expectedData   []interface{}
+	}{
+		"all the readings": {
+			path:           "/snapshot",
+			authorization:  "Bearer s3cr3t",
+			expectedStatus: http.StatusOK,
+			expectedData:   []interface{}{"certs-1", "certs-2", "nodes-1", "pods-2"},
+		},
+		"reading of a data gatherer": {
+			path:           "/snapshot/k8s/pods",
+			authorization:  "Bearer s3cr3t",
+			expectedStatus: http.StatusOK,
+			expectedData:   []interface{}{"pods-2"},
+		},
+		"reading of a data gatherer in a cluster": {
+			path:           "/snapshot/k8s/certs@spoke-2",
+			authorization:  "Bearer s3cr3t",
+			expectedStatus: http.StatusOK,
+			expectedData:   []interface{}{"certs-2"},
+		},
+		"data gatherer reading from several clusters": {
+			path:           "/snapshot/k8s/certs",
+			authorization:  "Bearer s3cr3t",
+			expectedStatus: http.StatusConflict,
+		},
+		"removed cluster": {
+			path:           "/snapshot/k8s/certs@spoke-3",
+			authorization:  "Bearer s3cr3t",
+			expectedStatus: http.StatusNotFound,
+		},
+		"unknown data gatherer": {
+			path:           "/snapshot/k8s/secrets",
+			authorization:  "Bearer s3cr3t",
+			expectedStatus: http.StatusNotFound,
+		},
+		"no token": {
+			path:           "/snapshot",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"wrong token": {
+			path:           "/snapshot",
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"not a bearer token": {
+			path:           "/snapshot",
+			authorization:  "s3cr3t",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"read only": {
+			method:         http.MethodPost,
+			path:           "/snapshot",
+			authorization:  "Bearer s3cr3t",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, server.URL+test.path, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != test.expectedStatus {
+				t.Fatalf("expected status %d, got %d", test.expectedStatus, res.StatusCode)
+			}
+			if test.expectedData == nil {
+				return
+			}
+
+			var readings []*api.DataReading
+			if test.path == snapshotPath {
+				var response snapshotResponse
+				err = json.NewDecoder(res.Body).Decode(&response)
+				readings = response.Readings
+			} else {
+				var reading api.DataReading
+				err = json.NewDecoder(res.Body).Decode(&reading)
+				readings = []*api.DataReading{&reading}
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(readings) != len(test.expectedData) {
+				t.Fatalf("expected %d readings, got %d", len(test.expectedData), len(readings))
+			}
+			for i, reading := range readings {
+				if reading.Data != test.expectedData[i] {
+					t.Errorf("expected data %v, got %v", test.expectedData[i], reading.Data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+	"github.com/jetstack/preflight/pkg/encryption"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/metrics"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// defaultSpoolMaxSize and defaultSpoolMaxAge bound the spool unless limits
+// are configured.
+const (
+	defaultSpoolMaxSize = "100Mi"
+	defaultSpoolMaxAge  = 24 * time.Hour
+)
+
+// spoolExtension and encryptedSpoolExtension are the extensions of the
+// plaintext and encrypted spooled uploads. Uploads being written have a
+// temporary name without them.
+const (
+	spoolExtension          = ".json"
+	encryptedSpoolExtension = ".enc"
+)
+
+// Spool configures the directory where the uploads failing after all their
+// retries are queued, so that an outage of the backend does not lose
+// readings. The queued uploads are sent, oldest first, before the readings of
+// the following cycles.
+type Spool struct {
+	// Directory is where the uploads are queued. It should be a persistent
+	// volume for the queue to survive restarts.
+	Directory string `yaml:"directory"`
+	// MaxSize is the maximum size of the queued uploads, e.g. 500Mi. The
+	// oldest uploads are dropped beyond it. Defaults to 100Mi.
+	MaxSize string `yaml:"max-size"`
+	// MaxAge is the maximum age of the queued uploads, older uploads are
+	// dropped. Defaults to 24h.
+	MaxAge time.Duration `yaml:"max-age"`
+	// Encryption encrypts the queued uploads with a key, e.g. mounted from a
+	// Secret, so that the readings are not written to disk in plaintext.
+	Encryption *encryption.Config `yaml:"encryption,omitempty"`
+}
+
+func (s *Spool) validate() error {
+	if s.Directory == "" {
+		return fmt.Errorf("spool.directory is required")
+	}
+	if s.MaxSize != "" {
+		if _, err := resource.ParseQuantity(s.MaxSize); err != nil {
+			return fmt.Errorf("spool.max-size is invalid: %v", err)
+		}
+	}
+	if s.MaxAge < 0 {
+		return fmt.Errorf("spool.max-age cannot be negative")
+	}
+	if s.Encryption != nil {
+		if err := s.Encryption.Validate(); err != nil {
+			return fmt.Errorf("spool.encryption.%v", err)
+		}
+	}
+	return nil
+}
+
+// spool is a queue of uploads on disk. A nil spool queues nothing.
+type spool struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	// cipher encrypts the queued uploads, it is nil if they are not
+	// encrypted.
+	cipher *encryption.Cipher
+
+	// draining serializes the drains, which post the uploads without
+	// holding mu.
+	draining sync.Mutex
+
+	mu sync.Mutex
+	// pending is the number of queued uploads.
+	pending int
+	// seq orders the uploads queued within the same nanosecond.
+	seq int
+}
+
+// newSpool creates the spool directory and loads the uploads queued by a
+// previous run. The config has already been validated.
+func newSpool(cfg *Spool) (*spool, error) {
+	maxSize := cfg.MaxSize
+	if maxSize == "" {
+		maxSize = defaultSpoolMaxSize
+	}
+	quantity, err := resource.ParseQuantity(maxSize)
+	if err != nil {
+		return nil, err
+	}
+	maxAge := cfg.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultSpoolMaxAge
+	}
+	cipher, err := cfg.Encryption.NewCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(cfg.Directory, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %v", err)
+	}
+	s := &spool{
+		dir:     cfg.Directory,
+		maxSize: quantity.Value(),
+		maxAge:  maxAge,
+		cipher:  cipher,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.prune(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// spoolEntry is a queued upload.
+type spoolEntry struct {
+	path    string
+	size    int64
+	created time.Time
+}
+
+// entries returns the queued uploads, oldest first. The names of the files
+// sort in the order they were queued.
+func (s *spool) entries() ([]spoolEntry, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %v", err)
+	}
+	var entries []spoolEntry
+	for _, f := range files {
+		if f.IsDir() || !(strings.HasSuffix(f.Name(), spoolExtension) || strings.HasSuffix(f.Name(), encryptedSpoolExtension)) {
+			continue
+		}
+		entries = append(entries, spoolEntry{
+			path:    filepath.Join(s.dir, f.Name()),
+			size:    f.Size(),
+			created: f.ModTime(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries, nil
+}
+
+// prune drops the uploads older than the maximum age, then the oldest ones
+// until the queue fits the maximum size. It must be called with mu held.
+func (s *spool) prune(now time.Time) error {
+	entries, err := s.entries()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+
+	kept := len(entries)
+	for _, e := range entries {
+		expired := now.Sub(e.created) > s.maxAge
+		if !expired && total <= s.maxSize {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to drop spooled upload: %v", err)
+		}
+		reason := "the spool is full"
+		if expired {
+			reason = "it is too old"
+		}
+		logs.Log.Warnf("Dropped spooled upload %s as %s", filepath.Base(e.path), reason)
+		metrics.SpoolDropped.Inc()
+		total -= e.size
+		kept--
+	}
+	s.pending = kept
+	metrics.SpoolEntries.Set(float64(kept))
+	return nil
+}
+
+// push queues an upload of the readings.
+func (s *spool) push(readings []*api.DataReading) error {
+	data, err := json.Marshal(readings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal readings: %v", err)
+	}
+	extension := spoolExtension
+	if s.cipher != nil {
+		if data, err = s.cipher.Encrypt(data); err != nil {
+			return fmt.Errorf("failed to encrypt readings: %v", err)
+		}
+		extension = encryptedSpoolExtension
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := ioutil.TempFile(s.dir, "upload")
+	if err != nil {
+		return fmt.Errorf("failed to spool upload: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to spool upload: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to spool upload: %v", err)
+	}
+	// the names sort in the order the uploads are queued
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, extension)
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("failed to spool upload: %v", err)
+	}
+
+	return s.prune(time.Now())
+}
+
+// empty returns whether no upload is queued.
+func (s *spool) empty() bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending == 0
+}
+
+// drain sends the queued uploads with post, oldest first. It stops at the
+// first failure, the uploads left are sent on the next drain, except for the
+// uploads the backend rejects which are dropped. It returns the number of
+// uploads sent. The uploads are posted without holding mu, so that uploads
+// can be queued meanwhile, they are only sent on the next drain.
+func (s *spool) drain(post func([]*api.DataReading) error) (int, error) {
+	if s == nil {
+		return 0, nil
+	}
+	s.draining.Lock()
+	defer s.draining.Unlock()
+
+	s.mu.Lock()
+	err := s.prune(time.Now())
+	var entries []spoolEntry
+	if err == nil {
+		entries, err = s.entries()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, e := range entries {
+		data, err := ioutil.ReadFile(e.path)
+		if os.IsNotExist(err) {
+			// the upload was pruned in the meantime
+			continue
+		}
+		if err != nil {
+			return sent, fmt.Errorf("failed to read spooled upload: %v", err)
+		}
+		if strings.HasSuffix(e.path, encryptedSpoolExtension) {
+			// the upload cannot be sent if it was encrypted with a previous
+			// key, or without encryption configured anymore
+			data, err = s.cipher.Decrypt(data)
+		}
+		var readings []*api.DataReading
+		if err != nil {
+			logs.Log.Warnf("Dropped spooled upload %s as it cannot be decrypted: %v", filepath.Base(e.path), err)
+			metrics.SpoolDropped.Inc()
+		} else if err := json.Unmarshal(data, &readings); err != nil {
+			// a corrupted upload would block the queue forever
+			logs.Log.Warnf("Dropped spooled upload %s as it cannot be parsed: %v", filepath.Base(e.path), err)
+			metrics.SpoolDropped.Inc()
+		} else if err := postMigrated(readings, post); rejected(err) {
+			// the backend would reject the upload every time, and block the
+			// queue forever
+			logs.Log.Warnf("Dropped spooled upload %s as it was rejected: %v", filepath.Base(e.path), err)
+			metrics.SpoolDropped.Inc()
+		} else if err != nil {
+			return sent, err
+		} else {
+			sent++
+		}
+		if err := s.remove(e); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// remove removes the sent or dropped upload from the queue.
+func (s *spool) remove(e spoolEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(e.path); err != nil {
+		if os.IsNotExist(err) {
+			// prune already removed and accounted for it
+			return nil
+		}
+		return fmt.Errorf("failed to remove spooled upload: %v", err)
+	}
+	s.pending--
+	metrics.SpoolEntries.Set(float64(s.pending))
+	return nil
+}
+
+// rejected returns true if the backend rejected an upload for what it is,
+// see client.UploadError.Rejected.
+func rejected(err error) bool {
+	var uploadErr *client.UploadError
+	return errors.As(err, &uploadErr) && uploadErr.Rejected()
+}
+
+// postMigrated sends the spooled readings with post, once migrated to the
+// current version of the schema of their data.
+func postMigrated(readings []*api.DataReading, post func([]*api.DataReading) error) error {
+	migrateReadings(readings)
+	return post(readings)
+}
+
+// upload sends the readings with post. The readings are queued instead if
+// uploads are already queued, to keep them in order, or if post fails.
+func (s *spool) upload(readings []*api.DataReading, post func([]*api.DataReading) error) error {
+	if s == nil {
+		return post(readings)
+	}
+	if !s.empty() {
+		if err := s.push(readings); err != nil {
+			return err
+		}
+		logs.Log.Infof("Uploads are queued, readings spooled to %s", s.dir)
+		return nil
+	}
+
+	err := post(readings)
+	if err == nil {
+		return nil
+	}
+	if pushErr := s.push(readings); pushErr != nil {
+		return fmt.Errorf("%v, and %v", err, pushErr)
+	}
+	logs.Log.Warnf("Upload failed, readings spooled to %s: %v", s.dir, err)
+	return nil
+}
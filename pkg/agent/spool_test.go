@@ -0,0 +1,291 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+	"github.com/jetstack/preflight/pkg/encryption"
+)
+
+func newTestSpool(t *testing.T, cfg Spool) (*spool, func()) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg.Directory = dir
+	s, err := newSpool(&cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func readingsOf(name string) []*api.DataReading {
+	return []*api.DataReading{{DataGatherer: name}}
+}
+
+func TestSpoolUpload(t *testing.T) {
+	s, cleanup := newTestSpool(t, Spool{})
+	defer cleanup()
+
+	var sent []string
+	backendUp := false
+	post := func(readings []*api.DataReading) error {
+		if !backendUp {
+			return fmt.Errorf("backend unreachable")
+		}
+		sent = append(sent, readings[0].DataGatherer)
+		return nil
+	}
+
+	// the backend is down: the first upload fails and is spooled, the
+	// second one is spooled behind it
+	for _, name := range []string{"first", "second"} {
+		if err := s.upload(readingsOf(name), post); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n, err := s.drain(post); n != 0 || err == nil {
+		t.Fatalf("expected the drain to fail, got %d %v", n, err)
+	}
+
+	backendUp = true
+	if n, err := s.drain(post); n != 2 || err != nil {
+		t.Fatalf("expected 2 uploads to be sent, got %d %v", n, err)
+	}
+	if err := s.upload(readingsOf("third"), post); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"first", "second", "third"}
+	if fmt.Sprint(sent) != fmt.Sprint(expected) {
+		t.Errorf("unexpected uploads: got=%v want=%v", sent, expected)
+	}
+	if !s.empty() {
+		t.Errorf("expected the spool to be empty")
+	}
+}
+
+func TestSpoolDrainRejected(t *testing.T) {
+	tests := map[string]struct {
+		err          error
+		expectedSent []string
+		expectedLeft bool
+	}{
+		"rejected upload is dropped": {
+			err:          fmt.Errorf("Post to server failed: %w", &client.UploadError{StatusCode: http.StatusRequestEntityTooLarge}),
+			expectedSent: []string{"second"},
+		},
+		"unauthorized upload is kept": {
+			err:          &client.UploadError{StatusCode: http.StatusUnauthorized},
+			expectedLeft: true,
+		},
+		"rate limited upload is kept": {
+			err:          &client.UploadError{StatusCode: http.StatusTooManyRequests},
+			expectedLeft: true,
+		},
+		"server error is kept": {
+			err:          &client.UploadError{StatusCode: http.StatusBadGateway},
+			expectedLeft: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, cleanup := newTestSpool(t, Spool{})
+			defer cleanup()
+			for _, name := range []string{"first", "second"} {
+				if err := s.push(readingsOf(name)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			var sent []string
+			_, err := s.drain(func(readings []*api.DataReading) error {
+				if readings[0].DataGatherer == "first" {
+					return test.err
+				}
+				sent = append(sent, readings[0].DataGatherer)
+				return nil
+			})
+			if (err != nil) != test.expectedLeft {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if fmt.Sprint(sent) != fmt.Sprint(test.expectedSent) {
+				t.Errorf("unexpected uploads: got=%v want=%v", sent, test.expectedSent)
+			}
+			if s.empty() == test.expectedLeft {
+				t.Errorf("unexpected uploads left in the spool: %d", s.pending)
+			}
+		})
+	}
+}
+
+func TestSpoolLimits(t *testing.T) {
+	s, cleanup := newTestSpool(t, Spool{MaxSize: "100"})
+	defer cleanup()
+
+	// each upload is about 80 bytes, only the last one fits
+	for _, name := range []string{"first", "second"} {
+		if err := s.push(readingsOf(name)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	var sent []string
+	post := func(readings []*api.DataReading) error {
+		sent = append(sent, readings[0].DataGatherer)
+		return nil
+	}
+	if _, err := s.drain(post); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(sent) != "[second]" {
+		t.Errorf("expected the oldest upload to be dropped, got %v", sent)
+	}
+
+	s.maxAge = time.Minute
+	if err := s.push(readingsOf("old")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries, err := s.entries()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected an upload to be queued, got %v %v", entries, err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(entries[0].path, old, old); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sent = nil
+	if _, err := s.drain(post); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sent) != 0 || !s.empty() {
+		t.Errorf("expected the old upload to be dropped, got %v", sent)
+	}
+}
+
+func TestSpoolEncryption(t *testing.T) {
+	keyFile, err := ioutil.TempFile("", "spool-key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(keyFile.Name())
+	if _, err := keyFile.Write(bytes.Repeat([]byte{1}, encryption.KeySize)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	keyFile.Close()
+
+	s, cleanup := newTestSpool(t, Spool{Encryption: &encryption.Config{KeyFile: keyFile.Name()}})
+	defer cleanup()
+
+	if err := s.push(readingsOf("k8s/secrets")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries, err := s.entries()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected an upload to be queued, got %v %v", entries, err)
+	}
+	data, err := ioutil.ReadFile(entries[0].path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(data, []byte("k8s/secrets")) {
+		t.Fatalf("the upload was spooled in plaintext: %q", data)
+	}
+
+	var sent []string
+	post := func(readings []*api.DataReading) error {
+		sent = append(sent, readings[0].DataGatherer)
+		return nil
+	}
+	if _, err := s.drain(post); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(sent) != "[k8s/secrets]" {
+		t.Errorf("expected the upload to be decrypted, got %v", sent)
+	}
+
+	// an upload encrypted with another key is dropped
+	if err := s.push(readingsOf("rotated")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.cipher, err = encryption.NewCipher(bytes.Repeat([]byte{2}, encryption.KeySize)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sent = nil
+	if _, err := s.drain(post); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sent) != 0 || !s.empty() {
+		t.Errorf("expected the upload to be dropped, got %v", sent)
+	}
+}
+
+func TestSpoolValidate(t *testing.T) {
+	if err := (&Spool{}).validate(); err == nil {
+		t.Errorf("expected error for missing directory")
+	}
+	if err := (&Spool{Directory: "/tmp", MaxSize: "lots"}).validate(); err == nil {
+		t.Errorf("expected error for invalid max-size")
+	}
+	if err := (&Spool{Directory: "/tmp", Encryption: &encryption.Config{}}).validate(); err == nil {
+		t.Errorf("expected error for missing encryption key")
+	}
+}
+
+func TestSpoolDrainDoesNotBlockUploads(t *testing.T) {
+	s, cleanup := newTestSpool(t, Spool{})
+	defer cleanup()
+	if err := s.push(readingsOf("spooled")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var sent []string
+	post := func(readings []*api.DataReading) error {
+		sent = append(sent, readings[0].DataGatherer)
+		if readings[0].DataGatherer != "spooled" {
+			return nil
+		}
+		// an upload is queued while the spooled one is being posted
+		queued := make(chan error, 1)
+		go func() {
+			if s.empty() {
+				queued <- fmt.Errorf("expected the spool not to be empty")
+				return
+			}
+			queued <- s.push(readingsOf("queued"))
+		}()
+		select {
+		case err := <-queued:
+			return err
+		case <-time.After(time.Second):
+			t.Fatalf("expected uploads to be queued during the drain")
+		}
+		return nil
+	}
+
+	if n, err := s.drain(post); n != 1 || err != nil {
+		t.Fatalf("expected the spooled upload to be sent, got %d %v", n, err)
+	}
+	if s.empty() {
+		t.Fatalf("expected the upload queued during the drain to be kept")
+	}
+	if n, err := s.drain(post); n != 1 || err != nil {
+		t.Fatalf("expected the queued upload to be sent, got %d %v", n, err)
+	}
+
+	expected := []string{"spooled", "queued"}
+	if fmt.Sprint(sent) != fmt.Sprint(expected) {
+		t.Errorf("unexpected uploads: got=%v want=%v", sent, expected)
+	}
+	if !s.empty() {
+		t.Errorf("expected the spool to be empty")
+	}
+}
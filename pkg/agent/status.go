@@ -0,0 +1,182 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/version"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+)
+
+// defaultUploadStatusName is the name of the ConfigMap of the upload status
+// unless configured.
+const defaultUploadStatusName = "jetstack-secure-agent-status"
+
+// uploadStatusTimeout bounds the update of the ConfigMap after an upload.
+const uploadStatusTimeout = 10 * time.Second
+
+var configMapsGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// UploadStatus configures the ConfigMap the agent records the outcome of its
+// uploads in, so that the operators of the cluster can tell when the data
+// last reached the backend with kubectl.
+type UploadStatus struct {
+	// KubeConfigPath is the kubeconfig of the cluster, the in-cluster
+	// configuration is used if it is empty.
+	KubeConfigPath string `yaml:"kubeconfig,omitempty"`
+	// Namespace is the namespace of the ConfigMap. Defaults to the namespace
+	// of the agent.
+	Namespace string `yaml:"namespace,omitempty"`
+	// Name is the name of the ConfigMap. Defaults to
+	// jetstack-secure-agent-status.
+	Name string `yaml:"name,omitempty"`
+}
+
+func (u *UploadStatus) validate() error {
+	if errs := validation.IsDNS1123Subdomain(u.Name); u.Name != "" && len(errs) > 0 {
+		return fmt.Errorf("upload-status.name is not a valid ConfigMap name: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// uploadStatus records the outcome of the uploads in a ConfigMap. A nil
+// uploadStatus records nothing.
+type uploadStatus struct {
+	client          dynamic.Interface
+	namespace, name string
+}
+
+// newUploadStatus returns the recorder of the upload status of the
+// configuration.
+func newUploadStatus(cfg *UploadStatus) (*uploadStatus, error) {
+	namespace := cfg.Namespace
+	if namespace == "" {
+		var ok bool
+		if namespace, ok = templateFuncs["namespace"](os.LookupEnv); !ok || namespace == "" {
+			return nil, fmt.Errorf("upload-status.namespace must be set when the agent does not run in a Pod")
+		}
+	}
+	name := cfg.Name
+	if name == "" {
+		name = defaultUploadStatusName
+	}
+	cl, err := k8s.NewDynamicClient(cfg.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return &uploadStatus{client: cl, namespace: namespace, name: name}, nil
+}
+
+// uploadOutcome is the outcome of an upload of readings.
+type uploadOutcome struct {
+	time     time.Time
+	readings []*api.DataReading
+	// receipt is the receipt ID the backend sent for a successful upload.
+	receipt string
+	err     error
+}
+
+// record records the outcome of an upload of the client. The uploads are not
+// failed when it cannot be recorded.
+func (s *uploadStatus) record(ctx context.Context, preflightClient client.Client, readings []*api.DataReading, err error) {
+	if s == nil {
+		return
+	}
+	outcome := uploadOutcome{time: time.Now(), readings: readings, err: err}
+	if receiptClient, ok := preflightClient.(client.ReceiptClient); ok && err == nil {
+		outcome.receipt = receiptClient.Receipt()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, uploadStatusTimeout)
+	defer cancel()
+	if err := s.update(ctx, outcome); err != nil {
+		logs.FromContext(ctx).Warnf("failed to record the upload status in the ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+}
+
+// update writes the outcome to the ConfigMap, creating it if needed. The
+// details of the last successful upload are kept when an upload fails.
+func (s *uploadStatus) update(ctx context.Context, outcome uploadOutcome) error {
+	configMaps := s.client.Resource(configMapsGVR).Namespace(s.namespace)
+	existing, err := configMaps.Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	data := map[string]string{}
+	if existing != nil {
+		data, _, _ = unstructured.NestedStringMap(existing.Object, "data")
+	}
+	setUploadStatus(data, outcome)
+
+	if existing == nil {
+		configMap := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      s.name,
+				"namespace": s.namespace,
+				"labels":    map[string]interface{}{"app.kubernetes.io/name": "jetstack-secure-agent"},
+			},
+		}}
+		if err := unstructured.SetNestedStringMap(configMap.Object, data, "data"); err != nil {
+			return err
+		}
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err := unstructured.SetNestedStringMap(existing.Object, data, "data"); err != nil {
+		return err
+	}
+	_, err = configMaps.Update(ctx, existing, metav1.UpdateOptions{})
+	return err
+}
+
+// setUploadStatus sets the keys of the outcome in the data of the ConfigMap.
+func setUploadStatus(data map[string]string, outcome uploadOutcome) {
+	timestamp := outcome.time.UTC().Format(time.RFC3339)
+	data["agentVersion"] = version.PreflightVersion
+	data["lastAttemptTime"] = timestamp
+	if outcome.err != nil {
+		data["lastAttemptResult"] = "Failed"
+		data["lastError"] = outcome.err.Error()
+		return
+	}
+	data["lastAttemptResult"] = "Succeeded"
+	delete(data, "lastError")
+	data["lastSuccessTime"] = timestamp
+	data["lastSuccessReadings"] = strconv.Itoa(len(outcome.readings))
+	data["lastSuccessPayloadSHA256"] = payloadHash(outcome.readings)
+	if outcome.receipt != "" {
+		data["lastSuccessReceiptID"] = outcome.receipt
+	} else {
+		delete(data, "lastSuccessReceiptID")
+	}
+}
+
+// payloadHash returns the SHA-256 of the readings encoded as JSON, to match
+// an upload with the readings written by an output.
+func payloadHash(readings []*api.DataReading) string {
+	data, err := json.Marshal(readings)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+// receiptClient is a client whose uploads get the receipt.
+type receiptClient struct {
+	receipt string
+}
+
+func (c *receiptClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
+	return nil
+}
+
+func (c *receiptClient) Post(path string, body io.Reader) (*http.Response, error) {
+	return nil, nil
+}
+
+func (c *receiptClient) Receipt() string {
+	return c.receipt
+}
+
+func TestUploadStatusRecord(t *testing.T) {
+	ctx := context.Background()
+	status := &uploadStatus{
+		client:    fake.NewSimpleDynamicClient(runtime.NewScheme()),
+		namespace: "jetstack-secure",
+		name:      defaultUploadStatusName,
+	}
+	readings := []*api.DataReading{{DataGatherer: "k8s/pods"}, {DataGatherer: "k8s/nodes"}}
+
+	data := func() map[string]string {
+		configMap, err := status.client.Resource(configMapsGVR).Namespace(status.namespace).Get(ctx, status.name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+		return data
+	}
+
+	// the ConfigMap is created by the first upload
+	status.record(ctx, &receiptClient{}, readings, fmt.Errorf("connection refused"))
+	got := data()
+	if got["lastAttemptResult"] != "Failed" || got["lastError"] != "connection refused" {
+		t.Errorf("expected the failure to be recorded, got %v", got)
+	}
+	if _, ok := got["lastSuccessTime"]; ok {
+		t.Errorf("unexpected last success: %v", got)
+	}
+
+	status.record(ctx, &receiptClient{receipt: "r-1"}, readings, nil)
+	got = data()
+	if got["lastAttemptResult"] != "Succeeded" || got["lastSuccessReceiptID"] != "r-1" || got["lastSuccessReadings"] != "2" {
+		t.Errorf("expected the success to be recorded, got %v", got)
+	}
+	if _, ok := got["lastError"]; ok {
+		t.Errorf("expected the last error to be cleared, got %v", got)
+	}
+	if got["lastSuccessPayloadSHA256"] != payloadHash(readings) {
+		t.Errorf("unexpected payload hash %q", got["lastSuccessPayloadSHA256"])
+	}
+	lastSuccess := got["lastSuccessTime"]
+
+	// a failure keeps the details of the last successful upload
+	status.record(ctx, &receiptClient{receipt: "r-2"}, nil, fmt.Errorf("401 Unauthorized"))
+	got = data()
+	if got["lastAttemptResult"] != "Failed" || got["lastSuccessReceiptID"] != "r-1" || got["lastSuccessTime"] != lastSuccess {
+		t.Errorf("expected the last success to be kept, got %v", got)
+	}
+
+	// a nil status records nothing
+	var disabled *uploadStatus
+	disabled.record(ctx, &receiptClient{}, readings, nil)
+}
+
+func TestUploadStatusValidate(t *testing.T) {
+	tests := map[string]struct {
+		status    UploadStatus
+		expectErr bool
+	}{
+		"defaults":     {status: UploadStatus{}},
+		"valid name":   {status: UploadStatus{Name: "agent-status"}},
+		"invalid name": {status: UploadStatus{Name: "Agent_Status"}, expectErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.status.validate()
+			if test.expectErr != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
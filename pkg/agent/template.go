@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// templateReference matches the references expanded in the configuration,
+// ${NAME} or ${NAME:-default}, and the escaped $$.
+var templateReference = regexp.MustCompile(`\$\$|\$\{([^}:]*)(:-([^}]*))?\}`)
+
+var templateName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// serviceAccountNamespacePath is the namespace of the Pod of the agent, as
+// mounted with its service account token.
+var serviceAccountNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// templateFuncs are the values of the configuration derived from the Pod of
+// the agent, set with the downward API. They take precedence over the
+// environment variables of the same name.
+var templateFuncs = map[string]func(lookupEnv func(string) (string, bool)) (string, bool){
+	"namespace": func(lookupEnv func(string) (string, bool)) (string, bool) {
+		if namespace, ok := lookupEnv("POD_NAMESPACE"); ok && namespace != "" {
+			return namespace, true
+		}
+		data, err := ioutil.ReadFile(serviceAccountNamespacePath)
+		if err != nil {
+			return "", false
+		}
+		return strings.TrimSpace(string(data)), true
+	},
+	"podName": func(lookupEnv func(string) (string, bool)) (string, bool) {
+		if name, ok := lookupEnv("POD_NAME"); ok && name != "" {
+			return name, true
+		}
+		// the hostname of a Pod is its name
+		name, err := os.Hostname()
+		return name, err == nil
+	},
+	"nodeName": func(lookupEnv func(string) (string, bool)) (string, bool) {
+		return lookupEnv("NODE_NAME")
+	},
+	"clusterName": func(lookupEnv func(string) (string, bool)) (string, bool) {
+		return lookupEnv("CLUSTER_NAME")
+	},
+}
+
+// expandTemplate replaces the references to environment variables and to the
+// template functions in the configuration, so that a single configuration
+// can be used by the agents of several clusters. A reference to an undefined
+// variable without a default is an error, rather than being silently
+// replaced by an empty string.
+func expandTemplate(data []byte, lookupEnv func(string) (string, bool)) ([]byte, error) {
+	var result *multierror.Error
+	expanded := templateReference.ReplaceAllFunc(data, func(ref []byte) []byte {
+		if string(ref) == "$$" {
+			return []byte("$")
+		}
+		groups := templateReference.FindSubmatch(ref)
+		name, hasDefault, def := string(groups[1]), len(groups[2]) > 0, groups[3]
+		if !templateName.MatchString(name) {
+			result = multierror.Append(result, fmt.Errorf("invalid reference %s", ref))
+			return ref
+		}
+		value, ok := "", false
+		if fn, isFunc := templateFuncs[name]; isFunc {
+			value, ok = fn(lookupEnv)
+		} else {
+			value, ok = lookupEnv(name)
+		}
+		switch {
+		case ok && value != "":
+			return []byte(value)
+		case hasDefault:
+			return def
+		case ok:
+			return nil
+		}
+		result = multierror.Append(result, fmt.Errorf("%s is not defined, set it or give it a default with ${%s:-default}", name, name))
+		return ref
+	})
+	if err := result.ErrorOrNil(); err != nil {
+		return nil, fmt.Errorf("failed to expand the config: %v", err)
+	}
+	return expanded, nil
+}
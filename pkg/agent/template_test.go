@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { serviceAccountNamespacePath = path }(serviceAccountNamespacePath)
+	serviceAccountNamespacePath = filepath.Join(dir, "namespace")
+	if err := ioutil.WriteFile(serviceAccountNamespacePath, []byte("jetstack-secure\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	env := map[string]string{
+		"CLUSTER_ID":   "prod-eu",
+		"EMPTY":        "",
+		"NODE_NAME":    "node-1",
+		"CLUSTER_NAME": "prod",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	tests := map[string]struct {
+		data        string
+		expected    string
+		expectedErr string
+	}{
+		"variable": {
+			data:     "cluster_id: ${CLUSTER_ID}",
+			expected: "cluster_id: prod-eu",
+		},
+		"default": {
+			data:     "server: ${SERVER:-https://platform.jetstack.io}",
+			expected: "server: https://platform.jetstack.io",
+		},
+		"empty uses the default": {
+			data:     "cluster_id: ${EMPTY:-default}",
+			expected: "cluster_id: default",
+		},
+		"empty without default": {
+			data:     "cluster_id: '${EMPTY}'",
+			expected: "cluster_id: ''",
+		},
+		"functions": {
+			data:     "${clusterName}/${namespace}/${nodeName}",
+			expected: "prod/jetstack-secure/node-1",
+		},
+		"escaped": {
+			data:     "pattern: '^v$${1}$'",
+			expected: "pattern: '^v${1}$'",
+		},
+		"undefined": {
+			data:        "cluster_id: ${UNDEFINED}",
+			expectedErr: "UNDEFINED is not defined",
+		},
+		"invalid name": {
+			data:        "cluster_id: ${1-cluster}",
+			expectedErr: "invalid reference ${1-cluster}",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			expanded, err := expandTemplate([]byte(test.data), lookupEnv)
+			if test.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(expanded) != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, expanded)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+	"golang.org/x/oauth2"
+)
+
+const (
+	tokenAuthClientCredentials = "client-credentials"
+	tokenAuthTokenExchange     = "token-exchange"
+
+	// defaultSubjectTokenFile is the ServiceAccount token mounted in the pod
+	// of the agent.
+	defaultSubjectTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// TokenAuth authenticates the agent to the backend with the short lived
+// tokens of an OAuth2 authorization server, instead of a credentials file or
+// an API token.
+type TokenAuth struct {
+	// Mode is client-credentials, to get tokens with a client ID and secret,
+	// or token-exchange, to trade the ServiceAccount token of the agent for
+	// a platform token.
+	Mode string `yaml:"mode"`
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string `yaml:"token-url"`
+	// ClientID and ClientSecretFile are the client credentials. They are
+	// required by client-credentials and optional for token-exchange.
+	ClientID         string `yaml:"client-id,omitempty"`
+	ClientSecretFile string `yaml:"client-secret-file,omitempty"`
+	// Audience and Scopes are the audience and scopes of the tokens.
+	Audience string   `yaml:"audience,omitempty"`
+	Scopes   []string `yaml:"scopes,omitempty"`
+	// SubjectTokenFile is the token traded by token-exchange, usually a
+	// projected ServiceAccount token. Defaults to the ServiceAccount token
+	// of the pod.
+	SubjectTokenFile string `yaml:"subject-token-file,omitempty"`
+}
+
+func (t *TokenAuth) validate() error {
+	switch t.Mode {
+	case tokenAuthClientCredentials:
+		if t.ClientID == "" || t.ClientSecretFile == "" {
+			return fmt.Errorf("token-auth.client-id and token-auth.client-secret-file are required by client-credentials")
+		}
+		if t.SubjectTokenFile != "" {
+			return fmt.Errorf("token-auth.subject-token-file is only used by token-exchange")
+		}
+	case tokenAuthTokenExchange:
+		if t.ClientSecretFile != "" && t.ClientID == "" {
+			return fmt.Errorf("token-auth.client-secret-file requires token-auth.client-id")
+		}
+	default:
+		return fmt.Errorf("token-auth.mode must be %s or %s, got %q", tokenAuthClientCredentials, tokenAuthTokenExchange, t.Mode)
+	}
+	if !isValidServerURL(t.TokenURL) {
+		return fmt.Errorf("token-auth.token-url is not a valid URL")
+	}
+	return nil
+}
+
+// tokenSource returns the source of the tokens of the mode.
+func (t *TokenAuth) tokenSource(clientSecret string) oauth2.TokenSource {
+	if t.Mode == tokenAuthClientCredentials {
+		return client.NewClientCredentialsTokenSource(t.TokenURL, t.ClientID, clientSecret, t.Audience, t.Scopes)
+	}
+
+	subjectTokenFile := t.SubjectTokenFile
+	if subjectTokenFile == "" {
+		subjectTokenFile = defaultSubjectTokenFile
+	}
+	source := client.NewTokenExchangeSource(t.TokenURL, subjectTokenFile, t.Audience, t.Scopes)
+	source.ClientID = t.ClientID
+	source.ClientSecret = clientSecret
+	return source
+}
+
+// newClient builds a client authenticating with the tokens. The tokens are
+// requested on the first upload, so that the agent starts while the
+// authorization server is unreachable. The client secret is reloaded by the
+// watcher when rotated.
+func (t *TokenAuth) newClient(agentMetadata *api.AgentMetadata, baseURL string, credentialFiles *credentialWatcher) (client.Client, error) {
+	var clientSecret string
+	var clientSecretData []byte
+	if t.ClientSecretFile != "" {
+		var err error
+		clientSecretData, err = ioutil.ReadFile(t.ClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client secret from file %s: %v", t.ClientSecretFile, err)
+		}
+		clientSecret = strings.TrimSpace(string(clientSecretData))
+	}
+
+	c, err := client.NewTokenClient(agentMetadata, t.tokenSource(clientSecret), baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if t.ClientSecretFile != "" {
+		credentialFiles.add("client secret", t.ClientSecretFile, clientSecretData, func(data []byte) error {
+			clientSecret := strings.TrimSpace(string(data))
+			if clientSecret == "" {
+				return fmt.Errorf("the client secret is empty")
+			}
+			c.SetTokenSource(t.tokenSource(clientSecret))
+			return nil
+		})
+	}
+	return c, nil
+}
@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"testing"
+)
+
+func TestValidateTokenAuth(t *testing.T) {
+	for _, a := range []TokenAuth{
+		{Mode: tokenAuthClientCredentials, TokenURL: "https://auth.example.com/oauth/token", ClientID: "agent", ClientSecretFile: "secret"},
+		{Mode: tokenAuthTokenExchange, TokenURL: "https://auth.example.com/oauth/token"},
+		{Mode: tokenAuthTokenExchange, TokenURL: "https://auth.example.com/oauth/token", SubjectTokenFile: "token", Audience: "preflight"},
+	} {
+		if err := a.validate(); err != nil {
+			t.Errorf("unexpected error for %+v: %v", a, err)
+		}
+	}
+
+	for _, a := range []TokenAuth{
+		{},
+		{Mode: "password", TokenURL: "https://auth.example.com/oauth/token"},
+		{Mode: tokenAuthClientCredentials, TokenURL: "https://auth.example.com/oauth/token", ClientID: "agent"},
+		{Mode: tokenAuthClientCredentials, TokenURL: "https://auth.example.com/oauth/token", ClientID: "agent", ClientSecretFile: "secret", SubjectTokenFile: "token"},
+		{Mode: tokenAuthTokenExchange, TokenURL: "https://auth.example.com/oauth/token", ClientSecretFile: "secret"},
+		{Mode: tokenAuthTokenExchange, TokenURL: "not a url"},
+	} {
+		if err := a.validate(); err == nil {
+			t.Errorf("expected error for %+v", a)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/encryption"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/spf13/cobra"
+)
+
+// UploadFromFile is the readings bundle uploaded by the upload command
+var UploadFromFile string
+
+// UploadEncryptionKeyFile is the key decrypting an encrypted readings bundle
+var UploadEncryptionKeyFile string
+
+// Upload posts a readings bundle previously written by a file output, or with
+// --output-path, to the backend. It uses the server and the credentials of
+// the agent configuration, and retries like the agent does.
+func Upload(cmd *cobra.Command, args []string) {
+	if UploadFromFile == "" {
+		logs.Log.Fatalf("--from-file must be set")
+	}
+
+	config, preflightClient, _ := getConfiguration()
+
+	bundle, err := ReadBundle(UploadFromFile, UploadEncryptionKeyFile)
+	if err != nil {
+		logs.Log.Fatalf("%s", err)
+	}
+	if bundle.AgentMetadata != nil && bundle.AgentMetadata.ClusterID != "" && bundle.AgentMetadata.ClusterID != config.ClusterID {
+		logs.Log.Warnf("The readings bundle was gathered for cluster %q, it is uploaded for cluster %q of the configuration", bundle.AgentMetadata.ClusterID, config.ClusterID)
+	}
+
+	logs.Log.Infof("Uploading %d data readings from %s", len(bundle.DataReadings), UploadFromFile)
+	if err := postDataWithRetry(context.Background(), config, preflightClient, bundle.DataReadings); err != nil {
+		logs.Log.Fatalf("%v", err)
+	}
+}
+
+// ReadBundle reads a readings bundle written by a file output, or with
+// --output-path. An encrypted bundle is decrypted with the key of keyFile.
+func ReadBundle(path, keyFile string) (*api.DataReadingsPost, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read readings bundle: %s", err)
+	}
+	if encryption.IsEncrypted(data) {
+		if keyFile == "" {
+			return nil, fmt.Errorf("the readings bundle %s is encrypted, --encryption-key-file must be set", path)
+		}
+		cipher, err := (&encryption.Config{KeyFile: keyFile}).NewCipher()
+		if err != nil {
+			return nil, err
+		}
+		if data, err = cipher.Decrypt(data); err != nil {
+			return nil, fmt.Errorf("failed to decrypt readings bundle %s: %s", path, err)
+		}
+	}
+	bundle, err := parseBundle(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse readings bundle %s: %s", path, err)
+	}
+	// the bundle may have been written by a previous version of the agent
+	migrateReadings(bundle.DataReadings)
+	return bundle, nil
+}
+
+// parseBundle parses a readings bundle, either written by a file output or a
+// list of readings written with --output-path.
+func parseBundle(data []byte) (*api.DataReadingsPost, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("the bundle is empty")
+	}
+
+	var bundle api.DataReadingsPost
+	if data[0] == '[' {
+		if err := json.Unmarshal(data, &bundle.DataReadings); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, err
+	}
+	if len(bundle.DataReadings) == 0 {
+		return nil, fmt.Errorf("the bundle has no data readings")
+	}
+	return &bundle, nil
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"testing"
+)
+
+func TestParseBundle(t *testing.T) {
+	tests := map[string]struct {
+		data              string
+		expectedReadings  int
+		expectedClusterID string
+		expectedErr       string
+	}{
+		"file output bundle": {
+			data:              `{"agent_metadata": {"cluster_id": "prod"}, "data_readings": [{"data-gatherer": "k8s/pods"}]}`,
+			expectedReadings:  1,
+			expectedClusterID: "prod",
+		},
+		"output-path readings": {
+			data:             ` [{"data-gatherer": "k8s/pods"}, {"data-gatherer": "k8s/nodes"}]`,
+			expectedReadings: 2,
+		},
+		"empty": {
+			data:        "\n",
+			expectedErr: "the bundle is empty",
+		},
+		"no readings": {
+			data:        `{"agent_metadata": {"cluster_id": "prod"}}`,
+			expectedErr: "the bundle has no data readings",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			bundle, err := parseBundle([]byte(test.data))
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(bundle.DataReadings) != test.expectedReadings {
+				t.Errorf("expected %d readings, got %d", test.expectedReadings, len(bundle.DataReadings))
+			}
+			if test.expectedClusterID != "" && bundle.AgentMetadata.ClusterID != test.expectedClusterID {
+				t.Errorf("expected cluster %q, got %q", test.expectedClusterID, bundle.AgentMetadata.ClusterID)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/transport"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Watch is a watch expression: the value found at Field in the resources of
+// ResourceType matching Selector is compared between cycles and a change
+// event is emitted when it differs.
+type Watch struct {
+	Name string `yaml:"name"`
+	// ResourceType is the type of the watched resources. It has to be
+	// gathered by one of the data gatherers.
+	ResourceType schema.GroupVersionResource `yaml:"resource-type"`
+	// DataGatherer optionally restricts the watch to the readings of a single
+	// data gatherer.
+	DataGatherer string `yaml:"data-gatherer,omitempty"`
+	// Namespace optionally restricts the watch to a single namespace.
+	Namespace string `yaml:"namespace,omitempty"`
+	// Selector is a label selector, e.g. "app=web,tier!=cache".
+	Selector string `yaml:"selector,omitempty"`
+	// Field is the dot separated path of the watched value, e.g.
+	// spec.ca.secretName.
+	Field string `yaml:"field"`
+	// Webhook is an optional URL change events are posted to as JSON. Events
+	// are always logged.
+	Webhook string `yaml:"webhook,omitempty"`
+}
+
+func (w *Watch) validate() error {
+	var errs []string
+	if w.ResourceType.Resource == "" {
+		errs = append(errs, "resource-type.resource cannot be empty")
+	}
+	if w.Field == "" {
+		errs = append(errs, "field cannot be empty")
+	}
+	if _, err := labels.Parse(w.Selector); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid selector: %v", err))
+	}
+	if w.Webhook != "" && !isValidServerURL(w.Webhook) {
+		errs = append(errs, "webhook is not a valid URL")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid watch %q: %s", w.Name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// WatchEvent is emitted when the value of a watch expression changed between
+// two cycles. Deleted resources are reported with a null new value.
+type WatchEvent struct {
+	Watch string `json:"watch"`
+	// ClusterID is the cluster of the resource, as data gatherers can read
+	// from several clusters.
+	ClusterID string          `json:"cluster_id,omitempty"`
+	Resource  string          `json:"resource"`
+	Field     string          `json:"field"`
+	Old       json.RawMessage `json:"old"`
+	New       json.RawMessage `json:"new"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// readingSource identifies the readings of a data gatherer, as the data
+// gatherers reading from several clusters produce readings with the same
+// name for each cluster.
+type readingSource struct {
+	dataGatherer string
+	clusterID    string
+}
+
+// watchedResource identifies a resource across the clusters.
+type watchedResource struct {
+	clusterID string
+	key       string
+}
+
+// watcher keeps the last value of every watch expression for each resource.
+type watcher struct {
+	watches   []Watch
+	selectors []labels.Selector
+	// resourceTypes is the resource type gathered by each data gatherer
+	// producing a single resource type, used to identify the readings where
+	// items are not keyed by resource type.
+	resourceTypes map[readingSource]schema.GroupVersionResource
+	// clusterID is the cluster of the readings without a cluster ID.
+	clusterID string
+	// values maps watch names to the marshalled value of each resource.
+	values map[string]map[watchedResource]string
+	client *http.Client
+}
+
+func newWatcher(config Config) *watcher {
+	if len(config.Watches) == 0 {
+		return nil
+	}
+
+	w := &watcher{
+		watches:       config.Watches,
+		resourceTypes: map[readingSource]schema.GroupVersionResource{},
+		values:        map[string]map[watchedResource]string{},
+		clusterID:     config.ClusterID,
+		client:        transport.Client(10 * time.Second),
+	}
+	for _, watch := range config.Watches {
+		// selectors have already been validated when parsing the config
+		selector, _ := labels.Parse(watch.Selector)
+		w.selectors = append(w.selectors, selector)
+	}
+	for _, dg := range config.DataGatherers {
+		if dynamicConfig, ok := dg.Config.(*k8s.ConfigDynamic); ok && len(dynamicConfig.GroupVersionResources) == 0 {
+			// the readings of the data gatherers expanded for several
+			// clusters are named after the configured data gatherer
+			name, clusterID := readingIdentity(config, dg.Name)
+			w.resourceTypes[readingSource{dataGatherer: name, clusterID: clusterID}] = dynamicConfig.GroupVersionResource
+		}
+	}
+	return w
+}
+
+// observe evaluates the watch expressions against the readings of a cycle and
+// returns the changes since the previous cycle. Resources seen for the first
+// time only record their value.
+func (w *watcher) observe(readings []*api.DataReading) []WatchEvent {
+	if w == nil {
+		return nil
+	}
+
+	var events []WatchEvent
+	now := time.Now().UTC()
+	for i, watch := range w.watches {
+		previous, ok := w.values[watch.Name]
+		if !ok {
+			previous = map[watchedResource]string{}
+			w.values[watch.Name] = previous
+		}
+
+		for _, reading := range readings {
+			if watch.DataGatherer != "" && reading.DataGatherer != watch.DataGatherer {
+				continue
+			}
+			for _, item := range w.itemsOfType(reading, watch.ResourceType) {
+				resource, ok := item.Resource.(*unstructured.Unstructured)
+				if !ok {
+					continue
+				}
+				if watch.Namespace != "" && resource.GetNamespace() != watch.Namespace {
+					continue
+				}
+				if !w.selectors[i].Matches(labels.Set(resource.GetLabels())) {
+					continue
+				}
+
+				key := resource.GetName()
+				if ns := resource.GetNamespace(); ns != "" {
+					key = ns + "/" + key
+				}
+
+				value := "null"
+				if item.DeletedAt.IsZero() {
+					value = fieldValue(resource, watch.Field)
+				}
+
+				id := watchedResource{clusterID: w.clusterOf(reading), key: key}
+				old, seen := previous[id]
+				if seen && old != value {
+					events = append(events, WatchEvent{
+						Watch:     watch.Name,
+						ClusterID: w.clusterOf(reading),
+						Resource:  key,
+						Field:     watch.Field,
+						Old:       json.RawMessage(old),
+						New:       json.RawMessage(value),
+						Timestamp: now,
+					})
+				}
+
+				if item.DeletedAt.IsZero() {
+					previous[id] = value
+				} else {
+					delete(previous, id)
+				}
+			}
+		}
+	}
+
+	return events
+}
+
+// itemsOfType returns the gathered resources of the reading that belong to
+// the resource type.
+func (w *watcher) itemsOfType(reading *api.DataReading, gvr schema.GroupVersionResource) []*api.GatheredResource {
+	list, ok := reading.Data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if resources, ok := list["resources"].(map[string]map[string]interface{}); ok {
+		items, _ := resources[k8s.ResourceTypeKey(gvr)]["items"].([]*api.GatheredResource)
+		return items
+	}
+
+	if w.resourceTypes[readingSource{dataGatherer: reading.DataGatherer, clusterID: w.clusterOf(reading)}] != gvr {
+		return nil
+	}
+	items, _ := list["items"].([]*api.GatheredResource)
+	return items
+}
+
+// clusterOf returns the cluster of the reading.
+func (w *watcher) clusterOf(reading *api.DataReading) string {
+	if reading.ClusterID == "" {
+		return w.clusterID
+	}
+	return reading.ClusterID
+}
+
+// fieldValue returns the JSON encoding of the value at the dot separated path,
+// or null if it is not set.
+func fieldValue(resource *unstructured.Unstructured, field string) string {
+	value, found, err := unstructured.NestedFieldNoCopy(resource.Object, strings.Split(field, ".")...)
+	if err != nil || !found {
+		return "null"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "null"
+	}
+	return string(data)
+}
+
+// notify logs the events and posts them to the webhook of their watch.
+// Failing webhooks are logged and do not affect the cycle.
+func (w *watcher) notify(events []WatchEvent) {
+	webhooks := map[string]string{}
+	for _, watch := range w.watches {
+		webhooks[watch.Name] = watch.Webhook
+	}
+
+	for _, event := range events {
+		resource := event.Resource
+		if event.ClusterID != "" {
+			resource = event.ClusterID + "/" + resource
+		}
+		logs.Log.Infof("watch %q: %s %s changed from %s to %s", event.Watch, resource, event.Field, event.Old, event.New)
+
+		webhook := webhooks[event.Watch]
+		if webhook == "" {
+			continue
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			logs.Log.Errorf("failed to marshal watch event: %v", err)
+			continue
+		}
+		res, err := w.client.Post(webhook, "application/json", bytes.NewReader(data))
+		if err != nil {
+			logs.Log.Errorf("failed to post watch event to %s: %v", webhook, err)
+			continue
+		}
+		res.Body.Close()
+		if code := res.StatusCode; code < 200 || code >= 300 {
+			logs.Log.Errorf("failed to post watch event to %s: received response with status code %d", webhook, code)
+		}
+	}
+}
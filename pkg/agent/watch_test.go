@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func getIssuer(name, secretName string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cert-manager.io/v1",
+			"kind":       "Issuer",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "default",
+				"labels": map[string]interface{}{
+					"team": "platform",
+				},
+			},
+			"spec": map[string]interface{}{
+				"ca": map[string]interface{}{
+					"secretName": secretName,
+				},
+			},
+		},
+	}
+}
+
+func issuerReadings(items ...*api.GatheredResource) []*api.DataReading {
+	return []*api.DataReading{
+		{
+			DataGatherer: "k8s/issuers",
+			Data: map[string]interface{}{
+				"items": items,
+			},
+		},
+	}
+}
+
+func TestWatcherObserve(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "example"
+      cluster_id: "example-cluster"
+      data-gatherers:
+      - kind: "k8s-dynamic"
+        name: "k8s/issuers"
+        config:
+          resource-type:
+            group: cert-manager.io
+            version: v1
+            resource: issuers
+      watches:
+      - name: issuer-ca
+        resource-type:
+          group: cert-manager.io
+          version: v1
+          resource: issuers
+        selector: team=platform
+        field: spec.ca.secretName
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := newWatcher(config)
+
+	if events := w.observe(issuerReadings(&api.GatheredResource{Resource: getIssuer("ca", "ca-1")})); len(events) != 0 {
+		t.Fatalf("expected no events on the first cycle, got %+v", events)
+	}
+	if events := w.observe(issuerReadings(&api.GatheredResource{Resource: getIssuer("ca", "ca-1")})); len(events) != 0 {
+		t.Fatalf("expected no events for an unchanged value, got %+v", events)
+	}
+
+	events := w.observe(issuerReadings(&api.GatheredResource{Resource: getIssuer("ca", "ca-2")}))
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %+v", events)
+	}
+	if got, want := string(events[0].Old), `"ca-1"`; got != want {
+		t.Errorf("unexpected old value: got=%s want=%s", got, want)
+	}
+	if got, want := string(events[0].New), `"ca-2"`; got != want {
+		t.Errorf("unexpected new value: got=%s want=%s", got, want)
+	}
+	if got, want := events[0].Resource, "default/ca"; got != want {
+		t.Errorf("unexpected resource: got=%s want=%s", got, want)
+	}
+
+	deleted := &api.GatheredResource{Resource: getIssuer("ca", "ca-2"), DeletedAt: api.Time{Time: time.Now()}}
+	events = w.observe(issuerReadings(deleted))
+	if len(events) != 1 || string(events[0].New) != "null" {
+		t.Fatalf("expected a deletion event, got %+v", events)
+	}
+}
+
+func TestWatcherObserveClusters(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "example"
+      cluster_id: "hub"
+      data-gatherers:
+      - kind: "k8s-dynamic"
+        name: "k8s/issuers"
+        config:
+          resource-type:
+            group: cert-manager.io
+            version: v1
+            resource: issuers
+        clusters:
+        - id: spoke-1
+        - id: spoke-2
+      watches:
+      - name: issuer-ca
+        resource-type:
+          group: cert-manager.io
+          version: v1
+          resource: issuers
+        field: spec.ca.secretName
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config.DataGatherers = expandClusters(config.DataGatherers)
+
+	w := newWatcher(config)
+	readings := func(first, second string) []*api.DataReading {
+		readings := append(issuerReadings(&api.GatheredResource{Resource: getIssuer("ca", first)}), issuerReadings(&api.GatheredResource{Resource: getIssuer("ca", second)})...)
+		readings[0].ClusterID = "spoke-1"
+		readings[1].ClusterID = "spoke-2"
+		return readings
+	}
+
+	// the same resource in two clusters is watched separately
+	if events := w.observe(readings("ca-1", "ca-2")); len(events) != 0 {
+		t.Fatalf("expected no events on the first cycle, got %+v", events)
+	}
+	if events := w.observe(readings("ca-1", "ca-2")); len(events) != 0 {
+		t.Fatalf("expected no events for unchanged values, got %+v", events)
+	}
+
+	events := w.observe(readings("ca-1", "ca-3"))
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %+v", events)
+	}
+	if events[0].ClusterID != "spoke-2" || events[0].Resource != "default/ca" || string(events[0].Old) != `"ca-2"` || string(events[0].New) != `"ca-3"` {
+		t.Errorf("unexpected event: %+v", events[0])
+	}
+}
+
+func TestWatchInvalidSelector(t *testing.T) {
+	_, err := ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "example"
+      cluster_id: "example-cluster"
+      watches:
+      - name: broken
+        resource-type:
+          version: v1
+          resource: secrets
+        selector: "a=b=c"
+        field: type
+`))
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
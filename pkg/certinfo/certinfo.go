@@ -0,0 +1,122 @@
+// Package certinfo extracts the metadata of X.509 certificates so that it can
+// be reported without sending the certificates themselves.
+package certinfo
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// Certificate is the metadata of an X.509 certificate.
+type Certificate struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serialNumber"`
+	DNSNames           []string  `json:"dnsNames,omitempty"`
+	IPAddresses        []string  `json:"ipAddresses,omitempty"`
+	EmailAddresses     []string  `json:"emailAddresses,omitempty"`
+	URIs               []string  `json:"uris,omitempty"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	IsCA               bool      `json:"isCA"`
+	KeyAlgorithm       string    `json:"keyAlgorithm"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	FingerprintSHA1    string    `json:"fingerprintSHA1"`
+	FingerprintSHA256  string    `json:"fingerprintSHA256"`
+}
+
+// ParsePEM parses all the CERTIFICATE blocks in the PEM data, in order. Other
+// blocks, such as private keys, are ignored.
+func ParsePEM(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %d: %v", len(certs), err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// DescribePEM returns the metadata of all the certificates in the PEM data.
+func DescribePEM(data []byte) ([]*Certificate, error) {
+	certs, err := ParsePEM(data)
+	if err != nil {
+		return nil, err
+	}
+	described := make([]*Certificate, 0, len(certs))
+	for _, cert := range certs {
+		described = append(described, Describe(cert))
+	}
+	return described, nil
+}
+
+// Describe returns the metadata of the certificate.
+func Describe(cert *x509.Certificate) *Certificate {
+	sha1Sum := sha1.Sum(cert.Raw)
+	sha256Sum := sha256.Sum256(cert.Raw)
+
+	c := &Certificate{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       cert.SerialNumber.String(),
+		DNSNames:           cert.DNSNames,
+		EmailAddresses:     cert.EmailAddresses,
+		NotBefore:          cert.NotBefore.UTC(),
+		NotAfter:           cert.NotAfter.UTC(),
+		IsCA:               cert.IsCA,
+		KeyAlgorithm:       keyAlgorithm(cert),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		FingerprintSHA1:    hex.EncodeToString(sha1Sum[:]),
+		FingerprintSHA256:  hex.EncodeToString(sha256Sum[:]),
+	}
+	for _, ip := range cert.IPAddresses {
+		c.IPAddresses = append(c.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		c.URIs = append(c.URIs, uri.String())
+	}
+	return c
+}
+
+// keyAlgorithm describes the public key of the certificate, e.g. RSA-2048 or
+// ECDSA-P-256.
+func keyAlgorithm(cert *x509.Certificate) string {
+	if algorithm := KeyAlgorithm(cert.PublicKey); algorithm != "" {
+		return algorithm
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+// KeyAlgorithm describes a public key, e.g. RSA-2048 or ECDSA-P-256, or
+// returns an empty string if the type of key is not known.
+func KeyAlgorithm(publicKey crypto.PublicKey) string {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA-%s", key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return ""
+	}
+}
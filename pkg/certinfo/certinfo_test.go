@@ -0,0 +1,78 @@
+package certinfo
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func selfSignedPEM(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com", "www.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+		NotBefore:    time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return append(
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...,
+	)
+}
+
+func TestDescribePEM(t *testing.T) {
+	certs, err := DescribePEM(selfSignedPEM(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(certs) != 1 {
+		t.Fatalf("expected one certificate, got %d", len(certs))
+	}
+
+	cert := certs[0]
+	if got, want := cert.Subject, "CN=example.com"; got != want {
+		t.Errorf("unexpected subject: got=%q want=%q", got, want)
+	}
+	if got, want := cert.SerialNumber, "42"; got != want {
+		t.Errorf("unexpected serial number: got=%q want=%q", got, want)
+	}
+	if len(cert.DNSNames) != 2 || len(cert.IPAddresses) != 1 || cert.IPAddresses[0] != "10.0.0.1" {
+		t.Errorf("unexpected SANs: %v %v", cert.DNSNames, cert.IPAddresses)
+	}
+	if got, want := cert.KeyAlgorithm, "ECDSA-P-256"; got != want {
+		t.Errorf("unexpected key algorithm: got=%q want=%q", got, want)
+	}
+	if got, want := cert.NotAfter, time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("unexpected notAfter: got=%v want=%v", got, want)
+	}
+	if len(cert.FingerprintSHA256) != 64 {
+		t.Errorf("unexpected fingerprint: %q", cert.FingerprintSHA256)
+	}
+}
+
+func TestDescribePEMInvalid(t *testing.T) {
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")})
+	if _, err := DescribePEM(data); err == nil {
+		t.Fatalf("expected error for invalid certificate")
+	}
+}
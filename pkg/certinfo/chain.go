@@ -0,0 +1,148 @@
+package certinfo
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The reasons of the chain errors.
+const (
+	// ChainExpired is reported for each certificate of the chain past its
+	// notAfter, the leaf or an intermediate.
+	ChainExpired = "Expired"
+	// ChainNotYetValid is reported for each certificate of the chain before
+	// its notBefore.
+	ChainNotYetValid = "NotYetValid"
+	// ChainWrongOrder is reported when a certificate is not followed by its
+	// issuer, although its issuer is in the chain.
+	ChainWrongOrder = "WrongOrder"
+	// ChainMissingSANs is reported when the leaf has no subject alternative
+	// name, which clients no longer fall back to the common name for.
+	ChainMissingSANs = "MissingSANs"
+	// ChainUnknownAuthority is reported when the chain does not lead to one
+	// of the roots, e.g. because an intermediate is missing.
+	ChainUnknownAuthority = "UnknownAuthority"
+	// ChainInvalid is reported for the other verification errors.
+	ChainInvalid = "Invalid"
+)
+
+// ChainError is an issue of a certificate chain.
+type ChainError struct {
+	Reason string `json:"reason"`
+	// Certificate is the index of the certificate in the chain, starting
+	// with the leaf at 0.
+	Certificate int    `json:"certificate"`
+	Message     string `json:"message"`
+}
+
+// ValidateChain validates the chain, the leaf first, against the roots at the
+// given time, and returns its issues. The roots are the ones of the system if
+// nil. The key usages of the leaf are not checked, so that the chains of the
+// client certificates are validated as the ones of the servers.
+func ValidateChain(chain []*x509.Certificate, roots *x509.CertPool, now time.Time) []ChainError {
+	if len(chain) == 0 {
+		return nil
+	}
+	var chainErrors []ChainError
+
+	for i, cert := range chain {
+		if now.After(cert.NotAfter) {
+			chainErrors = append(chainErrors, ChainError{
+				Reason:      ChainExpired,
+				Certificate: i,
+				Message:     fmt.Sprintf("%s expired at %s", cert.Subject, cert.NotAfter.UTC().Format(time.RFC3339)),
+			})
+		}
+		if now.Before(cert.NotBefore) {
+			chainErrors = append(chainErrors, ChainError{
+				Reason:      ChainNotYetValid,
+				Certificate: i,
+				Message:     fmt.Sprintf("%s is not valid before %s", cert.Subject, cert.NotBefore.UTC().Format(time.RFC3339)),
+			})
+		}
+	}
+
+	for i := 0; i < len(chain)-1; i++ {
+		if chain[i].CheckSignatureFrom(chain[i+1]) == nil {
+			continue
+		}
+		for j, issuer := range chain {
+			if j != i && j != i+1 && chain[i].CheckSignatureFrom(issuer) == nil {
+				chainErrors = append(chainErrors, ChainError{
+					Reason:      ChainWrongOrder,
+					Certificate: i,
+					Message:     fmt.Sprintf("%s is followed by %s rather than by its issuer %s", chain[i].Subject, chain[i+1].Subject, issuer.Subject),
+				})
+				break
+			}
+		}
+	}
+
+	leaf := chain[0]
+	if len(leaf.DNSNames) == 0 && len(leaf.IPAddresses) == 0 && len(leaf.EmailAddresses) == 0 && len(leaf.URIs) == 0 {
+		chainErrors = append(chainErrors, ChainError{
+			Reason:      ChainMissingSANs,
+			Certificate: 0,
+			Message:     fmt.Sprintf("%s has no subject alternative name", leaf.Subject),
+		})
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		if chainError, ok := verifyError(chain, err); ok {
+			chainErrors = append(chainErrors, chainError)
+		}
+	}
+	return chainErrors
+}
+
+// verifyError returns the chain error of the error of x509 verification,
+// unless it is already reported, such as the expiry of a certificate.
+func verifyError(chain []*x509.Certificate, err error) (ChainError, bool) {
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) {
+		return ChainError{
+			Reason:      ChainUnknownAuthority,
+			Certificate: indexOf(chain, unknownAuthority.Cert),
+			Message:     err.Error(),
+		}, true
+	}
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) {
+		if invalid.Reason == x509.Expired {
+			return ChainError{}, false
+		}
+		return ChainError{
+			Reason:      ChainInvalid,
+			Certificate: indexOf(chain, invalid.Cert),
+			Message:     err.Error(),
+		}, true
+	}
+	return ChainError{
+		Reason:      ChainInvalid,
+		Certificate: 0,
+		Message:     err.Error(),
+	}, true
+}
+
+// indexOf returns the index of the certificate in the chain, or the one of
+// the last certificate if it is not in the chain, e.g. a root.
+func indexOf(chain []*x509.Certificate, cert *x509.Certificate) int {
+	for i, c := range chain {
+		if cert != nil && c.Equal(cert) {
+			return i
+		}
+	}
+	return len(chain) - 1
+}
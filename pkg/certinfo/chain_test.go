@@ -0,0 +1,130 @@
+package certinfo
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue creates a certificate signed by the CA, or self-signed if ca is nil.
+func issue(t *testing.T, ca *testCA, template *x509.Certificate) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func caTemplate(name string, notBefore, notAfter time.Time) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+}
+
+func TestValidateChain(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	year := 365 * 24 * time.Hour
+
+	root := issue(t, nil, caTemplate("Root", now.Add(-year), now.Add(year)))
+	intermediate := issue(t, root, caTemplate("Intermediate", now.Add(-year), now.Add(year)))
+	expiredIntermediate := issue(t, root, caTemplate("Expired Intermediate", now.Add(-year), now.Add(-time.Hour)))
+	leaf := func(ca *testCA, dnsNames ...string) *x509.Certificate {
+		return issue(t, ca, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "example.com"},
+			DNSNames:  dnsNames,
+			NotBefore: now.Add(-time.Hour),
+			NotAfter:  now.Add(time.Hour),
+		}).cert
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	tests := map[string]struct {
+		chain           []*x509.Certificate
+		roots           *x509.CertPool
+		expectedReasons []string
+		expectedIndexes []int
+	}{
+		"valid chain": {
+			chain: []*x509.Certificate{leaf(intermediate, "example.com"), intermediate.cert},
+			roots: roots,
+		},
+		"expired intermediate": {
+			chain:           []*x509.Certificate{leaf(expiredIntermediate, "example.com"), expiredIntermediate.cert},
+			roots:           roots,
+			expectedReasons: []string{ChainExpired},
+			expectedIndexes: []int{1},
+		},
+		"wrong order": {
+			chain:           []*x509.Certificate{leaf(intermediate, "example.com"), root.cert, intermediate.cert},
+			roots:           roots,
+			expectedReasons: []string{ChainWrongOrder},
+			expectedIndexes: []int{0},
+		},
+		"missing SANs": {
+			chain:           []*x509.Certificate{leaf(intermediate), intermediate.cert},
+			roots:           roots,
+			expectedReasons: []string{ChainMissingSANs},
+			expectedIndexes: []int{0},
+		},
+		"missing intermediate": {
+			chain:           []*x509.Certificate{leaf(intermediate, "example.com")},
+			roots:           roots,
+			expectedReasons: []string{ChainUnknownAuthority},
+			expectedIndexes: []int{0},
+		},
+		"unknown root": {
+			chain:           []*x509.Certificate{leaf(intermediate, "example.com"), intermediate.cert},
+			roots:           x509.NewCertPool(),
+			expectedReasons: []string{ChainUnknownAuthority},
+			expectedIndexes: []int{1},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var reasons []string
+			var indexes []int
+			for _, chainError := range ValidateChain(test.chain, test.roots, now) {
+				reasons = append(reasons, chainError.Reason)
+				indexes = append(indexes, chainError.Certificate)
+			}
+			if !reflect.DeepEqual(reasons, test.expectedReasons) {
+				t.Errorf("expected reasons %v, got %v", test.expectedReasons, reasons)
+			}
+			if !reflect.DeepEqual(indexes, test.expectedIndexes) {
+				t.Errorf("expected certificates %v, got %v", test.expectedIndexes, indexes)
+			}
+		})
+	}
+}
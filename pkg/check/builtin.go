@@ -0,0 +1,41 @@
+package check
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// builtinPackages are the packages bundled with the agent.
+//
+//go:embed packs/*.yaml
+var builtinPackages embed.FS
+
+// builtinSource is the source of the built-in packages.
+const builtinSource = "built-in"
+
+// BuiltinPackages returns the packages bundled with the agent, sorted by ID.
+func BuiltinPackages() ([]*PolicyManifest, error) {
+	entries, err := builtinPackages.ReadDir("packs")
+	if err != nil {
+		return nil, err
+	}
+	var manifests []*PolicyManifest
+	for _, entry := range entries {
+		data, err := builtinPackages.ReadFile(path.Join("packs", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := ParseManifest(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid built-in package %s: %v", entry.Name(), err)
+		}
+		manifest.Source = builtinSource
+		manifests = append(manifests, manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].FullID() < manifests[j].FullID()
+	})
+	return manifests, nil
+}
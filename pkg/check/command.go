@@ -0,0 +1,212 @@
+package check
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/agent"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/spf13/cobra"
+)
+
+// EncryptionKeyFile is the key decrypting an encrypted readings bundle
+var EncryptionKeyFile string
+
+// PackageIDs are the IDs of the packages evaluated, all the packages are evaluated if empty
+var PackageIDs []string
+
+// PackageDirs are the directories of the packages evaluated in addition to the built-in ones
+var PackageDirs []string
+
+// PackageRefs are the OCI artifacts of the packages evaluated in addition to the built-in ones
+var PackageRefs []string
+
+// OutputFormat is the format of the results, text or json
+var OutputFormat string
+
+// The exit code is 1 when a rule fails, and 2 when the checks cannot run.
+const (
+	exitFailed = 1
+	exitError  = 2
+)
+
+// Check evaluates the packages against the readings bundle of args, and
+// prints the results.
+func Check(cmd *cobra.Command, args []string) {
+	if OutputFormat != "text" && OutputFormat != "json" {
+		fail("--output must be text or json")
+	}
+
+	bundle, err := agent.ReadBundle(args[0], EncryptionKeyFile)
+	if err != nil {
+		fail("%s", err)
+	}
+	packages, err := loadPackages(context.Background(), PackageDirs, PackageRefs)
+	if err != nil {
+		fail("%s", err)
+	}
+	packages, err = selectPackages(packages, PackageIDs)
+	if err != nil {
+		fail("%s", err)
+	}
+
+	var defaultCluster string
+	if bundle.AgentMetadata != nil {
+		defaultCluster = bundle.AgentMetadata.ClusterID
+	}
+	inputs, err := Input(bundle.DataReadings, defaultCluster)
+	if err != nil {
+		fail("%s", err)
+	}
+	if len(inputs) == 0 {
+		inputs[defaultCluster] = map[string]interface{}{}
+	}
+	var clusters []string
+	for cluster := range inputs {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	var reports []*api.Report
+	for _, cluster := range clusters {
+		for _, manifest := range packages {
+			report, err := Evaluate(manifest, cluster, inputs[cluster])
+			if err != nil {
+				fail("%s", err)
+			}
+			reports = append(reports, report)
+		}
+	}
+
+	if OutputFormat == "json" {
+		err = writeJSON(os.Stdout, reports)
+	} else {
+		err = writeText(os.Stdout, reports)
+	}
+	if err != nil {
+		fail("failed to write the results: %v", err)
+	}
+	for _, report := range reports {
+		if failures(report) > 0 {
+			os.Exit(exitFailed)
+		}
+	}
+}
+
+// loadPackages loads the built-in packages, and the ones of the directories
+// and of the OCI artifacts.
+func loadPackages(ctx context.Context, dirs, refs []string) ([]*PolicyManifest, error) {
+	builtin, err := BuiltinPackages()
+	if err != nil {
+		return nil, err
+	}
+	sources := [][]*PolicyManifest{builtin}
+	for _, dir := range dirs {
+		manifests, err := LoadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, manifests)
+	}
+	for _, ref := range refs {
+		manifests, err := LoadOCI(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		logs.Log.Infof("Loaded %d package(s) from %s", len(manifests), ref)
+		sources = append(sources, manifests)
+	}
+	return mergePackages(sources...)
+}
+
+// selectPackages returns the packages with the given IDs, with or without
+// their namespace, or all the packages if no IDs are given.
+func selectPackages(packages []*PolicyManifest, ids []string) ([]*PolicyManifest, error) {
+	if len(ids) == 0 {
+		return packages, nil
+	}
+	var selected []*PolicyManifest
+	for _, id := range ids {
+		found := false
+		for _, manifest := range packages {
+			if id == manifest.ID || id == manifest.FullID() {
+				selected = append(selected, manifest)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown package %q", id)
+		}
+	}
+	return selected, nil
+}
+
+// failures returns the number of rules of the report which failed. The
+// missing rules are not failures, their data gatherers are not configured.
+func failures(report *api.Report) int {
+	var count int
+	for _, section := range report.Sections {
+		for _, rule := range section.Rules {
+			if !rule.Success && !rule.Missing {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func writeJSON(w io.Writer, reports []*api.Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(reports)
+}
+
+func writeText(w io.Writer, reports []*api.Report) error {
+	var b strings.Builder
+	for _, report := range reports {
+		title := fmt.Sprintf("%s %s", report.Name, report.PackageInformation.Version)
+		if report.Cluster != "" {
+			title = fmt.Sprintf("%s (cluster %s)", title, report.Cluster)
+		}
+		fmt.Fprintf(&b, "%s\n", title)
+		var passed, skipped int
+		for _, section := range report.Sections {
+			fmt.Fprintf(&b, "  %s\n", section.Name)
+			for _, rule := range section.Rules {
+				status := "FAIL"
+				switch {
+				case rule.Missing:
+					status = "SKIP"
+					skipped++
+				case rule.Success:
+					status = "PASS"
+					passed++
+				}
+				fmt.Fprintf(&b, "    [%s] %s\n", status, rule.Name)
+				if rule.Success {
+					continue
+				}
+				for _, violation := range rule.Violations.([]string) {
+					fmt.Fprintf(&b, "      - %s\n", violation)
+				}
+				if !rule.Missing && rule.Remediation != "" {
+					fmt.Fprintf(&b, "      remediation: %s\n", strings.TrimSpace(rule.Remediation))
+				}
+			}
+		}
+		fmt.Fprintf(&b, "  %d passed, %d failed, %d skipped\n", passed, failures(report), skipped)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func fail(format string, args ...interface{}) {
+	logs.Log.Errorf(format, args...)
+	os.Exit(exitError)
+}
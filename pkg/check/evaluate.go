@@ -0,0 +1,187 @@
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/version"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// celEnv declares the variables of the rule queries: the data of the
+// readings keyed by data gatherer, and the time of the evaluation.
+var celEnv, celEnvErr = cel.NewEnv(cel.Declarations(
+	decls.NewVar("readings", decls.NewMapType(decls.String, decls.Dyn)),
+	decls.NewVar("now", decls.Timestamp),
+))
+
+// compileQuery parses and checks the CEL expression of a rule.
+func compileQuery(query string) (cel.Program, error) {
+	if celEnvErr != nil {
+		return nil, celEnvErr
+	}
+	ast, issues := celEnv.Compile(query)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	return celEnv.Program(ast)
+}
+
+// evaluateQuery evaluates the CEL expression of a rule, and returns its value
+// as JSON would decode it.
+func evaluateQuery(query string, input map[string]interface{}, now time.Time) (interface{}, error) {
+	program, err := compileQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	out, _, err := program.Eval(map[string]interface{}{
+		"readings": input,
+		"now":      now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	value, err := out.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return nil, fmt.Errorf("the query returned a %s which cannot be reported: %v", out.Type().TypeName(), err)
+	}
+	return value.(*structpb.Value).AsInterface(), nil
+}
+
+// Input returns the data of the readings keyed by data gatherer, grouped by
+// cluster, as queried by the rules. The data is normalized to its JSON
+// representation. The readings without a cluster are the ones of
+// defaultCluster.
+func Input(readings []*api.DataReading, defaultCluster string) (map[string]map[string]interface{}, error) {
+	inputs := map[string]map[string]interface{}{}
+	for _, reading := range readings {
+		if reading.Error != "" {
+			// the data gatherer could not be fetched, its rules are skipped
+			continue
+		}
+		cluster := reading.ClusterID
+		if cluster == "" {
+			cluster = defaultCluster
+		}
+		raw, err := json.Marshal(reading.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the data of %s: %v", reading.DataGatherer, err)
+		}
+		var data interface{}
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return nil, fmt.Errorf("failed to decode the data of %s: %v", reading.DataGatherer, err)
+		}
+		if inputs[cluster] == nil {
+			inputs[cluster] = map[string]interface{}{}
+		}
+		inputs[cluster][reading.DataGatherer] = data
+	}
+	return inputs, nil
+}
+
+// Evaluate evaluates the rules of the package against the input of a
+// cluster, and returns the results as a report. The rules are reported as
+// missing when a data gatherer of the package has no reading.
+func Evaluate(manifest *PolicyManifest, cluster string, input map[string]interface{}) (*api.Report, error) {
+	return evaluateAt(manifest, cluster, input, time.Now())
+}
+
+// evaluateAt evaluates the rules as Evaluate does, with now as the time of
+// the evaluation.
+func evaluateAt(manifest *PolicyManifest, cluster string, input map[string]interface{}, now time.Time) (*api.Report, error) {
+	report := &api.Report{
+		PreflightVersion: version.PreflightVersion,
+		Timestamp:        api.Time{Time: now},
+		Cluster:          cluster,
+		Package:          manifest.ID,
+		PackageInformation: api.PackageInformation{
+			Namespace:     manifest.Namespace,
+			ID:            manifest.ID,
+			Version:       manifest.PackageVersion,
+			SchemaVersion: manifest.SchemaVersion,
+		},
+		Name:        manifest.Name,
+		Description: manifest.Description,
+	}
+
+	var missing []string
+	for _, name := range manifest.DataGatherers {
+		if _, ok := input[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	for _, section := range manifest.Sections {
+		reportSection := api.ReportSection{
+			ID:          section.ID,
+			Name:        section.Name,
+			Description: section.Description,
+		}
+		for _, rule := range section.Rules {
+			result := api.ReportRule{
+				ID:          rule.ID,
+				Name:        rule.Name,
+				Description: rule.Description,
+				Remediation: rule.Remediation,
+				Links:       rule.Links,
+				Violations:  []string{},
+			}
+			if len(missing) > 0 {
+				result.Missing = true
+				result.Violations = []string{fmt.Sprintf("no readings of the data gatherer(s) %v", missing)}
+				reportSection.Rules = append(reportSection.Rules, result)
+				continue
+			}
+			value, err := evaluateQuery(rule.Query, input, now)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate rule %s of package %s: %v", rule.ID, manifest.FullID(), err)
+			}
+			violations := violationsOf(value)
+			result.Value = value
+			result.Violations = violations
+			result.Success = len(violations) == 0
+			reportSection.Rules = append(reportSection.Rules, result)
+		}
+		report.Sections = append(report.Sections, reportSection)
+	}
+	return report, nil
+}
+
+// violationsOf returns the violations of the value returned by a query:
+// none for null, false or an empty list, the elements of a list otherwise.
+// The elements which are not strings are formatted as JSON.
+func violationsOf(value interface{}) []string {
+	violations := []string{}
+	switch v := value.(type) {
+	case nil:
+	case bool:
+		if v {
+			violations = append(violations, "the rule query returned true")
+		}
+	case []interface{}:
+		for _, element := range v {
+			violations = append(violations, formatViolation(element))
+		}
+		sort.Strings(violations)
+	default:
+		violations = append(violations, formatViolation(v))
+	}
+	return violations
+}
+
+func formatViolation(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(b)
+}
@@ -0,0 +1,159 @@
+package check
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func certificate(namespace, name, ready string) map[string]interface{} {
+	return map[string]interface{}{
+		"resource": map[string]interface{}{
+			"metadata": map[string]interface{}{"namespace": namespace, "name": name},
+			"status": map[string]interface{}{
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "Ready", "status": ready},
+				},
+			},
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	manifest := &PolicyManifest{
+		Namespace:     "example.com",
+		ID:            "certificates",
+		Name:          "Certificates",
+		DataGatherers: []string{"k8s/certificates"},
+		Sections: []Section{{
+			ID: "certificates",
+			Rules: []Rule{
+				{
+					ID:    "ready",
+					Query: `readings["k8s/certificates"].items.map(i, i.resource).filter(c, !c.status.conditions.exists(cond, cond.type == "Ready" && cond.status == "True")).map(c, c.metadata.namespace + "/" + c.metadata.name)`,
+				},
+				{
+					ID:    "any",
+					Query: `size(readings["k8s/certificates"].items) == 0`,
+				},
+			},
+		}},
+	}
+
+	tests := map[string]struct {
+		readings []*api.DataReading
+		expected []api.ReportRule
+	}{
+		"rules passing": {
+			readings: []*api.DataReading{{
+				DataGatherer: "k8s/certificates",
+				Data:         map[string]interface{}{"items": []interface{}{certificate("default", "a", "True")}},
+			}},
+			expected: []api.ReportRule{
+				{ID: "ready", Success: true, Value: []interface{}{}, Violations: []string{}},
+				{ID: "any", Success: true, Value: false, Violations: []string{}},
+			},
+		},
+		"rules failing": {
+			readings: []*api.DataReading{{
+				DataGatherer: "k8s/certificates",
+				Data: map[string]interface{}{"items": []interface{}{
+					certificate("default", "b", "False"),
+					certificate("default", "a", "True"),
+					certificate("cert-manager", "c", "Unknown"),
+				}},
+			}},
+			expected: []api.ReportRule{
+				{ID: "ready", Value: []interface{}{"default/b", "cert-manager/c"}, Violations: []string{"cert-manager/c", "default/b"}},
+				{ID: "any", Success: true, Value: false, Violations: []string{}},
+			},
+		},
+		"data gatherer missing": {
+			readings: []*api.DataReading{{
+				DataGatherer: "k8s/certificates",
+				Error:        "timed out",
+			}},
+			expected: []api.ReportRule{
+				{ID: "ready", Missing: true, Violations: []string{"no readings of the data gatherer(s) [k8s/certificates]"}},
+				{ID: "any", Missing: true, Violations: []string{"no readings of the data gatherer(s) [k8s/certificates]"}},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			inputs, err := Input(test.readings, "cluster")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			report, err := Evaluate(manifest, "cluster", inputs["cluster"])
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if report.Cluster != "cluster" || report.PackageInformation.ID != "certificates" {
+				t.Errorf("unexpected report metadata: %+v", report)
+			}
+			if got := report.Sections[0].Rules; !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got rules %+v, want %+v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestEvaluateBuiltinExpiry(t *testing.T) {
+	now := time.Date(2021, time.March, 16, 0, 0, 0, 0, time.UTC)
+	expiring := func(namespace, name string, notAfter time.Time) map[string]interface{} {
+		c := certificate(namespace, name, "True")
+		c["resource"].(map[string]interface{})["status"].(map[string]interface{})["notAfter"] = notAfter.Format(time.RFC3339)
+		return c
+	}
+
+	manifests, err := BuiltinPackages()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var manifest *PolicyManifest
+	for _, m := range manifests {
+		if m.FullID() == "jetstack.io/cert-manager" {
+			manifest = m
+		}
+	}
+	if manifest == nil {
+		t.Fatalf("the cert-manager package is not built in")
+	}
+
+	inputs, err := Input([]*api.DataReading{
+		{DataGatherer: "k8s/secrets.v1", Data: map[string]interface{}{"items": []interface{}{}}},
+		{DataGatherer: "k8s/certificaterequests.v1alpha2.cert-manager.io", Data: map[string]interface{}{"items": []interface{}{}}},
+		{DataGatherer: "k8s/certificates.v1alpha2.cert-manager.io", Data: map[string]interface{}{"items": []interface{}{
+			expiring("default", "expired", now.Add(-time.Hour)),
+			expiring("default", "expiring", now.Add(7*24*time.Hour)),
+			expiring("default", "valid", now.Add(60*24*time.Hour)),
+			certificate("default", "pending", "False"),
+		}}},
+	}, "cluster")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	report, err := evaluateAt(manifest, "cluster", inputs["cluster"], now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string][]string{
+		"certificates-ready":              {"default/pending"},
+		"certificates-not-expired":        {"default/expired"},
+		"certificates-not-expiring":       {"default/expiring expires at 2021-03-23T00:00:00Z"},
+		"certificate-requests-not-failed": {},
+		"tls-secrets-have-certificate":    {},
+	}
+	for _, section := range report.Sections {
+		for _, rule := range section.Rules {
+			if !reflect.DeepEqual(rule.Violations, expected[rule.ID]) {
+				t.Errorf("unexpected violations of %s: got %v, want %v", rule.ID, rule.Violations, expected[rule.ID])
+			}
+		}
+	}
+}
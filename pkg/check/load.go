@@ -0,0 +1,74 @@
+package check
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LoadDir loads the packages of a directory, one per YAML file.
+func LoadDir(dir string) ([]*PolicyManifest, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the packages directory: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isManifestFile(entry.Name()) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files[entry.Name()] = data
+	}
+	return parseManifests(files, dir)
+}
+
+func isManifestFile(name string) bool {
+	return strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml")
+}
+
+// parseManifests parses the packages of the files, keyed by name, and
+// records where they were loaded from.
+func parseManifests(files map[string][]byte, source string) ([]*PolicyManifest, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no package found in %s", source)
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var manifests []*PolicyManifest
+	for _, name := range names {
+		manifest, err := ParseManifest(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid package %s in %s: %v", name, source, err)
+		}
+		manifest.Source = source
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+// mergePackages returns the packages of all the sources, and fails if a
+// package is in several of them, as it would be evaluated twice.
+func mergePackages(sources ...[]*PolicyManifest) ([]*PolicyManifest, error) {
+	seen := map[string]string{}
+	var merged []*PolicyManifest
+	for _, manifests := range sources {
+		for _, manifest := range manifests {
+			if source, ok := seen[manifest.FullID()]; ok {
+				return nil, fmt.Errorf("the package %s is both in %s and %s", manifest.FullID(), source, manifest.Source)
+			}
+			seen[manifest.FullID()] = manifest.Source
+			merged = append(merged, manifest)
+		}
+	}
+	return merged, nil
+}
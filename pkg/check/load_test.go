@@ -0,0 +1,195 @@
+package check
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const podsPackage = `
+namespace: example.com
+id: pods
+package-version: 1.2.0
+data-gatherers: [k8s/pods]
+sections:
+- id: pods
+  rules:
+  - id: "1"
+    query: readings["k8s/pods"].items
+`
+
+func TestLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packages")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "pods.yaml"), []byte(podsPackage), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("# Packages"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	manifests, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(manifests) != 1 || manifests[0].FullID() != "example.com/pods" || manifests[0].Source != dir {
+		t.Fatalf("unexpected packages: %+v", manifests)
+	}
+	if manifests[0].SchemaVersion != schemaVersion {
+		t.Errorf("expected the schema version to default to %s, got %s", schemaVersion, manifests[0].SchemaVersion)
+	}
+
+	// the same package twice would be evaluated twice
+	if _, err := mergePackages(manifests, manifests); err == nil {
+		t.Errorf("expected an error for a duplicate package")
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	tests := map[string]struct {
+		ref         string
+		expected    ociReference
+		expectedErr bool
+	}{
+		"registry": {
+			ref:      "ghcr.io/example/policies@" + digest,
+			expected: ociReference{registry: "ghcr.io", repository: "example/policies", digest: digest},
+		},
+		"registry with port and tag": {
+			ref:      "localhost:5000/policies:v1@" + digest,
+			expected: ociReference{registry: "localhost:5000", repository: "policies", digest: digest},
+		},
+		"docker hub": {
+			ref:      "policies@" + digest,
+			expected: ociReference{registry: dockerHub, repository: "library/policies", digest: digest},
+		},
+		"not pinned": {
+			ref:         "ghcr.io/example/policies:v1",
+			expectedErr: true,
+		},
+		"invalid digest": {
+			ref:         "ghcr.io/example/policies@sha256:abc",
+			expectedErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseReference(test.ref)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.expected {
+				t.Errorf("got %+v, want %+v", got, test.expected)
+			}
+		})
+	}
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestLoadOCI(t *testing.T) {
+	var layer bytes.Buffer
+	gz := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gz)
+	for name, content := range map[string]string{"policies/pods.yaml": podsPackage, "LICENSE": "MIT"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	layerDigest := sha256Digest(layer.Bytes())
+
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"layers": []interface{}{map[string]interface{}{
+			"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip",
+			"digest":    layerDigest,
+			"size":      layer.Len(),
+		}},
+	})
+	manifestDigest := sha256Digest(manifest)
+
+	// the registry requires a token, as the public registries do
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("scope") != "repository:example/policies:pull" {
+			http.Error(w, "invalid scope", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"token": "secret"}`)
+	})
+	mux.HandleFunc("/v2/example/policies/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/example/policies/manifests/" + manifestDigest:
+			w.Write(manifest)
+		case "/v2/example/policies/blobs/" + layerDigest:
+			w.Write(layer.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	client := &ociClient{
+		client: server.Client(),
+		scheme: "http",
+		credentials: func(string) (string, string, bool) {
+			return "", "", false
+		},
+	}
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	manifests, err := client.load(context.Background(), registry+"/example/policies@"+manifestDigest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(manifests) != 1 || manifests[0].FullID() != "example.com/pods" || manifests[0].PackageVersion != "1.2.0" {
+		t.Fatalf("unexpected packages: %+v", manifests)
+	}
+
+	// the manifest does not match another digest
+	otherDigest := "sha256:" + strings.Repeat("0", 64)
+	mux.HandleFunc("/v2/example/other/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(manifest)
+	})
+	if _, err := client.load(context.Background(), registry+"/example/other@"+otherDigest); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Errorf("expected a digest mismatch, got %v", err)
+	}
+}
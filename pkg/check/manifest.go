@@ -0,0 +1,116 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"gopkg.in/yaml.v2"
+)
+
+// schemaVersion is the version of the format of the packages.
+const schemaVersion = "1.0.0"
+
+// PolicyManifest is a package of rules evaluated against the readings of the
+// agent, grouped in sections.
+type PolicyManifest struct {
+	// SchemaVersion is the version of the format of the package, the
+	// packages of another major version are rejected. Defaults to 1.0.0.
+	SchemaVersion string `yaml:"schema-version,omitempty"`
+	// Namespace and ID identify the package, e.g. jetstack.io/cert-manager.
+	Namespace string `yaml:"namespace"`
+	ID        string `yaml:"id"`
+	// PackageVersion is the version of the package.
+	PackageVersion string `yaml:"package-version"`
+	Name           string `yaml:"name"`
+	Description    string `yaml:"description,omitempty"`
+	// DataGatherers are the names of the data gatherers whose readings the
+	// rules query. The rules are skipped when one of them has no reading.
+	DataGatherers []string  `yaml:"data-gatherers"`
+	Sections      []Section `yaml:"sections"`
+
+	// Source is where the package was loaded from: built-in, a directory
+	// or an OCI artifact.
+	Source string `yaml:"-"`
+}
+
+// Section is a group of rules of a package.
+type Section struct {
+	ID          string `yaml:"id"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	Rules       []Rule `yaml:"rules"`
+}
+
+// Rule is a check of the readings. Query is a CEL expression evaluated with
+// the data of the readings keyed by data gatherer as readings, and the time
+// of the evaluation as now. It returns the violations of the rule: the rule
+// passes when it returns an empty list, null or false.
+type Rule struct {
+	ID          string   `yaml:"id"`
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description,omitempty"`
+	Remediation string   `yaml:"remediation,omitempty"`
+	Links       []string `yaml:"links,omitempty"`
+	Query       string   `yaml:"query"`
+}
+
+// FullID returns the namespaced ID of the package.
+func (m *PolicyManifest) FullID() string {
+	return m.Namespace + "/" + m.ID
+}
+
+// ParseManifest parses and validates a policy manifest.
+func ParseManifest(data []byte) (*PolicyManifest, error) {
+	var manifest PolicyManifest
+	if err := yaml.UnmarshalStrict(data, &manifest); err != nil {
+		return nil, err
+	}
+	if err := manifest.validate(); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func (m *PolicyManifest) validate() error {
+	var result *multierror.Error
+	if m.SchemaVersion == "" {
+		m.SchemaVersion = schemaVersion
+	}
+	if strings.SplitN(m.SchemaVersion, ".", 2)[0] != strings.SplitN(schemaVersion, ".", 2)[0] {
+		result = multierror.Append(result, fmt.Errorf("unsupported schema-version %s, the agent supports %s", m.SchemaVersion, schemaVersion))
+	}
+	if m.Namespace == "" {
+		result = multierror.Append(result, fmt.Errorf("namespace is required"))
+	}
+	if m.ID == "" {
+		result = multierror.Append(result, fmt.Errorf("id is required"))
+	}
+	if len(m.DataGatherers) == 0 {
+		result = multierror.Append(result, fmt.Errorf("data-gatherers is required"))
+	}
+
+	ids := map[string]bool{}
+	for _, section := range m.Sections {
+		if section.ID == "" {
+			result = multierror.Append(result, fmt.Errorf("section %q: id is required", section.Name))
+		}
+		for _, rule := range section.Rules {
+			if rule.ID == "" {
+				result = multierror.Append(result, fmt.Errorf("rule %q: id is required", rule.Name))
+				continue
+			}
+			if ids[rule.ID] {
+				result = multierror.Append(result, fmt.Errorf("rule %s: duplicate id", rule.ID))
+			}
+			ids[rule.ID] = true
+			if _, err := compileQuery(rule.Query); err != nil {
+				result = multierror.Append(result, fmt.Errorf("rule %s: invalid query: %v", rule.ID, err))
+			}
+		}
+	}
+	if len(ids) == 0 {
+		result = multierror.Append(result, fmt.Errorf("the package has no rules"))
+	}
+	return result.ErrorOrNil()
+}
@@ -0,0 +1,91 @@
+package check
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	tests := map[string]struct {
+		manifest    string
+		expectedErr string
+	}{
+		"valid": {
+			manifest: `
+namespace: example.com
+id: pods
+data-gatherers: [k8s/pods]
+sections:
+- id: pods
+  rules:
+  - id: "1"
+    query: readings["k8s/pods"].items
+`,
+		},
+		"invalid query": {
+			manifest: `
+namespace: example.com
+id: pods
+data-gatherers: [k8s/pods]
+sections:
+- id: pods
+  rules:
+  - id: "1"
+    query: readings["k8s/pods"].items.filter(
+`,
+			expectedErr: "rule 1: invalid query",
+		},
+		"duplicate rule": {
+			manifest: `
+namespace: example.com
+id: pods
+data-gatherers: [k8s/pods]
+sections:
+- id: pods
+  rules:
+  - id: "1"
+    query: readings["k8s/pods"].items
+  - id: "1"
+    query: readings["k8s/pods"].items
+`,
+			expectedErr: "rule 1: duplicate id",
+		},
+		"no rules": {
+			manifest: `
+namespace: example.com
+id: pods
+data-gatherers: [k8s/pods]
+`,
+			expectedErr: "the package has no rules",
+		},
+		"unknown field": {
+			manifest:    "namespace: example.com\nrego: x\n",
+			expectedErr: "field rego not found",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseManifest([]byte(test.manifest))
+			if test.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+				t.Fatalf("expected error containing %q, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestBuiltinPackages(t *testing.T) {
+	packages, err := BuiltinPackages()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packages) == 0 {
+		t.Fatalf("expected built-in packages")
+	}
+}
@@ -0,0 +1,344 @@
+package check
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/transport"
+)
+
+const (
+	// dockerHub is the registry of the references without a registry.
+	dockerHub = "registry-1.docker.io"
+	// maxManifestSize and maxLayerSize bound what is read from a registry,
+	// a package is a few YAML files.
+	maxManifestSize = 1 << 20
+	maxLayerSize    = 10 << 20
+	// ociTimeout bounds the requests to a registry.
+	ociTimeout = time.Minute
+)
+
+// manifestMediaTypes are the manifests accepted from a registry.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// ociReference is a reference to an OCI artifact pinned by digest, e.g.
+// ghcr.io/example/policies@sha256:...
+type ociReference struct {
+	registry, repository, digest string
+}
+
+func (r ociReference) String() string {
+	return fmt.Sprintf("%s/%s@%s", r.registry, r.repository, r.digest)
+}
+
+// parseReference parses a reference to an artifact. The artifact must be
+// pinned by digest, a tag can be moved to other rules than the ones which
+// were reviewed.
+func parseReference(ref string) (ociReference, error) {
+	var r ociReference
+	i := strings.Index(ref, "@")
+	if i < 0 {
+		return r, fmt.Errorf("the reference %q is not pinned by digest, use name@sha256:<digest>", ref)
+	}
+	name, digest := ref[:i], ref[i+1:]
+	if !digestPattern.MatchString(digest) {
+		return r, fmt.Errorf("the reference %q has an invalid sha256 digest", ref)
+	}
+	// a tag is redundant with the digest
+	if j := strings.LastIndex(name, ":"); j > strings.LastIndex(name, "/") {
+		name = name[:j]
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		r.registry, r.repository = parts[0], parts[1]
+	} else {
+		r.registry, r.repository = dockerHub, name
+		if len(parts) == 1 {
+			r.repository = "library/" + name
+		}
+	}
+	if r.repository == "" {
+		return r, fmt.Errorf("the reference %q has no repository", ref)
+	}
+	r.digest = digest
+	return r, nil
+}
+
+// ociManifest is the part of an OCI image manifest used to find the layers.
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// ociClient pulls artifacts from registries, anonymously or with the
+// credentials of the Docker configuration.
+type ociClient struct {
+	client *http.Client
+	// scheme is https, and http in the tests.
+	scheme      string
+	credentials func(registry string) (string, string, bool)
+}
+
+func newOCIClient() *ociClient {
+	return &ociClient{
+		client:      transport.Client(ociTimeout),
+		scheme:      "https",
+		credentials: dockerCredentials,
+	}
+}
+
+// LoadOCI loads the packages of an OCI artifact pinned by digest. The YAML
+// files of its layers, tar archives optionally compressed with gzip, are the
+// packages. The digests of the manifest and of the layers are verified.
+func LoadOCI(ctx context.Context, ref string) ([]*PolicyManifest, error) {
+	return newOCIClient().load(ctx, ref)
+}
+
+func (c *ociClient) load(ctx context.Context, ref string) ([]*PolicyManifest, error) {
+	r, err := parseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.fetch(ctx, r, "manifests", r.digest, strings.Join(manifestMediaTypes, ", "), maxManifestSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull the manifest of %s: %v", r, err)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest of %s: %v", r, err)
+	}
+
+	files := map[string][]byte{}
+	for _, layer := range manifest.Layers {
+		if !digestPattern.MatchString(layer.Digest) {
+			return nil, fmt.Errorf("the layer %s of %s has an unsupported digest", layer.Digest, r)
+		}
+		if layer.Size > maxLayerSize {
+			return nil, fmt.Errorf("the layer %s of %s is larger than %d bytes", layer.Digest, r, maxLayerSize)
+		}
+		blob, err := c.fetch(ctx, r, "blobs", layer.Digest, "", maxLayerSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pull the layer %s of %s: %v", layer.Digest, r, err)
+		}
+		if err := extractManifests(blob, files); err != nil {
+			return nil, fmt.Errorf("invalid layer %s of %s: %v", layer.Digest, r, err)
+		}
+	}
+	return parseManifests(files, r.String())
+}
+
+// fetch fetches a manifest or a blob by digest and verifies its digest.
+func (c *ociClient) fetch(ctx context.Context, r ociReference, kind, digest, accept string, limit int64) ([]byte, error) {
+	u := fmt.Sprintf("%s://%s/v2/%s/%s/%s", c.scheme, r.registry, r.repository, kind, digest)
+	res, err := c.get(ctx, u, accept, "")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		authorization, err := c.authorize(ctx, r, res.Header.Get("WWW-Authenticate"))
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if res, err = c.get(ctx, u, accept, authorization); err != nil {
+			return nil, err
+		}
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received response with status code %d", res.StatusCode)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("larger than %d bytes", limit)
+	}
+	sum := sha256.Sum256(data)
+	if got := "sha256:" + hex.EncodeToString(sum[:]); got != digest {
+		return nil, fmt.Errorf("digest mismatch, got %s", got)
+	}
+	return data, nil
+}
+
+func (c *ociClient) get(ctx context.Context, u, accept, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.client.Do(req)
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authorize answers the challenge of a registry: a Basic challenge with the
+// credentials of the registry, a Bearer challenge with a token of the token
+// service, requested with the credentials if there are some.
+func (c *ociClient) authorize(ctx context.Context, r ociReference, challenge string) (string, error) {
+	username, password, hasCredentials := c.credentials(r.registry)
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+	switch {
+	case scheme == "basic" && hasCredentials:
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case scheme != "bearer":
+		return "", fmt.Errorf("the registry requires authentication, add its credentials to the Docker configuration")
+	}
+
+	params := map[string]string{}
+	for _, match := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("invalid authentication challenge %q", challenge)
+	}
+	query := url.Values{}
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", r.repository)
+	}
+	query.Set("scope", scope)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCredentials {
+		req.SetBasicAuth(username, password)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get a token: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get a token: received response with status code %d", res.StatusCode)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxManifestSize)).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode the token: %v", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// extractManifests adds the YAML files of a layer to the files.
+func extractManifests(blob []byte, files map[string][]byte) error {
+	var reader io.Reader = bytes.NewReader(blob)
+	if len(blob) > 2 && blob[0] == 0x1f && blob[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg || !isManifestFile(header.Name) {
+			continue
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(tr, maxLayerSize))
+		if err != nil {
+			return err
+		}
+		files[path.Clean(header.Name)] = data
+	}
+}
+
+// dockerCredentials returns the credentials of the registry in the Docker
+// configuration, as written by docker login. The credential helpers are not
+// supported.
+func dockerCredentials(registry string) (string, string, bool) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", false
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", "", false
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", "", false
+	}
+	keys := []string{registry, "https://" + registry}
+	if registry == dockerHub {
+		keys = append(keys, "https://index.docker.io/v1/", "index.docker.io")
+	}
+	for _, key := range keys {
+		auth, ok := config.Auths[key]
+		if !ok {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", false
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", false
+		}
+		return parts[0], parts[1], true
+	}
+	return "", "", false
+}
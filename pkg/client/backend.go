@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/jetstack/preflight/api"
+)
+
+type (
+	// ReadingsPoster is the interface implemented by the backends the readings are posted to. The clients of the
+	// Jetstack Secure backend implement it.
+	ReadingsPoster interface {
+		PostDataReadingsWithContext(ctx context.Context, orgID, clusterID string, readings []*api.DataReading) error
+	}
+
+	// BackendConfig is the configuration of a registered backend, decoded from the config of the backend in the
+	// agent configuration.
+	BackendConfig interface {
+		// Validate validates the configuration when the agent configuration is parsed.
+		Validate() error
+		// NewBackend constructs the backend with the configuration.
+		NewBackend(agentMetadata *api.AgentMetadata) (ReadingsPoster, error)
+	}
+
+	// BackendConfigFactory returns an empty configuration of a backend.
+	BackendConfigFactory func() BackendConfig
+)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]BackendConfigFactory{}
+)
+
+// RegisterBackend makes a backend available to the agent configuration by
+// name. It is meant to be called from the init function of the package of the
+// backend, and panics if the name is already registered.
+func RegisterBackend(name string, factory BackendConfigFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("backend %q is already registered", name))
+	}
+	backends[name] = factory
+}
+
+// NewBackendConfig returns an empty configuration of the registered backend.
+func NewBackendConfig(name string) (BackendConfig, error) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	factory, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("backend %q is not registered, the registered backends are: %v", name, registeredBackends())
+	}
+	return factory(), nil
+}
+
+// RegisteredBackends returns the names of the registered backends.
+func RegisteredBackends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	return registeredBackends()
+}
+
+func registeredBackends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewPosterClient returns the poster as a Client. A poster implementing
+// Client is returned as is, so that the optional interfaces it implements,
+// e.g. CertificateClient, are kept. Otherwise the Post method of the returned
+// client is not supported.
+func NewPosterClient(poster ReadingsPoster) Client {
+	if c, ok := poster.(Client); ok {
+		return c
+	}
+	return &posterClient{poster: poster}
+}
+
+// posterClient is the Client of a ReadingsPoster.
+type posterClient struct {
+	poster ReadingsPoster
+}
+
+func (c *posterClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
+	return c.poster.PostDataReadingsWithContext(context.Background(), orgID, clusterID, readings)
+}
+
+func (c *posterClient) PostDataReadingsWithContext(ctx context.Context, orgID, clusterID string, readings []*api.DataReading) error {
+	return c.poster.PostDataReadingsWithContext(ctx, orgID, clusterID, readings)
+}
+
+func (c *posterClient) Post(path string, body io.Reader) (*http.Response, error) {
+	return nil, fmt.Errorf("the backend does not support posting to %s", path)
+}
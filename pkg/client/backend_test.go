@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+type testBackendConfig struct {
+	posted *[]string
+}
+
+func (c *testBackendConfig) Validate() error { return nil }
+
+func (c *testBackendConfig) NewBackend(agentMetadata *api.AgentMetadata) (ReadingsPoster, error) {
+	return &testBackend{posted: c.posted}, nil
+}
+
+type testBackend struct {
+	posted *[]string
+}
+
+func (b *testBackend) PostDataReadingsWithContext(ctx context.Context, orgID, clusterID string, readings []*api.DataReading) error {
+	*b.posted = append(*b.posted, clusterID)
+	return nil
+}
+
+func TestRegisterBackend(t *testing.T) {
+	var posted []string
+	RegisterBackend("test", func() BackendConfig { return &testBackendConfig{posted: &posted} })
+
+	config, err := NewBackendConfig("test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	poster, err := config.NewBackend(&api.AgentMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := NewPosterClient(poster)
+	if err := c.PostDataReadings("org", "cluster", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(posted) != 1 || posted[0] != "cluster" {
+		t.Errorf("unexpected posts: %v", posted)
+	}
+	if _, err := c.Post("/api/v1/datareadings", nil); err == nil {
+		t.Errorf("expected Post to be unsupported")
+	}
+
+	if _, err := NewBackendConfig("unregistered"); err == nil {
+		t.Errorf("expected an error for an unregistered backend")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic registering the backend twice")
+		}
+	}()
+	RegisterBackend(VenafiTPPBackend, func() BackendConfig { return &VenafiTPPConfig{} })
+}
+
+func TestNewPosterClientKeepsClient(t *testing.T) {
+	c, err := NewUnauthenticatedClient(&api.AgentMetadata{}, "https://example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := NewPosterClient(c).(CertificateClient); !ok {
+		t.Errorf("expected the client to be returned as is")
+	}
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/jetstack/preflight/api"
+)
+
+// cborEncoding encodes deterministically, with the map keys sorted as RFC
+// 8949 recommends. The options are the library's own, they are valid.
+var cborEncoding, _ = cbor.CoreDetEncOptions().EncMode()
+
+// unstructuredContent is implemented by the unstructured Kubernetes objects,
+// their content is encoded directly rather than through their JSON.
+type unstructuredContent interface {
+	UnstructuredContent() map[string]interface{}
+}
+
+// marshalCBOR encodes v as CBOR, with the structure encoding/json would give
+// it, see jsonValue.
+func marshalCBOR(v interface{}) ([]byte, error) {
+	value, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return cborEncoding.Marshal(value)
+}
+
+// jsonValue returns the generic value encoding/json would decode the JSON of
+// v into, e.g. a map for a struct or a base64 string for a []byte, so that v
+// is encoded as CBOR with the same structure as its JSON. The readings, the
+// gathered resources and the unstructured content they hold, which make most
+// of an upload, are converted directly rather than encoded as JSON and
+// decoded again.
+func jsonValue(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case nil, string, bool, int64, float64:
+		return value, nil
+	case int:
+		return int64(value), nil
+	case map[string]interface{}:
+		if value == nil {
+			return nil, nil
+		}
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = converted
+		}
+		return m, nil
+	case []interface{}:
+		if value == nil {
+			return nil, nil
+		}
+		list := make([]interface{}, len(value))
+		for i, item := range value {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = converted
+		}
+		return list, nil
+	case map[string]map[string]interface{}:
+		if value == nil {
+			return nil, nil
+		}
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = converted
+		}
+		return m, nil
+	case []*api.GatheredResource:
+		if value == nil {
+			return nil, nil
+		}
+		list := make([]interface{}, len(value))
+		for i, item := range value {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = converted
+		}
+		return list, nil
+	case unstructuredContent:
+		return jsonValue(value.UnstructuredContent())
+	case *api.GatheredResource:
+		if value == nil {
+			return nil, nil
+		}
+		// the other fields are encoded as MarshalJSON encodes them
+		rest := *value
+		rest.Resource = nil
+		return withJSONValue(rest, "resource", value.Resource)
+	case api.DataReadingsPost:
+		rest := value
+		rest.DataReadings = nil
+		if value.DataReadings == nil {
+			return jsonValueOf(rest)
+		}
+		readings := make([]interface{}, len(value.DataReadings))
+		for i, reading := range value.DataReadings {
+			converted, err := jsonValue(reading)
+			if err != nil {
+				return nil, err
+			}
+			readings[i] = converted
+		}
+		m, err := jsonValueOf(rest)
+		if err != nil {
+			return nil, err
+		}
+		m.(map[string]interface{})["data_readings"] = readings
+		return m, nil
+	case *api.DataReading:
+		if value == nil {
+			return nil, nil
+		}
+		rest := *value
+		rest.Data = nil
+		return withJSONValue(rest, "data", value.Data)
+	}
+	return jsonValueOf(v)
+}
+
+// withJSONValue returns the generic value of the JSON object v, with key set
+// to the generic value of field.
+func withJSONValue(v interface{}, key string, field interface{}) (interface{}, error) {
+	m, err := jsonValueOf(v)
+	if err != nil {
+		return nil, err
+	}
+	converted, err := jsonValue(field)
+	if err != nil {
+		return nil, err
+	}
+	m.(map[string]interface{})[key] = converted
+	return m, nil
+}
+
+// jsonValueOf encodes v as JSON and decodes it into a generic value, keeping
+// the integers as integers.
+func jsonValueOf(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return numbers(value), nil
+}
+
+// numbers replaces the JSON numbers of the decoded value with integers when
+// they are, or floats.
+func numbers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			return u
+		}
+		f, _ := strconv.ParseFloat(string(value), 64)
+		return f
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = numbers(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = numbers(item)
+		}
+	}
+	return v
+}
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type cborEmbedded struct {
+	Promoted string `json:"promoted"`
+}
+
+type cborStruct struct {
+	cborEmbedded
+	Name     string            `json:"name"`
+	Skipped  string            `json:"-"`
+	Empty    string            `json:"empty,omitempty"`
+	Untagged int               `json:",omitempty"`
+	Labels   map[string]string `json:"labels"`
+	private  string
+}
+
+func TestMarshalCBOR(t *testing.T) {
+	tests := map[string]struct {
+		value    interface{}
+		expected string
+	}{
+		// examples from RFC 8949 appendix A
+		"zero":          {value: 0, expected: "00"},
+		"small integer": {value: 23, expected: "17"},
+		"one byte":      {value: 24, expected: "1818"},
+		"two bytes":     {value: 1000, expected: "1903e8"},
+		"eight bytes":   {value: uint64(1000000000000), expected: "1b000000e8d4a51000"},
+		"negative":      {value: -1000, expected: "3903e7"},
+		"float":         {value: 1.1, expected: "fb3ff199999999999a"},
+		"false":         {value: false, expected: "f4"},
+		"null":          {value: nil, expected: "f6"},
+		"string":        {value: "IETF", expected: "6449455446"},
+		"bytes":         {value: []byte{1, 2, 3, 4}, expected: "68" + hex.EncodeToString([]byte("AQIDBA=="))},
+		"array":         {value: []interface{}{1, []int{2, 3}}, expected: "8201820203"},
+		"sorted map":    {value: map[string]int{"b": 2, "a": 1}, expected: "a2616101616202"},
+		"json number":   {value: json.Number("-1000"), expected: "3903e7"},
+		"nil slice":     {value: []string(nil), expected: "f6"},
+		"struct": {
+			value: &cborStruct{
+				cborEmbedded: cborEmbedded{Promoted: "p"},
+				Name:         "n",
+				Skipped:      "s",
+				Labels:       map[string]string{"a": "b"},
+				private:      "x",
+			},
+			// {"name": "n", "labels": {"a": "b"}, "promoted": "p"}
+			expected: "a3646e616d65616e666c6162656c73a1616161626870726f6d6f7465646170",
+		},
+		"json marshaler": {
+			value:    api.Time{Time: time.Date(2021, time.March, 16, 18, 22, 15, 0, time.UTC)},
+			expected: "74" + hex.EncodeToString([]byte("2021-03-16T18:22:15Z")),
+		},
+		"gathered resource": {
+			value: &api.GatheredResource{
+				Resource:  &unstructured.Unstructured{Object: map[string]interface{}{"kind": "Pod"}},
+				DeletedAt: api.Time{Time: time.Date(2021, time.March, 16, 18, 22, 15, 0, time.UTC)},
+			},
+			// {"resource": {"kind": "Pod"}, "deleted_at": "2021-03-16T18:22:15Z"}
+			expected: "a2687265736f75726365a1646b696e6463506f646a64656c657465645f6174" + "74" + hex.EncodeToString([]byte("2021-03-16T18:22:15Z")),
+		},
+		"unstructured": {
+			value:    &unstructured.Unstructured{Object: map[string]interface{}{"kind": "Pod"}},
+			expected: "a1646b696e6463506f64",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, err := marshalCBOR(test.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := hex.EncodeToString(data); got != test.expected {
+				t.Errorf("unexpected encoding: got=%s want=%s", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestMarshalCBORUnsupported(t *testing.T) {
+	if _, err := marshalCBOR(make(chan int)); err == nil {
+		t.Errorf("expected error for unsupported type")
+	}
+}
+
+func TestMarshalCBORRoundTrip(t *testing.T) {
+	readings := benchmarkReadings(3)
+	readings = append(readings, &api.DataReading{
+		DataGatherer: "local",
+		Timestamp:    api.Time{Time: time.Date(2021, time.March, 16, 18, 22, 15, 0, time.UTC)},
+		Data: map[string]interface{}{
+			"raw":   []byte("data"),
+			"ratio": 0.5,
+			"large": uint64(1) << 63,
+		},
+		Health: &api.DataGathererHealth{Items: 3},
+	}, &api.DataReading{DataGatherer: "failed", Error: "connection refused"})
+	payload := api.DataReadingsPost{
+		AgentMetadata:  &api.AgentMetadata{Version: "v1", ClusterID: "cluster"},
+		DataGatherTime: time.Date(2021, time.March, 16, 18, 22, 15, 0, time.UTC),
+		DataReadings:   readings,
+	}
+
+	data, err := marshalCBOR(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decMode, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}{})}.DecMode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded interface{}
+	if err := decMode.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to decode the CBOR: %v", err)
+	}
+
+	// the CBOR decodes to the same value as the JSON
+	got, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := json.Unmarshal(want, &wantValue); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("the CBOR differs from the JSON:\ngot=%s\nwant=%s", got, want)
+	}
+}
+
+// benchmarkReadings returns readings of n Pods, the size of a typical upload.
+func benchmarkReadings(n int) []*api.DataReading {
+	items := make([]*api.GatheredResource, 0, n)
+	for i := 0; i < n; i++ {
+		items = append(items, &api.GatheredResource{Resource: &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]interface{}{
+				"name":      fmt.Sprintf("pod-%d", i),
+				"namespace": "default",
+				"uid":       fmt.Sprintf("uid-%d", i),
+				"labels":    map[string]interface{}{"app": "web", "tier": "frontend"},
+			},
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{
+						"name":  "web",
+						"image": "nginx:1.21",
+						"ports": []interface{}{map[string]interface{}{"containerPort": int64(80)}},
+					},
+				},
+			},
+		}}})
+	}
+	return []*api.DataReading{{
+		ClusterID:     "cluster",
+		DataGatherer:  "k8s/pods",
+		Timestamp:     api.Time{Time: time.Now()},
+		Data:          map[string]interface{}{"items": items},
+		SchemaVersion: "v2.0.0",
+	}}
+}
+
+func BenchmarkMarshalReadings(b *testing.B) {
+	readings := benchmarkReadings(1000)
+	formats := map[string]func(interface{}) ([]byte, error){
+		"json": json.Marshal,
+		"cbor": marshalCBOR,
+	}
+
+	for name, marshal := range formats {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				data, err := marshal(readings)
+				if err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+				b.SetBytes(int64(len(data)))
+			}
+		})
+	}
+}
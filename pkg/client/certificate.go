@@ -0,0 +1,36 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// CertificateClient is implemented by the clients able to authenticate to
+// the backend with a client certificate, in addition to their credentials.
+type CertificateClient interface {
+	Client
+	// SetClientCertificate sets the function returning the certificate
+	// presented to the backend. It is called on every TLS handshake, so
+	// that a rotated certificate is used by the following connections.
+	SetClientCertificate(getCertificate func() (*tls.Certificate, error))
+}
+
+// setClientCertificate makes the transport of the client present the
+// certificate. It must be called before the client sends any request.
+func setClientCertificate(c *http.Client, getCertificate func() (*tls.Certificate, error)) {
+	t, ok := c.Transport.(*http.Transport)
+	if !ok {
+		t = http.DefaultTransport.(*http.Transport).Clone()
+		c.Transport = t
+	}
+	tlsConfig := &tls.Config{}
+	if t.TLSClientConfig != nil {
+		tlsConfig = t.TLSClientConfig.Clone()
+	}
+	tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return getCertificate()
+	}
+	t.TLSClientConfig = tlsConfig
+	// the connections kept alive still present the previous certificate
+	t.CloseIdleConnections()
+}
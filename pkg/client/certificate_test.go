@@ -0,0 +1,72 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestClientCertificate(t *testing.T) {
+	var commonNames []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commonNames = append(commonNames, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	c, err := NewUnauthenticatedClient(&api.AgentMetadata{}, server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	c.client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	cert := selfSignedCertificate(t, "first")
+	c.SetClientCertificate(func() (*tls.Certificate, error) { return cert, nil })
+
+	post := func() {
+		if err := c.PostDataReadings("org", "cluster", []*api.DataReading{{DataGatherer: "dummy"}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	post()
+	// the rotated certificate is presented on the next connections
+	cert = selfSignedCertificate(t, "second")
+	c.client.Transport.(*http.Transport).CloseIdleConnections()
+	post()
+
+	if len(commonNames) != 2 || commonNames[0] != "first" || commonNames[1] != "second" {
+		t.Errorf("unexpected client certificates: %v", commonNames)
+	}
+}
+
+func selfSignedCertificate(t *testing.T, commonName string) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +10,9 @@ import (
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -29,6 +33,13 @@ type (
 		Post(path string, body io.Reader) (*http.Response, error)
 	}
 
+	// ContextClient is implemented by the clients able to trace their uploads
+	// as part of the span of a context.
+	ContextClient interface {
+		Client
+		ReadingsPoster
+	}
+
 	// Credentials defines the format of the credentials.json file.
 	Credentials struct {
 		// UserID is the ID or email for the user or service account.
@@ -47,6 +58,28 @@ type (
 	}
 )
 
+// UploadError is returned when the backend rejects an upload of readings.
+type UploadError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("received response with status code %d. Body: %s", e.StatusCode, e.Body)
+}
+
+// Rejected returns true if the upload was rejected for what it is, e.g. as
+// malformed or too large, so that sending it again cannot succeed. The
+// authentication and authorization failures, timeouts and rate limiting are
+// not rejections, as they can resolve on their own.
+func (e *UploadError) Rejected() bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
 // ParseCredentials reads credentials into a struct used. Performs validations.
 func ParseCredentials(data []byte) (*Credentials, error) {
 	var credentials Credentials
@@ -96,3 +129,30 @@ func fullURL(baseURL, path string) string {
 	}
 	return fmt.Sprintf("%s/%s", base, path)
 }
+
+// marshalPayload encodes the payload of an upload in the format.
+func marshalPayload(ctx context.Context, format Format, payload api.DataReadingsPost) ([]byte, error) {
+	_, span := tracing.Start(ctx, "marshal")
+	defer span.End()
+	span.SetAttributes(
+		attribute.Int("readings", len(payload.DataReadings)),
+		attribute.String("format", string(format)),
+	)
+
+	data, err := format.marshal(payload)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int("size_bytes", len(data)))
+	return data, err
+}
+
+// traceResponse records the outcome of a request on its span.
+func traceResponse(span trace.Span, res *http.Response, err error) {
+	if err != nil {
+		tracing.RecordError(span, err)
+		return
+	}
+	span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		tracing.RecordError(span, fmt.Errorf("received response with status code %d", res.StatusCode))
+	}
+}
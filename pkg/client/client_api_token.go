@@ -1,26 +1,33 @@
 package client
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"github.com/jetstack/preflight/pkg/transport"
 )
 
 type (
 	// The APITokenClient type is a Client implementation used to upload data readings to the Jetstack Secure platform
 	// using API tokens as its authentication method.
 	APITokenClient struct {
+		mu            sync.Mutex
 		apiToken      string
 		baseURL       string
 		agentMetadata *api.AgentMetadata
 		client        *http.Client
+		compressor    *compressor
+		formats       *formatNegotiator
+		directives    directivesReceiver
 	}
 )
 
@@ -35,24 +42,30 @@ func NewAPITokenClient(agentMetadata *api.AgentMetadata, apiToken, baseURL strin
 		apiToken:      apiToken,
 		agentMetadata: agentMetadata,
 		baseURL:       baseURL,
-		client:        &http.Client{Timeout: time.Minute},
+		client:        transport.Client(time.Minute),
+		compressor:    newCompressor(CompressionGzip),
+		formats:       newFormatNegotiator(FormatJSON),
 	}, nil
 }
 
 // PostDataReadings uploads the slice of api.DataReading to the Jetstack Secure backend to be processed for later
 // viewing in the user-interface.
 func (c *APITokenClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
+	return c.PostDataReadingsWithContext(context.Background(), orgID, clusterID, readings)
+}
+
+// PostDataReadingsWithContext uploads the readings as PostDataReadings, tracing
+// the upload as part of the span of the context.
+func (c *APITokenClient) PostDataReadingsWithContext(ctx context.Context, orgID, clusterID string, readings []*api.DataReading) error {
 	payload := api.DataReadingsPost{
 		AgentMetadata:  c.agentMetadata,
-		DataGatherTime: time.Now().UTC(),
+		DataGatherTime: dataGatherTime(ctx),
 		DataReadings:   readings,
 	}
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	res, err := c.Post(filepath.Join("/api/v1/org", orgID, "datareadings", clusterID), bytes.NewBuffer(data))
+	path := filepath.Join("/api/v1/org", orgID, "datareadings", clusterID)
+	res, err := c.formats.upload(ctx, payload, func(header http.Header, body io.Reader) (*http.Response, error) {
+		return c.post(ctx, path, header, body)
+	})
 	if err != nil {
 		return err
 	}
@@ -65,21 +78,82 @@ func (c *APITokenClient) PostDataReadings(orgID, clusterID string, readings []*a
 			errorContent = string(body)
 		}
 
-		return fmt.Errorf("received response with status code %d. Body: %s", code, errorContent)
+		return &UploadError{StatusCode: code, Body: errorContent}
 	}
+	c.directives.receive(res)
 
 	return nil
 }
 
 // Post performs an HTTP POST request.
 func (c *APITokenClient) Post(path string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodPost, fullURL(c.baseURL, path), body)
-	if err != nil {
-		return nil, err
-	}
+	return c.post(context.Background(), path, contentTypeHeader(FormatJSON), body)
+}
+
+func (c *APITokenClient) post(ctx context.Context, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	ctx, span := tracing.StartClient(ctx, "POST")
+	defer span.End()
+
+	c.mu.Lock()
+	apiToken := c.apiToken
+	c.mu.Unlock()
+
+	res, err := c.compressor.do(ctx, body, func(body io.Reader, contentEncoding string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullURL(c.baseURL, path), body)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiToken))
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiToken))
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+
+		tracing.Inject(ctx, req.Header)
+		return c.client.Do(req)
+	})
+	traceResponse(span, res, err)
+	return res, err
+}
+
+// SetAPIToken replaces the API token of the following requests, e.g. once
+// it has been rotated.
+func (c *APITokenClient) SetAPIToken(apiToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apiToken = apiToken
+}
+
+// SetCompression sets the compression of the uploads.
+func (c *APITokenClient) SetCompression(compression Compression) {
+	c.compressor.set(compression)
+}
+
+// SetFormat sets the format of the uploads of readings.
+func (c *APITokenClient) SetFormat(format Format) {
+	c.formats.set(format)
+}
+
+// SetSigner sets the signer of the attestations of the uploads of readings.
+func (c *APITokenClient) SetSigner(signer Signer) {
+	c.formats.setSigner(signer)
+}
+
+// SetClientCertificate sets the client certificate presented to the backend.
+func (c *APITokenClient) SetClientCertificate(getCertificate func() (*tls.Certificate, error)) {
+	setClientCertificate(c.client, getCertificate)
+}
+
+// Directives returns the directives the backend sent since the previous call.
+func (c *APITokenClient) Directives() *api.Directives {
+	return c.directives.take()
+}
 
-	return c.client.Do(req)
+// Receipt returns the receipt ID of the last successful upload.
+func (c *APITokenClient) Receipt() string {
+	return c.directives.lastReceipt()
 }
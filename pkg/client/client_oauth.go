@@ -2,6 +2,8 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,9 +12,13 @@ import (
 	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"github.com/jetstack/preflight/pkg/transport"
 	"github.com/juju/errors"
 )
 
@@ -20,66 +26,95 @@ type (
 	// The OAuthClient type is a Client implementation used to upload data readings to the Jetstack Secure platform
 	// using OAuth as its authentication method.
 	OAuthClient struct {
+		// mu guards the credentials and the access token, which are
+		// replaced when the credentials are rotated.
+		mu            sync.Mutex
 		credentials   *Credentials
 		accessToken   *accessToken
 		baseURL       string
 		agentMetadata *api.AgentMetadata
 		client        *http.Client
+		compressor    *compressor
+		formats       *formatNegotiator
+		directives    directivesReceiver
 	}
 
 	accessToken struct {
 		bearer         string
 		expirationDate time.Time
+		// renewDate is when the token is renewed, before it expires so that
+		// it does not expire during an upload.
+		renewDate time.Time
 	}
 )
 
+// accessTokenRenewBefore is how long before they expire the access tokens
+// are renewed. The tokens living less than twice as long are renewed halfway
+// through their lifetime.
+const accessTokenRenewBefore = time.Minute
+
 func (t *accessToken) needsRenew() bool {
-	return t.bearer == "" || time.Now().After(t.expirationDate)
+	return t.bearer == "" || !time.Now().Before(t.renewDate)
+}
+
+// expired returns true if the token cannot be used anymore. A token which
+// failed to be renewed is used until then.
+func (t *accessToken) expired() bool {
+	return t.bearer == "" || !time.Now().Before(t.expirationDate)
+}
+
+// setExpiration sets when the token expires, and when it is renewed.
+func (t *accessToken) setExpiration(now, expirationDate time.Time) {
+	renewBefore := accessTokenRenewBefore
+	if lifetime := expirationDate.Sub(now); lifetime < 2*renewBefore {
+		renewBefore = lifetime / 2
+	}
+	if renewBefore < 0 {
+		renewBefore = 0
+	}
+	t.expirationDate = expirationDate
+	t.renewDate = expirationDate.Add(-renewBefore)
 }
 
 // NewOAuthClient returns a new instance of the OAuthClient type that will perform HTTP requests using OAuth to provide
 // authentication tokens to the backend API.
 func NewOAuthClient(agentMetadata *api.AgentMetadata, credentials *Credentials, baseURL string) (*OAuthClient, error) {
-	if err := credentials.validate(); err != nil {
+	if err := prepareCredentials(credentials); err != nil {
 		return nil, fmt.Errorf("cannot create OAuthClient: %v", err)
 	}
 	if baseURL == "" {
 		return nil, fmt.Errorf("cannot create OAuthClient: baseURL cannot be empty")
 	}
 
-	if !credentials.IsClientSet() {
-		credentials.ClientID = ClientID
-		credentials.ClientSecret = ClientSecret
-		credentials.AuthServerDomain = AuthServerDomain
-	}
-
-	if !credentials.IsClientSet() {
-		return nil, fmt.Errorf("cannot create OAuthClient: invalid OAuth2 client configuration")
-	}
-
 	return &OAuthClient{
 		agentMetadata: agentMetadata,
 		credentials:   credentials,
 		baseURL:       baseURL,
 		accessToken:   &accessToken{},
-		client:        &http.Client{Timeout: time.Minute},
+		client:        transport.Client(time.Minute),
+		compressor:    newCompressor(CompressionGzip),
+		formats:       newFormatNegotiator(FormatJSON),
 	}, nil
 }
 
 // PostDataReadings uploads the slice of api.DataReading to the Jetstack Secure backend to be processed for later
 // viewing in the user-interface.
 func (c *OAuthClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
+	return c.PostDataReadingsWithContext(context.Background(), orgID, clusterID, readings)
+}
+
+// PostDataReadingsWithContext uploads the readings as PostDataReadings, tracing
+// the upload as part of the span of the context.
+func (c *OAuthClient) PostDataReadingsWithContext(ctx context.Context, orgID, clusterID string, readings []*api.DataReading) error {
 	payload := api.DataReadingsPost{
 		AgentMetadata:  c.agentMetadata,
-		DataGatherTime: time.Now().UTC(),
+		DataGatherTime: dataGatherTime(ctx),
 		DataReadings:   readings,
 	}
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	res, err := c.Post(filepath.Join("/api/v1/org", orgID, "datareadings", clusterID), bytes.NewBuffer(data))
+	path := filepath.Join("/api/v1/org", orgID, "datareadings", clusterID)
+	res, err := c.formats.upload(ctx, payload, func(header http.Header, body io.Reader) (*http.Response, error) {
+		return c.post(ctx, path, header, body)
+	})
 	if err != nil {
 		return err
 	}
@@ -92,45 +127,166 @@ func (c *OAuthClient) PostDataReadings(orgID, clusterID string, readings []*api.
 			errorContent = string(body)
 		}
 
-		return fmt.Errorf("received response with status code %d. Body: %s", code, errorContent)
+		return &UploadError{StatusCode: code, Body: errorContent}
 	}
+	c.directives.receive(res)
 
 	return nil
 }
 
 // Post performs an HTTP POST request.
 func (c *OAuthClient) Post(path string, body io.Reader) (*http.Response, error) {
-	token, err := c.getValidAccessToken()
+	return c.post(context.Background(), path, contentTypeHeader(FormatJSON), body)
+}
+
+// post sends the request with a valid access token. A request rejected with
+// a 401 Unauthorized response, e.g. as the token was revoked, is sent again
+// once with a new token.
+func (c *OAuthClient) post(ctx context.Context, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	ctx, span := tracing.StartClient(ctx, "POST")
+	defer span.End()
+
+	// the body is sent again on a 401 response
+	data, err := ioutil.ReadAll(body)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, fullURL(c.baseURL, path), body)
-	if err != nil {
-		return nil, err
+	for attempt := 1; ; attempt++ {
+		token, err := c.getValidAccessToken()
+		if err != nil {
+			tracing.RecordError(span, err)
+			return nil, err
+		}
+
+		res, err := c.compressor.do(ctx, bytes.NewReader(data), func(body io.Reader, contentEncoding string) (*http.Response, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullURL(c.baseURL, path), body)
+			if err != nil {
+				return nil, err
+			}
+
+			for name, values := range header {
+				req.Header[name] = values
+			}
+
+			if len(token.bearer) > 0 {
+				req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.bearer))
+			}
+
+			if contentEncoding != "" {
+				req.Header.Set("Content-Encoding", contentEncoding)
+			}
+
+			tracing.Inject(ctx, req.Header)
+			return c.client.Do(req)
+		})
+		if err != nil || res.StatusCode != http.StatusUnauthorized || attempt == 2 {
+			if err == nil && res.StatusCode == http.StatusUnauthorized {
+				// a new token is requested by the next upload
+				c.invalidateAccessToken(token)
+			}
+			traceResponse(span, res, err)
+			return res, err
+		}
+		res.Body.Close()
+		c.invalidateAccessToken(token)
+	}
+}
+
+// invalidateAccessToken discards the token rejected by the backend, so that
+// a new one is requested. The token is only discarded if it was not renewed
+// already, so that the uploads rejected concurrently renew it once.
+func (c *OAuthClient) invalidateAccessToken(token *accessToken) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.accessToken.bearer == token.bearer {
+		c.accessToken = &accessToken{}
+	}
+}
+
+// SetCredentials replaces the credentials, e.g. once they have been rotated.
+// The access token of the previous credentials is discarded.
+func (c *OAuthClient) SetCredentials(credentials *Credentials) error {
+	if err := prepareCredentials(credentials); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.credentials = credentials
+	c.accessToken = &accessToken{}
+	return nil
+}
+
+// prepareCredentials validates the credentials, and defaults their OAuth2
+// client to the one injected at build time.
+func prepareCredentials(credentials *Credentials) error {
+	if err := credentials.validate(); err != nil {
+		return err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	if !credentials.IsClientSet() {
+		credentials.ClientID = ClientID
+		credentials.ClientSecret = ClientSecret
+		credentials.AuthServerDomain = AuthServerDomain
+	}
 
-	if len(token.bearer) > 0 {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.bearer))
+	if !credentials.IsClientSet() {
+		return fmt.Errorf("invalid OAuth2 client configuration")
 	}
+	return nil
+}
+
+// SetCompression sets the compression of the uploads.
+func (c *OAuthClient) SetCompression(compression Compression) {
+	c.compressor.set(compression)
+}
+
+// SetFormat sets the format of the uploads of readings.
+func (c *OAuthClient) SetFormat(format Format) {
+	c.formats.set(format)
+}
+
+// SetSigner sets the signer of the attestations of the uploads of readings.
+func (c *OAuthClient) SetSigner(signer Signer) {
+	c.formats.setSigner(signer)
+}
+
+// SetClientCertificate sets the client certificate presented to the backend.
+func (c *OAuthClient) SetClientCertificate(getCertificate func() (*tls.Certificate, error)) {
+	setClientCertificate(c.client, getCertificate)
+}
+
+// Directives returns the directives the backend sent since the previous call.
+func (c *OAuthClient) Directives() *api.Directives {
+	return c.directives.take()
+}
 
-	return c.client.Do(req)
+// Receipt returns the receipt ID of the last successful upload.
+func (c *OAuthClient) Receipt() string {
+	return c.directives.lastReceipt()
 }
 
 // getValidAccessToken returns a valid access token. It will fetch a new access
 // token from the auth server in case the current access token does not exist
 // or it is expired.
 func (c *OAuthClient) getValidAccessToken() (*accessToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.accessToken.needsRenew() {
 		err := c.renewAccessToken()
-		if err != nil {
+		if err != nil && c.accessToken.expired() {
 			return nil, err
 		}
+		if err != nil {
+			logs.Log.Warnf("Failed to renew the access token, using the current one until it expires at %s: %v", c.accessToken.expirationDate.Format(time.RFC3339), err)
+		}
 	}
 
-	return c.accessToken, nil
+	// a copy, as the token is renewed in place
+	token := *c.accessToken
+	return &token, nil
 }
 
 func (c *OAuthClient) renewAccessToken() error {
@@ -149,7 +305,7 @@ func (c *OAuthClient) renewAccessToken() error {
 	}
 	req.Header.Add("content-type", "application/x-www-form-urlencoded")
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := c.client.Do(req)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -179,8 +335,9 @@ func (c *OAuthClient) renewAccessToken() error {
 		return errors.Errorf("got wrong expiration for access token")
 	}
 
+	now := time.Now()
 	c.accessToken.bearer = response.Bearer
-	c.accessToken.expirationDate = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	c.accessToken.setExpiration(now, now.Add(time.Duration(response.ExpiresIn)*time.Second))
 
 	return nil
 }
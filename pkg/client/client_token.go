@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"github.com/jetstack/preflight/pkg/transport"
+	"golang.org/x/oauth2"
+)
+
+type (
+	// The TokenClient type is a Client implementation used to upload data readings to the Jetstack Secure platform
+	// using the bearer tokens of an OAuth2 token source, e.g. of the client credentials flow or of a token exchange.
+	TokenClient struct {
+		mu            sync.Mutex
+		tokens        oauth2.TokenSource
+		baseURL       string
+		agentMetadata *api.AgentMetadata
+		client        *http.Client
+		compressor    *compressor
+		formats       *formatNegotiator
+		directives    directivesReceiver
+	}
+)
+
+// NewTokenClient returns a new instance of the TokenClient type that will perform HTTP requests using
+// the tokens of the source for authentication. The tokens are cached until they expire.
+func NewTokenClient(agentMetadata *api.AgentMetadata, tokens oauth2.TokenSource, baseURL string) (*TokenClient, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("cannot create TokenClient: baseURL cannot be empty")
+	}
+
+	return &TokenClient{
+		tokens:        oauth2.ReuseTokenSource(nil, tokens),
+		agentMetadata: agentMetadata,
+		baseURL:       baseURL,
+		client:        transport.Client(time.Minute),
+		compressor:    newCompressor(CompressionGzip),
+		formats:       newFormatNegotiator(FormatJSON),
+	}, nil
+}
+
+// PostDataReadings uploads the slice of api.DataReading to the Jetstack Secure backend to be processed for later
+// viewing in the user-interface.
+func (c *TokenClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
+	return c.PostDataReadingsWithContext(context.Background(), orgID, clusterID, readings)
+}
+
+// PostDataReadingsWithContext uploads the readings as PostDataReadings, tracing
+// the upload as part of the span of the context.
+func (c *TokenClient) PostDataReadingsWithContext(ctx context.Context, orgID, clusterID string, readings []*api.DataReading) error {
+	payload := api.DataReadingsPost{
+		AgentMetadata:  c.agentMetadata,
+		DataGatherTime: dataGatherTime(ctx),
+		DataReadings:   readings,
+	}
+	path := filepath.Join("/api/v1/org", orgID, "datareadings", clusterID)
+	res, err := c.formats.upload(ctx, payload, func(header http.Header, body io.Reader) (*http.Response, error) {
+		return c.post(ctx, path, header, body)
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if code := res.StatusCode; code < 200 || code >= 300 {
+		errorContent := ""
+		body, err := ioutil.ReadAll(res.Body)
+		if err == nil {
+			errorContent = string(body)
+		}
+
+		return &UploadError{StatusCode: code, Body: errorContent}
+	}
+	c.directives.receive(res)
+
+	return nil
+}
+
+// Post performs an HTTP POST request.
+func (c *TokenClient) Post(path string, body io.Reader) (*http.Response, error) {
+	return c.post(context.Background(), path, contentTypeHeader(FormatJSON), body)
+}
+
+func (c *TokenClient) post(ctx context.Context, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	ctx, span := tracing.StartClient(ctx, "POST")
+	defer span.End()
+
+	c.mu.Lock()
+	tokens := c.tokens
+	c.mu.Unlock()
+
+	token, err := tokens.Token()
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to get a token: %v", err)
+	}
+
+	res, err := c.compressor.do(ctx, body, func(body io.Reader, contentEncoding string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullURL(c.baseURL, path), body)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		token.SetAuthHeader(req)
+
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+
+		tracing.Inject(ctx, req.Header)
+		return c.client.Do(req)
+	})
+	traceResponse(span, res, err)
+	return res, err
+}
+
+// SetTokenSource replaces the source of the tokens, e.g. once its client
+// secret has been rotated. The cached token is discarded.
+func (c *TokenClient) SetTokenSource(tokens oauth2.TokenSource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens = oauth2.ReuseTokenSource(nil, tokens)
+}
+
+// SetCompression sets the compression of the uploads.
+func (c *TokenClient) SetCompression(compression Compression) {
+	c.compressor.set(compression)
+}
+
+// SetFormat sets the format of the uploads of readings.
+func (c *TokenClient) SetFormat(format Format) {
+	c.formats.set(format)
+}
+
+// SetSigner sets the signer of the attestations of the uploads of readings.
+func (c *TokenClient) SetSigner(signer Signer) {
+	c.formats.setSigner(signer)
+}
+
+// SetClientCertificate sets the client certificate presented to the backend.
+func (c *TokenClient) SetClientCertificate(getCertificate func() (*tls.Certificate, error)) {
+	setClientCertificate(c.client, getCertificate)
+}
+
+// Directives returns the directives the backend sent since the previous call.
+func (c *TokenClient) Directives() *api.Directives {
+	return c.directives.take()
+}
+
+// Receipt returns the receipt ID of the last successful upload.
+func (c *TokenClient) Receipt() string {
+	return c.directives.lastReceipt()
+}
@@ -1,8 +1,8 @@
 package client
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"github.com/jetstack/preflight/pkg/transport"
 )
 
 type (
@@ -20,6 +22,9 @@ type (
 		baseURL       string
 		agentMetadata *api.AgentMetadata
 		client        *http.Client
+		compressor    *compressor
+		formats       *formatNegotiator
+		directives    directivesReceiver
 	}
 )
 
@@ -33,24 +38,30 @@ func NewUnauthenticatedClient(agentMetadata *api.AgentMetadata, baseURL string)
 	return &UnauthenticatedClient{
 		agentMetadata: agentMetadata,
 		baseURL:       baseURL,
-		client:        &http.Client{Timeout: time.Minute},
+		client:        transport.Client(time.Minute),
+		compressor:    newCompressor(CompressionGzip),
+		formats:       newFormatNegotiator(FormatJSON),
 	}, nil
 }
 
 // PostDataReadings uploads the slice of api.DataReading to the Jetstack Secure backend to be processed for later
 // viewing in the user-interface.
 func (c *UnauthenticatedClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
+	return c.PostDataReadingsWithContext(context.Background(), orgID, clusterID, readings)
+}
+
+// PostDataReadingsWithContext uploads the readings as PostDataReadings, tracing
+// the upload as part of the span of the context.
+func (c *UnauthenticatedClient) PostDataReadingsWithContext(ctx context.Context, orgID, clusterID string, readings []*api.DataReading) error {
 	payload := api.DataReadingsPost{
 		AgentMetadata:  c.agentMetadata,
-		DataGatherTime: time.Now().UTC(),
+		DataGatherTime: dataGatherTime(ctx),
 		DataReadings:   readings,
 	}
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	res, err := c.Post(filepath.Join("/api/v1/org", orgID, "datareadings", clusterID), bytes.NewBuffer(data))
+	path := filepath.Join("/api/v1/org", orgID, "datareadings", clusterID)
+	res, err := c.formats.upload(ctx, payload, func(header http.Header, body io.Reader) (*http.Response, error) {
+		return c.post(ctx, path, header, body)
+	})
 	if err != nil {
 		return err
 	}
@@ -63,20 +74,69 @@ func (c *UnauthenticatedClient) PostDataReadings(orgID, clusterID string, readin
 			errorContent = string(body)
 		}
 
-		return fmt.Errorf("received response with status code %d. Body: %s", code, errorContent)
+		return &UploadError{StatusCode: code, Body: errorContent}
 	}
+	c.directives.receive(res)
 
 	return nil
 }
 
 // Post performs an HTTP POST request.
 func (c *UnauthenticatedClient) Post(path string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodPost, fullURL(c.baseURL, path), body)
-	if err != nil {
-		return nil, err
-	}
+	return c.post(context.Background(), path, contentTypeHeader(FormatJSON), body)
+}
+
+func (c *UnauthenticatedClient) post(ctx context.Context, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	ctx, span := tracing.StartClient(ctx, "POST")
+	defer span.End()
+
+	res, err := c.compressor.do(ctx, body, func(body io.Reader, contentEncoding string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullURL(c.baseURL, path), body)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, values := range header {
+			req.Header[name] = values
+		}
 
-	req.Header.Set("Content-Type", "application/json")
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+
+		tracing.Inject(ctx, req.Header)
+		return c.client.Do(req)
+	})
+	traceResponse(span, res, err)
+	return res, err
+}
+
+// SetCompression sets the compression of the uploads.
+func (c *UnauthenticatedClient) SetCompression(compression Compression) {
+	c.compressor.set(compression)
+}
+
+// SetFormat sets the format of the uploads of readings.
+func (c *UnauthenticatedClient) SetFormat(format Format) {
+	c.formats.set(format)
+}
+
+// SetSigner sets the signer of the attestations of the uploads of readings.
+func (c *UnauthenticatedClient) SetSigner(signer Signer) {
+	c.formats.setSigner(signer)
+}
+
+// SetClientCertificate sets the client certificate presented to the backend.
+func (c *UnauthenticatedClient) SetClientCertificate(getCertificate func() (*tls.Certificate, error)) {
+	setClientCertificate(c.client, getCertificate)
+}
+
+// Directives returns the directives the backend sent since the previous call.
+func (c *UnauthenticatedClient) Directives() *api.Directives {
+	return c.directives.take()
+}
 
-	return c.client.Do(req)
+// Receipt returns the receipt ID of the last successful upload.
+func (c *UnauthenticatedClient) Receipt() string {
+	return c.directives.lastReceipt()
 }
@@ -0,0 +1,364 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"github.com/jetstack/preflight/pkg/transport"
+	"github.com/juju/errors"
+)
+
+const (
+	// VenafiTPPBackend is the name the Venafi Trust Protection Platform
+	// backend is registered with.
+	VenafiTPPBackend = "venafi-tpp"
+
+	// DefaultVenafiTPPUploadPath is the path of the Venafi Trust Protection
+	// Platform endpoint receiving the readings of a cluster.
+	DefaultVenafiTPPUploadPath = "/vedsdk/discovery/kubernetes/datareadings"
+)
+
+func init() {
+	RegisterBackend(VenafiTPPBackend, func() BackendConfig { return &VenafiTPPConfig{} })
+}
+
+type (
+	// The VenafiTPPClient type is a Client implementation used to upload data readings to an on-prem Venafi Trust
+	// Protection Platform, authenticating with the tokens of its OAuth API.
+	VenafiTPPClient struct {
+		credentials   *VenafiTPPCredentials
+		baseURL       string
+		uploadPath    string
+		agentMetadata *api.AgentMetadata
+		client        *http.Client
+
+		// mu guards the tokens, which are refreshed by concurrent uploads.
+		mu           sync.Mutex
+		accessToken  *accessToken
+		refreshToken string
+	}
+
+	// VenafiTPPCredentials are the credentials of the Venafi Trust Protection
+	// Platform OAuth API.
+	VenafiTPPCredentials struct {
+		// ClientID is the ID of the API integration registered in TPP.
+		ClientID string
+		// Scope is the scope of the tokens, e.g. certificate:discover.
+		Scope string
+		// Username and Password authenticate the agent to get a token.
+		Username string
+		Password string
+		// AccessToken is a token issued beforehand, used instead of the
+		// username and password. It cannot be refreshed.
+		AccessToken string
+	}
+)
+
+// VenafiTPPConfig is the configuration of the Venafi Trust Protection Platform
+// backend.
+type VenafiTPPConfig struct {
+	// URL is the base URL of TPP, e.g. https://tpp.example.com.
+	URL string `yaml:"url"`
+	// UploadPath is the path of the endpoint receiving the readings, the
+	// cluster ID is appended to it.
+	UploadPath string `yaml:"upload-path,omitempty"`
+	// CABundle is the path of a PEM bundle of the certificate authorities
+	// of TPP, trusted in addition to the system ones.
+	CABundle string `yaml:"ca-bundle,omitempty"`
+	// ClientID and Scope are the API integration of TPP the tokens are
+	// requested for.
+	ClientID string `yaml:"client-id,omitempty"`
+	Scope    string `yaml:"scope,omitempty"`
+	// Username and PasswordFile authenticate the agent to get tokens.
+	Username     string `yaml:"username,omitempty"`
+	PasswordFile string `yaml:"password-file,omitempty"`
+	// AccessTokenFile is the path of a token issued beforehand, used instead
+	// of the username and password.
+	AccessTokenFile string `yaml:"access-token-file,omitempty"`
+}
+
+// Validate validates the configuration.
+func (c *VenafiTPPConfig) Validate() error {
+	if u, err := url.Parse(c.URL); err != nil || u.Hostname() == "" {
+		return fmt.Errorf("venafi-tpp.url is not a valid URL")
+	}
+	password := c.Username != "" || c.PasswordFile != ""
+	switch {
+	case password && c.AccessTokenFile != "":
+		return fmt.Errorf("venafi-tpp cannot use both a password and an access token")
+	case password && (c.ClientID == "" || c.Username == "" || c.PasswordFile == ""):
+		return fmt.Errorf("venafi-tpp.client-id, venafi-tpp.username and venafi-tpp.password-file must be set together")
+	case !password && c.AccessTokenFile == "":
+		return fmt.Errorf("venafi-tpp requires username and password-file, or access-token-file")
+	}
+	return nil
+}
+
+// NewBackend returns the VenafiTPPClient of the configuration.
+func (c *VenafiTPPConfig) NewBackend(agentMetadata *api.AgentMetadata) (ReadingsPoster, error) {
+	credentials := &VenafiTPPCredentials{
+		ClientID: c.ClientID,
+		Scope:    c.Scope,
+		Username: c.Username,
+	}
+	var err error
+	if c.PasswordFile != "" {
+		if credentials.Password, err = readSecretFile(c.PasswordFile); err != nil {
+			return nil, fmt.Errorf("failed to load the venafi-tpp password: %v", err)
+		}
+	}
+	if c.AccessTokenFile != "" {
+		if credentials.AccessToken, err = readSecretFile(c.AccessTokenFile); err != nil {
+			return nil, fmt.Errorf("failed to load the venafi-tpp access token: %v", err)
+		}
+	}
+	return NewVenafiTPPClient(agentMetadata, credentials, c.URL, c.UploadPath, c.CABundle)
+}
+
+// readSecretFile returns the content of the file without the surrounding
+// whitespace.
+func readSecretFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// NewVenafiTPPClient returns a new instance of the VenafiTPPClient type that will post the readings to the upload
+// path of the TPP server at baseURL. If caBundle is not empty, the certificate authorities of the PEM bundle are
+// trusted in addition to the system ones, as TPP is often served with a certificate of an internal CA.
+func NewVenafiTPPClient(agentMetadata *api.AgentMetadata, credentials *VenafiTPPCredentials, baseURL, uploadPath, caBundle string) (*VenafiTPPClient, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("cannot create VenafiTPPClient: baseURL cannot be empty")
+	}
+	if credentials.AccessToken == "" && (credentials.ClientID == "" || credentials.Username == "" || credentials.Password == "") {
+		return nil, fmt.Errorf("cannot create VenafiTPPClient: an access token, or a client ID, username and password are required")
+	}
+	if uploadPath == "" {
+		uploadPath = DefaultVenafiTPPUploadPath
+	}
+
+	t := transport.New()
+	if caBundle != "" {
+		if err := transport.SetCABundle(t, caBundle); err != nil {
+			return nil, fmt.Errorf("cannot create VenafiTPPClient: %v", err)
+		}
+	}
+
+	return &VenafiTPPClient{
+		credentials:   credentials,
+		baseURL:       baseURL,
+		uploadPath:    uploadPath,
+		agentMetadata: agentMetadata,
+		client:        &http.Client{Transport: t, Timeout: time.Minute},
+		accessToken:   &accessToken{},
+	}, nil
+}
+
+// PostDataReadings uploads the slice of api.DataReading to TPP. TPP has no organizations, so orgID is ignored.
+func (c *VenafiTPPClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
+	return c.PostDataReadingsWithContext(context.Background(), orgID, clusterID, readings)
+}
+
+// PostDataReadingsWithContext uploads the readings as PostDataReadings, tracing
+// the upload as part of the span of the context.
+func (c *VenafiTPPClient) PostDataReadingsWithContext(ctx context.Context, orgID, clusterID string, readings []*api.DataReading) error {
+	payload := api.DataReadingsPost{
+		AgentMetadata:  c.agentMetadata,
+		DataGatherTime: dataGatherTime(ctx),
+		DataReadings:   readings,
+	}
+	data, err := marshalPayload(ctx, FormatJSON, payload)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.post(ctx, filepath.Join(c.uploadPath, clusterID), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	RecordPayload(ctx, data)
+
+	if code := res.StatusCode; code < 200 || code >= 300 {
+		errorContent := ""
+		body, err := ioutil.ReadAll(res.Body)
+		if err == nil {
+			errorContent = string(body)
+		}
+
+		return &UploadError{StatusCode: code, Body: errorContent}
+	}
+
+	return nil
+}
+
+// Post performs an HTTP POST request.
+func (c *VenafiTPPClient) Post(path string, body io.Reader) (*http.Response, error) {
+	return c.post(context.Background(), path, body)
+}
+
+// post sends the request with a valid access token. A request rejected with
+// a 401 Unauthorized response, e.g. as the token was revoked, is sent again
+// once with a new token, unless it is the access token of the credentials.
+func (c *VenafiTPPClient) post(ctx context.Context, path string, body io.Reader) (*http.Response, error) {
+	ctx, span := tracing.StartClient(ctx, "POST")
+	defer span.End()
+
+	// the body is sent again on a 401 response
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		token, err := c.getValidAccessToken()
+		if err != nil {
+			tracing.RecordError(span, err)
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullURL(c.baseURL, path), bytes.NewReader(data))
+		if err != nil {
+			tracing.RecordError(span, err)
+			return nil, err
+		}
+		req.Header.Set("Content-Type", FormatJSON.contentType())
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.bearer))
+		tracing.Inject(ctx, req.Header)
+
+		res, err := c.client.Do(req)
+		if err != nil || res.StatusCode != http.StatusUnauthorized || c.credentials.AccessToken != "" || attempt == 2 {
+			if err == nil && res.StatusCode == http.StatusUnauthorized {
+				// a new token is requested by the next upload
+				c.invalidateAccessToken(token)
+			}
+			traceResponse(span, res, err)
+			return res, err
+		}
+		res.Body.Close()
+		c.invalidateAccessToken(token)
+	}
+}
+
+// invalidateAccessToken discards the token rejected by TPP, so that a new one
+// is requested. The token is only discarded if it was not renewed already, so
+// that the uploads rejected concurrently renew it once.
+func (c *VenafiTPPClient) invalidateAccessToken(token *accessToken) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.accessToken.bearer == token.bearer {
+		c.accessToken = &accessToken{}
+	}
+}
+
+// SetClientCertificate sets the client certificate presented to TPP.
+func (c *VenafiTPPClient) SetClientCertificate(getCertificate func() (*tls.Certificate, error)) {
+	setClientCertificate(c.client, getCertificate)
+}
+
+// getValidAccessToken returns a valid access token. It refreshes the current
+// token when it expires, or requests a new one with the username and password
+// when it cannot be refreshed. An access token of the credentials is used
+// until TPP rejects it.
+func (c *VenafiTPPClient) getValidAccessToken() (*accessToken, error) {
+	if c.credentials.AccessToken != "" {
+		return &accessToken{bearer: c.credentials.AccessToken}, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.accessToken.needsRenew() {
+		var err error
+		if c.refreshToken != "" {
+			err = c.renewAccessToken("/vedauth/authorize/token", map[string]string{
+				"client_id":     c.credentials.ClientID,
+				"refresh_token": c.refreshToken,
+			})
+		}
+		if c.refreshToken == "" || err != nil {
+			err = c.renewAccessToken("/vedauth/authorize/oauth", map[string]string{
+				"client_id": c.credentials.ClientID,
+				"username":  c.credentials.Username,
+				"password":  c.credentials.Password,
+				"scope":     c.credentials.Scope,
+			})
+		}
+		if err != nil && c.accessToken.expired() {
+			return nil, err
+		}
+		if err != nil {
+			logs.Log.Warnf("Failed to renew the access token, using the current one until it expires at %s: %v", c.accessToken.expirationDate.Format(time.RFC3339), err)
+		}
+	}
+
+	// a copy, as the token is renewed in place
+	token := *c.accessToken
+	return &token, nil
+}
+
+func (c *VenafiTPPClient) renewAccessToken(path string, request map[string]string) error {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, fullURL(c.baseURL, path), bytes.NewReader(payload))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if status := res.StatusCode; status < 200 || status >= 300 {
+		return errors.Errorf("TPP did not provide an access token: (status %d) %s.", status, string(body))
+	}
+
+	response := struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		// Expires is the Unix time the access token expires at.
+		Expires int64 `json:"expires"`
+	}{}
+
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if response.AccessToken == "" || response.Expires == 0 {
+		return errors.Errorf("got an invalid access token")
+	}
+
+	c.accessToken.bearer = response.AccessToken
+	c.accessToken.setExpiration(time.Now(), time.Unix(response.Expires, 0))
+	c.refreshToken = response.RefreshToken
+
+	return nil
+}
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestVenafiTPPClient(t *testing.T) {
+	var authorizations, paths []string
+	var grants []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vedauth/authorize/oauth", "/vedauth/authorize/token":
+			request := map[string]string{}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			grants = append(grants, request)
+			// the first token is already expired, so it is refreshed
+			expires := time.Now().Add(-time.Minute)
+			token := "first"
+			if r.URL.Path == "/vedauth/authorize/token" {
+				expires = time.Now().Add(time.Hour)
+				token = "refreshed"
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  token,
+				"refresh_token": "refresh",
+				"expires":       expires.Unix(),
+			})
+		default:
+			paths = append(paths, r.URL.Path)
+			authorizations = append(authorizations, r.Header.Get("Authorization"))
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewVenafiTPPClient(&api.AgentMetadata{}, &VenafiTPPCredentials{
+		ClientID: "jetstack-secure",
+		Scope:    "certificate:discover",
+		Username: "agent",
+		Password: "secret",
+	}, server.URL, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.PostDataReadings("", "cluster", []*api.DataReading{{DataGatherer: "dummy"}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if len(grants) != 2 || grants[0]["username"] != "agent" || grants[0]["password"] != "secret" || grants[1]["refresh_token"] != "refresh" {
+		t.Errorf("unexpected token requests: %v", grants)
+	}
+	if len(authorizations) != 2 || authorizations[0] != "Bearer first" || authorizations[1] != "Bearer refreshed" {
+		t.Errorf("unexpected Authorization headers: %v", authorizations)
+	}
+	for _, path := range paths {
+		if path != DefaultVenafiTPPUploadPath+"/cluster" {
+			t.Errorf("unexpected upload path: %s", path)
+		}
+	}
+}
+
+func TestVenafiTPPClientUnauthorized(t *testing.T) {
+	var mu sync.Mutex
+	var grants, uploads int
+	revoked := map[string]bool{"first": true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/vedauth/authorize/oauth", "/vedauth/authorize/token":
+			grants++
+			token := "first"
+			if grants > 1 {
+				token = "second"
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  token,
+				"refresh_token": "refresh",
+				"expires":       time.Now().Add(time.Hour).Unix(),
+			})
+		default:
+			uploads++
+			if revoked[r.Header.Get("Authorization")[len("Bearer "):]] {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewVenafiTPPClient(&api.AgentMetadata{}, &VenafiTPPCredentials{
+		ClientID: "jetstack-secure",
+		Username: "agent",
+		Password: "secret",
+	}, server.URL, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the uploads rejected concurrently renew the token once, and are sent
+	// again with the new token
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.PostDataReadings("", "cluster", []*api.DataReading{{DataGatherer: "dummy"}}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if grants != 2 {
+		t.Errorf("expected the token to be renewed once, got %d token requests", grants)
+	}
+	if uploads != 10 {
+		t.Errorf("expected every upload to be sent twice, got %d uploads", uploads)
+	}
+
+	// an upload rejected with the new token is only sent again once
+	revoked["second"] = true
+	grants, uploads = 0, 0
+	mu.Unlock()
+	err = c.PostDataReadings("", "cluster", []*api.DataReading{{DataGatherer: "dummy"}})
+	mu.Lock()
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+	if grants != 1 || uploads != 2 {
+		t.Errorf("expected 1 token request and 2 uploads, got %d and %d", grants, uploads)
+	}
+}
+
+func TestVenafiTPPClientRenewalFailure(t *testing.T) {
+	var mu sync.Mutex
+	failGrants := false
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/vedauth/authorize/oauth", "/vedauth/authorize/token":
+			if failGrants {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  "first",
+				"refresh_token": "refresh",
+				"expires":       time.Now().Add(time.Hour).Unix(),
+			})
+		default:
+			authorizations = append(authorizations, r.Header.Get("Authorization"))
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewVenafiTPPClient(&api.AgentMetadata{}, &VenafiTPPCredentials{
+		ClientID: "jetstack-secure",
+		Username: "agent",
+		Password: "secret",
+	}, server.URL, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	post := func() error {
+		return c.PostDataReadings("", "cluster", []*api.DataReading{{DataGatherer: "dummy"}})
+	}
+	if err := post(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the token is due for renewal but TPP fails to renew it, the current
+	// token is used as it is still valid
+	mu.Lock()
+	failGrants = true
+	mu.Unlock()
+	c.mu.Lock()
+	c.accessToken.renewDate = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+	if err := post(); err != nil {
+		t.Fatalf("expected the upload to succeed with the current token, got %v", err)
+	}
+	mu.Lock()
+	if len(authorizations) != 2 || authorizations[1] != "Bearer first" {
+		t.Errorf("unexpected Authorization headers: %v", authorizations)
+	}
+	mu.Unlock()
+
+	// once the token expires, the upload fails
+	c.mu.Lock()
+	c.accessToken.expirationDate = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+	if err := post(); err == nil {
+		t.Errorf("expected an error once the token expired")
+	}
+}
+
+func TestAccessTokenRenewal(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		lifetime, renewAfter time.Duration
+	}{
+		{lifetime: time.Hour, renewAfter: time.Hour - accessTokenRenewBefore},
+		{lifetime: 30 * time.Second, renewAfter: 15 * time.Second},
+		{lifetime: -time.Minute, renewAfter: -time.Minute},
+	} {
+		token := &accessToken{bearer: "token"}
+		token.setExpiration(now, now.Add(test.lifetime))
+		if renewAfter := token.renewDate.Sub(now); renewAfter != test.renewAfter {
+			t.Errorf("a token living %s: expected renewal after %s, got %s", test.lifetime, test.renewAfter, renewAfter)
+		}
+	}
+
+	token := &accessToken{bearer: "token"}
+	token.setExpiration(time.Now(), time.Now().Add(30*time.Second))
+	if token.needsRenew() {
+		t.Errorf("expected a new token not to need renewal")
+	}
+	token.setExpiration(time.Now(), time.Now().Add(time.Second))
+	time.Sleep(600 * time.Millisecond)
+	if !token.needsRenew() {
+		t.Errorf("expected the token to be renewed before it expires")
+	}
+}
+
+func TestVenafiTPPClientAccessToken(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	c, err := NewVenafiTPPClient(&api.AgentMetadata{}, &VenafiTPPCredentials{AccessToken: "token"}, server.URL, "/custom", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.PostDataReadings("", "cluster", []*api.DataReading{{DataGatherer: "dummy"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if authorization != "Bearer token" {
+		t.Errorf("unexpected Authorization header: %s", authorization)
+	}
+
+	if _, err := NewVenafiTPPClient(&api.AgentMetadata{}, &VenafiTPPCredentials{Username: "agent"}, server.URL, "", ""); err == nil {
+		t.Errorf("expected an error for incomplete credentials")
+	}
+}
+
+func TestValidateVenafiTPPConfig(t *testing.T) {
+	for _, c := range []VenafiTPPConfig{
+		{URL: "https://tpp.example.com", ClientID: "jetstack-secure", Username: "agent", PasswordFile: "password"},
+		{URL: "https://tpp.example.com", AccessTokenFile: "token"},
+	} {
+		if err := c.Validate(); err != nil {
+			t.Errorf("unexpected error for %+v: %v", c, err)
+		}
+	}
+
+	for _, c := range []VenafiTPPConfig{
+		{},
+		{URL: "https://tpp.example.com"},
+		{URL: "https://tpp.example.com", Username: "agent", PasswordFile: "password"},
+		{URL: "https://tpp.example.com", ClientID: "jetstack-secure", Username: "agent", PasswordFile: "password", AccessTokenFile: "token"},
+		{URL: "tpp", AccessTokenFile: "token"},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Compression is the content encoding of the uploads to the backend.
+type Compression string
+
+const (
+	// CompressionGzip compresses the uploads with gzip, it is the default.
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses the uploads with zstd, which is faster and
+	// smaller than gzip but not supported by every backend.
+	CompressionZstd Compression = "zstd"
+	// CompressionNone disables the compression of the uploads.
+	CompressionNone Compression = "none"
+)
+
+// ParseCompression parses the name of a compression, an empty name is the
+// default compression.
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(s); c {
+	case "":
+		return CompressionGzip, nil
+	case CompressionGzip, CompressionZstd, CompressionNone:
+		return c, nil
+	}
+	return "", fmt.Errorf("compression %q is not supported, use %s, %s or %s", s, CompressionGzip, CompressionZstd, CompressionNone)
+}
+
+// contentEncoding is the Content-Encoding header of the compression, empty
+// for uncompressed uploads.
+func (c Compression) contentEncoding() string {
+	if c == CompressionNone {
+		return ""
+	}
+	return string(c)
+}
+
+// compress returns the data compressed.
+func (c Compression) compress(data []byte) ([]byte, error) {
+	switch c {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll(data, nil), nil
+	}
+	return data, nil
+}
+
+// CompressionClient is implemented by the clients able to compress their
+// uploads.
+type CompressionClient interface {
+	Client
+	SetCompression(compression Compression)
+}
+
+// compressor compresses the bodies of the uploads. The compression is
+// negotiated with the backend: a backend rejecting the encoding with a 415
+// Unsupported Media Type response is retried with an encoding it lists in its
+// Accept-Encoding header, or uncompressed, and that encoding is used for the
+// following uploads. A nil compressor does not compress.
+type compressor struct {
+	mu          sync.Mutex
+	compression Compression
+}
+
+func newCompressor(compression Compression) *compressor {
+	return &compressor{compression: compression}
+}
+
+// set changes the compression of the following uploads.
+func (c *compressor) set(compression Compression) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compression = compression
+}
+
+func (c *compressor) current() Compression {
+	if c == nil {
+		return CompressionNone
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.compression
+}
+
+// fallback switches to an encoding accepted by the backend after it rejected
+// the current one.
+func (c *compressor) fallback(rejected Compression, acceptEncoding string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.compression != rejected {
+		// another upload already switched
+		return
+	}
+
+	next := CompressionNone
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		encoding = strings.TrimSpace(strings.SplitN(encoding, ";", 2)[0])
+		if candidate := Compression(encoding); candidate != rejected && (candidate == CompressionGzip || candidate == CompressionZstd) {
+			next = candidate
+			break
+		}
+	}
+	logs.Log.Warnf("The backend does not accept %s compressed uploads, using %s compression", rejected, next)
+	c.compression = next
+}
+
+// do sends the body with send, compressed with the negotiated compression.
+// send must set the Content-Encoding header to the encoding it is given when
+// it is not empty.
+func (c *compressor) do(ctx context.Context, body io.Reader, send func(body io.Reader, contentEncoding string) (*http.Response, error)) (*http.Response, error) {
+	if c.current() == CompressionNone {
+		return send(body, "")
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		compression := c.current()
+		compressed, err := c.trace(ctx, compression, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress upload: %v", err)
+		}
+
+		res, err := send(bytes.NewReader(compressed), compression.contentEncoding())
+		if err != nil || res.StatusCode != http.StatusUnsupportedMediaType || compression == CompressionNone {
+			return res, err
+		}
+		if res.Header.Get("Accept-Post") != "" {
+			// the backend rejects the format of the upload, not its encoding
+			return res, err
+		}
+		res.Body.Close()
+		c.fallback(compression, res.Header.Get("Accept-Encoding"))
+	}
+}
+
+// trace compresses the data as part of a span.
+func (c *compressor) trace(ctx context.Context, compression Compression, data []byte) ([]byte, error) {
+	if compression == CompressionNone {
+		return data, nil
+	}
+	_, span := tracing.Start(ctx, "compress")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("encoding", string(compression)),
+		attribute.Int("size_bytes", len(data)),
+	)
+
+	compressed, err := compression.compress(data)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int("compressed_bytes", len(compressed)))
+	return compressed, err
+}
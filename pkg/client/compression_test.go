@@ -0,0 +1,114 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompress returns the body of a request, decompressed according to its
+// Content-Encoding.
+func decompress(t *testing.T, r *http.Request) string {
+	var reader io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		reader = gz
+	case "zstd":
+		decoder, err := zstd.NewReader(r.Body)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer decoder.Close()
+		reader = decoder
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(data)
+}
+
+func TestCompressorNegotiation(t *testing.T) {
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.Header.Get("Content-Encoding")
+		encodings = append(encodings, encoding)
+		if encoding == "zstd" {
+			w.Header().Set("Accept-Encoding", "br, gzip")
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		if body := decompress(t, r); body != `{"data_readings":[]}` {
+			t.Errorf("unexpected body: %s", body)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewUnauthenticatedClient(nil, server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.SetCompression(CompressionZstd)
+
+	for i := 0; i < 2; i++ {
+		res, err := c.post(context.Background(), "/upload", contentTypeHeader(FormatJSON), strings.NewReader(`{"data_readings":[]}`))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", res.StatusCode)
+		}
+	}
+
+	expected := []string{"zstd", "gzip", "gzip"}
+	if strings.Join(encodings, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected encodings: got=%v want=%v", encodings, expected)
+	}
+}
+
+func TestCompressorFallsBackToUncompressed(t *testing.T) {
+	compressor := newCompressor(CompressionGzip)
+	var bodies []string
+	send := func(body io.Reader, contentEncoding string) (*http.Response, error) {
+		data, _ := ioutil.ReadAll(body)
+		bodies = append(bodies, string(data))
+		status := http.StatusOK
+		if contentEncoding != "" {
+			status = http.StatusUnsupportedMediaType
+		}
+		return &http.Response{StatusCode: status, Header: http.Header{}, Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+	}
+
+	res, err := compressor.do(context.Background(), strings.NewReader("readings"), send)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected result: %v %v", res, err)
+	}
+	if len(bodies) != 2 || bodies[1] != "readings" {
+		t.Errorf("expected an uncompressed retry, got %q", bodies)
+	}
+	if c := compressor.current(); c != CompressionNone {
+		t.Errorf("expected the compression to be disabled, got %s", c)
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	if c, err := ParseCompression(""); err != nil || c != CompressionGzip {
+		t.Errorf("expected gzip by default, got %q %v", c, err)
+	}
+	if _, err := ParseCompression("brotli"); err == nil {
+		t.Errorf("expected error for unsupported compression")
+	}
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// maxDirectivesSize bounds the body read from the response to an upload.
+const maxDirectivesSize = 1 << 20
+
+// DirectivesClient is implemented by the clients keeping the directives the
+// backend sends in response to the uploads of readings.
+type DirectivesClient interface {
+	Client
+	// Directives returns the directives received since the previous call,
+	// or nil if there were none.
+	Directives() *api.Directives
+}
+
+// ReceiptClient is implemented by the clients keeping the receipt the backend
+// sends in response to the uploads of readings.
+type ReceiptClient interface {
+	Client
+	// Receipt returns the receipt ID of the last successful upload, or an
+	// empty string if the backend did not send one.
+	Receipt() string
+}
+
+// directivesReceiver accumulates the directives of the responses to the
+// uploads until the agent takes them, as a cycle can upload several times.
+// It also keeps the receipt of the last upload.
+type directivesReceiver struct {
+	mu      sync.Mutex
+	pending *api.Directives
+	receipt string
+}
+
+// receive reads the directives and the receipt in the body of a successful
+// upload. A body that is empty or not a DataReadingsResponse is ignored, as
+// older backends do not send directives.
+func (r *directivesReceiver) receive(res *http.Response) {
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxDirectivesSize))
+	var response api.DataReadingsResponse
+	if err == nil && len(body) > 0 {
+		_ = json.Unmarshal(body, &response)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.receipt = response.ReceiptID
+	if response.Directives == nil {
+		return
+	}
+	if r.pending == nil {
+		r.pending = &api.Directives{}
+	}
+	r.pending.Merge(response.Directives)
+}
+
+// take returns the pending directives and clears them.
+func (r *directivesReceiver) take() *api.Directives {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.pending
+	r.pending = nil
+	return d
+}
+
+// lastReceipt returns the receipt of the last upload.
+func (r *directivesReceiver) lastReceipt() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.receipt
+}
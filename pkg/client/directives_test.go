@@ -0,0 +1,83 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestDirectives(t *testing.T) {
+	responses := []string{
+		"",
+		"OK",
+		`{"directives":{"next_period":"5m","data_gatherers":["k8s/pods"]}}`,
+		`{"directives":{"next_period":"1m","resources":[{"group":"apps","version":"v1","resource":"deployments"}]}}`,
+	}
+	i := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, responses[i])
+		i++
+	}))
+	defer server.Close()
+
+	c, err := NewUnauthenticatedClient(&api.AgentMetadata{}, server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	post := func() {
+		if err := c.PostDataReadings("org", "cluster", []*api.DataReading{{DataGatherer: "dummy"}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// responses without directives are ignored
+	post()
+	post()
+	if d := c.Directives(); d != nil {
+		t.Errorf("expected no directives, got %+v", d)
+	}
+
+	// the directives of a cycle are merged
+	post()
+	post()
+	d := c.Directives()
+	if d == nil || d.NextPeriod != "1m" || len(d.DataGatherers) != 1 || len(d.Resources) != 1 || d.Resources[0].Resource != "deployments" {
+		t.Fatalf("unexpected directives: %+v", d)
+	}
+	if d := c.Directives(); d != nil {
+		t.Errorf("expected the directives to be taken, got %+v", d)
+	}
+}
+
+func TestReceipt(t *testing.T) {
+	responses := []string{
+		`{"receipt_id":"7f3c"}`,
+		`{"directives":{"next_period":"5m"},"receipt_id":"8a1d"}`,
+		"",
+	}
+	i := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, responses[i])
+		i++
+	}))
+	defer server.Close()
+
+	c, err := NewUnauthenticatedClient(&api.AgentMetadata{}, server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{"7f3c", "8a1d", ""} {
+		if err := c.PostDataReadings("org", "cluster", []*api.DataReading{{DataGatherer: "dummy"}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if receipt := c.Receipt(); receipt != expected {
+			t.Errorf("expected receipt %q, got %q", expected, receipt)
+		}
+	}
+	if d := c.Directives(); d == nil || d.NextPeriod != "5m" {
+		t.Errorf("unexpected directives: %+v", d)
+	}
+}
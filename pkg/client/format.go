@@ -0,0 +1,159 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/logs"
+)
+
+// Format is the encoding of the readings uploaded to the backend.
+type Format string
+
+const (
+	// FormatJSON encodes the readings as JSON, it is the default.
+	FormatJSON Format = "json"
+	// FormatCBOR encodes the readings as CBOR, with the same structure as
+	// the JSON. It is more compact and faster to encode.
+	FormatCBOR Format = "cbor"
+)
+
+// ParseFormat parses the name of a format, an empty name is the default
+// format.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "":
+		return FormatJSON, nil
+	case FormatJSON, FormatCBOR:
+		return f, nil
+	}
+	return "", fmt.Errorf("format %q is not supported, use %s or %s", s, FormatJSON, FormatCBOR)
+}
+
+// contentType is the Content-Type header of the uploads in the format.
+func (f Format) contentType() string {
+	if f == FormatCBOR {
+		return "application/cbor"
+	}
+	return "application/json"
+}
+
+// contentTypeHeader returns the headers of an upload in the format.
+func contentTypeHeader(f Format) http.Header {
+	return http.Header{"Content-Type": {f.contentType()}}
+}
+
+// marshal encodes the payload in the format.
+func (f Format) marshal(payload api.DataReadingsPost) ([]byte, error) {
+	if f == FormatCBOR {
+		return marshalCBOR(payload)
+	}
+	return json.Marshal(payload)
+}
+
+// FormatClient is implemented by the clients able to upload the readings in
+// another format than JSON.
+type FormatClient interface {
+	Client
+	SetFormat(format Format)
+}
+
+// formatNegotiator encodes the uploads of readings. The format is negotiated
+// with the backend: an upload rejected with a 415 Unsupported Media Type
+// response is retried as JSON, which is used for the following uploads. The
+// backend tells the format apart from the compression being rejected with an
+// Accept-Post header. A nil formatNegotiator uploads JSON.
+//
+// The encoded payloads are signed by the signer, if one is set.
+type formatNegotiator struct {
+	mu     sync.Mutex
+	format Format
+	signer Signer
+}
+
+func newFormatNegotiator(format Format) *formatNegotiator {
+	return &formatNegotiator{format: format}
+}
+
+// set changes the format of the following uploads.
+func (n *formatNegotiator) set(format Format) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.format = format
+}
+
+func (n *formatNegotiator) current() Format {
+	if n == nil {
+		return FormatJSON
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.format
+}
+
+// setSigner sets the signer of the following uploads.
+func (n *formatNegotiator) setSigner(signer Signer) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.signer = signer
+}
+
+func (n *formatNegotiator) currentSigner() Signer {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.signer
+}
+
+// fallback switches to JSON after the backend rejected the current format.
+func (n *formatNegotiator) fallback(rejected Format) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.format != rejected {
+		// another upload already switched
+		return
+	}
+	logs.Log.Warnf("The backend does not accept %s uploads, using %s", rejected, FormatJSON)
+	n.format = FormatJSON
+}
+
+// upload encodes the payload in the negotiated format and sends it with send,
+// with the headers of the upload. The body the backend answered is recorded
+// in the payload recorder of the context.
+func (n *formatNegotiator) upload(ctx context.Context, payload api.DataReadingsPost, send func(header http.Header, body io.Reader) (*http.Response, error)) (*http.Response, error) {
+	for {
+		format := n.current()
+		data, err := marshalPayload(ctx, format, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		header := contentTypeHeader(format)
+		if signer := n.currentSigner(); signer != nil {
+			attestation, err := attest(ctx, signer, format, payload.AgentMetadata, data)
+			if err != nil {
+				return nil, err
+			}
+			header.Set(attestationHeader, attestation)
+		}
+
+		res, err := send(header, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusUnsupportedMediaType || format == FormatJSON {
+			RecordPayload(ctx, data)
+			return res, nil
+		}
+		res.Body.Close()
+		n.fallback(format)
+	}
+}
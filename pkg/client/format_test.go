@@ -0,0 +1,56 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestFormatNegotiation(t *testing.T) {
+	var contentTypes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		if r.Header.Get("Content-Type") == "application/cbor" {
+			w.Header().Set("Accept-Post", "application/json")
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		if body := decompress(t, r); !strings.Contains(body, `"data-gatherer":"dummy"`) {
+			t.Errorf("unexpected body: %s", body)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewAPITokenClient(&api.AgentMetadata{}, "token", server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.SetFormat(FormatCBOR)
+
+	for i := 0; i < 2; i++ {
+		err := c.PostDataReadings("org", "cluster", []*api.DataReading{{DataGatherer: "dummy"}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := []string{"application/cbor", "application/json", "application/json"}
+	if strings.Join(contentTypes, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected content types: got=%v want=%v", contentTypes, expected)
+	}
+	if c.compressor.current() != CompressionGzip {
+		t.Errorf("expected the compression to be kept, got %s", c.compressor.current())
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat(""); err != nil || f != FormatJSON {
+		t.Errorf("expected json by default, got %q %v", f, err)
+	}
+	if _, err := ParseFormat("protobuf"); err == nil {
+		t.Errorf("expected error for unsupported format")
+	}
+}
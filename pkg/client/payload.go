@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"sync"
+	"time"
+)
+
+// PayloadRecorder records the bodies of the uploads of readings as they were
+// sent, before compression, so that what different destinations received can
+// be compared. The uploads made with a context carrying the recorder, see
+// WithPayloadRecorder, are recorded.
+type PayloadRecorder struct {
+	mu      sync.Mutex
+	sum     hash.Hash
+	uploads int
+}
+
+// NewPayloadRecorder returns a recorder with no uploads recorded.
+func NewPayloadRecorder() *PayloadRecorder {
+	return &PayloadRecorder{sum: sha256.New()}
+}
+
+// Record adds the body of an upload to the recorder.
+func (r *PayloadRecorder) Record(body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sum.Write(body)
+	r.uploads++
+}
+
+// Sum returns the hex encoded sha256 sum of the bodies recorded, in the order
+// they were sent, or an empty string if none were. The sum of a single upload
+// is the sum of its body.
+func (r *PayloadRecorder) Sum() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.uploads == 0 {
+		return ""
+	}
+	return hex.EncodeToString(r.sum.Sum(nil))
+}
+
+type payloadRecorderKey struct{}
+
+// WithPayloadRecorder returns a context recording the bodies of the uploads
+// made with it in the recorder.
+func WithPayloadRecorder(ctx context.Context, r *PayloadRecorder) context.Context {
+	return context.WithValue(ctx, payloadRecorderKey{}, r)
+}
+
+// RecordPayload records the body of an upload in the recorder of the context,
+// if it has one.
+func RecordPayload(ctx context.Context, body []byte) {
+	if r, ok := ctx.Value(payloadRecorderKey{}).(*PayloadRecorder); ok {
+		r.Record(body)
+	}
+}
+
+type dataGatherTimeKey struct{}
+
+// WithDataGatherTime returns a context setting the DataGatherTime of the
+// uploads made with it, so that the uploads of the same readings to several
+// destinations are identical. The uploads default to the time they are made.
+func WithDataGatherTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, dataGatherTimeKey{}, t)
+}
+
+// dataGatherTime returns the DataGatherTime of an upload made with the
+// context.
+func dataGatherTime(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(dataGatherTimeKey{}).(time.Time); ok {
+		return t.UTC()
+	}
+	return time.Now().UTC()
+}
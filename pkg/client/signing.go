@@ -0,0 +1,334 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/tracing"
+	"github.com/jetstack/preflight/pkg/transport"
+)
+
+// attestationHeader is the header of the api.Attestation of a signed upload.
+const attestationHeader = "X-Preflight-Attestation"
+
+// DefaultFulcioURL is the URL of the public Sigstore certificate authority.
+const DefaultFulcioURL = "https://fulcio.sigstore.dev"
+
+// certificateRenewBefore is how long before its expiry a keyless certificate
+// is replaced, so that it is valid when the backend receives the upload.
+const certificateRenewBefore = time.Minute
+
+// SigningClient is implemented by the clients able to sign the readings they
+// upload.
+type SigningClient interface {
+	Client
+	// SetSigner sets the signer of the attestations of the uploads.
+	SetSigner(signer Signer)
+}
+
+// Signer signs the statements of the attestations of the uploads.
+type Signer interface {
+	// Sign returns the attestation of the statement, with its signature and
+	// the key it was signed with.
+	Sign(ctx context.Context, statement []byte) (*api.Attestation, error)
+}
+
+// attest returns the base64 encoded attestation of the encoded payload.
+func attest(ctx context.Context, signer Signer, format Format, agentMetadata *api.AgentMetadata, data []byte) (string, error) {
+	ctx, span := tracing.Start(ctx, "sign")
+	defer span.End()
+
+	digest := sha256.Sum256(data)
+	statement := api.AttestationStatement{
+		PayloadSHA256: hex.EncodeToString(digest[:]),
+		ContentType:   format.contentType(),
+		SignedAt:      time.Now().UTC(),
+	}
+	if agentMetadata != nil {
+		statement.AgentVersion = agentMetadata.Version
+		statement.ClusterID = agentMetadata.ClusterID
+	}
+	encoded, err := json.Marshal(statement)
+	if err != nil {
+		return "", err
+	}
+
+	attestation, err := signer.Sign(ctx, encoded)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return "", fmt.Errorf("failed to sign upload: %v", err)
+	}
+	envelope, err := json.Marshal(attestation)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+// signWith signs the data with the key: Ed25519 keys sign the data itself,
+// the others its SHA-256 digest.
+func signWith(key crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// keyID returns the hex encoded SHA-256 digest of the PKIX encoded public key.
+func keyID(key crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(der)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// KeySigner signs with a configured private key.
+type KeySigner struct {
+	key   crypto.Signer
+	keyID string
+}
+
+// NewKeySigner returns a KeySigner signing with the PEM encoded ECDSA, RSA or
+// Ed25519 private key.
+func NewKeySigner(keyPEM []byte) (*KeySigner, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded private key found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the private key: %v", err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("the private key of type %T cannot sign", parsed)
+	}
+
+	id, err := keyID(key)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySigner{key: key, keyID: id}, nil
+}
+
+// Sign signs the statement with the key.
+func (s *KeySigner) Sign(ctx context.Context, statement []byte) (*api.Attestation, error) {
+	signature, err := signWith(s.key, statement)
+	if err != nil {
+		return nil, err
+	}
+	return &api.Attestation{
+		Statement: statement,
+		Signature: signature,
+		KeyID:     s.keyID,
+	}, nil
+}
+
+// KeylessSigner signs with ephemeral keys certified by a Fulcio certificate
+// authority for the identity of an OIDC token, e.g. the ServiceAccount token
+// of the agent, as cosign does. The backend verifies the identity of the
+// certificate instead of trusting a key.
+type KeylessSigner struct {
+	fulcioURL         string
+	identityTokenFile string
+	client            *http.Client
+
+	mu           sync.Mutex
+	key          *ecdsa.PrivateKey
+	keyID        string
+	certificates string
+	notAfter     time.Time
+}
+
+// NewKeylessSigner returns a KeylessSigner requesting certificates from the
+// Fulcio instance for the OIDC token of the file. The file is read for every
+// certificate, as projected tokens are rotated.
+func NewKeylessSigner(fulcioURL, identityTokenFile string) *KeylessSigner {
+	if fulcioURL == "" {
+		fulcioURL = DefaultFulcioURL
+	}
+	return &KeylessSigner{
+		fulcioURL:         fulcioURL,
+		identityTokenFile: identityTokenFile,
+		client:            transport.Client(time.Minute),
+	}
+}
+
+// Sign signs the statement with the current ephemeral key, replacing it and
+// its certificate before the certificate expires.
+func (s *KeylessSigner) Sign(ctx context.Context, statement []byte) (*api.Attestation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key == nil || time.Now().Add(certificateRenewBefore).After(s.notAfter) {
+		if err := s.renewCertificate(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	signature, err := signWith(s.key, statement)
+	if err != nil {
+		return nil, err
+	}
+	return &api.Attestation{
+		Statement:    statement,
+		Signature:    signature,
+		KeyID:        s.keyID,
+		Certificates: s.certificates,
+	}, nil
+}
+
+// renewCertificate generates a new key, and requests its certificate from
+// Fulcio with the v2 API.
+func (s *KeylessSigner) renewCertificate(ctx context.Context) error {
+	token, err := ioutil.ReadFile(s.identityTokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read the identity token: %v", err)
+	}
+	identityToken := strings.TrimSpace(string(token))
+	subject, err := tokenSubject(identityToken)
+	if err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return err
+	}
+	// the proof of possession of the key is the signature of the subject of
+	// the token
+	proof, err := signWith(key, []byte(subject))
+	if err != nil {
+		return err
+	}
+
+	request := map[string]interface{}{
+		"credentials": map[string]string{"oidcIdentityToken": identityToken},
+		"publicKeyRequest": map[string]interface{}{
+			"publicKey": map[string]string{
+				"algorithm": "ECDSA",
+				"content":   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+			},
+			"proofOfPossession": base64.StdEncoding.EncodeToString(proof),
+		},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullURL(s.fulcioURL, "/api/v2/signingCert"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to request a signing certificate: %v", err)
+	}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if status := res.StatusCode; status < 200 || status >= 300 {
+		return fmt.Errorf("fulcio did not issue a signing certificate: (status %d) %s", status, string(resBody))
+	}
+
+	type chain struct {
+		Chain struct {
+			Certificates []string `json:"certificates"`
+		} `json:"chain"`
+	}
+	response := struct {
+		Embedded *chain `json:"signedCertificateEmbeddedSct"`
+		Detached *chain `json:"signedCertificateDetachedSct"`
+	}{}
+	if err := json.Unmarshal(resBody, &response); err != nil {
+		return fmt.Errorf("failed to parse the signing certificate response: %v", err)
+	}
+	var certificates []string
+	if response.Embedded != nil {
+		certificates = response.Embedded.Chain.Certificates
+	} else if response.Detached != nil {
+		certificates = response.Detached.Chain.Certificates
+	}
+	if len(certificates) == 0 {
+		return fmt.Errorf("fulcio did not return a certificate chain")
+	}
+
+	block, _ := pem.Decode([]byte(certificates[0]))
+	if block == nil {
+		return fmt.Errorf("fulcio returned an invalid certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("fulcio returned an invalid certificate: %v", err)
+	}
+
+	id, err := keyID(key)
+	if err != nil {
+		return err
+	}
+	s.key, s.keyID, s.notAfter = key, id, cert.NotAfter
+	s.certificates = strings.Join(certificates, "")
+	return nil
+}
+
+// tokenSubject returns the subject of the JWT, without verifying it as
+// Fulcio does.
+func tokenSubject(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("the identity token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", fmt.Errorf("the identity token is not a JWT: %v", err)
+	}
+	claims := struct {
+		Subject string `json:"sub"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("the identity token is not a JWT: %v", err)
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("the identity token has no subject")
+	}
+	return claims.Subject, nil
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// receiveAttestation returns a server recording the attestations of the
+// uploads, once they are verified against their bodies.
+func receiveAttestation(t *testing.T, verify func(attestation *api.Attestation) bool) (*httptest.Server, *[]api.AttestationStatement) {
+	var statements []api.AttestationStatement
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		envelope, err := base64.StdEncoding.DecodeString(r.Header.Get(attestationHeader))
+		if err != nil {
+			t.Errorf("invalid attestation header: %v", err)
+			return
+		}
+		var attestation api.Attestation
+		if err := json.Unmarshal(envelope, &attestation); err != nil {
+			t.Errorf("invalid attestation: %v", err)
+			return
+		}
+		if !verify(&attestation) {
+			t.Errorf("invalid signature")
+			return
+		}
+		var statement api.AttestationStatement
+		if err := json.Unmarshal(attestation.Statement, &statement); err != nil {
+			t.Errorf("invalid statement: %v", err)
+			return
+		}
+		digest := sha256.Sum256(body)
+		if statement.PayloadSHA256 != hex.EncodeToString(digest[:]) {
+			t.Errorf("the statement does not match the body")
+		}
+		statements = append(statements, statement)
+	}))
+	return server, &statements
+}
+
+func TestKeySigner(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signer, err := NewKeySigner(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server, statements := receiveAttestation(t, func(attestation *api.Attestation) bool {
+		return ed25519.Verify(publicKey, attestation.Statement, attestation.Signature)
+	})
+	defer server.Close()
+
+	c, err := NewUnauthenticatedClient(&api.AgentMetadata{Version: "v1.0.0", ClusterID: "cluster"}, server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.SetSigner(signer)
+	if err := c.PostDataReadings("org", "cluster", []*api.DataReading{{DataGatherer: "dummy"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(*statements) != 1 || (*statements)[0].AgentVersion != "v1.0.0" || (*statements)[0].ClusterID != "cluster" {
+		t.Errorf("unexpected statements: %+v", *statements)
+	}
+
+	if _, err := NewKeySigner([]byte("not a key")); err == nil {
+		t.Errorf("expected an error for an invalid key")
+	}
+}
+
+func TestKeylessSigner(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	issued := 0
+	fulcio := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/signingCert" {
+			http.NotFound(w, r)
+			return
+		}
+		request := struct {
+			PublicKeyRequest struct {
+				PublicKey struct {
+					Content string `json:"content"`
+				} `json:"publicKey"`
+			} `json:"publicKeyRequest"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		block, _ := pem.Decode([]byte(request.PublicKeyRequest.PublicKey.Content))
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "agent"},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(10 * time.Minute),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, publicKey, caKey)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		issued++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"signedCertificateEmbeddedSct": map[string]interface{}{
+				"chain": map[string]interface{}{
+					"certificates": []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))},
+				},
+			},
+		})
+	}))
+	defer fulcio.Close()
+
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"system:serviceaccount:jetstack-secure:agent"}`))
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("e30."+claims+".signature"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	signer := NewKeylessSigner(fulcio.URL, tokenFile)
+	for i := 0; i < 2; i++ {
+		attestation, err := signer.Sign(context.Background(), []byte("statement"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		block, _ := pem.Decode([]byte(attestation.Certificates))
+		if block == nil {
+			t.Fatalf("no certificate in the attestation")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		digest := sha256.Sum256([]byte("statement"))
+		if !ecdsa.VerifyASN1(cert.PublicKey.(*ecdsa.PublicKey), digest[:], attestation.Signature) {
+			t.Errorf("the signature does not match the certificate")
+		}
+	}
+	// the certificate is reused until it expires
+	if issued != 1 {
+		t.Errorf("expected 1 certificate, got %d", issued)
+	}
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/transport"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	// tokenExchangeGrantType is the grant type of the OAuth 2.0 token
+	// exchange, RFC 8693.
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// jwtTokenType is the type of the ServiceAccount tokens traded in a
+	// token exchange.
+	jwtTokenType = "urn:ietf:params:oauth:token-type:jwt"
+	// accessTokenType is the type of the token requested in a token
+	// exchange.
+	accessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+
+	// defaultExchangedTokenLifetime is how long an exchanged token is used
+	// when the authorization server does not say when it expires.
+	defaultExchangedTokenLifetime = 5 * time.Minute
+)
+
+// NewClientCredentialsTokenSource returns a token source getting tokens from
+// the authorization server with the OAuth2 client credentials flow. The
+// audience is sent as a parameter of the token request if not empty.
+func NewClientCredentialsTokenSource(tokenURL, clientID, clientSecret, audience string, scopes []string) oauth2.TokenSource {
+	config := &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     tokenURL,
+		Scopes:       scopes,
+	}
+	if audience != "" {
+		config.EndpointParams = url.Values{"audience": {audience}}
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, transport.Client(time.Minute))
+	return config.TokenSource(ctx)
+}
+
+// TokenExchangeSource is a token source trading a token read from a file,
+// usually a projected ServiceAccount token, for a token of the authorization
+// server with the OAuth 2.0 token exchange, RFC 8693.
+type TokenExchangeSource struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string
+	// SubjectTokenFile is the path of the token traded. It is read on every
+	// exchange, as the kubelet rotates projected tokens.
+	SubjectTokenFile string
+	// Audience and Scopes are the audience and the scopes of the token
+	// requested. They are optional.
+	Audience string
+	Scopes   []string
+	// ClientID and ClientSecret authenticate the agent to the authorization
+	// server, if it requires it.
+	ClientID     string
+	ClientSecret string
+
+	client *http.Client
+}
+
+// NewTokenExchangeSource returns a TokenExchangeSource trading the token of
+// the file at the token endpoint.
+func NewTokenExchangeSource(tokenURL, subjectTokenFile, audience string, scopes []string) *TokenExchangeSource {
+	return &TokenExchangeSource{
+		TokenURL:         tokenURL,
+		SubjectTokenFile: subjectTokenFile,
+		Audience:         audience,
+		Scopes:           scopes,
+		client:           transport.Client(time.Minute),
+	}
+}
+
+// Token exchanges the subject token for a new token.
+func (s *TokenExchangeSource) Token() (*oauth2.Token, error) {
+	subjectToken, err := ioutil.ReadFile(s.SubjectTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the subject token: %v", err)
+	}
+
+	payload := url.Values{}
+	payload.Set("grant_type", tokenExchangeGrantType)
+	payload.Set("subject_token", strings.TrimSpace(string(subjectToken)))
+	payload.Set("subject_token_type", jwtTokenType)
+	payload.Set("requested_token_type", accessTokenType)
+	if s.Audience != "" {
+		payload.Set("audience", s.Audience)
+	}
+	if len(s.Scopes) > 0 {
+		payload.Set("scope", strings.Join(s.Scopes, " "))
+	}
+	req, err := http.NewRequest(http.MethodPost, s.TokenURL, strings.NewReader(payload.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if s.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(s.ClientID), url.QueryEscape(s.ClientSecret))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if status := res.StatusCode; status < 200 || status >= 300 {
+		return nil, fmt.Errorf("auth server did not exchange the token: (status %d) %s", status, string(body))
+	}
+
+	response := struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse the token exchange response: %v", err)
+	}
+	if response.AccessToken == "" {
+		return nil, fmt.Errorf("auth server did not return an access token")
+	}
+
+	lifetime := defaultExchangedTokenLifetime
+	if response.ExpiresIn > 0 {
+		lifetime = time.Duration(response.ExpiresIn) * time.Second
+	}
+	return &oauth2.Token{
+		AccessToken: response.AccessToken,
+		TokenType:   response.TokenType,
+		Expiry:      time.Now().Add(lifetime),
+	}, nil
+}
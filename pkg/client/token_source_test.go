@@ -0,0 +1,85 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestTokenExchange(t *testing.T) {
+	exchanges := 0
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := r.PostForm.Get("grant_type"); got != tokenExchangeGrantType {
+			t.Errorf("unexpected grant_type: %s", got)
+		}
+		if got := r.PostForm.Get("subject_token"); got != "service-account-token" {
+			t.Errorf("unexpected subject_token: %s", got)
+		}
+		if got := r.PostForm.Get("audience"); got != "https://platform.jetstack.io" {
+			t.Errorf("unexpected audience: %s", got)
+		}
+		exchanges++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"platform-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer authServer.Close()
+
+	var authorizations []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+	}))
+	defer backend.Close()
+
+	subjectTokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(subjectTokenFile, []byte("service-account-token\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tokens := NewTokenExchangeSource(authServer.URL, subjectTokenFile, "https://platform.jetstack.io", nil)
+	c, err := NewTokenClient(&api.AgentMetadata{}, tokens, backend.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.PostDataReadings("org", "cluster", []*api.DataReading{{DataGatherer: "dummy"}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the token is reused until it expires
+	if exchanges != 1 {
+		t.Errorf("expected 1 token exchange, got %d", exchanges)
+	}
+	for _, authorization := range authorizations {
+		if authorization != "Bearer platform-token" {
+			t.Errorf("unexpected Authorization header: %s", authorization)
+		}
+	}
+}
+
+func TestTokenExchangeError(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+	}))
+	defer authServer.Close()
+
+	subjectTokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(subjectTokenFile, []byte("service-account-token"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := NewTokenExchangeSource(authServer.URL, subjectTokenFile, "", nil).Token(); err == nil {
+		t.Errorf("expected an error")
+	}
+	if _, err := NewTokenExchangeSource(authServer.URL, filepath.Join(t.TempDir(), "missing"), "", nil).Token(); err == nil {
+		t.Errorf("expected an error for a missing subject token")
+	}
+}
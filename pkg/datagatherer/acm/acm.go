@@ -0,0 +1,228 @@
+// Package acm provides a datagatherer for AWS Certificate Manager and AWS
+// Certificate Manager Private Certificate Authority.
+package acm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/aws/aws-sdk-go/service/acmpca"
+	"github.com/aws/aws-sdk-go/service/acmpca/acmpcaiface"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/eks"
+)
+
+// Config is the configuration for an ACM DataGatherer.
+type Config struct {
+	// AWSConfig are the credentials to access the ACM APIs, as for EKS.
+	eks.AWSConfig `yaml:",inline"`
+}
+
+// validate validates the configuration.
+func (c *Config) validate() error {
+	return c.AWSConfig.Validate()
+}
+
+// NewDataGatherer creates a new ACM DataGatherer. It performs a config validation.
+func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	sess, err := c.AWSConfig.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGatherer{
+		ctx:    ctx,
+		acm:    acm.New(sess),
+		acmpca: acmpca.New(sess),
+	}, nil
+}
+
+// DataGatherer is a data-gatherer for ACM and ACM-PCA.
+type DataGatherer struct {
+	ctx    context.Context
+	acm    acmiface.ACMAPI
+	acmpca acmpcaiface.ACMPCAAPI
+}
+
+// Info contains the data retrieved from ACM and ACM-PCA.
+type Info struct {
+	// Certificates are the certificates managed by ACM.
+	Certificates []*Certificate `json:"certificates"`
+	// CertificateAuthorities are the private CAs of ACM-PCA.
+	CertificateAuthorities []*CertificateAuthority `json:"certificateAuthorities"`
+}
+
+// Certificate summarizes a certificate managed by ACM.
+type Certificate struct {
+	ARN                     string     `json:"arn"`
+	DomainName              string     `json:"domainName"`
+	SubjectAlternativeNames []string   `json:"subjectAlternativeNames,omitempty"`
+	Type                    string     `json:"type"`
+	Status                  string     `json:"status"`
+	Issuer                  string     `json:"issuer,omitempty"`
+	KeyAlgorithm            string     `json:"keyAlgorithm,omitempty"`
+	NotBefore               *time.Time `json:"notBefore,omitempty"`
+	NotAfter                *time.Time `json:"notAfter,omitempty"`
+	// InUseBy are the ARNs of the resources using the certificate, e.g. the
+	// load balancers of the cluster.
+	InUseBy            []string `json:"inUseBy"`
+	RenewalEligibility string   `json:"renewalEligibility,omitempty"`
+	RenewalStatus      string   `json:"renewalStatus,omitempty"`
+	// CertificateAuthorityARN is the ARN of the private CA which issued the
+	// certificate, if any.
+	CertificateAuthorityARN string `json:"certificateAuthorityArn,omitempty"`
+}
+
+// CertificateAuthority summarizes a private CA of ACM-PCA.
+type CertificateAuthority struct {
+	ARN          string     `json:"arn"`
+	Type         string     `json:"type"`
+	Status       string     `json:"status"`
+	CommonName   string     `json:"commonName,omitempty"`
+	KeyAlgorithm string     `json:"keyAlgorithm,omitempty"`
+	NotBefore    *time.Time `json:"notBefore,omitempty"`
+	NotAfter     *time.Time `json:"notAfter,omitempty"`
+}
+
+func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Healthy() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync waits for the data gatherer's informers cache to sync.
+func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch retrieves the certificates of ACM and the private CAs of ACM-PCA.
+func (g *DataGatherer) Fetch() (interface{}, error) {
+	return g.FetchContext(g.ctx)
+}
+
+// FetchContext retrieves the certificates and the private CAs, cancelling
+// the requests once ctx is done.
+func (g *DataGatherer) FetchContext(ctx context.Context) (interface{}, error) {
+	certificates, err := g.certificates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ACM certificates: %v", err)
+	}
+
+	certificateAuthorities, err := g.certificateAuthorities(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ACM-PCA certificate authorities: %v", err)
+	}
+
+	return &Info{
+		Certificates:           certificates,
+		CertificateAuthorities: certificateAuthorities,
+	}, nil
+}
+
+// certificates lists the ARNs of the certificates page by page, then
+// describes each of them. ACM only lists the RSA_2048 certificates unless
+// other key types are included, all of them are.
+func (g *DataGatherer) certificates(ctx context.Context) ([]*Certificate, error) {
+	arns := []*string{}
+	input := &acm.ListCertificatesInput{
+		Includes: &acm.Filters{KeyTypes: aws.StringSlice(acm.KeyAlgorithm_Values())},
+	}
+	err := g.acm.ListCertificatesPagesWithContext(ctx, input, func(page *acm.ListCertificatesOutput, lastPage bool) bool {
+		for _, summary := range page.CertificateSummaryList {
+			arns = append(arns, summary.CertificateArn)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	certificates := []*Certificate{}
+	for _, arn := range arns {
+		output, err := g.acm.DescribeCertificateWithContext(ctx, &acm.DescribeCertificateInput{CertificateArn: arn})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe certificate %s: %v", aws.StringValue(arn), err)
+		}
+		certificates = append(certificates, summarizeCertificate(output.Certificate))
+	}
+	sort.Slice(certificates, func(i, j int) bool {
+		return certificates[i].ARN < certificates[j].ARN
+	})
+	return certificates, nil
+}
+
+func summarizeCertificate(detail *acm.CertificateDetail) *Certificate {
+	certificate := &Certificate{
+		ARN:                     aws.StringValue(detail.CertificateArn),
+		DomainName:              aws.StringValue(detail.DomainName),
+		SubjectAlternativeNames: aws.StringValueSlice(detail.SubjectAlternativeNames),
+		Type:                    aws.StringValue(detail.Type),
+		Status:                  aws.StringValue(detail.Status),
+		Issuer:                  aws.StringValue(detail.Issuer),
+		KeyAlgorithm:            aws.StringValue(detail.KeyAlgorithm),
+		NotBefore:               detail.NotBefore,
+		NotAfter:                detail.NotAfter,
+		InUseBy:                 aws.StringValueSlice(detail.InUseBy),
+		RenewalEligibility:      aws.StringValue(detail.RenewalEligibility),
+		CertificateAuthorityARN: aws.StringValue(detail.CertificateAuthorityArn),
+	}
+	if detail.RenewalSummary != nil {
+		certificate.RenewalStatus = aws.StringValue(detail.RenewalSummary.RenewalStatus)
+	}
+	sort.Strings(certificate.InUseBy)
+	return certificate
+}
+
+// certificateAuthorities lists the private CAs page by page.
+func (g *DataGatherer) certificateAuthorities(ctx context.Context) ([]*CertificateAuthority, error) {
+	certificateAuthorities := []*CertificateAuthority{}
+	err := g.acmpca.ListCertificateAuthoritiesPagesWithContext(ctx, &acmpca.ListCertificateAuthoritiesInput{}, func(page *acmpca.ListCertificateAuthoritiesOutput, lastPage bool) bool {
+		for _, ca := range page.CertificateAuthorities {
+			certificateAuthorities = append(certificateAuthorities, summarizeCertificateAuthority(ca))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(certificateAuthorities, func(i, j int) bool {
+		return certificateAuthorities[i].ARN < certificateAuthorities[j].ARN
+	})
+	return certificateAuthorities, nil
+}
+
+func summarizeCertificateAuthority(ca *acmpca.CertificateAuthority) *CertificateAuthority {
+	certificateAuthority := &CertificateAuthority{
+		ARN:       aws.StringValue(ca.Arn),
+		Type:      aws.StringValue(ca.Type),
+		Status:    aws.StringValue(ca.Status),
+		NotBefore: ca.NotBefore,
+		NotAfter:  ca.NotAfter,
+	}
+	if configuration := ca.CertificateAuthorityConfiguration; configuration != nil {
+		certificateAuthority.KeyAlgorithm = aws.StringValue(configuration.KeyAlgorithm)
+		if configuration.Subject != nil {
+			certificateAuthority.CommonName = aws.StringValue(configuration.Subject.CommonName)
+		}
+	}
+	return certificateAuthority
+}
@@ -0,0 +1,154 @@
+package acm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/aws/aws-sdk-go/service/acmpca"
+	"github.com/aws/aws-sdk-go/service/acmpca/acmpcaiface"
+	"github.com/d4l3k/messagediff"
+)
+
+type fakeACM struct {
+	acmiface.ACMAPI
+	pages   [][]string
+	details map[string]*acm.CertificateDetail
+	// keyTypes are the key types of the certificates, RSA_2048 if not set.
+	keyTypes map[string]string
+}
+
+func (f *fakeACM) ListCertificatesPagesWithContext(ctx aws.Context, input *acm.ListCertificatesInput, fn func(*acm.ListCertificatesOutput, bool) bool, opts ...request.Option) error {
+	// as ACM, only the RSA_2048 certificates are listed by default
+	included := map[string]bool{acm.KeyAlgorithmRsa2048: true}
+	if input.Includes != nil && len(input.Includes.KeyTypes) > 0 {
+		included = map[string]bool{}
+		for _, keyType := range input.Includes.KeyTypes {
+			included[aws.StringValue(keyType)] = true
+		}
+	}
+	for i, arns := range f.pages {
+		page := &acm.ListCertificatesOutput{}
+		for _, arn := range arns {
+			keyType, ok := f.keyTypes[arn]
+			if !ok {
+				keyType = acm.KeyAlgorithmRsa2048
+			}
+			if !included[keyType] {
+				continue
+			}
+			page.CertificateSummaryList = append(page.CertificateSummaryList, &acm.CertificateSummary{CertificateArn: aws.String(arn)})
+		}
+		if !fn(page, i == len(f.pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeACM) DescribeCertificateWithContext(ctx aws.Context, input *acm.DescribeCertificateInput, opts ...request.Option) (*acm.DescribeCertificateOutput, error) {
+	return &acm.DescribeCertificateOutput{Certificate: f.details[aws.StringValue(input.CertificateArn)]}, nil
+}
+
+type fakeACMPCA struct {
+	acmpcaiface.ACMPCAAPI
+	cas []*acmpca.CertificateAuthority
+}
+
+func (f *fakeACMPCA) ListCertificateAuthoritiesPagesWithContext(ctx aws.Context, input *acmpca.ListCertificateAuthoritiesInput, fn func(*acmpca.ListCertificateAuthoritiesOutput, bool) bool, opts ...request.Option) error {
+	fn(&acmpca.ListCertificateAuthoritiesOutput{CertificateAuthorities: f.cas}, true)
+	return nil
+}
+
+func TestFetch(t *testing.T) {
+	notAfter := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	lbARN := "arn:aws:elasticloadbalancing:eu-west-1:111122223333:loadbalancer/app/ingress/1"
+
+	dg := &DataGatherer{
+		ctx: context.Background(),
+		acm: &fakeACM{
+			pages:    [][]string{{"arn:cert-b"}, {"arn:cert-a"}},
+			keyTypes: map[string]string{"arn:cert-b": acm.KeyAlgorithmEcPrime256v1},
+			details: map[string]*acm.CertificateDetail{
+				"arn:cert-a": {
+					CertificateArn:     aws.String("arn:cert-a"),
+					DomainName:         aws.String("example.com"),
+					Type:               aws.String(acm.CertificateTypeAmazonIssued),
+					Status:             aws.String(acm.CertificateStatusIssued),
+					NotAfter:           &notAfter,
+					InUseBy:            aws.StringSlice([]string{lbARN}),
+					RenewalEligibility: aws.String(acm.RenewalEligibilityEligible),
+				},
+				"arn:cert-b": {
+					CertificateArn:          aws.String("arn:cert-b"),
+					DomainName:              aws.String("internal.example.com"),
+					Type:                    aws.String(acm.CertificateTypePrivate),
+					Status:                  aws.String(acm.CertificateStatusIssued),
+					CertificateAuthorityArn: aws.String("arn:ca"),
+					RenewalEligibility:      aws.String(acm.RenewalEligibilityIneligible),
+				},
+			},
+		},
+		acmpca: &fakeACMPCA{
+			cas: []*acmpca.CertificateAuthority{
+				{
+					Arn:      aws.String("arn:ca"),
+					Type:     aws.String(acmpca.CertificateAuthorityTypeRoot),
+					Status:   aws.String(acmpca.CertificateAuthorityStatusActive),
+					NotAfter: &notAfter,
+					CertificateAuthorityConfiguration: &acmpca.CertificateAuthorityConfiguration{
+						KeyAlgorithm: aws.String(acmpca.KeyAlgorithmRsa2048),
+						Subject:      &acmpca.ASN1Subject{CommonName: aws.String("Example Root CA")},
+					},
+				},
+			},
+		},
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := &Info{
+		Certificates: []*Certificate{
+			{
+				ARN:                     "arn:cert-a",
+				DomainName:              "example.com",
+				SubjectAlternativeNames: []string{},
+				Type:                    acm.CertificateTypeAmazonIssued,
+				Status:                  acm.CertificateStatusIssued,
+				NotAfter:                &notAfter,
+				InUseBy:                 []string{lbARN},
+				RenewalEligibility:      acm.RenewalEligibilityEligible,
+			},
+			{
+				ARN:                     "arn:cert-b",
+				DomainName:              "internal.example.com",
+				SubjectAlternativeNames: []string{},
+				Type:                    acm.CertificateTypePrivate,
+				Status:                  acm.CertificateStatusIssued,
+				InUseBy:                 []string{},
+				RenewalEligibility:      acm.RenewalEligibilityIneligible,
+				CertificateAuthorityARN: "arn:ca",
+			},
+		},
+		CertificateAuthorities: []*CertificateAuthority{
+			{
+				ARN:          "arn:ca",
+				Type:         acmpca.CertificateAuthorityTypeRoot,
+				Status:       acmpca.CertificateAuthorityStatusActive,
+				CommonName:   "Example Root CA",
+				KeyAlgorithm: acmpca.KeyAlgorithmRsa2048,
+				NotAfter:     &notAfter,
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+}
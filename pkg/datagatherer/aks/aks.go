@@ -7,10 +7,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 
 	aks "github.com/Azure/aks-engine/pkg/api/agentPoolOnlyApi/v20180331"
 	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/transport"
 )
 
 // Config is the configuration for an AKS DataGatherer.
@@ -59,6 +62,7 @@ func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer
 		resourceGroup: c.ResourceGroup,
 		clusterName:   c.ClusterName,
 		credentials:   credentials,
+		client:        transport.Client(0),
 	}, nil
 }
 
@@ -93,17 +97,72 @@ func readCredentials(path string) (*AzureCredentials, error) {
 	return &creds, nil
 }
 
+// azureManagementURL is the base URL of the Azure Resource Manager API.
+const azureManagementURL = "https://management.azure.com"
+
 // DataGatherer is a data-gatherer for AKS.
 type DataGatherer struct {
 	resourceGroup string
 	clusterName   string
 	credentials   *AzureCredentials
+	// baseURL is the Azure Resource Manager API URL, it is only overridden
+	// in tests.
+	baseURL string
+	client  *http.Client
 }
 
 // Info contains the data retrieved from AKS.
 type Info struct {
 	// Cluster represents an AKS cluster.
 	Cluster *aks.ManagedCluster
+	// AgentPools are the node pools of the cluster.
+	AgentPools []*AgentPool `json:"agentPools"`
+	// AddOns are the add-ons configured on the cluster.
+	AddOns []*AddOn `json:"addOns"`
+}
+
+// AgentPool describes a node pool of an AKS cluster.
+type AgentPool struct {
+	Name                string   `json:"name"`
+	Mode                string   `json:"mode,omitempty"`
+	VMSize              string   `json:"vmSize,omitempty"`
+	OSType              string   `json:"osType,omitempty"`
+	OSDiskSizeGB        int      `json:"osDiskSizeGB,omitempty"`
+	Count               int      `json:"count"`
+	EnableAutoScaling   bool     `json:"enableAutoScaling"`
+	MinCount            int      `json:"minCount,omitempty"`
+	MaxCount            int      `json:"maxCount,omitempty"`
+	OrchestratorVersion string   `json:"orchestratorVersion,omitempty"`
+	NodeImageVersion    string   `json:"nodeImageVersion,omitempty"`
+	AvailabilityZones   []string `json:"availabilityZones,omitempty"`
+	ProvisioningState   string   `json:"provisioningState,omitempty"`
+}
+
+// AddOn describes an add-on profile of an AKS cluster.
+type AddOn struct {
+	Name    string            `json:"name"`
+	Enabled bool              `json:"enabled"`
+	Config  map[string]string `json:"config,omitempty"`
+}
+
+// agentPoolList is a page of the agent pools of a cluster.
+type agentPoolList struct {
+	Value []struct {
+		Name       string    `json:"name"`
+		Properties AgentPool `json:"properties"`
+	} `json:"value"`
+	// NextLink is the URL of the next page, it is empty on the last page.
+	NextLink string `json:"nextLink"`
+}
+
+// addOnProfiles are the add-on profiles of a managed cluster.
+type addOnProfiles struct {
+	Properties struct {
+		AddonProfiles map[string]struct {
+			Enabled bool              `json:"enabled"`
+			Config  map[string]string `json:"config"`
+		} `json:"addonProfiles"`
+	} `json:"properties"`
 }
 
 func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
@@ -116,44 +175,124 @@ func (g *DataGatherer) Delete() error {
 	return nil
 }
 
+func (g *DataGatherer) Healthy() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
 func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
 	// no async functionality, see Fetch
 	return nil
 }
 
-// Fetch retrieves cluster information from AKS.
+// Fetch retrieves cluster information from AKS, including its agent pools
+// and add-ons.
 func (g *DataGatherer) Fetch() (interface{}, error) {
-	client := &http.Client{}
+	return g.FetchContext(context.Background())
+}
+
+// FetchContext retrieves cluster information from AKS, cancelling the
+// requests once ctx is done.
+func (g *DataGatherer) FetchContext(ctx context.Context) (interface{}, error) {
+	baseURL := g.baseURL
+	if baseURL == "" {
+		baseURL = azureManagementURL
+	}
+	clusterURL := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ContainerService/managedClusters/%s", baseURL, g.credentials.Subscription, g.resourceGroup, g.clusterName)
+
+	body, err := g.get(ctx, clusterURL+"?api-version=2019-08-01")
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving cluster information: %v", err)
+	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://management.azure.com/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ContainerService/managedClusters/%s?api-version=2019-08-01", g.credentials.Subscription, g.resourceGroup, g.clusterName), nil)
+	var cluster aks.ManagedCluster
+	err = json.Unmarshal(body, &cluster)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", g.credentials.AccessToken))
 
-	resp, err := client.Do(req)
+	// the add-on profiles are not part of the ManagedCluster type
+	var profiles addOnProfiles
+	err = json.Unmarshal(body, &profiles)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	addOns := []*AddOn{}
+	for name, profile := range profiles.Properties.AddonProfiles {
+		addOns = append(addOns, &AddOn{Name: name, Enabled: profile.Enabled, Config: profile.Config})
+	}
+	sort.Slice(addOns, func(i, j int) bool {
+		return addOns[i].Name < addOns[j].Name
+	})
 
-	if resp.StatusCode != http.StatusOK {
-		errorBody, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("error retrieving cluster information (status code %d): %v", resp.StatusCode, string(errorBody))
+	agentPools, err := g.listAgentPools(ctx, clusterURL+"/agentPools?api-version=2020-11-01")
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving agent pools: %v", err)
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	return &Info{
+		Cluster:    &cluster,
+		AgentPools: agentPools,
+		AddOns:     addOns,
+	}, nil
+}
+
+// listAgentPools lists the agent pools of the cluster, following the next
+// links of the pages until the last one. The next links must be on the host
+// of the first page, so that the token is not sent to another host.
+func (g *DataGatherer) listAgentPools(ctx context.Context, link string) ([]*AgentPool, error) {
+	first, err := url.Parse(link)
 	if err != nil {
 		return nil, err
 	}
+	agentPools := []*AgentPool{}
+	for link != "" {
+		body, err := g.get(ctx, link)
+		if err != nil {
+			return nil, err
+		}
+		var page agentPoolList
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		for _, value := range page.Value {
+			agentPool := value.Properties
+			agentPool.Name = value.Name
+			agentPools = append(agentPools, &agentPool)
+		}
+		if page.NextLink != "" {
+			next, err := url.Parse(page.NextLink)
+			if err != nil {
+				return nil, fmt.Errorf("invalid next link %q: %v", page.NextLink, err)
+			}
+			if next.Scheme != first.Scheme || next.Host != first.Host {
+				return nil, fmt.Errorf("next link %q is not on %s", page.NextLink, first.Host)
+			}
+		}
+		link = page.NextLink
+	}
+	return agentPools, nil
+}
 
-	var cluster aks.ManagedCluster
-	err = json.Unmarshal(body, &cluster)
+// get sends an authenticated GET request to the Azure API and returns the
+// body of the response.
+func (g *DataGatherer) get(ctx context.Context, link string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", g.credentials.AccessToken))
 
-	return &Info{
-		Cluster: &cluster,
-	}, nil
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errorBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("status code %d: %v", resp.StatusCode, string(errorBody))
+	}
+
+	return ioutil.ReadAll(resp.Body)
 }
@@ -0,0 +1,131 @@
+package aks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestFetch(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		clusterPath := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerService/managedClusters/cluster"
+		switch {
+		case r.URL.Path == clusterPath:
+			fmt.Fprint(w, `{"name": "cluster", "properties": {"kubernetesVersion": "1.19.6", "addonProfiles": {
+				"omsagent": {"enabled": true, "config": {"logAnalyticsWorkspaceResourceID": "/workspaces/ws"}},
+				"azurepolicy": {"enabled": false}
+			}}}`)
+		case r.URL.Path == clusterPath+"/agentPools" && r.URL.Query().Get("page") == "":
+			fmt.Fprintf(w, `{"value": [{"name": "system", "properties": {"mode": "System", "vmSize": "Standard_D2s_v3", "osType": "Linux", "count": 3, "enableAutoScaling": true, "minCount": 1, "maxCount": 5, "nodeImageVersion": "AKSUbuntu-1804-2021.01.06"}}], "nextLink": "%s%s/agentPools?api-version=2020-11-01&page=2"}`, server.URL, clusterPath)
+		case r.URL.Path == clusterPath+"/agentPools":
+			fmt.Fprint(w, `{"value": [{"name": "win", "properties": {"mode": "User", "vmSize": "Standard_D4s_v3", "osType": "Windows", "count": 1}}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dg := &DataGatherer{
+		resourceGroup: "rg",
+		clusterName:   "cluster",
+		credentials:   &AzureCredentials{AccessToken: "token", Subscription: "sub", TokenType: "Bearer"},
+		baseURL:       server.URL,
+		client:        http.DefaultClient,
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info := data.(*Info)
+
+	expectedAgentPools := []*AgentPool{
+		{
+			Name:              "system",
+			Mode:              "System",
+			VMSize:            "Standard_D2s_v3",
+			OSType:            "Linux",
+			Count:             3,
+			EnableAutoScaling: true,
+			MinCount:          1,
+			MaxCount:          5,
+			NodeImageVersion:  "AKSUbuntu-1804-2021.01.06",
+		},
+		{Name: "win", Mode: "User", VMSize: "Standard_D4s_v3", OSType: "Windows", Count: 1},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedAgentPools, info.AgentPools); !equal {
+		t.Errorf("unexpected agent pools:\n%s", diff)
+	}
+
+	expectedAddOns := []*AddOn{
+		{Name: "azurepolicy"},
+		{Name: "omsagent", Enabled: true, Config: map[string]string{"logAnalyticsWorkspaceResourceID": "/workspaces/ws"}},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedAddOns, info.AddOns); !equal {
+		t.Errorf("unexpected add-ons:\n%s", diff)
+	}
+}
+
+func TestFetchNextLinkOtherHost(t *testing.T) {
+	// the token must not be sent to the host of the next link
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to another host: %s", r.URL)
+	}))
+	defer other.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/agentPools") {
+			fmt.Fprintf(w, `{"value": [], "nextLink": "%s/agentPools?page=2"}`, other.URL)
+			return
+		}
+		fmt.Fprint(w, `{"name": "cluster"}`)
+	}))
+	defer server.Close()
+
+	dg := &DataGatherer{
+		resourceGroup: "rg",
+		clusterName:   "cluster",
+		credentials:   &AzureCredentials{AccessToken: "token", Subscription: "sub", TokenType: "Bearer"},
+		baseURL:       server.URL,
+		client:        http.DefaultClient,
+	}
+
+	if _, err := dg.Fetch(); err == nil || !strings.Contains(err.Error(), "is not on") {
+		t.Fatalf("expected the next link on another host to be rejected, got %v", err)
+	}
+}
+
+func TestFetchContextCancelled(t *testing.T) {
+	// the server hangs as during an outage of the Azure API
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	dg := &DataGatherer{
+		resourceGroup: "rg",
+		clusterName:   "cluster",
+		credentials:   &AzureCredentials{AccessToken: "token", Subscription: "sub", TokenType: "Bearer"},
+		baseURL:       server.URL,
+		client:        http.DefaultClient,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := dg.FetchContext(ctx); err == nil {
+		t.Fatalf("expected the fetch to fail once the context is done")
+	}
+}
@@ -0,0 +1,460 @@
+package certmanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The ACME solver types.
+const (
+	SolverDNS01  = "DNS01"
+	SolverHTTP01 = "HTTP01"
+)
+
+// challengeTypes are the solver types of the types of the Challenges.
+var challengeTypes = map[string]string{
+	"DNS-01":  SolverDNS01,
+	"HTTP-01": SolverHTTP01,
+}
+
+const (
+	defaultFailureWindow = 24 * time.Hour
+	defaultMaxFailures   = 5
+)
+
+// acmeResourceTypes are the cert-manager resources gathered by the
+// cert-manager-acme data gatherer.
+var acmeResourceTypes = []schema.GroupVersionResource{
+	issuersGVR,
+	clusterIssuersGVR,
+	ordersGVR,
+	challengesGVR,
+}
+
+// ACMEConfig is the configuration for the cert-manager-acme DataGatherer.
+type ACMEConfig struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+	// FailureWindow is how far back the Orders and Challenges are taken
+	// into account, 24 hours by default. The pending ones are always.
+	FailureWindow time.Duration `yaml:"failure-window"`
+	// MaxFailures is the maximum number of failures reported per issuer,
+	// the most recent ones are kept. 5 by default.
+	MaxFailures int `yaml:"max-failures"`
+}
+
+func (c *ACMEConfig) validate() error {
+	if c.FailureWindow < 0 {
+		return fmt.Errorf("invalid configuration: failure-window cannot be negative")
+	}
+	if c.MaxFailures < 0 {
+		return fmt.Errorf("invalid configuration: max-failures cannot be negative")
+	}
+	return nil
+}
+
+// DynamicConfig returns the configuration of the dynamic data gatherer used to
+// collect the issuers, Orders and Challenges.
+func (c *ACMEConfig) DynamicConfig() *k8s.ConfigDynamic {
+	return &k8s.ConfigDynamic{
+		KubeConfigPath:        c.KubeConfigPath,
+		KubeConfigContext:     c.KubeConfigContext,
+		GroupVersionResources: acmeResourceTypes,
+		ExcludeNamespaces:     c.ExcludeNamespaces,
+		IncludeNamespaces:     c.IncludeNamespaces,
+	}
+}
+
+// NewDataGatherer creates a new DataGatherer for the ACME issuers of
+// cert-manager.
+func (c *ACMEConfig) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	dynamicDg, err := c.DynamicConfig().NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &ACMEDataGatherer{
+		DynamicWrapper: k8s.DynamicWrapper{Dynamic: dynamicDg},
+		now:            time.Now,
+		failureWindow:  c.FailureWindow,
+		maxFailures:    c.MaxFailures,
+	}
+	if g.failureWindow == 0 {
+		g.failureWindow = defaultFailureWindow
+	}
+	if g.maxFailures == 0 {
+		g.maxFailures = defaultMaxFailures
+	}
+	return g, nil
+}
+
+// ACMEDataGatherer gathers the ACME Issuers and ClusterIssuers with their
+// Orders and Challenges, and emits the health of the issuance pipeline of
+// each issuer and solver type, so that outages can be detected before the
+// certificates expire.
+type ACMEDataGatherer struct {
+	k8s.DynamicWrapper
+	now           func() time.Time
+	failureWindow time.Duration
+	maxFailures   int
+}
+
+// ACMESummary is the data emitted by the cert-manager-acme data gatherer.
+type ACMESummary struct {
+	Issuers []*ACMEIssuerHealth `json:"issuers"`
+	// Solvers is the availability of the issuers per solver type.
+	Solvers []*ACMESolverTypeHealth `json:"solvers"`
+}
+
+// ACMEIssuerHealth is the health of an ACME Issuer or ClusterIssuer.
+type ACMEIssuerHealth struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Server is the URL of the ACME directory.
+	Server string `json:"server"`
+	// Ready, Reason and Message come from the Ready condition.
+	Ready   bool   `json:"ready"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	// AccountRegistered is whether the ACME account is registered, which is
+	// when its URI is set in the status.
+	AccountRegistered bool   `json:"accountRegistered"`
+	AccountURI        string `json:"accountURI,omitempty"`
+	// Solvers are the solvers configured, with the ones of the Challenges
+	// which are no longer configured.
+	Solvers []*ACMESolverHealth `json:"solvers"`
+	// Orders counts the Orders of the issuer by state.
+	Orders ACMEOrderCounts `json:"orders"`
+	// RecentFailures are the failed Orders and Challenges, the most recent
+	// first.
+	RecentFailures []*ACMEFailure `json:"recentFailures,omitempty"`
+	// Available is whether the issuer is ready, its account registered, and
+	// its last completed Order did not fail.
+	Available bool `json:"available"`
+}
+
+// ACMESolverHealth is the health of a solver of an issuer.
+type ACMESolverHealth struct {
+	// Type is DNS01 or HTTP01.
+	Type string `json:"type"`
+	// Provider is the DNS provider of the DNS01 solvers, e.g. route53, or
+	// how the HTTP01 challenges are served, e.g. ingress.
+	Provider          string `json:"provider,omitempty"`
+	PendingChallenges int    `json:"pendingChallenges"`
+	FailedChallenges  int    `json:"failedChallenges"`
+	// Available is whether the issuer is available and none of the
+	// Challenges of the solver failed.
+	Available bool `json:"available"`
+}
+
+// ACMEOrderCounts counts Orders by state. The pending Orders are not yet
+// valid or failed.
+type ACMEOrderCounts struct {
+	Pending int `json:"pending"`
+	Valid   int `json:"valid"`
+	Failed  int `json:"failed"`
+}
+
+// ACMEFailure is a failed Order or Challenge.
+type ACMEFailure struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Type is the solver type and DNSName the name validated by a
+	// Challenge.
+	Type    string `json:"type,omitempty"`
+	DNSName string `json:"dnsName,omitempty"`
+	State   string `json:"state"`
+	Reason  string `json:"reason,omitempty"`
+	// Time is when the Order failed, or when the Challenge was created.
+	Time time.Time `json:"time"`
+}
+
+// ACMESolverTypeHealth is the availability of the issuers of a solver type.
+type ACMESolverTypeHealth struct {
+	Type string `json:"type"`
+	// Issuers is the number of issuers with a solver of the type, and
+	// AvailableIssuers the ones with an available solver of the type.
+	Issuers           int `json:"issuers"`
+	AvailableIssuers  int `json:"availableIssuers"`
+	PendingChallenges int `json:"pendingChallenges"`
+	FailedChallenges  int `json:"failedChallenges"`
+}
+
+// Fetch summarises the health of the ACME issuers currently in the cache.
+// Deleted resources are ignored.
+func (g *ACMEDataGatherer) Fetch() (interface{}, error) {
+	objects, err := fetchObjects(g.Dynamic)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeACME(objects, g.now(), g.failureWindow, g.maxFailures), nil
+}
+
+// summarizeACME joins the Orders and Challenges returned by objects with
+// their ACME issuer. The ones completed before the failure window are
+// ignored.
+func summarizeACME(objects func(schema.GroupVersionResource) []*unstructured.Unstructured, now time.Time, failureWindow time.Duration, maxFailures int) *ACMESummary {
+	since := now.Add(-failureWindow)
+	issuers := map[string]*ACMEIssuerHealth{}
+	summary := &ACMESummary{
+		Issuers: []*ACMEIssuerHealth{},
+		Solvers: []*ACMESolverTypeHealth{},
+	}
+
+	for _, gvr := range []schema.GroupVersionResource{issuersGVR, clusterIssuersGVR} {
+		for _, issuer := range objects(gvr) {
+			acme, found, _ := unstructured.NestedMap(issuer.Object, "spec", "acme")
+			if !found {
+				continue
+			}
+			health := &ACMEIssuerHealth{
+				Kind:      issuer.GetKind(),
+				Namespace: issuer.GetNamespace(),
+				Name:      issuer.GetName(),
+				Solvers:   []*ACMESolverHealth{},
+			}
+			health.Server, _, _ = unstructured.NestedString(acme, "server")
+			health.AccountURI, _, _ = unstructured.NestedString(issuer.Object, "status", "acme", "uri")
+			health.AccountRegistered = health.AccountURI != ""
+			health.Ready, health.Reason, health.Message = readyCondition(issuer)
+
+			solvers, _, _ := unstructured.NestedSlice(acme, "solvers")
+			for _, s := range solvers {
+				solver, ok := s.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if solverType, provider := solverOf(solver); solverType != "" {
+					health.solver(solverType, provider)
+				}
+			}
+
+			issuers[issuerKey(health.Kind, health.Namespace, health.Name)] = health
+			summary.Issuers = append(summary.Issuers, health)
+		}
+	}
+
+	// the last completed Order of each issuer, to tell whether it failed
+	lastOrders := map[*ACMEIssuerHealth]*unstructured.Unstructured{}
+	for _, order := range objects(ordersGVR) {
+		health := issuers[issuerRefKey(order)]
+		if health == nil {
+			continue
+		}
+		state, _, _ := unstructured.NestedString(order.Object, "status", "state")
+		switch {
+		case !acmeStateCompleted(state):
+			health.Orders.Pending++
+			continue
+		case order.GetCreationTimestamp().Time.Before(since):
+			continue
+		case acmeStateFailed(state):
+			health.Orders.Failed++
+			health.RecentFailures = append(health.RecentFailures, newACMEFailure(order, state, failureTime(order)))
+		default:
+			health.Orders.Valid++
+		}
+		if last := lastOrders[health]; last == nil || order.GetCreationTimestamp().Time.After(last.GetCreationTimestamp().Time) {
+			lastOrders[health] = order
+		}
+	}
+
+	for _, challenge := range objects(challengesGVR) {
+		health := issuers[issuerRefKey(challenge)]
+		if health == nil {
+			continue
+		}
+		state, _, _ := unstructured.NestedString(challenge.Object, "status", "state")
+		created := challenge.GetCreationTimestamp().Time
+		solverConfig, _, _ := unstructured.NestedMap(challenge.Object, "spec", "solver")
+		solverType, provider := solverOf(solverConfig)
+		if solverType == "" {
+			challengeType, _, _ := unstructured.NestedString(challenge.Object, "spec", "type")
+			solverType = challengeTypes[challengeType]
+		}
+		if solverType == "" {
+			continue
+		}
+		solver := health.solver(solverType, provider)
+		switch {
+		case !acmeStateCompleted(state):
+			solver.PendingChallenges++
+		case acmeStateFailed(state) && !created.Before(since):
+			solver.FailedChallenges++
+			failure := newACMEFailure(challenge, state, created)
+			failure.Type = solver.Type
+			failure.DNSName, _, _ = unstructured.NestedString(challenge.Object, "spec", "dnsName")
+			health.RecentFailures = append(health.RecentFailures, failure)
+		}
+	}
+
+	types := map[string]*ACMESolverTypeHealth{}
+	for _, health := range summary.Issuers {
+		lastState := ""
+		if last := lastOrders[health]; last != nil {
+			lastState, _, _ = unstructured.NestedString(last.Object, "status", "state")
+		}
+		health.Available = health.Ready && health.AccountRegistered && !acmeStateFailed(lastState)
+
+		sort.SliceStable(health.RecentFailures, func(i, j int) bool {
+			return health.RecentFailures[i].Time.After(health.RecentFailures[j].Time)
+		})
+		if len(health.RecentFailures) > maxFailures {
+			health.RecentFailures = health.RecentFailures[:maxFailures]
+		}
+
+		available := map[string]bool{}
+		for _, solver := range health.Solvers {
+			solver.Available = health.Available && solver.FailedChallenges == 0
+			solverType := types[solver.Type]
+			if solverType == nil {
+				solverType = &ACMESolverTypeHealth{Type: solver.Type}
+				types[solver.Type] = solverType
+				summary.Solvers = append(summary.Solvers, solverType)
+			}
+			if _, counted := available[solver.Type]; !counted {
+				solverType.Issuers++
+			}
+			available[solver.Type] = available[solver.Type] || solver.Available
+			solverType.PendingChallenges += solver.PendingChallenges
+			solverType.FailedChallenges += solver.FailedChallenges
+		}
+		for solverType, ok := range available {
+			if ok {
+				types[solverType].AvailableIssuers++
+			}
+		}
+	}
+
+	sort.Slice(summary.Issuers, func(i, j int) bool {
+		a, b := summary.Issuers[i], summary.Issuers[j]
+		return a.Kind+"/"+a.Namespace+"/"+a.Name < b.Kind+"/"+b.Namespace+"/"+b.Name
+	})
+	sort.Slice(summary.Solvers, func(i, j int) bool {
+		return summary.Solvers[i].Type < summary.Solvers[j].Type
+	})
+
+	return summary
+}
+
+// solver returns the solver of the issuer with the type and provider, which
+// is added if the issuer has none.
+func (h *ACMEIssuerHealth) solver(solverType, provider string) *ACMESolverHealth {
+	for _, solver := range h.Solvers {
+		if solver.Type == solverType && solver.Provider == provider {
+			return solver
+		}
+	}
+	solver := &ACMESolverHealth{Type: solverType, Provider: provider}
+	h.Solvers = append(h.Solvers, solver)
+	return solver
+}
+
+// solverOf returns the type and the provider of the solver configuration of
+// an issuer or a Challenge.
+func solverOf(solver map[string]interface{}) (string, string) {
+	if dns01, ok := solver["dns01"].(map[string]interface{}); ok {
+		return SolverDNS01, firstKey(dns01, "cnameStrategy")
+	}
+	if http01, ok := solver["http01"].(map[string]interface{}); ok {
+		return SolverHTTP01, firstKey(http01)
+	}
+	return "", ""
+}
+
+// firstKey returns the first key of the map in alphabetical order, except
+// the ignored ones.
+func firstKey(m map[string]interface{}, ignored ...string) string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !contains(ignored, key) {
+			return key
+		}
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func issuerKey(kind, namespace, name string) string {
+	if kind == "ClusterIssuer" {
+		namespace = ""
+	}
+	return kind + "/" + namespace + "/" + name
+}
+
+// issuerRefKey returns the key of the issuer referenced by an Order or a
+// Challenge, an Issuer of its namespace by default.
+func issuerRefKey(obj *unstructured.Unstructured) string {
+	kind, _, _ := unstructured.NestedString(obj.Object, "spec", "issuerRef", "kind")
+	name, _, _ := unstructured.NestedString(obj.Object, "spec", "issuerRef", "name")
+	if kind == "" {
+		kind = "Issuer"
+	}
+	return issuerKey(kind, obj.GetNamespace(), name)
+}
+
+// acmeStateCompleted returns whether the state of an Order or a Challenge is
+// final.
+func acmeStateCompleted(state string) bool {
+	return state == "valid" || acmeStateFailed(state)
+}
+
+// acmeStateFailed returns whether the state of an Order or a Challenge is a
+// failure.
+func acmeStateFailed(state string) bool {
+	return state == "invalid" || state == "errored" || state == "expired"
+}
+
+// failureTime returns when the Order failed, or when it was created if the
+// failure time is not set.
+func failureTime(order *unstructured.Unstructured) time.Time {
+	if value, _, _ := unstructured.NestedString(order.Object, "status", "failureTime"); value != "" {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t
+		}
+	}
+	return order.GetCreationTimestamp().Time
+}
+
+func newACMEFailure(obj *unstructured.Unstructured, state string, t time.Time) *ACMEFailure {
+	reason, _, _ := unstructured.NestedString(obj.Object, "status", "reason")
+	return &ACMEFailure{
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		State:     state,
+		Reason:    reason,
+		Time:      t.UTC(),
+	}
+}
@@ -0,0 +1,126 @@
+package certmanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func withCreationTimestamp(obj *unstructured.Unstructured, t time.Time) *unstructured.Unstructured {
+	obj.SetCreationTimestamp(metav1.NewTime(t))
+	return obj
+}
+
+func TestSummarizeACME(t *testing.T) {
+	now := time.Date(2021, 3, 16, 12, 0, 0, 0, time.UTC)
+	letsencrypt := map[string]interface{}{"name": "letsencrypt", "kind": "ClusterIssuer"}
+
+	clusterIssuerStatus := condition("Ready", "True", "ACMEAccountRegistered", "The ACME account was registered")
+	clusterIssuerStatus["acme"] = map[string]interface{}{"uri": "https://acme-v02.api.letsencrypt.org/acme/acct/1"}
+	clusterIssuer := getResource("cert-manager.io/v1", "ClusterIssuer", "letsencrypt", "issuer-1", "",
+		map[string]interface{}{"acme": map[string]interface{}{
+			"server": "https://acme-v02.api.letsencrypt.org/directory",
+			"solvers": []interface{}{
+				map[string]interface{}{"dns01": map[string]interface{}{"cnameStrategy": "Follow", "route53": map[string]interface{}{}}},
+				map[string]interface{}{"http01": map[string]interface{}{"ingress": map[string]interface{}{}}},
+			},
+		}},
+		clusterIssuerStatus)
+	clusterIssuer.SetNamespace("")
+
+	failedOrder := getResource("acme.cert-manager.io/v1", "Order", "web-1-123", "order-2", "", map[string]interface{}{"issuerRef": letsencrypt},
+		map[string]interface{}{"state": "invalid", "reason": "Failed to finalize Order", "failureTime": "2021-03-16T09:30:00Z"})
+	failedChallenge := getResource("acme.cert-manager.io/v1", "Challenge", "web-2-456-0", "challenge-1", "", map[string]interface{}{
+		"issuerRef": letsencrypt,
+		"type":      "DNS-01",
+		"dnsName":   "example.com",
+		"solver":    map[string]interface{}{"dns01": map[string]interface{}{"route53": map[string]interface{}{}}},
+	}, map[string]interface{}{"state": "invalid", "reason": "DNS problem: NXDOMAIN"})
+
+	resources := map[schema.GroupVersionResource][]*unstructured.Unstructured{
+		issuersGVR: {
+			getResource("cert-manager.io/v1", "Issuer", "staging", "issuer-2", "",
+				map[string]interface{}{"acme": map[string]interface{}{
+					"server":  "https://acme-staging-v02.api.letsencrypt.org/directory",
+					"solvers": []interface{}{map[string]interface{}{"http01": map[string]interface{}{"ingress": map[string]interface{}{}}}},
+				}},
+				condition("Ready", "False", "ErrRegisterACMEAccount", "Failed to register ACME account")),
+			// not an ACME issuer
+			getResource("cert-manager.io/v1", "Issuer", "ca", "issuer-3", "",
+				map[string]interface{}{"ca": map[string]interface{}{"secretName": "ca"}},
+				condition("Ready", "True", "KeyPairVerified", "Signing CA verified")),
+		},
+		clusterIssuersGVR: {clusterIssuer},
+		ordersGVR: {
+			withCreationTimestamp(getResource("acme.cert-manager.io/v1", "Order", "web-1-789", "order-1", "", map[string]interface{}{"issuerRef": letsencrypt},
+				map[string]interface{}{"state": "valid"}), now.Add(-2*time.Hour)),
+			withCreationTimestamp(failedOrder, now.Add(-3*time.Hour)),
+			withCreationTimestamp(getResource("acme.cert-manager.io/v1", "Order", "web-2-456", "order-3", "", map[string]interface{}{"issuerRef": letsencrypt},
+				map[string]interface{}{"state": "pending"}), now.Add(-time.Hour)),
+			// failed before the failure window
+			withCreationTimestamp(getResource("acme.cert-manager.io/v1", "Order", "web-0-1", "order-4", "", map[string]interface{}{"issuerRef": letsencrypt},
+				map[string]interface{}{"state": "invalid"}), now.Add(-48*time.Hour)),
+		},
+		challengesGVR: {
+			withCreationTimestamp(failedChallenge, now.Add(-time.Hour)),
+			withCreationTimestamp(getResource("acme.cert-manager.io/v1", "Challenge", "web-2-456-1", "challenge-2", "", map[string]interface{}{
+				"issuerRef": letsencrypt,
+				"type":      "HTTP-01",
+				"solver":    map[string]interface{}{"http01": map[string]interface{}{"ingress": map[string]interface{}{}}},
+			}, map[string]interface{}{"state": "pending"}), now.Add(-time.Hour)),
+		},
+	}
+
+	summary := summarizeACME(func(gvr schema.GroupVersionResource) []*unstructured.Unstructured {
+		return resources[gvr]
+	}, now, 24*time.Hour, defaultMaxFailures)
+
+	expected := &ACMESummary{
+		Issuers: []*ACMEIssuerHealth{
+			{
+				Kind:              "ClusterIssuer",
+				Name:              "letsencrypt",
+				Server:            "https://acme-v02.api.letsencrypt.org/directory",
+				Ready:             true,
+				Reason:            "ACMEAccountRegistered",
+				Message:           "The ACME account was registered",
+				AccountRegistered: true,
+				AccountURI:        "https://acme-v02.api.letsencrypt.org/acme/acct/1",
+				Solvers: []*ACMESolverHealth{
+					{Type: SolverDNS01, Provider: "route53", FailedChallenges: 1},
+					{Type: SolverHTTP01, Provider: "ingress", PendingChallenges: 1, Available: true},
+				},
+				Orders: ACMEOrderCounts{Pending: 1, Valid: 1, Failed: 1},
+				RecentFailures: []*ACMEFailure{
+					{Kind: "Challenge", Namespace: "default", Name: "web-2-456-0", Type: SolverDNS01, DNSName: "example.com", State: "invalid", Reason: "DNS problem: NXDOMAIN", Time: now.Add(-time.Hour)},
+					{Kind: "Order", Namespace: "default", Name: "web-1-123", State: "invalid", Reason: "Failed to finalize Order", Time: now.Add(-150 * time.Minute)},
+				},
+				// the last completed Order is valid
+				Available: true,
+			},
+			{
+				Kind:    "Issuer",
+				Name:    "staging",
+				Server:  "https://acme-staging-v02.api.letsencrypt.org/directory",
+				Reason:  "ErrRegisterACMEAccount",
+				Message: "Failed to register ACME account",
+				Solvers: []*ACMESolverHealth{
+					{Type: SolverHTTP01, Provider: "ingress"},
+				},
+				Namespace: "default",
+			},
+		},
+		Solvers: []*ACMESolverTypeHealth{
+			{Type: SolverDNS01, Issuers: 1, FailedChallenges: 1},
+			{Type: SolverHTTP01, Issuers: 2, AvailableIssuers: 1, PendingChallenges: 1},
+		},
+	}
+
+	if diff, equal := messagediff.PrettyDiff(expected, summary); !equal {
+		t.Errorf("unexpected summary:\n%s", diff)
+	}
+}
@@ -0,0 +1,317 @@
+// Package certmanager provides a datagatherer summarising the state of
+// cert-manager resources.
+package certmanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	certificatesGVR        = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+	certificateRequestsGVR = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificaterequests"}
+	issuersGVR             = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "issuers"}
+	clusterIssuersGVR      = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "clusterissuers"}
+	ordersGVR              = schema.GroupVersionResource{Group: "acme.cert-manager.io", Version: "v1", Resource: "orders"}
+	challengesGVR          = schema.GroupVersionResource{Group: "acme.cert-manager.io", Version: "v1", Resource: "challenges"}
+)
+
+// resourceTypes are the cert-manager resources gathered together.
+var resourceTypes = []schema.GroupVersionResource{
+	certificatesGVR,
+	certificateRequestsGVR,
+	issuersGVR,
+	clusterIssuersGVR,
+	ordersGVR,
+	challengesGVR,
+}
+
+// issuerTypes are the fields of an issuer spec identifying its type.
+var issuerTypes = []string{"acme", "ca", "selfSigned", "vault", "venafi"}
+
+// Config is the configuration for the cert-manager DataGatherer.
+type Config struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+}
+
+// DynamicConfig returns the configuration of the dynamic data gatherer used to
+// collect the cert-manager resources.
+func (c *Config) DynamicConfig() *k8s.ConfigDynamic {
+	return &k8s.ConfigDynamic{
+		KubeConfigPath:        c.KubeConfigPath,
+		KubeConfigContext:     c.KubeConfigContext,
+		GroupVersionResources: resourceTypes,
+		ExcludeNamespaces:     c.ExcludeNamespaces,
+		IncludeNamespaces:     c.IncludeNamespaces,
+	}
+}
+
+// NewDataGatherer creates a new DataGatherer for cert-manager.
+func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	dynamicDg, err := c.DynamicConfig().NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGatherer{DynamicWrapper: k8s.DynamicWrapper{Dynamic: dynamicDg}}, nil
+}
+
+// DataGatherer gathers Certificates, CertificateRequests, Issuers,
+// ClusterIssuers, Orders and Challenges and emits a summary of their state.
+type DataGatherer struct {
+	k8s.DynamicWrapper
+}
+
+// Summary is the data emitted by the cert-manager data gatherer.
+type Summary struct {
+	Certificates []*CertificateSummary `json:"certificates"`
+	Issuers      []*IssuerSummary      `json:"issuers"`
+}
+
+// IssuerReference identifies the issuer of a Certificate.
+type IssuerReference struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// CertificateSummary is the state of a Certificate joined with the state of
+// its CertificateRequests, Orders and Challenges.
+type CertificateSummary struct {
+	Namespace  string          `json:"namespace"`
+	Name       string          `json:"name"`
+	SecretName string          `json:"secretName,omitempty"`
+	DNSNames   []string        `json:"dnsNames,omitempty"`
+	IssuerRef  IssuerReference `json:"issuerRef"`
+	Ready      bool            `json:"ready"`
+	// Reason and Message come from the Ready condition.
+	Reason      string `json:"reason,omitempty"`
+	Message     string `json:"message,omitempty"`
+	NotBefore   string `json:"notBefore,omitempty"`
+	NotAfter    string `json:"notAfter,omitempty"`
+	RenewalTime string `json:"renewalTime,omitempty"`
+	// FailureReasons lists the failures of the resources created to issue
+	// the Certificate.
+	FailureReasons []string `json:"failureReasons,omitempty"`
+}
+
+// IssuerSummary is the state of an Issuer or ClusterIssuer.
+type IssuerSummary struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Type is the issuer type configured in the spec, e.g. acme or ca.
+	Type    string `json:"type,omitempty"`
+	Ready   bool   `json:"ready"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Fetch summarises the cert-manager resources currently in the cache.
+// Deleted resources are ignored.
+func (g *DataGatherer) Fetch() (interface{}, error) {
+	objects, err := fetchObjects(g.Dynamic)
+	if err != nil {
+		return nil, err
+	}
+	return summarize(objects), nil
+}
+
+// fetchObjects fetches the resources of the dynamic data gatherer and returns
+// a function returning the resources of each type, deleted resources
+// excluded.
+func fetchObjects(dynamicDg datagatherer.DataGatherer) (func(schema.GroupVersionResource) []*unstructured.Unstructured, error) {
+	data, err := dynamicDg.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected data from the dynamic data gatherer")
+	}
+	resources, ok := list["resources"].(map[string]map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected data from the dynamic data gatherer")
+	}
+
+	return func(gvr schema.GroupVersionResource) []*unstructured.Unstructured {
+		items, _ := resources[k8s.ResourceTypeKey(gvr)]["items"].([]*api.GatheredResource)
+		var objects []*unstructured.Unstructured
+		for _, item := range items {
+			if !item.DeletedAt.IsZero() {
+				continue
+			}
+			if resource, ok := item.Resource.(*unstructured.Unstructured); ok {
+				objects = append(objects, resource)
+			}
+		}
+		return objects
+	}, nil
+}
+
+// summarize joins the resources returned by objects for each resource type.
+func summarize(objects func(schema.GroupVersionResource) []*unstructured.Unstructured) *Summary {
+	summary := &Summary{
+		Certificates: []*CertificateSummary{},
+		Issuers:      []*IssuerSummary{},
+	}
+
+	// failures are collected per owner UID and propagated from Challenges to
+	// Orders, CertificateRequests and finally Certificates
+	challengeFailures := failuresByOwner(objects(challengesGVR), acmeFailure)
+	orderFailures := failuresByOwner(objects(ordersGVR), acmeFailure)
+	mergeFailures(orderFailures, challengeFailures, objects(ordersGVR))
+	requestFailures := failuresByOwner(objects(certificateRequestsGVR), requestFailure)
+	mergeFailures(requestFailures, orderFailures, objects(certificateRequestsGVR))
+
+	for _, cert := range objects(certificatesGVR) {
+		c := &CertificateSummary{
+			Namespace:      cert.GetNamespace(),
+			Name:           cert.GetName(),
+			FailureReasons: requestFailures[string(cert.GetUID())],
+		}
+		c.SecretName, _, _ = unstructured.NestedString(cert.Object, "spec", "secretName")
+		c.DNSNames, _, _ = unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames")
+		c.IssuerRef.Name, _, _ = unstructured.NestedString(cert.Object, "spec", "issuerRef", "name")
+		c.IssuerRef.Kind, _, _ = unstructured.NestedString(cert.Object, "spec", "issuerRef", "kind")
+		c.IssuerRef.Group, _, _ = unstructured.NestedString(cert.Object, "spec", "issuerRef", "group")
+		c.NotBefore, _, _ = unstructured.NestedString(cert.Object, "status", "notBefore")
+		c.NotAfter, _, _ = unstructured.NestedString(cert.Object, "status", "notAfter")
+		c.RenewalTime, _, _ = unstructured.NestedString(cert.Object, "status", "renewalTime")
+		c.Ready, c.Reason, c.Message = readyCondition(cert)
+		summary.Certificates = append(summary.Certificates, c)
+	}
+
+	for _, gvr := range []schema.GroupVersionResource{issuersGVR, clusterIssuersGVR} {
+		for _, issuer := range objects(gvr) {
+			i := &IssuerSummary{
+				Kind:      issuer.GetKind(),
+				Namespace: issuer.GetNamespace(),
+				Name:      issuer.GetName(),
+			}
+			for _, t := range issuerTypes {
+				if _, found, _ := unstructured.NestedFieldNoCopy(issuer.Object, "spec", t); found {
+					i.Type = t
+					break
+				}
+			}
+			i.Ready, i.Reason, i.Message = readyCondition(issuer)
+			summary.Issuers = append(summary.Issuers, i)
+		}
+	}
+
+	sort.Slice(summary.Certificates, func(i, j int) bool {
+		a, b := summary.Certificates[i], summary.Certificates[j]
+		return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+	})
+	sort.Slice(summary.Issuers, func(i, j int) bool {
+		a, b := summary.Issuers[i], summary.Issuers[j]
+		return a.Kind+"/"+a.Namespace+"/"+a.Name < b.Kind+"/"+b.Namespace+"/"+b.Name
+	})
+
+	return summary
+}
+
+// readyCondition returns the status, reason and message of the Ready
+// condition of a cert-manager resource.
+func readyCondition(obj *unstructured.Unstructured) (bool, string, string) {
+	condition := findCondition(obj, "Ready")
+	if condition == nil {
+		return false, "", ""
+	}
+	status, _ := condition["status"].(string)
+	reason, _ := condition["reason"].(string)
+	message, _ := condition["message"].(string)
+	return status == "True", reason, message
+}
+
+func findCondition(obj *unstructured.Unstructured, conditionType string) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == conditionType {
+			return condition
+		}
+	}
+	return nil
+}
+
+// requestFailure describes why a CertificateRequest failed, or returns an
+// empty string if it did not.
+func requestFailure(req *unstructured.Unstructured) string {
+	if denied := findCondition(req, "Denied"); denied != nil && denied["status"] == "True" {
+		message, _ := denied["message"].(string)
+		return fmt.Sprintf("CertificateRequest %s was denied: %s", req.GetName(), message)
+	}
+	ready, reason, message := readyCondition(req)
+	if !ready && reason == "Failed" {
+		return fmt.Sprintf("CertificateRequest %s failed: %s", req.GetName(), message)
+	}
+	return ""
+}
+
+// acmeFailure describes why an Order or Challenge failed, or returns an empty
+// string if it did not.
+func acmeFailure(obj *unstructured.Unstructured) string {
+	state, _, _ := unstructured.NestedString(obj.Object, "status", "state")
+	if state != "invalid" && state != "errored" {
+		return ""
+	}
+	reason, _, _ := unstructured.NestedString(obj.Object, "status", "reason")
+	return fmt.Sprintf("%s %s is %s: %s", obj.GetKind(), obj.GetName(), state, reason)
+}
+
+// failuresByOwner groups the failures of the objects by the UID of their
+// controller.
+func failuresByOwner(objects []*unstructured.Unstructured, failure func(*unstructured.Unstructured) string) map[string][]string {
+	failures := map[string][]string{}
+	for _, obj := range objects {
+		reason := failure(obj)
+		if reason == "" {
+			continue
+		}
+		if owner := controllerUID(obj); owner != "" {
+			failures[owner] = append(failures[owner], reason)
+		}
+	}
+	return failures
+}
+
+// mergeFailures propagates the failures of the children of the objects to
+// the owners of the objects.
+func mergeFailures(ownerFailures, childFailures map[string][]string, objects []*unstructured.Unstructured) {
+	for _, obj := range objects {
+		reasons := childFailures[string(obj.GetUID())]
+		if len(reasons) == 0 {
+			continue
+		}
+		if owner := controllerUID(obj); owner != "" {
+			ownerFailures[owner] = append(ownerFailures[owner], reasons...)
+		}
+	}
+}
+
+func controllerUID(obj *unstructured.Unstructured) string {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.Controller != nil && *ref.Controller {
+			return string(ref.UID)
+		}
+	}
+	return ""
+}
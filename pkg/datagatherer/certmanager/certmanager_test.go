@@ -0,0 +1,116 @@
+package certmanager
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func getResource(apiVersion, kind, name, uid, owner string, spec, status map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"spec":       spec,
+			"status":     status,
+		},
+	}
+	obj.SetName(name)
+	obj.SetNamespace("default")
+	obj.SetUID(types.UID(uid))
+	if owner != "" {
+		controller := true
+		obj.SetOwnerReferences([]metav1.OwnerReference{{UID: types.UID(owner), Controller: &controller}})
+	}
+	return obj
+}
+
+func condition(conditionType, status, reason, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{
+				"type":    conditionType,
+				"status":  status,
+				"reason":  reason,
+				"message": message,
+			},
+		},
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	certStatus := condition("Ready", "False", "Issuing", "Issuing certificate as Secret does not exist")
+	certStatus["notAfter"] = "2021-06-01T00:00:00Z"
+
+	clusterIssuer := getResource("cert-manager.io/v1", "ClusterIssuer", "letsencrypt", "issuer-1", "",
+		map[string]interface{}{"acme": map[string]interface{}{}},
+		condition("Ready", "True", "ACMEAccountRegistered", "The ACME account was registered"))
+	clusterIssuer.SetNamespace("")
+
+	resources := map[schema.GroupVersionResource][]*unstructured.Unstructured{
+		certificatesGVR: {
+			getResource("cert-manager.io/v1", "Certificate", "web", "cert-1", "", map[string]interface{}{
+				"secretName": "web-tls",
+				"dnsNames":   []interface{}{"example.com"},
+				"issuerRef": map[string]interface{}{
+					"name": "letsencrypt",
+					"kind": "ClusterIssuer",
+				},
+			}, certStatus),
+		},
+		certificateRequestsGVR: {
+			getResource("cert-manager.io/v1", "CertificateRequest", "web-1", "cr-1", "cert-1", nil,
+				condition("Ready", "False", "Pending", "Waiting on certificate issuance")),
+		},
+		ordersGVR: {
+			getResource("acme.cert-manager.io/v1", "Order", "web-1-123", "order-1", "cr-1", nil,
+				map[string]interface{}{"state": "pending"}),
+		},
+		challengesGVR: {
+			getResource("acme.cert-manager.io/v1", "Challenge", "web-1-123-0", "challenge-1", "order-1", nil,
+				map[string]interface{}{"state": "invalid", "reason": "DNS problem: NXDOMAIN"}),
+		},
+		clusterIssuersGVR: {clusterIssuer},
+	}
+
+	summary := summarize(func(gvr schema.GroupVersionResource) []*unstructured.Unstructured {
+		return resources[gvr]
+	})
+
+	expected := &Summary{
+		Certificates: []*CertificateSummary{
+			{
+				Namespace:  "default",
+				Name:       "web",
+				SecretName: "web-tls",
+				DNSNames:   []string{"example.com"},
+				IssuerRef: IssuerReference{
+					Name: "letsencrypt",
+					Kind: "ClusterIssuer",
+				},
+				Reason:         "Issuing",
+				Message:        "Issuing certificate as Secret does not exist",
+				NotAfter:       "2021-06-01T00:00:00Z",
+				FailureReasons: []string{"Challenge web-1-123-0 is invalid: DNS problem: NXDOMAIN"},
+			},
+		},
+		Issuers: []*IssuerSummary{
+			{
+				Kind:    "ClusterIssuer",
+				Name:    "letsencrypt",
+				Type:    "acme",
+				Ready:   true,
+				Reason:  "ACMEAccountRegistered",
+				Message: "The ACME account was registered",
+			},
+		},
+	}
+
+	if diff, equal := messagediff.PrettyDiff(expected, summary); !equal {
+		t.Errorf("unexpected summary:\n%s", diff)
+	}
+}
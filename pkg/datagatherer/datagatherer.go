@@ -1,7 +1,11 @@
 // Package datagatherer provides the DataGatherer interface.
 package datagatherer
 
-import "context"
+import (
+	"context"
+
+	"github.com/jetstack/preflight/api"
+)
 
 // Config is the configuration of a DataGatherer.
 type Config interface {
@@ -20,4 +24,69 @@ type DataGatherer interface {
 	WaitForCacheSync(stopCh <-chan struct{}) error
 	// Delete, clear the cache of the DataGatherer if one is being used
 	Delete() error
+	// Healthy returns an error describing why the data may be stale, e.g.
+	// because a watch keeps failing, or nil if the data gatherer is healthy.
+	// The data gatherers fetching their data on every Fetch are healthy, their
+	// failures are returned by Fetch.
+	//
+	// The status of a data gatherer, its last successful fetch, the number of
+	// items it returned and its error counts, is not part of the interface:
+	// the agent records it from the outcome of every Fetch, in the same way
+	// for all the data gatherers, and reports it as api.DataGathererHealth.
+	// Healthy only reports what the agent cannot observe from Fetch.
+	Healthy() error
+}
+
+// StreamingDataGatherer is implemented by data gatherers able to return their
+// resources one at a time, allowing them to be uploaded in bounded-memory
+// chunks.
+type StreamingDataGatherer interface {
+	DataGatherer
+	// Streamable reports whether the resources can be streamed, which may
+	// depend on the configuration of the data gatherer.
+	Streamable() bool
+	// FetchStream calls fn for every resource that Fetch would return, it
+	// stops at the first error returned by fn. It returns the rest of the
+	// data Fetch would return, without the resources.
+	FetchStream(fn func(*api.GatheredResource) error) (map[string]interface{}, error)
+}
+
+// KubeconfigContextProvider is implemented by data gatherers reading from a
+// Kubernetes cluster to report the kubeconfig context they use.
+type KubeconfigContextProvider interface {
+	// KubeconfigContext returns the name of the context, or an empty string
+	// when the in-cluster configuration is used.
+	KubeconfigContext() string
+}
+
+// IssueReporter is implemented by data gatherers able to detail the errors
+// and warnings affecting the data of their last Fetch.
+type IssueReporter interface {
+	// Issues returns the issues of the data gatherer, or nil if it is
+	// healthy.
+	Issues() []*api.DataReadingIssue
+}
+
+// CacheSizeReporter is implemented by data gatherers caching resources in
+// memory, so that the agent can account for the memory they use.
+type CacheSizeReporter interface {
+	// CacheBytes returns the approximate size of the resources in the cache
+	// as of the last Fetch.
+	CacheBytes() int64
+}
+
+// ContextFetcher is implemented by data gatherers calling remote APIs on
+// Fetch, so that a hung call can be cancelled rather than blocking the agent.
+type ContextFetcher interface {
+	// FetchContext retrieves data as Fetch does, returning once ctx is done.
+	FetchContext(ctx context.Context) (interface{}, error)
+}
+
+// DeltaCommitter is implemented by data gatherers returning only the changes
+// since the changes last delivered. The changes returned by a Fetch are
+// returned again by the following ones until the agent commits them.
+type DeltaCommitter interface {
+	// Commit marks the changes returned by the last Fetch as delivered, once
+	// its data was uploaded, queued for upload or written out.
+	Commit()
 }
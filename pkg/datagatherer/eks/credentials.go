@@ -0,0 +1,109 @@
+package eks
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/jetstack/preflight/pkg/transport"
+)
+
+// AWSConfig configures how data gatherers authenticate against the AWS APIs.
+// It is shared by the data gatherers of AWS services.
+type AWSConfig struct {
+	// Region is the AWS region of the API. If empty, the region is read
+	// from the environment, as AWS_REGION set by IRSA.
+	Region string `yaml:"region"`
+	// RoleARN is the ARN of the IAM role to assume to access the API.
+	RoleARN string `yaml:"role-arn"`
+	// ExternalID is the external ID required to assume the role, if any.
+	ExternalID string `yaml:"external-id"`
+	// WebIdentityTokenFile is the path of the web identity token used to
+	// assume the role. It defaults to the projected service account token
+	// of IRSA, found with AWS_WEB_IDENTITY_TOKEN_FILE.
+	WebIdentityTokenFile string `yaml:"web-identity-token-file"`
+	// RoleSessionName is the name of the session of the assumed role.
+	RoleSessionName string `yaml:"role-session-name"`
+}
+
+// defaultRoleSessionName is the name of the session of the assumed role,
+// unless one is configured.
+const defaultRoleSessionName = "preflight"
+
+// credentialsExpiryWindow is how long before their expiry the credentials of
+// an assumed role are refreshed.
+const credentialsExpiryWindow = time.Minute
+
+// Validate validates the configuration.
+func (c *AWSConfig) Validate() error {
+	if c.RoleARN == "" {
+		if c.ExternalID != "" {
+			return fmt.Errorf("invalid configuration: external-id requires role-arn")
+		}
+		if c.WebIdentityTokenFile != "" {
+			return fmt.Errorf("invalid configuration: web-identity-token-file requires role-arn")
+		}
+	}
+	if c.ExternalID != "" && c.WebIdentityTokenFile != "" {
+		return fmt.Errorf("invalid configuration: external-id cannot be used with web-identity-token-file")
+	}
+	return nil
+}
+
+// NewSession returns an AWS session for the region, using the credentials of
+// the role to assume if one is configured, or the default credentials chain
+// otherwise.
+func (c *AWSConfig) NewSession() (*session.Session, error) {
+	awsConfig := aws.NewConfig().WithHTTPClient(transport.Client(0))
+	if c.Region != "" {
+		awsConfig = awsConfig.WithRegion(c.Region)
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %v", err)
+	}
+
+	if creds := c.credentials(sess); creds != nil {
+		sess = sess.Copy(aws.NewConfig().WithCredentials(creds))
+	}
+	return sess, nil
+}
+
+// credentials returns the credentials of the role to assume, or nil if no
+// role is configured and the default credentials of the session are used.
+// Without an external ID, the role is assumed with the web identity token of
+// the pod (IRSA). With an external ID, the role is assumed with the default
+// credentials of the session, which can themselves come from IRSA. In both
+// cases the credentials are refreshed before they expire.
+func (c *AWSConfig) credentials(sess *session.Session) *credentials.Credentials {
+	if c.RoleARN == "" {
+		return nil
+	}
+	sessionName := c.RoleSessionName
+	if sessionName == "" {
+		sessionName = defaultRoleSessionName
+	}
+
+	tokenFile := c.WebIdentityTokenFile
+	if tokenFile == "" {
+		tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if c.ExternalID == "" && tokenFile != "" {
+		provider := stscreds.NewWebIdentityRoleProvider(sts.New(sess), c.RoleARN, sessionName, tokenFile)
+		provider.ExpiryWindow = credentialsExpiryWindow
+		return credentials.NewCredentials(provider)
+	}
+
+	return stscreds.NewCredentials(sess, c.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = sessionName
+		p.ExpiryWindow = credentialsExpiryWindow
+		if c.ExternalID != "" {
+			p.ExternalID = aws.String(c.ExternalID)
+		}
+	})
+}
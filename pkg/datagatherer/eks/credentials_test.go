@@ -0,0 +1,67 @@
+package eks
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		config  AWSConfig
+		wantErr bool
+	}{
+		"default credentials": {
+			config: AWSConfig{},
+		},
+		"role with external id": {
+			config: AWSConfig{RoleARN: "arn:aws:iam::111122223333:role/preflight", ExternalID: "id"},
+		},
+		"external id without role": {
+			config:  AWSConfig{ExternalID: "id"},
+			wantErr: true,
+		},
+		"token file without role": {
+			config:  AWSConfig{WebIdentityTokenFile: "/token"},
+			wantErr: true,
+		},
+		"external id with token file": {
+			config:  AWSConfig{RoleARN: "arn:aws:iam::111122223333:role/preflight", ExternalID: "id", WebIdentityTokenFile: "/token"},
+			wantErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.config.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	sess, err := session.NewSession(aws.NewConfig().WithRegion("eu-west-1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c := &AWSConfig{}
+	if creds := c.credentials(sess); creds != nil {
+		t.Errorf("expected the default credentials without a role")
+	}
+
+	c.RoleARN = "arn:aws:iam::111122223333:role/preflight"
+	c.WebIdentityTokenFile = "/var/run/secrets/eks.amazonaws.com/serviceaccount/token"
+	if creds := c.credentials(sess); creds == nil {
+		t.Errorf("expected credentials for the web identity role")
+	}
+
+	c.WebIdentityTokenFile = ""
+	c.ExternalID = "id"
+	if creds := c.credentials(sess); creds == nil {
+		t.Errorf("expected credentials for the assumed role")
+	}
+}
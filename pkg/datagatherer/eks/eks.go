@@ -6,7 +6,6 @@ import (
 	"fmt"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/eks"
 	"github.com/jetstack/preflight/pkg/datagatherer"
 )
@@ -15,6 +14,8 @@ import (
 type Config struct {
 	// ClusterName is the ID of the cluster in EKS.
 	ClusterName string `yaml:"cluster-name"`
+	// AWSConfig are the credentials to access the EKS API.
+	AWSConfig `yaml:",inline"`
 }
 
 // validate validates the configuration.
@@ -22,7 +23,7 @@ func (c *Config) validate() error {
 	if c.ClusterName == "" {
 		return fmt.Errorf("invalid configuration: ClusterName cannot be empty")
 	}
-	return nil
+	return c.AWSConfig.Validate()
 }
 
 // NewDataGatherer creates a new EKS DataGatherer. It performs a config validation.
@@ -31,8 +32,13 @@ func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer
 		return nil, err
 	}
 
+	sess, err := c.AWSConfig.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
 	return &DataGatherer{
-		client:      eks.New(session.New()),
+		client:      eks.New(sess),
 		clustername: c.ClusterName,
 	}, nil
 }
@@ -59,6 +65,11 @@ func (g *DataGatherer) Delete() error {
 	return nil
 }
 
+func (g *DataGatherer) Healthy() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
 // WaitForCacheSync waits for the data gatherer's informers cache to sync.
 func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
 	// no async functionality, see Fetch
@@ -67,11 +78,17 @@ func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
 
 // Fetch retrieves cluster information from EKS.
 func (g *DataGatherer) Fetch() (interface{}, error) {
+	return g.FetchContext(context.Background())
+}
+
+// FetchContext retrieves cluster information from EKS, cancelling the
+// request once ctx is done.
+func (g *DataGatherer) FetchContext(ctx context.Context) (interface{}, error) {
 	input := &eks.DescribeClusterInput{
 		Name: aws.String(g.clustername),
 	}
 
-	result, err := g.client.DescribeCluster(input)
+	result, err := g.client.DescribeClusterWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,240 @@
+// Package exec provides a datagatherer running a command and gathering its
+// JSON output.
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// defaultTimeout is how long the command can run, unless a timeout is
+// configured.
+const defaultTimeout = time.Minute
+
+// defaultMaxOutputSize is the maximum size of the output of the command,
+// unless one is configured.
+const defaultMaxOutputSize = 10 << 20
+
+// maxStderrSize is how much of the standard error of a failed command is
+// included in the error.
+const maxStderrSize = 4 << 10
+
+// Config is the configuration for an exec DataGatherer.
+type Config struct {
+	// Command is the absolute path of the binary to run. It is not run
+	// through a shell.
+	Command string `yaml:"command"`
+	// Args are the arguments of the command.
+	Args []string `yaml:"args"`
+	// Env are the environment variables of the command. The command does
+	// not inherit the environment of the agent, except PATH.
+	Env map[string]string `yaml:"env"`
+	// Dir is the working directory of the command.
+	Dir string `yaml:"dir"`
+	// Timeout is how long the command can run before it is killed.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxOutputSize is the maximum size in bytes of the standard output of
+	// the command. The command is killed if its output is larger.
+	MaxOutputSize int64 `yaml:"max-output-size"`
+}
+
+// validate validates the configuration.
+func (c *Config) validate() error {
+	if c.Command == "" {
+		return fmt.Errorf("invalid configuration: Command cannot be empty")
+	}
+	if !filepath.IsAbs(c.Command) {
+		return fmt.Errorf("invalid configuration: Command must be an absolute path, got %q", c.Command)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid configuration: Timeout cannot be negative")
+	}
+	if c.MaxOutputSize < 0 {
+		return fmt.Errorf("invalid configuration: MaxOutputSize cannot be negative")
+	}
+	for name := range c.Env {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("invalid configuration: invalid environment variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// NewDataGatherer creates a new exec DataGatherer. It performs a config
+// validation.
+func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	maxOutputSize := c.MaxOutputSize
+	if maxOutputSize == 0 {
+		maxOutputSize = defaultMaxOutputSize
+	}
+
+	// the command only gets the configured environment, so that the
+	// credentials of the agent are not leaked to it
+	env := []string{"PATH=" + os.Getenv("PATH")}
+	for name, value := range c.Env {
+		env = append(env, name+"="+value)
+	}
+
+	return &DataGatherer{
+		ctx:           ctx,
+		command:       c.Command,
+		args:          c.Args,
+		env:           env,
+		dir:           c.Dir,
+		timeout:       timeout,
+		maxOutputSize: maxOutputSize,
+	}, nil
+}
+
+// DataGatherer is a data-gatherer running a command.
+type DataGatherer struct {
+	ctx           context.Context
+	command       string
+	args          []string
+	env           []string
+	dir           string
+	timeout       time.Duration
+	maxOutputSize int64
+}
+
+func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Healthy() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch runs the command and returns its parsed JSON output.
+func (g *DataGatherer) Fetch() (interface{}, error) {
+	return g.FetchContext(g.ctx)
+}
+
+// FetchContext runs the command as Fetch does, killing it once ctx is done.
+func (g *DataGatherer) FetchContext(ctx context.Context) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: g.maxOutputSize, cancel: cancel}
+	stderr := &limitedBuffer{limit: maxStderrSize}
+
+	cmd := exec.Command(g.command, g.args...)
+	cmd.Env = g.env
+	cmd.Dir = g.dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run %s: %v", g.command, err)
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// the processes started by the command are killed too, as they
+			// would keep its output open and Wait from returning
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+
+	if stdout.Exceeded() {
+		return nil, fmt.Errorf("output of %s is larger than %d bytes", g.command, g.maxOutputSize)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s did not complete within %s", g.command, g.timeout)
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%s was killed: %v", g.command, ctx.Err())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s: %v: %s", g.command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
+		return nil, fmt.Errorf("failed to parse output of %s as JSON: %v", g.command, err)
+	}
+	return data, nil
+}
+
+// limitedBuffer is a buffer keeping at most limit bytes. When the limit is
+// exceeded, it calls cancel if set to kill the command. It is written by the
+// goroutines copying the output of the command.
+type limitedBuffer struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	limit    int64
+	cancel   func()
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	remaining := b.limit - int64(b.buf.Len())
+	if int64(len(p)) > remaining {
+		b.exceeded = true
+		if remaining > 0 {
+			b.buf.Write(p[:remaining])
+		}
+		if b.cancel != nil {
+			b.cancel()
+			return 0, fmt.Errorf("output limit exceeded")
+		}
+		// the input is discarded rather than blocking the command
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Exceeded returns true if more than limit bytes were written.
+func (b *limitedBuffer) Exceeded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exceeded
+}
+
+// Bytes returns a copy of the bytes kept.
+func (b *limitedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func (b *limitedBuffer) String() string {
+	return string(b.Bytes())
+}
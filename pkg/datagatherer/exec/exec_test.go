@@ -0,0 +1,113 @@
+package exec
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		config  Config
+		wantErr bool
+	}{
+		"command":           {config: Config{Command: "/usr/local/bin/inventory", Args: []string{"--json"}}},
+		"empty":             {config: Config{}, wantErr: true},
+		"relative command":  {config: Config{Command: "inventory"}, wantErr: true},
+		"negative timeout":  {config: Config{Command: "/bin/true", Timeout: -1}, wantErr: true},
+		"negative max size": {config: Config{Command: "/bin/true", MaxOutputSize: -1}, wantErr: true},
+		"invalid env":       {config: Config{Command: "/bin/true", Env: map[string]string{"A=B": "c"}}, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.config.validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tests := map[string]struct {
+		config      Config
+		expected    interface{}
+		expectedErr string
+	}{
+		"json output": {
+			config:   Config{Command: sh, Args: []string{"-c", `echo "{\"host\": \"$HOST\", \"home\": \"$HOME\"}"`}, Env: map[string]string{"HOST": "node-1"}},
+			expected: map[string]interface{}{"host": "node-1", "home": ""},
+		},
+		"invalid json": {
+			config:      Config{Command: sh, Args: []string{"-c", "echo not json"}},
+			expectedErr: "failed to parse output",
+		},
+		"failure": {
+			config:      Config{Command: sh, Args: []string{"-c", "echo broken >&2; exit 3"}},
+			expectedErr: "exit status 3: broken",
+		},
+		"timeout": {
+			config:      Config{Command: sh, Args: []string{"-c", "exec sleep 5"}, Timeout: 100 * time.Millisecond},
+			expectedErr: "did not complete within",
+		},
+		"output too large": {
+			config:      Config{Command: sh, Args: []string{"-c", "echo '[1, 2, 3, 4, 5]'"}, MaxOutputSize: 4},
+			expectedErr: "larger than 4 bytes",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dg, err := test.config.NewDataGatherer(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data, err := dg.Fetch()
+			if test.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff, equal := messagediff.PrettyDiff(test.expected, data); !equal {
+				t.Errorf("unexpected data:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFetchKillsProcessGroup(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+
+	// the background sleep keeps the output of the command open
+	config := Config{Command: sh, Args: []string{"-c", "sleep 5 & sleep 5"}, Timeout: 100 * time.Millisecond}
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	_, err = dg.Fetch()
+	if err == nil || !strings.Contains(err.Error(), "did not complete within") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the processes started by the command to be killed on timeout, took %s", elapsed)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup runs the command in a process group of its own, so that
+// the processes it starts can be killed with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the command and the processes it started.
+func killProcessGroup(cmd *exec.Cmd) {
+	// the process group of the command has the ID of the command
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,14 @@
+package exec
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup does nothing, the processes started by the command are not
+// tracked on Windows.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command only, on Windows.
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
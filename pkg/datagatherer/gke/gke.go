@@ -4,10 +4,12 @@ package gke
 import (
 	"context"
 	"fmt"
-	"log"
+	"sort"
 	"strings"
 
 	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/transport"
 	"golang.org/x/oauth2/google"
 	container "google.golang.org/api/container/v1"
 	"google.golang.org/api/option"
@@ -19,8 +21,16 @@ type Config struct {
 	Cluster *Cluster `yaml:"cluster"`
 	// CredentialsPath is the path to the JSON file containing the credentials to authenticate against the GKE API.
 	CredentialsPath string `yaml:"credentials-path"`
+	// WorkloadIdentity makes the data gatherer authenticate with the token of
+	// the GKE metadata server, given to the pod by Workload Identity, rather
+	// than looking up the default credentials.
+	WorkloadIdentity bool `yaml:"workload-identity"`
 }
 
+// allLocations is the location listing the clusters of every location of a
+// project.
+const allLocations = "-"
+
 // validate validates the configuration.
 func (c *Config) validate() error {
 	errs := []string{}
@@ -29,9 +39,12 @@ func (c *Config) validate() error {
 	if c.Cluster.Project == "" {
 		errs = append(errs, fmt.Sprintf(emptyMsg, "Cluster.Project"))
 	}
-	if c.Cluster.Name == "" {
+	if c.Cluster.Name == "" && c.Cluster.Location != allLocations {
 		errs = append(errs, fmt.Sprintf(emptyMsg, "Cluster.Name"))
 	}
+	if c.WorkloadIdentity && c.CredentialsPath != "" {
+		errs = append(errs, "WorkloadIdentity and CredentialsPath cannot be used at the same time")
+	}
 	if c.Cluster.Zone != "" {
 		if c.Cluster.Location != "" {
 			errs = append(errs, "Cluster.Location and Cluster.Zone cannot be used at the same time, use only Location")
@@ -54,22 +67,37 @@ type Cluster struct {
 	Project string `yaml:"project"`
 	// Deprecated: Zone of the cluster. Use Location instead.
 	Zone string `yaml:"zone"`
-	// Name is the identifier of the cluster.
+	// Name is the identifier of the cluster. When listing all the locations,
+	// it is optional and limits the clusters to the ones with that name.
 	Name string `yaml:"name"`
-	// Location is the location of the cluster.
+	// Location is the location of the cluster. Use "-" to list the clusters
+	// of all the locations of the project.
 	Location string `yaml:"location"`
 }
 
 // DataGatherer is a DataGatherer for GKE.
 type DataGatherer struct {
-	ctx             context.Context
-	cluster         *Cluster
-	credentialsPath string
+	ctx              context.Context
+	cluster          *Cluster
+	credentialsPath  string
+	workloadIdentity bool
 }
 
 // Info contains the data retrieved from GKE.
 type Info struct {
-	Cluster *container.Cluster
+	// Cluster is the cluster, when a single location is configured.
+	Cluster *container.Cluster `json:",omitempty"`
+	// Clusters are the clusters found in all the locations of the project.
+	Clusters []*LocatedCluster `json:",omitempty"`
+	// UnreachableLocations are the locations the clusters could not be
+	// listed from.
+	UnreachableLocations []string `json:",omitempty"`
+}
+
+// LocatedCluster is a cluster tagged with its location.
+type LocatedCluster struct {
+	Location string
+	Cluster  *container.Cluster
 }
 
 // NewDataGatherer creates a new DataGatherer for a cluster.
@@ -79,9 +107,10 @@ func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer
 	}
 
 	return &DataGatherer{
-		ctx:             ctx,
-		cluster:         c.Cluster,
-		credentialsPath: c.CredentialsPath,
+		ctx:              ctx,
+		cluster:          c.Cluster,
+		credentialsPath:  c.CredentialsPath,
+		workloadIdentity: c.WorkloadIdentity,
 	}, nil
 }
 
@@ -95,6 +124,11 @@ func (g *DataGatherer) Delete() error {
 	return nil
 }
 
+func (g *DataGatherer) Healthy() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
 func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
 	// no async functionality, see Fetch
 	return nil
@@ -102,11 +136,22 @@ func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
 
 // Fetch retrieves cluster information from GKE.
 func (g *DataGatherer) Fetch() (interface{}, error) {
+	return g.FetchContext(g.ctx)
+}
+
+// FetchContext retrieves cluster information from GKE, cancelling the
+// requests once ctx is done.
+func (g *DataGatherer) FetchContext(ctx context.Context) (interface{}, error) {
 	var credsOpt option.ClientOption
-	if len(g.credentialsPath) == 0 {
-		log.Println("Credentials path for GKE was not provided. Attempting to use GCP Workload Identity.")
+	if g.workloadIdentity {
+		// the token of the default service account of the GKE metadata
+		// server is the one of the Google service account bound to the
+		// pod's Kubernetes service account
+		credsOpt = option.WithTokenSource(google.ComputeTokenSource("", container.CloudPlatformScope))
+	} else if len(g.credentialsPath) == 0 {
+		logs.Log.Info("Credentials path for GKE was not provided. Attempting to use GCP Workload Identity.")
 		// Connect to the Google Cloud Platform API using Workload Identity
-		creds, err := google.FindDefaultCredentials(g.ctx)
+		creds, err := google.FindDefaultCredentials(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to obtain credentials for Google Cloud Platform: try to run 'gcloud auth application-default login' to login to your account")
 		}
@@ -116,19 +161,29 @@ func (g *DataGatherer) Fetch() (interface{}, error) {
 		credsOpt = option.WithCredentialsFile(g.credentialsPath)
 	}
 
-	containerService, err := container.NewService(g.ctx, credsOpt)
+	// the options of the service are ignored with an HTTP client, the
+	// client is authenticated with the default scope of the service
+	clientOpt, err := transport.GoogleClientOption(ctx, credsOpt, option.WithScopes(container.CloudPlatformScope))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Google Cloud Platform container API: %v", err)
 	}
+	containerService, err := container.NewService(ctx, clientOpt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Google Cloud Platform container API: %v", err)
+	}
+
+	if g.cluster.Location == allLocations {
+		return g.listClusters(ctx, containerService)
+	}
 
 	var cluster *container.Cluster
 	if len(g.cluster.Location) > 0 {
-		cluster, err = containerService.Projects.Locations.Clusters.Get(fmt.Sprintf("projects/%s/locations/%s/clusters/%s", g.cluster.Project, g.cluster.Location, g.cluster.Name)).Do()
+		cluster, err = containerService.Projects.Locations.Clusters.Get(fmt.Sprintf("projects/%s/locations/%s/clusters/%s", g.cluster.Project, g.cluster.Location, g.cluster.Name)).Context(ctx).Do()
 		if err != nil {
 			return nil, fmt.Errorf("failed to get GKE cluster information (project: %s, location: %s, cluster: %s): %v", g.cluster.Project, g.cluster.Location, g.cluster.Name, err)
 		}
 	} else {
-		cluster, err = containerService.Projects.Zones.Clusters.Get(g.cluster.Project, g.cluster.Zone, g.cluster.Name).Do()
+		cluster, err = containerService.Projects.Zones.Clusters.Get(g.cluster.Project, g.cluster.Zone, g.cluster.Name).Context(ctx).Do()
 		if err != nil {
 			return nil, fmt.Errorf("failed to get GKE cluster information (project: %s, zone: %s, cluster: %s): %v", g.cluster.Project, g.cluster.Zone, g.cluster.Name, err)
 		}
@@ -138,3 +193,38 @@ func (g *DataGatherer) Fetch() (interface{}, error) {
 		Cluster: cluster,
 	}, nil
 }
+
+// listClusters lists the clusters of all the locations of the project,
+// keeping only the ones with the configured name if any.
+func (g *DataGatherer) listClusters(ctx context.Context, containerService *container.Service) (*Info, error) {
+	response, err := containerService.Projects.Locations.Clusters.List(fmt.Sprintf("projects/%s/locations/%s", g.cluster.Project, allLocations)).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list GKE clusters (project: %s): %v", g.cluster.Project, err)
+	}
+
+	clusters := []*LocatedCluster{}
+	for _, cluster := range response.Clusters {
+		if g.cluster.Name != "" && cluster.Name != g.cluster.Name {
+			continue
+		}
+		clusters = append(clusters, &LocatedCluster{
+			Location: cluster.Location,
+			Cluster:  cluster,
+		})
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Location != clusters[j].Location {
+			return clusters[i].Location < clusters[j].Location
+		}
+		return clusters[i].Cluster.Name < clusters[j].Cluster.Name
+	})
+
+	if len(response.MissingZones) > 0 {
+		logs.Log.Errorf("failed to list GKE clusters in some locations (project: %s): %s", g.cluster.Project, strings.Join(response.MissingZones, ", "))
+	}
+
+	return &Info{
+		Clusters:             clusters,
+		UnreachableLocations: response.MissingZones,
+	}, nil
+}
@@ -0,0 +1,37 @@
+package gke
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		config  Config
+		wantErr bool
+	}{
+		"single cluster": {
+			config: Config{Cluster: &Cluster{Project: "project", Location: "us-central1", Name: "cluster"}},
+		},
+		"missing name": {
+			config:  Config{Cluster: &Cluster{Project: "project", Location: "us-central1"}},
+			wantErr: true,
+		},
+		"all locations without name": {
+			config: Config{Cluster: &Cluster{Project: "project", Location: "-"}},
+		},
+		"workload identity": {
+			config: Config{Cluster: &Cluster{Project: "project", Location: "-"}, WorkloadIdentity: true},
+		},
+		"workload identity with credentials": {
+			config:  Config{Cluster: &Cluster{Project: "project", Location: "-"}, WorkloadIdentity: true, CredentialsPath: "/creds.json"},
+			wantErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.config.validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,460 @@
+// Package hostcerts provides a data gatherer scanning the filesystem of a
+// node for certificates and keys, such as the ones of the control plane and
+// of the kubelet, to be run by an agent deployed as a DaemonSet.
+package hostcerts
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jetstack/preflight/pkg/certinfo"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultPaths are the paths scanned by default, where kubeadm and the
+// kubelet store their certificates and kubeconfigs.
+var defaultPaths = []string{
+	"/etc/kubernetes",
+	"/var/lib/kubelet/pki",
+}
+
+// defaultMaxFileSize is the size of the largest file read by default.
+const defaultMaxFileSize = 1 << 20
+
+// Config is the configuration of a host-certs DataGatherer.
+type Config struct {
+	// Paths are the directories, files or glob patterns scanned on the
+	// host. Directories are scanned recursively. Defaults to /etc/kubernetes
+	// and /var/lib/kubelet/pki.
+	Paths []string `yaml:"paths"`
+	// HostRoot is the directory the filesystem of the host is mounted at in
+	// the container of the agent, e.g. /host. The paths are relative to it,
+	// and are reported as on the host.
+	HostRoot string `yaml:"host-root"`
+	// NodeName is the name of the node reported with the files. Defaults to
+	// NODE_NAME, as set with the downward API.
+	NodeName string `yaml:"node-name"`
+	// MaxFileSize is the size in bytes of the largest file read, the larger
+	// files are skipped. Defaults to 1MiB.
+	MaxFileSize int64 `yaml:"max-file-size"`
+}
+
+// validate validates the configuration.
+func (c *Config) validate() error {
+	for _, path := range c.Paths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("invalid configuration: path %q must be absolute", path)
+		}
+		if _, err := filepath.Match(path, ""); err != nil {
+			return fmt.Errorf("invalid configuration: invalid path %q: %v", path, err)
+		}
+	}
+	if c.MaxFileSize < 0 {
+		return fmt.Errorf("invalid configuration: MaxFileSize cannot be negative")
+	}
+	return nil
+}
+
+// NewDataGatherer returns a new DataGatherer.
+func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	nodeName := c.NodeName
+	if nodeName == "" {
+		nodeName = os.Getenv("NODE_NAME")
+	}
+	if nodeName == "" {
+		return nil, fmt.Errorf("invalid configuration: NodeName must be set when NODE_NAME is not, e.g. to ${nodeName}")
+	}
+	paths := c.Paths
+	if len(paths) == 0 {
+		paths = defaultPaths
+	}
+	maxFileSize := c.MaxFileSize
+	if maxFileSize == 0 {
+		maxFileSize = defaultMaxFileSize
+	}
+
+	return &DataGatherer{
+		paths:       paths,
+		hostRoot:    c.HostRoot,
+		nodeName:    nodeName,
+		maxFileSize: maxFileSize,
+	}, nil
+}
+
+// DataGatherer scans the paths of the host for certificates and keys, and
+// reports their metadata. The key material is never reported.
+type DataGatherer struct {
+	paths       []string
+	hostRoot    string
+	nodeName    string
+	maxFileSize int64
+}
+
+// File is a file of the host with certificates or keys.
+type File struct {
+	// Path is the path of the file on the host.
+	Path string `json:"path"`
+	// Target is the path the file links to, if it is a symlink, such as
+	// kubelet-client-current.pem.
+	Target string `json:"target,omitempty"`
+	// Mode is the permissions of the file, e.g. -rw-------.
+	Mode         string         `json:"mode"`
+	Certificates []*Certificate `json:"certificates,omitempty"`
+	Keys         []*Key         `json:"keys,omitempty"`
+	// Error is set if the file could not be read or parsed.
+	Error string `json:"error,omitempty"`
+}
+
+// Certificate is a certificate of a file.
+type Certificate struct {
+	*certinfo.Certificate
+	// Source is where the certificate is in a kubeconfig file, e.g.
+	// users/kubernetes-admin/client-certificate-data.
+	Source string `json:"source,omitempty"`
+
+	publicKeyInfo []byte
+}
+
+// Key is the metadata of a private or public key of a file.
+type Key struct {
+	// Type is the type of the PEM block, e.g. RSA PRIVATE KEY or PUBLIC KEY.
+	Type string `json:"type"`
+	// KeyAlgorithm describes the key, e.g. RSA-2048, unless it is
+	// encrypted.
+	KeyAlgorithm string `json:"keyAlgorithm,omitempty"`
+	// Source is where the key is in a kubeconfig file, e.g.
+	// users/kubernetes-admin/client-key-data.
+	Source string `json:"source,omitempty"`
+	// CertificateFingerprintSHA256 is the fingerprint of the certificate of
+	// the key, if it is one of the scanned certificates.
+	CertificateFingerprintSHA256 string `json:"certificateFingerprintSHA256,omitempty"`
+
+	publicKey crypto.PublicKey
+}
+
+func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Healthy() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch scans the paths and returns the files with certificates or keys,
+// along with the name of the node.
+func (g *DataGatherer) Fetch() (interface{}, error) {
+	files := []*File{}
+	matches := g.matchingFiles()
+	paths := make([]string, 0, len(matches))
+	for path := range matches {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if file := g.scan(path, matches[path]); file != nil {
+			files = append(files, file)
+		}
+	}
+
+	// the keys are matched with the certificates of all the files, as they
+	// are usually stored apart
+	var certs []*Certificate
+	for _, file := range files {
+		certs = append(certs, file.Certificates...)
+	}
+	for _, file := range files {
+		for _, key := range file.Keys {
+			key.CertificateFingerprintSHA256 = matchingCertificate(key.publicKey, certs)
+		}
+	}
+
+	return map[string]interface{}{
+		"node":  g.nodeName,
+		"files": files,
+	}, nil
+}
+
+// matchingFiles returns the regular files matching the paths, as paths of
+// the container of the agent, along with the files they link to if they are
+// symlinks. Directories are walked recursively.
+func (g *DataGatherer) matchingFiles() map[string]string {
+	files := map[string]string{}
+	for _, pattern := range g.paths {
+		matches, _ := filepath.Glob(filepath.Join(g.hostRoot, pattern))
+		for _, match := range matches {
+			_ = filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					// e.g. a directory which cannot be read
+					return nil
+				}
+				resolved := path
+				if info.Mode()&os.ModeSymlink != 0 {
+					resolved, info, err = g.resolve(path)
+					if err != nil {
+						return nil
+					}
+				}
+				if info.Mode().IsRegular() {
+					files[path] = resolved
+				}
+				return nil
+			})
+		}
+	}
+	return files
+}
+
+// maxSymlinks is the number of symlinks followed to resolve a path.
+const maxSymlinks = 8
+
+// resolve follows the symlink within the host root, as the absolute targets
+// of the symlinks of the host are relative to the host root.
+func (g *DataGatherer) resolve(path string) (string, os.FileInfo, error) {
+	for i := 0; i < maxSymlinks; i++ {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", nil, err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, info, nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", nil, err
+		}
+		if filepath.IsAbs(target) {
+			path = filepath.Join(g.hostRoot, target)
+		} else {
+			path = filepath.Join(filepath.Dir(path), target)
+		}
+	}
+	return "", nil, fmt.Errorf("too many symlinks")
+}
+
+// scan returns the certificates and keys of the file, or nil if it has none.
+// resolved is the file the path links to, or the path itself.
+func (g *DataGatherer) scan(path, resolved string) *File {
+	info, err := os.Stat(resolved)
+	if err != nil || info.Size() > g.maxFileSize {
+		return nil
+	}
+	file := &File{
+		Path: g.hostPath(path),
+		Mode: info.Mode().String(),
+	}
+	if resolved != path {
+		file.Target = g.hostPath(resolved)
+	}
+
+	data, err := ioutil.ReadFile(resolved)
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	switch {
+	case bytes.Contains(data, []byte("-----BEGIN ")):
+		file.Certificates, file.Keys, err = parsePEM(data, "")
+	case bytes.Contains(data, []byte("-data:")):
+		file.Certificates, file.Keys, err = parseKubeconfig(data)
+	default:
+		return nil
+	}
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	if len(file.Certificates) == 0 && len(file.Keys) == 0 {
+		return nil
+	}
+	return file
+}
+
+// hostPath returns the path of the file on the host.
+func (g *DataGatherer) hostPath(path string) string {
+	if g.hostRoot == "" {
+		return path
+	}
+	rel, err := filepath.Rel(g.hostRoot, path)
+	if err != nil {
+		return path
+	}
+	return "/" + rel
+}
+
+// parsePEM parses the certificates and keys of the PEM data. The other
+// blocks are ignored.
+func parsePEM(data []byte, source string) ([]*Certificate, []*Key, error) {
+	var certs []*Certificate
+	var keys []*Key
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch {
+		case block.Type == "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse certificate %d: %v", len(certs), err)
+			}
+			certs = append(certs, &Certificate{
+				Certificate:   certinfo.Describe(cert),
+				Source:        source,
+				publicKeyInfo: cert.RawSubjectPublicKeyInfo,
+			})
+		case strings.HasSuffix(block.Type, "PRIVATE KEY") || block.Type == "PUBLIC KEY":
+			key := &Key{Type: block.Type, Source: source}
+			key.publicKey = publicKey(block)
+			key.KeyAlgorithm = certinfo.KeyAlgorithm(key.publicKey)
+			keys = append(keys, key)
+		}
+	}
+	return certs, keys, nil
+}
+
+// publicKey returns the public key of the PEM block of a key, or nil if it
+// cannot be parsed, e.g. because it is encrypted.
+func publicKey(block *pem.Block) crypto.PublicKey {
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		return key
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		return key.Public()
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		return key.Public()
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return key.Public()
+		case *ecdsa.PrivateKey:
+			return key.Public()
+		case ed25519.PrivateKey:
+			return key.Public()
+		}
+	}
+	return nil
+}
+
+// matchingCertificate returns the SHA-256 fingerprint of the certificate of
+// the public key, or an empty string if there is none.
+func matchingCertificate(publicKey crypto.PublicKey, certs []*Certificate) string {
+	if publicKey == nil {
+		return ""
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return ""
+	}
+	for _, cert := range certs {
+		if bytes.Equal(cert.publicKeyInfo, der) {
+			return cert.FingerprintSHA256
+		}
+	}
+	return ""
+}
+
+// kubeconfig is the part of a kubeconfig file with certificates and keys.
+type kubeconfig struct {
+	Clusters []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			CertificateAuthorityData string `json:"certificate-authority-data"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			ClientCertificateData string `json:"client-certificate-data"`
+			ClientKeyData         string `json:"client-key-data"`
+		} `json:"user"`
+	} `json:"users"`
+}
+
+// parseKubeconfig parses the certificates and keys embedded in a kubeconfig
+// file, such as the ones generated by kubeadm.
+func parseKubeconfig(data []byte) ([]*Certificate, []*Key, error) {
+	var config kubeconfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		// not a kubeconfig
+		return nil, nil, nil
+	}
+
+	var certs []*Certificate
+	var keys []*Key
+	add := func(encoded, source string) error {
+		if encoded == "" {
+			return nil
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %v", source, err)
+		}
+		c, k, err := parsePEM(data, source)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %v", source, err)
+		}
+		certs = append(certs, c...)
+		keys = append(keys, k...)
+		return nil
+	}
+	for _, cluster := range config.Clusters {
+		if err := add(cluster.Cluster.CertificateAuthorityData, "clusters/"+cluster.Name+"/certificate-authority-data"); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, user := range config.Users {
+		if err := add(user.User.ClientCertificateData, "users/"+user.Name+"/client-certificate-data"); err != nil {
+			return nil, nil, err
+		}
+		if err := add(user.User.ClientKeyData, "users/"+user.Name+"/client-key-data"); err != nil {
+			return nil, nil, err
+		}
+	}
+	return certs, keys, nil
+}
@@ -0,0 +1,141 @@
+package hostcerts
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newCertificate returns a self-signed certificate and its key, PEM encoded.
+func newCertificate(t *testing.T, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create the directory of %q: %v", path, err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write %q: %v", path, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		config  Config
+		wantErr bool
+	}{
+		"defaults":              {config: Config{}},
+		"paths":                 {config: Config{Paths: []string{"/etc/kubernetes/pki", "/etc/*/ssl"}}},
+		"relative path":         {config: Config{Paths: []string{"etc/kubernetes"}}, wantErr: true},
+		"invalid pattern":       {config: Config{Paths: []string{"/etc/[a"}}, wantErr: true},
+		"negative maximum size": {config: Config{MaxFileSize: -1}, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.config.validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	root := t.TempDir()
+	apiserverCert, apiserverKey := newCertificate(t, "kube-apiserver")
+	adminCert, adminKey := newCertificate(t, "kubernetes-admin")
+	kubeletCert, kubeletKey := newCertificate(t, "system:node:node-1")
+
+	writeFile(t, filepath.Join(root, "etc/kubernetes/pki/apiserver.crt"), apiserverCert)
+	writeFile(t, filepath.Join(root, "etc/kubernetes/pki/apiserver.key"), apiserverKey)
+	writeFile(t, filepath.Join(root, "etc/kubernetes/manifests/kube-apiserver.yaml"), []byte("kind: Pod\n"))
+	writeFile(t, filepath.Join(root, "etc/kubernetes/admin.conf"), []byte(`apiVersion: v1
+kind: Config
+users:
+- name: kubernetes-admin
+  user:
+    client-certificate-data: `+base64.StdEncoding.EncodeToString(adminCert)+`
+    client-key-data: `+base64.StdEncoding.EncodeToString(adminKey)+`
+`))
+	writeFile(t, filepath.Join(root, "var/lib/kubelet/pki/kubelet-client-2021.pem"), append(kubeletCert, kubeletKey...))
+	// the symlinks of the host are absolute paths of the host
+	if err := os.Symlink("/var/lib/kubelet/pki/kubelet-client-2021.pem", filepath.Join(root, "var/lib/kubelet/pki/kubelet-client-current.pem")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dg, err := (&Config{HostRoot: root, NodeName: "node-1"}).NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if node := data.(map[string]interface{})["node"]; node != "node-1" {
+		t.Errorf("unexpected node: %v", node)
+	}
+
+	files := map[string]*File{}
+	for _, file := range data.(map[string]interface{})["files"].([]*File) {
+		files[file.Path] = file
+	}
+	if len(files) != 5 {
+		t.Fatalf("expected the 5 files with certificates or keys, got %d", len(files))
+	}
+
+	cert, key := files["/etc/kubernetes/pki/apiserver.crt"], files["/etc/kubernetes/pki/apiserver.key"]
+	if cert == nil || len(cert.Certificates) != 1 || cert.Certificates[0].Subject != "CN=kube-apiserver" {
+		t.Fatalf("unexpected certificate file: %+v", cert)
+	}
+	if key == nil || len(key.Keys) != 1 || key.Keys[0].KeyAlgorithm != "ECDSA-P-256" || key.Mode != "-rw-------" {
+		t.Fatalf("unexpected key file: %+v", key)
+	}
+	if key.Keys[0].CertificateFingerprintSHA256 != cert.Certificates[0].FingerprintSHA256 {
+		t.Errorf("expected the key to be matched with its certificate")
+	}
+
+	admin := files["/etc/kubernetes/admin.conf"]
+	if admin == nil || len(admin.Certificates) != 1 || len(admin.Keys) != 1 {
+		t.Fatalf("unexpected kubeconfig file: %+v", admin)
+	}
+	if source := admin.Certificates[0].Source; source != "users/kubernetes-admin/client-certificate-data" {
+		t.Errorf("unexpected source of the certificate: %q", source)
+	}
+
+	current := files["/var/lib/kubelet/pki/kubelet-client-current.pem"]
+	if current == nil || current.Target != "/var/lib/kubelet/pki/kubelet-client-2021.pem" || len(current.Certificates) != 1 {
+		t.Errorf("unexpected symlinked file: %+v", current)
+	}
+}
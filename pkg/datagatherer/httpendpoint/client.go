@@ -0,0 +1,172 @@
+package httpendpoint
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/transport"
+)
+
+// defaultTimeout is the timeout of the requests, unless one is configured.
+const defaultTimeout = 30 * time.Second
+
+// maxBodySize is the maximum size of the responses, larger responses fail
+// the fetch rather than exhausting the memory of the agent.
+const maxBodySize = 10 << 20
+
+// ClientConfig configures how data gatherers connect to HTTP endpoints. It is
+// shared by the data gatherers of HTTP APIs.
+type ClientConfig struct {
+	// Headers are additional headers sent with the requests.
+	Headers map[string]string `yaml:"headers"`
+	// BearerTokenPath is the path of a file containing a bearer token sent
+	// with the requests. It is read for every request, so that rotated
+	// tokens, as projected service account tokens, are picked up.
+	BearerTokenPath string `yaml:"bearer-token-path"`
+	// CAPath is the path of the PEM encoded CA certificates used to verify
+	// the endpoint, instead of the system ones.
+	CAPath string `yaml:"ca-path"`
+	// ClientCertPath and ClientKeyPath are the paths of the PEM encoded
+	// client certificate and key used for mutual TLS.
+	ClientCertPath string `yaml:"client-cert-path"`
+	ClientKeyPath  string `yaml:"client-key-path"`
+	// InsecureSkipVerify disables the verification of the certificate of
+	// the endpoint.
+	InsecureSkipVerify bool `yaml:"insecure-skip-verify"`
+	// Timeout is the timeout of the requests.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Validate validates the configuration.
+func (c *ClientConfig) Validate() error {
+	if (c.ClientCertPath == "") != (c.ClientKeyPath == "") {
+		return fmt.Errorf("invalid configuration: ClientCertPath and ClientKeyPath must be set together")
+	}
+	if c.InsecureSkipVerify && c.CAPath != "" {
+		return fmt.Errorf("invalid configuration: InsecureSkipVerify and CAPath cannot be used at the same time")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid configuration: Timeout cannot be negative")
+	}
+	return nil
+}
+
+// ValidateURL validates the URL of an endpoint.
+func ValidateURL(rawURL string) error {
+	if rawURL == "" {
+		return fmt.Errorf("invalid configuration: URL cannot be empty")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: invalid URL %q: %v", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid configuration: URL %q must be http or https", rawURL)
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS configuration of the client, loading the CA and
+// the client certificate.
+func (c *ClientConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAPath != "" {
+		caPEM, err := ioutil.ReadFile(c.CAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA %q", c.CAPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.ClientCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertPath, c.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// NewClient returns a client for the configuration.
+func (c *ClientConfig) NewClient() (*Client, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	t := transport.New()
+	// the certificate authorities of the agent's ca-bundle are trusted
+	// unless the endpoint has its own
+	if tlsConfig.RootCAs == nil && t.TLSClientConfig != nil {
+		tlsConfig.RootCAs = t.TLSClientConfig.RootCAs
+	}
+	t.TLSClientConfig = tlsConfig
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	return &Client{
+		headers:         c.Headers,
+		bearerTokenPath: c.BearerTokenPath,
+		client: &http.Client{
+			Transport: t,
+			Timeout:   timeout,
+		},
+	}, nil
+}
+
+// Client sends GET requests to HTTP endpoints.
+type Client struct {
+	headers         map[string]string
+	bearerTokenPath string
+	client          *http.Client
+}
+
+// Get gets the URL, accepting the content type, and returns the body of the
+// response. Responses which are not successful are returned as errors.
+func (c *Client) Get(ctx context.Context, url, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+	if c.bearerTokenPath != "" {
+		token, err := ioutil.ReadFile(c.bearerTokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response of %s: %v", url, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("failed to get %s: status code %d: %s", url, resp.StatusCode, string(body))
+	}
+	return body, nil
+}
@@ -0,0 +1,92 @@
+// Package httpendpoint provides a datagatherer for JSON HTTP endpoints.
+package httpendpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// Config is the configuration for an HTTP endpoint DataGatherer.
+type Config struct {
+	// URL is the URL of the endpoint returning JSON.
+	URL string `yaml:"url"`
+	// ClientConfig configures the connection to the endpoint.
+	ClientConfig `yaml:",inline"`
+}
+
+// validate validates the configuration.
+func (c *Config) validate() error {
+	if err := ValidateURL(c.URL); err != nil {
+		return err
+	}
+	return c.ClientConfig.Validate()
+}
+
+// NewDataGatherer creates a new HTTP endpoint DataGatherer. It performs a
+// config validation.
+func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := c.ClientConfig.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGatherer{
+		ctx:    ctx,
+		url:    c.URL,
+		client: client,
+	}, nil
+}
+
+// DataGatherer is a data-gatherer for JSON HTTP endpoints.
+type DataGatherer struct {
+	ctx    context.Context
+	url    string
+	client *Client
+}
+
+func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Healthy() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch gets the endpoint and returns its parsed JSON response.
+func (g *DataGatherer) Fetch() (interface{}, error) {
+	return g.FetchContext(g.ctx)
+}
+
+// FetchContext gets the endpoint as Fetch does, cancelling the request once
+// ctx is done.
+func (g *DataGatherer) FetchContext(ctx context.Context) (interface{}, error) {
+	body, err := g.client.Get(ctx, g.url, "application/json")
+	if err != nil {
+		return nil, err
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse response of %s as JSON: %v", g.url, err)
+	}
+	return data, nil
+}
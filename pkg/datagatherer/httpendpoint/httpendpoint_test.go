@@ -0,0 +1,110 @@
+package httpendpoint
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		config  Config
+		wantErr bool
+	}{
+		"url":                {config: Config{URL: "https://controller.example:8443/status"}},
+		"empty":              {config: Config{}, wantErr: true},
+		"unsupported scheme": {config: Config{URL: "ftp://example"}, wantErr: true},
+		"cert without key":   {config: Config{URL: "https://example", ClientConfig: ClientConfig{ClientCertPath: "tls.crt"}}, wantErr: true},
+		"insecure with ca":   {config: Config{URL: "https://example", ClientConfig: ClientConfig{CAPath: "ca.crt", InsecureSkipVerify: true}}, wantErr: true},
+		"negative timeout":   {config: Config{URL: "https://example", ClientConfig: ClientConfig{Timeout: -1}}, wantErr: true},
+		"client certificate": {config: Config{URL: "https://example", ClientConfig: ClientConfig{ClientCertPath: "tls.crt", ClientKeyPath: "tls.key"}}},
+		"insecure":           {config: Config{URL: "https://example", ClientConfig: ClientConfig{InsecureSkipVerify: true}}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.config.validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "unauthorized")
+			return
+		}
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"ready": true, "certificates": [{"name": "a", "expiry": "2021-06-01T00:00:00Z"}]}`)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "httpendpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caPath := filepath.Join(dir, "ca.crt")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caPath, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tokenPath := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenPath, []byte("token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{URL: server.URL + "/status", ClientConfig: ClientConfig{CAPath: caPath, BearerTokenPath: tokenPath}}
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{
+		"ready": true,
+		"certificates": []interface{}{
+			map[string]interface{}{"name": "a", "expiry": "2021-06-01T00:00:00Z"},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+
+	// the token is read again on every fetch
+	if err := ioutil.WriteFile(tokenPath, []byte("rotated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dg.Fetch(); err == nil {
+		t.Errorf("expected an error with an invalid token")
+	}
+
+	// the endpoint is not trusted without the CA
+	config.CAPath = ""
+	dg, err = config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := dg.Fetch(); err == nil {
+		t.Errorf("expected an error without the CA")
+	}
+}
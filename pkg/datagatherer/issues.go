@@ -0,0 +1,37 @@
+package datagatherer
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// IssueCode classifies the error as api.IssueForbidden, api.IssueUnauthorized
+// or api.IssueTimeout, and returns fallback for the other errors. The errors
+// of the informers only keep the message of the API server errors, so the
+// message is checked too.
+func IssueCode(err error, fallback string) string {
+	message := err.Error()
+	switch {
+	case apierrors.IsForbidden(err) || strings.Contains(message, " is forbidden: "):
+		return api.IssueForbidden
+	case apierrors.IsUnauthorized(err) || strings.HasSuffix(message, ": Unauthorized"):
+		return api.IssueUnauthorized
+	case apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) || errors.Is(err, context.DeadlineExceeded):
+		return api.IssueTimeout
+	}
+	return fallback
+}
+
+// IssueSeverity returns the severity of an issue by its code, the resources
+// the agent is not allowed to read are left out of the data.
+func IssueSeverity(code string) string {
+	switch code {
+	case api.IssueForbidden, api.IssueUnauthorized:
+		return api.IssueSeverityError
+	}
+	return api.IssueSeverityWarning
+}
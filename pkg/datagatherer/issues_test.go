@@ -0,0 +1,40 @@
+package datagatherer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestIssueCode(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	forbidden := apierrors.NewForbidden(pods, "", fmt.Errorf("RBAC denied"))
+	tests := map[string]struct {
+		err      error
+		expected string
+	}{
+		"forbidden":         {err: forbidden, expected: api.IssueForbidden},
+		"wrapped forbidden": {err: errors.WithStack(forbidden), expected: api.IssueForbidden},
+		"informer forbidden": {
+			err:      fmt.Errorf("failed to list *unstructured.Unstructured: %v", forbidden),
+			expected: api.IssueForbidden,
+		},
+		"unauthorized":      {err: apierrors.NewUnauthorized("token expired"), expected: api.IssueUnauthorized},
+		"server timeout":    {err: apierrors.NewServerTimeout(pods, "list", 1), expected: api.IssueTimeout},
+		"deadline exceeded": {err: fmt.Errorf("listing: %w", context.DeadlineExceeded), expected: api.IssueTimeout},
+		"other":             {err: fmt.Errorf("connection refused"), expected: api.IssueWatchFailed},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if code := IssueCode(test.err, api.IssueWatchFailed); code != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, code)
+			}
+		})
+	}
+}
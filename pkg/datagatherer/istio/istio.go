@@ -54,8 +54,8 @@ func (c *Config) validate() error {
 
 // DataGatherer is a DataGatherer for Istio.
 type DataGatherer struct {
-	sourceAnalyzer       *local.SourceAnalyzer
-	dynamicDataGatherers []datagatherer.DataGatherer
+	k8s.DynamicWrappers
+	sourceAnalyzer *local.SourceAnalyzer
 }
 
 // NewDataGatherer creates a new DataGatherer for a cluster.
@@ -77,7 +77,7 @@ func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer
 	}
 
 	// Create a list of Kubernetes dynamic data gatherers to fetch all the required resources for Istio analysis.
-	var dynamicDataGatherers []datagatherer.DataGatherer
+	var dynamicDataGatherers k8s.DynamicWrappers
 	for _, gvr := range gvrs {
 		configDynamic := k8s.ConfigDynamic{
 			KubeConfigPath:       c.KubeConfigPath,
@@ -97,52 +97,17 @@ func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer
 		"", resource.Namespace(istioNamespace), nil, true, 30*time.Second)
 
 	return &DataGatherer{
-		sourceAnalyzer:       sourceAnalyzer,
-		dynamicDataGatherers: dynamicDataGatherers,
+		sourceAnalyzer:  sourceAnalyzer,
+		DynamicWrappers: dynamicDataGatherers,
 	}, nil
 }
 
-// Run starts the istio data gatherer's dynamic informers for resource collection.
-// Returns error if the pod and node data gatherers haven't been correctly initialized
-func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
-	// start dynamic dynamic data gatherers informers
-	for _, dynamicDg := range g.dynamicDataGatherers {
-		err := dynamicDg.Run(stopCh)
-		if err != nil {
-			return fmt.Errorf("failed to run datagatherer: %s", err)
-		}
-	}
-
-	return nil
-}
-
-// WaitForCacheSync waits for the data gatherer's informers cache to sync before collecting the resources.
-func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
-	for _, dynamicDg := range g.dynamicDataGatherers {
-		err := dynamicDg.WaitForCacheSync(stopCh)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (g *DataGatherer) Delete() error {
-	for _, dynamicDg := range g.dynamicDataGatherers {
-		err := dynamicDg.Delete()
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 // Fetch retrieves resources from the K8s API and runs Istio analysis.
 func (g *DataGatherer) Fetch() (interface{}, error) {
 
 	// Fetch resources from all data gatherers and accumulate in an Unstructured slice.
 	var allResources []unstructured.Unstructured
-	for _, dynamicDataGatherer := range g.dynamicDataGatherers {
+	for _, dynamicDataGatherer := range g.DynamicWrappers {
 		rawResources, err := dynamicDataGatherer.Fetch()
 		if err != nil {
 			// Data gatherers will error if the resource kind they are trying to fetch is not present in the cluster.
@@ -0,0 +1,355 @@
+package istio
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	gatewaysGVR            = schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1alpha3", Resource: "gateways"}
+	destinationRulesGVR    = schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1alpha3", Resource: "destinationrules"}
+	peerAuthenticationsGVR = schema.GroupVersionResource{Group: "security.istio.io", Version: "v1beta1", Resource: "peerauthentications"}
+	configMapsGVR          = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+)
+
+// meshResourceTypes are the Istio resources describing the certificate
+// posture of the mesh.
+var meshResourceTypes = []schema.GroupVersionResource{
+	gatewaysGVR,
+	destinationRulesGVR,
+	peerAuthenticationsGVR,
+}
+
+const (
+	// rootCertConfigMap is the ConfigMap istiod distributes the root
+	// certificate of the mesh with.
+	rootCertConfigMap = "istio-ca-root-cert"
+	rootCertKey       = "root-cert.pem"
+
+	// defaultMTLSMode is the mode of the workloads which are not selected by
+	// any PeerAuthentication.
+	defaultMTLSMode = "PERMISSIVE"
+)
+
+// MeshConfig is the configuration for the istio-mesh DataGatherer.
+type MeshConfig struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// IstioNamespace is the root namespace of the mesh, where istiod runs.
+	// It defaults to `istio-system`.
+	IstioNamespace string `yaml:"istio-namespace"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+}
+
+func (c *MeshConfig) istioNamespace() string {
+	if c.IstioNamespace == "" {
+		return "istio-system"
+	}
+	return c.IstioNamespace
+}
+
+// DynamicConfigs returns the configurations of the dynamic data gatherers
+// used to collect the Istio resources and the root certificate ConfigMap of
+// the Istio namespace.
+func (c *MeshConfig) DynamicConfigs() []*k8s.ConfigDynamic {
+	return []*k8s.ConfigDynamic{
+		{
+			KubeConfigPath:        c.KubeConfigPath,
+			KubeConfigContext:     c.KubeConfigContext,
+			GroupVersionResources: meshResourceTypes,
+			ExcludeNamespaces:     c.ExcludeNamespaces,
+			IncludeNamespaces:     c.IncludeNamespaces,
+		},
+		{
+			KubeConfigPath:       c.KubeConfigPath,
+			KubeConfigContext:    c.KubeConfigContext,
+			GroupVersionResource: configMapsGVR,
+			IncludeNamespaces:    []string{c.istioNamespace()},
+		},
+	}
+}
+
+// NewDataGatherer creates a new DataGatherer for the certificate posture of
+// an Istio mesh.
+func (c *MeshConfig) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	configs := c.DynamicConfigs()
+	meshDg, err := configs[0].NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rootCertDg, err := configs[1].NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MeshDataGatherer{
+		DynamicWrappers: k8s.DynamicWrappers{meshDg, rootCertDg},
+		istioNamespace:  c.istioNamespace(),
+		meshDg:          meshDg,
+		rootCertDg:      rootCertDg,
+	}, nil
+}
+
+// MeshDataGatherer gathers Gateways, DestinationRules, PeerAuthentications
+// and the root certificate of an Istio mesh, and emits a summary of the mTLS
+// modes and certificates in use.
+type MeshDataGatherer struct {
+	k8s.DynamicWrappers
+	istioNamespace string
+	meshDg         datagatherer.DataGatherer
+	rootCertDg     datagatherer.DataGatherer
+}
+
+// MeshSummary is the data emitted by the istio-mesh data gatherer.
+type MeshSummary struct {
+	// MeshMTLSMode is the mode of the mesh-wide PeerAuthentication, or
+	// PERMISSIVE if there is none.
+	MeshMTLSMode string `json:"meshMTLSMode"`
+	// Namespaces are the mTLS modes of the namespaces with
+	// PeerAuthentications.
+	Namespaces       []*NamespaceMTLS   `json:"namespaces"`
+	Gateways         []*Gateway         `json:"gateways"`
+	DestinationRules []*DestinationRule `json:"destinationRules"`
+	// RootCertificates are the root certificates distributed by istiod.
+	RootCertificates []*certinfo.Certificate `json:"rootCertificates"`
+	// RootCertificateExpiry is the earliest expiry of the root
+	// certificates.
+	RootCertificateExpiry *time.Time `json:"rootCertificateExpiry,omitempty"`
+	// RootCertificateError is set if the root certificate cannot be parsed.
+	RootCertificateError string `json:"rootCertificateError,omitempty"`
+}
+
+// NamespaceMTLS is the mTLS mode of a namespace.
+type NamespaceMTLS struct {
+	Namespace string `json:"namespace"`
+	// Mode is the mode of the namespace-wide PeerAuthentication, inherited
+	// from the mesh if there is none or if it is UNSET.
+	Mode string `json:"mode"`
+	// WorkloadModes are the modes of the PeerAuthentications selecting
+	// workloads of the namespace, keyed by PeerAuthentication name.
+	WorkloadModes map[string]string `json:"workloadModes,omitempty"`
+}
+
+// Gateway describes the servers of an Istio Gateway.
+type Gateway struct {
+	Namespace string           `json:"namespace"`
+	Name      string           `json:"name"`
+	Servers   []*GatewayServer `json:"servers"`
+}
+
+// GatewayServer is a server of an Istio Gateway.
+type GatewayServer struct {
+	Port     int64    `json:"port"`
+	Protocol string   `json:"protocol,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"`
+	// TLSMode is SIMPLE, MUTUAL, ISTIO_MUTUAL, PASSTHROUGH or
+	// AUTO_PASSTHROUGH, or empty for plain text servers.
+	TLSMode string `json:"tlsMode,omitempty"`
+	// CredentialName is the name of the Secret holding the certificate of
+	// the server.
+	CredentialName string `json:"credentialName,omitempty"`
+}
+
+// DestinationRule describes the client TLS settings of a DestinationRule.
+type DestinationRule struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	// TLSMode is DISABLE, SIMPLE, MUTUAL or ISTIO_MUTUAL, or empty if the
+	// DestinationRule has no TLS settings.
+	TLSMode string `json:"tlsMode,omitempty"`
+}
+
+// Fetch summarizes the Istio resources currently in the cache. Deleted
+// resources are ignored.
+func (g *MeshDataGatherer) Fetch() (interface{}, error) {
+	summary := &MeshSummary{
+		MeshMTLSMode:     defaultMTLSMode,
+		Namespaces:       []*NamespaceMTLS{},
+		Gateways:         []*Gateway{},
+		DestinationRules: []*DestinationRule{},
+		RootCertificates: []*certinfo.Certificate{},
+	}
+
+	var peerAuthentications []*unstructured.Unstructured
+	err := visitResources(g.meshDg, func(resource *unstructured.Unstructured) {
+		switch resource.GetKind() {
+		case "PeerAuthentication":
+			peerAuthentications = append(peerAuthentications, resource)
+		case "Gateway":
+			summary.Gateways = append(summary.Gateways, summarizeGateway(resource))
+		case "DestinationRule":
+			summary.DestinationRules = append(summary.DestinationRules, summarizeDestinationRule(resource))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	summary.MeshMTLSMode, summary.Namespaces = mtlsModes(peerAuthentications, g.istioNamespace)
+
+	err = visitResources(g.rootCertDg, func(resource *unstructured.Unstructured) {
+		if resource.GetName() != rootCertConfigMap {
+			return
+		}
+		data, _, _ := unstructured.NestedString(resource.Object, "data", rootCertKey)
+		certificates, err := certinfo.DescribePEM([]byte(data))
+		if err != nil {
+			summary.RootCertificateError = err.Error()
+			return
+		}
+		summary.RootCertificates = certificates
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, certificate := range summary.RootCertificates {
+		if summary.RootCertificateExpiry == nil || certificate.NotAfter.Before(*summary.RootCertificateExpiry) {
+			notAfter := certificate.NotAfter
+			summary.RootCertificateExpiry = &notAfter
+		}
+	}
+
+	sort.Slice(summary.Gateways, func(i, j int) bool {
+		return key(summary.Gateways[i].Namespace, summary.Gateways[i].Name) < key(summary.Gateways[j].Namespace, summary.Gateways[j].Name)
+	})
+	sort.Slice(summary.DestinationRules, func(i, j int) bool {
+		return key(summary.DestinationRules[i].Namespace, summary.DestinationRules[i].Name) < key(summary.DestinationRules[j].Namespace, summary.DestinationRules[j].Name)
+	})
+
+	return summary, nil
+}
+
+// visitResources calls fn for every resource fetched from the data gatherer
+// which has not been deleted.
+func visitResources(dg datagatherer.DataGatherer, fn func(*unstructured.Unstructured)) error {
+	data, err := dg.Fetch()
+	if err != nil {
+		return err
+	}
+	return k8s.VisitGatheredResources(data, func(item *api.GatheredResource) error {
+		if !item.DeletedAt.IsZero() {
+			return nil
+		}
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("failed to parse cached resource")
+		}
+		fn(resource)
+		return nil
+	})
+}
+
+// mtlsModes resolves the mesh-wide mTLS mode and the mode of every namespace
+// with PeerAuthentications. PeerAuthentications without a selector apply to
+// their whole namespace, or to the whole mesh in the Istio namespace.
+func mtlsModes(peerAuthentications []*unstructured.Unstructured, istioNamespace string) (string, []*NamespaceMTLS) {
+	meshMode := defaultMTLSMode
+	for _, pa := range peerAuthentications {
+		if pa.GetNamespace() == istioNamespace && !hasSelector(pa) {
+			if mode := peerAuthenticationMode(pa); mode != "" {
+				meshMode = mode
+			}
+		}
+	}
+
+	byNamespace := map[string]*NamespaceMTLS{}
+	for _, pa := range peerAuthentications {
+		namespace := pa.GetNamespace()
+		if namespace == istioNamespace && !hasSelector(pa) {
+			continue
+		}
+		ns, ok := byNamespace[namespace]
+		if !ok {
+			ns = &NamespaceMTLS{Namespace: namespace, Mode: meshMode}
+			byNamespace[namespace] = ns
+		}
+		mode := peerAuthenticationMode(pa)
+		if hasSelector(pa) {
+			if ns.WorkloadModes == nil {
+				ns.WorkloadModes = map[string]string{}
+			}
+			if mode == "" {
+				mode = "UNSET"
+			}
+			ns.WorkloadModes[pa.GetName()] = mode
+		} else if mode != "" {
+			ns.Mode = mode
+		}
+	}
+
+	namespaces := []*NamespaceMTLS{}
+	for _, ns := range byNamespace {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Namespace < namespaces[j].Namespace
+	})
+	return meshMode, namespaces
+}
+
+// peerAuthenticationMode returns the mTLS mode of the PeerAuthentication, or
+// an empty string if it inherits the mode of its parent.
+func peerAuthenticationMode(pa *unstructured.Unstructured) string {
+	mode, _, _ := unstructured.NestedString(pa.Object, "spec", "mtls", "mode")
+	if mode == "UNSET" {
+		return ""
+	}
+	return mode
+}
+
+func hasSelector(pa *unstructured.Unstructured) bool {
+	labels, _, _ := unstructured.NestedStringMap(pa.Object, "spec", "selector", "matchLabels")
+	return len(labels) > 0
+}
+
+func summarizeGateway(resource *unstructured.Unstructured) *Gateway {
+	gateway := &Gateway{
+		Namespace: resource.GetNamespace(),
+		Name:      resource.GetName(),
+		Servers:   []*GatewayServer{},
+	}
+	servers, _, _ := unstructured.NestedSlice(resource.Object, "spec", "servers")
+	for _, s := range servers {
+		server, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		summary := &GatewayServer{}
+		summary.Port, _, _ = unstructured.NestedInt64(server, "port", "number")
+		summary.Protocol, _, _ = unstructured.NestedString(server, "port", "protocol")
+		summary.Hosts, _, _ = unstructured.NestedStringSlice(server, "hosts")
+		summary.TLSMode, _, _ = unstructured.NestedString(server, "tls", "mode")
+		summary.CredentialName, _, _ = unstructured.NestedString(server, "tls", "credentialName")
+		gateway.Servers = append(gateway.Servers, summary)
+	}
+	return gateway
+}
+
+func summarizeDestinationRule(resource *unstructured.Unstructured) *DestinationRule {
+	rule := &DestinationRule{
+		Namespace: resource.GetNamespace(),
+		Name:      resource.GetName(),
+	}
+	rule.Host, _, _ = unstructured.NestedString(resource.Object, "spec", "host")
+	rule.TLSMode, _, _ = unstructured.NestedString(resource.Object, "spec", "trafficPolicy", "tls", "mode")
+	return rule
+}
+
+func key(namespace, name string) string {
+	return namespace + "/" + name
+}
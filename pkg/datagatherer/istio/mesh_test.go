@@ -0,0 +1,145 @@
+package istio
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fakeDataGatherer returns a fixed set of data on Fetch
+type fakeDataGatherer struct {
+	data interface{}
+}
+
+func (g *fakeDataGatherer) Run(stopCh <-chan struct{}) error              { return nil }
+func (g *fakeDataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error { return nil }
+func (g *fakeDataGatherer) Delete() error                                 { return nil }
+func (g *fakeDataGatherer) Healthy() error                                { return nil }
+func (g *fakeDataGatherer) Fetch() (interface{}, error)                   { return g.data, nil }
+
+func getResource(apiVersion, kind, namespace, name string, spec map[string]interface{}) *api.GatheredResource {
+	return &api.GatheredResource{
+		Resource: &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": apiVersion,
+				"kind":       kind,
+				"metadata": map[string]interface{}{
+					"name":      name,
+					"namespace": namespace,
+				},
+				"spec": spec,
+			},
+		},
+	}
+}
+
+func getRootCertPEM(t *testing.T, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"cluster.local"}},
+		NotBefore:             time.Now(),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestMeshDataGathererFetch(t *testing.T) {
+	notAfter := time.Now().Add(365 * 24 * time.Hour).UTC().Truncate(time.Second)
+	rootCert := getResource("v1", "ConfigMap", "istio-system", rootCertConfigMap, nil)
+	rootCert.Resource.(*unstructured.Unstructured).Object["data"] = map[string]interface{}{
+		rootCertKey: getRootCertPEM(t, notAfter),
+	}
+
+	dg := &MeshDataGatherer{
+		istioNamespace: "istio-system",
+		meshDg: &fakeDataGatherer{data: map[string]interface{}{
+			"items": []*api.GatheredResource{
+				getResource("security.istio.io/v1beta1", "PeerAuthentication", "istio-system", "default",
+					map[string]interface{}{"mtls": map[string]interface{}{"mode": "STRICT"}}),
+				getResource("security.istio.io/v1beta1", "PeerAuthentication", "legacy", "default",
+					map[string]interface{}{"mtls": map[string]interface{}{"mode": "PERMISSIVE"}}),
+				getResource("security.istio.io/v1beta1", "PeerAuthentication", "payments", "db",
+					map[string]interface{}{
+						"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "db"}},
+						"mtls":     map[string]interface{}{"mode": "DISABLE"},
+					}),
+				getResource("networking.istio.io/v1alpha3", "Gateway", "istio-system", "ingress",
+					map[string]interface{}{
+						"servers": []interface{}{
+							map[string]interface{}{
+								"port":  map[string]interface{}{"number": int64(443), "protocol": "HTTPS"},
+								"hosts": []interface{}{"example.com"},
+								"tls":   map[string]interface{}{"mode": "SIMPLE", "credentialName": "example-tls"},
+							},
+						},
+					}),
+				getResource("networking.istio.io/v1alpha3", "DestinationRule", "payments", "db",
+					map[string]interface{}{
+						"host":          "db.payments.svc.cluster.local",
+						"trafficPolicy": map[string]interface{}{"tls": map[string]interface{}{"mode": "DISABLE"}},
+					}),
+			},
+		}},
+		rootCertDg: &fakeDataGatherer{data: map[string]interface{}{
+			"items": []*api.GatheredResource{rootCert},
+		}},
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	summary := data.(*MeshSummary)
+
+	if summary.MeshMTLSMode != "STRICT" {
+		t.Errorf("expected the mesh to be STRICT, got %q", summary.MeshMTLSMode)
+	}
+	expectedNamespaces := []*NamespaceMTLS{
+		{Namespace: "legacy", Mode: "PERMISSIVE"},
+		{Namespace: "payments", Mode: "STRICT", WorkloadModes: map[string]string{"db": "DISABLE"}},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedNamespaces, summary.Namespaces); !equal {
+		t.Errorf("unexpected namespaces:\n%s", diff)
+	}
+	expectedGateways := []*Gateway{
+		{
+			Namespace: "istio-system",
+			Name:      "ingress",
+			Servers: []*GatewayServer{
+				{Port: 443, Protocol: "HTTPS", Hosts: []string{"example.com"}, TLSMode: "SIMPLE", CredentialName: "example-tls"},
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedGateways, summary.Gateways); !equal {
+		t.Errorf("unexpected gateways:\n%s", diff)
+	}
+	expectedRules := []*DestinationRule{
+		{Namespace: "payments", Name: "db", Host: "db.payments.svc.cluster.local", TLSMode: "DISABLE"},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedRules, summary.DestinationRules); !equal {
+		t.Errorf("unexpected destination rules:\n%s", diff)
+	}
+	if len(summary.RootCertificates) != 1 || summary.RootCertificateExpiry == nil || !summary.RootCertificateExpiry.Equal(notAfter) {
+		t.Errorf("unexpected root certificates: %+v, expiry %v", summary.RootCertificates, summary.RootCertificateExpiry)
+	}
+}
@@ -0,0 +1,361 @@
+package k8s
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/logs"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// admissionWebhookResourceTypes are the webhook configurations gathered by
+// the k8s-admission data gatherer.
+var admissionWebhookResourceTypes = []schema.GroupVersionResource{
+	{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "validatingwebhookconfigurations"},
+	{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "mutatingwebhookconfigurations"},
+}
+
+// admissionPolicyVersions are the versions ValidatingAdmissionPolicies can be
+// served with, most preferred first.
+var admissionPolicyVersions = []string{"v1", "v1beta1", "v1alpha1"}
+
+const admissionPolicyResource = "validatingadmissionpolicies"
+
+// ConfigAdmission contains the configuration for the k8s-admission
+// data-gatherer.
+type ConfigAdmission struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+}
+
+// DynamicConfigs returns the configurations of the dynamic data gatherers
+// used to collect the webhook configurations and the
+// ValidatingAdmissionPolicies. The version of the policies is only known
+// once it is discovered from the cluster, the one returned here is only
+// meant to generate permissions, which do not depend on it.
+func (c *ConfigAdmission) DynamicConfigs() []*ConfigDynamic {
+	return []*ConfigDynamic{
+		{
+			KubeConfigPath:        c.KubeConfigPath,
+			KubeConfigContext:     c.KubeConfigContext,
+			GroupVersionResources: admissionWebhookResourceTypes,
+		},
+		c.policyConfig(admissionPolicyVersions[0]),
+	}
+}
+
+// policyConfig returns the configuration of the dynamic data gatherer
+// collecting the ValidatingAdmissionPolicies of the version.
+func (c *ConfigAdmission) policyConfig(version string) *ConfigDynamic {
+	return &ConfigDynamic{
+		KubeConfigPath:       c.KubeConfigPath,
+		KubeConfigContext:    c.KubeConfigContext,
+		GroupVersionResource: schema.GroupVersionResource{Group: "admissionregistration.k8s.io", Version: version, Resource: admissionPolicyResource},
+	}
+}
+
+// NewDataGatherer constructs a new instance of the k8s-admission data-gatherer.
+func (c *ConfigAdmission) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	webhookDg, err := c.DynamicConfigs()[0].NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryClient, err := NewDiscoveryClientForContext(c.KubeConfigPath, c.KubeConfigContext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGathererAdmission{
+		DynamicWrappers: DynamicWrappers{webhookDg},
+		webhookDg:       webhookDg,
+		discoveryClient: &discoveryClient,
+		newPolicyDg: func(version string) (datagatherer.DataGatherer, error) {
+			return c.policyConfig(version).NewDataGatherer(ctx)
+		},
+	}, nil
+}
+
+// DataGathererAdmission summarizes the admission webhooks and the
+// ValidatingAdmissionPolicies of the cluster. The version of the policies is
+// discovered when the data gatherer starts, the policies are not gathered
+// from clusters not serving them.
+type DataGathererAdmission struct {
+	DynamicWrappers
+	webhookDg       datagatherer.DataGatherer
+	discoveryClient discovery.DiscoveryInterface
+	newPolicyDg     func(version string) (datagatherer.DataGatherer, error)
+	// policyDg is set by Run when the cluster serves the policies.
+	policyDg datagatherer.DataGatherer
+}
+
+// AdmissionWebhook describes a webhook of a webhook configuration.
+type AdmissionWebhook struct {
+	// Configuration is the name of the webhook configuration.
+	Configuration string `json:"configuration"`
+	// Type is Validating or Mutating.
+	Type           string `json:"type"`
+	Name           string `json:"name"`
+	FailurePolicy  string `json:"failurePolicy,omitempty"`
+	SideEffects    string `json:"sideEffects,omitempty"`
+	TimeoutSeconds int64  `json:"timeoutSeconds,omitempty"`
+	// Service is the namespace/name of the Service called, or URL the URL
+	// called, for webhooks running outside of the cluster.
+	Service string `json:"service,omitempty"`
+	URL     string `json:"url,omitempty"`
+	// CABundleExpiry is the earliest expiry of the certificates used to
+	// verify the webhook. It is empty when the webhook relies on the CAs of
+	// the API server.
+	CABundleExpiry *time.Time `json:"caBundleExpiry,omitempty"`
+	CABundleError  string     `json:"caBundleError,omitempty"`
+	// NamespaceSelector and ObjectSelector are true if the webhook only
+	// applies to some namespaces or objects.
+	NamespaceSelector bool             `json:"namespaceSelector"`
+	ObjectSelector    bool             `json:"objectSelector"`
+	Rules             []*AdmissionRule `json:"rules"`
+}
+
+// AdmissionRule describes the requests an admission webhook or policy
+// applies to.
+type AdmissionRule struct {
+	Operations  []string `json:"operations,omitempty"`
+	APIGroups   []string `json:"apiGroups,omitempty"`
+	APIVersions []string `json:"apiVersions,omitempty"`
+	Resources   []string `json:"resources,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+}
+
+// AdmissionPolicy describes a ValidatingAdmissionPolicy.
+type AdmissionPolicy struct {
+	Name          string           `json:"name"`
+	FailurePolicy string           `json:"failurePolicy,omitempty"`
+	Validations   int              `json:"validations"`
+	Rules         []*AdmissionRule `json:"rules"`
+}
+
+// Run starts the dynamic data gatherer's informers for the webhook
+// configurations and, if the cluster serves them, the ones for the
+// ValidatingAdmissionPolicies.
+func (g *DataGathererAdmission) Run(stopCh <-chan struct{}) error {
+	version, err := admissionPolicyVersion(g.discoveryClient)
+	if err != nil {
+		return err
+	}
+	if version == "" {
+		logs.Log.Warnf("%s are not served, they will not be gathered", admissionPolicyResource)
+	} else {
+		policyDg, err := g.newPolicyDg(version)
+		if err != nil {
+			return err
+		}
+		g.policyDg = policyDg
+	}
+	g.DynamicWrappers = DynamicWrappers{g.webhookDg}
+	if g.policyDg != nil {
+		g.DynamicWrappers = append(g.DynamicWrappers, g.policyDg)
+	}
+	return g.DynamicWrappers.Run(stopCh)
+}
+
+// Fetch summarizes the webhooks and policies currently in the cache. Deleted
+// resources are ignored.
+func (g *DataGathererAdmission) Fetch() (interface{}, error) {
+	webhooks := []*AdmissionWebhook{}
+	err := visitAdmissionResources(g.webhookDg, func(resource *unstructured.Unstructured) {
+		webhooks = append(webhooks, summarizeWebhooks(resource)...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		if webhooks[i].Configuration != webhooks[j].Configuration {
+			return webhooks[i].Configuration < webhooks[j].Configuration
+		}
+		if webhooks[i].Type != webhooks[j].Type {
+			return webhooks[i].Type < webhooks[j].Type
+		}
+		return webhooks[i].Name < webhooks[j].Name
+	})
+
+	policies := []*AdmissionPolicy{}
+	if g.policyDg != nil {
+		err := visitAdmissionResources(g.policyDg, func(resource *unstructured.Unstructured) {
+			policies = append(policies, summarizeAdmissionPolicy(resource))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Name < policies[j].Name
+	})
+
+	return map[string]interface{}{
+		"webhooks":                    webhooks,
+		"validatingAdmissionPolicies": policies,
+	}, nil
+}
+
+// visitAdmissionResources calls fn for every resource fetched from the data
+// gatherer which has not been deleted.
+func visitAdmissionResources(dg datagatherer.DataGatherer, fn func(*unstructured.Unstructured)) error {
+	data, err := dg.Fetch()
+	if err != nil {
+		return err
+	}
+	return VisitGatheredResources(data, func(item *api.GatheredResource) error {
+		if !item.DeletedAt.IsZero() {
+			return nil
+		}
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("failed to parse cached resource")
+		}
+		fn(resource)
+		return nil
+	})
+}
+
+// admissionPolicyVersion returns the most preferred version the cluster
+// serves ValidatingAdmissionPolicies with, or an empty string if it does not
+// serve them.
+func admissionPolicyVersion(cl discovery.DiscoveryInterface) (string, error) {
+	if cl == nil {
+		return "", fmt.Errorf("discovery client was not initialized, impossible to discover %s", admissionPolicyResource)
+	}
+	_, resourceLists, err := cl.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return "", fmt.Errorf("failed to discover %s: %v", admissionPolicyResource, err)
+	}
+
+	served := map[string]bool{}
+	for _, list := range resourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil || gv.Group != "admissionregistration.k8s.io" {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			if resource.Name == admissionPolicyResource {
+				served[gv.Version] = true
+			}
+		}
+	}
+	for _, version := range admissionPolicyVersions {
+		if served[version] {
+			return version, nil
+		}
+	}
+	return "", nil
+}
+
+// summarizeWebhooks returns the webhooks of a webhook configuration.
+func summarizeWebhooks(resource *unstructured.Unstructured) []*AdmissionWebhook {
+	webhookType := "Validating"
+	if resource.GetKind() == "MutatingWebhookConfiguration" {
+		webhookType = "Mutating"
+	}
+
+	items, _, _ := unstructured.NestedSlice(resource.Object, "webhooks")
+	webhooks := []*AdmissionWebhook{}
+	for _, item := range items {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		webhook := &AdmissionWebhook{
+			Configuration: resource.GetName(),
+			Type:          webhookType,
+			Rules:         admissionRules(object, "rules"),
+		}
+		webhook.Name, _, _ = unstructured.NestedString(object, "name")
+		webhook.FailurePolicy, _, _ = unstructured.NestedString(object, "failurePolicy")
+		webhook.SideEffects, _, _ = unstructured.NestedString(object, "sideEffects")
+		webhook.TimeoutSeconds, _, _ = unstructured.NestedInt64(object, "timeoutSeconds")
+		webhook.NamespaceSelector = hasLabelSelector(object, "namespaceSelector")
+		webhook.ObjectSelector = hasLabelSelector(object, "objectSelector")
+
+		if service, found, _ := unstructured.NestedMap(object, "clientConfig", "service"); found {
+			namespace, _, _ := unstructured.NestedString(service, "namespace")
+			name, _, _ := unstructured.NestedString(service, "name")
+			webhook.Service = namespace + "/" + name
+		}
+		webhook.URL, _, _ = unstructured.NestedString(object, "clientConfig", "url")
+
+		if caBundle, _, _ := unstructured.NestedString(object, "clientConfig", "caBundle"); caBundle != "" {
+			webhook.CABundleExpiry, webhook.CABundleError = caBundleExpiry(caBundle)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks
+}
+
+// caBundleExpiry returns the earliest expiry of the certificates of a base64
+// encoded PEM bundle, or why it cannot be parsed.
+func caBundleExpiry(caBundle string) (*time.Time, string) {
+	pemData, err := base64.StdEncoding.DecodeString(caBundle)
+	if err != nil {
+		return nil, fmt.Sprintf("failed to decode caBundle: %v", err)
+	}
+	certificates, err := certinfo.DescribePEM(pemData)
+	if err != nil {
+		return nil, fmt.Sprintf("failed to parse caBundle: %v", err)
+	}
+	var expiry *time.Time
+	for _, certificate := range certificates {
+		if expiry == nil || certificate.NotAfter.Before(*expiry) {
+			notAfter := certificate.NotAfter
+			expiry = &notAfter
+		}
+	}
+	return expiry, ""
+}
+
+// hasLabelSelector returns true if the field is a label selector matching
+// only some objects.
+func hasLabelSelector(object map[string]interface{}, field string) bool {
+	selector, found, _ := unstructured.NestedMap(object, field)
+	return found && len(selector) > 0
+}
+
+// admissionRules returns the rules of a webhook or a policy.
+func admissionRules(object map[string]interface{}, fields ...string) []*AdmissionRule {
+	items, _, _ := unstructured.NestedSlice(object, fields...)
+	rules := []*AdmissionRule{}
+	for _, item := range items {
+		rule, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		summary := &AdmissionRule{}
+		summary.Operations, _, _ = unstructured.NestedStringSlice(rule, "operations")
+		summary.APIGroups, _, _ = unstructured.NestedStringSlice(rule, "apiGroups")
+		summary.APIVersions, _, _ = unstructured.NestedStringSlice(rule, "apiVersions")
+		summary.Resources, _, _ = unstructured.NestedStringSlice(rule, "resources")
+		summary.Scope, _, _ = unstructured.NestedString(rule, "scope")
+		rules = append(rules, summary)
+	}
+	return rules
+}
+
+// summarizeAdmissionPolicy summarizes a ValidatingAdmissionPolicy.
+func summarizeAdmissionPolicy(resource *unstructured.Unstructured) *AdmissionPolicy {
+	policy := &AdmissionPolicy{
+		Name:  resource.GetName(),
+		Rules: admissionRules(resource.Object, "spec", "matchConstraints", "resourceRules"),
+	}
+	policy.FailurePolicy, _, _ = unstructured.NestedString(resource.Object, "spec", "failurePolicy")
+	validations, _, _ := unstructured.NestedSlice(resource.Object, "spec", "validations")
+	policy.Validations = len(validations)
+	return policy
+}
@@ -0,0 +1,176 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func getAdmissionObject(apiVersion, kind, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	object := getObject(apiVersion, kind, name, "", false)
+	for field, value := range fields {
+		object.Object[field] = value
+	}
+	return object
+}
+
+func TestDataGathererAdmissionFetch(t *testing.T) {
+	caPEM := getCertificatePEM(t, "webhook-ca")
+	certificates, err := certinfo.DescribePEM(caPEM)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	caExpiry := certificates[0].NotAfter
+
+	webhooks := []*api.GatheredResource{
+		{Resource: getAdmissionObject("admissionregistration.k8s.io/v1", "ValidatingWebhookConfiguration", "cert-manager-webhook", map[string]interface{}{
+			"webhooks": []interface{}{
+				map[string]interface{}{
+					"name":              "webhook.cert-manager.io",
+					"failurePolicy":     "Fail",
+					"sideEffects":       "None",
+					"timeoutSeconds":    int64(10),
+					"namespaceSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"team": "platform"}},
+					"clientConfig": map[string]interface{}{
+						"service":  map[string]interface{}{"namespace": "cert-manager", "name": "cert-manager-webhook"},
+						"caBundle": base64.StdEncoding.EncodeToString(caPEM),
+					},
+					"rules": []interface{}{
+						map[string]interface{}{
+							"operations":  []interface{}{"CREATE", "UPDATE"},
+							"apiGroups":   []interface{}{"cert-manager.io"},
+							"apiVersions": []interface{}{"*"},
+							"resources":   []interface{}{"*/*"},
+						},
+					},
+				},
+			},
+		})},
+		{Resource: getAdmissionObject("admissionregistration.k8s.io/v1", "MutatingWebhookConfiguration", "external", map[string]interface{}{
+			"webhooks": []interface{}{
+				map[string]interface{}{
+					"name":          "external.example.com",
+					"failurePolicy": "Ignore",
+					"clientConfig": map[string]interface{}{
+						"url":      "https://webhook.example.com/mutate",
+						"caBundle": "not base64",
+					},
+				},
+			},
+		})},
+		{Resource: getAdmissionObject("admissionregistration.k8s.io/v1", "MutatingWebhookConfiguration", "deleted", nil), DeletedAt: api.Time{Time: clock.now()}},
+	}
+	policies := []*api.GatheredResource{
+		{Resource: getAdmissionObject("admissionregistration.k8s.io/v1", "ValidatingAdmissionPolicy", "require-labels", map[string]interface{}{
+			"spec": map[string]interface{}{
+				"failurePolicy": "Fail",
+				"matchConstraints": map[string]interface{}{
+					"resourceRules": []interface{}{
+						map[string]interface{}{
+							"operations":  []interface{}{"CREATE"},
+							"apiGroups":   []interface{}{"apps"},
+							"apiVersions": []interface{}{"v1"},
+							"resources":   []interface{}{"deployments"},
+						},
+					},
+				},
+				"validations": []interface{}{
+					map[string]interface{}{"expression": "has(object.metadata.labels.team)"},
+				},
+			},
+		})},
+	}
+
+	dg := &DataGathererAdmission{
+		webhookDg: &fakeDataGatherer{data: map[string]interface{}{"items": webhooks}},
+		policyDg:  &fakeDataGatherer{data: map[string]interface{}{"items": policies}},
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"webhooks": []*AdmissionWebhook{
+			{
+				Configuration:     "cert-manager-webhook",
+				Type:              "Validating",
+				Name:              "webhook.cert-manager.io",
+				FailurePolicy:     "Fail",
+				SideEffects:       "None",
+				TimeoutSeconds:    10,
+				Service:           "cert-manager/cert-manager-webhook",
+				CABundleExpiry:    &caExpiry,
+				NamespaceSelector: true,
+				Rules: []*AdmissionRule{
+					{Operations: []string{"CREATE", "UPDATE"}, APIGroups: []string{"cert-manager.io"}, APIVersions: []string{"*"}, Resources: []string{"*/*"}},
+				},
+			},
+			{
+				Configuration: "external",
+				Type:          "Mutating",
+				Name:          "external.example.com",
+				FailurePolicy: "Ignore",
+				URL:           "https://webhook.example.com/mutate",
+				CABundleError: "failed to decode caBundle: illegal base64 data at input byte 3",
+				Rules:         []*AdmissionRule{},
+			},
+		},
+		"validatingAdmissionPolicies": []*AdmissionPolicy{
+			{
+				Name:          "require-labels",
+				FailurePolicy: "Fail",
+				Validations:   1,
+				Rules: []*AdmissionRule{
+					{Operations: []string{"CREATE"}, APIGroups: []string{"apps"}, APIVersions: []string{"v1"}, Resources: []string{"deployments"}},
+				},
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+}
+
+func TestDataGathererAdmissionRun(t *testing.T) {
+	cl := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}}
+	cl.Resources = []*metav1.APIResourceList{
+		{GroupVersion: "admissionregistration.k8s.io/v1", APIResources: []metav1.APIResource{{Name: "validatingwebhookconfigurations"}}},
+	}
+
+	var policyVersion string
+	dg := &DataGathererAdmission{
+		webhookDg:       &fakeDataGatherer{},
+		discoveryClient: cl,
+		newPolicyDg: func(version string) (datagatherer.DataGatherer, error) {
+			policyVersion = version
+			return &fakeDataGatherer{}, nil
+		},
+	}
+	if err := dg.Run(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dg.policyDg != nil {
+		t.Errorf("expected the policies not to be gathered when they are not served")
+	}
+
+	cl.Resources = append(cl.Resources,
+		&metav1.APIResourceList{GroupVersion: "admissionregistration.k8s.io/v1alpha1", APIResources: []metav1.APIResource{{Name: admissionPolicyResource}}},
+		&metav1.APIResourceList{GroupVersion: "admissionregistration.k8s.io/v1beta1", APIResources: []metav1.APIResource{{Name: admissionPolicyResource}}},
+	)
+	if err := dg.Run(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dg.policyDg == nil || policyVersion != "v1beta1" {
+		t.Errorf("expected the policies to be gathered with v1beta1, got %q", policyVersion)
+	}
+}
@@ -0,0 +1,276 @@
+package k8s
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// AnonymizationProfile is a named bundle of redaction, PII scrubbing and
+// metadata stripping rules that is applied to every gathered resource.
+type AnonymizationProfile struct {
+	// Name identifies the profile in the agent configuration.
+	Name string
+	// RedactFields are removed from every resource, see Redact.
+	RedactFields []string
+	// ScrubMetadataEmails replaces email addresses found in labels and
+	// annotations.
+	ScrubMetadataEmails bool
+	// ScrubAllEmails replaces email addresses found anywhere in the resource.
+	ScrubAllEmails bool
+	// StripContainerEnvValues removes literal values of container environment
+	// variables, keeping their names and valueFrom references.
+	StripContainerEnvValues bool
+	// StripContainerCommands removes the command and args of containers.
+	StripContainerCommands bool
+}
+
+const (
+	// AnonymizationProfileNone does not apply any rule on top of the default
+	// Secret redaction.
+	AnonymizationProfileNone = "none"
+	// AnonymizationProfileStandard removes fields that commonly leak applied
+	// manifests, credentials or personal data.
+	AnonymizationProfileStandard = "standard"
+	// AnonymizationProfileStrict keeps only the data needed to identify
+	// resources and their relationships.
+	AnonymizationProfileStrict = "strict"
+)
+
+// redactedEmail replaces scrubbed email addresses.
+const redactedEmail = "[redacted-email]"
+
+var emailRegexp = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+var anonymizationProfiles = map[string]*AnonymizationProfile{
+	AnonymizationProfileNone: {
+		Name: AnonymizationProfileNone,
+	},
+	AnonymizationProfileStandard: {
+		Name: AnonymizationProfileStandard,
+		RedactFields: []string{
+			"metadata.managedFields",
+			"metadata.selfLink",
+			"/metadata/annotations/kubectl.kubernetes.io~1last-applied-configuration",
+		},
+		ScrubMetadataEmails:     true,
+		StripContainerEnvValues: true,
+	},
+	AnonymizationProfileStrict: {
+		Name: AnonymizationProfileStrict,
+		RedactFields: []string{
+			"metadata.managedFields",
+			"metadata.selfLink",
+			"metadata.annotations",
+			"metadata.generateName",
+			"spec.nodeName",
+			"status.hostIP",
+			"status.podIP",
+			"status.podIPs",
+		},
+		ScrubAllEmails:          true,
+		StripContainerEnvValues: true,
+		StripContainerCommands:  true,
+	},
+}
+
+// GetAnonymizationProfile returns the built-in profile with the given name.
+// An empty name is equivalent to the none profile.
+func GetAnonymizationProfile(name string) (*AnonymizationProfile, error) {
+	if name == "" {
+		name = AnonymizationProfileNone
+	}
+	profile, ok := anonymizationProfiles[name]
+	if !ok {
+		names := make([]string, 0, len(anonymizationProfiles))
+		for k := range anonymizationProfiles {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown anonymization profile %q, must be one of: %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// Anonymize applies the profile rules to a resource.
+func (p *AnonymizationProfile) Anonymize(resource *unstructured.Unstructured) error {
+	if len(p.RedactFields) > 0 {
+		if err := Redact(p.RedactFields, resource); err != nil {
+			return err
+		}
+	}
+
+	if p.StripContainerEnvValues || p.StripContainerCommands {
+		stripContainers(resource.Object, p.StripContainerEnvValues, p.StripContainerCommands)
+	}
+
+	switch {
+	case p.ScrubAllEmails:
+		resource.Object = scrubEmails(resource.Object).(map[string]interface{})
+	case p.ScrubMetadataEmails:
+		if metadata, ok := resource.Object["metadata"].(map[string]interface{}); ok {
+			for _, key := range []string{"labels", "annotations"} {
+				if values, ok := metadata[key]; ok {
+					metadata[key] = scrubEmails(values)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// AnonymizeData replaces all the gathered resources contained in the output
+// of a data gatherer's Fetch with their anonymized copies. The resources are
+// copied as they may be held by the cache of their data gatherer.
+func (p *AnonymizationProfile) AnonymizeData(data interface{}) error {
+	if p.Name == AnonymizationProfileNone {
+		return nil
+	}
+	return ReplaceGatheredResources(data, p.AnonymizeResource)
+}
+
+// AnonymizeResource returns a copy of the gathered resource with the profile
+// rules applied.
+func (p *AnonymizationProfile) AnonymizeResource(item *api.GatheredResource) (*api.GatheredResource, error) {
+	resource, ok := item.Resource.(*unstructured.Unstructured)
+	if !ok {
+		return item, nil
+	}
+	resource = resource.DeepCopy()
+	if err := p.Anonymize(resource); err != nil {
+		return nil, err
+	}
+	anonymized := *item
+	anonymized.Resource = resource
+	return &anonymized, nil
+}
+
+// ReplaceGatheredResources replaces every api.GatheredResource found in the
+// output of a data gatherer's Fetch with the one returned by fn, so that the
+// resources held by the data gatherer are left as they are. The formats
+// supported are the ones of VisitGatheredResources.
+func ReplaceGatheredResources(data interface{}, fn func(*api.GatheredResource) (*api.GatheredResource, error)) error {
+	list, ok := data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if items, ok := list["items"].([]*api.GatheredResource); ok {
+		for i, item := range items {
+			replaced, err := fn(item)
+			if err != nil {
+				return err
+			}
+			items[i] = replaced
+		}
+	}
+
+	if resources, ok := list["resources"].(map[string]map[string]interface{}); ok {
+		for _, resourceList := range resources {
+			if err := ReplaceGatheredResources(resourceList, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// VisitGatheredResources calls fn for every api.GatheredResource found in the
+// output of a data gatherer's Fetch. Both the single resource type
+// ({"items": [...]}) and the multiple resource types ({"resources": {...}})
+// formats are supported, other data is ignored.
+func VisitGatheredResources(data interface{}, fn func(*api.GatheredResource) error) error {
+	list, ok := data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if items, ok := list["items"].([]*api.GatheredResource); ok {
+		for _, item := range items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+	}
+
+	if resources, ok := list["resources"].(map[string]map[string]interface{}); ok {
+		for _, resourceList := range resources {
+			if err := VisitGatheredResources(resourceList, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// stripContainers walks the object looking for container lists, as found in
+// Pods and pod templates, and removes sensitive fields from them.
+func stripContainers(value interface{}, envValues, commands bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if key == "containers" || key == "initContainers" || key == "ephemeralContainers" {
+				if containers, ok := child.([]interface{}); ok {
+					for _, c := range containers {
+						if container, ok := c.(map[string]interface{}); ok {
+							stripContainer(container, envValues, commands)
+						}
+					}
+					continue
+				}
+			}
+			stripContainers(child, envValues, commands)
+		}
+	case []interface{}:
+		for _, child := range v {
+			stripContainers(child, envValues, commands)
+		}
+	}
+}
+
+func stripContainer(container map[string]interface{}, envValues, commands bool) {
+	if commands {
+		delete(container, "command")
+		delete(container, "args")
+	}
+	if !envValues {
+		return
+	}
+	env, ok := container["env"].([]interface{})
+	if !ok {
+		return
+	}
+	for _, e := range env {
+		if envVar, ok := e.(map[string]interface{}); ok {
+			delete(envVar, "value")
+		}
+	}
+}
+
+// scrubEmails returns a copy of value where all the email addresses found in
+// strings have been replaced.
+func scrubEmails(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = scrubEmails(child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = scrubEmails(child)
+		}
+		return v
+	case string:
+		return emailRegexp.ReplaceAllString(v, redactedEmail)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,136 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func getPod() *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]interface{}{
+				"name":      "example",
+				"namespace": "default",
+				"selfLink":  "/api/v1/namespaces/default/pods/example",
+				"annotations": map[string]interface{}{
+					"owner": "jane.doe@example.com",
+				},
+			},
+			"spec": map[string]interface{}{
+				"nodeName": "node-1",
+				"containers": []interface{}{
+					map[string]interface{}{
+						"name":    "app",
+						"command": []interface{}{"/app", "--contact=ops@example.com"},
+						"env": []interface{}{
+							map[string]interface{}{
+								"name":  "PASSWORD",
+								"value": "hunter2",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestAnonymizationProfiles(t *testing.T) {
+	tests := map[string]struct {
+		profile  string
+		expected map[string]interface{}
+	}{
+		"none leaves the resource untouched": {
+			profile:  AnonymizationProfileNone,
+			expected: getPod().Object,
+		},
+		"standard strips env values, selfLink and metadata emails": {
+			profile: AnonymizationProfileStandard,
+			expected: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"metadata": map[string]interface{}{
+					"name":      "example",
+					"namespace": "default",
+					"annotations": map[string]interface{}{
+						"owner": redactedEmail,
+					},
+				},
+				"spec": map[string]interface{}{
+					"nodeName": "node-1",
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name":    "app",
+							"command": []interface{}{"/app", "--contact=ops@example.com"},
+							"env": []interface{}{
+								map[string]interface{}{
+									"name": "PASSWORD",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		"strict also strips annotations, node names and commands": {
+			profile: AnonymizationProfileStrict,
+			expected: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"metadata": map[string]interface{}{
+					"name":      "example",
+					"namespace": "default",
+				},
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name": "app",
+							"env": []interface{}{
+								map[string]interface{}{
+									"name": "PASSWORD",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			profile, err := GetAnonymizationProfile(tc.profile)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			pod := getPod()
+			data := map[string]interface{}{
+				"items": []*api.GatheredResource{{Resource: pod}},
+			}
+			if err := profile.AnonymizeData(data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			anonymized := data["items"].([]*api.GatheredResource)[0].Resource.(*unstructured.Unstructured)
+			if diff, equal := messagediff.PrettyDiff(tc.expected, anonymized.Object); !equal {
+				t.Errorf("unexpected result:\n%s", diff)
+			}
+			// the resource may be held by the cache of the data gatherer
+			if diff, equal := messagediff.PrettyDiff(getPod().Object, pod.Object); !equal {
+				t.Errorf("expected the gathered resource not to be modified:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetAnonymizationProfileUnknown(t *testing.T) {
+	if _, err := GetAnonymizationProfile("paranoid"); err == nil {
+		t.Fatalf("expected error for unknown profile")
+	}
+}
@@ -1,10 +1,10 @@
 package k8s
 
 import (
-	"log"
 	"time"
 
 	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/logs"
 	"github.com/pmylund/go-cache"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
@@ -36,10 +36,10 @@ func onAdd(obj interface{}, dgCache *cache.Cache) {
 			}
 			dgCache.Set(uid.(string), cacheObject, cache.DefaultExpiration)
 		} else {
-			log.Printf("could not %q resource %q to the cache, missing uid field", "add", data["name"].(string))
+			logs.Log.Warnf("could not %q resource %q to the cache, missing uid field", "add", data["name"].(string))
 		}
 	} else {
-		log.Printf("could not %q resource to the cache, missing metadata", "add")
+		logs.Log.Warnf("could not %q resource to the cache, missing metadata", "add")
 	}
 }
 
@@ -54,10 +54,10 @@ func onUpdate(old, new interface{}, dgCache *cache.Cache) {
 			cacheObject := updateCacheGatheredResource(uid.(string), new, dgCache)
 			dgCache.Set(uid.(string), cacheObject, cache.DefaultExpiration)
 		} else {
-			log.Printf("could not %q resource %q to the cache, missing uid field", "update", data["name"].(string))
+			logs.Log.Warnf("could not %q resource %q to the cache, missing uid field", "update", data["name"].(string))
 		}
 	} else {
-		log.Printf("could not %q resource to the cache, missing metadata", "update")
+		logs.Log.Warnf("could not %q resource to the cache, missing metadata", "update")
 	}
 }
 
@@ -73,10 +73,10 @@ func onDelete(obj interface{}, dgCache *cache.Cache) {
 			cacheObject.DeletedAt = api.Time{Time: clock.now()}
 			dgCache.Set(uid.(string), cacheObject, cache.DefaultExpiration)
 		} else {
-			log.Printf("could not %q resource %q to the cache, missing uid field", "delete", data["name"].(string))
+			logs.Log.Warnf("could not %q resource %q to the cache, missing uid field", "delete", data["name"].(string))
 		}
 	} else {
-		log.Printf("could not %q resource to the cache, missing metadata", "delete")
+		logs.Log.Warnf("could not %q resource to the cache, missing metadata", "delete")
 	}
 }
 
@@ -98,3 +98,41 @@ func updateCacheGatheredResource(cacheKey string, resource interface{},
 	}
 	return cacheObject
 }
+
+// retainTombstone sets the expiration of a deleted resource to the configured
+// deleted resource TTL, the cache janitor evicts it once it has expired.
+func (g *DataGathererDynamic) retainTombstone(obj interface{}) {
+	if g.deletedResourceTTL == 0 {
+		return
+	}
+	item, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	key := string(item.GetUID())
+	if cacheObject, ok := g.cache.Get(key); ok {
+		g.cache.Set(key, cacheObject, g.deletedResourceTTL)
+	}
+}
+
+// retainPolled keeps a polled resource in the cache until it is listed again.
+// The informers of the polled resource types do not resync, so the resources
+// would otherwise expire from the cache long before the next poll.
+func (g *DataGathererDynamic) retainPolled(obj interface{}) {
+	item, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	key := string(item.GetUID())
+	if cacheObject, ok := g.cache.Get(key); ok {
+		g.cache.Set(key, cacheObject, cache.NoExpiration)
+	}
+}
+
+// tombstoneExpiration returns the cache expiration of deleted resources.
+func (g *DataGathererDynamic) tombstoneExpiration() time.Duration {
+	if g.deletedResourceTTL == 0 {
+		return cache.DefaultExpiration
+	}
+	return g.deletedResourceTTL
+}
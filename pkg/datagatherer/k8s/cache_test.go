@@ -135,3 +135,34 @@ func TestOnAddCache(t *testing.T) {
 		})
 	}
 }
+
+func TestRetainTombstone(t *testing.T) {
+	for name, tc := range map[string]struct {
+		ttl      time.Duration
+		expected time.Duration
+	}{
+		"default expiration": {expected: 5 * time.Minute},
+		"configured ttl":     {ttl: time.Hour, expected: time.Hour},
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := &DataGathererDynamic{
+				cache:              cache.New(5*time.Minute, 30*time.Second),
+				deletedResourceTTL: tc.ttl,
+			}
+			obj := getObject("foobar/v1", "Foo", "testfoo", "testns", false)
+
+			onAdd(obj, g.cache)
+			onDelete(obj, g.cache)
+			g.retainTombstone(obj)
+
+			item, ok := g.cache.Items()["testfoo1"]
+			if !ok {
+				t.Fatalf("expected the deleted resource to be in the cache")
+			}
+			expiresIn := time.Until(time.Unix(0, item.Expiration))
+			if expiresIn > tc.expected || expiresIn < tc.expected-time.Minute {
+				t.Errorf("expected the deleted resource to expire in %s, expires in %s", tc.expected, expiresIn)
+			}
+		})
+	}
+}
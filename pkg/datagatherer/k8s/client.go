@@ -2,18 +2,122 @@
 package k8s
 
 import (
+	"fmt"
+
 	"github.com/pkg/errors"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// ClientOptions tune the requests made to the Kubernetes API, e.g. to avoid
+// being throttled by API Priority and Fairness on large clusters. Zero values
+// fall back to the defaults set with SetDefaultClientOptions, and then to the
+// client-go defaults.
+type ClientOptions struct {
+	// QPS is the maximum number of requests per second to the API server.
+	QPS float32 `yaml:"qps,omitempty"`
+	// Burst is the maximum number of requests sent at once to the API
+	// server.
+	Burst int `yaml:"burst,omitempty"`
+	// PageSize is the maximum number of resources per page when listing.
+	PageSize int64 `yaml:"page-size,omitempty"`
+	// ImpersonateUser is the user the requests are made as, the identity of
+	// the kubeconfig must be allowed to impersonate it.
+	ImpersonateUser string `yaml:"impersonate-user,omitempty"`
+	// ImpersonateGroups are the groups the requests are made as, they
+	// require ImpersonateUser.
+	ImpersonateGroups []string `yaml:"impersonate-groups,omitempty"`
+	// ServiceAccount, in the namespace/name format, is the service account
+	// the requests are made as, using bound tokens requested with the
+	// identity of the kubeconfig.
+	ServiceAccount string `yaml:"service-account,omitempty"`
+	// TokenAudiences are the audiences of the tokens of ServiceAccount,
+	// which default to the audiences of the API server.
+	TokenAudiences []string `yaml:"token-audiences,omitempty"`
+}
+
+// defaultClientOptions are the options used for the values the data
+// gatherers do not configure.
+var defaultClientOptions ClientOptions
+
+// SetDefaultClientOptions sets the options used by the clients of all the
+// data gatherers, unless they configure their own.
+func SetDefaultClientOptions(options ClientOptions) {
+	defaultClientOptions = options
+}
+
+// Validate returns an error if any of the options is invalid.
+func (o ClientOptions) Validate() error {
+	if o.QPS < 0 {
+		return fmt.Errorf("invalid configuration: QPS cannot be negative")
+	}
+	if o.Burst < 0 {
+		return fmt.Errorf("invalid configuration: Burst cannot be negative")
+	}
+	if o.PageSize < 0 {
+		return fmt.Errorf("invalid configuration: PageSize cannot be negative")
+	}
+	if len(o.ImpersonateGroups) > 0 && o.ImpersonateUser == "" {
+		return fmt.Errorf("invalid configuration: ImpersonateGroups requires ImpersonateUser")
+	}
+	if o.ServiceAccount != "" {
+		if o.ImpersonateUser != "" {
+			return fmt.Errorf("invalid configuration: ServiceAccount and ImpersonateUser cannot be used at the same time")
+		}
+		if _, _, err := splitServiceAccount(o.ServiceAccount); err != nil {
+			return err
+		}
+	} else if len(o.TokenAudiences) > 0 {
+		return fmt.Errorf("invalid configuration: TokenAudiences requires ServiceAccount")
+	}
+	return nil
+}
+
+// withDefaults returns the options with the default options set for the
+// missing values.
+func (o ClientOptions) withDefaults() ClientOptions {
+	if o.QPS == 0 {
+		o.QPS = defaultClientOptions.QPS
+	}
+	if o.Burst == 0 {
+		o.Burst = defaultClientOptions.Burst
+	}
+	if o.PageSize == 0 {
+		o.PageSize = defaultClientOptions.PageSize
+	}
+	// the identity is defaulted as a whole, so that the defaults are
+	// never mixed with the identity of the data gatherer
+	if o.ImpersonateUser == "" && o.ServiceAccount == "" {
+		o.ImpersonateUser = defaultClientOptions.ImpersonateUser
+		o.ImpersonateGroups = defaultClientOptions.ImpersonateGroups
+		o.ServiceAccount = defaultClientOptions.ServiceAccount
+		o.TokenAudiences = defaultClientOptions.TokenAudiences
+	}
+	return o
+}
+
 // NewDynamicClient creates a new 'dynamic' clientset using the provided kubeconfig.
 // If kubeconfigPath is not set/empty, it will attempt to load configuration using
 // the default loading rules.
 func NewDynamicClient(kubeconfigPath string) (dynamic.Interface, error) {
-	cfg, err := loadRESTConfig(kubeconfigPath)
+	return NewDynamicClientForContext(kubeconfigPath, "")
+}
+
+// NewDynamicClientForContext creates a new 'dynamic' clientset using the
+// provided kubeconfig and context. If kubeconfigContext is empty, the current
+// context of the kubeconfig is used.
+func NewDynamicClientForContext(kubeconfigPath, kubeconfigContext string) (dynamic.Interface, error) {
+	return NewDynamicClientWithOptions(kubeconfigPath, kubeconfigContext, ClientOptions{})
+}
+
+// NewDynamicClientWithOptions creates a new 'dynamic' clientset using the
+// provided kubeconfig, context and client options.
+func NewDynamicClientWithOptions(kubeconfigPath, kubeconfigContext string, options ClientOptions) (dynamic.Interface, error) {
+	cfg, err := loadRESTConfig(kubeconfigPath, kubeconfigContext, options)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -28,9 +132,22 @@ func NewDynamicClient(kubeconfigPath string) (dynamic.Interface, error) {
 // kubeconfig.  If kubeconfigPath is not set/empty, it will attempt to load
 // configuration using the default loading rules.
 func NewDiscoveryClient(kubeconfigPath string) (discovery.DiscoveryClient, error) {
+	return NewDiscoveryClientForContext(kubeconfigPath, "")
+}
+
+// NewDiscoveryClientForContext creates a new 'discovery' client using the
+// provided kubeconfig and context. If kubeconfigContext is empty, the current
+// context of the kubeconfig is used.
+func NewDiscoveryClientForContext(kubeconfigPath, kubeconfigContext string) (discovery.DiscoveryClient, error) {
+	return NewDiscoveryClientWithOptions(kubeconfigPath, kubeconfigContext, ClientOptions{})
+}
+
+// NewDiscoveryClientWithOptions creates a new 'discovery' client using the
+// provided kubeconfig, context and client options.
+func NewDiscoveryClientWithOptions(kubeconfigPath, kubeconfigContext string, options ClientOptions) (discovery.DiscoveryClient, error) {
 	var discoveryClient *discovery.DiscoveryClient
 
-	cfg, err := loadRESTConfig(kubeconfigPath)
+	cfg, err := loadRESTConfig(kubeconfigPath, kubeconfigContext, options)
 	if err != nil {
 		return *discoveryClient, errors.WithStack(err)
 	}
@@ -43,7 +160,62 @@ func NewDiscoveryClient(kubeconfigPath string) (discovery.DiscoveryClient, error
 	return *discoveryClient, nil
 }
 
-func loadRESTConfig(path string) (*rest.Config, error) {
+// NewClientset creates a new typed clientset using the provided kubeconfig.
+// If kubeconfigPath is not set/empty, it will attempt to load configuration
+// using the default loading rules.
+func NewClientset(kubeconfigPath string) (kubernetes.Interface, error) {
+	cfg, err := loadRESTConfig(kubeconfigPath, "", ClientOptions{})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cl, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return cl, nil
+}
+
+// NewMetadataClientWithOptions creates a new 'metadata' client using the
+// provided kubeconfig, context and client options, it only reads the
+// metadata of resources.
+func NewMetadataClientWithOptions(kubeconfigPath, kubeconfigContext string, options ClientOptions) (metadata.Interface, error) {
+	cfg, err := loadRESTConfig(kubeconfigPath, kubeconfigContext, options)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cl, err := metadata.NewForConfig(cfg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return cl, nil
+}
+
+func loadRESTConfig(path, kubeconfigContext string, options ClientOptions) (*rest.Config, error) {
+	cfg, err := loadKubeconfig(path, kubeconfigContext)
+	if err != nil {
+		return nil, err
+	}
+	options = options.withDefaults()
+	if options.QPS > 0 {
+		cfg.QPS = options.QPS
+	}
+	if options.Burst > 0 {
+		cfg.Burst = options.Burst
+	}
+	if options.ImpersonateUser != "" {
+		cfg.Impersonate = rest.ImpersonationConfig{
+			UserName: options.ImpersonateUser,
+			Groups:   options.ImpersonateGroups,
+		}
+	}
+	if options.ServiceAccount != "" {
+		return serviceAccountConfig(cfg, options.ServiceAccount, options.TokenAudiences)
+	}
+	return cfg, nil
+}
+
+func loadKubeconfig(path, kubeconfigContext string) (*rest.Config, error) {
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeconfigContext}
 	switch path {
 	// If the kubeconfig path is not provided, use the default loading rules
 	// so we read the regular KUBECONFIG variable or create a non-interactive
@@ -51,7 +223,7 @@ func loadRESTConfig(path string) (*rest.Config, error) {
 	case "":
 		loadingrules := clientcmd.NewDefaultClientConfigLoadingRules()
 		cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-			loadingrules, &clientcmd.ConfigOverrides{}).ClientConfig()
+			loadingrules, overrides).ClientConfig()
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
@@ -60,10 +232,29 @@ func loadRESTConfig(path string) (*rest.Config, error) {
 	default:
 		cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 			&clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
-			&clientcmd.ConfigOverrides{}).ClientConfig()
+			overrides).ClientConfig()
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 		return cfg, nil
 	}
 }
+
+// kubeconfigContext returns the name of the context loadRESTConfig uses for
+// the kubeconfig path, or an empty string if there is none, e.g. when running
+// in cluster.
+func kubeconfigContext(path, override string) string {
+	if override != "" {
+		return override
+	}
+	loadingrules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if path != "" {
+		loadingrules = &clientcmd.ClientConfigLoadingRules{ExplicitPath: path}
+	}
+	rawConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingrules, &clientcmd.ConfigOverrides{}).RawConfig()
+	if err != nil {
+		return ""
+	}
+	return rawConfig.CurrentContext
+}
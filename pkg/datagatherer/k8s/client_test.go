@@ -54,6 +54,42 @@ func TestNewDiscoveryClient_InferredKubeconfig(t *testing.T) {
 	}
 }
 
+func TestLoadRESTConfig_ClientOptions(t *testing.T) {
+	kc := createValidTestConfig()
+	path := writeConfigToFile(t, kc)
+
+	SetDefaultClientOptions(ClientOptions{QPS: 20, Burst: 40})
+	defer SetDefaultClientOptions(ClientOptions{})
+
+	cfg, err := loadRESTConfig(path, "", ClientOptions{})
+	if err != nil {
+		t.Fatal("failed to load config: ", err)
+	}
+	if cfg.QPS != 20 || cfg.Burst != 40 {
+		t.Errorf("expected the default options to be used, got QPS %v and Burst %v", cfg.QPS, cfg.Burst)
+	}
+
+	cfg, err = loadRESTConfig(path, "", ClientOptions{QPS: 5})
+	if err != nil {
+		t.Fatal("failed to load config: ", err)
+	}
+	if cfg.QPS != 5 || cfg.Burst != 40 {
+		t.Errorf("expected the options of the data gatherer to be used, got QPS %v and Burst %v", cfg.QPS, cfg.Burst)
+	}
+
+	if err := (ClientOptions{Burst: -1}).Validate(); err == nil {
+		t.Errorf("expected a negative burst to be invalid")
+	}
+
+	cfg, err = loadRESTConfig(path, "", ClientOptions{ImpersonateUser: "auditor", ImpersonateGroups: []string{"readers"}})
+	if err != nil {
+		t.Fatal("failed to load config: ", err)
+	}
+	if cfg.Impersonate.UserName != "auditor" || len(cfg.Impersonate.Groups) != 1 {
+		t.Errorf("expected the requests to be impersonated, got %+v", cfg.Impersonate)
+	}
+}
+
 func writeConfigToFile(t *testing.T, cfg clientcmdapi.Config) string {
 	f, err := ioutil.TempFile("", "testcase-*")
 	if err != nil {
@@ -0,0 +1,182 @@
+package k8s
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// pemCertificateHeader starts the PEM blocks of the certificates.
+const pemCertificateHeader = "-----BEGIN CERTIFICATE-----"
+
+var configMapsGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// ConfigConfigMapCerts contains the configuration for the k8s-configmap-certs
+// data-gatherer.
+type ConfigConfigMapCerts struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+}
+
+// DynamicConfig returns the configuration of the dynamic data gatherer used to
+// collect the ConfigMaps.
+func (c *ConfigConfigMapCerts) DynamicConfig() *ConfigDynamic {
+	return &ConfigDynamic{
+		KubeConfigPath:       c.KubeConfigPath,
+		KubeConfigContext:    c.KubeConfigContext,
+		GroupVersionResource: configMapsGVR,
+		ExcludeNamespaces:    c.ExcludeNamespaces,
+		IncludeNamespaces:    c.IncludeNamespaces,
+	}
+}
+
+// NewDataGatherer constructs a new instance of the k8s-configmap-certs
+// data-gatherer.
+func (c *ConfigConfigMapCerts) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	dynamicDg, err := c.DynamicConfig().NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGathererConfigMapCerts{DynamicWrapper: DynamicWrapper{Dynamic: dynamicDg}}, nil
+}
+
+// DataGathererConfigMapCerts looks for the PEM certificates stored in the
+// values of ConfigMaps, such as CA bundles, and emits their metadata and
+// location only, so that neither the certificates nor the other values are
+// uploaded.
+type DataGathererConfigMapCerts struct {
+	DynamicWrapper
+}
+
+// ConfigMapCertificates are the certificates found in a ConfigMap.
+type ConfigMapCertificates struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	UID       string `json:"uid"`
+	// Keys are the keys of the ConfigMap with certificates, sorted.
+	Keys []*ConfigMapKeyCertificates `json:"keys"`
+}
+
+// ConfigMapKeyCertificates are the certificates found in the value of a key
+// of a ConfigMap.
+type ConfigMapKeyCertificates struct {
+	Key string `json:"key"`
+	// Binary is set if the key is one of binaryData.
+	Binary bool `json:"binary,omitempty"`
+	// Certificates are parsed in the order of the value.
+	Certificates []*certinfo.Certificate `json:"certificates"`
+	// Error is set if the value looks like a certificate but could not be
+	// parsed.
+	Error string `json:"error,omitempty"`
+}
+
+// Fetch looks for certificates in the ConfigMaps currently in the cache.
+// Deleted ConfigMaps and ConfigMaps without certificates are ignored.
+func (g *DataGathererConfigMapCerts) Fetch() (interface{}, error) {
+	data, err := g.Dynamic.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	configMaps := []*ConfigMapCertificates{}
+	err = VisitGatheredResources(data, func(item *api.GatheredResource) error {
+		if !item.DeletedAt.IsZero() {
+			return nil
+		}
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("failed to parse cached resource")
+		}
+		if certs := findConfigMapCertificates(resource); certs != nil {
+			configMaps = append(configMaps, certs)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(configMaps, func(i, j int) bool {
+		return configMaps[i].Namespace+"/"+configMaps[i].Name < configMaps[j].Namespace+"/"+configMaps[j].Name
+	})
+
+	return map[string]interface{}{
+		"configmaps": configMaps,
+	}, nil
+}
+
+// findConfigMapCertificates returns the certificates of the values of the
+// ConfigMap, or nil if it has none.
+func findConfigMapCertificates(resource *unstructured.Unstructured) *ConfigMapCertificates {
+	var keys []*ConfigMapKeyCertificates
+
+	values, _, _ := unstructured.NestedStringMap(resource.Object, "data")
+	for key, value := range values {
+		if certs := parseConfigMapValue(key, []byte(value)); certs != nil {
+			keys = append(keys, certs)
+		}
+	}
+
+	binaryValues, _, _ := unstructured.NestedStringMap(resource.Object, "binaryData")
+	for key, encoded := range binaryValues {
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		if certs := parseConfigMapValue(key, value); certs != nil {
+			certs.Binary = true
+			keys = append(keys, certs)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Key < keys[j].Key
+	})
+	return &ConfigMapCertificates{
+		Namespace: resource.GetNamespace(),
+		Name:      resource.GetName(),
+		UID:       string(resource.GetUID()),
+		Keys:      keys,
+	}
+}
+
+// parseConfigMapValue parses the PEM certificates of the value, or returns
+// nil if it has none. Values are only parsed if they have a certificate
+// header, so that the other values are skipped cheaply.
+func parseConfigMapValue(key string, value []byte) *ConfigMapKeyCertificates {
+	if !strings.Contains(string(value), pemCertificateHeader) {
+		return nil
+	}
+	certs := &ConfigMapKeyCertificates{Key: key, Certificates: []*certinfo.Certificate{}}
+	described, err := certinfo.DescribePEM(value)
+	if err != nil {
+		certs.Error = err.Error()
+		return certs
+	}
+	if len(described) == 0 {
+		// e.g. a certificate embedded in a JSON or YAML document
+		certs.Error = "failed to decode the PEM certificates"
+		return certs
+	}
+	certs.Certificates = described
+	return certs
+}
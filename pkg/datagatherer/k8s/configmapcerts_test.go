@@ -0,0 +1,68 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func getConfigMap(name, namespace string, data, binaryData map[string]interface{}) *unstructured.Unstructured {
+	object := getObject("v1", "ConfigMap", name, namespace, false)
+	if data != nil {
+		object.Object["data"] = data
+	}
+	if binaryData != nil {
+		object.Object["binaryData"] = binaryData
+	}
+	return object
+}
+
+func TestDataGathererConfigMapCertsFetch(t *testing.T) {
+	caPEM := string(getCertificatePEM(t, "Example CA"))
+
+	dg := &DataGathererConfigMapCerts{
+		DynamicWrapper: DynamicWrapper{Dynamic: &fakeDataGatherer{
+			data: map[string]interface{}{
+				"items": []*api.GatheredResource{
+					{Resource: getConfigMap("trust-bundle", "default", map[string]interface{}{
+						"ca.crt":      caPEM,
+						"config.yaml": "log-level: debug",
+					}, map[string]interface{}{
+						"bundle.pem": base64.StdEncoding.EncodeToString([]byte(caPEM + caPEM)),
+					})},
+					{Resource: getConfigMap("app-config", "default", map[string]interface{}{
+						"config.yaml": "log-level: debug",
+					}, nil)},
+					{Resource: getConfigMap("embedded", "default", map[string]interface{}{
+						"config.json": `{"ca": "-----BEGIN CERTIFICATE-----\nMIIB..."}`,
+					}, nil)},
+				},
+			},
+		}},
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configMaps := data.(map[string]interface{})["configmaps"].([]*ConfigMapCertificates)
+	if len(configMaps) != 2 {
+		t.Fatalf("expected the two ConfigMaps with certificates, got %d", len(configMaps))
+	}
+
+	embedded, bundle := configMaps[0], configMaps[1]
+	if embedded.Name != "embedded" || len(embedded.Keys) != 1 || embedded.Keys[0].Error == "" {
+		t.Errorf("expected an error for the certificate that cannot be decoded, got %+v", embedded.Keys)
+	}
+	if bundle.Name != "trust-bundle" || len(bundle.Keys) != 2 {
+		t.Fatalf("unexpected keys of the trust bundle: %+v", bundle.Keys)
+	}
+	if key := bundle.Keys[0]; key.Key != "bundle.pem" || !key.Binary || len(key.Certificates) != 2 {
+		t.Errorf("unexpected certificates of the binary key: %+v", key)
+	}
+	if key := bundle.Keys[1]; key.Key != "ca.crt" || key.Binary || len(key.Certificates) != 1 || key.Certificates[0].Subject != "CN=Example CA" {
+		t.Errorf("unexpected certificates of the key: %+v", key)
+	}
+}
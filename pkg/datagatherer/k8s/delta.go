@@ -0,0 +1,128 @@
+package k8s
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// defaultFullResyncInterval is how often a complete snapshot is returned by
+// Fetch in incremental mode if no interval is configured.
+const defaultFullResyncInterval = time.Hour
+
+// markDirty records that the resource changed since the previous Fetch. It is
+// a no-op unless the data gatherer runs in incremental mode.
+func (g *DataGathererDynamic) markDirty(obj interface{}) {
+	if !g.incremental {
+		return
+	}
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	g.dirtyMu.Lock()
+	defer g.dirtyMu.Unlock()
+	g.generation++
+	g.dirty[string(resource.GetUID())] = g.generation
+}
+
+// markUpdated marks the resource as dirty if the update event carries a new
+// version of it. Periodic informer resyncs deliver the same object as old and
+// new and are ignored.
+func (g *DataGathererDynamic) markUpdated(old, new interface{}) {
+	if isResync(old, new) {
+		return
+	}
+	g.markDirty(new)
+}
+
+// isResync returns true if the update event is a periodic informer resync,
+// which delivers the same version of the resource as old and new.
+func isResync(old, new interface{}) bool {
+	oldResource, ok := old.(*unstructured.Unstructured)
+	if !ok {
+		return false
+	}
+	newResource, ok := new.(*unstructured.Unstructured)
+	if !ok {
+		return false
+	}
+	rv := newResource.GetResourceVersion()
+	return oldResource == newResource || (rv != "" && rv == oldResource.GetResourceVersion())
+}
+
+// forgetDirty drops the dirty mark of a resource that left the cache.
+func (g *DataGathererDynamic) forgetDirty(key string) {
+	if !g.incremental {
+		return
+	}
+	g.dirtyMu.Lock()
+	defer g.dirtyMu.Unlock()
+	delete(g.dirty, key)
+}
+
+// fetchedDelta is the delta returned by a Fetch, until it is committed.
+type fetchedDelta struct {
+	full bool
+	at   time.Time
+	// dirty is the generation of the changes of the resources returned.
+	dirty map[string]uint64
+}
+
+// takeDelta decides whether the next Fetch should return a full snapshot or
+// only the resources that changed since the changes last committed. In the
+// latter case the set of changed resource UIDs is returned. The changes stay
+// dirty until they are committed, so that they are returned again if the
+// data of the Fetch is never delivered.
+func (g *DataGathererDynamic) takeDelta() (full bool, dirty map[string]bool) {
+	g.dirtyMu.Lock()
+	defer g.dirtyMu.Unlock()
+
+	now := clock.now()
+	full = g.lastFullFetch.IsZero() || now.Sub(g.lastFullFetch) >= g.fullResyncInterval
+	fetched := &fetchedDelta{full: full, at: now, dirty: map[string]uint64{}}
+	dirty = map[string]bool{}
+	for uid, generation := range g.dirty {
+		fetched.dirty[uid] = generation
+		dirty[uid] = true
+	}
+	g.fetched = fetched
+
+	if full {
+		return true, nil
+	}
+	return false, dirty
+}
+
+// discardDelta forgets the delta of a Fetch that failed, its changes stay
+// dirty.
+func (g *DataGathererDynamic) discardDelta() {
+	g.dirtyMu.Lock()
+	defer g.dirtyMu.Unlock()
+	g.fetched = nil
+}
+
+// Commit marks the changes returned by the last Fetch as delivered, they are
+// not returned by the following Fetches unless the resources change again.
+// It is a no-op unless the data gatherer runs in incremental mode.
+func (g *DataGathererDynamic) Commit() {
+	if !g.incremental {
+		return
+	}
+	g.dirtyMu.Lock()
+	defer g.dirtyMu.Unlock()
+	if g.fetched == nil {
+		return
+	}
+	for uid, generation := range g.fetched.dirty {
+		// the resources changed since the Fetch stay dirty
+		if g.dirty[uid] == generation {
+			delete(g.dirty, uid)
+		}
+	}
+	if g.fetched.full {
+		g.lastFullFetch = g.fetched.at
+	}
+	g.fetched = nil
+}
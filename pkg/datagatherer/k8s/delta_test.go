@@ -0,0 +1,154 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func fetchNames(t *testing.T, dg *DataGathererDynamic) ([]string, bool) {
+	res, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	list := res.(map[string]interface{})
+	var names []string
+	for _, item := range list["items"].([]*api.GatheredResource) {
+		names = append(names, item.Resource.(*unstructured.Unstructured).GetName())
+	}
+	_, delta := list["delta"]
+	return names, delta
+}
+
+// fetchCommitted fetches the resources and commits them, as the agent does
+// once they are uploaded.
+func fetchCommitted(t *testing.T, dg *DataGathererDynamic) ([]string, bool) {
+	names, delta := fetchNames(t, dg)
+	dg.Commit()
+	return names, delta
+}
+
+func TestDynamicGatherer_FetchIncremental(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	cl := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	config := ConfigDynamic{
+		GroupVersionResource: gvr,
+		Incremental:          true,
+	}
+	dgInterface, err := config.newDataGathererWithClient(context.Background(), cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	dg := dgInterface.(*DataGathererDynamic)
+
+	// drive the handlers directly instead of going through the informer
+	add := func(obj *unstructured.Unstructured) {
+		dg.markDirty(obj)
+		onAdd(obj, dg.cache)
+	}
+	update := func(old, new *unstructured.Unstructured) {
+		dg.markUpdated(old, new)
+		onUpdate(old, new, dg.cache)
+	}
+
+	foo := getObject("foobar/v1", "Foo", "foo", "testns", false)
+	bar := getObject("foobar/v1", "Foo", "bar", "testns", false)
+	add(foo)
+	add(bar)
+
+	names, delta := fetchCommitted(t, dg)
+	if delta || len(names) != 2 {
+		t.Fatalf("expected a full snapshot with two items, got delta=%v items=%v", delta, names)
+	}
+
+	names, delta = fetchCommitted(t, dg)
+	if !delta || len(names) != 0 {
+		t.Fatalf("expected an empty delta, got delta=%v items=%v", delta, names)
+	}
+
+	// a resync delivers the same object, it is not a change
+	update(foo, foo)
+	newFoo := getObject("foobar/v1", "Foo", "foo", "testns", false)
+	newFoo.SetResourceVersion("2")
+	update(foo, newFoo)
+
+	names, delta = fetchCommitted(t, dg)
+	if !delta || len(names) != 1 || names[0] != "foo" {
+		t.Fatalf("expected a delta containing foo, got delta=%v items=%v", delta, names)
+	}
+
+	dg.markDirty(bar)
+	onDelete(bar, dg.cache)
+
+	names, delta = fetchCommitted(t, dg)
+	if !delta || len(names) != 1 || names[0] != "bar" {
+		t.Fatalf("expected a delta containing the deleted bar, got delta=%v items=%v", delta, names)
+	}
+
+	// force the next Fetch to be a full resync
+	dg.lastFullFetch = clock.now().Add(-2 * time.Hour)
+	names, delta = fetchCommitted(t, dg)
+	if delta || len(names) != 2 {
+		t.Fatalf("expected a full resync with two items, got delta=%v items=%v", delta, names)
+	}
+}
+
+func TestDynamicGatherer_FetchIncrementalCommit(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	cl := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	config := ConfigDynamic{
+		GroupVersionResource: gvr,
+		Incremental:          true,
+	}
+	dgInterface, err := config.newDataGathererWithClient(context.Background(), cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	dg := dgInterface.(*DataGathererDynamic)
+
+	foo := getObject("foobar/v1", "Foo", "foo", "testns", false)
+	bar := getObject("foobar/v1", "Foo", "bar", "testns", false)
+	for _, obj := range []*unstructured.Unstructured{foo, bar} {
+		dg.markDirty(obj)
+		onAdd(obj, dg.cache)
+	}
+
+	// a full snapshot is returned until one is committed
+	if names, delta := fetchNames(t, dg); delta || len(names) != 2 {
+		t.Fatalf("expected a full snapshot with two items, got delta=%v items=%v", delta, names)
+	}
+	if names, delta := fetchCommitted(t, dg); delta || len(names) != 2 {
+		t.Fatalf("expected the full snapshot again, got delta=%v items=%v", delta, names)
+	}
+
+	// the changes of a Fetch that was not delivered are returned again
+	newFoo := getObject("foobar/v1", "Foo", "foo", "testns", false)
+	newFoo.SetResourceVersion("2")
+	dg.markUpdated(foo, newFoo)
+	onUpdate(foo, newFoo, dg.cache)
+	if names, delta := fetchNames(t, dg); !delta || len(names) != 1 || names[0] != "foo" {
+		t.Fatalf("expected a delta containing foo, got delta=%v items=%v", delta, names)
+	}
+	if names, delta := fetchNames(t, dg); !delta || len(names) != 1 || names[0] != "foo" {
+		t.Fatalf("expected the uncommitted delta again, got delta=%v items=%v", delta, names)
+	}
+
+	// the resources changed again since the Fetch committed stay dirty
+	newerFoo := getObject("foobar/v1", "Foo", "foo", "testns", false)
+	newerFoo.SetResourceVersion("3")
+	dg.markUpdated(newFoo, newerFoo)
+	onUpdate(newFoo, newerFoo, dg.cache)
+	dg.Commit()
+	if names, delta := fetchCommitted(t, dg); !delta || len(names) != 1 || names[0] != "foo" {
+		t.Fatalf("expected a delta containing the new change of foo, got delta=%v items=%v", delta, names)
+	}
+	if names, delta := fetchCommitted(t, dg); !delta || len(names) != 0 {
+		t.Fatalf("expected an empty delta, got delta=%v items=%v", delta, names)
+	}
+}
@@ -0,0 +1,297 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/metadata"
+)
+
+// lastAppliedAnnotation is set by kubectl apply to the applied manifest,
+// which gives the version the object was written with.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// deprecatedAPI is an API version of a resource which is deprecated, and
+// removed in a later Kubernetes minor version.
+type deprecatedAPI struct {
+	groupVersion string
+	resource     string
+	// replacement is the group version to migrate to, it is empty when the
+	// resource is removed without replacement.
+	replacement  string
+	deprecatedIn int
+	removedIn    int
+}
+
+// deprecatedAPIs is the table of the deprecated APIs of Kubernetes, by the
+// minor version they are deprecated in and removed in.
+var deprecatedAPIs = []deprecatedAPI{
+	{"extensions/v1beta1", "deployments", "apps/v1", 9, 16},
+	{"extensions/v1beta1", "daemonsets", "apps/v1", 9, 16},
+	{"extensions/v1beta1", "replicasets", "apps/v1", 9, 16},
+	{"extensions/v1beta1", "networkpolicies", "networking.k8s.io/v1", 9, 16},
+	{"extensions/v1beta1", "podsecuritypolicies", "policy/v1beta1", 10, 16},
+	{"extensions/v1beta1", "ingresses", "networking.k8s.io/v1", 14, 22},
+	{"apps/v1beta1", "deployments", "apps/v1", 9, 16},
+	{"apps/v1beta1", "statefulsets", "apps/v1", 9, 16},
+	{"apps/v1beta2", "deployments", "apps/v1", 9, 16},
+	{"apps/v1beta2", "statefulsets", "apps/v1", 9, 16},
+	{"apps/v1beta2", "daemonsets", "apps/v1", 9, 16},
+	{"apps/v1beta2", "replicasets", "apps/v1", 9, 16},
+	{"admissionregistration.k8s.io/v1beta1", "mutatingwebhookconfigurations", "admissionregistration.k8s.io/v1", 16, 22},
+	{"admissionregistration.k8s.io/v1beta1", "validatingwebhookconfigurations", "admissionregistration.k8s.io/v1", 16, 22},
+	{"apiextensions.k8s.io/v1beta1", "customresourcedefinitions", "apiextensions.k8s.io/v1", 16, 22},
+	{"apiregistration.k8s.io/v1beta1", "apiservices", "apiregistration.k8s.io/v1", 19, 22},
+	{"certificates.k8s.io/v1beta1", "certificatesigningrequests", "certificates.k8s.io/v1", 19, 22},
+	{"coordination.k8s.io/v1beta1", "leases", "coordination.k8s.io/v1", 19, 22},
+	{"networking.k8s.io/v1beta1", "ingresses", "networking.k8s.io/v1", 19, 22},
+	{"networking.k8s.io/v1beta1", "ingressclasses", "networking.k8s.io/v1", 19, 22},
+	{"rbac.authorization.k8s.io/v1beta1", "clusterroles", "rbac.authorization.k8s.io/v1", 17, 22},
+	{"rbac.authorization.k8s.io/v1beta1", "clusterrolebindings", "rbac.authorization.k8s.io/v1", 17, 22},
+	{"rbac.authorization.k8s.io/v1beta1", "roles", "rbac.authorization.k8s.io/v1", 17, 22},
+	{"rbac.authorization.k8s.io/v1beta1", "rolebindings", "rbac.authorization.k8s.io/v1", 17, 22},
+	{"scheduling.k8s.io/v1beta1", "priorityclasses", "scheduling.k8s.io/v1", 14, 22},
+	{"storage.k8s.io/v1beta1", "csidrivers", "storage.k8s.io/v1", 19, 22},
+	{"storage.k8s.io/v1beta1", "csinodes", "storage.k8s.io/v1", 17, 22},
+	{"storage.k8s.io/v1beta1", "storageclasses", "storage.k8s.io/v1", 19, 22},
+	{"storage.k8s.io/v1beta1", "volumeattachments", "storage.k8s.io/v1", 19, 22},
+	{"batch/v1beta1", "cronjobs", "batch/v1", 21, 25},
+	{"discovery.k8s.io/v1beta1", "endpointslices", "discovery.k8s.io/v1", 21, 25},
+	{"events.k8s.io/v1beta1", "events", "events.k8s.io/v1", 19, 25},
+	{"autoscaling/v2beta1", "horizontalpodautoscalers", "autoscaling/v2", 22, 25},
+	{"policy/v1beta1", "poddisruptionbudgets", "policy/v1", 21, 25},
+	{"policy/v1beta1", "podsecuritypolicies", "", 21, 25},
+	{"node.k8s.io/v1beta1", "runtimeclasses", "node.k8s.io/v1", 20, 25},
+	{"autoscaling/v2beta2", "horizontalpodautoscalers", "autoscaling/v2", 23, 26},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "flowschemas", "flowcontrol.apiserver.k8s.io/v1beta3", 23, 26},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "prioritylevelconfigurations", "flowcontrol.apiserver.k8s.io/v1beta3", 23, 26},
+	{"storage.k8s.io/v1beta1", "csistoragecapacities", "storage.k8s.io/v1", 24, 27},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "flowschemas", "flowcontrol.apiserver.k8s.io/v1", 26, 29},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "prioritylevelconfigurations", "flowcontrol.apiserver.k8s.io/v1", 26, 29},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "flowschemas", "flowcontrol.apiserver.k8s.io/v1", 29, 32},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "prioritylevelconfigurations", "flowcontrol.apiserver.k8s.io/v1", 29, 32},
+}
+
+// DeprecatedAPIResourceTypes returns the resource types listed to detect the
+// objects written with deprecated APIs, for the permissions of the agent.
+func DeprecatedAPIResourceTypes() []schema.GroupVersionResource {
+	gvrs := []schema.GroupVersionResource{}
+	for _, deprecated := range deprecatedAPIs {
+		gv, _ := schema.ParseGroupVersion(deprecated.groupVersion)
+		gvrs = append(gvrs, gv.WithResource(deprecated.resource))
+	}
+	return gvrs
+}
+
+// DeprecatedAPIUsage reports a deprecated API of a resource the cluster
+// serves, or which objects of the cluster were written with.
+type DeprecatedAPIUsage struct {
+	GroupVersion string `json:"groupVersion"`
+	Resource     string `json:"resource"`
+	// Replacement is the group version to migrate to, it is empty when the
+	// resource is removed without replacement.
+	Replacement  string `json:"replacement,omitempty"`
+	DeprecatedIn string `json:"deprecatedIn"`
+	RemovedIn    string `json:"removedIn"`
+	// Served is true if the cluster still serves the deprecated API.
+	Served bool `json:"served"`
+	// Deprecated and Removed are true if the API is deprecated or removed
+	// in the version of the cluster.
+	Deprecated bool `json:"deprecated"`
+	Removed    bool `json:"removed"`
+	// Objects are the objects last written with the deprecated API.
+	Objects []*DeprecatedAPIObject `json:"objects"`
+	// Error is set when the objects of the resource could not be listed, in
+	// which case Objects is empty.
+	Error string `json:"error,omitempty"`
+}
+
+// DeprecatedAPIObject is an object written with a deprecated API.
+type DeprecatedAPIObject struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Source is how the API version was found: the managed fields of the
+	// object, or the last configuration applied with kubectl.
+	Source string `json:"source"`
+}
+
+// metadataListPageSize is the number of objects listed per request, so
+// that large resource types are listed in pages rather than at once.
+const metadataListPageSize = 500
+
+// metadataLister lists the metadata of all the objects of a resource type.
+type metadataLister func(gvr schema.GroupVersionResource) ([]metav1.PartialObjectMetadata, error)
+
+// newMetadataLister returns a metadataLister listing with the client.
+func newMetadataLister(ctx context.Context, cl metadata.Interface) metadataLister {
+	return func(gvr schema.GroupVersionResource) ([]metav1.PartialObjectMetadata, error) {
+		return listAllMetadata(func(opts metav1.ListOptions) (*metav1.PartialObjectMetadataList, error) {
+			return cl.Resource(gvr).Namespace(metav1.NamespaceAll).List(ctx, opts)
+		})
+	}
+}
+
+// listAllMetadata lists all the pages of objects returned by list.
+func listAllMetadata(list func(opts metav1.ListOptions) (*metav1.PartialObjectMetadataList, error)) ([]metav1.PartialObjectMetadata, error) {
+	var items []metav1.PartialObjectMetadata
+	opts := metav1.ListOptions{Limit: metadataListPageSize}
+	for {
+		page, err := list(opts)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.Items...)
+		if page.Continue == "" {
+			return items, nil
+		}
+		opts.Continue = page.Continue
+	}
+}
+
+// parseMinorVersion parses the minor version reported by the API server,
+// which some providers suffix, e.g. 21+.
+func parseMinorVersion(minor string) (int, error) {
+	digits := strings.TrimRightFunc(minor, func(r rune) bool { return r < '0' || r > '9' })
+	version, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse minor version %q", minor)
+	}
+	return version, nil
+}
+
+// deprecatedAPIUsages cross-references the resources served by the cluster
+// with the deprecated APIs. For each deprecated API, the objects of its
+// resource are listed through a served version, preferably the replacement
+// one, to find the objects written with the deprecated API. Only the
+// deprecated APIs which are served or used by objects are returned. A
+// resource which fails to be listed does not fail the others, its error is
+// set on its usage and returned as an issue.
+func deprecatedAPIUsages(cl discovery.DiscoveryInterface, listObjects metadataLister) ([]*DeprecatedAPIUsage, []*api.DataReadingIssue, error) {
+	version, err := cl.ServerVersion()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get server version: %v", err)
+	}
+	minor, err := parseMinorVersion(version.Minor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, resourceLists, err := cl.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, nil, fmt.Errorf("failed to discover resources: %v", err)
+	}
+	served := map[string]bool{}
+	for _, list := range resourceLists {
+		for _, resource := range list.APIResources {
+			if !strings.Contains(resource.Name, "/") && rbacMatches(resource.Verbs, "list") {
+				served[list.GroupVersion+"/"+resource.Name] = true
+			}
+		}
+	}
+
+	// the replacement of several deprecated APIs is often the same, e.g.
+	// apps/v1 deployments, its objects are only listed once
+	type listResult struct {
+		objects []metav1.PartialObjectMetadata
+		err     error
+	}
+	listed := map[schema.GroupVersionResource]*listResult{}
+
+	usages := []*DeprecatedAPIUsage{}
+	var issues []*api.DataReadingIssue
+	for _, deprecated := range deprecatedAPIs {
+		usage := &DeprecatedAPIUsage{
+			GroupVersion: deprecated.groupVersion,
+			Resource:     deprecated.resource,
+			Replacement:  deprecated.replacement,
+			DeprecatedIn: fmt.Sprintf("1.%d", deprecated.deprecatedIn),
+			RemovedIn:    fmt.Sprintf("1.%d", deprecated.removedIn),
+			Served:       served[deprecated.groupVersion+"/"+deprecated.resource],
+			Deprecated:   minor >= deprecated.deprecatedIn,
+			Removed:      minor >= deprecated.removedIn,
+			Objects:      []*DeprecatedAPIObject{},
+		}
+
+		listVersion := ""
+		if deprecated.replacement != "" && served[deprecated.replacement+"/"+deprecated.resource] {
+			listVersion = deprecated.replacement
+		} else if usage.Served {
+			listVersion = deprecated.groupVersion
+		}
+		if listVersion != "" {
+			gv, err := schema.ParseGroupVersion(listVersion)
+			if err != nil {
+				return nil, nil, err
+			}
+			gvr := gv.WithResource(deprecated.resource)
+			result, ok := listed[gvr]
+			if !ok {
+				result = &listResult{}
+				result.objects, result.err = listObjects(gvr)
+				listed[gvr] = result
+				if result.err != nil {
+					code := datagatherer.IssueCode(result.err, api.IssueFailed)
+					issues = append(issues, &api.DataReadingIssue{
+						Severity:     datagatherer.IssueSeverity(code),
+						Code:         code,
+						ResourceType: ResourceTypeKey(gvr),
+						Message:      fmt.Sprintf("failed to list %s %s: %v", listVersion, deprecated.resource, result.err),
+					})
+				}
+			}
+			if result.err != nil {
+				usage.Error = fmt.Sprintf("failed to list %s %s: %v", listVersion, deprecated.resource, result.err)
+			}
+			objects := result.objects
+			for i := range objects {
+				if source := deprecatedAPISource(&objects[i], deprecated.groupVersion); source != "" {
+					usage.Objects = append(usage.Objects, &DeprecatedAPIObject{
+						Namespace: objects[i].Namespace,
+						Name:      objects[i].Name,
+						Source:    source,
+					})
+				}
+			}
+		}
+
+		if !usage.Served && len(usage.Objects) == 0 && usage.Error == "" {
+			continue
+		}
+		sort.Slice(usage.Objects, func(i, j int) bool {
+			if usage.Objects[i].Namespace != usage.Objects[j].Namespace {
+				return usage.Objects[i].Namespace < usage.Objects[j].Namespace
+			}
+			return usage.Objects[i].Name < usage.Objects[j].Name
+		})
+		usages = append(usages, usage)
+	}
+	return usages, issues, nil
+}
+
+// deprecatedAPISource returns how the object is found to be written with the
+// group version, or an empty string if it is not.
+func deprecatedAPISource(object *metav1.PartialObjectMetadata, groupVersion string) string {
+	if lastApplied, ok := object.Annotations[lastAppliedAnnotation]; ok {
+		applied := struct {
+			APIVersion string `json:"apiVersion"`
+		}{}
+		if json.Unmarshal([]byte(lastApplied), &applied) == nil && applied.APIVersion == groupVersion {
+			return "last-applied-configuration"
+		}
+	}
+	for _, entry := range object.ManagedFields {
+		if entry.APIVersion == groupVersion {
+			return "managedFields"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package k8s
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDeprecatedAPIUsages(t *testing.T) {
+	cl := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}}
+	cl.FakedServerVersion = &version.Info{Major: "1", Minor: "21+"}
+	listVerbs := metav1.Verbs{"get", "list", "watch"}
+	cl.Resources = []*metav1.APIResourceList{
+		{GroupVersion: "networking.k8s.io/v1", APIResources: []metav1.APIResource{{Name: "ingresses", Verbs: listVerbs}}},
+		{GroupVersion: "networking.k8s.io/v1beta1", APIResources: []metav1.APIResource{{Name: "ingresses", Verbs: listVerbs}, {Name: "ingresses/status", Verbs: listVerbs}}},
+		{GroupVersion: "batch/v1", APIResources: []metav1.APIResource{{Name: "cronjobs", Verbs: listVerbs}}},
+		{GroupVersion: "batch/v1beta1", APIResources: []metav1.APIResource{{Name: "cronjobs", Verbs: listVerbs}}},
+		{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{{Name: "deployments", Verbs: listVerbs}}},
+		{GroupVersion: "policy/v1beta1", APIResources: []metav1.APIResource{{Name: "poddisruptionbudgets", Verbs: listVerbs}}},
+	}
+
+	objects := map[schema.GroupVersionResource][]metav1.PartialObjectMetadata{
+		{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}: {
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "applied", Annotations: map[string]string{
+				lastAppliedAnnotation: `{"apiVersion": "networking.k8s.io/v1beta1", "kind": "Ingress"}`,
+			}}},
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "current", ManagedFields: []metav1.ManagedFieldsEntry{{APIVersion: "networking.k8s.io/v1"}}}},
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "api", Name: "managed", ManagedFields: []metav1.ManagedFieldsEntry{{APIVersion: "extensions/v1beta1"}}}},
+		},
+		{Group: "apps", Version: "v1", Resource: "deployments"}: {
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "legacy", Name: "old", ManagedFields: []metav1.ManagedFieldsEntry{{APIVersion: "extensions/v1beta1"}}}},
+		},
+	}
+	listed := map[string]int{}
+	list := func(gvr schema.GroupVersionResource) ([]metav1.PartialObjectMetadata, error) {
+		listed[gvr.String()]++
+		if gvr.Resource == "poddisruptionbudgets" {
+			return nil, fmt.Errorf("poddisruptionbudgets.policy is forbidden: User cannot list resource")
+		}
+		return objects[gvr], nil
+	}
+
+	usages, issues, err := deprecatedAPIUsages(cl, list)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*DeprecatedAPIUsage{
+		{
+			GroupVersion: "extensions/v1beta1", Resource: "deployments", Replacement: "apps/v1",
+			DeprecatedIn: "1.9", RemovedIn: "1.16", Deprecated: true, Removed: true,
+			Objects: []*DeprecatedAPIObject{{Namespace: "legacy", Name: "old", Source: "managedFields"}},
+		},
+		{
+			GroupVersion: "extensions/v1beta1", Resource: "ingresses", Replacement: "networking.k8s.io/v1",
+			DeprecatedIn: "1.14", RemovedIn: "1.22", Deprecated: true,
+			Objects: []*DeprecatedAPIObject{{Namespace: "api", Name: "managed", Source: "managedFields"}},
+		},
+		{
+			GroupVersion: "networking.k8s.io/v1beta1", Resource: "ingresses", Replacement: "networking.k8s.io/v1",
+			DeprecatedIn: "1.19", RemovedIn: "1.22", Served: true, Deprecated: true,
+			Objects: []*DeprecatedAPIObject{{Namespace: "web", Name: "applied", Source: "last-applied-configuration"}},
+		},
+		{
+			GroupVersion: "batch/v1beta1", Resource: "cronjobs", Replacement: "batch/v1",
+			DeprecatedIn: "1.21", RemovedIn: "1.25", Served: true, Deprecated: true,
+			Objects: []*DeprecatedAPIObject{},
+		},
+		{
+			GroupVersion: "policy/v1beta1", Resource: "poddisruptionbudgets", Replacement: "policy/v1",
+			DeprecatedIn: "1.21", RemovedIn: "1.25", Served: true, Deprecated: true,
+			Objects: []*DeprecatedAPIObject{},
+			Error:   "failed to list policy/v1beta1 poddisruptionbudgets: poddisruptionbudgets.policy is forbidden: User cannot list resource",
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, usages); !equal {
+		t.Errorf("unexpected usages:\n%s", diff)
+	}
+
+	// a resource type failing to be listed is reported as an issue
+	expectedIssues := []*api.DataReadingIssue{{
+		Severity:     api.IssueSeverityError,
+		Code:         api.IssueForbidden,
+		ResourceType: "poddisruptionbudgets.v1beta1.policy",
+		Message:      "failed to list policy/v1beta1 poddisruptionbudgets: poddisruptionbudgets.policy is forbidden: User cannot list resource",
+	}}
+	if diff, equal := messagediff.PrettyDiff(expectedIssues, issues); !equal {
+		t.Errorf("unexpected issues:\n%s", diff)
+	}
+
+	// objects are listed with the replacement API rather than the deprecated
+	// one, and only once for the deprecated APIs sharing a replacement
+	if listed["networking.k8s.io/v1beta1, Resource=ingresses"] > 0 {
+		t.Errorf("expected ingresses not to be listed with the deprecated API")
+	}
+	if n := listed["apps/v1, Resource=deployments"]; n != 1 {
+		t.Errorf("expected deployments to be listed once, got %d", n)
+	}
+}
+
+func TestListAllMetadata(t *testing.T) {
+	pages := map[string]*metav1.PartialObjectMetadataList{
+		"": {
+			ListMeta: metav1.ListMeta{Continue: "2"},
+			Items:    []metav1.PartialObjectMetadata{{ObjectMeta: metav1.ObjectMeta{Name: "a"}}},
+		},
+		"2": {
+			Items: []metav1.PartialObjectMetadata{{ObjectMeta: metav1.ObjectMeta{Name: "b"}}},
+		},
+	}
+	items, err := listAllMetadata(func(opts metav1.ListOptions) (*metav1.PartialObjectMetadataList, error) {
+		if opts.Limit != metadataListPageSize {
+			t.Errorf("expected a limit of %d, got %d", metadataListPageSize, opts.Limit)
+		}
+		return pages[opts.Continue], nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(items) != 2 || items[0].Name != "a" || items[1].Name != "b" {
+		t.Errorf("unexpected items: %+v", items)
+	}
+
+	_, err = listAllMetadata(func(opts metav1.ListOptions) (*metav1.PartialObjectMetadataList, error) {
+		if opts.Continue == "2" {
+			return nil, fmt.Errorf("expired")
+		}
+		return pages[opts.Continue], nil
+	})
+	if err == nil {
+		t.Errorf("expected the error of a page to be returned")
+	}
+}
+
+func TestParseMinorVersion(t *testing.T) {
+	for minor, expected := range map[string]int{"21": 21, "21+": 21, "18-gke": 18} {
+		version, err := parseMinorVersion(minor)
+		if err != nil || version != expected {
+			t.Errorf("parsing %q: expected %d, got %d, %v", minor, expected, version, err)
+		}
+	}
+	if _, err := parseMinorVersion("x"); err == nil {
+		t.Errorf("expected an error for an invalid minor version")
+	}
+}
@@ -3,7 +3,10 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/jetstack/preflight/api"
 	"github.com/jetstack/preflight/pkg/datagatherer"
 	"k8s.io/client-go/discovery"
 )
@@ -12,12 +15,28 @@ import (
 type ConfigDiscovery struct {
 	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
 	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// DeprecatedAPIs enables the detection of the deprecated APIs served by
+	// the cluster, and of the objects written with them.
+	DeprecatedAPIs bool `yaml:"deprecated-apis"`
+	// DeprecatedAPIsInterval is how often the objects are listed to detect
+	// the deprecated APIs, the last detection is reported in between. It
+	// defaults to 1h.
+	DeprecatedAPIsInterval time.Duration `yaml:"deprecated-apis-interval"`
 }
 
+// defaultDeprecatedAPIsInterval is the default DeprecatedAPIsInterval.
+const defaultDeprecatedAPIsInterval = time.Hour
+
 // UnmarshalYAML unmarshals the Config resolving GroupVersionResource.
 func (c *ConfigDiscovery) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	aux := struct {
-		KubeConfigPath string `yaml:"kubeconfig"`
+		KubeConfigPath         string        `yaml:"kubeconfig"`
+		KubeConfigContext      string        `yaml:"kubeconfig-context"`
+		DeprecatedAPIs         bool          `yaml:"deprecated-apis"`
+		DeprecatedAPIsInterval time.Duration `yaml:"deprecated-apis-interval"`
 	}{}
 	err := unmarshal(&aux)
 	if err != nil {
@@ -25,6 +44,9 @@ func (c *ConfigDiscovery) UnmarshalYAML(unmarshal func(interface{}) error) error
 	}
 
 	c.KubeConfigPath = aux.KubeConfigPath
+	c.KubeConfigContext = aux.KubeConfigContext
+	c.DeprecatedAPIs = aux.DeprecatedAPIs
+	c.DeprecatedAPIsInterval = aux.DeprecatedAPIsInterval
 
 	return nil
 }
@@ -32,18 +54,45 @@ func (c *ConfigDiscovery) UnmarshalYAML(unmarshal func(interface{}) error) error
 // NewDataGatherer constructs a new instance of the generic K8s data-gatherer for the provided
 // GroupVersionResource.
 func (c *ConfigDiscovery) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
-	cl, err := NewDiscoveryClient(c.KubeConfigPath)
+	if c.DeprecatedAPIsInterval < 0 {
+		return nil, fmt.Errorf("deprecated-apis-interval cannot be negative")
+	}
+	cl, err := NewDiscoveryClientForContext(c.KubeConfigPath, c.KubeConfigContext)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DataGathererDiscovery{cl: cl}, nil
+	g := &DataGathererDiscovery{cl: cl}
+	if c.DeprecatedAPIs {
+		metadataClient, err := NewMetadataClientWithOptions(c.KubeConfigPath, c.KubeConfigContext, ClientOptions{})
+		if err != nil {
+			return nil, err
+		}
+		g.listMetadata = newMetadataLister(ctx, metadataClient)
+		g.deprecatedAPIsInterval = c.DeprecatedAPIsInterval
+		if g.deprecatedAPIsInterval == 0 {
+			g.deprecatedAPIsInterval = defaultDeprecatedAPIsInterval
+		}
+	}
+	return g, nil
 }
 
 // DataGathererDiscovery stores the config for a k8s-discovery datagatherer
 type DataGathererDiscovery struct {
 	// The 'discovery' client used for fetching data.
 	cl discovery.DiscoveryClient
+	// listMetadata lists the objects checked for deprecated APIs, it is
+	// only set when deprecated APIs are detected.
+	listMetadata metadataLister
+	// deprecatedAPIsInterval is how long the last detection of the
+	// deprecated APIs is reported before the objects are listed again.
+	deprecatedAPIsInterval time.Duration
+
+	// mu guards the last detection of the deprecated APIs.
+	mu                  sync.Mutex
+	deprecatedAPIs      []*DeprecatedAPIUsage
+	deprecatedAPIIssues []*api.DataReadingIssue
+	deprecatedAPIsTime  time.Time
 }
 
 func (g *DataGathererDiscovery) Run(stopCh <-chan struct{}) error {
@@ -61,6 +110,11 @@ func (g *DataGathererDiscovery) Delete() error {
 	return nil
 }
 
+func (g *DataGathererDiscovery) Healthy() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
 // Fetch will fetch discovery data from the apiserver, or return an error
 func (g *DataGathererDiscovery) Fetch() (interface{}, error) {
 	data, err := g.cl.ServerVersion()
@@ -73,5 +127,39 @@ func (g *DataGathererDiscovery) Fetch() (interface{}, error) {
 		"server_version": data,
 	}
 
+	if g.listMetadata != nil {
+		usages, err := g.detectDeprecatedAPIs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect deprecated APIs: %v", err)
+		}
+		response["deprecated_apis"] = usages
+	}
+
 	return response, nil
 }
+
+// detectDeprecatedAPIs returns the deprecated API usages, detected again
+// once the last detection is older than the interval.
+func (g *DataGathererDiscovery) detectDeprecatedAPIs() ([]*DeprecatedAPIUsage, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.deprecatedAPIsTime.IsZero() && time.Since(g.deprecatedAPIsTime) < g.deprecatedAPIsInterval {
+		return g.deprecatedAPIs, nil
+	}
+	usages, issues, err := deprecatedAPIUsages(&g.cl, g.listMetadata)
+	if err != nil {
+		return nil, err
+	}
+	g.deprecatedAPIs = usages
+	g.deprecatedAPIIssues = issues
+	g.deprecatedAPIsTime = time.Now()
+	return usages, nil
+}
+
+// Issues reports the resource types which failed to be listed by the last
+// detection of the deprecated APIs.
+func (g *DataGathererDiscovery) Issues() []*api.DataReadingIssue {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.deprecatedAPIIssues
+}
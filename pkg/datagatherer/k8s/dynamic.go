@@ -3,47 +3,160 @@ package k8s
 import (
 	"context"
 	"fmt"
-	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jetstack/preflight/api"
 	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/jetstack/preflight/pkg/metrics"
 	"github.com/pkg/errors"
 	"github.com/pmylund/go-cache"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
 	k8scache "k8s.io/client-go/tools/cache"
 )
 
+// informerResyncPeriod is how often the informers deliver their resources to
+// the event handlers again, which keeps them from expiring from the cache.
+var informerResyncPeriod = 60 * time.Second
+
 // ConfigDynamic contains the configuration for the data-gatherer.
 type ConfigDynamic struct {
 	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
 	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
 	// GroupVersionResource identifies the resource type to gather.
 	GroupVersionResource schema.GroupVersionResource
+	// GroupVersionResources identifies several resource types to gather using
+	// a single informer factory and cache. It cannot be used together with
+	// GroupVersionResource.
+	GroupVersionResources []schema.GroupVersionResource
 	// ExcludeNamespaces is a list of namespaces to exclude.
 	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
-	// IncludeNamespaces is a list of namespaces to include.
+	// IncludeNamespaces is a list of namespaces to include. The resources
+	// of each namespace are watched separately, so that the agent only needs
+	// to be granted these namespaces.
 	IncludeNamespaces []string `yaml:"include-namespaces"`
+	// NamespaceSyncConcurrency is the number of included namespaces whose
+	// resources are listed at the same time when the data gatherer starts.
+	// Defaults to 4.
+	NamespaceSyncConcurrency int `yaml:"namespace-sync-concurrency"`
+	// Incremental makes Fetch return only the resources added, updated or
+	// deleted since the previous Fetch, with a periodic full snapshot.
+	Incremental bool `yaml:"incremental"`
+	// FullResyncInterval is how often a full snapshot is returned in
+	// incremental mode, defaults to one hour.
+	FullResyncInterval time.Duration `yaml:"full-resync-interval"`
+	// ExcludeLabels are labels that resources must all have to be excluded.
+	// Resources with the ExcludeAnnotation are always excluded.
+	ExcludeLabels map[string]string `yaml:"exclude-labels"`
+	// PruneMetadata removes metadata from all the gathered resources.
+	PruneMetadata *MetadataPruning `yaml:"prune-metadata"`
+	// Fields, if set, limits the data uploaded for each resource to the
+	// selected sub-trees, see projectionFields for the supported expressions.
+	Fields []string `yaml:"fields"`
+	// NamespaceLabelSelector limits the resources gathered to the namespaces
+	// matching the label selector. Namespaces are watched so that the
+	// selection follows namespaces as they are created, deleted or relabelled.
+	NamespaceLabelSelector string `yaml:"namespace-label-selector"`
+	// CachePath, if set, is a file the cache is saved to after every Fetch
+	// and restored from when the data gatherer starts, so that deletions are
+	// not lost across agent restarts.
+	CachePath string `yaml:"cache-path"`
+	// DeletedResourceTTL is how long deleted resources are kept in the cache,
+	// and reported as deleted, before being evicted. It defaults to the
+	// cache expiration of five minutes.
+	DeletedResourceTTL time.Duration `yaml:"deleted-resource-ttl"`
+	// MaxItems, if set, is the maximum number of resources returned by a
+	// Fetch. Resources beyond the limit are left out.
+	MaxItems int `yaml:"max-items"`
+	// MaxObjectBytes, if set, is the maximum size of a resource encoded as
+	// JSON. Larger resources are reduced to the fields identifying them.
+	MaxObjectBytes int `yaml:"max-object-bytes"`
+	// MetadataOnly watches the metadata of the resources only, i.e. their
+	// names, namespaces, labels, annotations and owner references, using the
+	// metadata API.
+	MetadataOnly bool `yaml:"metadata-only"`
+	// Transforms are applied in order to every resource before it enters the
+	// cache, to drop resources or to remove, rename and derive fields.
+	Transforms []Transform `yaml:"transforms"`
+	// RemovePaths are JSON pointers of fields removed from the resources as
+	// they are listed and watched, as RFC 6902 remove operations, so that the
+	// removed fields never take memory in the informers nor in the cache.
+	RemovePaths []string `yaml:"remove-paths"`
+	// PollInterval, if set, makes the data gatherer list the resources every
+	// interval instead of watching them, which saves watches for the
+	// resources that rarely change.
+	PollInterval time.Duration `yaml:"poll-interval"`
+	// PollResourceTypes are the resource types polled when PollInterval is
+	// set, the other resource types are watched. All the resource types are
+	// polled if it is empty.
+	PollResourceTypes []schema.GroupVersionResource `yaml:"poll-resource-types"`
+	// RelistInterval, if set, is how often the watched resources are listed
+	// again to reconcile the cache with the cluster, in case the informers
+	// missed some events.
+	RelistInterval time.Duration `yaml:"relist-interval"`
+	// ClientOptions tune the rate of the requests to the API server and the
+	// page size of the lists.
+	ClientOptions ClientOptions `yaml:",inline"`
+}
+
+// resourceType is the config file representation of a GroupVersionResource.
+type resourceType struct {
+	Group    string `yaml:"group"`
+	Version  string `yaml:"version"`
+	Resource string `yaml:"resource"`
+}
+
+func (r resourceType) groupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{
+		Group:    r.Group,
+		Version:  r.Version,
+		Resource: r.Resource,
+	}
 }
 
 // UnmarshalYAML unmarshals the ConfigDynamic resolving GroupVersionResource.
 func (c *ConfigDynamic) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	aux := struct {
-		KubeConfigPath string `yaml:"kubeconfig"`
-		ResourceType   struct {
-			Group    string `yaml:"group"`
-			Version  string `yaml:"version"`
-			Resource string `yaml:"resource"`
-		} `yaml:"resource-type"`
-		ExcludeNamespaces []string `yaml:"exclude-namespaces"`
-		IncludeNamespaces []string `yaml:"include-namespaces"`
+		KubeConfigPath           string            `yaml:"kubeconfig"`
+		KubeConfigContext        string            `yaml:"kubeconfig-context"`
+		ResourceType             resourceType      `yaml:"resource-type"`
+		ResourceTypes            []resourceType    `yaml:"resource-types"`
+		ExcludeNamespaces        []string          `yaml:"exclude-namespaces"`
+		IncludeNamespaces        []string          `yaml:"include-namespaces"`
+		NamespaceSyncConcurrency int               `yaml:"namespace-sync-concurrency"`
+		Incremental              bool              `yaml:"incremental"`
+		FullResyncInterval       time.Duration     `yaml:"full-resync-interval"`
+		ExcludeLabels            map[string]string `yaml:"exclude-labels"`
+		PruneMetadata            *MetadataPruning  `yaml:"prune-metadata"`
+		Fields                   []string          `yaml:"fields"`
+		NamespaceLabelSelector   string            `yaml:"namespace-label-selector"`
+		CachePath                string            `yaml:"cache-path"`
+		DeletedResourceTTL       time.Duration     `yaml:"deleted-resource-ttl"`
+		MaxItems                 int               `yaml:"max-items"`
+		MaxObjectBytes           int               `yaml:"max-object-bytes"`
+		MetadataOnly             bool              `yaml:"metadata-only"`
+		Transforms               []Transform       `yaml:"transforms"`
+		RemovePaths              []string          `yaml:"remove-paths"`
+		PollInterval             time.Duration     `yaml:"poll-interval"`
+		PollResourceTypes        []resourceType    `yaml:"poll-resource-types"`
+		RelistInterval           time.Duration     `yaml:"relist-interval"`
+		ClientOptions            ClientOptions     `yaml:",inline"`
 	}{}
 	err := unmarshal(&aux)
 	if err != nil {
@@ -51,11 +164,34 @@ func (c *ConfigDynamic) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	}
 
 	c.KubeConfigPath = aux.KubeConfigPath
-	c.GroupVersionResource.Group = aux.ResourceType.Group
-	c.GroupVersionResource.Version = aux.ResourceType.Version
-	c.GroupVersionResource.Resource = aux.ResourceType.Resource
+	c.KubeConfigContext = aux.KubeConfigContext
+	c.GroupVersionResource = aux.ResourceType.groupVersionResource()
+	for _, r := range aux.ResourceTypes {
+		c.GroupVersionResources = append(c.GroupVersionResources, r.groupVersionResource())
+	}
 	c.ExcludeNamespaces = aux.ExcludeNamespaces
 	c.IncludeNamespaces = aux.IncludeNamespaces
+	c.NamespaceSyncConcurrency = aux.NamespaceSyncConcurrency
+	c.Incremental = aux.Incremental
+	c.FullResyncInterval = aux.FullResyncInterval
+	c.ExcludeLabels = aux.ExcludeLabels
+	c.PruneMetadata = aux.PruneMetadata
+	c.Fields = aux.Fields
+	c.NamespaceLabelSelector = aux.NamespaceLabelSelector
+	c.CachePath = aux.CachePath
+	c.DeletedResourceTTL = aux.DeletedResourceTTL
+	c.MaxItems = aux.MaxItems
+	c.MaxObjectBytes = aux.MaxObjectBytes
+	c.MetadataOnly = aux.MetadataOnly
+	c.Transforms = aux.Transforms
+	c.RemovePaths = aux.RemovePaths
+	c.PollInterval = aux.PollInterval
+	c.RelistInterval = aux.RelistInterval
+	c.ClientOptions = aux.ClientOptions
+	c.PollResourceTypes = nil
+	for _, r := range aux.PollResourceTypes {
+		c.PollResourceTypes = append(c.PollResourceTypes, r.groupVersionResource())
+	}
 
 	return nil
 }
@@ -67,10 +203,79 @@ func (c *ConfigDynamic) validate() error {
 		errors = append(errors, "cannot set excluded and included namespaces")
 	}
 
-	if c.GroupVersionResource.Resource == "" {
+	if len(c.GroupVersionResources) > 0 {
+		if c.GroupVersionResource.Resource != "" {
+			errors = append(errors, "invalid configuration: GroupVersionResource and GroupVersionResources cannot be used at the same time")
+		}
+		for i, gvr := range c.GroupVersionResources {
+			if gvr.Resource == "" {
+				errors = append(errors, fmt.Sprintf("invalid configuration: GroupVersionResources[%d].Resource cannot be empty", i))
+			}
+		}
+	} else if c.GroupVersionResource.Resource == "" {
 		errors = append(errors, "invalid configuration: GroupVersionResource.Resource cannot be empty")
 	}
 
+	if c.NamespaceSyncConcurrency < 0 {
+		errors = append(errors, "invalid configuration: NamespaceSyncConcurrency cannot be negative")
+	}
+	if c.FullResyncInterval < 0 {
+		errors = append(errors, "invalid configuration: FullResyncInterval cannot be negative")
+	}
+	if c.DeletedResourceTTL < 0 {
+		errors = append(errors, "invalid configuration: DeletedResourceTTL cannot be negative")
+	}
+	if c.MaxItems < 0 {
+		errors = append(errors, "invalid configuration: MaxItems cannot be negative")
+	}
+	if c.MaxObjectBytes < 0 {
+		errors = append(errors, "invalid configuration: MaxObjectBytes cannot be negative")
+	}
+	if c.PollInterval < 0 {
+		errors = append(errors, "invalid configuration: PollInterval cannot be negative")
+	}
+	if len(c.PollResourceTypes) > 0 && c.PollInterval == 0 {
+		errors = append(errors, "invalid configuration: PollResourceTypes requires a PollInterval")
+	}
+	if c.RelistInterval < 0 {
+		errors = append(errors, "invalid configuration: RelistInterval cannot be negative")
+	}
+	if err := c.ClientOptions.Validate(); err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	if c.PruneMetadata != nil {
+		if err := c.PruneMetadata.validate(); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+
+	if _, err := projectionFields(c.Fields); err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	for i := range c.Transforms {
+		if err := c.Transforms[i].validate(); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+
+	if _, err := removePaths(c.RemovePaths); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if len(c.RemovePaths) > 0 && c.MetadataOnly {
+		errors = append(errors, "invalid configuration: RemovePaths cannot be used with MetadataOnly")
+	}
+
+	if c.NamespaceLabelSelector != "" {
+		if len(c.IncludeNamespaces) > 0 {
+			errors = append(errors, "cannot set included namespaces and a namespace label selector")
+		}
+		if _, err := labels.Parse(c.NamespaceLabelSelector); err != nil {
+			errors = append(errors, fmt.Sprintf("invalid configuration: invalid namespace label selector: %s", err))
+		}
+	}
+
 	if len(errors) > 0 {
 		return fmt.Errorf(strings.Join(errors, ", "))
 	}
@@ -78,60 +283,277 @@ func (c *ConfigDynamic) validate() error {
 	return nil
 }
 
+// ResourceTypes returns all the GroupVersionResources gathered with this
+// configuration.
+func (c *ConfigDynamic) ResourceTypes() []schema.GroupVersionResource {
+	if len(c.GroupVersionResources) > 0 {
+		return c.GroupVersionResources
+	}
+	return []schema.GroupVersionResource{c.GroupVersionResource}
+}
+
 // NewDataGatherer constructs a new instance of the generic K8s data-gatherer for the provided
 // GroupVersionResource.
 func (c *ConfigDynamic) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
-	cl, err := NewDynamicClient(c.KubeConfigPath)
+	cl, err := NewDynamicClientWithOptions(c.KubeConfigPath, c.KubeConfigContext, c.ClientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadataClient metadata.Interface
+	if c.MetadataOnly {
+		metadataClient, err = NewMetadataClientWithOptions(c.KubeConfigPath, c.KubeConfigContext, c.ClientOptions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the kinds of the resources are looked up with the discovery API in
+	// metadata only mode, and the scope of the resource types with a
+	// namespace label selector
+	concrete, patterns := splitResourcePatterns(c.ResourceTypes())
+	var discoveryClient discovery.DiscoveryInterface
+	if len(patterns) > 0 || c.MetadataOnly || c.NamespaceLabelSelector != "" {
+		client, err := NewDiscoveryClientWithOptions(c.KubeConfigPath, c.KubeConfigContext, c.ClientOptions)
+		if err != nil {
+			return nil, err
+		}
+		discoveryClient = &client
+	}
+	var clusterScoped map[schema.GroupVersionResource]bool
+	if c.NamespaceLabelSelector != "" {
+		clusterScoped, err = discoverClusterScoped(discoveryClient, concrete)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dg, err := c.newDataGathererWithClients(ctx, cl, metadataClient, clusterScoped)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.newDataGathererWithClient(ctx, cl)
+	dynamicDg := dg.(*DataGathererDynamic)
+	dynamicDg.discoveryClient = discoveryClient
+	dynamicDg.kubeconfigContext = kubeconfigContext(c.KubeConfigPath, c.KubeConfigContext)
+
+	return dg, nil
 }
 
 func (c *ConfigDynamic) newDataGathererWithClient(ctx context.Context, cl dynamic.Interface) (datagatherer.DataGatherer, error) {
+	return c.newDataGathererWithClients(ctx, cl, nil, nil)
+}
+
+// newDataGathererWithClients constructs the data gatherer, its informers use
+// the metadata client if one is provided and the dynamic client otherwise.
+// clusterScoped are the resource types which are not namespaced, it is only
+// used with a namespace label selector.
+func (c *ConfigDynamic) newDataGathererWithClients(ctx context.Context, cl dynamic.Interface, metadataClient metadata.Interface, clusterScoped map[schema.GroupVersionResource]bool) (datagatherer.DataGatherer, error) {
 	if err := c.validate(); err != nil {
 		return nil, err
 	}
 
+	// the resources are redacted and the removed paths removed as they are
+	// listed and watched, so that the Secret data never enters the informers.
+	// The namespaces are watched with the client as is.
+	namespaceClient := cl
+	// the paths have already been validated
+	paths, _ := removePaths(c.RemovePaths)
+	cl = newIngestingClient(cl, newIngestFunc(paths))
+
 	// init shared informer for selected namespaces
 	fieldSelector := generateFieldSelector(c.ExcludeNamespaces)
-	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
-		cl,
-		60*time.Second,
-		metav1.NamespaceAll,
-		func(options *metav1.ListOptions) { options.FieldSelector = fieldSelector },
-	)
-	resourceInformer := factory.ForResource(c.GroupVersionResource)
-	informer := resourceInformer.Informer()
+	pageSize := c.ClientOptions.withDefaults().PageSize
+	tweakListOptions := func(options *metav1.ListOptions) {
+		options.FieldSelector = fieldSelector
+		// the limit is only used by paginated lists, watches ignore it
+		if pageSize > 0 {
+			options.Limit = pageSize
+		}
+	}
+	newFactory := func(namespace string) informerFactory {
+		if metadataClient != nil {
+			return metadatainformer.NewFilteredSharedInformerFactory(metadataClient, informerResyncPeriod, namespace, tweakListOptions)
+		}
+		return dynamicinformer.NewFilteredDynamicSharedInformerFactory(cl, informerResyncPeriod, namespace, tweakListOptions)
+	}
+	var factory, clusterFactory informerFactory
+	switch {
+	case len(c.IncludeNamespaces) > 0:
+		factory = newShardedInformerFactory(c.IncludeNamespaces, c.NamespaceSyncConcurrency, newFactory)
+	case c.NamespaceLabelSelector != "":
+		// the namespaced resources are only listed and watched in the
+		// selected namespaces, which are added as shards as they are
+		// selected, and the cluster scoped resources across the cluster
+		factory = newShardedInformerFactory(nil, c.NamespaceSyncConcurrency, newFactory)
+		clusterFactory = newFactory(metav1.NamespaceAll)
+	default:
+		factory = newFactory(metav1.NamespaceAll)
+	}
 
 	// init cache to store gathered resources
 	dgCache := cache.New(5*time.Minute, 30*time.Second)
 
+	var projection []string
+	if len(c.Fields) > 0 {
+		// the expressions have already been validated
+		projection, _ = projectionFields(c.Fields)
+	}
+
+	gvrs, patterns := splitResourcePatterns(c.ResourceTypes())
 	newDataGatherer := &DataGathererDynamic{
-		ctx:                  ctx,
-		cl:                   cl,
-		groupVersionResource: c.GroupVersionResource,
-		fieldSelector:        fieldSelector,
-		namespaces:           c.IncludeNamespaces,
-		cache:                dgCache,
-		sharedInformer:       factory,
-		informer:             informer,
+		ctx:                ctx,
+		cl:                 cl,
+		fieldSelector:      fieldSelector,
+		namespaces:         c.IncludeNamespaces,
+		cache:              dgCache,
+		sharedInformer:     factory,
+		clusterInformer:    clusterFactory,
+		clusterScoped:      clusterScoped,
+		informers:          map[schema.GroupVersionResource]k8scache.SharedIndexInformer{},
+		resourceTypeIndex:  map[string]schema.GroupVersionResource{},
+		health:             map[schema.GroupVersionResource]*resourceTypeHealth{},
+		resourcePatterns:   patterns,
+		incremental:        c.Incremental,
+		pruneMetadata:      c.PruneMetadata,
+		projection:         projection,
+		cachePath:          c.CachePath,
+		deletedResourceTTL: c.DeletedResourceTTL,
+		maxItems:           c.MaxItems,
+		maxObjectBytes:     c.MaxObjectBytes,
+		metadataOnly:       metadataClient != nil,
+		transforms:         c.Transforms,
+		metadataClient:     metadataClient,
+		pollInterval:       c.PollInterval,
+		pollResourceTypes:  c.PollResourceTypes,
+		relistInterval:     c.RelistInterval,
+		pageSize:           pageSize,
+		kinds:              map[schema.GroupVersionResource]string{},
+	}
+	if c.NamespaceLabelSelector != "" {
+		newDataGatherer.namespaceInformer = newNamespaceInformer(namespaceClient, c.NamespaceLabelSelector)
+		newDataGatherer.followSelectedNamespaces()
+	}
+	if len(c.ExcludeLabels) > 0 {
+		newDataGatherer.excludeSelector = labels.SelectorFromSet(c.ExcludeLabels)
+	}
+	if c.Incremental {
+		newDataGatherer.dirty = map[string]uint64{}
+		newDataGatherer.fullResyncInterval = c.FullResyncInterval
+		if newDataGatherer.fullResyncInterval == 0 {
+			newDataGatherer.fullResyncInterval = defaultFullResyncInterval
+		}
+	}
+	if len(gvrs) > 0 {
+		newDataGatherer.groupVersionResource = gvrs[0]
+	}
+	if len(c.GroupVersionResources) > 0 || len(patterns) > 0 {
+		newDataGatherer.groupVersionResources = gvrs
 	}
 
+	// keep the resource type index in line with the cache contents
+	dgCache.OnEvicted(func(key string, _ interface{}) {
+		newDataGatherer.resourceTypeIndexMu.Lock()
+		defer newDataGatherer.resourceTypeIndexMu.Unlock()
+		delete(newDataGatherer.resourceTypeIndex, key)
+		newDataGatherer.forgetDirty(key)
+	})
+
+	for _, gvr := range gvrs {
+		newDataGatherer.addInformer(gvr)
+	}
+
+	return newDataGatherer, nil
+}
+
+// addInformer creates an informer for the resource type in the shared
+// informer factory, feeding the data gatherer's cache.
+func (g *DataGathererDynamic) addInformer(gvr schema.GroupVersionResource) {
+	var informer k8scache.SharedIndexInformer
+	polled := g.isPolled(gvr)
+	if polled {
+		informer = g.newPollingInformer(gvr)
+		g.pollingInformers = append(g.pollingInformers, informer)
+	} else if g.clusterInformer != nil && g.clusterScoped[gvr] {
+		informer = g.clusterInformer.ForResource(gvr).Informer()
+	} else {
+		informer = g.sharedInformer.ForResource(gvr).Informer()
+	}
+	if g.informer == nil {
+		g.informer = informer
+	}
+	g.informers[gvr] = informer
+
 	informer.AddEventHandler(k8scache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			onAdd(obj, dgCache)
+			obj = g.toUnstructured(obj, gvr)
+			g.markSynced(gvr)
+			if g.isExcluded(obj) {
+				return
+			}
+			transformed, keep := g.transform(obj)
+			if !keep {
+				return
+			}
+			g.indexResourceType(obj, gvr)
+			if !g.isRestoredUnchanged(obj) {
+				g.markDirty(obj)
+			}
+			onAdd(transformed, g.cache)
+			if polled {
+				g.retainPolled(transformed)
+			}
 		},
 		UpdateFunc: func(old, new interface{}) {
-			onUpdate(old, new, dgCache)
+			old, new = g.toUnstructured(old, gvr), g.toUnstructured(new, gvr)
+			g.markSynced(gvr)
+			if isResync(old, new) {
+				metrics.InformerResyncs.WithLabelValues(ResourceTypeKey(gvr)).Inc()
+			}
+			if g.isExcluded(new) {
+				g.removeExcluded(new)
+				return
+			}
+			transformed, keep := g.transform(new)
+			if !keep {
+				g.removeExcluded(new)
+				return
+			}
+			g.indexResourceType(new, gvr)
+			g.markUpdated(old, new)
+			onUpdate(old, transformed, g.cache)
+			if polled {
+				g.retainPolled(transformed)
+			}
 		},
 		DeleteFunc: func(obj interface{}) {
-			onDelete(obj, dgCache)
+			// resources found missing when listing again are delivered
+			// as tombstones
+			if tombstone, ok := obj.(k8scache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			obj = g.toUnstructured(obj, gvr)
+			g.markSynced(gvr)
+			if g.isExcluded(obj) {
+				return
+			}
+			transformed, keep := g.transform(obj)
+			if !keep {
+				return
+			}
+			g.indexResourceType(obj, gvr)
+			g.markDirty(obj)
+			onDelete(transformed, g.cache)
+			g.retainTombstone(obj)
 		},
 	})
+}
 
-	return newDataGatherer, nil
+// informerFactory is implemented by the dynamic and metadata shared informer
+// factories.
+type informerFactory interface {
+	ForResource(gvr schema.GroupVersionResource) informers.GenericInformer
+	Start(stopCh <-chan struct{})
 }
 
 // DataGathererDynamic is a generic gatherer for Kubernetes. It knows how to request
@@ -147,10 +569,15 @@ type DataGathererDynamic struct {
 	// groupVersionResource is the name of the API group, version and resource
 	// that should be fetched by this data gatherer.
 	groupVersionResource schema.GroupVersionResource
+	// groupVersionResources is set when the data gatherer fetches more than
+	// one resource type, in that case the Fetch output is keyed by resource
+	// type.
+	groupVersionResources []schema.GroupVersionResource
 	// namespace, if specified, limits the namespace of the resources returned.
 	// This field *must* be omitted when the groupVersionResource refers to a
-	// non-namespaced resource.
-	namespaces []string
+	// non-namespaced resource. It is changed by SetNamespaces.
+	namespaces   []string
+	namespacesMu sync.RWMutex
 	// fieldSelector is a field selector string used to filter resources
 	// returned by the Kubernetes API.
 	// https://kubernetes.io/docs/concepts/overview/working-with-objects/field-selectors/
@@ -159,16 +586,113 @@ type DataGathererDynamic struct {
 	// 30 seconds purge time https://pkg.go.dev/github.com/patrickmn/go-cache
 	cache *cache.Cache
 	// informer watches the events around the targeted resource and updates the cache
-	informer       k8scache.SharedIndexInformer
-	sharedInformer dynamicinformer.DynamicSharedInformerFactory
-	informerCtx    context.Context
-	informerCancel context.CancelFunc
+	informer k8scache.SharedIndexInformer
+	// informers contains the informer for each of the resource types, they
+	// all share the same factory and cache.
+	informers      map[schema.GroupVersionResource]k8scache.SharedIndexInformer
+	sharedInformer informerFactory
+	// resourcePatterns are resource types containing wildcards, they are
+	// resolved using the discovery client when the data gatherer is started.
+	resourcePatterns []schema.GroupVersionResource
+	// discoveryClient is used to resolve resourcePatterns.
+	discoveryClient discovery.DiscoveryInterface
+	// resourceTypeIndex maps the cache key of each resource to the resource
+	// type it was gathered for.
+	resourceTypeIndex   map[string]schema.GroupVersionResource
+	resourceTypeIndexMu sync.RWMutex
+	informerCtx         context.Context
+	informerCancel      context.CancelFunc
+
+	// health tracks the sync status of the informer of each resource type.
+	health   map[schema.GroupVersionResource]*resourceTypeHealth
+	healthMu sync.Mutex
+	// truncated is the truncation of the last Fetch, guarded by healthMu.
+	truncated truncation
+
+	// incremental is set when Fetch only returns the resources that changed
+	// since the changes last committed, tracked in dirty by the informer
+	// handlers along with the generation of their last change. fetched is
+	// the delta of the last Fetch, dropped from dirty once committed.
+	incremental        bool
+	fullResyncInterval time.Duration
+	lastFullFetch      time.Time
+	dirty              map[string]uint64
+	generation         uint64
+	fetched            *fetchedDelta
+	dirtyMu            sync.Mutex
+
+	// excludeSelector matches the resources excluded from the cache by
+	// label, it is nil if no labels are configured.
+	excludeSelector labels.Selector
+	// pruneMetadata, if set, is applied to all the resources returned.
+	pruneMetadata *MetadataPruning
+	// projection, if set, are the only fields of the resources returned.
+	projection []string
+	// transforms are applied to the resources before they enter the cache.
+	transforms []Transform
+
+	// metadataClient is used by the polling informers in metadata only mode.
+	metadataClient metadata.Interface
+	// pollInterval, if set, is how often the resources of pollResourceTypes,
+	// or of all the resource types if empty, are listed. pollingInformers
+	// are the informers of the polled resource types, they are not part of
+	// the shared informer factory.
+	pollInterval      time.Duration
+	pollResourceTypes []schema.GroupVersionResource
+	pollingInformers  []k8scache.SharedIndexInformer
+	// relistInterval, if set, is how often the watched resource types are
+	// listed again to reconcile the cache, see relist.
+	relistInterval time.Duration
+	// pageSize, if set, is the number of resources per page when listing.
+	pageSize int64
+
+	// namespaceInformer watches the namespaces matching the namespace label
+	// selector, if one is configured. The shards of the shared informer
+	// factory follow the selected namespaces, and the cluster scoped
+	// resource types of clusterScoped are watched by clusterInformer.
+	namespaceInformer k8scache.SharedIndexInformer
+	clusterInformer   informerFactory
+	clusterScoped     map[schema.GroupVersionResource]bool
+
+	// kubeconfigContext is the kubeconfig context used by the client.
+	kubeconfigContext string
+
+	// cachePath is the file the cache is persisted to, if any. restored
+	// holds the resources restored from it that the informers have not
+	// listed yet.
+	cachePath  string
+	restored   map[string]*api.GatheredResource
+	restoredMu sync.Mutex
+
+	// deletedResourceTTL is how long deleted resources stay in the cache,
+	// the cache default expiration is used if it is zero.
+	deletedResourceTTL time.Duration
+
+	// maxItems and maxObjectBytes limit the size of the Fetch output, they
+	// are disabled when zero.
+	maxItems       int
+	maxObjectBytes int
+
+	// metadataOnly is set when the informers use the metadata API, in which
+	// case kinds maps each resource type to the kind of its resources, as
+	// the metadata API does not report it.
+	metadataOnly bool
+	kinds        map[schema.GroupVersionResource]string
+
+	// cacheBytes is the approximate size of the cache as of the last
+	// Fetch, it is accessed atomically.
+	cacheBytes int64
 
 	// isInitialized is set to true when data is first collected, prior to
 	// this the fetch method will return an error
 	isInitialized bool
 }
 
+// KubeconfigContext returns the kubeconfig context used to gather resources.
+func (g *DataGathererDynamic) KubeconfigContext() string {
+	return g.kubeconfigContext
+}
+
 // Run starts the dynamic data gatherer's informers for resource collection.
 // Returns error if the data gatherer informer wasn't initialized
 func (g *DataGathererDynamic) Run(stopCh <-chan struct{}) error {
@@ -176,6 +700,23 @@ func (g *DataGathererDynamic) Run(stopCh <-chan struct{}) error {
 		return fmt.Errorf("informer was not initialized, impossible to start")
 	}
 
+	if len(g.resourcePatterns) > 0 {
+		if err := g.resolveResourcePatterns(); err != nil {
+			return err
+		}
+	}
+
+	if g.metadataOnly {
+		g.resolveKinds()
+	}
+
+	if g.cachePath != "" {
+		// a missing or corrupt snapshot only means starting from scratch
+		if err := g.restoreCache(); err != nil {
+			logs.Log.Errorf("failed to restore the cache of datagatherer from %q: %v", g.cachePath, err)
+		}
+	}
+
 	// starting a new ctx for the informer
 	// WithCancel copies the parent ctx and creates a new done() channel
 	informerCtx, cancel := context.WithCancel(g.ctx)
@@ -183,21 +724,43 @@ func (g *DataGathererDynamic) Run(stopCh <-chan struct{}) error {
 	g.informerCancel = cancel
 
 	// attach WatchErrorHandler, it needs to be set before starting an informer
-	err := g.informer.SetWatchErrorHandler(func(r *k8scache.Reflector, err error) {
-		if strings.Contains(fmt.Sprintf("%s", err), "the server could not find the requested resource") {
-			log.Printf("server missing resource for datagatherer of %q ", g.groupVersionResource)
-		} else {
-			log.Printf("datagatherer informer for %q hash failed and is backing off due to error: %s", g.groupVersionResource, err)
-		}
-		// cancel the informer ctx to stop the informer in case of error
-		cancel()
-	})
-	if err != nil {
-		return fmt.Errorf("failed to SetWatchErrorHandler on informer: %s", err)
+	for gvr, informer := range g.informers {
+		gvr := gvr
+		err := informer.SetWatchErrorHandler(func(r *k8scache.Reflector, err error) {
+			delay := g.watchError(gvr, err)
+			log := logs.Log.WithField(logs.ResourceField, ResourceTypeKey(gvr))
+			if strings.Contains(fmt.Sprintf("%s", err), "the server could not find the requested resource") {
+				log.Warnf("server missing resource for datagatherer of %q, retrying in %s", gvr, delay)
+			} else {
+				log.Warnf("datagatherer informer for %q has failed and is backing off for %s due to error: %s", gvr, delay, err)
+			}
+			// the handler is called before the informer lists and watches
+			// again, so waiting here delays the retry
+			select {
+			case <-time.After(delay):
+			case <-stopCh:
+			case <-informerCtx.Done():
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to SetWatchErrorHandler on informer: %s", err)
+		}
 	}
 
 	// start shared informer
 	g.sharedInformer.Start(stopCh)
+	if g.clusterInformer != nil {
+		g.clusterInformer.Start(stopCh)
+	}
+	for _, informer := range g.pollingInformers {
+		go informer.Run(stopCh)
+	}
+	if g.namespaceInformer != nil {
+		go g.namespaceInformer.Run(stopCh)
+	}
+	if g.relistInterval > 0 {
+		go g.runRelist(stopCh)
+	}
 
 	return nil
 }
@@ -205,7 +768,19 @@ func (g *DataGathererDynamic) Run(stopCh <-chan struct{}) error {
 // WaitForCacheSync waits for the data gatherer's informers cache to sync
 // before collecting the resources.
 func (g *DataGathererDynamic) WaitForCacheSync(stopCh <-chan struct{}) error {
-	if !k8scache.WaitForCacheSync(stopCh, g.informer.HasSynced) {
+	if g.namespaceInformer != nil {
+		// the informers are only scoped to the selected namespaces once
+		// they are known
+		if !k8scache.WaitForCacheSync(stopCh, g.namespaceInformer.HasSynced) {
+			return fmt.Errorf("timed out waiting for the namespaces to sync, using parent stop channel")
+		}
+		g.scopeSelectedNamespaces()
+	}
+	var hasSynced []k8scache.InformerSynced
+	for _, informer := range g.informers {
+		hasSynced = append(hasSynced, informer.HasSynced)
+	}
+	if !k8scache.WaitForCacheSync(stopCh, hasSynced...) {
 		return fmt.Errorf("timed out waiting for caches to sync, using parent stop channel")
 	}
 
@@ -223,44 +798,243 @@ func (g *DataGathererDynamic) Delete() error {
 // Fetch will fetch the requested data from the apiserver, or return an error
 // if fetching the data fails.
 func (g *DataGathererDynamic) Fetch() (interface{}, error) {
-	if g.groupVersionResource.String() == "" {
-		return nil, fmt.Errorf("resource type must be specified")
+	var items = []*api.GatheredResource{}
+
+	full, truncated, err := g.stream(func(item *api.GatheredResource) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		g.discardDelta()
+		return nil, err
+	}
+	g.persistCache()
+	list := g.describe(full, truncated)
+
+	if len(g.groupVersionResources) == 0 {
+		// add gathered resources to items
+		list["items"] = items
+		return list, nil
+	}
+
+	// group gathered resources by resource type when fetching several types
+	resources := map[string]map[string]interface{}{}
+	for _, gvr := range g.groupVersionResources {
+		resources[ResourceTypeKey(gvr)] = map[string]interface{}{
+			"items": []*api.GatheredResource{},
+		}
+	}
+	for _, item := range items {
+		gvr, ok := g.resourceTypeOf(item)
+		if !ok {
+			continue
+		}
+		key := ResourceTypeKey(gvr)
+		resources[key]["items"] = append(resources[key]["items"].([]*api.GatheredResource), item)
+	}
+	list["resources"] = resources
+
+	return list, nil
+}
+
+// Streamable reports whether the resources can be streamed with
+// FetchStream. They cannot be when several resource types or patterns are
+// gathered, as they are grouped by resource type, nor in incremental mode, as
+// the changes streamed could not be told apart from a full snapshot until
+// the end.
+func (g *DataGathererDynamic) Streamable() bool {
+	return len(g.groupVersionResources) == 0 && len(g.resourcePatterns) == 0 && !g.incremental
+}
+
+// FetchStream calls fn for each of the resources Fetch would return, one at a
+// time, so that callers can process large caches without holding all the
+// resources in a single document. It returns the rest of the data Fetch
+// would return, such as the status of the resource type.
+func (g *DataGathererDynamic) FetchStream(fn func(*api.GatheredResource) error) (map[string]interface{}, error) {
+	if !g.Streamable() {
+		return nil, fmt.Errorf("the resources of %q cannot be streamed", g.groupVersionResource)
+	}
+	full, truncated, err := g.stream(fn)
+	if err != nil {
+		return nil, err
 	}
+	g.persistCache()
+	return g.describe(full, truncated), nil
+}
 
+// describe returns the data of a Fetch but the resources themselves.
+func (g *DataGathererDynamic) describe(full bool, truncated truncation) map[string]interface{} {
 	var list = map[string]interface{}{}
-	var items = []*api.GatheredResource{}
 
+	if truncated.any() {
+		// tell the backend some resources are missing or incomplete
+		list["truncated"] = truncated
+	}
+
+	if !full {
+		// tell the backend this is not a complete snapshot
+		list["delta"] = true
+	}
+
+	// report how up to date the resources of each type are
+	list["status"] = g.resourceTypeStatus()
+
+	return list
+}
+
+// persistCache saves the cache if a cache path is configured. Failing to
+// save it does not fail the Fetch.
+func (g *DataGathererDynamic) persistCache() {
+	if g.cachePath == "" {
+		return
+	}
+	if err := g.saveCache(); err != nil {
+		logs.Log.Errorf("failed to save the cache of datagatherer to %q: %v", g.cachePath, err)
+	}
+}
+
+// stream calls fn for every cached resource that has to be returned, after
+// redacting it. It reports whether the resources are a full snapshot or only
+// the changes since the previous call in incremental mode, and how many
+// resources were truncated because of the configured limits.
+func (g *DataGathererDynamic) stream(fn func(*api.GatheredResource) error) (bool, truncation, error) {
+	if g.groupVersionResource.String() == "" {
+		return false, truncation{}, fmt.Errorf("resource type must be specified")
+	}
+
+	g.namespacesMu.RLock()
 	fetchNamespaces := g.namespaces
+	g.namespacesMu.RUnlock()
 	if len(fetchNamespaces) == 0 {
 		// then they must have been looking for all namespaces
 		fetchNamespaces = []string{metav1.NamespaceAll}
 	}
 
+	selectedNamespaces := g.selectedNamespaces()
+
+	// report the restored resources deleted while the agent was stopped
+	g.reconcileRestored()
+
+	full := true
+	var dirty map[string]bool
+	if g.incremental {
+		full, dirty = g.takeDelta()
+	}
+
+	var truncated truncation
+
 	//delete expired items from the cache
 	g.cache.DeleteExpired()
-	for _, item := range g.cache.Items() {
+	items := g.cache.Items()
+	g.accountCache(items)
+	var selected []*api.GatheredResource
+	for _, item := range items {
 		// filter cache items by namespace
 		cacheObject := item.Object.(*api.GatheredResource)
 		resource, ok := cacheObject.Resource.(*unstructured.Unstructured)
 		if !ok {
-			return nil, fmt.Errorf("failed to parse cached resource")
+			return false, truncation{}, fmt.Errorf("failed to parse cached resource")
 		}
 		namespace := resource.GetNamespace()
-		if isIncludedNamespace(namespace, fetchNamespaces) {
-			items = append(items, cacheObject)
+		if !isIncludedNamespace(namespace, fetchNamespaces) {
+			continue
+		}
+		// the cluster scoped resources are not filtered by namespace
+		if selectedNamespaces != nil && namespace != "" && !selectedNamespaces[namespace] {
+			continue
+		}
+		if !full && !dirty[string(resource.GetUID())] {
+			continue
 		}
+		selected = append(selected, cacheObject)
+	}
+	if g.maxItems > 0 && len(selected) > g.maxItems {
+		// the same resources are kept from one Fetch to the next, rather
+		// than the ones the cache happens to list first
+		sortByNamespaceName(selected)
+		truncated.Items = len(selected) - g.maxItems
+		selected = selected[:g.maxItems]
 	}
 
-	// Redact Secret data
-	err := redactList(items)
-	if err != nil {
-		return nil, errors.WithStack(err)
+	for _, cacheObject := range selected {
+		// the cached resources are shared with the informers, they are
+		// copied before being redacted, pruned or projected
+		resource := cacheObject.Resource.(*unstructured.Unstructured).DeepCopy()
+		output := &api.GatheredResource{Resource: resource, DeletedAt: cacheObject.DeletedAt}
+
+		// Secret data is redacted as it is ingested, the labels and resource
+		// version kept for the informers are redacted here
+		if err := redactList([]*api.GatheredResource{output}); err != nil {
+			return false, truncation{}, errors.WithStack(err)
+		}
+		if g.pruneMetadata != nil {
+			g.pruneMetadata.Prune(resource)
+		}
+		if len(g.projection) > 0 {
+			if err := Select(g.projection, resource); err != nil {
+				return false, truncation{}, errors.WithStack(err)
+			}
+		}
+
+		limited, err := g.limitObjectSize(output)
+		if err != nil {
+			return false, truncation{}, err
+		}
+		if limited != output {
+			truncated.Objects++
+		}
+
+		if err := fn(limited); err != nil {
+			return false, truncation{}, err
+		}
 	}
 
-	// add gathered resources to items
-	list["items"] = items
+	g.healthMu.Lock()
+	g.truncated = truncated
+	g.healthMu.Unlock()
+	if truncated.any() {
+		logs.Log.WithField(logs.ResourceField, ResourceTypeKey(g.groupVersionResource)).Warnf("datagatherer for %q exceeded its limits, %d resource(s) left out and %d oversized resource(s) reduced to their identity", g.groupVersionResource, truncated.Items, truncated.Objects)
+	}
 
-	return list, nil
+	return full, truncated, nil
+}
+
+// indexResourceType records which resource type an object from the informer
+// belongs to.
+func (g *DataGathererDynamic) indexResourceType(obj interface{}, gvr schema.GroupVersionResource) {
+	item, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	uid := string(item.GetUID())
+	if uid == "" {
+		return
+	}
+	g.resourceTypeIndexMu.Lock()
+	defer g.resourceTypeIndexMu.Unlock()
+	g.resourceTypeIndex[uid] = gvr
+}
+
+// resourceTypeOf returns the resource type a cached resource was gathered for.
+func (g *DataGathererDynamic) resourceTypeOf(item *api.GatheredResource) (schema.GroupVersionResource, bool) {
+	resource, ok := item.Resource.(*unstructured.Unstructured)
+	if !ok {
+		return schema.GroupVersionResource{}, false
+	}
+	g.resourceTypeIndexMu.RLock()
+	defer g.resourceTypeIndexMu.RUnlock()
+	gvr, ok := g.resourceTypeIndex[string(resource.GetUID())]
+	return gvr, ok
+}
+
+// ResourceTypeKey formats a GroupVersionResource as resource.version.group,
+// e.g. certificates.v1.cert-manager.io or pods.v1 for core resources.
+func ResourceTypeKey(gvr schema.GroupVersionResource) string {
+	parts := []string{gvr.Resource, gvr.Version}
+	if gvr.Group != "" {
+		parts = append(parts, gvr.Group)
+	}
+	return strings.Join(parts, ".")
 }
 
 func redactList(list []*api.GatheredResource) error {
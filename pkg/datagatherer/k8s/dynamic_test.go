@@ -13,6 +13,7 @@ import (
 
 	"github.com/d4l3k/messagediff"
 	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
 	"gopkg.in/yaml.v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -111,7 +112,8 @@ func TestNewDataGathererWithClient(t *testing.T) {
 	if !reflect.DeepEqual(gatherer.ctx, expected.ctx) {
 		t.Errorf("unexpected ctx difference: %v", diff.ObjectDiff(dg, expected))
 	}
-	if !reflect.DeepEqual(gatherer.cl, expected.cl) {
+	// the client is wrapped to redact the resources as they are ingested
+	if ingesting, ok := gatherer.cl.(*ingestingClient); !ok || !reflect.DeepEqual(ingesting.Interface, expected.cl) {
 		t.Errorf("unexpected client difference: %v", diff.ObjectDiff(dg, expected))
 	}
 	if !reflect.DeepEqual(gatherer.groupVersionResource, expected.groupVersionResource) {
@@ -205,6 +207,24 @@ func TestConfigDynamicValidate(t *testing.T) {
 			},
 			ExpectedError: "cannot set excluded and included namespaces",
 		},
+		{
+			Config: ConfigDynamic{
+				GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+				GroupVersionResources: []schema.GroupVersionResource{
+					{Version: "v1", Resource: "services"},
+				},
+			},
+			ExpectedError: "GroupVersionResource and GroupVersionResources cannot be used at the same time",
+		},
+		{
+			Config: ConfigDynamic{
+				GroupVersionResources: []schema.GroupVersionResource{
+					{Version: "v1", Resource: "services"},
+					{Version: "v1"},
+				},
+			},
+			ExpectedError: "GroupVersionResources[1].Resource cannot be empty",
+		},
 	}
 
 	for _, test := range tests {
@@ -215,6 +235,177 @@ func TestConfigDynamicValidate(t *testing.T) {
 	}
 }
 
+func TestUnmarshalDynamicConfigResourceTypes(t *testing.T) {
+	textCfg := `
+resource-types:
+- version: v1
+  resource: pods
+- group: cert-manager.io
+  version: v1
+  resource: certificates
+`
+
+	expected := []schema.GroupVersionResource{
+		{Version: "v1", Resource: "pods"},
+		{Group: "cert-manager.io", Version: "v1", Resource: "certificates"},
+	}
+
+	cfg := ConfigDynamic{}
+	err := yaml.Unmarshal([]byte(textCfg), &cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if got := cfg.ResourceTypes(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("ResourceTypes does not match: got=%+v want=%+v", got, expected)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("unexpected validation error: %+v", err)
+	}
+}
+
+func TestResourceTypeKey(t *testing.T) {
+	tests := map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "pods"}:                                       "pods.v1",
+		{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}:     "certificates.v1.cert-manager.io",
+		{Group: "networking.k8s.io", Version: "v1beta1", Resource: "ingresses"}: "ingresses.v1beta1.networking.k8s.io",
+	}
+
+	for gvr, want := range tests {
+		if got := ResourceTypeKey(gvr); got != want {
+			t.Errorf("unexpected key for %v: got=%q want=%q", gvr, got, want)
+		}
+	}
+}
+
+func TestDynamicGatherer_FetchMultipleResourceTypes(t *testing.T) {
+	ctx := context.Background()
+	foos := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	bars := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "bars"}
+	gvrToListKind := map[schema.GroupVersionResource]string{
+		foos: "UnstructuredList",
+		bars: "UnstructuredList",
+	}
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), gvrToListKind,
+		getObject("foobar/v1", "Foo", "testfoo", "testns", false),
+		getObject("foobar/v1", "Bar", "testbar", "testns", false),
+	)
+
+	config := ConfigDynamic{
+		GroupVersionResources: []schema.GroupVersionResource{foos, bars},
+	}
+	dg, err := config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	res, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	resources, ok := res.(map[string]interface{})["resources"].(map[string]map[string]interface{})
+	if !ok {
+		t.Fatalf("expected resources to be keyed by resource type, got %#v", res)
+	}
+
+	for key, name := range map[string]string{"foos.v1.foobar": "testfoo", "bars.v1.foobar": "testbar"} {
+		items, ok := resources[key]["items"].([]*api.GatheredResource)
+		if !ok || len(items) != 1 {
+			t.Fatalf("expected one item for %s, got %#v", key, resources[key])
+		}
+		if got := items[0].Resource.(*unstructured.Unstructured).GetName(); got != name {
+			t.Errorf("unexpected item for %s: got=%q want=%q", key, got, name)
+		}
+	}
+}
+
+func TestDynamicGatherer_FetchStream(t *testing.T) {
+	foos := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	bars := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "bars"}
+
+	tests := map[string]struct {
+		config        ConfigDynamic
+		expectedNames []string
+		err           bool
+	}{
+		"single resource type": {
+			config:        ConfigDynamic{GroupVersionResource: foos},
+			expectedNames: []string{"testfoo1", "testfoo2"},
+		},
+		"several resource types": {
+			config: ConfigDynamic{GroupVersionResources: []schema.GroupVersionResource{foos, bars}},
+			err:    true,
+		},
+		"incremental": {
+			config: ConfigDynamic{GroupVersionResource: foos, Incremental: true},
+			err:    true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			gvrToListKind := map[schema.GroupVersionResource]string{
+				foos: "UnstructuredList",
+				bars: "UnstructuredList",
+			}
+			cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), gvrToListKind,
+				getObject("foobar/v1", "Foo", "testfoo1", "testns", false),
+				getObject("foobar/v1", "Foo", "testfoo2", "testns", false),
+				getObject("foobar/v1", "Bar", "testbar", "testns", false),
+			)
+			dg, err := tc.config.newDataGathererWithClient(ctx, cl)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if err := dg.Run(ctx.Done()); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			streaming := dg.(datagatherer.StreamingDataGatherer)
+			if streaming.Streamable() == tc.err {
+				t.Errorf("unexpected Streamable: got=%t want=%t", streaming.Streamable(), !tc.err)
+			}
+
+			var names []string
+			rest, err := streaming.FetchStream(func(item *api.GatheredResource) error {
+				names = append(names, item.Resource.(*unstructured.Unstructured).GetName())
+				return nil
+			})
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			sort.Strings(names)
+			if diff, equal := messagediff.PrettyDiff(tc.expectedNames, names); !equal {
+				t.Errorf("unexpected resources:\n%s", diff)
+			}
+			if _, ok := rest["status"]; !ok {
+				t.Errorf("expected the status of the resource type, got %#v", rest)
+			}
+			if _, ok := rest["items"]; ok {
+				t.Errorf("expected no items with the rest of the data, got %#v", rest)
+			}
+		})
+	}
+}
+
 func TestGenerateFieldSelector(t *testing.T) {
 	tests := []struct {
 		ExcludeNamespaces     []string
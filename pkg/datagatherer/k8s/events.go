@@ -0,0 +1,366 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	coreEventsGVR = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+	eventsGVR     = schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"}
+)
+
+const (
+	// defaultEventsWindow is how long the events are retained by default,
+	// the default TTL of the Events in the API server.
+	defaultEventsWindow = time.Hour
+	// defaultEventsRateLimit is how often an event of the same reason and
+	// object is recorded by default, the occurrences in between are counted.
+	defaultEventsRateLimit = 10 * time.Minute
+	// defaultMaxEvents bounds the events returned by default.
+	defaultMaxEvents = 1000
+)
+
+// ConfigEvents contains the configuration for the k8s-events data-gatherer.
+type ConfigEvents struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+	// EventsAPI watches the Events of the events.k8s.io API instead of the
+	// core ones.
+	EventsAPI bool `yaml:"events-api"`
+	// Types are the types of the events recorded, Warning by default.
+	Types []string `yaml:"types"`
+	// InvolvedKinds, if set, limits the events recorded to the ones about
+	// objects of these kinds, e.g. Certificate.
+	InvolvedKinds []string `yaml:"involved-kinds"`
+	// Reasons, if set, limits the events recorded to these reasons.
+	Reasons []string `yaml:"reasons"`
+	// Window is how long the events are retained after their last
+	// occurrence, one hour by default.
+	Window time.Duration `yaml:"window"`
+	// RateLimit is how often an event of the same type, reason and involved
+	// object is recorded, ten minutes by default. The occurrences in between
+	// are counted in the event recorded last.
+	RateLimit time.Duration `yaml:"rate-limit"`
+	// MaxEvents is the maximum number of events returned, the most recent
+	// ones are kept. 1000 by default.
+	MaxEvents int `yaml:"max-events"`
+}
+
+func (c *ConfigEvents) validate() error {
+	if c.Window < 0 {
+		return fmt.Errorf("invalid configuration: window cannot be negative")
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("invalid configuration: rate-limit cannot be negative")
+	}
+	if c.MaxEvents < 0 {
+		return fmt.Errorf("invalid configuration: max-events cannot be negative")
+	}
+	return nil
+}
+
+// DynamicConfig returns the configuration of the dynamic data gatherer used to
+// watch the Events.
+func (c *ConfigEvents) DynamicConfig() *ConfigDynamic {
+	gvr := coreEventsGVR
+	if c.EventsAPI {
+		gvr = eventsGVR
+	}
+	return &ConfigDynamic{
+		KubeConfigPath:       c.KubeConfigPath,
+		KubeConfigContext:    c.KubeConfigContext,
+		GroupVersionResource: gvr,
+		ExcludeNamespaces:    c.ExcludeNamespaces,
+		IncludeNamespaces:    c.IncludeNamespaces,
+	}
+}
+
+// NewDataGatherer constructs a new instance of the k8s-events data-gatherer.
+func (c *ConfigEvents) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	dynamicDg, err := c.DynamicConfig().NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newDataGathererEvents(c, dynamicDg), nil
+}
+
+func newDataGathererEvents(c *ConfigEvents, dynamicDg datagatherer.DataGatherer) *DataGathererEvents {
+	g := &DataGathererEvents{
+		DynamicWrapper: DynamicWrapper{Dynamic: dynamicDg},
+		types:          stringSet(c.Types),
+		kinds:          stringSet(c.InvolvedKinds),
+		reasons:        stringSet(c.Reasons),
+		window:         c.Window,
+		rateLimit:      c.RateLimit,
+		maxEvents:      c.MaxEvents,
+		seen:           map[string]int64{},
+		latest:         map[eventKey]*EventRecord{},
+		now:            clock.now,
+	}
+	if len(g.types) == 0 {
+		g.types = stringSet([]string{"Warning"})
+	}
+	if g.window == 0 {
+		g.window = defaultEventsWindow
+	}
+	if g.rateLimit == 0 {
+		g.rateLimit = defaultEventsRateLimit
+	}
+	if g.maxEvents == 0 {
+		g.maxEvents = defaultMaxEvents
+	}
+	return g
+}
+
+func stringSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// DataGathererEvents watches Events and keeps a log of them, deduplicated by
+// type, reason and involved object, rate limited and retained for a rolling
+// window, so that failures reach the backend without flooding it.
+type DataGathererEvents struct {
+	DynamicWrapper
+
+	types, kinds, reasons map[string]bool
+	window, rateLimit     time.Duration
+	maxEvents             int
+
+	mu sync.Mutex
+	// seen is the count of the Events already recorded, by UID.
+	seen map[string]int64
+	// records is the log of the events, latest the last record of each key.
+	records []*EventRecord
+	latest  map[eventKey]*EventRecord
+	now     func() time.Time
+}
+
+// InvolvedObject is the object an event is about.
+type InvolvedObject struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// EventRecord is a deduplicated event: the occurrences of the events of the
+// same type, reason and involved object within the rate limit.
+type EventRecord struct {
+	Type           string         `json:"type"`
+	Reason         string         `json:"reason"`
+	InvolvedObject InvolvedObject `json:"involvedObject"`
+	// Message is the message of the last occurrence.
+	Message string `json:"message"`
+	// Source is the component reporting the event.
+	Source string `json:"source,omitempty"`
+	// Count is the number of occurrences.
+	Count     int64    `json:"count"`
+	FirstSeen api.Time `json:"firstSeen"`
+	LastSeen  api.Time `json:"lastSeen"`
+}
+
+type eventKey struct {
+	eventType, reason string
+	object            InvolvedObject
+}
+
+// Delete clears the cache of the dynamic data gatherer and the log.
+func (g *DataGathererEvents) Delete() error {
+	g.mu.Lock()
+	g.seen = map[string]int64{}
+	g.records = nil
+	g.latest = map[eventKey]*EventRecord{}
+	g.mu.Unlock()
+	return g.Dynamic.Delete()
+}
+
+// Fetch records the occurrences of the Events in the cache since the
+// previous Fetch, and returns the log of the events within the window.
+func (g *DataGathererEvents) Fetch() (interface{}, error) {
+	data, err := g.Dynamic.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	present := map[string]bool{}
+	err = VisitGatheredResources(data, func(item *api.GatheredResource) error {
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("failed to parse cached resource")
+		}
+		uid := string(resource.GetUID())
+		present[uid] = true
+		if item.DeletedAt.IsZero() {
+			g.record(resource)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the Events which expired are not counted again
+	for uid := range g.seen {
+		if !present[uid] {
+			delete(g.seen, uid)
+		}
+	}
+	g.expire()
+
+	events := make([]*EventRecord, len(g.records))
+	copy(events, g.records)
+	return map[string]interface{}{
+		"events": events,
+	}, nil
+}
+
+// record records the occurrences of the Event since it was last seen.
+func (g *DataGathererEvents) record(event *unstructured.Unstructured) {
+	e := parseEvent(event)
+	if !g.types[e.Type] || (len(g.kinds) > 0 && !g.kinds[e.InvolvedObject.Kind]) || (len(g.reasons) > 0 && !g.reasons[e.Reason]) {
+		return
+	}
+
+	uid := string(event.GetUID())
+	previous := g.seen[uid]
+	occurrences := e.Count - previous
+	if occurrences <= 0 {
+		return
+	}
+	g.seen[uid] = e.Count
+
+	key := eventKey{eventType: e.Type, reason: e.Reason, object: e.InvolvedObject}
+	if last, ok := g.latest[key]; ok && e.LastSeen.Sub(last.FirstSeen.Time) < g.rateLimit {
+		last.Count += occurrences
+		if e.LastSeen.After(last.LastSeen.Time) {
+			last.LastSeen = e.LastSeen
+			last.Message = e.Message
+		}
+		return
+	}
+	e.Count = occurrences
+	if previous > 0 {
+		// the earlier occurrences of the Event were recorded already
+		e.FirstSeen = e.LastSeen
+	}
+	g.records = append(g.records, e)
+	g.latest[key] = e
+}
+
+// expire drops the events older than the window, and the oldest events
+// beyond the maximum.
+func (g *DataGathererEvents) expire() {
+	cutoff := g.now().Add(-g.window)
+	sort.SliceStable(g.records, func(i, j int) bool {
+		return g.records[i].LastSeen.Before(g.records[j].LastSeen.Time)
+	})
+	start := sort.Search(len(g.records), func(i int) bool {
+		return !g.records[i].LastSeen.Before(cutoff)
+	})
+	if len(g.records)-start > g.maxEvents {
+		start = len(g.records) - g.maxEvents
+	}
+	for _, e := range g.records[:start] {
+		key := eventKey{eventType: e.Type, reason: e.Reason, object: e.InvolvedObject}
+		if g.latest[key] == e {
+			delete(g.latest, key)
+		}
+	}
+	g.records = append([]*EventRecord(nil), g.records[start:]...)
+}
+
+// parseEvent reads an Event of the core API or of the events.k8s.io API.
+func parseEvent(event *unstructured.Unstructured) *EventRecord {
+	e := &EventRecord{
+		Type:   nestedString(event.Object, "type"),
+		Reason: nestedString(event.Object, "reason"),
+	}
+
+	object := "involvedObject"
+	if _, ok := event.Object["regarding"]; ok {
+		object = "regarding"
+	}
+	e.InvolvedObject = InvolvedObject{
+		APIVersion: nestedString(event.Object, object, "apiVersion"),
+		Kind:       nestedString(event.Object, object, "kind"),
+		Namespace:  nestedString(event.Object, object, "namespace"),
+		Name:       nestedString(event.Object, object, "name"),
+	}
+
+	e.Message = firstString(event.Object, []string{"message"}, []string{"note"})
+	e.Source = firstString(event.Object, []string{"source", "component"}, []string{"reportingComponent"}, []string{"reportingController"})
+
+	e.Count = 1
+	for _, path := range [][]string{{"series", "count"}, {"count"}, {"deprecatedCount"}} {
+		if count, ok, _ := unstructured.NestedInt64(event.Object, path...); ok && count > 0 {
+			e.Count = count
+			break
+		}
+	}
+
+	first := parseEventTime(firstString(event.Object, []string{"firstTimestamp"}, []string{"deprecatedFirstTimestamp"}, []string{"eventTime"}))
+	last := parseEventTime(firstString(event.Object, []string{"series", "lastObservedTime"}, []string{"lastTimestamp"}, []string{"deprecatedLastTimestamp"}, []string{"eventTime"}))
+	created := event.GetCreationTimestamp().Time
+	if first.IsZero() {
+		first = created
+	}
+	if last.IsZero() {
+		last = first
+	}
+	e.FirstSeen = api.Time{Time: first}
+	e.LastSeen = api.Time{Time: last}
+	return e
+}
+
+func nestedString(obj map[string]interface{}, fields ...string) string {
+	s, _, _ := unstructured.NestedString(obj, fields...)
+	return s
+}
+
+func firstString(obj map[string]interface{}, paths ...[]string) string {
+	for _, path := range paths {
+		if s := nestedString(obj, path...); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// parseEventTime parses the timestamps of the Events, which are RFC 3339
+// with seconds or microseconds.
+func parseEventTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,136 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func getEvent(uid, eventType, reason, name string, count int64, last time.Time) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Event",
+			"metadata": map[string]interface{}{
+				"name":      name + "." + uid,
+				"namespace": "default",
+				"uid":       uid,
+			},
+			"type":   eventType,
+			"reason": reason,
+			"involvedObject": map[string]interface{}{
+				"apiVersion": "cert-manager.io/v1",
+				"kind":       "Certificate",
+				"namespace":  "default",
+				"name":       name,
+			},
+			"message":        "Issuing certificate failed: " + reason,
+			"source":         map[string]interface{}{"component": "cert-manager"},
+			"count":          count,
+			"firstTimestamp": last.Add(-time.Duration(count-1) * time.Minute).Format(time.RFC3339),
+			"lastTimestamp":  last.Format(time.RFC3339),
+		},
+	}
+}
+
+func TestDataGathererEventsFetch(t *testing.T) {
+	start := time.Date(2021, 3, 16, 18, 0, 0, 0, time.UTC)
+	now := start
+	dynamicDg := &fakeDataGatherer{}
+	dg := newDataGathererEvents(&ConfigEvents{RateLimit: 10 * time.Minute, Window: time.Hour, MaxEvents: 3}, dynamicDg)
+	dg.now = func() time.Time { return now }
+
+	fetch := func(events ...*unstructured.Unstructured) []*EventRecord {
+		var items []*api.GatheredResource
+		for _, e := range events {
+			items = append(items, &api.GatheredResource{Resource: e})
+		}
+		dynamicDg.data = map[string]interface{}{"items": items}
+		data, err := dg.Fetch()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return data.(map[string]interface{})["events"].([]*EventRecord)
+	}
+
+	// the normal events are ignored, the events of the same object and
+	// reason are deduplicated
+	records := fetch(
+		getEvent("1", "Warning", "Failed", "example", 3, start),
+		getEvent("2", "Warning", "Failed", "example", 1, start.Add(time.Minute)),
+		getEvent("3", "Normal", "Issuing", "example", 1, start),
+	)
+	if len(records) != 1 || records[0].Count != 4 || !records[0].LastSeen.Equal(start.Add(time.Minute)) || records[0].Source != "cert-manager" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	// the occurrences counted already are not counted again, the new ones
+	// within the rate limit are added to the record
+	now = start.Add(5 * time.Minute)
+	records = fetch(
+		getEvent("1", "Warning", "Failed", "example", 4, start.Add(5*time.Minute)),
+		getEvent("2", "Warning", "Failed", "example", 1, start.Add(time.Minute)),
+	)
+	if len(records) != 1 || records[0].Count != 5 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	// past the rate limit, a new record is added
+	now = start.Add(20 * time.Minute)
+	records = fetch(
+		getEvent("1", "Warning", "Failed", "example", 6, start.Add(20*time.Minute)),
+	)
+	if len(records) != 2 || records[1].Count != 2 || !records[1].FirstSeen.Equal(start.Add(20*time.Minute)) {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	// the records are retained for the window after the Events expired
+	now = start.Add(70 * time.Minute)
+	records = fetch()
+	if len(records) != 1 || records[0].Count != 2 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	// the number of records is bounded, the most recent ones are kept
+	var events []*unstructured.Unstructured
+	for i, name := range []string{"a", "b", "c", "d"} {
+		events = append(events, getEvent("e-"+name, "Warning", "Failed", name, 1, now.Add(time.Duration(i)*time.Second)))
+	}
+	records = fetch(events...)
+	if len(records) != 3 || records[0].InvolvedObject.Name != "b" || records[2].InvolvedObject.Name != "d" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+}
+
+func TestParseEventsAPIEvent(t *testing.T) {
+	event := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "events.k8s.io/v1",
+			"kind":       "Event",
+			"metadata":   map[string]interface{}{"name": "a", "uid": "1"},
+			"type":       "Warning",
+			"reason":     "BadConfig",
+			"regarding": map[string]interface{}{
+				"kind": "Issuer",
+				"name": "letsencrypt",
+			},
+			"note":                "Error initializing issuer",
+			"reportingController": "cert-manager",
+			"eventTime":           "2021-03-16T18:00:00.123456Z",
+			"series": map[string]interface{}{
+				"count":            int64(7),
+				"lastObservedTime": "2021-03-16T18:30:00.000000Z",
+			},
+		},
+	}
+
+	e := parseEvent(event)
+	if e.InvolvedObject.Kind != "Issuer" || e.Message != "Error initializing issuer" || e.Source != "cert-manager" || e.Count != 7 {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if !e.FirstSeen.Equal(time.Date(2021, 3, 16, 18, 0, 0, 123456000, time.UTC)) || !e.LastSeen.Equal(time.Date(2021, 3, 16, 18, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected timestamps: %v, %v", e.FirstSeen, e.LastSeen)
+	}
+}
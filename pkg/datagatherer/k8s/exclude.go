@@ -0,0 +1,35 @@
+package k8s
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ExcludeAnnotation can be set to "true" on any resource to prevent it from
+// being gathered.
+const ExcludeAnnotation = "preflight.jetstack.io/exclude"
+
+// isExcluded returns true if the resource opted out of being gathered, either
+// with the exclude annotation or by matching the configured labels.
+func (g *DataGathererDynamic) isExcluded(obj interface{}) bool {
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return false
+	}
+
+	if resource.GetAnnotations()[ExcludeAnnotation] == "true" {
+		return true
+	}
+
+	return g.excludeSelector != nil && g.excludeSelector.Matches(labels.Set(resource.GetLabels()))
+}
+
+// removeExcluded drops a resource that has been updated to be excluded from
+// the cache, so it is not reported as deleted either.
+func (g *DataGathererDynamic) removeExcluded(obj interface{}) {
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	g.cache.Delete(string(resource.GetUID()))
+}
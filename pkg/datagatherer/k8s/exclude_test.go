@@ -0,0 +1,60 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestDynamicGatherer_FetchExcluded(t *testing.T) {
+	ctx := context.Background()
+	gvr := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+
+	annotated := getObject("foobar/v1", "Foo", "annotated", "testns", false)
+	annotated.SetAnnotations(map[string]string{ExcludeAnnotation: "true"})
+	labelled := getObject("foobar/v1", "Foo", "labelled", "testns", false)
+	labelled.SetLabels(map[string]string{"team": "secret", "tier": "db"})
+	partiallyLabelled := getObject("foobar/v1", "Foo", "partially-labelled", "testns", false)
+	partiallyLabelled.SetLabels(map[string]string{"team": "secret"})
+
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "UnstructuredList"},
+		getObject("foobar/v1", "Foo", "included", "testns", false),
+		annotated,
+		labelled,
+		partiallyLabelled,
+	)
+
+	config := ConfigDynamic{
+		GroupVersionResource: gvr,
+		ExcludeLabels:        map[string]string{"team": "secret", "tier": "db"},
+	}
+	dg, err := config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	res, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	names := map[string]bool{}
+	for _, item := range res.(map[string]interface{})["items"].([]*api.GatheredResource) {
+		names[item.Resource.(*unstructured.Unstructured).GetName()] = true
+	}
+	if len(names) != 2 || !names["included"] || !names["partially-labelled"] {
+		t.Fatalf("unexpected resources gathered: %v", names)
+	}
+}
@@ -0,0 +1,392 @@
+package k8s
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// HashedNamespaces hashes the namespaces of the resources, the names of
+	// the Namespaces and the namespaces they reference.
+	HashedNamespaces = "namespaces"
+	// HashedSecretNames hashes the names of the Secrets and the names of the
+	// Secrets the resources reference.
+	HashedSecretNames = "secret-names"
+	// HashedHostnames hashes the hostnames and DNS names found in the
+	// resources, e.g. the hosts of Ingresses or the DNS names of Certificates.
+	HashedHostnames = "hostnames"
+)
+
+// hashedPrefix marks the hashed values, so that they are not mistaken for
+// actual names.
+const hashedPrefix = "hashed:"
+
+// hashedLength is the number of bytes of the HMAC kept in the hashed values.
+const hashedLength = 16
+
+var hashedIdentifiers = map[string]bool{
+	HashedNamespaces:  true,
+	HashedSecretNames: true,
+	HashedHostnames:   true,
+}
+
+// hostnameKeys are the keys of the fields holding a hostname.
+var hostnameKeys = map[string]bool{
+	"host":         true,
+	"hostname":     true,
+	"commonName":   true,
+	"externalName": true,
+}
+
+// hostnameListKeys are the keys of the fields holding a list of hostnames.
+var hostnameListKeys = map[string]bool{
+	"hosts":     true,
+	"hostnames": true,
+	"dnsNames":  true,
+}
+
+// hostnameAnnotations are the annotations holding hostnames, set by
+// cert-manager on the Secrets of its Certificates and on the Ingresses it
+// issues certificates for. Their value is a comma separated list.
+var hostnameAnnotations = map[string]bool{
+	"cert-manager.io/alt-names":   true,
+	"cert-manager.io/common-name": true,
+}
+
+// IdentifierHasher replaces identifiers of the gathered resources with a
+// keyed hash, so that the same identifier always has the same hash within a
+// cluster without the identifier being revealed.
+type IdentifierHasher struct {
+	key         []byte
+	namespaces  bool
+	secretNames bool
+	hostnames   bool
+}
+
+// ValidateHashedIdentifiers checks that the identifiers are known.
+func ValidateHashedIdentifiers(identifiers []string) error {
+	if len(identifiers) == 0 {
+		return fmt.Errorf("at least one identifier must be hashed")
+	}
+	for _, identifier := range identifiers {
+		if !hashedIdentifiers[identifier] {
+			names := make([]string, 0, len(hashedIdentifiers))
+			for k := range hashedIdentifiers {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown hashed identifier %q, must be one of: %s", identifier, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// NewIdentifierHasher returns the hasher of the identifiers of a cluster. Its
+// key is derived from the salt and the cluster ID, so that the hashes of the
+// same identifier differ between clusters.
+func NewIdentifierHasher(salt []byte, clusterID string, identifiers []string) (*IdentifierHasher, error) {
+	if len(salt) == 0 {
+		return nil, fmt.Errorf("the salt cannot be empty")
+	}
+	if err := ValidateHashedIdentifiers(identifiers); err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(clusterID))
+	h := &IdentifierHasher{key: mac.Sum(nil)}
+	for _, identifier := range identifiers {
+		switch identifier {
+		case HashedNamespaces:
+			h.namespaces = true
+		case HashedSecretNames:
+			h.secretNames = true
+		case HashedHostnames:
+			h.hostnames = true
+		}
+	}
+	return h, nil
+}
+
+// Hash returns the hash of an identifier, empty identifiers are kept as is.
+func (h *IdentifierHasher) Hash(value string) string {
+	if value == "" {
+		return value
+	}
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(value))
+	return hashedPrefix + hex.EncodeToString(mac.Sum(nil)[:hashedLength])
+}
+
+// HashResource returns a copy of the gathered resource with its identifiers
+// hashed. The resources are copied as they may be held by the cache of their
+// data gatherer.
+func (h *IdentifierHasher) HashResource(item *api.GatheredResource) *api.GatheredResource {
+	resource, ok := item.Resource.(*unstructured.Unstructured)
+	if !ok {
+		return item
+	}
+	resource = resource.DeepCopy()
+
+	if resource.GetAPIVersion() == "v1" {
+		switch {
+		case h.namespaces && resource.GetKind() == "Namespace":
+			resource.SetName(h.Hash(resource.GetName()))
+		case h.secretNames && resource.GetKind() == "Secret":
+			resource.SetName(h.Hash(resource.GetName()))
+		}
+		if h.hostnames && resource.GetKind() == "Secret" {
+			// the certificate lists its hostnames, which cannot be hashed
+			// without breaking it
+			unstructured.RemoveNestedField(resource.Object, "data", "tls.crt")
+		}
+	}
+	if h.hostnames {
+		h.hashAnnotations(resource)
+	}
+	h.hashFields(resource.Object)
+
+	hashed := *item
+	hashed.Resource = resource
+	return &hashed
+}
+
+// HashData replaces all the gathered resources contained in the output of a
+// data gatherer's Fetch with their hashed copies. The formats supported are
+// the ones of VisitGatheredResources, and the summaries of the
+// k8s-tls-secrets, k8s-configmap-certs and k8s-ingress-tls data gatherers.
+func (h *IdentifierHasher) HashData(data interface{}) {
+	list, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if secrets, ok := list["secrets"].([]*TLSSecret); ok {
+		for i, secret := range secrets {
+			secrets[i] = h.hashTLSSecret(secret)
+		}
+	}
+	if configMaps, ok := list["configmaps"].([]*ConfigMapCertificates); ok {
+		for i, configMap := range configMaps {
+			configMaps[i] = h.hashConfigMapCertificates(configMap)
+		}
+	}
+	if hosts, ok := list["hosts"].([]*ExposedHost); ok {
+		for i, host := range hosts {
+			hosts[i] = h.hashExposedHost(host)
+		}
+	}
+
+	if items, ok := list["items"].([]*api.GatheredResource); ok {
+		for i, item := range items {
+			items[i] = h.HashResource(item)
+		}
+	}
+
+	if resources, ok := list["resources"].(map[string]map[string]interface{}); ok {
+		for _, resourceList := range resources {
+			h.HashData(resourceList)
+		}
+	}
+}
+
+// hashFields walks the object looking for the fields holding the hashed
+// identifiers, by their keys.
+func (h *IdentifierHasher) hashFields(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			switch {
+			case h.namespaces && key == "namespace":
+				v[key] = h.hashString(child)
+			case h.namespaces && key == "namespaces":
+				v[key] = h.hashStrings(child)
+			case h.secretNames && key == "secretName":
+				v[key] = h.hashString(child)
+			case h.secretNames && isSecretReference(key):
+				h.hashName(child)
+				// e.g. the secretName of a secret volume
+				h.hashFields(child)
+			case h.secretNames && (key == "imagePullSecrets" || key == "secrets"):
+				if references, ok := child.([]interface{}); ok {
+					for _, reference := range references {
+						h.hashName(reference)
+						h.hashFields(reference)
+					}
+				}
+			case h.hostnames && hostnameKeys[key]:
+				v[key] = h.hashString(child)
+			case h.hostnames && hostnameListKeys[key]:
+				v[key] = h.hashStrings(child)
+			default:
+				h.hashFields(child)
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			h.hashFields(child)
+		}
+	}
+}
+
+// isSecretReference returns true if the key is the one of a reference to a
+// Secret by name, e.g. secretRef, secretKeyRef, privateKeySecretRef or the
+// secret of a projected volume.
+func isSecretReference(key string) bool {
+	return key == "secret" || key == "secretKeyRef" || strings.HasSuffix(strings.ToLower(key), "secretref")
+}
+
+// hashName hashes the name of a reference.
+func (h *IdentifierHasher) hashName(value interface{}) {
+	if reference, ok := value.(map[string]interface{}); ok {
+		if name, ok := reference["name"]; ok {
+			reference["name"] = h.hashString(name)
+		}
+	}
+}
+
+func (h *IdentifierHasher) hashString(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		return h.Hash(s)
+	}
+	return value
+}
+
+func (h *IdentifierHasher) hashStrings(value interface{}) interface{} {
+	values, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+	for i := range values {
+		values[i] = h.hashString(values[i])
+	}
+	return value
+}
+
+// hashAnnotations hashes each of the hostnames of the hostname annotations.
+func (h *IdentifierHasher) hashAnnotations(resource *unstructured.Unstructured) {
+	annotations := resource.GetAnnotations()
+	hashed := false
+	for key, value := range annotations {
+		if !hostnameAnnotations[key] || value == "" {
+			continue
+		}
+		hostnames := strings.Split(value, ",")
+		for i := range hostnames {
+			hostnames[i] = h.Hash(strings.TrimSpace(hostnames[i]))
+		}
+		annotations[key] = strings.Join(hostnames, ",")
+		hashed = true
+	}
+	if hashed {
+		resource.SetAnnotations(annotations)
+	}
+}
+
+// hashTLSSecret returns a copy of the summary of a TLS Secret with its
+// identifiers hashed.
+func (h *IdentifierHasher) hashTLSSecret(secret *TLSSecret) *TLSSecret {
+	hashed := *secret
+	if h.namespaces {
+		hashed.Namespace = h.Hash(secret.Namespace)
+	}
+	if h.secretNames {
+		hashed.Name = h.Hash(secret.Name)
+	}
+	if h.hostnames {
+		hashed.Certificates = h.hashCertificates(secret.Certificates)
+		hashed.CACertificates = h.hashCertificates(secret.CACertificates)
+		hashed.ChainErrors = hashChainErrors(secret.ChainErrors)
+	}
+	return &hashed
+}
+
+// hashConfigMapCertificates returns a copy of the certificates of a ConfigMap
+// with their identifiers hashed.
+func (h *IdentifierHasher) hashConfigMapCertificates(configMap *ConfigMapCertificates) *ConfigMapCertificates {
+	hashed := *configMap
+	if h.namespaces {
+		hashed.Namespace = h.Hash(configMap.Namespace)
+	}
+	if h.hostnames {
+		hashed.Keys = make([]*ConfigMapKeyCertificates, 0, len(configMap.Keys))
+		for _, key := range configMap.Keys {
+			hashedKey := *key
+			hashedKey.Certificates = h.hashCertificates(key.Certificates)
+			hashed.Keys = append(hashed.Keys, &hashedKey)
+		}
+	}
+	return &hashed
+}
+
+// hashExposedHost returns a copy of an exposed host with its identifiers
+// hashed. The "*" hostname of the Gateway listeners accepting any hostname is
+// kept as is.
+func (h *IdentifierHasher) hashExposedHost(host *ExposedHost) *ExposedHost {
+	hashed := *host
+	if h.namespaces {
+		hashed.Namespace = h.Hash(host.Namespace)
+		hashed.SecretNamespace = h.Hash(host.SecretNamespace)
+	}
+	if h.secretNames {
+		hashed.SecretName = h.Hash(host.SecretName)
+	}
+	if h.hostnames {
+		if host.Hostname != "*" {
+			hashed.Hostname = h.Hash(host.Hostname)
+		}
+		hashed.Certificates = h.hashCertificates(host.Certificates)
+	}
+	return &hashed
+}
+
+// hashCertificates returns copies of the certificates with their DNS names,
+// URIs and subject hashed. The subject is hashed as a whole as its common
+// name is usually a hostname.
+func (h *IdentifierHasher) hashCertificates(certs []*certinfo.Certificate) []*certinfo.Certificate {
+	if certs == nil {
+		return nil
+	}
+	hashed := make([]*certinfo.Certificate, 0, len(certs))
+	for _, cert := range certs {
+		c := *cert
+		c.Subject = h.Hash(cert.Subject)
+		c.DNSNames = h.hashList(cert.DNSNames)
+		c.URIs = h.hashList(cert.URIs)
+		hashed = append(hashed, &c)
+	}
+	return hashed
+}
+
+func (h *IdentifierHasher) hashList(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	hashed := make([]string, 0, len(values))
+	for _, value := range values {
+		hashed = append(hashed, h.Hash(value))
+	}
+	return hashed
+}
+
+// hashChainErrors returns copies of the issues of a chain without their
+// messages, which quote the subjects of the certificates.
+func hashChainErrors(chainErrors []certinfo.ChainError) []certinfo.ChainError {
+	if chainErrors == nil {
+		return nil
+	}
+	hashed := make([]certinfo.ChainError, 0, len(chainErrors))
+	for _, chainError := range chainErrors {
+		chainError.Message = ""
+		hashed = append(hashed, chainError)
+	}
+	return hashed
+}
@@ -0,0 +1,294 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func getIngressWithTLS() *unstructured.Unstructured {
+	ingress := getObject("networking.k8s.io/v1", "Ingress", "web", "shop", false)
+	ingress.Object["spec"] = map[string]interface{}{
+		"tls": []interface{}{
+			map[string]interface{}{
+				"hosts":      []interface{}{"shop.internal.example.com"},
+				"secretName": "shop-tls",
+			},
+		},
+		"rules": []interface{}{
+			map[string]interface{}{"host": "shop.internal.example.com"},
+		},
+	}
+	return ingress
+}
+
+func TestIdentifierHasher(t *testing.T) {
+	hasher, err := NewIdentifierHasher([]byte("salt"), "cluster-1", []string{HashedNamespaces, HashedSecretNames, HashedHostnames})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := hasher.Hash
+
+	pod := getObject("v1", "Pod", "web", "shop", false)
+	pod.Object["spec"] = map[string]interface{}{
+		"imagePullSecrets": []interface{}{map[string]interface{}{"name": "registry"}},
+		"containers": []interface{}{
+			map[string]interface{}{
+				"name": "app",
+				"env": []interface{}{
+					map[string]interface{}{
+						"name":      "PASSWORD",
+						"valueFrom": map[string]interface{}{"secretKeyRef": map[string]interface{}{"name": "db", "key": "password"}},
+					},
+				},
+			},
+		},
+		"volumes": []interface{}{
+			map[string]interface{}{"name": "tls", "secret": map[string]interface{}{"secretName": "shop-tls"}},
+		},
+	}
+
+	secret := getObject("v1", "Secret", "shop-tls", "shop", false)
+	secret.SetAnnotations(map[string]string{
+		"cert-manager.io/alt-names":        "shop.internal.example.com,www.internal.example.com",
+		"cert-manager.io/common-name":      "shop.internal.example.com",
+		"cert-manager.io/certificate-name": "shop",
+	})
+	secret.Object["data"] = map[string]interface{}{"tls.crt": "Y2VydA==", "ca.crt": "Y2E="}
+
+	tests := map[string]struct {
+		resource *unstructured.Unstructured
+		expected map[string]interface{}
+	}{
+		"ingress": {
+			resource: getIngressWithTLS(),
+			expected: map[string]interface{}{
+				"apiVersion": "networking.k8s.io/v1",
+				"kind":       "Ingress",
+				"metadata":   map[string]interface{}{"name": "web", "namespace": h("shop"), "uid": "web1"},
+				"spec": map[string]interface{}{
+					"tls": []interface{}{
+						map[string]interface{}{
+							"hosts":      []interface{}{h("shop.internal.example.com")},
+							"secretName": h("shop-tls"),
+						},
+					},
+					"rules": []interface{}{
+						map[string]interface{}{"host": h("shop.internal.example.com")},
+					},
+				},
+			},
+		},
+		"pod": {
+			resource: pod,
+			expected: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"metadata":   map[string]interface{}{"name": "web", "namespace": h("shop"), "uid": "web1"},
+				"spec": map[string]interface{}{
+					"imagePullSecrets": []interface{}{map[string]interface{}{"name": h("registry")}},
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name": "app",
+							"env": []interface{}{
+								map[string]interface{}{
+									"name":      "PASSWORD",
+									"valueFrom": map[string]interface{}{"secretKeyRef": map[string]interface{}{"name": h("db"), "key": "password"}},
+								},
+							},
+						},
+					},
+					"volumes": []interface{}{
+						map[string]interface{}{"name": "tls", "secret": map[string]interface{}{"secretName": h("shop-tls")}},
+					},
+				},
+			},
+		},
+		"secret": {
+			resource: secret,
+			expected: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"metadata": map[string]interface{}{
+					"name":      h("shop-tls"),
+					"namespace": h("shop"),
+					"uid":       "shop-tls1",
+					"annotations": map[string]interface{}{
+						"cert-manager.io/alt-names":        h("shop.internal.example.com") + "," + h("www.internal.example.com"),
+						"cert-manager.io/common-name":      h("shop.internal.example.com"),
+						"cert-manager.io/certificate-name": "shop",
+					},
+				},
+				"data": map[string]interface{}{"ca.crt": "Y2E="},
+			},
+		},
+		"namespace": {
+			resource: getObject("v1", "Namespace", "shop", "", false),
+			expected: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Namespace",
+				"metadata":   map[string]interface{}{"name": h("shop"), "namespace": "", "uid": "shop1"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			original := test.resource.DeepCopy()
+			item := &api.GatheredResource{Resource: test.resource}
+
+			hashed := hasher.HashResource(item)
+
+			if diff, equal := messagediff.PrettyDiff(test.expected, hashed.Resource.(*unstructured.Unstructured).Object); !equal {
+				t.Errorf("unexpected resource:\n%s", diff)
+			}
+			// the resource may be held by the cache of the data gatherer
+			if diff, equal := messagediff.PrettyDiff(original.Object, item.Resource.(*unstructured.Unstructured).Object); !equal {
+				t.Errorf("expected the resource not to be modified:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIdentifierHasherSummaries(t *testing.T) {
+	hasher, err := NewIdentifierHasher([]byte("salt"), "cluster-1", []string{HashedNamespaces, HashedSecretNames, HashedHostnames})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := hasher.Hash
+
+	cert := func() *certinfo.Certificate {
+		return &certinfo.Certificate{
+			Subject:  "CN=shop.internal.example.com",
+			Issuer:   "CN=Internal CA",
+			DNSNames: []string{"shop.internal.example.com"},
+		}
+	}
+	hashedCert := &certinfo.Certificate{
+		Subject:  h("CN=shop.internal.example.com"),
+		Issuer:   "CN=Internal CA",
+		DNSNames: []string{h("shop.internal.example.com")},
+	}
+
+	tests := map[string]struct {
+		data     map[string]interface{}
+		expected map[string]interface{}
+	}{
+		"tls secrets": {
+			data: map[string]interface{}{
+				"secrets": []*TLSSecret{{
+					Namespace:    "shop",
+					Name:         "shop-tls",
+					Certificates: []*certinfo.Certificate{cert()},
+					ChainErrors:  []certinfo.ChainError{{Reason: "Expired", Message: "CN=shop.internal.example.com expired"}},
+				}},
+			},
+			expected: map[string]interface{}{
+				"secrets": []*TLSSecret{{
+					Namespace:    h("shop"),
+					Name:         h("shop-tls"),
+					Certificates: []*certinfo.Certificate{hashedCert},
+					ChainErrors:  []certinfo.ChainError{{Reason: "Expired"}},
+				}},
+			},
+		},
+		"configmap certs": {
+			data: map[string]interface{}{
+				"configmaps": []*ConfigMapCertificates{{
+					Namespace: "shop",
+					Name:      "bundle",
+					Keys:      []*ConfigMapKeyCertificates{{Key: "ca.crt", Certificates: []*certinfo.Certificate{cert()}}},
+				}},
+			},
+			expected: map[string]interface{}{
+				"configmaps": []*ConfigMapCertificates{{
+					Namespace: h("shop"),
+					Name:      "bundle",
+					Keys:      []*ConfigMapKeyCertificates{{Key: "ca.crt", Certificates: []*certinfo.Certificate{hashedCert}}},
+				}},
+			},
+		},
+		"ingress tls": {
+			data: map[string]interface{}{
+				"hosts": []*ExposedHost{
+					{Hostname: "shop.internal.example.com", Kind: "Route", Namespace: "shop", Name: "web", TLS: true, Certificates: []*certinfo.Certificate{cert()}},
+					{Hostname: "*", Kind: "Gateway", Namespace: "shop", Name: "gateway", TLS: true, SecretNamespace: "shop", SecretName: "shop-tls"},
+				},
+			},
+			expected: map[string]interface{}{
+				"hosts": []*ExposedHost{
+					{Hostname: h("shop.internal.example.com"), Kind: "Route", Namespace: h("shop"), Name: "web", TLS: true, Certificates: []*certinfo.Certificate{hashedCert}},
+					{Hostname: "*", Kind: "Gateway", Namespace: h("shop"), Name: "gateway", TLS: true, SecretNamespace: h("shop"), SecretName: h("shop-tls")},
+				},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			hasher.HashData(test.data)
+			if diff, equal := messagediff.PrettyDiff(test.expected, test.data); !equal {
+				t.Errorf("unexpected summary:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIdentifierHasherClusters(t *testing.T) {
+	identifiers := []string{HashedHostnames}
+	first, err := NewIdentifierHasher([]byte("salt"), "cluster-1", identifiers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again, err := NewIdentifierHasher([]byte("salt"), "cluster-1", identifiers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := NewIdentifierHasher([]byte("salt"), "cluster-2", identifiers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if first.Hash("example.com") != again.Hash("example.com") {
+		t.Errorf("expected the hashes of a cluster to be stable")
+	}
+	if first.Hash("example.com") == second.Hash("example.com") {
+		t.Errorf("expected the hashes of different clusters to differ")
+	}
+
+	// only the selected identifiers are hashed
+	data := map[string]interface{}{
+		"items": []*api.GatheredResource{{Resource: getIngressWithTLS()}},
+	}
+	first.HashData(data)
+	ingress := data["items"].([]*api.GatheredResource)[0].Resource.(*unstructured.Unstructured)
+	if ingress.GetNamespace() != "shop" {
+		t.Errorf("expected the namespace not to be hashed, got %q", ingress.GetNamespace())
+	}
+	hosts, _, _ := unstructured.NestedSlice(ingress.Object, "spec", "rules")
+	if host := hosts[0].(map[string]interface{})["host"]; host != first.Hash("shop.internal.example.com") {
+		t.Errorf("expected the host to be hashed, got %q", host)
+	}
+}
+
+func TestNewIdentifierHasherValidation(t *testing.T) {
+	tests := map[string]struct {
+		salt        []byte
+		identifiers []string
+	}{
+		"no salt":            {identifiers: []string{HashedNamespaces}},
+		"no identifiers":     {salt: []byte("salt")},
+		"unknown identifier": {salt: []byte("salt"), identifiers: []string{"pod-names"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewIdentifierHasher(test.salt, "cluster", test.identifiers); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
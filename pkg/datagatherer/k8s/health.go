@@ -0,0 +1,237 @@
+package k8s
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// watchBackoffInitial and watchBackoffMax bound the delay added after each
+// consecutive watch error of a resource type, on top of the backoff of the
+// informer itself, so that a revoked permission or an unavailable API server
+// is not hammered.
+const (
+	watchBackoffInitial = time.Second
+	watchBackoffMax     = 5 * time.Minute
+)
+
+// ResourceTypeStatus describes how up to date the gathered resources of a
+// resource type are.
+type ResourceTypeStatus struct {
+	// LastSyncTime is the last time the informer was known to be in sync
+	// with the API server. Resources may be stale if it is old.
+	LastSyncTime *api.Time `json:"lastSyncTime,omitempty"`
+	// LastError is the last error of the informer's watch. It is cleared
+	// once the informer syncs again.
+	LastError string `json:"lastError,omitempty"`
+	// ConsecutiveErrors is the number of watch errors since the last sync.
+	ConsecutiveErrors int `json:"consecutiveErrors,omitempty"`
+	// Namespaces tells whether the resources of each included namespace
+	// synced, when the data gatherer has included namespaces.
+	Namespaces map[string]bool `json:"namespaces,omitempty"`
+}
+
+// resourceTypeHealth tracks the sync status of the informer of a resource
+// type.
+type resourceTypeHealth struct {
+	lastSyncTime        time.Time
+	lastResourceVersion string
+	lastError           error
+	consecutiveErrors   int
+}
+
+// markSynced records that the informer of the resource type is in sync,
+// which is the case whenever it delivers an event.
+func (g *DataGathererDynamic) markSynced(gvr schema.GroupVersionResource) {
+	g.healthMu.Lock()
+	defer g.healthMu.Unlock()
+	h := g.healthOf(gvr)
+	h.lastSyncTime = clock.now()
+	h.lastError = nil
+	h.consecutiveErrors = 0
+}
+
+// watchError records a watch error of the informer of the resource type and
+// returns how long to back off before the informer retries.
+func (g *DataGathererDynamic) watchError(gvr schema.GroupVersionResource, err error) time.Duration {
+	g.healthMu.Lock()
+	defer g.healthMu.Unlock()
+	h := g.healthOf(gvr)
+	h.lastError = err
+	h.consecutiveErrors++
+	return watchBackoff(h.consecutiveErrors)
+}
+
+// healthOf returns the health of the resource type, healthMu must be held.
+func (g *DataGathererDynamic) healthOf(gvr schema.GroupVersionResource) *resourceTypeHealth {
+	h, ok := g.health[gvr]
+	if !ok {
+		h = &resourceTypeHealth{}
+		g.health[gvr] = h
+	}
+	return h
+}
+
+// watchBackoff returns the delay after the given number of consecutive
+// errors, doubling from watchBackoffInitial up to watchBackoffMax.
+func watchBackoff(consecutiveErrors int) time.Duration {
+	delay := watchBackoffInitial
+	for i := 1; i < consecutiveErrors && delay < watchBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > watchBackoffMax {
+		delay = watchBackoffMax
+	}
+	return delay
+}
+
+// refreshHealth marks the informers whose last synced resource version
+// changed as in sync, this covers lists and watch bookmarks, which do not
+// deliver events.
+func (g *DataGathererDynamic) refreshHealth() {
+	g.healthMu.Lock()
+	defer g.healthMu.Unlock()
+	for gvr, informer := range g.informers {
+		if !informer.HasSynced() {
+			continue
+		}
+		h := g.healthOf(gvr)
+		if rv := informer.LastSyncResourceVersion(); rv != h.lastResourceVersion {
+			h.lastResourceVersion = rv
+			h.lastSyncTime = clock.now()
+			h.lastError = nil
+			h.consecutiveErrors = 0
+		}
+	}
+}
+
+// resourceTypeStatus returns the status of every resource type, keyed as in
+// the Fetch output.
+func (g *DataGathererDynamic) resourceTypeStatus() map[string]*ResourceTypeStatus {
+	g.refreshHealth()
+
+	g.healthMu.Lock()
+	defer g.healthMu.Unlock()
+	status := map[string]*ResourceTypeStatus{}
+	for gvr := range g.informers {
+		h := g.healthOf(gvr)
+		s := &ResourceTypeStatus{ConsecutiveErrors: h.consecutiveErrors}
+		if !h.lastSyncTime.IsZero() {
+			s.LastSyncTime = &api.Time{Time: h.lastSyncTime}
+		}
+		if h.lastError != nil {
+			s.LastError = h.lastError.Error()
+		}
+		if sharded, ok := g.informers[gvr].(*shardedInformer); ok {
+			s.Namespaces = sharded.namespaceStatus()
+		}
+		status[ResourceTypeKey(gvr)] = s
+	}
+	return status
+}
+
+// Healthy returns an error if the informer of any resource type has not
+// synced yet or is failing to watch, in which case the resources returned
+// by Fetch may be stale.
+func (g *DataGathererDynamic) Healthy() error {
+	var problems []string
+	for key, status := range g.resourceTypeStatus() {
+		switch {
+		case status.ConsecutiveErrors > 0:
+			problems = append(problems, fmt.Sprintf("%s: watch failed %d time(s): %s", key, status.ConsecutiveErrors, status.LastError))
+		case status.LastSyncTime == nil:
+			problems = append(problems, fmt.Sprintf("%s: not synced", key))
+		default:
+			if unsynced := unsyncedNamespaces(status.Namespaces); len(unsynced) > 0 {
+				problems = append(problems, fmt.Sprintf("%s: not synced in namespaces %s", key, strings.Join(unsynced, ", ")))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("%s", strings.Join(problems, ", "))
+}
+
+// Issues details the problems reported by Healthy for each resource type,
+// and the resources truncated by the last Fetch.
+func (g *DataGathererDynamic) Issues() []*api.DataReadingIssue {
+	g.refreshHealth()
+
+	g.healthMu.Lock()
+	var issues []*api.DataReadingIssue
+	for gvr, informer := range g.informers {
+		key := ResourceTypeKey(gvr)
+		h := g.healthOf(gvr)
+		switch {
+		case h.consecutiveErrors > 0 && h.lastError != nil:
+			code := datagatherer.IssueCode(h.lastError, api.IssueWatchFailed)
+			issues = append(issues, &api.DataReadingIssue{
+				Severity:     datagatherer.IssueSeverity(code),
+				Code:         code,
+				ResourceType: key,
+				Message:      fmt.Sprintf("watch failed %d time(s): %s", h.consecutiveErrors, h.lastError),
+			})
+		case h.lastSyncTime.IsZero():
+			issues = append(issues, &api.DataReadingIssue{
+				Severity:     api.IssueSeverityWarning,
+				Code:         api.IssueNotSynced,
+				ResourceType: key,
+				Message:      "not synced",
+			})
+		default:
+			sharded, ok := informer.(*shardedInformer)
+			if !ok {
+				continue
+			}
+			if unsynced := unsyncedNamespaces(sharded.namespaceStatus()); len(unsynced) > 0 {
+				issues = append(issues, &api.DataReadingIssue{
+					Severity:     api.IssueSeverityWarning,
+					Code:         api.IssueNotSynced,
+					ResourceType: key,
+					Message:      fmt.Sprintf("not synced in namespaces %s", strings.Join(unsynced, ", ")),
+				})
+			}
+		}
+	}
+	truncated := g.truncated
+	g.healthMu.Unlock()
+
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].ResourceType < issues[j].ResourceType
+	})
+	if truncated.any() {
+		issues = append(issues, &api.DataReadingIssue{
+			Severity: api.IssueSeverityWarning,
+			Code:     api.IssueTruncated,
+			Message:  fmt.Sprintf("%d resource(s) left out and %d oversized resource(s) reduced to their identity", truncated.Items, truncated.Objects),
+		})
+	}
+	return issues
+}
+
+// issues returns the issues of the data gatherer, if it is able to report
+// them. It is used by the data gatherers wrapping a dynamic data gatherer.
+func issues(dg datagatherer.DataGatherer) []*api.DataReadingIssue {
+	if reporter, ok := dg.(datagatherer.IssueReporter); ok {
+		return reporter.Issues()
+	}
+	return nil
+}
+
+func unsyncedNamespaces(namespaces map[string]bool) []string {
+	var unsynced []string
+	for namespace, synced := range namespaces {
+		if !synced {
+			unsynced = append(unsynced, namespace)
+		}
+	}
+	sort.Strings(unsynced)
+	return unsynced
+}
@@ -0,0 +1,110 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestWatchBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		5:  16 * time.Second,
+		9:  256 * time.Second,
+		10: watchBackoffMax,
+		50: watchBackoffMax,
+	}
+	for errors, expected := range tests {
+		if got := watchBackoff(errors); got != expected {
+			t.Errorf("watchBackoff(%d) = %s, expected %s", errors, got, expected)
+		}
+	}
+}
+
+func TestDataGathererDynamicDegraded(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	config := ConfigDynamic{GroupVersionResource: gvr}
+	cl := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	dg, err := config.newDataGathererWithClient(context.Background(), cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g := dg.(*DataGathererDynamic)
+
+	if err := g.Healthy(); err == nil || err.Error() != "foos.v1.foobar: not synced" {
+		t.Errorf("expected the data gatherer to be degraded until synced, got: %v", err)
+	}
+
+	g.markSynced(gvr)
+	if err := g.Healthy(); err != nil {
+		t.Errorf("unexpected degradation: %v", err)
+	}
+	if status := g.resourceTypeStatus()["foos.v1.foobar"]; status.LastSyncTime == nil {
+		t.Errorf("expected a last sync time")
+	}
+
+	if delay := g.watchError(gvr, fmt.Errorf("forbidden")); delay != time.Second {
+		t.Errorf("unexpected delay after the first error: %s", delay)
+	}
+	if delay := g.watchError(gvr, fmt.Errorf("forbidden")); delay != 2*time.Second {
+		t.Errorf("unexpected delay after the second error: %s", delay)
+	}
+	expected := "foos.v1.foobar: watch failed 2 time(s): forbidden"
+	if err := g.Healthy(); err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got: %v", expected, err)
+	}
+
+	g.markSynced(gvr)
+	if err := g.Healthy(); err != nil {
+		t.Errorf("expected the data gatherer to recover, got: %v", err)
+	}
+}
+
+func TestDataGathererDynamicIssues(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	config := ConfigDynamic{GroupVersionResource: gvr}
+	cl := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	dg, err := config.newDataGathererWithClient(context.Background(), cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g := dg.(*DataGathererDynamic)
+
+	expected := []*api.DataReadingIssue{
+		{Severity: api.IssueSeverityWarning, Code: api.IssueNotSynced, ResourceType: "foos.v1.foobar", Message: "not synced"},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, g.Issues()); !equal {
+		t.Errorf("unexpected issues:\n%s", diff)
+	}
+
+	g.markSynced(gvr)
+	if issues := g.Issues(); len(issues) != 0 {
+		t.Errorf("unexpected issues: %v", issues)
+	}
+
+	// the informers only keep the message of the API server errors
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Group: "foobar", Resource: "foos"}, "", fmt.Errorf("RBAC denied"))
+	g.watchError(gvr, fmt.Errorf("failed to list *unstructured.Unstructured: %v", forbidden))
+	g.truncated = truncation{Items: 2, Objects: 1}
+	expected = []*api.DataReadingIssue{
+		{
+			Severity:     api.IssueSeverityError,
+			Code:         api.IssueForbidden,
+			ResourceType: "foos.v1.foobar",
+			Message:      fmt.Sprintf("watch failed 1 time(s): failed to list *unstructured.Unstructured: %v", forbidden),
+		},
+		{Severity: api.IssueSeverityWarning, Code: api.IssueTruncated, Message: "2 resource(s) left out and 1 oversized resource(s) reduced to their identity"},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, g.Issues()); !equal {
+		t.Errorf("unexpected issues:\n%s", diff)
+	}
+}
@@ -0,0 +1,190 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var podsGVR = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// podContainerFields are the fields of a Pod spec listing containers.
+var podContainerFields = []string{"initContainers", "containers", "ephemeralContainers"}
+
+// ConfigImages contains the configuration for the k8s-images data-gatherer.
+type ConfigImages struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+}
+
+// DynamicConfig returns the configuration of the dynamic data gatherer used to
+// collect the Pods.
+func (c *ConfigImages) DynamicConfig() *ConfigDynamic {
+	return &ConfigDynamic{
+		KubeConfigPath:       c.KubeConfigPath,
+		KubeConfigContext:    c.KubeConfigContext,
+		GroupVersionResource: podsGVR,
+		ExcludeNamespaces:    c.ExcludeNamespaces,
+		IncludeNamespaces:    c.IncludeNamespaces,
+	}
+}
+
+// NewDataGatherer constructs a new instance of the k8s-images data-gatherer.
+func (c *ConfigImages) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	dynamicDg, err := c.DynamicConfig().NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGathererImages{DynamicWrapper: DynamicWrapper{Dynamic: dynamicDg}}, nil
+}
+
+// DataGathererImages gathers Pods but only emits the container images they
+// run, deduplicated per namespace, as full Pod specs are large and may
+// contain sensitive data.
+type DataGathererImages struct {
+	DynamicWrapper
+}
+
+// NamespaceImages is the inventory of the images run in a namespace.
+type NamespaceImages struct {
+	Namespace string            `json:"namespace"`
+	Images    []*ContainerImage `json:"images"`
+	// ImagePullSecrets are the names of the Secrets used by the Pods to
+	// pull images.
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+}
+
+// ContainerImage is an image reference and the pull policy it is used
+// with.
+type ContainerImage struct {
+	Image           string `json:"image"`
+	ImagePullPolicy string `json:"imagePullPolicy,omitempty"`
+	// Pods is the number of Pods running the image with this pull policy.
+	Pods int `json:"pods"`
+}
+
+// Fetch builds the image inventory from the Pods currently in the cache.
+// Deleted Pods are ignored.
+func (g *DataGathererImages) Fetch() (interface{}, error) {
+	data, err := g.Dynamic.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := map[string]*imageInventory{}
+	err = VisitGatheredResources(data, func(item *api.GatheredResource) error {
+		if !item.DeletedAt.IsZero() {
+			return nil
+		}
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("failed to parse cached resource")
+		}
+		inv, ok := inventory[resource.GetNamespace()]
+		if !ok {
+			inv = newImageInventory()
+			inventory[resource.GetNamespace()] = inv
+		}
+		inv.addPod(resource)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := []*NamespaceImages{}
+	for namespace, inv := range inventory {
+		namespaces = append(namespaces, inv.namespaceImages(namespace))
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Namespace < namespaces[j].Namespace
+	})
+
+	return map[string]interface{}{
+		"namespaces": namespaces,
+	}, nil
+}
+
+// imageInventory deduplicates the images and pull secrets of the Pods of a
+// namespace.
+type imageInventory struct {
+	images      map[ContainerImage]int
+	pullSecrets map[string]bool
+}
+
+func newImageInventory() *imageInventory {
+	return &imageInventory{
+		images:      map[ContainerImage]int{},
+		pullSecrets: map[string]bool{},
+	}
+}
+
+// addPod records the images of all the containers of the Pod, counting the
+// Pod once per image even if several of its containers run it.
+func (inv *imageInventory) addPod(pod *unstructured.Unstructured) {
+	seen := map[ContainerImage]bool{}
+	for _, field := range podContainerFields {
+		containers, _, _ := unstructured.NestedSlice(pod.Object, "spec", field)
+		for _, c := range containers {
+			container, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			image := ContainerImage{}
+			image.Image, _, _ = unstructured.NestedString(container, "image")
+			image.ImagePullPolicy, _, _ = unstructured.NestedString(container, "imagePullPolicy")
+			if image.Image == "" || seen[image] {
+				continue
+			}
+			seen[image] = true
+			inv.images[image]++
+		}
+	}
+
+	secrets, _, _ := unstructured.NestedSlice(pod.Object, "spec", "imagePullSecrets")
+	for _, s := range secrets {
+		secret, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name, _, _ := unstructured.NestedString(secret, "name"); name != "" {
+			inv.pullSecrets[name] = true
+		}
+	}
+}
+
+func (inv *imageInventory) namespaceImages(namespace string) *NamespaceImages {
+	result := &NamespaceImages{
+		Namespace: namespace,
+		Images:    make([]*ContainerImage, 0, len(inv.images)),
+	}
+	for image, pods := range inv.images {
+		image := image
+		image.Pods = pods
+		result.Images = append(result.Images, &image)
+	}
+	sort.Slice(result.Images, func(i, j int) bool {
+		if result.Images[i].Image != result.Images[j].Image {
+			return result.Images[i].Image < result.Images[j].Image
+		}
+		return result.Images[i].ImagePullPolicy < result.Images[j].ImagePullPolicy
+	})
+	for name := range inv.pullSecrets {
+		result.ImagePullSecrets = append(result.ImagePullSecrets, name)
+	}
+	sort.Strings(result.ImagePullSecrets)
+	return result
+}
@@ -0,0 +1,84 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func getImagesPod(namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]interface{}{
+				"namespace": namespace,
+				"name":      name,
+			},
+			"spec": spec,
+		},
+	}
+}
+
+func TestDataGathererImagesFetch(t *testing.T) {
+	container := func(image, pullPolicy string) interface{} {
+		return map[string]interface{}{"name": "c", "image": image, "imagePullPolicy": pullPolicy}
+	}
+
+	dg := &DataGathererImages{
+		DynamicWrapper: DynamicWrapper{Dynamic: &fakeDataGatherer{
+			data: map[string]interface{}{
+				"items": []*api.GatheredResource{
+					{Resource: getImagesPod("default", "web-1", map[string]interface{}{
+						"initContainers": []interface{}{container("busybox", "Always")},
+						"containers": []interface{}{
+							container("nginx:1.19", "IfNotPresent"),
+							container("nginx:1.19", "IfNotPresent"),
+						},
+						"imagePullSecrets": []interface{}{
+							map[string]interface{}{"name": "registry"},
+						},
+					})},
+					{Resource: getImagesPod("default", "web-2", map[string]interface{}{
+						"containers": []interface{}{container("nginx:1.19", "IfNotPresent")},
+					})},
+					{Resource: getImagesPod("monitoring", "prometheus", map[string]interface{}{
+						"containers": []interface{}{container("prom/prometheus", "")},
+					})},
+					{Resource: getImagesPod("default", "deleted", map[string]interface{}{
+						"containers": []interface{}{container("deleted", "")},
+					}), DeletedAt: api.Time{Time: clock.now()}},
+				},
+			},
+		}},
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*NamespaceImages{
+		{
+			Namespace: "default",
+			Images: []*ContainerImage{
+				{Image: "busybox", ImagePullPolicy: "Always", Pods: 1},
+				{Image: "nginx:1.19", ImagePullPolicy: "IfNotPresent", Pods: 2},
+			},
+			ImagePullSecrets: []string{"registry"},
+		},
+		{
+			Namespace: "monitoring",
+			Images: []*ContainerImage{
+				{Image: "prom/prometheus", Pods: 1},
+			},
+		},
+	}
+
+	namespaces := data.(map[string]interface{})["namespaces"].([]*NamespaceImages)
+	if diff, equal := messagediff.PrettyDiff(expected, namespaces); !equal {
+		t.Errorf("unexpected images:\n%s", diff)
+	}
+}
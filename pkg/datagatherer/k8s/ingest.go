@@ -0,0 +1,144 @@
+package k8s
+
+import (
+	"context"
+
+	"github.com/jetstack/preflight/pkg/logs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// ingestedSecretFields are the fields of the Secrets stored by the informers:
+// the fields sent to the backend, plus the labels needed to exclude Secrets
+// and the resource version needed by the informers to resume watching. The
+// other fields, the private keys included, never enter the informers.
+var ingestedSecretFields = append(append([]string{}, SecretSelectedFields...),
+	"metadata.labels",
+	"metadata.resourceVersion",
+)
+
+// ingestFunc modifies a resource of the given type as it is listed or
+// watched, before it is stored by the informers.
+type ingestFunc func(gvr schema.GroupVersionResource, resource *unstructured.Unstructured)
+
+// newIngestFunc returns the ingestFunc removing the fields at the paths, the
+// data of the Secrets but their certificates, and the RedactFields.
+func newIngestFunc(paths [][]string) ingestFunc {
+	return func(gvr schema.GroupVersionResource, resource *unstructured.Unstructured) {
+		removeFields(resource, paths)
+
+		if gvr.Group == "" && gvr.Resource == "secrets" {
+			if err := Select(ingestedSecretFields, resource); err != nil {
+				// never keep the data of a Secret which could not be redacted
+				logs.Log.Warnf("failed to redact secret %s/%s: %v", resource.GetNamespace(), resource.GetName(), err)
+				unstructured.RemoveNestedField(resource.Object, "data")
+				unstructured.RemoveNestedField(resource.Object, "stringData")
+			}
+		}
+
+		if err := Redact(RedactFields, resource); err != nil {
+			logs.Log.Warnf("failed to redact %s %s/%s: %v", gvr.Resource, resource.GetNamespace(), resource.GetName(), err)
+		}
+	}
+}
+
+// ingestingClient is a dynamic client modifying the resources it lists and
+// watches, so that the informers only ever store the modified resources. The
+// informers of this version of client-go cannot transform the resources they
+// store.
+type ingestingClient struct {
+	dynamic.Interface
+	ingest ingestFunc
+}
+
+// newIngestingClient wraps the client to apply ingest to every resource.
+func newIngestingClient(cl dynamic.Interface, ingest ingestFunc) dynamic.Interface {
+	return &ingestingClient{Interface: cl, ingest: ingest}
+}
+
+func (c *ingestingClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &ingestingNamespaceableResource{
+		NamespaceableResourceInterface: c.Interface.Resource(gvr),
+		gvr:                            gvr,
+		ingest:                         c.ingest,
+	}
+}
+
+type ingestingNamespaceableResource struct {
+	dynamic.NamespaceableResourceInterface
+	gvr    schema.GroupVersionResource
+	ingest ingestFunc
+}
+
+func (r *ingestingNamespaceableResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &ingestingResource{
+		ResourceInterface: r.NamespaceableResourceInterface.Namespace(namespace),
+		gvr:               r.gvr,
+		ingest:            r.ingest,
+	}
+}
+
+func (r *ingestingNamespaceableResource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	list, err := r.NamespaceableResourceInterface.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	ingestList(list, r.gvr, r.ingest)
+	return list, nil
+}
+
+func (r *ingestingNamespaceableResource) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := r.NamespaceableResourceInterface.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ingestWatch(w, r.gvr, r.ingest), nil
+}
+
+type ingestingResource struct {
+	dynamic.ResourceInterface
+	gvr    schema.GroupVersionResource
+	ingest ingestFunc
+}
+
+func (r *ingestingResource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	list, err := r.ResourceInterface.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	ingestList(list, r.gvr, r.ingest)
+	return list, nil
+}
+
+func (r *ingestingResource) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	w, err := r.ResourceInterface.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ingestWatch(w, r.gvr, r.ingest), nil
+}
+
+// ingestList applies ingest to the items of a list. The items are decoded for
+// each request, so they are modified in place.
+func ingestList(list *unstructured.UnstructuredList, gvr schema.GroupVersionResource, ingest ingestFunc) {
+	for i := range list.Items {
+		ingest(gvr, &list.Items[i])
+	}
+}
+
+// ingestWatch applies ingest to the resources of the watch events. The errors
+// and bookmarks are passed as is.
+func ingestWatch(w watch.Interface, gvr schema.GroupVersionResource, ingest ingestFunc) watch.Interface {
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		switch event.Type {
+		case watch.Added, watch.Modified, watch.Deleted:
+			if resource, ok := event.Object.(*unstructured.Unstructured); ok {
+				ingest(gvr, resource)
+			}
+		}
+		return event, true
+	})
+}
@@ -0,0 +1,139 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+// secretValues are the values which must never be stored by the data gatherer
+var secretValues = []string{"private-key", "hunter2", "string-data"}
+
+func getIngestedSecret(name string) *unstructured.Unstructured {
+	secret := getSecret(name, "default", map[string]interface{}{
+		"tls.crt":  "certificate",
+		"tls.key":  "private-key",
+		"password": "hunter2",
+	}, true, true)
+	secret.Object["stringData"] = map[string]interface{}{"token": "string-data"}
+	secret.SetLabels(map[string]string{"app": "web"})
+	secret.SetResourceVersion("1")
+	return secret
+}
+
+func TestNewIngestFunc(t *testing.T) {
+	paths, err := removePaths([]string{"/spec/containers/*/env"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ingest := newIngestFunc(paths)
+
+	secret := getIngestedSecret("web")
+	ingest(secretsGVR, secret)
+
+	expected := getSecret("web", "default", map[string]interface{}{"tls.crt": "certificate"}, true, false)
+	// the labels and the resource version are needed by the informers
+	expected.SetLabels(map[string]string{"app": "web"})
+	expected.SetResourceVersion("1")
+	if diff, equal := messagediff.PrettyDiff(expected.Object, secret.Object); !equal {
+		t.Errorf("unexpected secret:\n%s", diff)
+	}
+
+	pod := getPodWithEnv("example", "default")
+	pod.Object["metadata"].(map[string]interface{})["managedFields"] = "set"
+	ingest(schema.GroupVersionResource{Version: "v1", Resource: "pods"}, pod)
+
+	if _, found := pod.Object["metadata"].(map[string]interface{})["managedFields"]; found {
+		t.Errorf("expected the managed fields to be removed, got %v", pod.Object["metadata"])
+	}
+	containers, _, _ := unstructured.NestedSlice(pod.Object, "spec", "containers")
+	if _, found := containers[0].(map[string]interface{})["env"]; found {
+		t.Errorf("expected the env of the containers to be removed, got %v", containers[0])
+	}
+}
+
+func TestDataGathererDynamicIngestSecrets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{secretsGVR: "UnstructuredList"},
+		getIngestedSecret("listed"),
+	)
+
+	config := ConfigDynamic{GroupVersionResource: secretsGVR}
+	dg, err := config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g := dg.(*DataGathererDynamic)
+
+	// a Secret received from the watch rather than the list
+	if _, err := cl.Resource(secretsGVR).Namespace("default").Create(ctx, getIngestedSecret("watched"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return len(g.informers[secretsGVR].GetStore().List()) == 2 && g.cache.ItemCount() == 2, nil
+	})
+	if err != nil {
+		t.Fatalf("expected the listed and the watched secrets to be stored, got %d", g.cache.ItemCount())
+	}
+
+	assertRedacted := func(where string, resource interface{}) {
+		data, err := json.Marshal(resource)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, value := range secretValues {
+			if strings.Contains(string(data), value) {
+				t.Errorf("expected %q never to be stored in the %s, got %s", value, where, data)
+			}
+		}
+		if !strings.Contains(string(data), "certificate") {
+			t.Errorf("expected the certificate to be stored in the %s, got %s", where, data)
+		}
+	}
+
+	// no Fetch has redacted the stored secrets yet
+	for _, resource := range g.informers[secretsGVR].GetStore().List() {
+		assertRedacted("informer", resource)
+	}
+	for _, item := range g.cache.Items() {
+		assertRedacted("cache", item.Object.(*api.GatheredResource).Resource)
+	}
+
+	// Fetch redacts copies of the stored secrets, the informers keep the
+	// labels and resource versions they need
+	res, err := g.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, item := range res.(map[string]interface{})["items"].([]*api.GatheredResource) {
+		secret := item.Resource.(*unstructured.Unstructured)
+		if secret.GetResourceVersion() != "" || len(secret.GetLabels()) > 0 {
+			t.Errorf("expected the fetched secret %q to be redacted, got %v", secret.GetName(), secret.Object)
+		}
+	}
+	for _, obj := range g.informers[secretsGVR].GetStore().List() {
+		secret := obj.(*unstructured.Unstructured)
+		if secret.GetResourceVersion() != "1" || secret.GetLabels()["app"] != "web" {
+			t.Errorf("expected the stored secret %q to be left alone by Fetch, got %v", secret.GetName(), secret.Object)
+		}
+	}
+}
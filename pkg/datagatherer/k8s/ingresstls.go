@@ -0,0 +1,356 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ingressTLSResourceTypes are the resources exposing hostnames. The preferred
+// version of each group is resolved with the discovery API, so the Gateway
+// API and OpenShift Routes are simply ignored on clusters not serving them.
+var ingressTLSResourceTypes = []schema.GroupVersionResource{
+	{Group: "networking.k8s.io", Version: "*", Resource: "ingresses"},
+	{Group: "gateway.networking.k8s.io", Version: "*", Resource: "gateways"},
+	{Group: "gateway.networking.k8s.io", Version: "*", Resource: "httproutes"},
+	{Group: "route.openshift.io", Version: "*", Resource: "routes"},
+}
+
+// ConfigIngressTLS contains the configuration for the k8s-ingress-tls
+// data-gatherer.
+type ConfigIngressTLS struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+}
+
+// DynamicConfig returns the configuration of the dynamic data gatherer used to
+// collect the resources exposing hostnames.
+func (c *ConfigIngressTLS) DynamicConfig() *ConfigDynamic {
+	return &ConfigDynamic{
+		KubeConfigPath:        c.KubeConfigPath,
+		KubeConfigContext:     c.KubeConfigContext,
+		GroupVersionResources: ingressTLSResourceTypes,
+		ExcludeNamespaces:     c.ExcludeNamespaces,
+		IncludeNamespaces:     c.IncludeNamespaces,
+	}
+}
+
+// NewDataGatherer constructs a new instance of the k8s-ingress-tls data-gatherer.
+func (c *ConfigIngressTLS) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	dynamicDg, err := c.DynamicConfig().NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGathererIngressTLS{DynamicWrapper: DynamicWrapper{Dynamic: dynamicDg}}, nil
+}
+
+// DataGathererIngressTLS gathers Ingresses, Gateway API Gateways and
+// HTTPRoutes, and OpenShift Routes, and emits the list of exposed hostnames
+// along with the certificates securing them.
+type DataGathererIngressTLS struct {
+	DynamicWrapper
+}
+
+// ExposedHost is a hostname exposed by a resource.
+type ExposedHost struct {
+	Hostname string `json:"hostname"`
+	// Kind, Namespace and Name identify the resource exposing the hostname.
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// TLS is true if the hostname is served over TLS.
+	TLS bool `json:"tls"`
+	// SecretNamespace and SecretName reference the Secret containing the
+	// certificate, if any.
+	SecretNamespace string `json:"secretNamespace,omitempty"`
+	SecretName      string `json:"secretName,omitempty"`
+	// Certificates are set when the certificate is inlined in the resource,
+	// as is the case for OpenShift Routes.
+	Certificates []*certinfo.Certificate `json:"certificates,omitempty"`
+	// Error is set if an inlined certificate could not be parsed.
+	Error string `json:"error,omitempty"`
+}
+
+// Fetch builds the list of exposed hostnames from the resources currently in
+// the cache. Deleted resources are ignored.
+func (g *DataGathererIngressTLS) Fetch() (interface{}, error) {
+	data, err := g.Dynamic.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	var ingresses, gateways, httpRoutes, routes []*unstructured.Unstructured
+	err = VisitGatheredResources(data, func(item *api.GatheredResource) error {
+		if !item.DeletedAt.IsZero() {
+			return nil
+		}
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("failed to parse cached resource")
+		}
+		switch resource.GetKind() {
+		case "Ingress":
+			ingresses = append(ingresses, resource)
+		case "Gateway":
+			gateways = append(gateways, resource)
+		case "HTTPRoute":
+			httpRoutes = append(httpRoutes, resource)
+		case "Route":
+			routes = append(routes, resource)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := []*ExposedHost{}
+	for _, ingress := range ingresses {
+		hosts = append(hosts, ingressHosts(ingress)...)
+	}
+	for _, gateway := range gateways {
+		hosts = append(hosts, gatewayHosts(gateway)...)
+	}
+	for _, route := range httpRoutes {
+		hosts = append(hosts, httpRouteHosts(route, gateways)...)
+	}
+	for _, route := range routes {
+		hosts = append(hosts, openShiftRouteHosts(route)...)
+	}
+
+	sort.SliceStable(hosts, func(i, j int) bool {
+		if hosts[i].Hostname != hosts[j].Hostname {
+			return hosts[i].Hostname < hosts[j].Hostname
+		}
+		return hosts[i].Kind+"/"+hosts[i].Namespace+"/"+hosts[i].Name < hosts[j].Kind+"/"+hosts[j].Namespace+"/"+hosts[j].Name
+	})
+
+	return map[string]interface{}{
+		"hosts": hosts,
+	}, nil
+}
+
+func newExposedHost(resource *unstructured.Unstructured, hostname string) *ExposedHost {
+	return &ExposedHost{
+		Hostname:  hostname,
+		Kind:      resource.GetKind(),
+		Namespace: resource.GetNamespace(),
+		Name:      resource.GetName(),
+	}
+}
+
+// ingressHosts returns the hosts in the TLS section of the Ingress, secured
+// by their Secret, followed by the hosts of the rules that are not secured.
+func ingressHosts(ingress *unstructured.Unstructured) []*ExposedHost {
+	var hosts []*ExposedHost
+	secured := map[string]bool{}
+
+	tlsList, _, _ := unstructured.NestedSlice(ingress.Object, "spec", "tls")
+	for _, t := range tlsList {
+		tls, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		secretName, _, _ := unstructured.NestedString(tls, "secretName")
+		tlsHosts, _, _ := unstructured.NestedStringSlice(tls, "hosts")
+		for _, hostname := range tlsHosts {
+			host := newExposedHost(ingress, hostname)
+			host.TLS = true
+			host.SecretName = secretName
+			if secretName != "" {
+				host.SecretNamespace = ingress.GetNamespace()
+			}
+			hosts = append(hosts, host)
+			secured[hostname] = true
+		}
+	}
+
+	rules, _, _ := unstructured.NestedSlice(ingress.Object, "spec", "rules")
+	for _, r := range rules {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		hostname, _, _ := unstructured.NestedString(rule, "host")
+		if hostname == "" || secured[hostname] {
+			continue
+		}
+		secured[hostname] = true
+		hosts = append(hosts, newExposedHost(ingress, hostname))
+	}
+
+	return hosts
+}
+
+// gatewayListener is the subset of a Gateway listener needed to find the
+// certificate securing a hostname.
+type gatewayListener struct {
+	name            string
+	hostname        string
+	tls             bool
+	secretNamespace string
+	secretName      string
+}
+
+// gatewayListeners supports both the certificateRefs list of recent Gateway
+// API versions and the single certificateRef of v1alpha1.
+func gatewayListeners(gateway *unstructured.Unstructured) []gatewayListener {
+	var listeners []gatewayListener
+	list, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	for _, l := range list {
+		listener, ok := l.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		gl := gatewayListener{}
+		gl.name, _, _ = unstructured.NestedString(listener, "name")
+		gl.hostname, _, _ = unstructured.NestedString(listener, "hostname")
+		protocol, _, _ := unstructured.NestedString(listener, "protocol")
+		gl.tls = protocol == "HTTPS" || protocol == "TLS"
+
+		var ref map[string]interface{}
+		if refs, found, _ := unstructured.NestedSlice(listener, "tls", "certificateRefs"); found && len(refs) > 0 {
+			ref, _ = refs[0].(map[string]interface{})
+		} else if r, found, _ := unstructured.NestedMap(listener, "tls", "certificateRef"); found {
+			ref = r
+		}
+		if ref != nil {
+			if kind, _, _ := unstructured.NestedString(ref, "kind"); kind == "" || kind == "Secret" {
+				gl.secretName, _, _ = unstructured.NestedString(ref, "name")
+				gl.secretNamespace, _, _ = unstructured.NestedString(ref, "namespace")
+				if gl.secretNamespace == "" {
+					gl.secretNamespace = gateway.GetNamespace()
+				}
+			}
+		}
+		listeners = append(listeners, gl)
+	}
+	return listeners
+}
+
+// gatewayHosts returns the hostnames of the listeners of the Gateway. A
+// listener without hostname accepts any hostname and is reported as "*".
+func gatewayHosts(gateway *unstructured.Unstructured) []*ExposedHost {
+	var hosts []*ExposedHost
+	for _, listener := range gatewayListeners(gateway) {
+		hostname := listener.hostname
+		if hostname == "" {
+			hostname = "*"
+		}
+		host := newExposedHost(gateway, hostname)
+		host.TLS = listener.tls
+		host.SecretNamespace = listener.secretNamespace
+		host.SecretName = listener.secretName
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// httpRouteHosts returns the hostnames of the HTTPRoute, using the listeners
+// of the parent Gateways to find out how they are secured.
+func httpRouteHosts(route *unstructured.Unstructured, gateways []*unstructured.Unstructured) []*ExposedHost {
+	var hosts []*ExposedHost
+	hostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+	parentRefs, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+
+	for _, hostname := range hostnames {
+		host := newExposedHost(route, hostname)
+		for _, p := range parentRefs {
+			parentRef, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if listener, ok := matchingListener(route, parentRef, hostname, gateways); ok && listener.tls {
+				host.TLS = true
+				host.SecretNamespace = listener.secretNamespace
+				host.SecretName = listener.secretName
+				break
+			}
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// matchingListener finds the listener of the Gateway referenced by parentRef
+// that serves the hostname.
+func matchingListener(route *unstructured.Unstructured, parentRef map[string]interface{}, hostname string, gateways []*unstructured.Unstructured) (gatewayListener, bool) {
+	if kind, _, _ := unstructured.NestedString(parentRef, "kind"); kind != "" && kind != "Gateway" {
+		return gatewayListener{}, false
+	}
+	name, _, _ := unstructured.NestedString(parentRef, "name")
+	namespace, _, _ := unstructured.NestedString(parentRef, "namespace")
+	if namespace == "" {
+		namespace = route.GetNamespace()
+	}
+	sectionName, _, _ := unstructured.NestedString(parentRef, "sectionName")
+
+	for _, gateway := range gateways {
+		if gateway.GetName() != name || gateway.GetNamespace() != namespace {
+			continue
+		}
+		for _, listener := range gatewayListeners(gateway) {
+			if sectionName != "" && listener.name != sectionName {
+				continue
+			}
+			if matchesHostname(listener.hostname, hostname) {
+				return listener, true
+			}
+		}
+	}
+	return gatewayListener{}, false
+}
+
+// matchesHostname returns true if the hostname is accepted by the listener
+// hostname, which can be empty to accept any hostname or start with a "*."
+// wildcard label.
+func matchesHostname(listenerHostname, hostname string) bool {
+	switch {
+	case listenerHostname == "" || listenerHostname == hostname:
+		return true
+	case strings.HasPrefix(listenerHostname, "*."):
+		return strings.HasSuffix(hostname, listenerHostname[1:])
+	default:
+		return false
+	}
+}
+
+// openShiftRouteHosts returns the host of the Route, OpenShift Routes inline
+// their certificate which is parsed.
+func openShiftRouteHosts(route *unstructured.Unstructured) []*ExposedHost {
+	hostname, _, _ := unstructured.NestedString(route.Object, "spec", "host")
+	if hostname == "" {
+		return nil
+	}
+	host := newExposedHost(route, hostname)
+
+	tls, found, _ := unstructured.NestedMap(route.Object, "spec", "tls")
+	if !found {
+		return []*ExposedHost{host}
+	}
+	host.TLS = true
+	if certificate, _, _ := unstructured.NestedString(tls, "certificate"); certificate != "" {
+		certs, err := certinfo.DescribePEM([]byte(certificate))
+		if err != nil {
+			host.Error = err.Error()
+		} else {
+			host.Certificates = certs
+		}
+	}
+	return []*ExposedHost{host}
+}
@@ -0,0 +1,108 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func getNetworkingObject(kind, namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"kind": kind,
+			"metadata": map[string]interface{}{
+				"namespace": namespace,
+				"name":      name,
+			},
+			"spec": spec,
+		},
+	}
+}
+
+func TestDataGathererIngressTLSFetch(t *testing.T) {
+	dg := &DataGathererIngressTLS{
+		DynamicWrapper: DynamicWrapper{Dynamic: &fakeDataGatherer{
+			data: map[string]interface{}{
+				"items": []*api.GatheredResource{
+					{Resource: getNetworkingObject("Ingress", "default", "web", map[string]interface{}{
+						"tls": []interface{}{
+							map[string]interface{}{
+								"hosts":      []interface{}{"example.com"},
+								"secretName": "web-tls",
+							},
+						},
+						"rules": []interface{}{
+							map[string]interface{}{"host": "example.com"},
+							map[string]interface{}{"host": "plain.example.com"},
+						},
+					})},
+					{Resource: getNetworkingObject("Gateway", "infra", "gateway", map[string]interface{}{
+						"listeners": []interface{}{
+							map[string]interface{}{
+								"name":     "https",
+								"hostname": "*.apps.example.com",
+								"protocol": "HTTPS",
+								"tls": map[string]interface{}{
+									"certificateRefs": []interface{}{
+										map[string]interface{}{"name": "apps-tls"},
+									},
+								},
+							},
+						},
+					})},
+					{Resource: getNetworkingObject("HTTPRoute", "shop", "shop", map[string]interface{}{
+						"hostnames": []interface{}{"shop.apps.example.com", "shop.example.org"},
+						"parentRefs": []interface{}{
+							map[string]interface{}{"name": "gateway", "namespace": "infra"},
+						},
+					})},
+					{Resource: getNetworkingObject("Route", "console", "console", map[string]interface{}{
+						"host": "console.example.com",
+						"tls": map[string]interface{}{
+							"termination": "edge",
+						},
+					})},
+				},
+			},
+		}},
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*ExposedHost{
+		{Hostname: "*.apps.example.com", Kind: "Gateway", Namespace: "infra", Name: "gateway", TLS: true, SecretNamespace: "infra", SecretName: "apps-tls"},
+		{Hostname: "console.example.com", Kind: "Route", Namespace: "console", Name: "console", TLS: true},
+		{Hostname: "example.com", Kind: "Ingress", Namespace: "default", Name: "web", TLS: true, SecretNamespace: "default", SecretName: "web-tls"},
+		{Hostname: "plain.example.com", Kind: "Ingress", Namespace: "default", Name: "web"},
+		{Hostname: "shop.apps.example.com", Kind: "HTTPRoute", Namespace: "shop", Name: "shop", TLS: true, SecretNamespace: "infra", SecretName: "apps-tls"},
+		{Hostname: "shop.example.org", Kind: "HTTPRoute", Namespace: "shop", Name: "shop"},
+	}
+
+	hosts := data.(map[string]interface{})["hosts"].([]*ExposedHost)
+	if diff, equal := messagediff.PrettyDiff(expected, hosts); !equal {
+		t.Errorf("unexpected hosts:\n%s", diff)
+	}
+}
+
+func TestMatchesHostname(t *testing.T) {
+	tests := []struct {
+		listener, hostname string
+		want               bool
+	}{
+		{"", "example.com", true},
+		{"example.com", "example.com", true},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"example.com", "www.example.com", false},
+	}
+	for _, test := range tests {
+		if got := matchesHostname(test.listener, test.hostname); got != test.want {
+			t.Errorf("matchesHostname(%q, %q) = %v, want %v", test.listener, test.hostname, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package k8s
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/logs"
+)
+
+// The paths of the service account issuer discovery documents, served by the
+// API server.
+const (
+	openIDConfigurationPath = "/.well-known/openid-configuration"
+	jwksPath                = "/openid/v1/jwks"
+)
+
+// ConfigServiceAccountIssuer contains the configuration for the
+// k8s-service-account-issuer data-gatherer.
+type ConfigServiceAccountIssuer struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// StatePath, if set, is a file the time each key was first seen is
+	// saved to and restored from when the data gatherer starts. Without it,
+	// the keys are first seen when the agent starts, and their age resets on
+	// every restart.
+	StatePath string `yaml:"state-path"`
+}
+
+// NewDataGatherer constructs a new instance of the k8s-service-account-issuer
+// data-gatherer.
+func (c *ConfigServiceAccountIssuer) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	cl, err := NewDiscoveryClientForContext(c.KubeConfigPath, c.KubeConfigContext)
+	if err != nil {
+		return nil, err
+	}
+	restClient := cl.RESTClient()
+
+	g := &DataGathererServiceAccountIssuer{
+		ctx: ctx,
+		get: func(ctx context.Context, path string) ([]byte, error) {
+			return restClient.Get().AbsPath(path).Do(ctx).Raw()
+		},
+		statePath: c.StatePath,
+		firstSeen: map[string]time.Time{},
+	}
+	if g.statePath != "" {
+		// a missing or corrupt state only means seeing the keys again
+		if err := g.restoreState(); err != nil {
+			logs.Log.Errorf("failed to restore the service account issuer keys from %q: %v", g.statePath, err)
+		}
+	}
+	return g, nil
+}
+
+// DataGathererServiceAccountIssuer reads the service account issuer discovery
+// documents of the API server, and reports the issuer and its signing keys,
+// so that the rotation of the keys signing the service account tokens can be
+// audited.
+type DataGathererServiceAccountIssuer struct {
+	ctx context.Context
+	// get reads a path of the API server.
+	get func(ctx context.Context, path string) ([]byte, error)
+	// statePath is the file firstSeen is persisted to, if set.
+	statePath string
+
+	mu sync.Mutex
+	// firstSeen is when each key was first seen, by key ID, as the JWKS
+	// does not tell when the keys were created.
+	firstSeen map[string]time.Time
+}
+
+// serviceAccountIssuerState is the on-disk representation of the keys seen
+// by the data gatherer.
+type serviceAccountIssuerState struct {
+	FirstSeen map[string]time.Time `json:"firstSeen"`
+}
+
+// ServiceAccountIssuer is the service account issuer of a cluster.
+type ServiceAccountIssuer struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwksURI"`
+	IDTokenSigningAlgValuesSupported []string `json:"idTokenSigningAlgValuesSupported,omitempty"`
+	// Keys are the public keys of the JWKS, sorted by key ID.
+	Keys []*ServiceAccountIssuerKey `json:"keys"`
+}
+
+// ServiceAccountIssuerKey is a public key of the JWKS of the service account
+// issuer. The key material is not reported.
+type ServiceAccountIssuerKey struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	// KeyAlgorithm describes the key as the certificates do, e.g. RSA-2048.
+	KeyAlgorithm string `json:"keyAlgorithm,omitempty"`
+	// FirstSeen is when the agent first saw the key. It is only kept across
+	// restarts of the agent when the data gatherer has a state path,
+	// otherwise it is when the agent first saw the key since it started.
+	FirstSeen api.Time `json:"firstSeen"`
+	// AgeSeconds is the number of seconds since FirstSeen.
+	AgeSeconds int64 `json:"ageSeconds"`
+}
+
+// openIDConfiguration is the part of the OpenID configuration document read
+// by the data gatherer.
+type openIDConfiguration struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// jsonWebKeySet is the part of the JWKS read by the data gatherer.
+type jsonWebKeySet struct {
+	Keys []struct {
+		KeyID     string `json:"kid"`
+		KeyType   string `json:"kty"`
+		Algorithm string `json:"alg"`
+		Use       string `json:"use"`
+		// N is the modulus of the RSA keys.
+		N string `json:"n"`
+		// Curve is the curve of the EC keys.
+		Curve string `json:"crv"`
+	} `json:"keys"`
+}
+
+func (g *DataGathererServiceAccountIssuer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGathererServiceAccountIssuer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete forgets when the keys were first seen.
+func (g *DataGathererServiceAccountIssuer) Delete() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.firstSeen = map[string]time.Time{}
+	return nil
+}
+
+func (g *DataGathererServiceAccountIssuer) Healthy() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch reads the OpenID configuration and the JWKS of the service account
+// issuer. The JWKS is read from the API server rather than from its jwks_uri,
+// which may only be reachable from outside the cluster.
+func (g *DataGathererServiceAccountIssuer) Fetch() (interface{}, error) {
+	data, err := g.get(g.ctx, openIDConfigurationPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the service account issuer configuration: %v", err)
+	}
+	var config openIDConfiguration
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse the service account issuer configuration: %v", err)
+	}
+
+	data, err = g.get(g.ctx, jwksPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the service account issuer keys: %v", err)
+	}
+	var jwks jsonWebKeySet
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to parse the service account issuer keys: %v", err)
+	}
+
+	issuer := &ServiceAccountIssuer{
+		Issuer:                           config.Issuer,
+		JWKSURI:                          config.JWKSURI,
+		IDTokenSigningAlgValuesSupported: config.IDTokenSigningAlgValuesSupported,
+		Keys:                             []*ServiceAccountIssuerKey{},
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := clock.now()
+	seen := map[string]time.Time{}
+	for _, key := range jwks.Keys {
+		firstSeen, ok := g.firstSeen[key.KeyID]
+		if !ok {
+			firstSeen = now
+		}
+		seen[key.KeyID] = firstSeen
+		issuer.Keys = append(issuer.Keys, &ServiceAccountIssuerKey{
+			KeyID:        key.KeyID,
+			KeyType:      key.KeyType,
+			Algorithm:    key.Algorithm,
+			Use:          key.Use,
+			KeyAlgorithm: jwkKeyAlgorithm(key.KeyType, key.N, key.Curve),
+			FirstSeen:    api.Time{Time: firstSeen},
+			AgeSeconds:   int64(now.Sub(firstSeen) / time.Second),
+		})
+	}
+	// the keys no longer served are forgotten
+	changed := len(seen) != len(g.firstSeen)
+	for keyID := range seen {
+		if _, ok := g.firstSeen[keyID]; !ok {
+			changed = true
+		}
+	}
+	g.firstSeen = seen
+	if changed && g.statePath != "" {
+		if err := g.saveState(); err != nil {
+			logs.Log.Errorf("failed to save the service account issuer keys to %q: %v", g.statePath, err)
+		}
+	}
+
+	sort.Slice(issuer.Keys, func(i, j int) bool {
+		return issuer.Keys[i].KeyID < issuer.Keys[j].KeyID
+	})
+
+	return map[string]interface{}{
+		"issuer": issuer,
+	}, nil
+}
+
+// saveState writes the time each key was first seen to the state path,
+// through a temporary file so that a crash never leaves a partial state.
+func (g *DataGathererServiceAccountIssuer) saveState() error {
+	data, err := json.Marshal(serviceAccountIssuerState{FirstSeen: g.firstSeen})
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(g.statePath), filepath.Base(g.statePath)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create state: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), g.statePath); err != nil {
+		return fmt.Errorf("failed to replace state: %v", err)
+	}
+	return nil
+}
+
+// restoreState reads the time each key was first seen from the state path.
+// A missing state is not an error.
+func (g *DataGathererServiceAccountIssuer) restoreState() error {
+	data, err := ioutil.ReadFile(g.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state: %v", err)
+	}
+	var state serviceAccountIssuerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse state: %v", err)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for keyID, firstSeen := range state.FirstSeen {
+		g.firstSeen[keyID] = firstSeen
+	}
+	return nil
+}
+
+// jwkKeyAlgorithm describes the key of a JWK, e.g. RSA-2048 or ECDSA-P-256,
+// or returns an empty string for the other key types.
+func jwkKeyAlgorithm(keyType, modulus, curve string) string {
+	switch keyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(modulus)
+		if err != nil || len(n) == 0 {
+			return ""
+		}
+		// the modulus is big-endian without leading zeros
+		bits := len(n) * 8
+		for b := n[0]; b&0x80 == 0 && bits > 0; b <<= 1 {
+			bits--
+		}
+		return fmt.Sprintf("RSA-%d", bits)
+	case "EC":
+		if curve == "" {
+			return ""
+		}
+		return "ECDSA-" + curve
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,124 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// settableTime is a clock whose time is set by the tests.
+type settableTime struct {
+	time time.Time
+}
+
+func (s *settableTime) now() time.Time {
+	return s.time
+}
+
+func TestDataGathererServiceAccountIssuerFetch(t *testing.T) {
+	now := &settableTime{time: time.Unix(1615918935, 0)}
+	defer func(c timeInterface) { clock = c }(clock)
+	clock = now
+
+	jwks := `{"keys":[{"kid":"key-1","kty":"RSA","alg":"RS256","use":"sig","n":"xjlCRBqkQRwhMdEnh1s_v5KX5Kf6HInf8QtTH2KhFkM","e":"AQAB"}]}`
+	dg := &DataGathererServiceAccountIssuer{
+		ctx: context.Background(),
+		get: func(_ context.Context, path string) ([]byte, error) {
+			switch path {
+			case openIDConfigurationPath:
+				return []byte(`{"issuer":"https://kubernetes.default.svc","jwks_uri":"https://10.0.0.1:443/openid/v1/jwks","id_token_signing_alg_values_supported":["RS256"]}`), nil
+			case jwksPath:
+				return []byte(jwks), nil
+			default:
+				return nil, fmt.Errorf("unexpected path %q", path)
+			}
+		},
+		firstSeen: map[string]time.Time{},
+	}
+
+	fetch := func() *ServiceAccountIssuer {
+		data, err := dg.Fetch()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return data.(map[string]interface{})["issuer"].(*ServiceAccountIssuer)
+	}
+
+	issuer := fetch()
+	if issuer.Issuer != "https://kubernetes.default.svc" || issuer.JWKSURI != "https://10.0.0.1:443/openid/v1/jwks" {
+		t.Errorf("unexpected issuer: %+v", issuer)
+	}
+	if len(issuer.Keys) != 1 {
+		t.Fatalf("expected one key, got %d", len(issuer.Keys))
+	}
+	if key := issuer.Keys[0]; key.KeyID != "key-1" || key.KeyAlgorithm != "RSA-256" || key.AgeSeconds != 0 {
+		t.Errorf("unexpected key: %+v", key)
+	}
+
+	// the key is rotated an hour later
+	now.time = now.time.Add(time.Hour)
+	jwks = `{"keys":[{"kid":"key-1","kty":"RSA","n":"xjlCRBqkQRwhMdEnh1s_v5KX5Kf6HInf8QtTH2KhFkM"},{"kid":"key-2","kty":"EC","crv":"P-256"}]}`
+	issuer = fetch()
+	if len(issuer.Keys) != 2 {
+		t.Fatalf("expected two keys, got %d", len(issuer.Keys))
+	}
+	if key := issuer.Keys[0]; key.KeyID != "key-1" || key.AgeSeconds != 3600 {
+		t.Errorf("expected the age of the first key to be an hour, got %+v", key)
+	}
+	if key := issuer.Keys[1]; key.KeyID != "key-2" || key.KeyAlgorithm != "ECDSA-P-256" || key.AgeSeconds != 0 {
+		t.Errorf("unexpected new key: %+v", key)
+	}
+}
+
+func TestDataGathererServiceAccountIssuerState(t *testing.T) {
+	now := &settableTime{time: time.Unix(1615918935, 0)}
+	defer func(c timeInterface) { clock = c }(clock)
+	clock = now
+
+	dir, err := ioutil.TempDir("", "issuer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "issuer.json")
+
+	newDataGatherer := func() *DataGathererServiceAccountIssuer {
+		dg := &DataGathererServiceAccountIssuer{
+			ctx: context.Background(),
+			get: func(_ context.Context, path string) ([]byte, error) {
+				if path == openIDConfigurationPath {
+					return []byte(`{"issuer":"https://kubernetes.default.svc"}`), nil
+				}
+				return []byte(`{"keys":[{"kid":"key-1","kty":"EC","crv":"P-256"}]}`), nil
+			},
+			statePath: statePath,
+			firstSeen: map[string]time.Time{},
+		}
+		if err := dg.restoreState(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return dg
+	}
+	fetchKey := func(dg *DataGathererServiceAccountIssuer) *ServiceAccountIssuerKey {
+		data, err := dg.Fetch()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return data.(map[string]interface{})["issuer"].(*ServiceAccountIssuer).Keys[0]
+	}
+
+	if key := fetchKey(newDataGatherer()); key.AgeSeconds != 0 {
+		t.Errorf("unexpected key: %+v", key)
+	}
+
+	// the agent restarts an hour later, the key keeps its age
+	now.time = now.time.Add(time.Hour)
+	key := fetchKey(newDataGatherer())
+	if key.AgeSeconds != 3600 || !key.FirstSeen.Time.Equal(time.Unix(1615918935, 0)) {
+		t.Errorf("expected the key to be first seen an hour ago, got %+v", key)
+	}
+}
@@ -0,0 +1,67 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// truncation counts the resources affected by the limits of a data gatherer
+// during a Fetch.
+type truncation struct {
+	// Items is the number of resources left out because of max-items.
+	Items int `json:"items,omitempty"`
+	// Objects is the number of resources reduced to the fields identifying
+	// them because of max-object-bytes.
+	Objects int `json:"objects,omitempty"`
+}
+
+func (t truncation) any() bool {
+	return t.Items > 0 || t.Objects > 0
+}
+
+// sortByNamespaceName sorts the resources by namespace, name and uid, so
+// that max-items keeps the same resources from one Fetch to the next.
+func sortByNamespaceName(items []*api.GatheredResource) {
+	key := func(item *api.GatheredResource) string {
+		resource := item.Resource.(*unstructured.Unstructured)
+		return resource.GetNamespace() + "/" + resource.GetName() + "/" + string(resource.GetUID())
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return key(items[i]) < key(items[j])
+	})
+}
+
+// limitObjectSize returns the cached resource if it is within the maximum
+// object size, or a copy of it reduced to the fields identifying it
+// otherwise. The cached resource is left untouched, so that it is returned in
+// full if it shrinks.
+func (g *DataGathererDynamic) limitObjectSize(cacheObject *api.GatheredResource) (*api.GatheredResource, error) {
+	if g.maxObjectBytes == 0 {
+		return cacheObject, nil
+	}
+	resource, ok := cacheObject.Resource.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse cached resource")
+	}
+
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal json for resource: %s", err)
+	}
+	if len(data) <= g.maxObjectBytes {
+		return cacheObject, nil
+	}
+
+	reduced := resource.DeepCopy()
+	if err := Select(projectionIdentityFields, reduced); err != nil {
+		return nil, err
+	}
+	return &api.GatheredResource{
+		Resource:  reduced,
+		DeletedAt: cacheObject.DeletedAt,
+	}, nil
+}
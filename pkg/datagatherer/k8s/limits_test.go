@@ -0,0 +1,103 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestDynamicGatherer_Limits(t *testing.T) {
+	foos := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+
+	for name, tc := range map[string]struct {
+		config          ConfigDynamic
+		expectedItems   int
+		expectedReduced int
+		expectedNames   []string
+		expected        truncation
+	}{
+		"no limits": {
+			config:        ConfigDynamic{GroupVersionResource: foos},
+			expectedItems: 3,
+		},
+		"max items": {
+			config:        ConfigDynamic{GroupVersionResource: foos, MaxItems: 2},
+			expectedItems: 2,
+			// the resources are kept by namespace and name
+			expectedNames: []string{"large", "small1"},
+			expected:      truncation{Items: 1},
+		},
+		"max object bytes": {
+			config:          ConfigDynamic{GroupVersionResource: foos, MaxObjectBytes: 200},
+			expectedItems:   3,
+			expectedReduced: 1,
+			expected:        truncation{Objects: 1},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cl := fake.NewSimpleDynamicClient(runtime.NewScheme())
+			dg, err := tc.config.newDataGathererWithClient(context.Background(), cl)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			g := dg.(*DataGathererDynamic)
+
+			large := getObject("foobar/v1", "Foo", "large", "testns", false)
+			large.Object["spec"] = map[string]interface{}{"data": string(make([]byte, 500))}
+			onAdd(getObject("foobar/v1", "Foo", "small1", "testns", false), g.cache)
+			onAdd(getObject("foobar/v1", "Foo", "small2", "testns", false), g.cache)
+			onAdd(large, g.cache)
+
+			res, err := g.Fetch()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data := res.(map[string]interface{})
+
+			items := data["items"].([]*api.GatheredResource)
+			if len(items) != tc.expectedItems {
+				t.Errorf("expected %d items, got %d", tc.expectedItems, len(items))
+			}
+			reduced := 0
+			for _, item := range items {
+				if _, found := item.Resource.(*unstructured.Unstructured).Object["spec"]; !found && item.Resource.(*unstructured.Unstructured).GetName() == "large" {
+					reduced++
+				}
+			}
+			if reduced != tc.expectedReduced {
+				t.Errorf("expected %d reduced items, got %d", tc.expectedReduced, reduced)
+			}
+			if tc.expectedNames != nil {
+				names := []string{}
+				for _, item := range items {
+					names = append(names, item.Resource.(*unstructured.Unstructured).GetName())
+				}
+				if fmt.Sprint(names) != fmt.Sprint(tc.expectedNames) {
+					t.Errorf("expected the resources %v to be kept, got %v", tc.expectedNames, names)
+				}
+			}
+
+			truncated, found := data["truncated"]
+			if !tc.expected.any() {
+				if found {
+					t.Errorf("unexpected truncation marker: %v", truncated)
+				}
+				return
+			}
+			if truncated != tc.expected {
+				t.Errorf("expected truncation %+v, got %+v", tc.expected, truncated)
+			}
+
+			// the cached resources are left untouched
+			if cached, ok := g.cache.Get("large1"); !ok || cached.(*api.GatheredResource).Resource.(*unstructured.Unstructured).Object["spec"] == nil {
+				t.Errorf("expected the cached resource to be kept in full")
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package k8s
+
+import (
+	"sync/atomic"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/pmylund/go-cache"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// The overheads of the values of unstructured resources, as laid out in
+// memory on 64-bit platforms. They only need to be in the right order of
+// magnitude, the sizes are approximate.
+const (
+	mapOverhead       = 48
+	mapEntryOverhead  = 16
+	sliceOverhead     = 24
+	stringOverhead    = 16
+	scalarSize        = 8
+	resourceOverhead  = 64
+	interfaceOverhead = 16
+)
+
+// approximateSize returns the approximate number of bytes used by a value of
+// an unstructured resource.
+func approximateSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		size := int64(mapOverhead)
+		for key, value := range v {
+			size += mapEntryOverhead + stringOverhead + int64(len(key)) + approximateSize(value)
+		}
+		return size
+	case []interface{}:
+		size := int64(sliceOverhead)
+		for _, value := range v {
+			size += interfaceOverhead + approximateSize(value)
+		}
+		return size
+	case string:
+		return stringOverhead + int64(len(v))
+	case nil:
+		return 0
+	default:
+		return scalarSize
+	}
+}
+
+// approximateCacheSize returns the approximate number of bytes used by the
+// resources of the cache.
+func approximateCacheSize(items map[string]cache.Item) int64 {
+	var size int64
+	for key, item := range items {
+		size += mapEntryOverhead + stringOverhead + int64(len(key)) + resourceOverhead
+		cacheObject, ok := item.Object.(*api.GatheredResource)
+		if !ok {
+			continue
+		}
+		if resource, ok := cacheObject.Resource.(*unstructured.Unstructured); ok {
+			size += approximateSize(resource.Object)
+		}
+	}
+	return size
+}
+
+// CacheBytes returns the approximate size of the resources in the cache as
+// of the last Fetch. The informers hold a copy of the resources too, before
+// they are transformed.
+func (g *DataGathererDynamic) CacheBytes() int64 {
+	return atomic.LoadInt64(&g.cacheBytes)
+}
+
+// accountCache records the approximate size of the cache.
+func (g *DataGathererDynamic) accountCache(items map[string]cache.Item) {
+	atomic.StoreInt64(&g.cacheBytes, approximateCacheSize(items))
+}
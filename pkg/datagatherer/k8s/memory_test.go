@@ -0,0 +1,48 @@
+package k8s
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/pmylund/go-cache"
+)
+
+func TestApproximateSize(t *testing.T) {
+	small := approximateSize(map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "a"},
+	})
+	large := approximateSize(map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "a"},
+		"data":     map[string]interface{}{"tls.crt": strings.Repeat("x", 4096)},
+	})
+	if small <= 0 || large-small < 4096 {
+		t.Errorf("unexpected sizes: small %d, large %d", small, large)
+	}
+
+	if size := approximateSize([]interface{}{"a", int64(1), true, nil}); size != sliceOverhead+4*interfaceOverhead+stringOverhead+1+2*scalarSize {
+		t.Errorf("unexpected size of a list: %d", size)
+	}
+}
+
+func TestCacheBytes(t *testing.T) {
+	dgCache := cache.New(cache.NoExpiration, cache.NoExpiration)
+	g := &DataGathererDynamic{cache: dgCache}
+	if g.CacheBytes() != 0 {
+		t.Fatalf("expected an empty cache to have no size")
+	}
+
+	onAdd(getObject("v1", "Pod", "a", "default", false), dgCache)
+	g.accountCache(dgCache.Items())
+	one := g.CacheBytes()
+	if one <= 0 {
+		t.Fatalf("expected the cache to have a size, got %d", one)
+	}
+
+	onAdd(getObject("v1", "Pod", "b", "default", false), dgCache)
+	dgCache.Set("not-a-resource", &api.GatheredResource{Resource: "x"}, cache.NoExpiration)
+	g.accountCache(dgCache.Items())
+	if g.CacheBytes() <= 2*one {
+		t.Errorf("expected the size to grow with the cache, got %d then %d", one, g.CacheBytes())
+	}
+}
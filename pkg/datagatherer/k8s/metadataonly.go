@@ -0,0 +1,66 @@
+package k8s
+
+import (
+	"github.com/jetstack/preflight/pkg/logs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// toUnstructured converts the objects delivered by metadata informers to
+// unstructured resources, so that they can be handled as the resources of
+// the dynamic informers. Other objects are returned as is.
+func (g *DataGathererDynamic) toUnstructured(obj interface{}, gvr schema.GroupVersionResource) interface{} {
+	partial, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		return obj
+	}
+	resource, err := metadataToUnstructured(partial, gvr, g.kinds[gvr])
+	if err != nil {
+		logs.Log.WithField(logs.ResourceField, ResourceTypeKey(gvr)).Errorf("failed to convert the metadata of %q resource %q: %v", gvr, partial.GetName(), err)
+		return obj
+	}
+	return resource
+}
+
+// metadataToUnstructured converts the metadata of a resource to an
+// unstructured resource of the given resource type and kind.
+func metadataToUnstructured(partial *metav1.PartialObjectMetadata, gvr schema.GroupVersionResource, kind string) (*unstructured.Unstructured, error) {
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&partial.ObjectMeta)
+	if err != nil {
+		return nil, err
+	}
+	resource := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": object,
+		},
+	}
+	resource.SetAPIVersion(gvr.GroupVersion().String())
+	if kind != "" {
+		resource.SetKind(kind)
+	}
+	return resource, nil
+}
+
+// resolveKinds looks up the kind of the resources of every resource type
+// with the discovery API. Resource types that cannot be resolved are gathered
+// without kind.
+func (g *DataGathererDynamic) resolveKinds() {
+	if g.discoveryClient == nil {
+		return
+	}
+	for gvr := range g.informers {
+		list, err := g.discoveryClient.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+		if err != nil {
+			logs.Log.WithField(logs.ResourceField, ResourceTypeKey(gvr)).Errorf("failed to look up the kind of %q resources: %v", ResourceTypeKey(gvr), err)
+			continue
+		}
+		for _, resource := range list.APIResources {
+			if resource.Name == gvr.Resource {
+				g.kinds[gvr] = resource.Kind
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestMetadataToUnstructured(t *testing.T) {
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	partial := &metav1.PartialObjectMetadata{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "example",
+			Namespace:   "default",
+			UID:         types.UID("uid1"),
+			Labels:      map[string]string{"app": "example"},
+			Annotations: map[string]string{"team": "payments"},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "v1", Kind: "Namespace", Name: "default", UID: types.UID("uid2")},
+			},
+		},
+	}
+
+	resource, err := metadataToUnstructured(partial, deployments, "Deployment")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":              "example",
+				"namespace":         "default",
+				"uid":               "uid1",
+				"creationTimestamp": nil,
+				"labels":            map[string]interface{}{"app": "example"},
+				"annotations":       map[string]interface{}{"team": "payments"},
+				"ownerReferences": []interface{}{
+					map[string]interface{}{
+						"apiVersion": "v1",
+						"kind":       "Namespace",
+						"name":       "default",
+						"uid":        "uid2",
+					},
+				},
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, resource); !equal {
+		t.Errorf("unexpected resource:\n%s", diff)
+	}
+}
+
+func TestToUnstructured(t *testing.T) {
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	g := &DataGathererDynamic{kinds: map[schema.GroupVersionResource]string{pods: "Pod"}}
+
+	converted := g.toUnstructured(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "pod1"}}, pods)
+	resource, ok := converted.(*unstructured.Unstructured)
+	if !ok {
+		t.Fatalf("expected an unstructured resource, got %T", converted)
+	}
+	if resource.GetAPIVersion() != "v1" || resource.GetKind() != "Pod" || resource.GetName() != "pod1" {
+		t.Errorf("unexpected resource: %v", resource.Object)
+	}
+
+	// objects from dynamic informers are left untouched
+	object := getObject("v1", "Pod", "pod2", "testns", false)
+	if g.toUnstructured(object, pods) != object {
+		t.Errorf("expected the unstructured resource to be returned as is")
+	}
+}
@@ -0,0 +1,92 @@
+package k8s
+
+import (
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+var namespacesGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// newNamespaceInformer creates an informer watching the namespaces matching
+// the label selector. The selector is applied by the apiserver, so the store
+// of the informer only ever contains the selected namespaces.
+func newNamespaceInformer(cl dynamic.Interface, labelSelector string) k8scache.SharedIndexInformer {
+	return dynamicinformer.NewFilteredDynamicInformer(
+		cl,
+		namespacesGVR,
+		metav1.NamespaceAll,
+		60*time.Second,
+		k8scache.Indexers{},
+		func(options *metav1.ListOptions) { options.LabelSelector = labelSelector },
+	).Informer()
+}
+
+// selectedNamespaces returns the namespaces currently matching the namespace
+// label selector, or nil if no selector is configured.
+func (g *DataGathererDynamic) selectedNamespaces() map[string]bool {
+	if g.namespaceInformer == nil {
+		return nil
+	}
+	selected := map[string]bool{}
+	for _, name := range g.namespaceInformer.GetStore().ListKeys() {
+		selected[name] = true
+	}
+	return selected
+}
+
+// followSelectedNamespaces scopes the informers to the selected namespaces
+// whenever a namespace is selected, relabelled or deleted.
+func (g *DataGathererDynamic) followSelectedNamespaces() {
+	g.namespaceInformer.AddEventHandler(k8scache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { g.scopeSelectedNamespaces() },
+		UpdateFunc: func(interface{}, interface{}) { g.scopeSelectedNamespaces() },
+		DeleteFunc: func(interface{}) { g.scopeSelectedNamespaces() },
+	})
+}
+
+// scopeSelectedNamespaces starts the informers of the namespaces newly
+// selected, and stops the ones of the namespaces no longer selected, whose
+// resources are removed from the cache.
+func (g *DataGathererDynamic) scopeSelectedNamespaces() {
+	shards, ok := g.sharedInformer.(*shardedInformerFactory)
+	if !ok {
+		return
+	}
+	g.forgetNamespaces(shards.setNamespaces(g.namespaceInformer.GetStore().ListKeys()))
+}
+
+// discoverClusterScoped returns the resource types which are not namespaced.
+// The resource types the API server does not serve, e.g. as their CRD is not
+// installed yet, are assumed to be namespaced.
+func discoverClusterScoped(cl discovery.DiscoveryInterface, gvrs []schema.GroupVersionResource) (map[schema.GroupVersionResource]bool, error) {
+	clusterScoped := map[schema.GroupVersionResource]bool{}
+	lists := map[schema.GroupVersion]*metav1.APIResourceList{}
+	for _, gvr := range gvrs {
+		gv := gvr.GroupVersion()
+		list, ok := lists[gv]
+		if !ok {
+			var err error
+			list, err = cl.ServerResourcesForGroupVersion(gv.String())
+			if apierrors.IsNotFound(err) {
+				list = &metav1.APIResourceList{}
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to discover the resources of %s: %v", gv, err)
+			}
+			lists[gv] = list
+		}
+		for _, resource := range list.APIResources {
+			if resource.Name == gvr.Resource && !resource.Namespaced {
+				clusterScoped[gvr] = true
+			}
+		}
+	}
+	return clusterScoped, nil
+}
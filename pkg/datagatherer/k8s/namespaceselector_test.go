@@ -0,0 +1,170 @@
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestDynamicGatherer_FetchNamespaceLabelSelector(t *testing.T) {
+	ctx := context.Background()
+	gvr := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+
+	prod := getObject("v1", "Namespace", "prod", "", false)
+	prod.SetLabels(map[string]string{"env": "prod"})
+	dev := getObject("v1", "Namespace", "dev", "", false)
+	dev.SetLabels(map[string]string{"env": "dev"})
+
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			gvr:           "UnstructuredList",
+			namespacesGVR: "UnstructuredList",
+		},
+		prod,
+		dev,
+		getObject("foobar/v1", "Foo", "prodfoo", "prod", false),
+		getObject("foobar/v1", "Foo", "devfoo", "dev", false),
+	)
+
+	config := ConfigDynamic{
+		GroupVersionResource:   gvr,
+		NamespaceLabelSelector: "env=prod",
+	}
+	dg, err := config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	res, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	items := res.(map[string]interface{})["items"].([]*api.GatheredResource)
+	if len(items) != 1 || items[0].Resource.(*unstructured.Unstructured).GetName() != "prodfoo" {
+		t.Fatalf("expected only the resources of the prod namespace, got %+v", items)
+	}
+}
+
+func TestDynamicGatherer_NamespaceLabelSelectorScope(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	foos := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	bars := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "bars"}
+
+	prod := getObject("v1", "Namespace", "prod", "", false)
+	prod.SetLabels(map[string]string{"env": "prod"})
+	dev := getObject("v1", "Namespace", "dev", "", false)
+	dev.SetLabels(map[string]string{"env": "dev"})
+
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			foos:          "UnstructuredList",
+			bars:          "UnstructuredList",
+			namespacesGVR: "UnstructuredList",
+		},
+		prod,
+		dev,
+		getObject("foobar/v1", "Foo", "prodfoo", "prod", false),
+		getObject("foobar/v1", "Foo", "devfoo", "dev", false),
+		getObject("foobar/v1", "Bar", "bar", "", false),
+	)
+
+	config := ConfigDynamic{
+		GroupVersionResources:  []schema.GroupVersionResource{foos, bars},
+		NamespaceLabelSelector: "env=prod",
+	}
+	dg, err := config.newDataGathererWithClients(ctx, cl, nil, map[schema.GroupVersionResource]bool{bars: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	g := dg.(*DataGathererDynamic)
+	if err := g.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := g.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	fetched := func() []string {
+		res, err := g.Fetch()
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		var names []string
+		for _, resources := range res.(map[string]interface{})["resources"].(map[string]map[string]interface{}) {
+			for _, item := range resources["items"].([]*api.GatheredResource) {
+				if item.DeletedAt.IsZero() {
+					names = append(names, item.Resource.(*unstructured.Unstructured).GetName())
+				}
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	waitFor := func(expected []string) {
+		err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			return reflect.DeepEqual(fetched(), expected), nil
+		})
+		if err != nil {
+			t.Fatalf("expected %v, got %v", expected, fetched())
+		}
+	}
+
+	// the cluster scoped resources are gathered regardless of the selector
+	waitFor([]string{"bar", "prodfoo"})
+
+	// the namespaced resources are only listed in the selected namespaces
+	for _, action := range cl.Actions() {
+		if action.GetVerb() == "list" && action.GetResource() == foos && action.GetNamespace() != "prod" {
+			t.Errorf("expected the foos to be listed in the prod namespace only, got a list in %q", action.GetNamespace())
+		}
+		if action.GetVerb() == "list" && action.GetResource() == bars && action.GetNamespace() != metav1.NamespaceAll {
+			t.Errorf("expected the bars to be listed across the cluster, got a list in %q", action.GetNamespace())
+		}
+	}
+
+	// the informers follow the namespaces as they are selected or deleted
+	dev.SetLabels(map[string]string{"env": "prod"})
+	if _, err := cl.Resource(namespacesGVR).Update(ctx, dev, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	waitFor([]string{"bar", "devfoo", "prodfoo"})
+
+	if err := cl.Resource(namespacesGVR).Delete(ctx, "prod", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	waitFor([]string{"bar", "devfoo"})
+}
+
+func TestConfigDynamicValidateNamespaceLabelSelector(t *testing.T) {
+	config := ConfigDynamic{
+		GroupVersionResource:   schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+		NamespaceLabelSelector: "env in (prod",
+	}
+	if err := config.validate(); err == nil {
+		t.Fatalf("expected error for invalid selector")
+	}
+
+	config.NamespaceLabelSelector = "env=prod"
+	config.IncludeNamespaces = []string{"default"}
+	if err := config.validate(); err == nil {
+		t.Fatalf("expected error when combined with include-namespaces")
+	}
+}
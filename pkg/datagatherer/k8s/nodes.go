@@ -0,0 +1,207 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var nodesGVR = schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+
+// nodeRoleLabelPrefix is the prefix of the labels listing the roles of a Node.
+const nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+
+// The well-known labels set by cloud providers, the deprecated beta labels
+// are used as fallbacks for older clusters.
+var (
+	instanceTypeLabels = []string{"node.kubernetes.io/instance-type", "beta.kubernetes.io/instance-type"}
+	regionLabels       = []string{"topology.kubernetes.io/region", "failure-domain.beta.kubernetes.io/region"}
+	zoneLabels         = []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"}
+)
+
+// ConfigNodes contains the configuration for the k8s-nodes data-gatherer.
+type ConfigNodes struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+}
+
+// DynamicConfig returns the configuration of the dynamic data gatherer used to
+// collect the Nodes.
+func (c *ConfigNodes) DynamicConfig() *ConfigDynamic {
+	return &ConfigDynamic{
+		KubeConfigPath:       c.KubeConfigPath,
+		KubeConfigContext:    c.KubeConfigContext,
+		GroupVersionResource: nodesGVR,
+	}
+}
+
+// NewDataGatherer constructs a new instance of the k8s-nodes data-gatherer.
+func (c *ConfigNodes) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	dynamicDg, err := c.DynamicConfig().NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGathererNodes{DynamicWrapper: DynamicWrapper{Dynamic: dynamicDg}}, nil
+}
+
+// DataGathererNodes gathers Nodes and emits a compact summary of each of
+// them instead of the full Node objects, which are large because of their
+// images and status.
+type DataGathererNodes struct {
+	DynamicWrapper
+}
+
+// NodeSummary is the summary of a Node.
+type NodeSummary struct {
+	Name  string   `json:"name"`
+	UID   string   `json:"uid"`
+	Roles []string `json:"roles,omitempty"`
+	Ready bool     `json:"ready"`
+	// Unschedulable is true if the Node is cordoned.
+	Unschedulable bool `json:"unschedulable,omitempty"`
+
+	KubeletVersion          string `json:"kubeletVersion"`
+	KubeProxyVersion        string `json:"kubeProxyVersion,omitempty"`
+	ContainerRuntime        string `json:"containerRuntime,omitempty"`
+	ContainerRuntimeVersion string `json:"containerRuntimeVersion,omitempty"`
+	OSImage                 string `json:"osImage,omitempty"`
+	OperatingSystem         string `json:"operatingSystem,omitempty"`
+	Architecture            string `json:"architecture,omitempty"`
+	KernelVersion           string `json:"kernelVersion,omitempty"`
+
+	ProviderID   string `json:"providerID,omitempty"`
+	InstanceType string `json:"instanceType,omitempty"`
+	Region       string `json:"region,omitempty"`
+	Zone         string `json:"zone,omitempty"`
+
+	Taints []NodeTaint `json:"taints,omitempty"`
+}
+
+// NodeTaint is a taint of a Node.
+type NodeTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+// Fetch summarizes the Nodes currently in the cache. Deleted Nodes are
+// ignored.
+func (g *DataGathererNodes) Fetch() (interface{}, error) {
+	data, err := g.Dynamic.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []*NodeSummary{}
+	err = VisitGatheredResources(data, func(item *api.GatheredResource) error {
+		if !item.DeletedAt.IsZero() {
+			return nil
+		}
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("failed to parse cached resource")
+		}
+		nodes = append(nodes, summarizeNode(resource))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	return map[string]interface{}{
+		"nodes": nodes,
+	}, nil
+}
+
+func summarizeNode(node *unstructured.Unstructured) *NodeSummary {
+	labels := node.GetLabels()
+	summary := &NodeSummary{
+		Name:         node.GetName(),
+		UID:          string(node.GetUID()),
+		InstanceType: firstLabel(labels, instanceTypeLabels),
+		Region:       firstLabel(labels, regionLabels),
+		Zone:         firstLabel(labels, zoneLabels),
+	}
+
+	for label := range labels {
+		if strings.HasPrefix(label, nodeRoleLabelPrefix) {
+			if role := strings.TrimPrefix(label, nodeRoleLabelPrefix); role != "" {
+				summary.Roles = append(summary.Roles, role)
+			}
+		}
+	}
+	sort.Strings(summary.Roles)
+
+	summary.Unschedulable, _, _ = unstructured.NestedBool(node.Object, "spec", "unschedulable")
+	summary.ProviderID, _, _ = unstructured.NestedString(node.Object, "spec", "providerID")
+
+	taints, _, _ := unstructured.NestedSlice(node.Object, "spec", "taints")
+	for _, t := range taints {
+		taint, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		nodeTaint := NodeTaint{}
+		nodeTaint.Key, _, _ = unstructured.NestedString(taint, "key")
+		nodeTaint.Value, _, _ = unstructured.NestedString(taint, "value")
+		nodeTaint.Effect, _, _ = unstructured.NestedString(taint, "effect")
+		summary.Taints = append(summary.Taints, nodeTaint)
+	}
+
+	info, _, _ := unstructured.NestedStringMap(node.Object, "status", "nodeInfo")
+	summary.KubeletVersion = info["kubeletVersion"]
+	summary.KubeProxyVersion = info["kubeProxyVersion"]
+	summary.ContainerRuntime, summary.ContainerRuntimeVersion = parseContainerRuntimeVersion(info["containerRuntimeVersion"])
+	summary.OSImage = info["osImage"]
+	summary.OperatingSystem = info["operatingSystem"]
+	summary.Architecture = info["architecture"]
+	summary.KernelVersion = info["kernelVersion"]
+
+	conditions, _, _ := unstructured.NestedSlice(node.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if conditionType, _, _ := unstructured.NestedString(condition, "type"); conditionType == "Ready" {
+			status, _, _ := unstructured.NestedString(condition, "status")
+			summary.Ready = status == "True"
+		}
+	}
+
+	return summary
+}
+
+// firstLabel returns the value of the first of the labels that is set.
+func firstLabel(labels map[string]string, keys []string) string {
+	for _, key := range keys {
+		if value, ok := labels[key]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseContainerRuntimeVersion splits the runtime version reported by the
+// kubelet, e.g. containerd://1.4.3, into the runtime name and its version.
+func parseContainerRuntimeVersion(version string) (string, string) {
+	parts := strings.SplitN(version, "://", 2)
+	if len(parts) != 2 {
+		return "", version
+	}
+	return parts[0], parts[1]
+}
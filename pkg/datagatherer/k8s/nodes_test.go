@@ -0,0 +1,89 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDataGathererNodesFetch(t *testing.T) {
+	node := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Node",
+			"metadata": map[string]interface{}{
+				"name": "node-1",
+				"uid":  "uid-1",
+				"labels": map[string]interface{}{
+					"node-role.kubernetes.io/master":           "",
+					"node.kubernetes.io/instance-type":         "m5.large",
+					"failure-domain.beta.kubernetes.io/region": "eu-west-1",
+					"topology.kubernetes.io/zone":              "eu-west-1a",
+				},
+			},
+			"spec": map[string]interface{}{
+				"providerID": "aws:///eu-west-1a/i-0123",
+				"taints": []interface{}{
+					map[string]interface{}{"key": "node-role.kubernetes.io/master", "effect": "NoSchedule"},
+				},
+			},
+			"status": map[string]interface{}{
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "MemoryPressure", "status": "False"},
+					map[string]interface{}{"type": "Ready", "status": "True"},
+				},
+				"nodeInfo": map[string]interface{}{
+					"kubeletVersion":          "v1.20.1",
+					"kubeProxyVersion":        "v1.20.1",
+					"containerRuntimeVersion": "containerd://1.4.3",
+					"osImage":                 "Ubuntu 20.04.1 LTS",
+					"operatingSystem":         "linux",
+					"architecture":            "amd64",
+					"kernelVersion":           "5.4.0-1029-aws",
+				},
+			},
+		},
+	}
+
+	dg := &DataGathererNodes{
+		DynamicWrapper: DynamicWrapper{Dynamic: &fakeDataGatherer{
+			data: map[string]interface{}{
+				"items": []*api.GatheredResource{{Resource: node}},
+			},
+		}},
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []*NodeSummary{
+		{
+			Name:                    "node-1",
+			UID:                     "uid-1",
+			Roles:                   []string{"master"},
+			Ready:                   true,
+			KubeletVersion:          "v1.20.1",
+			KubeProxyVersion:        "v1.20.1",
+			ContainerRuntime:        "containerd",
+			ContainerRuntimeVersion: "1.4.3",
+			OSImage:                 "Ubuntu 20.04.1 LTS",
+			OperatingSystem:         "linux",
+			Architecture:            "amd64",
+			KernelVersion:           "5.4.0-1029-aws",
+			ProviderID:              "aws:///eu-west-1a/i-0123",
+			InstanceType:            "m5.large",
+			Region:                  "eu-west-1",
+			Zone:                    "eu-west-1a",
+			Taints:                  []NodeTaint{{Key: "node-role.kubernetes.io/master", Effect: "NoSchedule"}},
+		},
+	}
+
+	nodes := data.(map[string]interface{})["nodes"].([]*NodeSummary)
+	if diff, equal := messagediff.PrettyDiff(expected, nodes); !equal {
+		t.Errorf("unexpected nodes:\n%s", diff)
+	}
+}
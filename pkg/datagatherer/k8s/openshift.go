@@ -0,0 +1,342 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/logs"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// openShiftConfigGroupVersion is served by OpenShift clusters only, it is
+// used to detect them.
+const openShiftConfigGroupVersion = "config.openshift.io/v1"
+
+// openShiftResourceTypes are the resource types gathered from OpenShift
+// clusters.
+var openShiftResourceTypes = []schema.GroupVersionResource{
+	{Group: "config.openshift.io", Version: "v1", Resource: "clusterversions"},
+	{Group: "config.openshift.io", Version: "v1", Resource: "clusteroperators"},
+	{Group: "config.openshift.io", Version: "v1", Resource: "proxies"},
+	{Group: "config.openshift.io", Version: "v1", Resource: "ingresses"},
+	{Group: "route.openshift.io", Version: "v1", Resource: "routes"},
+}
+
+// ConfigOpenShift contains the configuration for the k8s-openshift
+// data-gatherer.
+type ConfigOpenShift struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// ExcludeNamespaces is a list of namespaces to exclude Routes from. The
+	// cluster configuration is not namespaced, so namespaces cannot be
+	// included instead.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+}
+
+// DynamicConfig returns the configuration of the dynamic data gatherer used to
+// collect the OpenShift resources.
+func (c *ConfigOpenShift) DynamicConfig() *ConfigDynamic {
+	return &ConfigDynamic{
+		KubeConfigPath:        c.KubeConfigPath,
+		KubeConfigContext:     c.KubeConfigContext,
+		GroupVersionResources: openShiftResourceTypes,
+		ExcludeNamespaces:     c.ExcludeNamespaces,
+	}
+}
+
+// NewDataGatherer constructs a new instance of the k8s-openshift data-gatherer.
+func (c *ConfigOpenShift) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	dynamicDg, err := c.DynamicConfig().NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryClient, err := NewDiscoveryClientForContext(c.KubeConfigPath, c.KubeConfigContext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGathererOpenShift{DynamicWrapper: DynamicWrapper{Dynamic: dynamicDg}, discoveryClient: &discoveryClient}, nil
+}
+
+// DataGathererOpenShift gathers the version, operators, proxy and ingress
+// configuration and Routes of OpenShift clusters. Clusters are detected
+// with the discovery API when the data gatherer starts, nothing is gathered
+// from other clusters.
+type DataGathererOpenShift struct {
+	DynamicWrapper
+	discoveryClient discovery.DiscoveryInterface
+	// openShift is set by Run once the cluster is detected as OpenShift.
+	openShift bool
+}
+
+// OpenShiftClusterVersion describes the version of an OpenShift cluster.
+type OpenShiftClusterVersion struct {
+	ClusterID string `json:"clusterID,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+	// History are the versions the cluster has been updated to, most
+	// recent first.
+	History     []string `json:"history,omitempty"`
+	Available   bool     `json:"available"`
+	Progressing bool     `json:"progressing"`
+	Failing     bool     `json:"failing"`
+}
+
+// OpenShiftClusterOperator describes the status of a ClusterOperator.
+type OpenShiftClusterOperator struct {
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Available   bool   `json:"available"`
+	Progressing bool   `json:"progressing"`
+	Degraded    bool   `json:"degraded"`
+}
+
+// OpenShiftProxy is the cluster-wide proxy configuration.
+type OpenShiftProxy struct {
+	HTTPProxy  string `json:"httpProxy,omitempty"`
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+	NoProxy    string `json:"noProxy,omitempty"`
+	// TrustedCA is the name of the ConfigMap holding the CA bundle trusted
+	// for the proxy connections.
+	TrustedCA string `json:"trustedCA,omitempty"`
+}
+
+// OpenShiftIngress is the cluster-wide ingress configuration.
+type OpenShiftIngress struct {
+	Domain     string `json:"domain,omitempty"`
+	AppsDomain string `json:"appsDomain,omitempty"`
+}
+
+// OpenShiftRoute describes a Route.
+type OpenShiftRoute struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Host      string `json:"host,omitempty"`
+	Path      string `json:"path,omitempty"`
+	// Service is the name of the Service the Route sends traffic to.
+	Service string `json:"service,omitempty"`
+	// TLSTermination is edge, passthrough or reencrypt, or empty for
+	// plain HTTP Routes.
+	TLSTermination string `json:"tlsTermination,omitempty"`
+	// InsecureEdgeTerminationPolicy is what happens to plain HTTP traffic
+	// on TLS Routes: Allow, Disable or Redirect.
+	InsecureEdgeTerminationPolicy string `json:"insecureEdgeTerminationPolicy,omitempty"`
+}
+
+// Run detects whether the cluster is OpenShift and, if it is, starts the
+// dynamic data gatherer's informers for resource collection.
+func (g *DataGathererOpenShift) Run(stopCh <-chan struct{}) error {
+	openShift, err := isOpenShift(g.discoveryClient)
+	if err != nil {
+		return err
+	}
+	g.openShift = openShift
+	if !openShift {
+		logs.Log.Infof("%s is not served, the cluster is not OpenShift", openShiftConfigGroupVersion)
+		return nil
+	}
+	return g.Dynamic.Run(stopCh)
+}
+
+// WaitForCacheSync waits for the dynamic data gatherer's informers cache to sync.
+func (g *DataGathererOpenShift) WaitForCacheSync(stopCh <-chan struct{}) error {
+	if !g.openShift {
+		return nil
+	}
+	return g.Dynamic.WaitForCacheSync(stopCh)
+}
+
+// Healthy reports whether the resources of the dynamic data gatherer may be
+// stale.
+func (g *DataGathererOpenShift) Healthy() error {
+	if !g.openShift {
+		return nil
+	}
+	return g.Dynamic.Healthy()
+}
+
+// Fetch summarizes the OpenShift resources currently in the cache. Deleted
+// resources are ignored.
+func (g *DataGathererOpenShift) Fetch() (interface{}, error) {
+	if !g.openShift {
+		return map[string]interface{}{
+			"openshift": false,
+		}, nil
+	}
+
+	data, err := g.Dynamic.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	var clusterVersion *OpenShiftClusterVersion
+	var proxy *OpenShiftProxy
+	var ingress *OpenShiftIngress
+	operators := []*OpenShiftClusterOperator{}
+	routes := []*OpenShiftRoute{}
+	err = VisitGatheredResources(data, func(item *api.GatheredResource) error {
+		if !item.DeletedAt.IsZero() {
+			return nil
+		}
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("failed to parse cached resource")
+		}
+		gvk := resource.GroupVersionKind()
+		switch {
+		case gvk.Group == "config.openshift.io" && gvk.Kind == "ClusterVersion":
+			clusterVersion = summarizeClusterVersion(resource)
+		case gvk.Group == "config.openshift.io" && gvk.Kind == "ClusterOperator":
+			operators = append(operators, summarizeClusterOperator(resource))
+		// the cluster-wide configuration is always named cluster
+		case gvk.Group == "config.openshift.io" && gvk.Kind == "Proxy" && resource.GetName() == "cluster":
+			proxy = summarizeProxy(resource)
+		case gvk.Group == "config.openshift.io" && gvk.Kind == "Ingress" && resource.GetName() == "cluster":
+			ingress = &OpenShiftIngress{}
+			ingress.Domain, _, _ = unstructured.NestedString(resource.Object, "spec", "domain")
+			ingress.AppsDomain, _, _ = unstructured.NestedString(resource.Object, "spec", "appsDomain")
+		case gvk.Group == "route.openshift.io" && gvk.Kind == "Route":
+			routes = append(routes, summarizeRoute(resource))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(operators, func(i, j int) bool {
+		return operators[i].Name < operators[j].Name
+	})
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Namespace != routes[j].Namespace {
+			return routes[i].Namespace < routes[j].Namespace
+		}
+		return routes[i].Name < routes[j].Name
+	})
+
+	return map[string]interface{}{
+		"openshift":        true,
+		"clusterVersion":   clusterVersion,
+		"clusterOperators": operators,
+		"proxy":            proxy,
+		"ingress":          ingress,
+		"routes":           routes,
+	}, nil
+}
+
+// isOpenShift returns true if the cluster serves the OpenShift config API.
+func isOpenShift(cl discovery.DiscoveryInterface) (bool, error) {
+	if cl == nil {
+		return false, fmt.Errorf("discovery client was not initialized, impossible to detect OpenShift")
+	}
+	groups, err := cl.ServerGroups()
+	if err != nil {
+		return false, fmt.Errorf("failed to detect OpenShift: %v", err)
+	}
+	for _, group := range groups.Groups {
+		for _, version := range group.Versions {
+			if version.GroupVersion == openShiftConfigGroupVersion {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func summarizeClusterVersion(resource *unstructured.Unstructured) *OpenShiftClusterVersion {
+	summary := &OpenShiftClusterVersion{}
+	summary.ClusterID, _, _ = unstructured.NestedString(resource.Object, "spec", "clusterID")
+	summary.Channel, _, _ = unstructured.NestedString(resource.Object, "spec", "channel")
+	summary.Version, _, _ = unstructured.NestedString(resource.Object, "status", "desired", "version")
+
+	history, _, _ := unstructured.NestedSlice(resource.Object, "status", "history")
+	for _, h := range history {
+		entry, ok := h.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if state, _, _ := unstructured.NestedString(entry, "state"); state != "Completed" {
+			continue
+		}
+		if version, _, _ := unstructured.NestedString(entry, "version"); version != "" {
+			summary.History = append(summary.History, version)
+		}
+	}
+
+	conditions := openShiftConditions(resource)
+	summary.Available = conditions["Available"]
+	summary.Progressing = conditions["Progressing"]
+	summary.Failing = conditions["Failing"]
+	return summary
+}
+
+func summarizeClusterOperator(resource *unstructured.Unstructured) *OpenShiftClusterOperator {
+	summary := &OpenShiftClusterOperator{Name: resource.GetName()}
+
+	versions, _, _ := unstructured.NestedSlice(resource.Object, "status", "versions")
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// the version of the operator itself, the other versions are
+		// those of its operands
+		if name, _, _ := unstructured.NestedString(version, "name"); name == "operator" {
+			summary.Version, _, _ = unstructured.NestedString(version, "version")
+		}
+	}
+
+	conditions := openShiftConditions(resource)
+	summary.Available = conditions["Available"]
+	summary.Progressing = conditions["Progressing"]
+	summary.Degraded = conditions["Degraded"]
+	return summary
+}
+
+func summarizeProxy(resource *unstructured.Unstructured) *OpenShiftProxy {
+	summary := &OpenShiftProxy{}
+	summary.HTTPProxy, _, _ = unstructured.NestedString(resource.Object, "spec", "httpProxy")
+	summary.HTTPSProxy, _, _ = unstructured.NestedString(resource.Object, "spec", "httpsProxy")
+	summary.NoProxy, _, _ = unstructured.NestedString(resource.Object, "spec", "noProxy")
+	summary.TrustedCA, _, _ = unstructured.NestedString(resource.Object, "spec", "trustedCA", "name")
+	return summary
+}
+
+func summarizeRoute(resource *unstructured.Unstructured) *OpenShiftRoute {
+	summary := &OpenShiftRoute{
+		Namespace: resource.GetNamespace(),
+		Name:      resource.GetName(),
+	}
+	summary.Host, _, _ = unstructured.NestedString(resource.Object, "spec", "host")
+	summary.Path, _, _ = unstructured.NestedString(resource.Object, "spec", "path")
+	summary.Service, _, _ = unstructured.NestedString(resource.Object, "spec", "to", "name")
+	summary.TLSTermination, _, _ = unstructured.NestedString(resource.Object, "spec", "tls", "termination")
+	summary.InsecureEdgeTerminationPolicy, _, _ = unstructured.NestedString(resource.Object, "spec", "tls", "insecureEdgeTerminationPolicy")
+	return summary
+}
+
+// openShiftConditions returns whether each of the status conditions of the
+// resource is true.
+func openShiftConditions(resource *unstructured.Unstructured) map[string]bool {
+	result := map[string]bool{}
+	conditions, _, _ := unstructured.NestedSlice(resource.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		conditionType, _, _ := unstructured.NestedString(condition, "type")
+		status, _, _ := unstructured.NestedString(condition, "status")
+		result[conditionType] = status == "True"
+	}
+	return result
+}
@@ -0,0 +1,130 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func getOpenShiftObject(apiVersion, kind, name, namespace string, spec, status map[string]interface{}) *unstructured.Unstructured {
+	object := getObject(apiVersion, kind, name, namespace, false)
+	if spec != nil {
+		object.Object["spec"] = spec
+	}
+	if status != nil {
+		object.Object["status"] = status
+	}
+	return object
+}
+
+func TestDataGathererOpenShiftFetch(t *testing.T) {
+	resources := []*api.GatheredResource{
+		{Resource: getOpenShiftObject("config.openshift.io/v1", "ClusterVersion", "version", "",
+			map[string]interface{}{"clusterID": "abc", "channel": "stable-4.6"},
+			map[string]interface{}{
+				"desired": map[string]interface{}{"version": "4.6.9"},
+				"history": []interface{}{
+					map[string]interface{}{"state": "Partial", "version": "4.6.10"},
+					map[string]interface{}{"state": "Completed", "version": "4.6.9"},
+					map[string]interface{}{"state": "Completed", "version": "4.6.8"},
+				},
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "Available", "status": "True"},
+					map[string]interface{}{"type": "Progressing", "status": "False"},
+				},
+			})},
+		{Resource: getOpenShiftObject("config.openshift.io/v1", "ClusterOperator", "ingress", "", nil,
+			map[string]interface{}{
+				"versions": []interface{}{
+					map[string]interface{}{"name": "operator", "version": "4.6.9"},
+					map[string]interface{}{"name": "ingress-controller", "version": "quay.io/openshift/router"},
+				},
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "Available", "status": "True"},
+					map[string]interface{}{"type": "Degraded", "status": "True"},
+				},
+			})},
+		{Resource: getOpenShiftObject("config.openshift.io/v1", "ClusterOperator", "dns", "", nil, nil)},
+		{Resource: getOpenShiftObject("config.openshift.io/v1", "Proxy", "cluster", "",
+			map[string]interface{}{"httpsProxy": "http://proxy:3128", "trustedCA": map[string]interface{}{"name": "user-ca-bundle"}}, nil)},
+		{Resource: getOpenShiftObject("config.openshift.io/v1", "Ingress", "cluster", "",
+			map[string]interface{}{"domain": "apps.example.com"}, nil)},
+		{Resource: getOpenShiftObject("route.openshift.io/v1", "Route", "console", "openshift-console",
+			map[string]interface{}{
+				"host": "console.apps.example.com",
+				"to":   map[string]interface{}{"kind": "Service", "name": "console"},
+				"tls":  map[string]interface{}{"termination": "reencrypt", "insecureEdgeTerminationPolicy": "Redirect"},
+			}, nil)},
+		{Resource: getOpenShiftObject("route.openshift.io/v1", "Route", "deleted", "default", nil, nil), DeletedAt: api.Time{Time: clock.now()}},
+	}
+
+	dg := &DataGathererOpenShift{
+		DynamicWrapper: DynamicWrapper{Dynamic: &fakeDataGatherer{data: map[string]interface{}{"items": resources}}},
+		openShift:      true,
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"openshift": true,
+		"clusterVersion": &OpenShiftClusterVersion{
+			ClusterID: "abc",
+			Version:   "4.6.9",
+			Channel:   "stable-4.6",
+			History:   []string{"4.6.9", "4.6.8"},
+			Available: true,
+		},
+		"clusterOperators": []*OpenShiftClusterOperator{
+			{Name: "dns"},
+			{Name: "ingress", Version: "4.6.9", Available: true, Degraded: true},
+		},
+		"proxy":   &OpenShiftProxy{HTTPSProxy: "http://proxy:3128", TrustedCA: "user-ca-bundle"},
+		"ingress": &OpenShiftIngress{Domain: "apps.example.com"},
+		"routes": []*OpenShiftRoute{
+			{
+				Namespace:                     "openshift-console",
+				Name:                          "console",
+				Host:                          "console.apps.example.com",
+				Service:                       "console",
+				TLSTermination:                "reencrypt",
+				InsecureEdgeTerminationPolicy: "Redirect",
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+}
+
+func TestDataGathererOpenShiftNotOpenShift(t *testing.T) {
+	cl := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}}
+	cl.Resources = []*metav1.APIResourceList{{GroupVersion: "v1"}}
+
+	dg := &DataGathererOpenShift{
+		DynamicWrapper:  DynamicWrapper{Dynamic: &fakeDataGatherer{}},
+		discoveryClient: cl,
+	}
+	if err := dg.Run(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data.(map[string]interface{})["openshift"] != false {
+		t.Errorf("expected the cluster not to be OpenShift, got %v", data)
+	}
+
+	cl.Resources = append(cl.Resources, &metav1.APIResourceList{GroupVersion: openShiftConfigGroupVersion})
+	if openShift, err := isOpenShift(cl); err != nil || !openShift {
+		t.Errorf("expected the cluster to be OpenShift, got %t, %v", openShift, err)
+	}
+}
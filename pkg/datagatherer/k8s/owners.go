@@ -0,0 +1,174 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// defaultOwnerResourceTypes are the resource types used to build the owner
+// graph if none are configured. They cover the usual workload chains, e.g.
+// Deployment -> ReplicaSet -> Pod and CronJob -> Job -> Pod.
+var defaultOwnerResourceTypes = []schema.GroupVersionResource{
+	{Group: "apps", Version: "v1", Resource: "deployments"},
+	{Group: "apps", Version: "v1", Resource: "replicasets"},
+	{Group: "apps", Version: "v1", Resource: "statefulsets"},
+	{Group: "apps", Version: "v1", Resource: "daemonsets"},
+	{Group: "batch", Version: "v1", Resource: "jobs"},
+	{Group: "batch", Version: "v1beta1", Resource: "cronjobs"},
+	{Group: "", Version: "v1", Resource: "pods"},
+}
+
+// ConfigOwners contains the configuration for the k8s-owners data-gatherer.
+type ConfigOwners struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// GroupVersionResources are the resource types included in the graph.
+	GroupVersionResources []schema.GroupVersionResource
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+}
+
+// UnmarshalYAML unmarshals the ConfigOwners resolving GroupVersionResources.
+func (c *ConfigOwners) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath    string         `yaml:"kubeconfig"`
+		KubeConfigContext string         `yaml:"kubeconfig-context"`
+		ResourceTypes     []resourceType `yaml:"resource-types"`
+		ExcludeNamespaces []string       `yaml:"exclude-namespaces"`
+		IncludeNamespaces []string       `yaml:"include-namespaces"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.KubeConfigContext = aux.KubeConfigContext
+	for _, r := range aux.ResourceTypes {
+		c.GroupVersionResources = append(c.GroupVersionResources, r.groupVersionResource())
+	}
+	c.ExcludeNamespaces = aux.ExcludeNamespaces
+	c.IncludeNamespaces = aux.IncludeNamespaces
+
+	return nil
+}
+
+// DynamicConfig returns the configuration of the dynamic data gatherer used to
+// collect the resources the graph is built from.
+func (c *ConfigOwners) DynamicConfig() *ConfigDynamic {
+	gvrs := c.GroupVersionResources
+	if len(gvrs) == 0 {
+		gvrs = defaultOwnerResourceTypes
+	}
+	return &ConfigDynamic{
+		KubeConfigPath:        c.KubeConfigPath,
+		KubeConfigContext:     c.KubeConfigContext,
+		GroupVersionResources: gvrs,
+		ExcludeNamespaces:     c.ExcludeNamespaces,
+		IncludeNamespaces:     c.IncludeNamespaces,
+	}
+}
+
+// NewDataGatherer constructs a new instance of the k8s-owners data-gatherer.
+func (c *ConfigOwners) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	dynamicDg, err := c.DynamicConfig().NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGathererOwners{DynamicWrapper: DynamicWrapper{Dynamic: dynamicDg}}, nil
+}
+
+// DataGathererOwners emits the ownerReferences graph of the gathered
+// resources instead of the resources themselves, allowing the backend to
+// reconstruct chains such as Deployment -> ReplicaSet -> Pod without
+// receiving full Pod specs.
+type DataGathererOwners struct {
+	DynamicWrapper
+}
+
+// OwnerGraphNode is a resource in the owner graph.
+type OwnerGraphNode struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Namespace  string   `json:"namespace,omitempty"`
+	Name       string   `json:"name"`
+	Owners     []string `json:"owners,omitempty"`
+	// Controller is the UID of the owner that manages this resource, if any.
+	Controller string `json:"controller,omitempty"`
+}
+
+// OwnerGraph maps resource UIDs to their node in the graph. Owners reference
+// UIDs that may not be present in the graph if the owner's resource type was
+// not gathered.
+type OwnerGraph struct {
+	Nodes map[string]*OwnerGraphNode `json:"nodes"`
+}
+
+// KubeconfigContext returns the kubeconfig context used to gather resources.
+func (g *DataGathererOwners) KubeconfigContext() string {
+	if p, ok := g.Dynamic.(datagatherer.KubeconfigContextProvider); ok {
+		return p.KubeconfigContext()
+	}
+	return ""
+}
+
+// Fetch builds the owner graph from the resources currently in the cache.
+// Deleted resources are not part of the graph.
+func (g *DataGathererOwners) Fetch() (interface{}, error) {
+	data, err := g.Dynamic.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &OwnerGraph{Nodes: map[string]*OwnerGraphNode{}}
+	err = VisitGatheredResources(data, func(item *api.GatheredResource) error {
+		if !item.DeletedAt.IsZero() {
+			return nil
+		}
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("failed to parse cached resource")
+		}
+		uid := string(resource.GetUID())
+		if uid == "" {
+			return nil
+		}
+		graph.Nodes[uid] = ownerGraphNode(resource)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return graph, nil
+}
+
+func ownerGraphNode(resource *unstructured.Unstructured) *OwnerGraphNode {
+	node := &OwnerGraphNode{
+		APIVersion: resource.GetAPIVersion(),
+		Kind:       resource.GetKind(),
+		Namespace:  resource.GetNamespace(),
+		Name:       resource.GetName(),
+	}
+	for _, ref := range resource.GetOwnerReferences() {
+		owner := string(ref.UID)
+		node.Owners = append(node.Owners, owner)
+		if ref.Controller != nil && *ref.Controller {
+			node.Controller = owner
+		}
+	}
+	sort.Strings(node.Owners)
+	return node
+}
@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fakeDataGatherer returns a fixed set of data on Fetch
+type fakeDataGatherer struct {
+	data interface{}
+}
+
+func (g *fakeDataGatherer) Run(stopCh <-chan struct{}) error              { return nil }
+func (g *fakeDataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error { return nil }
+func (g *fakeDataGatherer) Delete() error                                 { return nil }
+func (g *fakeDataGatherer) Healthy() error                                { return nil }
+func (g *fakeDataGatherer) Fetch() (interface{}, error)                   { return g.data, nil }
+
+func withOwner(obj *unstructured.Unstructured, kind, name, uid string, controller bool) *unstructured.Unstructured {
+	ref := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       kind,
+		"name":       name,
+		"uid":        uid,
+	}
+	if controller {
+		ref["controller"] = true
+	}
+	obj.Object["metadata"].(map[string]interface{})["ownerReferences"] = []interface{}{ref}
+	return obj
+}
+
+func TestDataGathererOwnersFetch(t *testing.T) {
+	deleted := getObject("v1", "Pod", "deleted", "default", false)
+
+	dg := &DataGathererOwners{
+		DynamicWrapper: DynamicWrapper{Dynamic: &fakeDataGatherer{
+			data: map[string]interface{}{
+				"resources": map[string]map[string]interface{}{
+					"deployments.v1.apps": {
+						"items": []*api.GatheredResource{
+							{Resource: getObject("apps/v1", "Deployment", "app", "default", false)},
+						},
+					},
+					"replicasets.v1.apps": {
+						"items": []*api.GatheredResource{
+							{Resource: withOwner(getObject("apps/v1", "ReplicaSet", "app-1", "default", false), "Deployment", "app", "app1", true)},
+						},
+					},
+					"pods.v1": {
+						"items": []*api.GatheredResource{
+							{Resource: withOwner(getObject("v1", "Pod", "app-1-x", "default", false), "ReplicaSet", "app-1", "app-11", true)},
+							{Resource: deleted, DeletedAt: api.Time{Time: clock.now()}},
+						},
+					},
+				},
+			},
+		}},
+	}
+
+	result, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := &OwnerGraph{
+		Nodes: map[string]*OwnerGraphNode{
+			"app1": {
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Namespace:  "default",
+				Name:       "app",
+			},
+			"app-11": {
+				APIVersion: "apps/v1",
+				Kind:       "ReplicaSet",
+				Namespace:  "default",
+				Name:       "app-1",
+				Owners:     []string{"app1"},
+				Controller: "app1",
+			},
+			"app-1-x1": {
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Namespace:  "default",
+				Name:       "app-1-x",
+				Owners:     []string{"app-11"},
+				Controller: "app-11",
+			},
+		},
+	}
+
+	if diff, equal := messagediff.PrettyDiff(expected, result); !equal {
+		t.Errorf("unexpected graph:\n%s", diff)
+	}
+}
@@ -0,0 +1,235 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/pmylund/go-cache"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// cacheSnapshotVersion is the version of the cache snapshot format, snapshots
+// of other versions are ignored.
+const cacheSnapshotVersion = 1
+
+// cacheSnapshot is the on-disk representation of the cache of a dynamic data
+// gatherer.
+type cacheSnapshot struct {
+	Version       int       `json:"version"`
+	SavedAt       time.Time `json:"savedAt"`
+	LastFullFetch time.Time `json:"lastFullFetch,omitempty"`
+	// Dirty are the UIDs of the resources whose changes were not committed
+	// when the snapshot was saved.
+	Dirty []string            `json:"dirty,omitempty"`
+	Items []cacheSnapshotItem `json:"items"`
+}
+
+// cacheSnapshotItem is a cached resource, along with its deletion time and
+// cache expiration.
+type cacheSnapshotItem struct {
+	Key          string                      `json:"key"`
+	ResourceType schema.GroupVersionResource `json:"resourceType"`
+	Resource     json.RawMessage             `json:"resource"`
+	DeletedAt    *time.Time                  `json:"deletedAt,omitempty"`
+	// Expiration is the cache expiration time in nanoseconds since the
+	// epoch, zero if the item does not expire.
+	Expiration int64 `json:"expiration"`
+}
+
+// saveCache writes the cache contents to the cache path, so that it can be
+// restored when the agent restarts. The snapshot is written to a temporary
+// file first, so that a crash never leaves a partial snapshot. Secrets are
+// redacted as in the Fetch output and are never written to disk.
+func (g *DataGathererDynamic) saveCache() error {
+	snapshot := cacheSnapshot{
+		Version: cacheSnapshotVersion,
+		SavedAt: clock.now(),
+		Items:   []cacheSnapshotItem{},
+	}
+	if g.incremental {
+		g.dirtyMu.Lock()
+		snapshot.LastFullFetch = g.lastFullFetch
+		for uid := range g.dirty {
+			snapshot.Dirty = append(snapshot.Dirty, uid)
+		}
+		g.dirtyMu.Unlock()
+		sort.Strings(snapshot.Dirty)
+	}
+
+	for key, item := range g.cache.Items() {
+		cacheObject := item.Object.(*api.GatheredResource)
+		resource, ok := cacheObject.Resource.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		gvr, ok := g.resourceTypeOf(cacheObject)
+		if !ok {
+			gvr = g.groupVersionResource
+		}
+
+		redacted := []*api.GatheredResource{{Resource: resource.DeepCopy()}}
+		if err := redactList(redacted); err != nil {
+			return err
+		}
+		data, err := json.Marshal(redacted[0].Resource)
+		if err != nil {
+			return fmt.Errorf("failed to marshal cached resource %q: %v", key, err)
+		}
+
+		snapshotItem := cacheSnapshotItem{
+			Key:          key,
+			ResourceType: gvr,
+			Resource:     data,
+			Expiration:   item.Expiration,
+		}
+		if !cacheObject.DeletedAt.IsZero() {
+			deletedAt := cacheObject.DeletedAt.Time
+			snapshotItem.DeletedAt = &deletedAt
+		}
+		snapshot.Items = append(snapshot.Items, snapshotItem)
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache snapshot: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(g.cachePath), filepath.Base(g.cachePath)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cache snapshot: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache snapshot: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), g.cachePath); err != nil {
+		return fmt.Errorf("failed to replace cache snapshot: %v", err)
+	}
+	return nil
+}
+
+// restoreCache loads the snapshot at the cache path into the cache. Expired
+// items and items of resource types that are no longer gathered are skipped.
+// A missing snapshot is not an error.
+func (g *DataGathererDynamic) restoreCache() error {
+	data, err := ioutil.ReadFile(g.cachePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cache snapshot: %v", err)
+	}
+
+	var snapshot cacheSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse cache snapshot: %v", err)
+	}
+	if snapshot.Version != cacheSnapshotVersion {
+		return fmt.Errorf("unsupported cache snapshot version %d", snapshot.Version)
+	}
+
+	now := clock.now()
+	restored := map[string]*api.GatheredResource{}
+	for _, item := range snapshot.Items {
+		if _, ok := g.informers[item.ResourceType]; !ok {
+			continue
+		}
+		expiration := cache.DefaultExpiration
+		if item.Expiration > 0 {
+			expiration = time.Unix(0, item.Expiration).Sub(now)
+			if expiration <= 0 {
+				continue
+			}
+		}
+
+		resource := &unstructured.Unstructured{}
+		if err := resource.UnmarshalJSON(item.Resource); err != nil {
+			return fmt.Errorf("failed to parse cached resource %q: %v", item.Key, err)
+		}
+		cacheObject := &api.GatheredResource{Resource: resource}
+		if item.DeletedAt != nil {
+			cacheObject.DeletedAt = api.Time{Time: *item.DeletedAt}
+		}
+
+		g.indexResourceType(resource, item.ResourceType)
+		g.cache.Set(item.Key, cacheObject, expiration)
+		if cacheObject.DeletedAt.IsZero() {
+			restored[item.Key] = cacheObject
+		}
+	}
+
+	g.restoredMu.Lock()
+	g.restored = restored
+	g.restoredMu.Unlock()
+
+	if g.incremental {
+		g.dirtyMu.Lock()
+		g.lastFullFetch = snapshot.LastFullFetch
+		// the changes not delivered before the agent stopped are returned
+		// with the next delta
+		for _, uid := range snapshot.Dirty {
+			g.generation++
+			g.dirty[uid] = g.generation
+		}
+		g.dirtyMu.Unlock()
+	}
+
+	return nil
+}
+
+// isRestoredUnchanged returns true if the object was restored from the
+// snapshot with the same resource version, in which case its addition by
+// the initial list of the informer is not a change.
+func (g *DataGathererDynamic) isRestoredUnchanged(obj interface{}) bool {
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return false
+	}
+	g.restoredMu.Lock()
+	defer g.restoredMu.Unlock()
+	restored, ok := g.restored[string(resource.GetUID())]
+	if !ok {
+		return false
+	}
+	restoredResource, ok := restored.Resource.(*unstructured.Unstructured)
+	return ok && resource.GetResourceVersion() != "" && resource.GetResourceVersion() == restoredResource.GetResourceVersion()
+}
+
+// reconcileRestored marks as deleted the restored resources that were
+// deleted while the agent was not running. Once all the informers have
+// synced, every resource that still exists has replaced its restored
+// version in the cache, so the restored versions left are deletions.
+func (g *DataGathererDynamic) reconcileRestored() {
+	g.restoredMu.Lock()
+	defer g.restoredMu.Unlock()
+	if g.restored == nil {
+		return
+	}
+	for _, informer := range g.informers {
+		if !informer.HasSynced() {
+			return
+		}
+	}
+
+	for key, restored := range g.restored {
+		current, ok := g.cache.Get(key)
+		if !ok || current != restored {
+			continue
+		}
+		restored.DeletedAt = api.Time{Time: clock.now()}
+		g.cache.Set(key, restored, g.tombstoneExpiration())
+		g.markDirty(restored.Resource)
+	}
+	g.restored = nil
+}
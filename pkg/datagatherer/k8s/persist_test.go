@@ -0,0 +1,157 @@
+package k8s
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestDynamicGatherer_CachePersistence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	foos := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	gvrToListKind := map[schema.GroupVersionResource]string{foos: "UnstructuredList"}
+	kept := getObject("foobar/v1", "Foo", "kept", "testns", false)
+	deleted := getObject("foobar/v1", "Foo", "deleted", "testns", false)
+	config := ConfigDynamic{
+		GroupVersionResource: foos,
+		CachePath:            filepath.Join(t.TempDir(), "cache.json"),
+	}
+
+	// the first agent saw both resources before stopping
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), gvrToListKind, kept, deleted)
+	dg, err := config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if _, err := dg.Fetch(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// one of them is deleted while no agent is running
+	cl = fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), gvrToListKind, kept)
+	dg, err = config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	res, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	deletedAt := map[string]bool{}
+	for _, item := range res.(map[string]interface{})["items"].([]*api.GatheredResource) {
+		deletedAt[item.Resource.(*unstructured.Unstructured).GetName()] = !item.DeletedAt.IsZero()
+	}
+	if len(deletedAt) != 2 {
+		t.Fatalf("expected the restored resources to be returned, got %v", deletedAt)
+	}
+	if deletedAt["kept"] {
+		t.Errorf("expected %q not to be deleted", "kept")
+	}
+	if !deletedAt["deleted"] {
+		t.Errorf("expected %q to be reported as deleted", "deleted")
+	}
+}
+
+func TestDynamicGatherer_CacheSnapshotRedactsSecrets(t *testing.T) {
+	secrets := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	config := ConfigDynamic{
+		GroupVersionResource: secrets,
+		CachePath:            filepath.Join(t.TempDir(), "cache.json"),
+	}
+	cl := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	dg, err := config.newDataGathererWithClient(context.Background(), cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	g := dg.(*DataGathererDynamic)
+
+	secret := getSecret("example", "default", map[string]interface{}{"password": "c2VjcmV0"}, false, false)
+	onAdd(secret, g.cache)
+	if err := g.saveCache(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if _, found, _ := unstructured.NestedString(secret.Object, "data", "password"); !found {
+		t.Errorf("expected the cached secret to be left untouched")
+	}
+
+	if err := g.restoreCache(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	item, ok := g.cache.Get("example1")
+	if !ok {
+		t.Fatalf("expected the secret to be restored")
+	}
+	restored := item.(*api.GatheredResource).Resource.(*unstructured.Unstructured)
+	if _, found, _ := unstructured.NestedString(restored.Object, "data", "password"); found {
+		t.Errorf("expected the secret data not to be persisted")
+	}
+}
+
+func TestDynamicGatherer_CachePersistenceUncommitted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	foos := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	gvrToListKind := map[schema.GroupVersionResource]string{foos: "UnstructuredList"}
+	foo := getObject("foobar/v1", "Foo", "foo", "testns", false)
+	foo.SetResourceVersion("1")
+	bar := getObject("foobar/v1", "Foo", "bar", "testns", false)
+	bar.SetResourceVersion("1")
+	config := ConfigDynamic{
+		GroupVersionResource: foos,
+		Incremental:          true,
+		CachePath:            filepath.Join(t.TempDir(), "cache.json"),
+	}
+	start := func() *DataGathererDynamic {
+		cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), gvrToListKind, foo, bar)
+		dg, err := config.newDataGathererWithClient(ctx, cl)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		if err := dg.Run(ctx.Done()); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		return dg.(*DataGathererDynamic)
+	}
+
+	// the first agent stops before the change of foo is delivered
+	dg := start()
+	if names, delta := fetchCommitted(t, dg); delta || len(names) != 2 {
+		t.Fatalf("expected a full snapshot with two items, got delta=%v items=%v", delta, names)
+	}
+	dg.markDirty(foo)
+	if names, delta := fetchNames(t, dg); !delta || len(names) != 1 {
+		t.Fatalf("expected a delta containing foo, got delta=%v items=%v", delta, names)
+	}
+
+	// the next agent returns the change again
+	dg = start()
+	if names, delta := fetchNames(t, dg); !delta || len(names) != 1 || names[0] != "foo" {
+		t.Fatalf("expected the undelivered change of foo, got delta=%v items=%v", delta, names)
+	}
+}
@@ -0,0 +1,161 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+// defaultPollPageSize is the number of resources requested per page when
+// polling, unless a page size is configured.
+const defaultPollPageSize = 500
+
+// isPolled returns true if the resources of the resource type are polled
+// with a periodic LIST rather than watched.
+func (g *DataGathererDynamic) isPolled(gvr schema.GroupVersionResource) bool {
+	if g.pollInterval == 0 {
+		return false
+	}
+	if len(g.pollResourceTypes) == 0 {
+		return true
+	}
+	for _, polled := range g.pollResourceTypes {
+		if polled == gvr {
+			return true
+		}
+	}
+	return false
+}
+
+// newPollingInformer returns an informer listing the resources of the
+// resource type every poll interval instead of watching them. Its watches
+// deliver no events and expire after the poll interval, which makes the
+// reflector list the resources again. Resources that disappear between two
+// lists are deleted from the informer's store, so the data gatherer handles
+// the polled resources as the watched ones.
+func (g *DataGathererDynamic) newPollingInformer(gvr schema.GroupVersionResource) k8scache.SharedIndexInformer {
+	lw := &k8scache.ListWatch{
+		ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+			return g.listPages(gvr)
+		},
+		WatchFunc: func(metav1.ListOptions) (watch.Interface, error) {
+			return newPollWatcher(g.pollInterval), nil
+		},
+	}
+	var example runtime.Object = &unstructured.Unstructured{}
+	if g.metadataClient != nil {
+		example = &metav1.PartialObjectMetadata{}
+	}
+	return k8scache.NewSharedIndexInformer(lw, example, 0, k8scache.Indexers{k8scache.NamespaceIndex: k8scache.MetaNamespaceIndexFunc})
+}
+
+// listPages lists all the resources of the resource type page by page, and
+// returns them as a single list.
+func (g *DataGathererDynamic) listPages(gvr schema.GroupVersionResource) (runtime.Object, error) {
+	ctx := g.informerCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// the options of the reflector are ignored, as pages can only be
+	// requested with the latest resource version
+	options := metav1.ListOptions{
+		FieldSelector: g.fieldSelector,
+		Limit:         g.pageSize,
+	}
+	if options.Limit == 0 {
+		options.Limit = defaultPollPageSize
+	}
+
+	// the resources of the data gatherers with included namespaces are listed
+	// in each namespace, as the agent may only be granted these
+	namespaces := g.listNamespaces(gvr)
+
+	if g.metadataClient != nil {
+		all := &metav1.PartialObjectMetadataList{}
+		for _, namespace := range namespaces {
+			options.Continue = ""
+			for {
+				page, err := g.metadataClient.Resource(gvr).Namespace(namespace).List(ctx, options)
+				if err != nil {
+					return nil, err
+				}
+				all.ListMeta = page.ListMeta
+				all.Items = append(all.Items, page.Items...)
+				if page.Continue == "" {
+					break
+				}
+				options.Continue = page.Continue
+			}
+		}
+		return all, nil
+	}
+
+	all := &unstructured.UnstructuredList{}
+	for _, namespace := range namespaces {
+		options.Continue = ""
+		for {
+			page, err := g.cl.Resource(gvr).Namespace(namespace).List(ctx, options)
+			if err != nil {
+				return nil, err
+			}
+			all.Object = page.Object
+			all.Items = append(all.Items, page.Items...)
+			if page.GetContinue() == "" {
+				break
+			}
+			options.Continue = page.GetContinue()
+		}
+	}
+	all.SetContinue("")
+	return all, nil
+}
+
+// pollWatcher is a watch delivering no events, which expires after the poll
+// interval. The reflector lists the resources again when a watch expires,
+// whereas it would only watch again if the watch merely ended.
+type pollWatcher struct {
+	result chan watch.Event
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		result: make(chan watch.Event),
+		stop:   make(chan struct{}),
+	}
+	go func() {
+		defer close(w.result)
+		select {
+		case <-time.After(interval):
+		case <-w.stop:
+			return
+		}
+		expired := apierrors.NewResourceExpired("the poll interval has elapsed")
+		select {
+		case w.result <- watch.Event{Type: watch.Error, Object: &expired.ErrStatus}:
+		case <-w.stop:
+		}
+	}()
+	return w
+}
+
+// Stop ends the watch.
+func (w *pollWatcher) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+// ResultChan returns the channel of the events, closed when the watch ends or
+// after it has expired.
+func (w *pollWatcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
@@ -0,0 +1,160 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestDynamicGatherer_Poll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	crds := schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	foos := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			crds: "CustomResourceDefinitionList",
+			foos: "UnstructuredList",
+		},
+		getObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "foos.foobar", "", false),
+		getObject("foobar/v1", "Foo", "foo", "testns", false),
+	)
+
+	config := ConfigDynamic{
+		GroupVersionResources: []schema.GroupVersionResource{crds, foos},
+		PollInterval:          time.Hour,
+		PollResourceTypes:     []schema.GroupVersionResource{crds},
+	}
+	dg, err := config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	g := dg.(*DataGathererDynamic)
+	if len(g.pollingInformers) != 1 || !g.isPolled(crds) || g.isPolled(foos) {
+		t.Fatalf("expected only the CRDs to be polled")
+	}
+
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	res, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	names := map[string]bool{}
+	for _, resources := range res.(map[string]interface{})["resources"].(map[string]map[string]interface{}) {
+		for _, item := range resources["items"].([]*api.GatheredResource) {
+			names[item.Resource.(*unstructured.Unstructured).GetName()] = true
+		}
+	}
+	if len(names) != 2 || !names["foos.foobar"] || !names["foo"] {
+		t.Fatalf("unexpected resources gathered: %v", names)
+	}
+
+	// the polled resources do not expire from the cache between two polls
+	for _, item := range g.cache.Items() {
+		name := item.Object.(*api.GatheredResource).Resource.(*unstructured.Unstructured).GetName()
+		if expires := item.Expiration != 0; expires != (name == "foo") {
+			t.Errorf("unexpected expiration of %q: %d", name, item.Expiration)
+		}
+	}
+}
+
+func TestDynamicGatherer_PollListsAgain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	crds := schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{crds: "CustomResourceDefinitionList"},
+		getObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "foos.foobar", "", false),
+	)
+
+	config := ConfigDynamic{
+		GroupVersionResource: crds,
+		PollInterval:         10 * time.Millisecond,
+	}
+	dg, err := config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	lists := func() int {
+		count := 0
+		for _, action := range cl.Actions() {
+			if action.GetVerb() == "list" && action.GetResource() == crds {
+				count++
+			}
+		}
+		return count
+	}
+	// the reflector backs off for up to a couple of seconds before listing
+	// again
+	deadline := time.After(10 * time.Second)
+	for lists() < 2 {
+		select {
+		case <-deadline:
+			t.Fatalf("expected the resources to be listed again after the poll interval, got %d list(s)", lists())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestPollWatcher(t *testing.T) {
+	w := newPollWatcher(10 * time.Millisecond)
+	select {
+	case event := <-w.ResultChan():
+		if event.Type != watch.Error || !apierrors.IsResourceExpired(apierrors.FromObject(event.Object)) {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the watch to expire after the poll interval")
+	}
+	select {
+	case _, ok := <-w.ResultChan():
+		if ok {
+			t.Fatalf("unexpected event")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the watch to end once expired")
+	}
+
+	w = newPollWatcher(time.Hour)
+	w.Stop()
+	w.Stop()
+	select {
+	case <-w.ResultChan():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the watch to end when stopped")
+	}
+}
+
+func TestPollValidation(t *testing.T) {
+	config := ConfigDynamic{
+		GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+		PollResourceTypes:    []schema.GroupVersionResource{{Version: "v1", Resource: "pods"}},
+	}
+	if err := config.validate(); err == nil {
+		t.Errorf("expected an error when poll-resource-types is set without poll-interval")
+	}
+}
@@ -0,0 +1,38 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+)
+
+// projectionIdentityFields are always kept when projecting resources so that
+// they can still be identified.
+var projectionIdentityFields = []string{
+	"apiVersion",
+	"kind",
+	"metadata.name",
+	"metadata.namespace",
+	"metadata.uid",
+}
+
+// projectionFields converts the configured field expressions into the paths
+// supported by Select. Expressions are either JSON pointers, e.g.
+// /metadata/labels/app.kubernetes.io~1name, or dot separated JSONPath
+// expressions, e.g. .spec.dnsNames or $.status.conditions. JSONPath
+// wildcards, filters and array subscripts are not supported.
+func projectionFields(expressions []string) ([]string, error) {
+	fields := append([]string{}, projectionIdentityFields...)
+	for _, expression := range expressions {
+		if strings.HasPrefix(expression, "/") {
+			fields = append(fields, expression)
+			continue
+		}
+
+		field := strings.TrimPrefix(strings.TrimPrefix(expression, "$"), ".")
+		if field == "" || strings.ContainsAny(field, "[]*?@(){}") || strings.Contains(field, "..") {
+			return nil, fmt.Errorf("invalid configuration: unsupported field expression %q, only dot separated paths and JSON pointers are supported", expression)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
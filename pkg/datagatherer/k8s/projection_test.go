@@ -0,0 +1,112 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestProjectionFields(t *testing.T) {
+	resource := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cert-manager.io/v1",
+			"kind":       "Certificate",
+			"metadata": map[string]interface{}{
+				"name":      "example",
+				"namespace": "default",
+				"uid":       "example1",
+				"labels": map[string]interface{}{
+					"app.kubernetes.io/name": "web",
+					"team":                   "platform",
+				},
+			},
+			"spec": map[string]interface{}{
+				"dnsNames":   []interface{}{"example.com"},
+				"secretName": "example-tls",
+			},
+			"status": map[string]interface{}{
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "Ready", "status": "True"},
+				},
+			},
+		},
+	}
+
+	fields, err := projectionFields([]string{".spec.dnsNames", "$.status.conditions", "/metadata/labels/app.kubernetes.io~1name"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := Select(fields, resource); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata": map[string]interface{}{
+			"name":      "example",
+			"namespace": "default",
+			"uid":       "example1",
+			"labels": map[string]interface{}{
+				"app.kubernetes.io/name": "web",
+			},
+		},
+		"spec": map[string]interface{}{
+			"dnsNames": []interface{}{"example.com"},
+		},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True"},
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, resource.Object); !equal {
+		t.Errorf("unexpected result:\n%s", diff)
+	}
+}
+
+func TestProjectionFieldsUnsupported(t *testing.T) {
+	for _, expression := range []string{"", ".spec.containers[0].image", "$..name", ".items[*]"} {
+		if _, err := projectionFields([]string{expression}); err == nil {
+			t.Errorf("expected error for %q", expression)
+		}
+	}
+}
+
+func TestDynamicGatherer_ProjectionLeavesCacheAlone(t *testing.T) {
+	config := ConfigDynamic{
+		GroupVersionResource: schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"},
+		Fields:               []string{".spec.replicas"},
+	}
+	dg, err := config.newDataGathererWithClient(context.Background(), fake.NewSimpleDynamicClient(runtime.NewScheme()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g := dg.(*DataGathererDynamic)
+
+	// the object of the informer, shared with the cache
+	obj := getObject("foobar/v1", "Foo", "foo", "testns", false)
+	obj.Object["spec"] = map[string]interface{}{"replicas": int64(2), "template": "large"}
+	onAdd(obj, g.cache)
+
+	res, err := g.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	items := res.(map[string]interface{})["items"].([]*api.GatheredResource)
+	if len(items) != 1 {
+		t.Fatalf("expected a single resource, got %d", len(items))
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(items[0].Resource.(*unstructured.Unstructured).Object, "spec", "template"); found {
+		t.Errorf("expected the resource to be projected")
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "template"); !found {
+		t.Errorf("expected the cached resource not to be projected")
+	}
+}
@@ -0,0 +1,73 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// MetadataPruning configures the metadata removed from every gathered
+// resource in order to shrink payloads and avoid leaking applied manifests.
+type MetadataPruning struct {
+	// Fields are the names of the metadata fields to remove, e.g.
+	// resourceVersion or generation.
+	Fields []string `yaml:"fields"`
+	// Annotations are the keys of the annotations to remove. A trailing "*"
+	// matches all the keys with the preceding prefix, e.g.
+	// "deployment.kubernetes.io/*".
+	Annotations []string `yaml:"annotations"`
+}
+
+// protectedMetadataFields are needed to identify resources and cannot be
+// pruned.
+var protectedMetadataFields = []string{"name", "namespace", "uid"}
+
+func (p *MetadataPruning) validate() error {
+	for _, field := range p.Fields {
+		for _, protected := range protectedMetadataFields {
+			if field == protected {
+				return fmt.Errorf("invalid configuration: metadata field %q cannot be pruned", field)
+			}
+		}
+	}
+	return nil
+}
+
+// Prune removes the configured metadata from the resource.
+func (p *MetadataPruning) Prune(resource *unstructured.Unstructured) {
+	metadata, ok := resource.Object["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for _, field := range p.Fields {
+		delete(metadata, field)
+	}
+
+	annotations, ok := metadata["annotations"].(map[string]interface{})
+	if !ok || len(p.Annotations) == 0 {
+		return
+	}
+	for key := range annotations {
+		if p.matchesAnnotation(key) {
+			delete(annotations, key)
+		}
+	}
+	if len(annotations) == 0 {
+		delete(metadata, "annotations")
+	}
+}
+
+func (p *MetadataPruning) matchesAnnotation(key string) bool {
+	for _, pattern := range p.Annotations {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if key == pattern {
+			return true
+		}
+	}
+	return false
+}
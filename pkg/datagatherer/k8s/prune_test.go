@@ -0,0 +1,90 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestMetadataPruning(t *testing.T) {
+	resource := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":            "example",
+				"namespace":       "default",
+				"resourceVersion": "1234",
+				"generation":      int64(3),
+				"annotations": map[string]interface{}{
+					"kubectl.kubernetes.io/last-applied-configuration": "{}",
+					"deployment.kubernetes.io/revision":                "3",
+					"team":                                             "platform",
+				},
+			},
+		},
+	}
+
+	pruning := &MetadataPruning{
+		Fields:      []string{"resourceVersion", "generation"},
+		Annotations: []string{"kubectl.kubernetes.io/last-applied-configuration", "deployment.kubernetes.io/*"},
+	}
+	pruning.Prune(resource)
+
+	expected := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      "example",
+			"namespace": "default",
+			"annotations": map[string]interface{}{
+				"team": "platform",
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, resource.Object); !equal {
+		t.Errorf("unexpected result:\n%s", diff)
+	}
+}
+
+func TestDynamicGatherer_PruneLeavesCacheAlone(t *testing.T) {
+	config := ConfigDynamic{
+		GroupVersionResource: schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"},
+		PruneMetadata:        &MetadataPruning{Fields: []string{"resourceVersion"}},
+	}
+	dg, err := config.newDataGathererWithClient(context.Background(), fake.NewSimpleDynamicClient(runtime.NewScheme()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g := dg.(*DataGathererDynamic)
+
+	// the object of the informer, shared with the cache
+	obj := getObject("foobar/v1", "Foo", "foo", "testns", false)
+	obj.SetResourceVersion("1")
+	onAdd(obj, g.cache)
+
+	res, err := g.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	items := res.(map[string]interface{})["items"].([]*api.GatheredResource)
+	if len(items) != 1 || items[0].Resource.(*unstructured.Unstructured).GetResourceVersion() != "" {
+		t.Fatalf("expected the resource version to be pruned, got %+v", items)
+	}
+	if obj.GetResourceVersion() != "1" {
+		t.Errorf("expected the cached resource not to be pruned")
+	}
+}
+
+func TestMetadataPruningProtectedFields(t *testing.T) {
+	pruning := &MetadataPruning{Fields: []string{"uid"}}
+	if err := pruning.validate(); err == nil {
+		t.Fatalf("expected error when pruning uid")
+	}
+}
@@ -0,0 +1,347 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// rbacResourceTypes are the resource types gathered by the k8s-rbac data
+// gatherer.
+var rbacResourceTypes = []schema.GroupVersionResource{
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterrolebindings"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings"},
+}
+
+// rbacCapability is a sensitive permission granted by a rule if it allows
+// any of the verbs on any of the resources.
+type rbacCapability struct {
+	name      string
+	verbs     []string
+	apiGroup  string
+	resources []string
+}
+
+// rbacCapabilities are the sensitive permissions reported for each subject.
+var rbacCapabilities = []rbacCapability{
+	{name: "cluster-admin", verbs: []string{"*"}, apiGroup: "*", resources: []string{"*"}},
+	{name: "read-secrets", verbs: []string{"get", "list", "watch"}, apiGroup: "", resources: []string{"secrets"}},
+	{name: "escalate", verbs: []string{"escalate", "bind"}, apiGroup: "rbac.authorization.k8s.io", resources: []string{"roles", "clusterroles"}},
+	{name: "impersonate", verbs: []string{"impersonate"}, apiGroup: "", resources: []string{"users", "groups", "serviceaccounts"}},
+	{name: "create-tokens", verbs: []string{"create"}, apiGroup: "", resources: []string{"serviceaccounts/token"}},
+	{name: "exec-pods", verbs: []string{"create"}, apiGroup: "", resources: []string{"pods/exec", "pods/attach"}},
+	{name: "create-pods", verbs: []string{"create"}, apiGroup: "", resources: []string{"pods"}},
+	{name: "modify-admission-webhooks", verbs: []string{"create", "update", "patch", "delete"}, apiGroup: "admissionregistration.k8s.io", resources: []string{"validatingwebhookconfigurations", "mutatingwebhookconfigurations"}},
+	{name: "approve-certificates", verbs: []string{"approve"}, apiGroup: "certificates.k8s.io", resources: []string{"signers"}},
+}
+
+// ConfigRBAC contains the configuration for the k8s-rbac data-gatherer.
+type ConfigRBAC struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// ExcludeNamespaces is a list of namespaces to exclude Roles and
+	// RoleBindings from.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+}
+
+// DynamicConfig returns the configuration of the dynamic data gatherer used to
+// collect the RBAC resources.
+func (c *ConfigRBAC) DynamicConfig() *ConfigDynamic {
+	return &ConfigDynamic{
+		KubeConfigPath:        c.KubeConfigPath,
+		KubeConfigContext:     c.KubeConfigContext,
+		GroupVersionResources: rbacResourceTypes,
+		ExcludeNamespaces:     c.ExcludeNamespaces,
+	}
+}
+
+// NewDataGatherer constructs a new instance of the k8s-rbac data-gatherer.
+func (c *ConfigRBAC) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	dynamicDg, err := c.DynamicConfig().NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGathererRBAC{DynamicWrapper: DynamicWrapper{Dynamic: dynamicDg}}, nil
+}
+
+// DataGathererRBAC gathers the roles and bindings of the cluster and
+// resolves them into the sensitive permissions of each subject, instead of
+// emitting the roles and bindings themselves.
+type DataGathererRBAC struct {
+	DynamicWrapper
+}
+
+// RBACSubject is a user, group or service account with sensitive
+// permissions.
+type RBACSubject struct {
+	// Kind is User, Group or ServiceAccount.
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Namespace is the namespace of service accounts.
+	Namespace string `json:"namespace,omitempty"`
+	// Roles are the roles bound to the subject, as Kind/name or
+	// Kind/namespace/name for Roles, and the namespace of the binding for
+	// ClusterRoles bound in a namespace.
+	Roles []string `json:"roles"`
+	// Capabilities are the sensitive permissions of the subject.
+	Capabilities []*RBACCapability `json:"capabilities"`
+}
+
+// RBACCapability is a sensitive permission of a subject, and where it
+// applies.
+type RBACCapability struct {
+	Name string `json:"name"`
+	// ClusterWide is true if the permission applies to all the namespaces.
+	ClusterWide bool `json:"clusterWide"`
+	// Namespaces are the namespaces the permission applies to when it is
+	// not cluster wide.
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// rbacRule is a policy rule of a role.
+type rbacRule struct {
+	verbs         []string
+	apiGroups     []string
+	resources     []string
+	resourceNames []string
+}
+
+// Fetch resolves the bindings currently in the cache into the sensitive
+// permissions of their subjects. Deleted resources are ignored, as are
+// subjects without sensitive permissions.
+func (g *DataGathererRBAC) Fetch() (interface{}, error) {
+	data, err := g.Dynamic.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	clusterRoles := map[string][]rbacRule{}
+	roles := map[string][]rbacRule{}
+	bindings := []*unstructured.Unstructured{}
+	err = VisitGatheredResources(data, func(item *api.GatheredResource) error {
+		if !item.DeletedAt.IsZero() {
+			return nil
+		}
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("failed to parse cached resource")
+		}
+		switch resource.GetKind() {
+		case "ClusterRole":
+			clusterRoles[resource.GetName()] = rbacRules(resource)
+		case "Role":
+			roles[resource.GetNamespace()+"/"+resource.GetName()] = rbacRules(resource)
+		case "ClusterRoleBinding", "RoleBinding":
+			bindings = append(bindings, resource)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := map[string]*resolvedSubject{}
+	for _, binding := range bindings {
+		// ClusterRoleBindings grant their role in all the namespaces
+		namespace := binding.GetNamespace()
+		roleKind, _, _ := unstructured.NestedString(binding.Object, "roleRef", "kind")
+		roleName, _, _ := unstructured.NestedString(binding.Object, "roleRef", "name")
+
+		var rules []rbacRule
+		var role string
+		switch roleKind {
+		case "ClusterRole":
+			rules = clusterRoles[roleName]
+			role = "ClusterRole/" + roleName
+			if namespace != "" {
+				role = "ClusterRole/" + roleName + "@" + namespace
+			}
+		case "Role":
+			// Roles can only be bound in their namespace
+			rules = roles[namespace+"/"+roleName]
+			role = "Role/" + namespace + "/" + roleName
+		default:
+			continue
+		}
+
+		items, _, _ := unstructured.NestedSlice(binding.Object, "subjects")
+		for _, item := range items {
+			object, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			subject := &RBACSubject{}
+			subject.Kind, _, _ = unstructured.NestedString(object, "kind")
+			subject.Name, _, _ = unstructured.NestedString(object, "name")
+			if subject.Kind == "ServiceAccount" {
+				subject.Namespace, _, _ = unstructured.NestedString(object, "namespace")
+				if subject.Namespace == "" {
+					subject.Namespace = namespace
+				}
+			}
+
+			key := subject.Kind + "/" + subject.Namespace + "/" + subject.Name
+			resolved, ok := subjects[key]
+			if !ok {
+				resolved = &resolvedSubject{subject: subject, roles: map[string]bool{}, capabilities: map[string]map[string]bool{}}
+				subjects[key] = resolved
+			}
+			resolved.roles[role] = true
+			for _, capability := range rbacCapabilities {
+				if grantsCapability(rules, capability) {
+					resolved.grant(capability.name, namespace)
+				}
+			}
+		}
+	}
+
+	result := []*RBACSubject{}
+	for _, resolved := range subjects {
+		if len(resolved.capabilities) == 0 {
+			continue
+		}
+		result = append(result, resolved.summary())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	return map[string]interface{}{
+		"subjects": result,
+	}, nil
+}
+
+// resolvedSubject accumulates the roles and capabilities of a subject over
+// its bindings.
+type resolvedSubject struct {
+	subject *RBACSubject
+	roles   map[string]bool
+	// capabilities are the namespaces of each capability, an empty
+	// namespace meaning cluster wide
+	capabilities map[string]map[string]bool
+}
+
+func (s *resolvedSubject) grant(capability, namespace string) {
+	if s.capabilities[capability] == nil {
+		s.capabilities[capability] = map[string]bool{}
+	}
+	s.capabilities[capability][namespace] = true
+}
+
+func (s *resolvedSubject) summary() *RBACSubject {
+	summary := s.subject
+	summary.Roles = []string{}
+	for role := range s.roles {
+		summary.Roles = append(summary.Roles, role)
+	}
+	sort.Strings(summary.Roles)
+
+	summary.Capabilities = []*RBACCapability{}
+	for _, capability := range rbacCapabilities {
+		namespaces, ok := s.capabilities[capability.name]
+		if !ok {
+			continue
+		}
+		granted := &RBACCapability{Name: capability.name}
+		if namespaces[""] {
+			// cluster wide permissions make the namespaced ones redundant
+			granted.ClusterWide = true
+		} else {
+			for namespace := range namespaces {
+				granted.Namespaces = append(granted.Namespaces, namespace)
+			}
+			sort.Strings(granted.Namespaces)
+		}
+		summary.Capabilities = append(summary.Capabilities, granted)
+	}
+	return summary
+}
+
+// rbacRules returns the rules of a role.
+func rbacRules(role *unstructured.Unstructured) []rbacRule {
+	items, _, _ := unstructured.NestedSlice(role.Object, "rules")
+	rules := []rbacRule{}
+	for _, item := range items {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rule := rbacRule{}
+		rule.verbs, _, _ = unstructured.NestedStringSlice(object, "verbs")
+		rule.apiGroups, _, _ = unstructured.NestedStringSlice(object, "apiGroups")
+		rule.resources, _, _ = unstructured.NestedStringSlice(object, "resources")
+		rule.resourceNames, _, _ = unstructured.NestedStringSlice(object, "resourceNames")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// grantsCapability returns true if any of the rules allows any of the verbs
+// of the capability on any of its resources. Rules limited to some resource
+// names are ignored.
+func grantsCapability(rules []rbacRule, capability rbacCapability) bool {
+	for _, rule := range rules {
+		if len(rule.resourceNames) > 0 {
+			continue
+		}
+		if !rbacMatches(rule.apiGroups, capability.apiGroup) {
+			continue
+		}
+		for _, verb := range capability.verbs {
+			if !rbacMatches(rule.verbs, verb) {
+				continue
+			}
+			for _, resource := range capability.resources {
+				if rbacResourceMatches(rule.resources, resource) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// rbacMatches returns true if the values of a rule include value, or the
+// wildcard.
+func rbacMatches(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// rbacResourceMatches returns true if the resources of a rule include the
+// resource, following the RBAC wildcards: * matches all the resources and
+// their subresources, */subresource matches the subresource of all the
+// resources.
+func rbacResourceMatches(resources []string, resource string) bool {
+	for _, r := range resources {
+		if r == "*" || r == resource {
+			return true
+		}
+		if i := strings.Index(resource, "/"); i > 0 && strings.HasPrefix(r, "*/") && resource[i:] == r[1:] {
+			return true
+		}
+	}
+	return false
+}
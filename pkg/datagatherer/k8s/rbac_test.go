@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func getRole(kind, name, namespace string, rules ...map[string]interface{}) *unstructured.Unstructured {
+	object := getObject("rbac.authorization.k8s.io/v1", kind, name, namespace, false)
+	items := []interface{}{}
+	for _, rule := range rules {
+		items = append(items, rule)
+	}
+	object.Object["rules"] = items
+	return object
+}
+
+func getBinding(kind, name, namespace, roleKind, roleName string, subjects ...map[string]interface{}) *unstructured.Unstructured {
+	object := getObject("rbac.authorization.k8s.io/v1", kind, name, namespace, false)
+	object.Object["roleRef"] = map[string]interface{}{"apiGroup": "rbac.authorization.k8s.io", "kind": roleKind, "name": roleName}
+	items := []interface{}{}
+	for _, subject := range subjects {
+		items = append(items, subject)
+	}
+	object.Object["subjects"] = items
+	return object
+}
+
+func rbacTestRule(verbs, apiGroups, resources []interface{}) map[string]interface{} {
+	return map[string]interface{}{"verbs": verbs, "apiGroups": apiGroups, "resources": resources}
+}
+
+func TestDataGathererRBACFetch(t *testing.T) {
+	secretNamed := rbacTestRule([]interface{}{"get"}, []interface{}{""}, []interface{}{"secrets"})
+	secretNamed["resourceNames"] = []interface{}{"one"}
+
+	resources := []*api.GatheredResource{
+		{Resource: getRole("ClusterRole", "cluster-admin", "", rbacTestRule([]interface{}{"*"}, []interface{}{"*"}, []interface{}{"*"}))},
+		{Resource: getRole("ClusterRole", "secret-reader", "", rbacTestRule([]interface{}{"get", "list"}, []interface{}{""}, []interface{}{"secrets", "configmaps"}))},
+		{Resource: getRole("ClusterRole", "view", "", rbacTestRule([]interface{}{"get"}, []interface{}{""}, []interface{}{"pods"}))},
+		{Resource: getRole("Role", "debugger", "apps", rbacTestRule([]interface{}{"create"}, []interface{}{""}, []interface{}{"*/exec"}), secretNamed)},
+		{Resource: getBinding("ClusterRoleBinding", "admins", "", "ClusterRole", "cluster-admin",
+			map[string]interface{}{"kind": "Group", "name": "system:masters"})},
+		{Resource: getBinding("ClusterRoleBinding", "viewers", "", "ClusterRole", "view",
+			map[string]interface{}{"kind": "Group", "name": "system:authenticated"})},
+		{Resource: getBinding("RoleBinding", "secret-readers", "apps", "ClusterRole", "secret-reader",
+			map[string]interface{}{"kind": "ServiceAccount", "name": "ci"},
+			map[string]interface{}{"kind": "User", "name": "jane"})},
+		{Resource: getBinding("RoleBinding", "secret-readers", "web", "ClusterRole", "secret-reader",
+			map[string]interface{}{"kind": "User", "name": "jane"})},
+		{Resource: getBinding("RoleBinding", "debuggers", "apps", "Role", "debugger",
+			map[string]interface{}{"kind": "ServiceAccount", "name": "ci", "namespace": "apps"})},
+		{Resource: getBinding("ClusterRoleBinding", "missing", "", "ClusterRole", "missing",
+			map[string]interface{}{"kind": "User", "name": "nobody"})},
+		{Resource: getBinding("ClusterRoleBinding", "deleted", "", "ClusterRole", "cluster-admin",
+			map[string]interface{}{"kind": "User", "name": "former-admin"}), DeletedAt: api.Time{Time: clock.now()}},
+	}
+
+	dg := &DataGathererRBAC{DynamicWrapper: DynamicWrapper{Dynamic: &fakeDataGatherer{data: map[string]interface{}{"items": resources}}}}
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	allCapabilities := []*RBACCapability{}
+	for _, capability := range rbacCapabilities {
+		allCapabilities = append(allCapabilities, &RBACCapability{Name: capability.name, ClusterWide: true})
+	}
+	expected := map[string]interface{}{
+		"subjects": []*RBACSubject{
+			{Kind: "Group", Name: "system:masters", Roles: []string{"ClusterRole/cluster-admin"}, Capabilities: allCapabilities},
+			{
+				Kind:      "ServiceAccount",
+				Name:      "ci",
+				Namespace: "apps",
+				Roles:     []string{"ClusterRole/secret-reader@apps", "Role/apps/debugger"},
+				Capabilities: []*RBACCapability{
+					{Name: "read-secrets", Namespaces: []string{"apps"}},
+					{Name: "exec-pods", Namespaces: []string{"apps"}},
+				},
+			},
+			{
+				Kind:  "User",
+				Name:  "jane",
+				Roles: []string{"ClusterRole/secret-reader@apps", "ClusterRole/secret-reader@web"},
+				Capabilities: []*RBACCapability{
+					{Name: "read-secrets", Namespaces: []string{"apps", "web"}},
+				},
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+}
+
+func TestRBACResourceMatches(t *testing.T) {
+	tests := []struct {
+		resources []string
+		resource  string
+		expected  bool
+	}{
+		{[]string{"*"}, "pods/exec", true},
+		{[]string{"pods"}, "pods/exec", false},
+		{[]string{"*/exec"}, "pods/exec", true},
+		{[]string{"*/exec"}, "pods", false},
+		{[]string{"pods/exec"}, "pods/exec", true},
+	}
+	for _, test := range tests {
+		if matches := rbacResourceMatches(test.resources, test.resource); matches != test.expected {
+			t.Errorf("%v matching %q: expected %t, got %t", test.resources, test.resource, test.expected, matches)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package k8s
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/logs"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+// runRelist lists the watched resource types again every relist interval,
+// until the stop channel or the informer context are closed.
+func (g *DataGathererDynamic) runRelist(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(g.relistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		case <-g.informerCtx.Done():
+			return
+		}
+		for gvr := range g.informers {
+			// polled resource types are listed every poll interval already
+			if g.isPolled(gvr) {
+				continue
+			}
+			if err := g.relist(gvr); err != nil {
+				logs.Log.WithField(logs.ResourceField, ResourceTypeKey(gvr)).Errorf("failed to relist %q resources: %v", ResourceTypeKey(gvr), err)
+			}
+		}
+	}
+}
+
+// relist lists all the resources of the resource type and reconciles the
+// cache with them, in case the informer missed some events: the listed
+// resources missing from the cache are added, the cached resources with an
+// outdated resource version are updated and the cached resources missing
+// from the list are marked as deleted. The cached resources changed by the
+// informer while listing are left alone, as the list may predate the change.
+// The store of the informer is reconciled as well, otherwise the informer
+// would put the outdated resources back in the cache when it resyncs.
+func (g *DataGathererDynamic) relist(gvr schema.GroupVersionResource) error {
+	before := map[string]*api.GatheredResource{}
+	for key, item := range g.cache.Items() {
+		cacheObject := item.Object.(*api.GatheredResource)
+		if !cacheObject.DeletedAt.IsZero() {
+			continue
+		}
+		if resourceType, ok := g.resourceTypeOf(cacheObject); ok && resourceType == gvr {
+			before[key] = cacheObject
+		}
+	}
+
+	list, err := g.listPages(gvr)
+	if err != nil {
+		return err
+	}
+	items, err := listItems(list)
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]bool{}
+	for _, raw := range items {
+		obj := g.toUnstructured(raw, gvr)
+		resource, ok := obj.(*unstructured.Unstructured)
+		if !ok || g.isExcluded(obj) {
+			continue
+		}
+		transformed, keep := g.transform(obj)
+		if !keep {
+			continue
+		}
+		key := string(resource.GetUID())
+		listed[key] = true
+
+		current, ok := g.cache.Get(key)
+		if !ok {
+			g.reconcileStore(gvr, resource, resource.GetResourceVersion(), raw)
+			g.indexResourceType(obj, gvr)
+			g.markDirty(obj)
+			onAdd(transformed, g.cache)
+			continue
+		}
+		cached := before[key]
+		if cached == nil || current.(*api.GatheredResource) != cached {
+			continue
+		}
+		cachedResource, ok := cached.Resource.(*unstructured.Unstructured)
+		if !ok || cachedResource.GetResourceVersion() == "" || cachedResource.GetResourceVersion() == resource.GetResourceVersion() {
+			continue
+		}
+		g.reconcileStore(gvr, resource, cachedResource.GetResourceVersion(), raw)
+		g.markUpdated(cachedResource, obj)
+		onUpdate(obj, transformed, g.cache)
+	}
+
+	for key, cached := range before {
+		if listed[key] {
+			continue
+		}
+		current, ok := g.cache.Get(key)
+		if !ok || current.(*api.GatheredResource) != cached {
+			continue
+		}
+		if cachedResource, ok := cached.Resource.(*unstructured.Unstructured); ok {
+			g.reconcileStore(gvr, cachedResource, cachedResource.GetResourceVersion(), nil)
+		}
+		g.markDirty(cached.Resource)
+		onDelete(cached.Resource, g.cache)
+		g.retainTombstone(cached.Resource)
+	}
+
+	return nil
+}
+
+// reconcileStore replaces the resource in the store of the informer of the
+// resource type with the listed one, or deletes it from the store if listed
+// is nil. The stored resource is left alone if its resource version differs
+// from the expected one, as the informer has received a newer version since.
+func (g *DataGathererDynamic) reconcileStore(gvr schema.GroupVersionResource, resource *unstructured.Unstructured, resourceVersion string, listed interface{}) {
+	store, ok := g.storeOf(gvr, resource.GetNamespace())
+	if !ok {
+		return
+	}
+	key := resource.GetName()
+	if resource.GetNamespace() != "" {
+		key = resource.GetNamespace() + "/" + key
+	}
+	stored, exists, err := store.GetByKey(key)
+	if err != nil {
+		return
+	}
+	if exists {
+		accessor, err := meta.Accessor(stored)
+		if err != nil || accessor.GetUID() != resource.GetUID() || accessor.GetResourceVersion() != resourceVersion {
+			return
+		}
+	}
+
+	log := logs.Log.WithField(logs.ResourceField, ResourceTypeKey(gvr))
+	if listed == nil {
+		if exists {
+			if err := store.Delete(stored); err != nil {
+				log.Errorf("failed to delete %q from the informer store: %v", key, err)
+			}
+		}
+		return
+	}
+	if err := store.Update(listed); err != nil {
+		log.Errorf("failed to update %q in the informer store: %v", key, err)
+	}
+}
+
+// storeOf returns the store of the informer holding the resources of the
+// resource type in the namespace. The store of a sharded informer is a
+// snapshot, the store of the informer of the namespace's shard is returned
+// instead.
+func (g *DataGathererDynamic) storeOf(gvr schema.GroupVersionResource, namespace string) (k8scache.Store, bool) {
+	informer, ok := g.informers[gvr]
+	if !ok {
+		return nil, false
+	}
+	if sharded, ok := informer.(*shardedInformer); ok {
+		if informer, ok = sharded.informerOf(namespace); !ok {
+			return nil, false
+		}
+	}
+	return informer.GetStore(), true
+}
+
+// listItems returns the items of a list returned by listPages.
+func listItems(list runtime.Object) ([]interface{}, error) {
+	var items []interface{}
+	switch l := list.(type) {
+	case *unstructured.UnstructuredList:
+		for i := range l.Items {
+			items = append(items, &l.Items[i])
+		}
+	case *metav1.PartialObjectMetadataList:
+		for i := range l.Items {
+			items = append(items, &l.Items[i])
+		}
+	default:
+		return nil, fmt.Errorf("unexpected list type %T", list)
+	}
+	return items, nil
+}
@@ -0,0 +1,149 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDynamicGatherer_Relist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	foos := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+
+	updated := getObject("foobar/v1", "Foo", "updated", "testns", false)
+	updated.SetResourceVersion("2")
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{foos: "UnstructuredList"},
+		getObject("foobar/v1", "Foo", "missing", "testns", false),
+		getObject("foobar/v1", "Foo", "unchanged", "testns", false),
+		updated,
+	)
+
+	config := ConfigDynamic{
+		GroupVersionResource: foos,
+		RelistInterval:       time.Hour,
+	}
+	dg, err := config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	g := dg.(*DataGathererDynamic)
+
+	// the cache as left by an informer that missed some events
+	outdated := getObject("foobar/v1", "Foo", "updated", "testns", false)
+	outdated.SetResourceVersion("1")
+	for _, obj := range []*unstructured.Unstructured{
+		getObject("foobar/v1", "Foo", "unchanged", "testns", false),
+		outdated,
+		getObject("foobar/v1", "Foo", "deleted", "testns", false),
+	} {
+		g.indexResourceType(obj, foos)
+		onAdd(obj, g.cache)
+	}
+	unchanged, _ := g.cache.Get("unchanged1")
+
+	if err := g.relist(foos); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	for key, wantDeleted := range map[string]bool{"missing1": false, "unchanged1": false, "updated1": false, "deleted1": true} {
+		item, ok := g.cache.Get(key)
+		if !ok {
+			t.Errorf("expected %q to be cached", key)
+			continue
+		}
+		if deleted := !item.(*api.GatheredResource).DeletedAt.IsZero(); deleted != wantDeleted {
+			t.Errorf("expected %q deleted to be %v, got %v", key, wantDeleted, deleted)
+		}
+	}
+	if item, _ := g.cache.Get("unchanged1"); item != unchanged {
+		t.Errorf("expected the unchanged resource to be left alone")
+	}
+	item, _ := g.cache.Get("updated1")
+	if rv := item.(*api.GatheredResource).Resource.(*unstructured.Unstructured).GetResourceVersion(); rv != "2" {
+		t.Errorf("expected the outdated resource to be updated, got resource version %q", rv)
+	}
+}
+
+func TestDynamicGatherer_RelistThenResync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resyncPeriod := informerResyncPeriod
+	informerResyncPeriod = 50 * time.Millisecond
+	defer func() { informerResyncPeriod = resyncPeriod }()
+
+	foos := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	updated := getObject("foobar/v1", "Foo", "updated", "testns", false)
+	updated.SetResourceVersion("1")
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{foos: "UnstructuredList"},
+		getObject("foobar/v1", "Foo", "deleted", "testns", false),
+		updated,
+	)
+	// the informer misses all the events
+	cl.PrependWatchReactor("*", func(k8stesting.Action) (bool, watch.Interface, error) {
+		return true, watch.NewFake(), nil
+	})
+
+	config := ConfigDynamic{
+		GroupVersionResource: foos,
+		RelistInterval:       time.Hour,
+	}
+	dg, err := config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	g := dg.(*DataGathererDynamic)
+	if err := g.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := g.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := cl.Resource(foos).Namespace("testns").Delete(ctx, "deleted", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	updated = updated.DeepCopy()
+	updated.SetResourceVersion("2")
+	if _, err := cl.Resource(foos).Namespace("testns").Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if err := g.relist(foos); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	// let the informer resync a few times
+	time.Sleep(10 * informerResyncPeriod)
+
+	item, ok := g.cache.Get("deleted1")
+	if !ok || item.(*api.GatheredResource).DeletedAt.IsZero() {
+		t.Errorf("expected the deleted resource to stay deleted after a resync")
+	}
+	item, _ = g.cache.Get("updated1")
+	if rv := item.(*api.GatheredResource).Resource.(*unstructured.Unstructured).GetResourceVersion(); rv != "2" {
+		t.Errorf("expected the updated resource to stay updated after a resync, got resource version %q", rv)
+	}
+}
+
+func TestRelistValidation(t *testing.T) {
+	config := ConfigDynamic{
+		GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+		RelistInterval:       -time.Minute,
+	}
+	if err := config.validate(); err == nil {
+		t.Errorf("expected an error when relist-interval is negative")
+	}
+}
@@ -0,0 +1,118 @@
+package k8s
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// removePathWildcard is the segment of a removed path matching every element
+// of an array or every key of an object.
+const removePathWildcard = "*"
+
+// protectedRemovePaths identify resources, and resourceVersion is needed by
+// the informers to resume watching, so they cannot be removed.
+var protectedRemovePaths = [][]string{
+	{"apiVersion"},
+	{"kind"},
+	{"metadata", "name"},
+	{"metadata", "namespace"},
+	{"metadata", "uid"},
+	{"metadata", "resourceVersion"},
+}
+
+// removePaths parses the JSON pointers of the fields removed before the
+// resources enter the informers, as the paths of RFC 6902 remove operations.
+func removePaths(pointers []string) ([][]string, error) {
+	var paths [][]string
+	for _, pointer := range pointers {
+		if !strings.HasPrefix(pointer, "/") {
+			return nil, fmt.Errorf("invalid configuration: removed path %q must be a JSON pointer", pointer)
+		}
+		path, err := gabs.JSONPointerToSlice(pointer)
+		if err != nil || len(path) == 0 {
+			return nil, fmt.Errorf("invalid configuration: invalid removed path %q", pointer)
+		}
+		for _, protected := range protectedRemovePaths {
+			if matchesPrefix(path, protected) {
+				return nil, fmt.Errorf("invalid configuration: removed path %q cannot remove %q", pointer, "/"+strings.Join(protected, "/"))
+			}
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// matchesPrefix returns true if removing the path would remove the protected
+// path, i.e. if the path, wildcards included, is a prefix of it.
+func matchesPrefix(path, protected []string) bool {
+	if len(path) > len(protected) {
+		return false
+	}
+	for i, segment := range path {
+		if segment != protected[i] && segment != removePathWildcard {
+			return false
+		}
+	}
+	return true
+}
+
+// removeFields removes the fields at the paths from the resource, in order.
+// Unlike RFC 6902, a missing field is not an error.
+func removeFields(resource *unstructured.Unstructured, paths [][]string) {
+	for _, path := range paths {
+		removeField(resource.Object, path)
+	}
+}
+
+// removeField removes the field at the path from the value, and returns the
+// value, which is a new slice if an element of an array was removed.
+func removeField(value interface{}, path []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if path[0] == removePathWildcard {
+			for key, child := range v {
+				if len(path) == 1 {
+					delete(v, key)
+				} else {
+					v[key] = removeField(child, path[1:])
+				}
+			}
+			return v
+		}
+		child, ok := v[path[0]]
+		if !ok {
+			return v
+		}
+		if len(path) == 1 {
+			delete(v, path[0])
+		} else {
+			v[path[0]] = removeField(child, path[1:])
+		}
+		return v
+	case []interface{}:
+		if path[0] == removePathWildcard {
+			if len(path) == 1 {
+				return []interface{}{}
+			}
+			for i := range v {
+				v[i] = removeField(v[i], path[1:])
+			}
+			return v
+		}
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= len(v) {
+			return v
+		}
+		if len(path) == 1 {
+			return append(v[:i:i], v[i+1:]...)
+		}
+		v[i] = removeField(v[i], path[1:])
+		return v
+	default:
+		return value
+	}
+}
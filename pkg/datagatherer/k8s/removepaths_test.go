@@ -0,0 +1,141 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func getPodWithEnv(name, namespace string) *unstructured.Unstructured {
+	pod := getObject("v1", "Pod", name, namespace, false)
+	pod.Object["spec"] = map[string]interface{}{
+		"containers": []interface{}{
+			map[string]interface{}{
+				"name":  "app",
+				"image": "app:v1",
+				"env":   []interface{}{map[string]interface{}{"name": "A", "value": "1"}},
+			},
+			map[string]interface{}{
+				"name":  "sidecar",
+				"image": "sidecar:v1",
+				"env":   []interface{}{map[string]interface{}{"name": "B", "value": "2"}},
+			},
+		},
+		"volumes": []interface{}{
+			map[string]interface{}{"name": "config"},
+			map[string]interface{}{"name": "token"},
+		},
+	}
+	return pod
+}
+
+func TestRemovePathsValidate(t *testing.T) {
+	tests := map[string]struct {
+		paths   []string
+		wantErr bool
+	}{
+		"pointers":          {paths: []string{"/spec/containers/*/env", "/metadata/annotations/kubectl.kubernetes.io~1last-applied-configuration"}},
+		"index":             {paths: []string{"/spec/volumes/0"}},
+		"dot path":          {paths: []string{".spec.containers"}, wantErr: true},
+		"name":              {paths: []string{"/metadata/name"}, wantErr: true},
+		"metadata":          {paths: []string{"/metadata"}, wantErr: true},
+		"resource version":  {paths: []string{"/metadata/resourceVersion"}, wantErr: true},
+		"wildcard metadata": {paths: []string{"/metadata/*"}, wantErr: true},
+		"wildcard":          {paths: []string{"/*"}, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := removePaths(test.paths)
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRemoveFields(t *testing.T) {
+	paths, err := removePaths([]string{
+		"/spec/containers/*/env",
+		"/spec/volumes/1",
+		"/spec/missing/field",
+		"/spec/containers/5/image",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pod := getPodWithEnv("example", "default")
+	removeFields(pod, paths)
+
+	expected := map[string]interface{}{
+		"containers": []interface{}{
+			map[string]interface{}{"name": "app", "image": "app:v1"},
+			map[string]interface{}{"name": "sidecar", "image": "sidecar:v1"},
+		},
+		"volumes": []interface{}{
+			map[string]interface{}{"name": "config"},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, pod.Object["spec"]); !equal {
+		t.Errorf("unexpected spec:\n%s", diff)
+	}
+}
+
+func TestDataGathererDynamicRemovePaths(t *testing.T) {
+	ctx := context.Background()
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{pods: "UnstructuredList"},
+		getPodWithEnv("example", "default"),
+	)
+
+	config := ConfigDynamic{
+		GroupVersionResource: pods,
+		RemovePaths:          []string{"/spec/containers/*/env"},
+	}
+	dg, err := config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the fields are removed before the informer stores the resources
+	stored := dg.(*DataGathererDynamic).informers[pods].GetStore().List()
+	if len(stored) != 1 {
+		t.Fatalf("expected one pod in the informer, got %d", len(stored))
+	}
+	containers, _, _ := unstructured.NestedSlice(stored[0].(*unstructured.Unstructured).Object, "spec", "containers")
+	for _, container := range containers {
+		if _, found := container.(map[string]interface{})["env"]; found {
+			t.Errorf("expected the env of the containers to be removed from the informer, got %v", container)
+		}
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	items := data.(map[string]interface{})["items"].([]*api.GatheredResource)
+	if len(items) != 1 {
+		t.Fatalf("expected one pod, got %d", len(items))
+	}
+	containers, _, _ = unstructured.NestedSlice(items[0].Resource.(*unstructured.Unstructured).Object, "spec", "containers")
+	if len(containers) != 2 {
+		t.Fatalf("expected the containers to be kept, got %v", containers)
+	}
+	if _, found := containers[0].(map[string]interface{})["env"]; found {
+		t.Errorf("expected the env of the containers to be removed, got %v", containers[0])
+	}
+}
@@ -0,0 +1,172 @@
+package k8s
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jetstack/preflight/pkg/logs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// isResourcePattern returns true if the resource type contains wildcards and
+// has to be resolved using the discovery API.
+func isResourcePattern(gvr schema.GroupVersionResource) bool {
+	return strings.ContainsAny(gvr.Group+gvr.Version+gvr.Resource, "*?[")
+}
+
+// splitResourcePatterns separates concrete resource types from the ones
+// containing wildcards.
+func splitResourcePatterns(gvrs []schema.GroupVersionResource) (concrete, patterns []schema.GroupVersionResource) {
+	for _, gvr := range gvrs {
+		if isResourcePattern(gvr) {
+			patterns = append(patterns, gvr)
+		} else {
+			concrete = append(concrete, gvr)
+		}
+	}
+	return concrete, patterns
+}
+
+// matchesResourcePattern returns true if the resource type matches the
+// pattern. Each of group, version and resource are matched using shell file
+// name patterns, see path.Match.
+func matchesResourcePattern(pattern, gvr schema.GroupVersionResource) bool {
+	for _, p := range [][2]string{
+		{pattern.Group, gvr.Group},
+		{pattern.Version, gvr.Version},
+		{pattern.Resource, gvr.Resource},
+	} {
+		if ok, err := path.Match(p[0], p[1]); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveResourcePatterns uses the discovery API to find all the resource
+// types matching the configured patterns and creates an informer for each of
+// them. Subresources and resources that cannot be listed and watched are
+// ignored. When the version of a pattern is "*", only the preferred version
+// of each group is used.
+func (g *DataGathererDynamic) resolveResourcePatterns() error {
+	if g.discoveryClient == nil {
+		return fmt.Errorf("discovery client was not initialized, impossible to resolve resource patterns")
+	}
+
+	resolved, err := resolveResourcePatterns(g.discoveryClient, g.resourcePatterns)
+	if err != nil {
+		return err
+	}
+
+	for _, gvr := range resolved {
+		if _, ok := g.informers[gvr]; ok {
+			continue
+		}
+		logs.Log.WithField(logs.ResourceField, ResourceTypeKey(gvr)).Infof("resolved resource type %q for datagatherer", ResourceTypeKey(gvr))
+		g.addInformer(gvr)
+		g.groupVersionResources = append(g.groupVersionResources, gvr)
+	}
+
+	return nil
+}
+
+// ResolveResourcePatterns returns the resource types served by the cluster
+// that match the patterns and can be listed and watched.
+func ResolveResourcePatterns(cl discovery.DiscoveryInterface, patterns []schema.GroupVersionResource) ([]schema.GroupVersionResource, error) {
+	return resolveResourcePatterns(cl, patterns)
+}
+
+func resolveResourcePatterns(cl discovery.DiscoveryInterface, patterns []schema.GroupVersionResource) ([]schema.GroupVersionResource, error) {
+	var preferredOnly []schema.GroupVersionResource
+	var allVersions []schema.GroupVersionResource
+	for _, pattern := range patterns {
+		if pattern.Version == "*" {
+			preferredOnly = append(preferredOnly, pattern)
+		} else {
+			allVersions = append(allVersions, pattern)
+		}
+	}
+
+	var resolved []schema.GroupVersionResource
+	seen := map[schema.GroupVersionResource]bool{}
+	add := func(lists []*metav1.APIResourceList, patterns []schema.GroupVersionResource) {
+		for _, gvr := range listableResources(lists) {
+			if seen[gvr] {
+				continue
+			}
+			for _, pattern := range patterns {
+				if matchesResourcePattern(pattern, gvr) {
+					seen[gvr] = true
+					resolved = append(resolved, gvr)
+					break
+				}
+			}
+		}
+	}
+
+	if len(preferredOnly) > 0 {
+		lists, err := cl.ServerPreferredResources()
+		if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("failed to discover preferred resources: %v", err)
+		}
+		add(lists, preferredOnly)
+	}
+
+	if len(allVersions) > 0 {
+		_, lists, err := cl.ServerGroupsAndResources()
+		if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("failed to discover resources: %v", err)
+		}
+		add(lists, allVersions)
+	}
+
+	if len(resolved) == 0 {
+		logs.Log.Warnf("no resource types matched the patterns %v", patterns)
+	}
+
+	return resolved, nil
+}
+
+// listableResources returns the resource types in the discovery lists that
+// support list and watch, excluding subresources.
+func listableResources(lists []*metav1.APIResourceList) []schema.GroupVersionResource {
+	var gvrs []schema.GroupVersionResource
+	for _, list := range lists {
+		if list == nil {
+			continue
+		}
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			if !hasVerbs(resource.Verbs, "list", "watch") {
+				continue
+			}
+			gvrs = append(gvrs, gv.WithResource(resource.Name))
+		}
+	}
+	return gvrs
+}
+
+func hasVerbs(verbs metav1.Verbs, required ...string) bool {
+	for _, r := range required {
+		found := false
+		for _, v := range verbs {
+			if v == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
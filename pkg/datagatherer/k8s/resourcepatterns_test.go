@@ -0,0 +1,80 @@
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestMatchesResourcePattern(t *testing.T) {
+	certificates := schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+	tests := map[string]struct {
+		pattern schema.GroupVersionResource
+		matches bool
+	}{
+		"all resources in group": {
+			pattern: schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "*"},
+			matches: true,
+		},
+		"all versions": {
+			pattern: schema.GroupVersionResource{Group: "cert-manager.io", Version: "*", Resource: "certificates"},
+			matches: true,
+		},
+		"group suffix": {
+			pattern: schema.GroupVersionResource{Group: "*cert-manager.io", Version: "*", Resource: "*"},
+			matches: true,
+		},
+		"other group": {
+			pattern: schema.GroupVersionResource{Group: "acme.cert-manager.io", Version: "v1", Resource: "*"},
+			matches: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := matchesResourcePattern(tc.pattern, certificates); got != tc.matches {
+				t.Errorf("unexpected match result: got=%t want=%t", got, tc.matches)
+			}
+		})
+	}
+}
+
+func TestResolveResourcePatterns(t *testing.T) {
+	cl := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}}
+	cl.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "cert-manager.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "certificates", Verbs: metav1.Verbs{"get", "list", "watch"}},
+				{Name: "certificates/status", Verbs: metav1.Verbs{"get", "update"}},
+				{Name: "issuers", Verbs: metav1.Verbs{"get", "list", "watch"}},
+				{Name: "notwatchable", Verbs: metav1.Verbs{"get", "list"}},
+			},
+		},
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "pods", Verbs: metav1.Verbs{"get", "list", "watch"}},
+			},
+		},
+	}
+
+	got, err := resolveResourcePatterns(cl, []schema.GroupVersionResource{
+		{Group: "cert-manager.io", Version: "v1", Resource: "*"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []schema.GroupVersionResource{
+		{Group: "cert-manager.io", Version: "v1", Resource: "certificates"},
+		{Group: "cert-manager.io", Version: "v1", Resource: "issuers"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected resolved resources: got=%v want=%v", got, expected)
+	}
+}
@@ -0,0 +1,84 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+)
+
+// serviceAccountTokenExpiration is the requested lifetime of the service
+// account tokens, they are requested again shortly before they expire.
+const serviceAccountTokenExpiration = time.Hour
+
+// splitServiceAccount splits a service account in the namespace/name
+// format.
+func splitServiceAccount(serviceAccount string) (string, string, error) {
+	parts := strings.Split(serviceAccount, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid configuration: ServiceAccount %q must be in the namespace/name format", serviceAccount)
+	}
+	return parts[0], parts[1], nil
+}
+
+// serviceAccountConfig returns a copy of the config authenticating as the
+// service account instead, with bound tokens requested using the identity of
+// the config.
+func serviceAccountConfig(cfg *rest.Config, serviceAccount string, audiences []string) (*rest.Config, error) {
+	namespace, name, err := splitServiceAccount(serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// the credentials of the kubeconfig would take precedence over the
+	// token, so they are removed
+	saCfg := rest.AnonymousClientConfig(cfg)
+	saCfg.Wrap(transport.TokenSourceWrapTransport(transport.NewCachedTokenSource(&tokenRequestSource{
+		cl:        cl,
+		namespace: namespace,
+		name:      name,
+		audiences: audiences,
+	})))
+	return saCfg, nil
+}
+
+// tokenRequestSource requests bound tokens for a service account with the
+// TokenRequest API.
+type tokenRequestSource struct {
+	cl        kubernetes.Interface
+	namespace string
+	name      string
+	audiences []string
+}
+
+// Token requests a new token for the service account.
+func (s *tokenRequestSource) Token() (*oauth2.Token, error) {
+	expirationSeconds := int64(serviceAccountTokenExpiration.Seconds())
+	request := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         s.audiences,
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}
+	response, err := s.cl.CoreV1().ServiceAccounts(s.namespace).CreateToken(context.Background(), s.name, request, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request a token for service account %s/%s: %s", s.namespace, s.name, err)
+	}
+	return &oauth2.Token{
+		AccessToken: response.Status.Token,
+		TokenType:   "Bearer",
+		// renew the token before the API server rejects it
+		Expiry: response.Status.ExpirationTimestamp.Add(-time.Minute),
+	}, nil
+}
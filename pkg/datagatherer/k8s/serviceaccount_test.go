@@ -0,0 +1,80 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTokenRequestSource(t *testing.T) {
+	cl := fake.NewSimpleClientset()
+	expiration := time.Now().Add(time.Hour)
+	var requested *authenticationv1.TokenRequest
+	cl.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" || action.GetNamespace() != "preflight" {
+			t.Fatalf("unexpected action: %v", action)
+		}
+		requested = action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		return true, &authenticationv1.TokenRequest{
+			Status: authenticationv1.TokenRequestStatus{
+				Token:               "token",
+				ExpirationTimestamp: metav1.NewTime(expiration),
+			},
+		}, nil
+	})
+
+	source := &tokenRequestSource{cl: cl, namespace: "preflight", name: "gatherer", audiences: []string{"https://kubernetes.default.svc"}}
+	token, err := source.Token()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.AccessToken != "token" || !token.Expiry.Before(expiration) {
+		t.Errorf("unexpected token: %+v", token)
+	}
+	if len(requested.Spec.Audiences) != 1 || *requested.Spec.ExpirationSeconds != 3600 {
+		t.Errorf("unexpected token request: %+v", requested.Spec)
+	}
+}
+
+func TestClientOptionsValidateIdentity(t *testing.T) {
+	for name, tc := range map[string]struct {
+		options ClientOptions
+		valid   bool
+	}{
+		"impersonation": {
+			options: ClientOptions{ImpersonateUser: "auditor", ImpersonateGroups: []string{"readers"}},
+			valid:   true,
+		},
+		"service account": {
+			options: ClientOptions{ServiceAccount: "preflight/gatherer", TokenAudiences: []string{"api"}},
+			valid:   true,
+		},
+		"groups without user": {
+			options: ClientOptions{ImpersonateGroups: []string{"readers"}},
+		},
+		"invalid service account": {
+			options: ClientOptions{ServiceAccount: "gatherer"},
+		},
+		"audiences without service account": {
+			options: ClientOptions{TokenAudiences: []string{"api"}},
+		},
+		"impersonation and service account": {
+			options: ClientOptions{ImpersonateUser: "auditor", ServiceAccount: "preflight/gatherer"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.options.Validate()
+			if tc.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+// defaultNamespaceSyncConcurrency is the number of included namespaces whose
+// informers list their resources at the same time, unless configured.
+const defaultNamespaceSyncConcurrency = 4
+
+// shardedInformerFactory is the informer factory of the data gatherers with
+// included namespaces. Each namespace is a shard with an informer factory of
+// its own, so that only the resources of the included namespaces are listed
+// and watched, and the agent only needs to be granted these namespaces. The
+// informer of a resource type spans the informers of all the shards.
+type shardedInformerFactory struct {
+	newFactory func(namespace string) informerFactory
+	// slots bounds the number of shards listing their resources at the same
+	// time, so that a long list of namespaces does not flood the API server
+	// when the data gatherer starts.
+	slots chan struct{}
+
+	mu        sync.Mutex
+	shards    map[string]*namespaceShard
+	informers map[schema.GroupVersionResource]*shardedInformer
+	// stopCh is set once the factory is started, the shards added
+	// afterwards are started straight away.
+	stopCh <-chan struct{}
+}
+
+// namespaceShard is the informer factory of an included namespace.
+type namespaceShard struct {
+	namespace string
+	factory   informerFactory
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+func newShardedInformerFactory(namespaces []string, concurrency int, newFactory func(namespace string) informerFactory) *shardedInformerFactory {
+	if concurrency <= 0 {
+		concurrency = defaultNamespaceSyncConcurrency
+	}
+	f := &shardedInformerFactory{
+		newFactory: newFactory,
+		slots:      make(chan struct{}, concurrency),
+		shards:     map[string]*namespaceShard{},
+		informers:  map[schema.GroupVersionResource]*shardedInformer{},
+	}
+	f.setNamespaces(namespaces)
+	return f
+}
+
+// ForResource returns the informer of the resource type across all the
+// shards.
+func (f *shardedInformerFactory) ForResource(gvr schema.GroupVersionResource) informers.GenericInformer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	informer, ok := f.informers[gvr]
+	if !ok {
+		informer = &shardedInformer{informers: map[string]k8scache.SharedIndexInformer{}}
+		for namespace, shard := range f.shards {
+			informer.add(namespace, shard.factory.ForResource(gvr).Informer())
+		}
+		f.informers[gvr] = informer
+	}
+	return &shardedGenericInformer{informer: informer, resource: gvr.GroupResource()}
+}
+
+// Start starts the shards, with at most the concurrency of the factory
+// listing their resources at the same time.
+func (f *shardedInformerFactory) Start(stopCh <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopCh != nil {
+		return
+	}
+	f.stopCh = stopCh
+	for _, namespace := range sortedShardNames(f.shards) {
+		f.start(f.shards[namespace])
+	}
+}
+
+// start starts the informers of the shard once a slot is free, and frees the
+// slot once they synced. f.mu must be held.
+func (f *shardedInformerFactory) start(shard *namespaceShard) {
+	stopCh := f.stopCh
+	var synced []k8scache.InformerSynced
+	for _, informer := range f.informers {
+		if shardInformer, ok := informer.informerOf(shard.namespace); ok {
+			synced = append(synced, shardInformer.HasSynced)
+		}
+	}
+	go func() {
+		select {
+		case <-stopCh:
+			shard.cancel()
+		case <-shard.ctx.Done():
+		}
+	}()
+	go func() {
+		select {
+		case f.slots <- struct{}{}:
+		case <-shard.ctx.Done():
+			return
+		}
+		defer func() { <-f.slots }()
+		shard.factory.Start(shard.ctx.Done())
+		k8scache.WaitForCacheSync(shard.ctx.Done(), synced...)
+	}()
+}
+
+// setNamespaces adds a shard for each of the new namespaces and stops the
+// shards of the namespaces removed. It returns the namespaces removed.
+func (f *shardedInformerFactory) setNamespaces(namespaces []string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	included := map[string]bool{}
+	for _, namespace := range namespaces {
+		included[namespace] = true
+		if _, ok := f.shards[namespace]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		shard := &namespaceShard{
+			namespace: namespace,
+			factory:   f.newFactory(namespace),
+			ctx:       ctx,
+			cancel:    cancel,
+		}
+		for gvr, informer := range f.informers {
+			informer.add(namespace, shard.factory.ForResource(gvr).Informer())
+		}
+		f.shards[namespace] = shard
+		if f.stopCh != nil {
+			f.start(shard)
+		}
+	}
+
+	var removed []string
+	for _, namespace := range sortedShardNames(f.shards) {
+		if included[namespace] {
+			continue
+		}
+		f.shards[namespace].cancel()
+		for _, informer := range f.informers {
+			informer.remove(namespace)
+		}
+		delete(f.shards, namespace)
+		removed = append(removed, namespace)
+	}
+	return removed
+}
+
+// namespaces returns the namespaces of the shards.
+func (f *shardedInformerFactory) namespaces() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedShardNames(f.shards)
+}
+
+func sortedShardNames(shards map[string]*namespaceShard) []string {
+	names := make([]string, 0, len(shards))
+	for name := range shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// shardedGenericInformer is the GenericInformer of a sharded informer.
+type shardedGenericInformer struct {
+	informer *shardedInformer
+	resource schema.GroupResource
+}
+
+func (i *shardedGenericInformer) Informer() k8scache.SharedIndexInformer {
+	return i.informer
+}
+
+func (i *shardedGenericInformer) Lister() k8scache.GenericLister {
+	return k8scache.NewGenericLister(i.informer.GetIndexer(), i.resource)
+}
+
+// shardedInformer is the informer of a resource type spanning the informers
+// of the shards. The event handlers, watch error handler and indexers are
+// added to the informers of the shards, including the shards added later.
+type shardedInformer struct {
+	mu        sync.RWMutex
+	informers map[string]k8scache.SharedIndexInformer
+	handlers  []shardedEventHandler
+	// watchErrorHandler is set on the informers of the shards with the
+	// namespace of the shard in the errors.
+	watchErrorHandler k8scache.WatchErrorHandler
+	indexers          k8scache.Indexers
+}
+
+// shardedEventHandler is an event handler of a sharded informer, with its
+// resync period unless it uses the one of the informers.
+type shardedEventHandler struct {
+	handler      k8scache.ResourceEventHandler
+	resyncPeriod *time.Duration
+}
+
+// add adds the informer of a shard, it is started by the shard.
+func (s *shardedInformer) add(namespace string, informer k8scache.SharedIndexInformer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range s.handlers {
+		if h.resyncPeriod != nil {
+			informer.AddEventHandlerWithResyncPeriod(h.handler, *h.resyncPeriod)
+		} else {
+			informer.AddEventHandler(h.handler)
+		}
+	}
+	if s.watchErrorHandler != nil {
+		// the informer of a new shard is not started yet
+		_ = informer.SetWatchErrorHandler(namespacedWatchErrorHandler(namespace, s.watchErrorHandler))
+	}
+	if len(s.indexers) > 0 {
+		_ = informer.AddIndexers(s.indexers)
+	}
+	s.informers[namespace] = informer
+}
+
+func (s *shardedInformer) informerOf(namespace string) (k8scache.SharedIndexInformer, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	informer, ok := s.informers[namespace]
+	return informer, ok
+}
+
+func (s *shardedInformer) remove(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.informers, namespace)
+}
+
+func namespacedWatchErrorHandler(namespace string, handler k8scache.WatchErrorHandler) k8scache.WatchErrorHandler {
+	return func(r *k8scache.Reflector, err error) {
+		handler(r, fmt.Errorf("namespace %q: %w", namespace, err))
+	}
+}
+
+func (s *shardedInformer) AddEventHandler(handler k8scache.ResourceEventHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, shardedEventHandler{handler: handler})
+	for _, informer := range s.informers {
+		informer.AddEventHandler(handler)
+	}
+}
+
+func (s *shardedInformer) AddEventHandlerWithResyncPeriod(handler k8scache.ResourceEventHandler, resyncPeriod time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, shardedEventHandler{handler: handler, resyncPeriod: &resyncPeriod})
+	for _, informer := range s.informers {
+		informer.AddEventHandlerWithResyncPeriod(handler, resyncPeriod)
+	}
+}
+
+// GetStore returns a snapshot of the stores of the shards.
+func (s *shardedInformer) GetStore() k8scache.Store {
+	return s.GetIndexer()
+}
+
+// GetIndexer returns a snapshot of the stores of the shards, indexed by
+// namespace.
+func (s *shardedInformer) GetIndexer() k8scache.Indexer {
+	indexer := k8scache.NewIndexer(k8scache.MetaNamespaceKeyFunc, k8scache.Indexers{k8scache.NamespaceIndex: k8scache.MetaNamespaceIndexFunc})
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, informer := range s.informers {
+		for _, obj := range informer.GetStore().List() {
+			_ = indexer.Add(obj)
+		}
+	}
+	return indexer
+}
+
+// GetController returns nil, as each shard has a controller of its own.
+func (s *shardedInformer) GetController() k8scache.Controller {
+	return nil
+}
+
+// Run waits for stopCh to be closed, the informers of the shards are run by
+// the shards.
+func (s *shardedInformer) Run(stopCh <-chan struct{}) {
+	<-stopCh
+}
+
+// HasSynced returns true once the informers of all the shards synced.
+func (s *shardedInformer) HasSynced() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, informer := range s.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// LastSyncResourceVersion returns the resource versions of the shards, so
+// that it changes whenever one of them changes.
+func (s *shardedInformer) LastSyncResourceVersion() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	versions := make([]string, 0, len(s.informers))
+	for namespace, informer := range s.informers {
+		versions = append(versions, namespace+"="+informer.LastSyncResourceVersion())
+	}
+	sort.Strings(versions)
+	return strings.Join(versions, ",")
+}
+
+func (s *shardedInformer) SetWatchErrorHandler(handler k8scache.WatchErrorHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchErrorHandler = handler
+	for namespace, informer := range s.informers {
+		if err := informer.SetWatchErrorHandler(namespacedWatchErrorHandler(namespace, handler)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *shardedInformer) AddIndexers(indexers k8scache.Indexers) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.indexers == nil {
+		s.indexers = k8scache.Indexers{}
+	}
+	for name, indexer := range indexers {
+		s.indexers[name] = indexer
+	}
+	for _, informer := range s.informers {
+		if err := informer.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namespaceStatus returns whether the informer of each shard synced.
+func (s *shardedInformer) namespaceStatus() map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := map[string]bool{}
+	for namespace, informer := range s.informers {
+		status[namespace] = informer.HasSynced()
+	}
+	return status
+}
+
+// listNamespaces returns the namespaces the resources of the resource type
+// are listed in, when polling or listing them again: each of the included or
+// selected namespaces, as the agent may only be granted these, or all the
+// namespaces at once.
+func (g *DataGathererDynamic) listNamespaces(gvr schema.GroupVersionResource) []string {
+	if g.clusterInformer != nil && g.clusterScoped[gvr] {
+		return []string{metav1.NamespaceAll}
+	}
+	if shards, ok := g.sharedInformer.(*shardedInformerFactory); ok {
+		return shards.namespaces()
+	}
+	return []string{metav1.NamespaceAll}
+}
+
+// SetNamespaces changes the included namespaces of a data gatherer with
+// included namespaces, without restarting it: the informers of the new
+// namespaces are started, and the ones of the removed namespaces are stopped
+// and their resources removed from the cache.
+func (g *DataGathererDynamic) SetNamespaces(namespaces []string) error {
+	shards, ok := g.sharedInformer.(*shardedInformerFactory)
+	if !ok || g.namespaceInformer != nil || len(namespaces) == 0 {
+		return fmt.Errorf("the namespaces can only be changed from included namespaces to included namespaces")
+	}
+	removed := shards.setNamespaces(namespaces)
+
+	g.namespacesMu.Lock()
+	g.namespaces = append([]string(nil), namespaces...)
+	g.namespacesMu.Unlock()
+
+	g.forgetNamespaces(removed)
+	return nil
+}
+
+// forgetNamespaces removes the resources of the namespaces from the cache,
+// once their informers are stopped.
+func (g *DataGathererDynamic) forgetNamespaces(removed []string) {
+	if len(removed) == 0 {
+		return
+	}
+	forget := map[string]bool{}
+	for _, namespace := range removed {
+		forget[namespace] = true
+	}
+	for key, item := range g.cache.Items() {
+		// the resources are unstructured, or partial object metadata in
+		// metadata-only mode
+		resource, err := meta.Accessor(item.Object.(*api.GatheredResource).Resource)
+		if err == nil && forget[resource.GetNamespace()] {
+			g.cache.Delete(key)
+		}
+	}
+}
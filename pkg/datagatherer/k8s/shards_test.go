@@ -0,0 +1,156 @@
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/pmylund/go-cache"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+)
+
+// blockingFactory is an informer factory whose Start blocks until released,
+// as a shard listing its resources.
+type blockingFactory struct {
+	mu      *sync.Mutex
+	started *int
+	release <-chan struct{}
+}
+
+func (f *blockingFactory) ForResource(schema.GroupVersionResource) informers.GenericInformer {
+	return nil
+}
+
+func (f *blockingFactory) Start(stopCh <-chan struct{}) {
+	f.mu.Lock()
+	*f.started++
+	f.mu.Unlock()
+	select {
+	case <-f.release:
+	case <-stopCh:
+	}
+}
+
+func TestShardedInformerFactory_Concurrency(t *testing.T) {
+	var mu sync.Mutex
+	started := 0
+	release := make(chan struct{})
+	factory := newShardedInformerFactory([]string{"a", "b", "c", "d", "e"}, 2, func(string) informerFactory {
+		return &blockingFactory{mu: &mu, started: &started, release: release}
+	})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	factory.Start(stopCh)
+
+	startedCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return started
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := startedCount(); got != 2 {
+		t.Fatalf("expected 2 shards to be started at the same time, got %d", got)
+	}
+
+	close(release)
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return startedCount() == 5, nil
+	})
+	if err != nil {
+		t.Fatalf("expected all the shards to be started, got %d", startedCount())
+	}
+}
+
+func TestDynamicGatherer_SetNamespaces(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gvr := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "UnstructuredList"},
+		getObject("foobar/v1", "Foo", "foo-a", "a", false),
+		getObject("foobar/v1", "Foo", "foo-b", "b", false),
+		getObject("foobar/v1", "Foo", "foo-c", "c", false),
+	)
+
+	config := ConfigDynamic{
+		GroupVersionResource: gvr,
+		IncludeNamespaces:    []string{"a", "b"},
+	}
+	dg, err := config.newDataGathererWithClient(ctx, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g := dg.(*DataGathererDynamic)
+	if err := g.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := g.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fetched := func() []string {
+		res, err := g.Fetch()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var names []string
+		for _, item := range res.(map[string]interface{})["items"].([]*api.GatheredResource) {
+			names = append(names, item.Resource.(*unstructured.Unstructured).GetName())
+		}
+		sort.Strings(names)
+		return names
+	}
+	if expected := []string{"foo-a", "foo-b"}; !reflect.DeepEqual(fetched(), expected) {
+		t.Errorf("expected %v, got %v", expected, fetched())
+	}
+	status := g.resourceTypeStatus()["foos.v1.foobar"]
+	if expected := map[string]bool{"a": true, "b": true}; !reflect.DeepEqual(status.Namespaces, expected) {
+		t.Errorf("expected the namespaces status %v, got %v", expected, status.Namespaces)
+	}
+
+	if err := g.SetNamespaces([]string{"b", "c"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := g.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"foo-b", "foo-c"}; !reflect.DeepEqual(fetched(), expected) {
+		t.Errorf("expected %v, got %v", expected, fetched())
+	}
+	for _, item := range g.cache.Items() {
+		if item.Object.(*api.GatheredResource).Resource.(*unstructured.Unstructured).GetNamespace() == "a" {
+			t.Errorf("expected the resources of the removed namespace to be removed from the cache")
+		}
+	}
+	if got := g.listNamespaces(gvr); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Errorf("expected the resources to be listed in b and c, got %v", got)
+	}
+}
+
+func TestDynamicGatherer_forgetNamespaces(t *testing.T) {
+	g := &DataGathererDynamic{cache: cache.New(cache.NoExpiration, cache.NoExpiration)}
+	g.cache.Set("a/unstructured", &api.GatheredResource{Resource: getObject("v1", "Pod", "unstructured", "a", false)}, cache.NoExpiration)
+	g.cache.Set("a/metadata", &api.GatheredResource{Resource: &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "metadata", Namespace: "a"}}}, cache.NoExpiration)
+	g.cache.Set("b/metadata", &api.GatheredResource{Resource: &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "metadata", Namespace: "b"}}}, cache.NoExpiration)
+
+	g.forgetNamespaces([]string{"a"})
+
+	var keys []string
+	for key := range g.cache.Items() {
+		keys = append(keys, key)
+	}
+	if expected := []string{"b/metadata"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected the resources of both kinds in the removed namespace to be removed, got %v", keys)
+	}
+}
@@ -0,0 +1,541 @@
+package k8s
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	defaultTLSProbeTimeout     = 5 * time.Second
+	defaultTLSProbeConcurrency = 10
+)
+
+var servicesGVR = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+
+// tlsVersions are the names of the TLS versions, as crypto/tls only names
+// them from Go 1.21.
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// ConfigTLSProbe contains the configuration for the k8s-tls-probe
+// data-gatherer.
+type ConfigTLSProbe struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+	// Endpoints are probed as configured.
+	Endpoints []TLSProbeEndpoint `yaml:"endpoints"`
+	// FromIngresses probes the hostnames secured by the resources gathered
+	// by the k8s-ingress-tls data gatherer, on port 443.
+	FromIngresses bool `yaml:"from-ingresses"`
+	// FromServices probes the HTTPS ports of the Services, through their
+	// cluster DNS name.
+	FromServices bool `yaml:"from-services"`
+	// CompareSecrets compares the certificate served by the endpoints
+	// referencing a Secret with the certificate of the Secret.
+	CompareSecrets bool `yaml:"compare-secrets"`
+	// CABundlePath is a PEM file of the roots the served chains are
+	// validated against. The roots of the system are used if empty.
+	CABundlePath string `yaml:"ca-bundle"`
+	// Timeout is the timeout of each handshake, 5s by default.
+	Timeout time.Duration `yaml:"timeout"`
+	// Concurrency is the number of endpoints probed at once, 10 by default.
+	Concurrency int `yaml:"concurrency"`
+}
+
+// TLSProbeEndpoint is an endpoint probed by the k8s-tls-probe data gatherer.
+type TLSProbeEndpoint struct {
+	// Address is the host:port of the endpoint.
+	Address string `yaml:"address"`
+	// ServerName is sent with SNI, the host of the address by default.
+	ServerName string `yaml:"server-name"`
+	// SecretNamespace and SecretName reference the Secret the certificate
+	// served is expected to come from, if any.
+	SecretNamespace string `yaml:"secret-namespace"`
+	SecretName      string `yaml:"secret-name"`
+}
+
+func (c *ConfigTLSProbe) validate() error {
+	if len(c.Endpoints) == 0 && !c.FromIngresses && !c.FromServices {
+		return fmt.Errorf("invalid configuration: no endpoints, from-ingresses or from-services")
+	}
+	for i, endpoint := range c.Endpoints {
+		if _, _, err := net.SplitHostPort(endpoint.Address); err != nil {
+			return fmt.Errorf("invalid configuration: endpoints[%d].address: %v", i, err)
+		}
+		if (endpoint.SecretNamespace == "") != (endpoint.SecretName == "") {
+			return fmt.Errorf("invalid configuration: endpoints[%d] must set both secret-namespace and secret-name", i)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid configuration: timeout cannot be negative")
+	}
+	if c.Concurrency < 0 {
+		return fmt.Errorf("invalid configuration: concurrency cannot be negative")
+	}
+	return nil
+}
+
+func (c *ConfigTLSProbe) ingressConfig() *ConfigIngressTLS {
+	return &ConfigIngressTLS{
+		KubeConfigPath:    c.KubeConfigPath,
+		KubeConfigContext: c.KubeConfigContext,
+		ExcludeNamespaces: c.ExcludeNamespaces,
+		IncludeNamespaces: c.IncludeNamespaces,
+	}
+}
+
+func (c *ConfigTLSProbe) secretsConfig() *ConfigTLSSecrets {
+	return &ConfigTLSSecrets{
+		KubeConfigPath:    c.KubeConfigPath,
+		KubeConfigContext: c.KubeConfigContext,
+		ExcludeNamespaces: c.ExcludeNamespaces,
+		IncludeNamespaces: c.IncludeNamespaces,
+	}
+}
+
+// DynamicConfigs returns the configurations of the dynamic data gatherers
+// used to derive the endpoints from the resources of the cluster and to read
+// the Secrets, if any.
+func (c *ConfigTLSProbe) DynamicConfigs() []*ConfigDynamic {
+	var configs []*ConfigDynamic
+	if c.FromIngresses {
+		configs = append(configs, c.ingressConfig().DynamicConfig())
+	}
+	if c.FromServices {
+		configs = append(configs, c.servicesConfig())
+	}
+	if c.CompareSecrets {
+		configs = append(configs, c.secretsConfig().DynamicConfig())
+	}
+	return configs
+}
+
+func (c *ConfigTLSProbe) servicesConfig() *ConfigDynamic {
+	return &ConfigDynamic{
+		KubeConfigPath:       c.KubeConfigPath,
+		KubeConfigContext:    c.KubeConfigContext,
+		GroupVersionResource: servicesGVR,
+		ExcludeNamespaces:    c.ExcludeNamespaces,
+		IncludeNamespaces:    c.IncludeNamespaces,
+	}
+}
+
+// NewDataGatherer constructs a new instance of the k8s-tls-probe data-gatherer.
+func (c *ConfigTLSProbe) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	var roots *x509.CertPool
+	if c.CABundlePath != "" {
+		var err error
+		roots, err = (&ChainValidation{CABundlePath: c.CABundlePath}).roots()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	g := &DataGathererTLSProbe{
+		ctx:         ctx,
+		endpoints:   c.Endpoints,
+		roots:       roots,
+		timeout:     c.Timeout,
+		concurrency: c.Concurrency,
+	}
+	if g.timeout == 0 {
+		g.timeout = defaultTLSProbeTimeout
+	}
+	if g.concurrency == 0 {
+		g.concurrency = defaultTLSProbeConcurrency
+	}
+
+	if c.FromIngresses {
+		dg, err := c.ingressConfig().NewDataGatherer(ctx)
+		if err != nil {
+			return nil, err
+		}
+		g.ingressDg = dg
+		g.DynamicWrappers = append(g.DynamicWrappers, dg)
+	}
+	if c.FromServices {
+		dg, err := c.servicesConfig().NewDataGatherer(ctx)
+		if err != nil {
+			return nil, err
+		}
+		g.servicesDg = dg
+		g.DynamicWrappers = append(g.DynamicWrappers, dg)
+	}
+	if c.CompareSecrets {
+		dg, err := c.secretsConfig().NewDataGatherer(ctx)
+		if err != nil {
+			return nil, err
+		}
+		g.secretsDg = dg
+		g.DynamicWrappers = append(g.DynamicWrappers, dg)
+	}
+
+	return g, nil
+}
+
+// DataGathererTLSProbe performs TLS handshakes against endpoints, configured
+// or derived from the resources of the cluster, and reports the chain
+// actually served, the negotiated parameters and the SNI behaviour, so that
+// drifts from the certificate of the Secret can be detected.
+type DataGathererTLSProbe struct {
+	// DynamicWrappers are the data gatherers the endpoints and Secrets are
+	// read from.
+	DynamicWrappers
+
+	ctx         context.Context
+	endpoints   []TLSProbeEndpoint
+	roots       *x509.CertPool
+	timeout     time.Duration
+	concurrency int
+
+	// ingressDg, servicesDg and secretsDg are nil unless enabled.
+	ingressDg  datagatherer.DataGatherer
+	servicesDg datagatherer.DataGatherer
+	secretsDg  datagatherer.DataGatherer
+}
+
+// TLSProbe is the result of the probe of an endpoint.
+type TLSProbe struct {
+	Address    string `json:"address"`
+	ServerName string `json:"serverName,omitempty"`
+	// Kind, Namespace and Name identify the resource the endpoint is
+	// derived from, if any.
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// SecretNamespace and SecretName reference the Secret the certificate
+	// served is expected to come from, if any.
+	SecretNamespace string `json:"secretNamespace,omitempty"`
+	SecretName      string `json:"secretName,omitempty"`
+	// Version, CipherSuite and NegotiatedProtocol are negotiated by the
+	// handshake, the protocol with ALPN.
+	Version            string `json:"version,omitempty"`
+	CipherSuite        string `json:"cipherSuite,omitempty"`
+	NegotiatedProtocol string `json:"negotiatedProtocol,omitempty"`
+	// Certificates are the chain served, the leaf first.
+	Certificates []*certinfo.Certificate `json:"certificates,omitempty"`
+	// HostnameMatches is whether the leaf is valid for the server name, if
+	// any.
+	HostnameMatches *bool `json:"hostnameMatches,omitempty"`
+	// ChainErrors are the issues of the chain served.
+	ChainErrors []certinfo.ChainError `json:"chainErrors,omitempty"`
+	// WithoutSNI is the result of a handshake without SNI, which is only
+	// performed when the server name is a hostname.
+	WithoutSNI *TLSProbeWithoutSNI `json:"withoutSNI,omitempty"`
+	// MatchesSecret is whether the leaf served is the leaf of the Secret,
+	// if the Secrets are compared.
+	MatchesSecret *bool `json:"matchesSecret,omitempty"`
+	// SecretError is set if the Secret could not be compared, e.g. as it
+	// does not exist.
+	SecretError string `json:"secretError,omitempty"`
+	// Error is set if the handshake failed.
+	Error string `json:"error,omitempty"`
+}
+
+// TLSProbeWithoutSNI is the result of a handshake without SNI, as made by
+// old clients.
+type TLSProbeWithoutSNI struct {
+	// FingerprintSHA256 is the fingerprint of the leaf served.
+	FingerprintSHA256 string `json:"fingerprintSHA256,omitempty"`
+	// SameCertificate is whether the leaf is the one served with SNI.
+	SameCertificate bool `json:"sameCertificate"`
+	// Error is set if the handshake failed, e.g. as the server requires SNI.
+	Error string `json:"error,omitempty"`
+}
+
+// Fetch probes the configured endpoints and the ones derived from the
+// resources currently in the cache. The handshakes failing are reported
+// with their error, they do not fail the fetch.
+func (g *DataGathererTLSProbe) Fetch() (interface{}, error) {
+	probes, err := g.targets()
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets map[string]*TLSSecret
+	if g.secretsDg != nil {
+		if secrets, err = g.fetchSecrets(); err != nil {
+			return nil, err
+		}
+	}
+
+	slots := make(chan struct{}, g.concurrency)
+	var wg sync.WaitGroup
+	for _, probe := range probes {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(probe *TLSProbe) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			g.probe(probe)
+			if secrets != nil && probe.SecretName != "" {
+				compareSecret(probe, secrets[probe.SecretNamespace+"/"+probe.SecretName])
+			}
+		}(probe)
+	}
+	wg.Wait()
+
+	return map[string]interface{}{
+		"endpoints": probes,
+	}, nil
+}
+
+// targets returns the probes of the endpoints to probe, sorted by address and
+// server name. An endpoint derived from several resources is only probed
+// once, for the first resource.
+func (g *DataGathererTLSProbe) targets() ([]*TLSProbe, error) {
+	var probes []*TLSProbe
+	for _, endpoint := range g.endpoints {
+		probe := &TLSProbe{
+			Address:         endpoint.Address,
+			ServerName:      endpoint.ServerName,
+			SecretNamespace: endpoint.SecretNamespace,
+			SecretName:      endpoint.SecretName,
+		}
+		if probe.ServerName == "" {
+			probe.ServerName, _, _ = net.SplitHostPort(endpoint.Address)
+		}
+		probes = append(probes, probe)
+	}
+
+	if g.ingressDg != nil {
+		data, err := g.ingressDg.Fetch()
+		if err != nil {
+			return nil, err
+		}
+		hosts, _ := data.(map[string]interface{})["hosts"].([]*ExposedHost)
+		probes = append(probes, ingressProbes(hosts)...)
+	}
+
+	if g.servicesDg != nil {
+		data, err := g.servicesDg.Fetch()
+		if err != nil {
+			return nil, err
+		}
+		err = VisitGatheredResources(data, func(item *api.GatheredResource) error {
+			if !item.DeletedAt.IsZero() {
+				return nil
+			}
+			resource, ok := item.Resource.(*unstructured.Unstructured)
+			if !ok {
+				return fmt.Errorf("failed to parse cached resource")
+			}
+			probes = append(probes, serviceProbes(resource)...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(probes, func(i, j int) bool {
+		if probes[i].Address != probes[j].Address {
+			return probes[i].Address < probes[j].Address
+		}
+		return probes[i].ServerName < probes[j].ServerName
+	})
+
+	unique := []*TLSProbe{}
+	for i, probe := range probes {
+		if i > 0 && probe.Address == probes[i-1].Address && probe.ServerName == probes[i-1].ServerName {
+			continue
+		}
+		unique = append(unique, probe)
+	}
+	return unique, nil
+}
+
+// ingressProbes returns the probes of the hostnames served over TLS, on port
+// 443. Wildcard hostnames cannot be probed and are skipped.
+func ingressProbes(hosts []*ExposedHost) []*TLSProbe {
+	var probes []*TLSProbe
+	for _, host := range hosts {
+		if !host.TLS || host.Hostname == "" || strings.Contains(host.Hostname, "*") {
+			continue
+		}
+		probes = append(probes, &TLSProbe{
+			Address:         net.JoinHostPort(host.Hostname, "443"),
+			ServerName:      host.Hostname,
+			Kind:            host.Kind,
+			Namespace:       host.Namespace,
+			Name:            host.Name,
+			SecretNamespace: host.SecretNamespace,
+			SecretName:      host.SecretName,
+		})
+	}
+	return probes
+}
+
+// serviceProbes returns the probes of the HTTPS ports of the Service, which
+// are the ports with the https or tls application protocol or name prefix,
+// and port 443. Headless and ExternalName Services are skipped, as they have
+// no cluster IP to probe.
+func serviceProbes(service *unstructured.Unstructured) []*TLSProbe {
+	serviceType, _, _ := unstructured.NestedString(service.Object, "spec", "type")
+	clusterIP, _, _ := unstructured.NestedString(service.Object, "spec", "clusterIP")
+	if serviceType == "ExternalName" || clusterIP == "None" {
+		return nil
+	}
+
+	var probes []*TLSProbe
+	hostname := service.GetName() + "." + service.GetNamespace() + ".svc"
+	ports, _, _ := unstructured.NestedSlice(service.Object, "spec", "ports")
+	for _, p := range ports {
+		port, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		number, _, _ := unstructured.NestedInt64(port, "port")
+		name, _, _ := unstructured.NestedString(port, "name")
+		appProtocol, _, _ := unstructured.NestedString(port, "appProtocol")
+		if number == 0 || !isTLSPort(number, name, appProtocol) {
+			continue
+		}
+		probes = append(probes, &TLSProbe{
+			Address:    net.JoinHostPort(hostname, strconv.FormatInt(number, 10)),
+			ServerName: hostname,
+			Kind:       service.GetKind(),
+			Namespace:  service.GetNamespace(),
+			Name:       service.GetName(),
+		})
+	}
+	return probes
+}
+
+func isTLSPort(number int64, name, appProtocol string) bool {
+	for _, protocol := range []string{appProtocol, name} {
+		protocol = strings.ToLower(protocol)
+		if strings.HasPrefix(protocol, "https") || strings.HasPrefix(protocol, "tls") {
+			return true
+		}
+	}
+	return number == 443
+}
+
+// fetchSecrets returns the TLS Secrets currently in the cache, by namespace
+// and name.
+func (g *DataGathererTLSProbe) fetchSecrets() (map[string]*TLSSecret, error) {
+	data, err := g.secretsDg.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	secrets := map[string]*TLSSecret{}
+	list, _ := data.(map[string]interface{})["secrets"].([]*TLSSecret)
+	for _, secret := range list {
+		secrets[secret.Namespace+"/"+secret.Name] = secret
+	}
+	return secrets, nil
+}
+
+// probe performs the handshakes with the endpoint and records their results.
+func (g *DataGathererTLSProbe) probe(probe *TLSProbe) {
+	state, err := g.handshake(probe.Address, probe.ServerName)
+	if err != nil {
+		probe.Error = err.Error()
+		return
+	}
+
+	probe.Version = tlsVersions[state.Version]
+	if probe.Version == "" {
+		probe.Version = fmt.Sprintf("0x%04x", state.Version)
+	}
+	probe.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	probe.NegotiatedProtocol = state.NegotiatedProtocol
+	probe.Certificates = describe(state.PeerCertificates)
+	if len(state.PeerCertificates) == 0 {
+		return
+	}
+	leaf := state.PeerCertificates[0]
+
+	if probe.ServerName != "" {
+		matches := leaf.VerifyHostname(probe.ServerName) == nil
+		probe.HostnameMatches = &matches
+	}
+	probe.ChainErrors = certinfo.ValidateChain(state.PeerCertificates, g.roots, clock.now())
+
+	// clients never send an IP address with SNI
+	if probe.ServerName == "" || net.ParseIP(probe.ServerName) != nil {
+		return
+	}
+	probe.WithoutSNI = &TLSProbeWithoutSNI{}
+	state, err = g.handshake(probe.Address, "")
+	if err != nil {
+		probe.WithoutSNI.Error = err.Error()
+		return
+	}
+	if len(state.PeerCertificates) > 0 {
+		probe.WithoutSNI.FingerprintSHA256 = certinfo.Describe(state.PeerCertificates[0]).FingerprintSHA256
+		probe.WithoutSNI.SameCertificate = state.PeerCertificates[0].Equal(leaf)
+	}
+}
+
+// handshake performs a TLS handshake with the address, sending the server
+// name with SNI unless empty, and returns the state of the connection.
+func (g *DataGathererTLSProbe) handshake(address, serverName string) (tls.ConnectionState, error) {
+	ctx, cancel := context.WithTimeout(g.ctx, g.timeout)
+	defer cancel()
+
+	dialer := &tls.Dialer{
+		Config: &tls.Config{
+			ServerName: serverName,
+			// the chain served is validated after the handshake, so that
+			// an invalid chain is reported rather than failing the probe
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2", "http/1.1"},
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	return conn.(*tls.Conn).ConnectionState(), nil
+}
+
+// compareSecret records whether the leaf served is the leaf of the Secret.
+func compareSecret(probe *TLSProbe, secret *TLSSecret) {
+	switch {
+	case secret == nil:
+		probe.SecretError = "the Secret does not exist or is not a TLS Secret"
+	case secret.Error != "":
+		probe.SecretError = secret.Error
+	case len(secret.Certificates) == 0:
+		probe.SecretError = "the Secret has no certificate"
+	case len(probe.Certificates) == 0:
+		// the handshake failed
+	default:
+		matches := probe.Certificates[0].FingerprintSHA256 == secret.Certificates[0].FingerprintSHA256
+		probe.MatchesSecret = &matches
+	}
+}
@@ -0,0 +1,164 @@
+package k8s
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+)
+
+func TestConfigTLSProbeValidate(t *testing.T) {
+	tests := map[string]struct {
+		config  ConfigTLSProbe
+		wantErr bool
+	}{
+		"endpoints":            {config: ConfigTLSProbe{Endpoints: []TLSProbeEndpoint{{Address: "example.com:443"}}}},
+		"from ingresses":       {config: ConfigTLSProbe{FromIngresses: true}},
+		"nothing to probe":     {config: ConfigTLSProbe{CompareSecrets: true}, wantErr: true},
+		"missing port":         {config: ConfigTLSProbe{Endpoints: []TLSProbeEndpoint{{Address: "example.com"}}}, wantErr: true},
+		"partial secret":       {config: ConfigTLSProbe{Endpoints: []TLSProbeEndpoint{{Address: "example.com:443", SecretName: "web-tls"}}}, wantErr: true},
+		"negative timeout":     {config: ConfigTLSProbe{FromServices: true, Timeout: -1}, wantErr: true},
+		"negative concurrency": {config: ConfigTLSProbe{FromServices: true, Concurrency: -1}, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.config.validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDataGathererTLSProbeFetch(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	address := server.Listener.Addr().String()
+
+	served := certinfo.Describe(server.Certificate())
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	dg := &DataGathererTLSProbe{
+		ctx: context.Background(),
+		endpoints: []TLSProbeEndpoint{
+			{Address: address, ServerName: "example.com", SecretNamespace: "default", SecretName: "web-tls"},
+			{Address: address, ServerName: "other.test", SecretNamespace: "default", SecretName: "other-tls"},
+			{Address: "127.0.0.1:1"},
+		},
+		roots:       roots,
+		timeout:     defaultTLSProbeTimeout,
+		concurrency: 2,
+		secretsDg: &fakeDataGatherer{data: map[string]interface{}{
+			"secrets": []*TLSSecret{
+				{Namespace: "default", Name: "web-tls", Certificates: []*certinfo.Certificate{served}},
+				{Namespace: "default", Name: "other-tls", Certificates: []*certinfo.Certificate{{FingerprintSHA256: "other"}}},
+			},
+		}},
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	probes := data.(map[string]interface{})["endpoints"].([]*TLSProbe)
+	if len(probes) != 3 {
+		t.Fatalf("expected 3 probes, got %d", len(probes))
+	}
+
+	refused := probes[0]
+	if refused.Address != "127.0.0.1:1" || refused.ServerName != "127.0.0.1" || refused.Error == "" || refused.WithoutSNI != nil {
+		t.Errorf("expected the handshake with a closed port to fail, got %+v", refused)
+	}
+
+	probe := probes[1]
+	if probe.ServerName != "example.com" || probe.Error != "" {
+		t.Fatalf("unexpected probe: %+v", probe)
+	}
+	if probe.Version == "" || probe.CipherSuite == "" || probe.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("unexpected negotiated parameters: %+v", probe)
+	}
+	if len(probe.Certificates) != 1 || probe.Certificates[0].FingerprintSHA256 != served.FingerprintSHA256 {
+		t.Errorf("unexpected chain served: %+v", probe.Certificates)
+	}
+	if probe.HostnameMatches == nil || !*probe.HostnameMatches || len(probe.ChainErrors) != 0 {
+		t.Errorf("expected the chain to be valid for the hostname, got %+v", probe)
+	}
+	if probe.WithoutSNI == nil || !probe.WithoutSNI.SameCertificate {
+		t.Errorf("expected the same certificate to be served without SNI, got %+v", probe.WithoutSNI)
+	}
+	if probe.MatchesSecret == nil || !*probe.MatchesSecret {
+		t.Errorf("expected the certificate served to match the Secret, got %+v", probe)
+	}
+
+	drifted := probes[2]
+	if drifted.HostnameMatches == nil || *drifted.HostnameMatches {
+		t.Errorf("expected the certificate not to match other.test, got %+v", drifted)
+	}
+	if drifted.MatchesSecret == nil || *drifted.MatchesSecret {
+		t.Errorf("expected the certificate served to drift from the Secret, got %+v", drifted)
+	}
+}
+
+func TestDataGathererTLSProbeTargets(t *testing.T) {
+	web := getObject("v1", "Service", "web", "default", false)
+	web.Object["spec"] = map[string]interface{}{
+		"clusterIP": "10.0.0.10",
+		"ports": []interface{}{
+			map[string]interface{}{"name": "http", "port": int64(80)},
+			map[string]interface{}{"name": "https", "port": int64(8443)},
+			map[string]interface{}{"port": int64(443)},
+			map[string]interface{}{"name": "grpc", "port": int64(9000), "appProtocol": "tls"},
+		},
+	}
+	headless := getObject("v1", "Service", "headless", "default", false)
+	headless.Object["spec"] = map[string]interface{}{
+		"clusterIP": "None",
+		"ports":     []interface{}{map[string]interface{}{"port": int64(443)}},
+	}
+
+	dg := &DataGathererTLSProbe{
+		ingressDg: &fakeDataGatherer{data: map[string]interface{}{
+			"hosts": []*ExposedHost{
+				{Hostname: "example.com", Kind: "Ingress", Namespace: "default", Name: "web", TLS: true, SecretNamespace: "default", SecretName: "web-tls"},
+				{Hostname: "example.com", Kind: "HTTPRoute", Namespace: "default", Name: "web", TLS: true},
+				{Hostname: "*.example.com", Kind: "Gateway", Namespace: "default", Name: "gateway", TLS: true},
+				{Hostname: "insecure.example.com", Kind: "Ingress", Namespace: "default", Name: "insecure"},
+			},
+		}},
+		servicesDg: &fakeDataGatherer{data: map[string]interface{}{
+			"items": []*api.GatheredResource{{Resource: web}, {Resource: headless}},
+		}},
+	}
+
+	probes, err := dg.targets()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{
+		"example.com:443",
+		"web.default.svc:443",
+		"web.default.svc:8443",
+		"web.default.svc:9000",
+	}
+	if len(probes) != len(want) {
+		t.Fatalf("expected %d probes, got %d", len(want), len(probes))
+	}
+	for i, address := range want {
+		if probes[i].Address != address {
+			t.Errorf("expected probe %d to be %s, got %s", i, address, probes[i].Address)
+		}
+	}
+	if ingress := probes[0]; ingress.Kind != "Ingress" || ingress.SecretName != "web-tls" || ingress.ServerName != "example.com" {
+		t.Errorf("expected the endpoint of the Ingress to reference its Secret, got %+v", ingress)
+	}
+	if service := probes[1]; service.Kind != "Service" || service.ServerName != "web.default.svc" {
+		t.Errorf("unexpected endpoint of the Service: %+v", service)
+	}
+}
@@ -0,0 +1,237 @@
+package k8s
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// tlsSecretType is the type of the Secrets parsed by the k8s-tls-secrets
+// data-gatherer.
+const tlsSecretType = "kubernetes.io/tls"
+
+var secretsGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// ConfigTLSSecrets contains the configuration for the k8s-tls-secrets
+// data-gatherer.
+type ConfigTLSSecrets struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// KubeConfigContext is the kubeconfig context to use. If empty, the
+	// current context is used.
+	KubeConfigContext string `yaml:"kubeconfig-context"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+	// ValidateChain, if set, validates the chain of tls.crt and reports its
+	// issues, such as an expired intermediate or a wrong order.
+	ValidateChain *ChainValidation `yaml:"validate-chain"`
+}
+
+// ChainValidation configures the validation of the chains of the Secrets.
+type ChainValidation struct {
+	// CABundlePath is a PEM file of the roots the chains are validated
+	// against. The roots of the system are used if empty.
+	CABundlePath string `yaml:"ca-bundle"`
+	// TrustSecretCA validates the chain of a Secret with a ca.crt against
+	// the certificates of its ca.crt instead, as for a private CA.
+	TrustSecretCA bool `yaml:"trust-secret-ca"`
+}
+
+// roots returns the roots of the CA bundle, or nil for the roots of the
+// system.
+func (c *ChainValidation) roots() (*x509.CertPool, error) {
+	if c.CABundlePath == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(c.CABundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA bundle: %v", err)
+	}
+	certs, err := certinfo.ParsePEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the CA bundle: %v", err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate in the CA bundle %q", c.CABundlePath)
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// DynamicConfig returns the configuration of the dynamic data gatherer used to
+// collect the Secrets.
+func (c *ConfigTLSSecrets) DynamicConfig() *ConfigDynamic {
+	return &ConfigDynamic{
+		KubeConfigPath:       c.KubeConfigPath,
+		KubeConfigContext:    c.KubeConfigContext,
+		GroupVersionResource: secretsGVR,
+		ExcludeNamespaces:    c.ExcludeNamespaces,
+		IncludeNamespaces:    c.IncludeNamespaces,
+	}
+}
+
+// NewDataGatherer constructs a new instance of the k8s-tls-secrets data-gatherer.
+func (c *ConfigTLSSecrets) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	var validation *chainValidation
+	if c.ValidateChain != nil {
+		roots, err := c.ValidateChain.roots()
+		if err != nil {
+			return nil, err
+		}
+		validation = &chainValidation{roots: roots, trustSecretCA: c.ValidateChain.TrustSecretCA}
+	}
+
+	dynamicDg, err := c.DynamicConfig().NewDataGatherer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGathererTLSSecrets{DynamicWrapper: DynamicWrapper{Dynamic: dynamicDg}, validation: validation}, nil
+}
+
+// DataGathererTLSSecrets parses the certificates stored in kubernetes.io/tls
+// Secrets and emits their metadata only, so that no certificate is uploaded.
+type DataGathererTLSSecrets struct {
+	DynamicWrapper
+	// validation is nil unless the chains are validated.
+	validation *chainValidation
+}
+
+// chainValidation holds the roots the chains are validated against.
+type chainValidation struct {
+	roots         *x509.CertPool
+	trustSecretCA bool
+}
+
+// TLSSecret is the metadata of the certificates stored in a Secret.
+type TLSSecret struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	UID       string `json:"uid"`
+	// Certificates are parsed from tls.crt, the leaf certificate first.
+	Certificates []*certinfo.Certificate `json:"certificates"`
+	// CACertificates are parsed from ca.crt, if present.
+	CACertificates []*certinfo.Certificate `json:"caCertificates,omitempty"`
+	// ChainValid is whether the chain of tls.crt is valid, if validated.
+	ChainValid *bool `json:"chainValid,omitempty"`
+	// ChainErrors are the issues of the chain of tls.crt, if validated.
+	ChainErrors []certinfo.ChainError `json:"chainErrors,omitempty"`
+	// Error is set if the Secret data could not be parsed.
+	Error string `json:"error,omitempty"`
+}
+
+// Fetch parses the TLS Secrets currently in the cache. Deleted Secrets and
+// Secrets of other types are ignored.
+func (g *DataGathererTLSSecrets) Fetch() (interface{}, error) {
+	data, err := g.Dynamic.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []*TLSSecret{}
+	err = VisitGatheredResources(data, func(item *api.GatheredResource) error {
+		if !item.DeletedAt.IsZero() {
+			return nil
+		}
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("failed to parse cached resource")
+		}
+		if secretType, _, _ := unstructured.NestedString(resource.Object, "type"); secretType != tlsSecretType {
+			return nil
+		}
+		secrets = append(secrets, parseTLSSecret(resource, g.validation))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Namespace+"/"+secrets[i].Name < secrets[j].Namespace+"/"+secrets[j].Name
+	})
+
+	return map[string]interface{}{
+		"secrets": secrets,
+	}, nil
+}
+
+func parseTLSSecret(resource *unstructured.Unstructured, validation *chainValidation) *TLSSecret {
+	secret := &TLSSecret{
+		Namespace:    resource.GetNamespace(),
+		Name:         resource.GetName(),
+		UID:          string(resource.GetUID()),
+		Certificates: []*certinfo.Certificate{},
+	}
+
+	certs, err := parseSecretKey(resource, "tls.crt")
+	if err != nil {
+		secret.Error = err.Error()
+		return secret
+	}
+	secret.Certificates = describe(certs)
+
+	caCerts, err := parseSecretKey(resource, "ca.crt")
+	if err != nil {
+		secret.Error = err.Error()
+		return secret
+	}
+	if caCerts != nil {
+		secret.CACertificates = describe(caCerts)
+	}
+
+	if validation != nil && len(certs) > 0 {
+		roots := validation.roots
+		if validation.trustSecretCA && len(caCerts) > 0 {
+			roots = x509.NewCertPool()
+			for _, cert := range caCerts {
+				roots.AddCert(cert)
+			}
+		}
+		secret.ChainErrors = certinfo.ValidateChain(certs, roots, clock.now())
+		valid := len(secret.ChainErrors) == 0
+		secret.ChainValid = &valid
+	}
+
+	return secret
+}
+
+func describe(certs []*x509.Certificate) []*certinfo.Certificate {
+	described := make([]*certinfo.Certificate, 0, len(certs))
+	for _, cert := range certs {
+		described = append(described, certinfo.Describe(cert))
+	}
+	return described
+}
+
+// parseSecretKey parses the certificates in the base64 encoded PEM data
+// stored at key in the Secret. A missing key is not an error.
+func parseSecretKey(resource *unstructured.Unstructured, key string) ([]*x509.Certificate, error) {
+	encoded, found, err := unstructured.NestedString(resource.Object, "data", key)
+	if err != nil || !found || encoded == "" {
+		return nil, nil
+	}
+	pemData, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", key, err)
+	}
+	certs, err := certinfo.ParsePEM(pemData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", key, err)
+	}
+	return certs, nil
+}
@@ -0,0 +1,128 @@
+package k8s
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/certinfo"
+)
+
+func getCertificatePEM(t *testing.T, commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestDataGathererTLSSecretsFetch(t *testing.T) {
+	encode := func(data []byte) string { return base64.StdEncoding.EncodeToString(data) }
+
+	dg := &DataGathererTLSSecrets{
+		DynamicWrapper: DynamicWrapper{Dynamic: &fakeDataGatherer{
+			data: map[string]interface{}{
+				"items": []*api.GatheredResource{
+					{Resource: getSecret("web-tls", "default", map[string]interface{}{
+						"tls.crt": encode(getCertificatePEM(t, "example.com")),
+						"ca.crt":  encode(getCertificatePEM(t, "Example CA")),
+					}, true, false)},
+					{Resource: getSecret("broken-tls", "default", map[string]interface{}{
+						"tls.crt": "not base64!",
+					}, true, false)},
+					{Resource: getSecret("opaque", "default", map[string]interface{}{
+						"password": encode([]byte("hunter2")),
+					}, false, false)},
+				},
+			},
+		}},
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secrets := data.(map[string]interface{})["secrets"].([]*TLSSecret)
+	if len(secrets) != 2 {
+		t.Fatalf("expected the two TLS secrets, got %d", len(secrets))
+	}
+
+	broken, web := secrets[0], secrets[1]
+	if broken.Name != "broken-tls" || broken.Error == "" {
+		t.Errorf("expected an error for the broken secret, got %+v", broken)
+	}
+	if web.Name != "web-tls" || web.Error != "" {
+		t.Fatalf("unexpected result for the web secret: %+v", web)
+	}
+	if len(web.Certificates) != 1 || web.Certificates[0].Subject != "CN=example.com" {
+		t.Errorf("unexpected certificates: %+v", web.Certificates)
+	}
+	if len(web.CACertificates) != 1 || web.CACertificates[0].Subject != "CN=Example CA" {
+		t.Errorf("unexpected CA certificates: %+v", web.CACertificates)
+	}
+}
+
+func TestDataGathererTLSSecretsValidateChain(t *testing.T) {
+	// the certificates are valid from now on
+	defer func(c timeInterface) { clock = c }(clock)
+	clock = &realTime{}
+
+	encode := func(data []byte) string { return base64.StdEncoding.EncodeToString(data) }
+	selfSigned := getCertificatePEM(t, "example.com")
+
+	dg := &DataGathererTLSSecrets{
+		DynamicWrapper: DynamicWrapper{Dynamic: &fakeDataGatherer{
+			data: map[string]interface{}{
+				"items": []*api.GatheredResource{
+					{Resource: getSecret("private-ca", "default", map[string]interface{}{
+						"tls.crt": encode(selfSigned),
+						"ca.crt":  encode(selfSigned),
+					}, true, false)},
+					{Resource: getSecret("unknown-ca", "default", map[string]interface{}{
+						"tls.crt": encode(getCertificatePEM(t, "example.org")),
+					}, true, false)},
+				},
+			},
+		}},
+		validation: &chainValidation{roots: x509.NewCertPool(), trustSecretCA: true},
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secrets := data.(map[string]interface{})["secrets"].([]*TLSSecret)
+	if len(secrets) != 2 {
+		t.Fatalf("expected the two TLS secrets, got %d", len(secrets))
+	}
+
+	privateCA, unknownCA := secrets[0], secrets[1]
+	if privateCA.ChainValid == nil || !*privateCA.ChainValid {
+		t.Errorf("expected the chain trusted by ca.crt to be valid, got %+v", privateCA.ChainErrors)
+	}
+	if unknownCA.ChainValid == nil || *unknownCA.ChainValid {
+		t.Fatalf("expected the chain of an unknown CA to be invalid")
+	}
+	if len(unknownCA.ChainErrors) != 1 || unknownCA.ChainErrors[0].Reason != certinfo.ChainUnknownAuthority {
+		t.Errorf("unexpected chain errors: %+v", unknownCA.ChainErrors)
+	}
+}
@@ -0,0 +1,228 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/jetstack/preflight/pkg/logs"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Transform is an operation of the pipeline applied to every resource before
+// it enters the cache. The operations are applied in the order they are
+// configured, each one sees the result of the previous ones. A transform has
+// exactly one operation: Drop, Remove, Rename, Copy or Set. Fields are
+// referenced with the expressions supported by projectionFields, e.g.
+// .spec.dnsNames or /metadata/labels/app.kubernetes.io~1name.
+type Transform struct {
+	// When, if set, is the condition a resource must match for the operation
+	// to be applied. Otherwise the operation is applied to all the
+	// resources.
+	When *TransformCondition `yaml:"when"`
+	// Drop leaves the matching resources out of the cache.
+	Drop bool `yaml:"drop"`
+	// Remove is a field removed from the resource.
+	Remove string `yaml:"remove"`
+	// Rename moves the value of a field to another field.
+	Rename *TransformMove `yaml:"rename"`
+	// Copy copies the value of a field to another field, e.g. to derive a
+	// field from a label.
+	Copy *TransformMove `yaml:"copy"`
+	// Set sets a field to a literal string value.
+	Set *TransformSet `yaml:"set"`
+}
+
+// TransformMove is the source and destination of a renamed or copied field.
+type TransformMove struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// TransformSet is a field set to a literal string value.
+type TransformSet struct {
+	Field string `yaml:"field"`
+	Value string `yaml:"value"`
+}
+
+// TransformCondition matches resources on the value of a field.
+type TransformCondition struct {
+	// Field is the field the condition applies to.
+	Field string `yaml:"field"`
+	// Equals, if set, is the value the field must have once formatted as a
+	// string. Otherwise the field only needs to exist.
+	Equals *string `yaml:"equals"`
+	// Not negates the condition.
+	Not bool `yaml:"not"`
+}
+
+// protectedTransformFields identify resources in the cache and cannot be
+// modified by transforms.
+var protectedTransformFields = []string{"metadata.name", "metadata.namespace", "metadata.uid"}
+
+// transformPath converts a field expression into the keys leading to the
+// field.
+func transformPath(expression string) ([]string, error) {
+	if strings.HasPrefix(expression, "/") {
+		path, err := gabs.JSONPointerToSlice(expression)
+		if err != nil || len(path) == 0 {
+			return nil, fmt.Errorf("invalid configuration: invalid transform field %q", expression)
+		}
+		return path, nil
+	}
+
+	field := strings.TrimPrefix(strings.TrimPrefix(expression, "$"), ".")
+	if field == "" || strings.ContainsAny(field, "[]*?@(){}") || strings.Contains(field, "..") {
+		return nil, fmt.Errorf("invalid configuration: unsupported transform field %q, only dot separated paths and JSON pointers are supported", expression)
+	}
+	return strings.Split(field, "."), nil
+}
+
+// transformTarget converts the expression of a field modified by a transform,
+// refusing the fields identifying resources.
+func transformTarget(expression string) ([]string, error) {
+	path, err := transformPath(expression)
+	if err != nil {
+		return nil, err
+	}
+	joined := strings.Join(path, ".")
+	for _, protected := range protectedTransformFields {
+		if joined == protected || joined == "metadata" || strings.HasPrefix(protected, joined+".") {
+			return nil, fmt.Errorf("invalid configuration: transform cannot modify %q", expression)
+		}
+	}
+	return path, nil
+}
+
+func (t *Transform) validate() error {
+	if t.When != nil {
+		if _, err := transformPath(t.When.Field); err != nil {
+			return err
+		}
+	}
+
+	operations := 0
+	if t.Drop {
+		operations++
+	}
+	if t.Remove != "" {
+		operations++
+		if _, err := transformTarget(t.Remove); err != nil {
+			return err
+		}
+	}
+	if t.Rename != nil {
+		operations++
+		if _, err := transformTarget(t.Rename.From); err != nil {
+			return err
+		}
+		if _, err := transformTarget(t.Rename.To); err != nil {
+			return err
+		}
+	}
+	if t.Copy != nil {
+		operations++
+		if _, err := transformPath(t.Copy.From); err != nil {
+			return err
+		}
+		if _, err := transformTarget(t.Copy.To); err != nil {
+			return err
+		}
+	}
+	if t.Set != nil {
+		operations++
+		if _, err := transformTarget(t.Set.Field); err != nil {
+			return err
+		}
+	}
+	if operations != 1 {
+		return fmt.Errorf("invalid configuration: a transform must have exactly one of drop, remove, rename, copy or set, got %d", operations)
+	}
+	return nil
+}
+
+// matches returns true if the operation applies to the resource.
+func (t *Transform) matches(resource *unstructured.Unstructured) bool {
+	if t.When == nil {
+		return true
+	}
+	path, _ := transformPath(t.When.Field)
+	value, found, _ := unstructured.NestedFieldNoCopy(resource.Object, path...)
+	matched := found
+	if found && t.When.Equals != nil {
+		matched = fmt.Sprint(value) == *t.When.Equals
+	}
+	return matched != t.When.Not
+}
+
+// Apply applies the operation to the resource. It returns false if the
+// resource is dropped.
+func (t *Transform) Apply(resource *unstructured.Unstructured) (bool, error) {
+	if !t.matches(resource) {
+		return true, nil
+	}
+
+	switch {
+	case t.Drop:
+		return false, nil
+	case t.Remove != "":
+		path, _ := transformPath(t.Remove)
+		unstructured.RemoveNestedField(resource.Object, path...)
+	case t.Rename != nil:
+		if err := copyField(resource, t.Rename.From, t.Rename.To); err != nil {
+			return false, err
+		}
+		path, _ := transformPath(t.Rename.From)
+		unstructured.RemoveNestedField(resource.Object, path...)
+	case t.Copy != nil:
+		if err := copyField(resource, t.Copy.From, t.Copy.To); err != nil {
+			return false, err
+		}
+	case t.Set != nil:
+		path, _ := transformPath(t.Set.Field)
+		if err := unstructured.SetNestedField(resource.Object, t.Set.Value, path...); err != nil {
+			return false, fmt.Errorf("failed to set %q: %s", t.Set.Field, err)
+		}
+	}
+	return true, nil
+}
+
+// copyField copies the value of a field of the resource to another field, a
+// missing field is not copied.
+func copyField(resource *unstructured.Unstructured, from, to string) error {
+	fromPath, _ := transformPath(from)
+	value, found, err := unstructured.NestedFieldCopy(resource.Object, fromPath...)
+	if err != nil || !found {
+		return nil
+	}
+	toPath, _ := transformPath(to)
+	if err := unstructured.SetNestedField(resource.Object, value, toPath...); err != nil {
+		return fmt.Errorf("failed to copy %q to %q: %s", from, to, err)
+	}
+	return nil
+}
+
+// transform applies the configured transforms to an object from the
+// informers. It returns a transformed copy of the object, or false if the
+// object is dropped. Objects failing to be transformed are dropped, so
+// that fields meant to be removed are never gathered.
+func (g *DataGathererDynamic) transform(obj interface{}) (interface{}, bool) {
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok || len(g.transforms) == 0 {
+		return obj, true
+	}
+
+	// the informers share their objects, they must not be modified
+	resource = resource.DeepCopy()
+	for i := range g.transforms {
+		keep, err := g.transforms[i].Apply(resource)
+		if err != nil {
+			logs.Log.Errorf("failed to transform resource %s/%s, dropping it: %v", resource.GetNamespace(), resource.GetName(), err)
+			return nil, false
+		}
+		if !keep {
+			return nil, false
+		}
+	}
+	return resource, true
+}
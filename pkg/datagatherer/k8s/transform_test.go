@@ -0,0 +1,125 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestTransform(t *testing.T) {
+	prod := "prod"
+	newResource := func(env string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":      "example",
+					"namespace": "default",
+					"uid":       "uid1",
+					"labels": map[string]interface{}{
+						"env":                    env,
+						"app.kubernetes.io/name": "example",
+					},
+				},
+				"data": map[string]interface{}{
+					"config": "value",
+					"token":  "secret",
+				},
+			},
+		}
+	}
+
+	g := &DataGathererDynamic{
+		transforms: []Transform{
+			{When: &TransformCondition{Field: ".metadata.labels.env", Equals: &prod, Not: true}, Drop: true},
+			{Remove: ".data.token"},
+			{Rename: &TransformMove{From: ".data.config", To: ".data.settings"}},
+			// the operations are applied in order, the renamed field is
+			// copied
+			{Copy: &TransformMove{From: ".data.settings", To: ".settings"}},
+			{Copy: &TransformMove{From: "/metadata/labels/app.kubernetes.io~1name", To: ".app"}},
+			{Set: &TransformSet{Field: ".environment", Value: "production"}},
+			{When: &TransformCondition{Field: ".environment"}, Set: &TransformSet{Field: ".app", Value: "overridden"}},
+		},
+	}
+
+	if _, keep := g.transform(newResource("dev")); keep {
+		t.Errorf("expected the resource to be dropped")
+	}
+
+	resource := newResource("prod")
+	transformed, keep := g.transform(resource)
+	if !keep {
+		t.Fatalf("expected the resource to be kept")
+	}
+
+	expected := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":      "example",
+			"namespace": "default",
+			"uid":       "uid1",
+			"labels": map[string]interface{}{
+				"env":                    "prod",
+				"app.kubernetes.io/name": "example",
+			},
+		},
+		"data": map[string]interface{}{
+			"settings": "value",
+		},
+		"settings":    "value",
+		"app":         "overridden",
+		"environment": "production",
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, transformed.(*unstructured.Unstructured).Object); !equal {
+		t.Errorf("unexpected result:\n%s", diff)
+	}
+
+	// the object from the informer is left untouched
+	if diff, equal := messagediff.PrettyDiff(newResource("prod").Object, resource.Object); !equal {
+		t.Errorf("unexpected change of the original resource:\n%s", diff)
+	}
+}
+
+func TestTransformValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		transform Transform
+		valid     bool
+	}{
+		"valid": {
+			transform: Transform{Set: &TransformSet{Field: "/metadata/labels/a~1b", Value: "c"}},
+			valid:     true,
+		},
+		"no operation": {
+			transform: Transform{When: &TransformCondition{Field: ".spec"}},
+		},
+		"several operations": {
+			transform: Transform{Remove: ".spec.template", Set: &TransformSet{Field: ".spec.x", Value: "y"}},
+		},
+		"wildcard": {
+			transform: Transform{Remove: ".spec.containers[*].env"},
+		},
+		"protected field": {
+			transform: Transform{Rename: &TransformMove{From: ".metadata.name", To: ".name"}},
+		},
+		"protected parent": {
+			transform: Transform{Remove: ".metadata"},
+		},
+		"invalid condition": {
+			transform: Transform{When: &TransformCondition{Field: ""}, Drop: true},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.transform.validate()
+			if tc.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
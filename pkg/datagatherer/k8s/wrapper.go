@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// DynamicWrapper is embedded by the data gatherers deriving their data from
+// the resources of a dynamic data gatherer, e.g. by summarizing them. It
+// delegates all the methods of the data gatherer but Fetch to the dynamic
+// data gatherer, so that the embedding data gatherer only implements Fetch.
+type DynamicWrapper struct {
+	// Dynamic is the data gatherer the resources are read from.
+	Dynamic datagatherer.DataGatherer
+}
+
+// Run starts the dynamic data gatherer's informers for resource collection.
+func (w *DynamicWrapper) Run(stopCh <-chan struct{}) error {
+	return w.Dynamic.Run(stopCh)
+}
+
+// WaitForCacheSync waits for the dynamic data gatherer's informers cache to sync.
+func (w *DynamicWrapper) WaitForCacheSync(stopCh <-chan struct{}) error {
+	return w.Dynamic.WaitForCacheSync(stopCh)
+}
+
+// Delete clears the cache of the dynamic data gatherer.
+func (w *DynamicWrapper) Delete() error {
+	return w.Dynamic.Delete()
+}
+
+// Healthy reports whether the resources of the dynamic data gatherer may be
+// stale.
+func (w *DynamicWrapper) Healthy() error {
+	return w.Dynamic.Healthy()
+}
+
+// Issues reports the issues of the dynamic data gatherer.
+func (w *DynamicWrapper) Issues() []*api.DataReadingIssue {
+	return issues(w.Dynamic)
+}
+
+// DynamicWrappers is embedded by the data gatherers deriving their data from
+// the resources of several data gatherers. As DynamicWrapper, it delegates
+// all the methods of the data gatherer but Fetch, to each of the data
+// gatherers in turn.
+type DynamicWrappers []datagatherer.DataGatherer
+
+// Run starts the informers of the data gatherers, it stops at the first one
+// failing.
+func (w DynamicWrappers) Run(stopCh <-chan struct{}) error {
+	for _, dg := range w {
+		if err := dg.Run(stopCh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitForCacheSync waits for the informers cache of the data gatherers to
+// sync.
+func (w DynamicWrappers) WaitForCacheSync(stopCh <-chan struct{}) error {
+	for _, dg := range w {
+		if err := dg.WaitForCacheSync(stopCh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete clears the caches of the data gatherers.
+func (w DynamicWrappers) Delete() error {
+	for _, dg := range w {
+		if err := dg.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Healthy reports whether the resources of any of the data gatherers may be
+// stale.
+func (w DynamicWrappers) Healthy() error {
+	for _, dg := range w {
+		if err := dg.Healthy(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Issues reports the issues of all the data gatherers.
+func (w DynamicWrappers) Issues() []*api.DataReadingIssue {
+	var all []*api.DataReadingIssue
+	for _, dg := range w {
+		all = append(all, issues(dg)...)
+	}
+	return all
+}
@@ -0,0 +1,57 @@
+package k8s
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+)
+
+type fakeIssueReporter struct {
+	fakeDataGatherer
+	healthy error
+	issues  []*api.DataReadingIssue
+}
+
+func (g *fakeIssueReporter) Healthy() error                  { return g.healthy }
+func (g *fakeIssueReporter) Issues() []*api.DataReadingIssue { return g.issues }
+
+func TestDynamicWrappers(t *testing.T) {
+	webhooks := &api.DataReadingIssue{Severity: api.IssueSeverityWarning, Code: "forbidden", ResourceType: "validatingwebhookconfigurations.v1.admissionregistration.k8s.io"}
+	policies := &api.DataReadingIssue{Severity: api.IssueSeverityWarning, Code: "forbidden", ResourceType: "validatingadmissionpolicies.v1.admissionregistration.k8s.io"}
+
+	tests := map[string]struct {
+		wrappers       DynamicWrappers
+		expectedErr    string
+		expectedIssues []*api.DataReadingIssue
+	}{
+		"empty": {},
+		"healthy": {
+			wrappers:       DynamicWrappers{&fakeDataGatherer{}, &fakeIssueReporter{issues: []*api.DataReadingIssue{webhooks}}},
+			expectedIssues: []*api.DataReadingIssue{webhooks},
+		},
+		"unhealthy": {
+			wrappers: DynamicWrappers{
+				&fakeIssueReporter{issues: []*api.DataReadingIssue{webhooks}},
+				&fakeIssueReporter{healthy: fmt.Errorf("stale"), issues: []*api.DataReadingIssue{policies}},
+			},
+			expectedErr:    "stale",
+			expectedIssues: []*api.DataReadingIssue{webhooks, policies},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.wrappers.Healthy()
+			if test.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr) {
+				t.Fatalf("expected error %q, got %v", test.expectedErr, err)
+			}
+			if diff, equal := messagediff.PrettyDiff(test.expectedIssues, test.wrappers.Issues()); !equal {
+				t.Errorf("unexpected issues:\n%s", diff)
+			}
+		})
+	}
+}
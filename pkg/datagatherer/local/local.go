@@ -4,27 +4,74 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/jetstack/preflight/api"
 	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/logs"
+	"sigs.k8s.io/yaml"
 )
 
 // Config is the configuration for a local DataGatherer.
 type Config struct {
 	// DataPath is the path to file containing the data to load.
 	DataPath string `yaml:"data-path"`
+	// Paths are glob patterns or directories of the files to gather. Each
+	// file is parsed as JSON or YAML and gathered as a resource identified
+	// by its path.
+	Paths []string `yaml:"paths"`
+	// Watch makes the data gatherer watch the files of Paths for changes,
+	// rather than reading them on every Fetch.
+	Watch bool `yaml:"watch"`
 }
 
 // validate validates the configuration.
 func (c *Config) validate() error {
-	if c.DataPath == "" {
+	if c.DataPath == "" && len(c.Paths) == 0 {
 		return fmt.Errorf("invalid configuration: DataPath cannot be empty")
 	}
+	if c.DataPath != "" && len(c.Paths) > 0 {
+		return fmt.Errorf("invalid configuration: DataPath and Paths cannot be used at the same time")
+	}
+	if c.Watch && len(c.Paths) == 0 {
+		return fmt.Errorf("invalid configuration: Watch requires Paths")
+	}
+	for _, path := range c.Paths {
+		if _, err := filepath.Match(path, ""); err != nil {
+			return fmt.Errorf("invalid configuration: invalid path %q: %v", path, err)
+		}
+		if c.Watch && hasMeta(filepath.Dir(path)) {
+			return fmt.Errorf("invalid configuration: cannot watch %q, only the file names of watched paths can be patterns", path)
+		}
+	}
 	return nil
 }
 
-// DataGatherer is a data-gatherer that loads data from a local file.
+// hasMeta returns true if the path contains glob patterns.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// DataGatherer is a data-gatherer that loads data from local files.
 type DataGatherer struct {
 	dataPath string
+	paths    []string
+	watch    bool
+
+	// mu protects files, failures and synced
+	mu sync.Mutex
+	// files are the gathered files by path
+	files map[string]*api.GatheredResource
+	// failures are the errors of the files which could not be read or
+	// parsed by the last load, by path
+	failures map[string]error
+	synced   bool
 }
 
 // NewDataGatherer returns a new DataGatherer.
@@ -35,11 +82,58 @@ func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer
 
 	return &DataGatherer{
 		dataPath: c.DataPath,
+		paths:    c.Paths,
+		watch:    c.Watch,
+		files:    map[string]*api.GatheredResource{},
+		failures: map[string]error{},
 	}, nil
 }
 
+// Run watches the directories of the paths when watching is enabled, and
+// reloads the files on every change until stopCh is closed.
 func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
-	// no async functionality, see Fetch
+	if !g.watch {
+		// no async functionality, see Fetch
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %v", err)
+	}
+	for _, dir := range g.watchedDirs() {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("failed to watch %q: %v", dir, err)
+		}
+	}
+
+	g.load()
+	g.mu.Lock()
+	g.synced = true
+	g.mu.Unlock()
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// files can be replaced through symlinks, as in mounted
+				// ConfigMaps, so all the files are loaded again
+				g.load()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logs.Log.Errorf("error watching local files: %v", err)
+			}
+		}
+	}()
 	return nil
 }
 
@@ -48,16 +142,203 @@ func (g *DataGatherer) Delete() error {
 	return nil
 }
 
+// Healthy returns an error if some files could not be read or parsed, in
+// which case their last good copy is returned by Fetch.
+func (g *DataGatherer) Healthy() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.failures) == 0 {
+		return nil
+	}
+	problems := []string{}
+	for path, err := range g.failures {
+		problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("failed to load %d file(s): %s", len(problems), strings.Join(problems, ", "))
+}
+
+// Issues details the files which could not be read or parsed.
+func (g *DataGatherer) Issues() []*api.DataReadingIssue {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var issues []*api.DataReadingIssue
+	for path, err := range g.failures {
+		issues = append(issues, &api.DataReadingIssue{
+			Severity: api.IssueSeverityWarning,
+			Code:     api.IssueFailed,
+			Message:  fmt.Sprintf("%s: %v", path, err),
+		})
+	}
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].Message < issues[j].Message
+	})
+	return issues
+}
+
+// WaitForCacheSync waits for the files to be loaded when watching is
+// enabled.
 func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
-	// no async functionality, see Fetch
-	return nil
+	if !g.watch {
+		// no async functionality, see Fetch
+		return nil
+	}
+	for {
+		g.mu.Lock()
+		synced := g.synced
+		g.mu.Unlock()
+		if synced {
+			return nil
+		}
+		select {
+		case <-stopCh:
+			return fmt.Errorf("timed out waiting for local files to load")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
 }
 
-// Fetch loads and returns the data from the LocalDatagatherer's dataPath
+// Fetch loads and returns the data from the LocalDatagatherer's dataPath.
+// With paths, it returns the gathered files as items. Files deleted since
+// the previous Fetch are returned once with DeletedAt set.
 func (g *DataGatherer) Fetch() (interface{}, error) {
-	dataBytes, err := ioutil.ReadFile(g.dataPath)
-	if err != nil {
-		return nil, err
+	if g.dataPath != "" {
+		dataBytes, err := ioutil.ReadFile(g.dataPath)
+		if err != nil {
+			return nil, err
+		}
+		return dataBytes, nil
+	}
+
+	if !g.watch {
+		g.load()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	items := []*api.GatheredResource{}
+	for path, item := range g.files {
+		items = append(items, item)
+		if !item.DeletedAt.IsZero() {
+			delete(g.files, path)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return itemPath(items[i]) < itemPath(items[j])
+	})
+	return map[string]interface{}{
+		"items": items,
+	}, nil
+}
+
+func itemPath(item *api.GatheredResource) string {
+	return item.Resource.(map[string]interface{})["path"].(string)
+}
+
+// watchedDirs returns the directories containing the files of the paths.
+func (g *DataGatherer) watchedDirs() []string {
+	dirs := map[string]bool{}
+	for _, path := range g.paths {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dirs[path] = true
+			continue
+		}
+		dirs[filepath.Dir(path)] = true
+	}
+	list := []string{}
+	for dir := range dirs {
+		list = append(list, dir)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// matchingFiles returns the regular files matching the paths. Directories
+// match the files they directly contain.
+func (g *DataGatherer) matchingFiles() []string {
+	files := map[string]bool{}
+	for _, pattern := range g.paths {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				continue
+			}
+			if !info.IsDir() {
+				files[match] = true
+				continue
+			}
+			entries, err := ioutil.ReadDir(match)
+			if err != nil {
+				logs.Log.Errorf("failed to read directory %q: %v", match, err)
+				continue
+			}
+			for _, entry := range entries {
+				path := filepath.Join(match, entry.Name())
+				// stat the path to follow symlinks
+				if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+					files[path] = true
+				}
+			}
+		}
+	}
+	list := []string{}
+	for file := range files {
+		list = append(list, file)
+	}
+	return list
+}
+
+// load reads and parses the files matching the paths. Only the files which
+// no longer exist are marked as deleted. The files which cannot be read or
+// parsed, e.g. while they are being written, keep their last good copy and
+// their error is recorded.
+func (g *DataGatherer) load() {
+	loaded := map[string]*api.GatheredResource{}
+	failures := map[string]error{}
+	for _, path := range g.matchingFiles() {
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			// deleted since it was matched
+			continue
+		}
+		if err != nil {
+			logs.Log.Errorf("failed to read local file %q: %v", path, err)
+			failures[path] = fmt.Errorf("failed to read: %v", err)
+			continue
+		}
+		// YAML is a superset of JSON, so both are parsed as YAML
+		var content interface{}
+		if err := yaml.Unmarshal(data, &content); err != nil {
+			logs.Log.Errorf("failed to parse local file %q as JSON or YAML: %v", path, err)
+			failures[path] = fmt.Errorf("failed to parse as JSON or YAML: %v", err)
+			continue
+		}
+		loaded[path] = &api.GatheredResource{
+			Resource: map[string]interface{}{
+				"path": path,
+				"data": content,
+			},
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for path, item := range g.files {
+		if _, found := loaded[path]; found {
+			continue
+		}
+		if _, failed := failures[path]; !failed && item.DeletedAt.IsZero() {
+			if _, err := os.Stat(path); err != nil && !os.IsNotExist(err) {
+				// the file may still be there, e.g. its directory is not
+				// readable
+				failures[path] = fmt.Errorf("failed to stat: %v", err)
+			} else {
+				item.DeletedAt = api.Time{Time: time.Now()}
+			}
+		}
+		loaded[path] = item
 	}
-	return dataBytes, nil
+	g.files = loaded
+	g.failures = failures
 }
@@ -0,0 +1,211 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/api"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %q: %v", path, err)
+	}
+}
+
+// fetchFiles returns the data of the fetched files by path, and the paths
+// of the deleted ones.
+func fetchFiles(t *testing.T, g *DataGatherer) (map[string]interface{}, []string) {
+	data, err := g.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files := map[string]interface{}{}
+	deleted := []string{}
+	for _, item := range data.(map[string]interface{})["items"].([]*api.GatheredResource) {
+		resource := item.Resource.(map[string]interface{})
+		if !item.DeletedAt.IsZero() {
+			deleted = append(deleted, resource["path"].(string))
+			continue
+		}
+		files[resource["path"].(string)] = resource["data"]
+	}
+	return files, deleted
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		config  Config
+		wantErr bool
+	}{
+		"data path":            {config: Config{DataPath: "data.json"}},
+		"paths":                {config: Config{Paths: []string{"/etc/config/*.yaml"}, Watch: true}},
+		"empty":                {config: Config{}, wantErr: true},
+		"data path and paths":  {config: Config{DataPath: "data.json", Paths: []string{"dir"}}, wantErr: true},
+		"watch without paths":  {config: Config{DataPath: "data.json", Watch: true}, wantErr: true},
+		"invalid pattern":      {config: Config{Paths: []string{"[a"}}, wantErr: true},
+		"watch pattern in dir": {config: Config{Paths: []string{"/etc/*/config.yaml"}, Watch: true}, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.config.validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFetchPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	confDir := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(confDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "a.json"), `{"a": 1}`)
+	writeFile(t, filepath.Join(dir, "b.txt"), `ignored`)
+	writeFile(t, filepath.Join(confDir, "c.yaml"), "c: [1, 2]\n")
+	writeFile(t, filepath.Join(confDir, "invalid.yaml"), "c: [\n")
+
+	config := &Config{Paths: []string{filepath.Join(dir, "*.json"), confDir}}
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g := dg.(*DataGatherer)
+
+	files, deleted := fetchFiles(t, g)
+	expected := map[string]interface{}{
+		filepath.Join(dir, "a.json"):     map[string]interface{}{"a": float64(1)},
+		filepath.Join(confDir, "c.yaml"): map[string]interface{}{"c": []interface{}{float64(1), float64(2)}},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, files); !equal {
+		t.Errorf("unexpected files:\n%s", diff)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("unexpected deleted files: %v", deleted)
+	}
+
+	os.Remove(filepath.Join(dir, "a.json"))
+	_, deleted = fetchFiles(t, g)
+	if diff, equal := messagediff.PrettyDiff([]string{filepath.Join(dir, "a.json")}, deleted); !equal {
+		t.Errorf("unexpected deleted files:\n%s", diff)
+	}
+	// deleted files are only returned once
+	_, deleted = fetchFiles(t, g)
+	if len(deleted) != 0 {
+		t.Errorf("unexpected deleted files: %v", deleted)
+	}
+}
+
+func TestFetchWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, "version: 1\n")
+
+	config := &Config{Paths: []string{filepath.Join(dir, "*.yaml")}, Watch: true}
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g := dg.(*DataGatherer)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := g.Run(stopCh); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := g.WaitForCacheSync(stopCh); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files, _ := fetchFiles(t, g)
+	if diff, equal := messagediff.PrettyDiff(map[string]interface{}{path: map[string]interface{}{"version": float64(1)}}, files); !equal {
+		t.Errorf("unexpected files:\n%s", diff)
+	}
+
+	writeFile(t, path, "version: 2\n")
+	expected := map[string]interface{}{path: map[string]interface{}{"version": float64(2)}}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		files, _ = fetchFiles(t, g)
+		if _, equal := messagediff.PrettyDiff(expected, files); equal {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the change, got %v", files)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestFetchInvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, "version: 1\n")
+
+	config := &Config{Paths: []string{dir}}
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g := dg.(*DataGatherer)
+
+	expected := map[string]interface{}{path: map[string]interface{}{"version": float64(1)}}
+	files, _ := fetchFiles(t, g)
+	if diff, equal := messagediff.PrettyDiff(expected, files); !equal {
+		t.Errorf("unexpected files:\n%s", diff)
+	}
+	if err := g.Healthy(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// a half-written file keeps its last good copy rather than being deleted
+	writeFile(t, path, "version: [\n")
+	files, deleted := fetchFiles(t, g)
+	if diff, equal := messagediff.PrettyDiff(expected, files); !equal {
+		t.Errorf("unexpected files:\n%s", diff)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("unexpected deleted files: %v", deleted)
+	}
+	if err := g.Healthy(); err == nil {
+		t.Errorf("expected an error for the invalid file")
+	}
+	if issues := g.Issues(); len(issues) != 1 || issues[0].Code != api.IssueFailed {
+		t.Errorf("unexpected issues: %+v", issues)
+	}
+
+	writeFile(t, path, "version: 2\n")
+	files, _ = fetchFiles(t, g)
+	if diff, equal := messagediff.PrettyDiff(map[string]interface{}{path: map[string]interface{}{"version": float64(2)}}, files); !equal {
+		t.Errorf("unexpected files:\n%s", diff)
+	}
+	if err := g.Healthy(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if issues := g.Issues(); len(issues) != 0 {
+		t.Errorf("unexpected issues: %+v", issues)
+	}
+}
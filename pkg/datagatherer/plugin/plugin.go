@@ -0,0 +1,238 @@
+// Package plugin provides a datagatherer running an external plugin, and the
+// helper implementing the plugins, so that proprietary data gatherers can be
+// added without forking the agent.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// defaultTimeout is how long a request to the plugin can take, unless a
+// timeout is configured.
+const defaultTimeout = time.Minute
+
+// defaultMaxOutputSize is the maximum size of the response of the plugin,
+// unless one is configured.
+const defaultMaxOutputSize = 10 << 20
+
+// maxStderrSize is how much of the standard error of a failed plugin is
+// included in the error.
+const maxStderrSize = 4 << 10
+
+// Config is the configuration for a plugin DataGatherer.
+type Config struct {
+	// Command is the absolute path of the plugin binary. It is not run
+	// through a shell.
+	Command string `yaml:"command"`
+	// Args are the arguments of the plugin.
+	Args []string `yaml:"args"`
+	// Env are the environment variables of the plugin. The plugin does not
+	// inherit the environment of the agent, except PATH.
+	Env map[string]string `yaml:"env"`
+	// Timeout is how long a request can take before the plugin is killed.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxOutputSize is the maximum size in bytes of the response of the
+	// plugin. The plugin is killed if its response is larger.
+	MaxOutputSize int64 `yaml:"max-output-size"`
+	// Config is the config of the plugin, sent with every request.
+	Config map[string]interface{} `yaml:"config"`
+}
+
+// validate validates the configuration.
+func (c *Config) validate() error {
+	if c.Command == "" {
+		return fmt.Errorf("invalid configuration: Command cannot be empty")
+	}
+	if !filepath.IsAbs(c.Command) {
+		return fmt.Errorf("invalid configuration: Command must be an absolute path, got %q", c.Command)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid configuration: Timeout cannot be negative")
+	}
+	if c.MaxOutputSize < 0 {
+		return fmt.Errorf("invalid configuration: MaxOutputSize cannot be negative")
+	}
+	for name := range c.Env {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("invalid configuration: invalid environment variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// NewDataGatherer creates a new plugin DataGatherer. It performs a config
+// validation, and has the plugin validate its config.
+func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	maxOutputSize := c.MaxOutputSize
+	if maxOutputSize == 0 {
+		maxOutputSize = defaultMaxOutputSize
+	}
+
+	// the plugin only gets the configured environment, so that the
+	// credentials of the agent are not leaked to it
+	env := []string{"PATH=" + os.Getenv("PATH")}
+	for name, value := range c.Env {
+		env = append(env, name+"="+value)
+	}
+
+	g := &DataGatherer{
+		ctx:           ctx,
+		command:       c.Command,
+		args:          c.Args,
+		env:           env,
+		config:        c.Config,
+		timeout:       timeout,
+		maxOutputSize: maxOutputSize,
+	}
+	if _, err := g.request(ctx, OperationValidate); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// DataGatherer is a data-gatherer running a plugin.
+type DataGatherer struct {
+	ctx           context.Context
+	command       string
+	args          []string
+	env           []string
+	config        map[string]interface{}
+	timeout       time.Duration
+	maxOutputSize int64
+
+	mu       sync.Mutex
+	degraded string
+}
+
+func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch runs the plugin and returns the data of its response.
+func (g *DataGatherer) Fetch() (interface{}, error) {
+	return g.FetchContext(g.ctx)
+}
+
+// FetchContext runs the plugin as Fetch does, killing it once ctx is done.
+func (g *DataGatherer) FetchContext(ctx context.Context) (interface{}, error) {
+	res, err := g.request(ctx, OperationFetch)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	g.degraded = res.Degraded
+	g.mu.Unlock()
+	return res.Data, nil
+}
+
+// Healthy returns the reason the data of the last fetch may be stale, as
+// reported by the plugin.
+func (g *DataGatherer) Healthy() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.degraded == "" {
+		return nil
+	}
+	return fmt.Errorf("%s", g.degraded)
+}
+
+// request runs the plugin with a request for the operation, and returns its
+// response.
+func (g *DataGatherer) request(ctx context.Context, operation string) (*Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	req, err := json.Marshal(Request{
+		ProtocolVersion: ProtocolVersion,
+		Operation:       operation,
+		Config:          g.config,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stdout := &limitedBuffer{limit: g.maxOutputSize, cancel: cancel}
+	stderr := &limitedBuffer{limit: maxStderrSize}
+
+	cmd := exec.CommandContext(ctx, g.command, g.args...)
+	cmd.Env = g.env
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	if stdout.exceeded {
+		return nil, fmt.Errorf("response of plugin %s is larger than %d bytes", g.command, g.maxOutputSize)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("plugin %s did not complete %s within %s", g.command, operation, g.timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run plugin %s: %v: %s", g.command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var res Response
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("failed to parse the response of plugin %s: %v", g.command, err)
+	}
+	if res.Error != "" {
+		return nil, fmt.Errorf("plugin %s failed to %s: %s", g.command, operation, res.Error)
+	}
+	return &res, nil
+}
+
+// limitedBuffer is a buffer keeping at most limit bytes. When the limit is
+// exceeded, it calls cancel if set to kill the plugin.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int64
+	cancel   func()
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.limit - int64(b.Len())
+	if int64(len(p)) > remaining {
+		b.exceeded = true
+		if remaining > 0 {
+			b.Buffer.Write(p[:remaining])
+		}
+		if b.cancel != nil {
+			b.cancel()
+			return 0, fmt.Errorf("output limit exceeded")
+		}
+		// the input is discarded rather than blocking the plugin
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// the test binary is the plugin when helperEnv is set
+const helperEnv = "PREFLIGHT_PLUGIN_TEST_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnv) != "" {
+		Serve(&helperConfig{})
+		return
+	}
+	os.Exit(m.Run())
+}
+
+type helperConfig struct {
+	Message  string        `yaml:"message"`
+	Sleep    time.Duration `yaml:"sleep"`
+	Degraded string        `yaml:"degraded"`
+}
+
+func (c *helperConfig) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if c.Message == "" {
+		return nil, fmt.Errorf("message is required")
+	}
+	return &helperDataGatherer{config: c}, nil
+}
+
+type helperDataGatherer struct {
+	config *helperConfig
+}
+
+func (g *helperDataGatherer) Run(stopCh <-chan struct{}) error              { return nil }
+func (g *helperDataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error { return nil }
+func (g *helperDataGatherer) Delete() error                                 { return nil }
+
+func (g *helperDataGatherer) Fetch() (interface{}, error) {
+	time.Sleep(g.config.Sleep)
+	return map[string]interface{}{"message": g.config.Message}, nil
+}
+
+func (g *helperDataGatherer) Healthy() error {
+	if g.config.Degraded == "" {
+		return nil
+	}
+	return fmt.Errorf("%s", g.config.Degraded)
+}
+
+func TestPlugin(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Skipf("the test binary cannot be run as a plugin: %v", err)
+	}
+	env := map[string]string{helperEnv: "1"}
+
+	tests := map[string]struct {
+		config           Config
+		expected         interface{}
+		expectedDegraded string
+		expectedErr      string
+	}{
+		"fetch": {
+			config:   Config{Command: executable, Env: env, Config: map[string]interface{}{"message": "hello"}},
+			expected: map[string]interface{}{"message": "hello"},
+		},
+		"degraded": {
+			config:           Config{Command: executable, Env: env, Config: map[string]interface{}{"message": "hello", "degraded": "watch failing"}},
+			expected:         map[string]interface{}{"message": "hello"},
+			expectedDegraded: "watch failing",
+		},
+		"invalid config": {
+			config:      Config{Command: executable, Env: env, Config: map[string]interface{}{"message": "hello", "unknown": true}},
+			expectedErr: "failed to validate: invalid config",
+		},
+		"config rejected by the data gatherer": {
+			config:      Config{Command: executable, Env: env},
+			expectedErr: "failed to validate: message is required",
+		},
+		"timeout": {
+			config:      Config{Command: executable, Env: env, Timeout: time.Second, Config: map[string]interface{}{"message": "hello", "sleep": "5s"}},
+			expectedErr: "did not complete fetch within 1s",
+		},
+		"not a plugin": {
+			config:      Config{Command: "/bin/sh", Args: []string{"-c", "cat >/dev/null; echo not json"}},
+			expectedErr: "failed to parse the response",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dg, err := test.config.NewDataGatherer(context.Background())
+			if err == nil {
+				var data interface{}
+				data, err = dg.Fetch()
+				if err == nil {
+					if diff, equal := messagediff.PrettyDiff(test.expected, data); !equal {
+						t.Errorf("unexpected data:\n%s", diff)
+					}
+					degraded := ""
+					if err := dg.(*DataGatherer).Healthy(); err != nil {
+						degraded = err.Error()
+					}
+					if degraded != test.expectedDegraded {
+						t.Errorf("expected degraded %q, got %q", test.expectedDegraded, degraded)
+					}
+				}
+			}
+			if test.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), test.expectedErr)) {
+				t.Fatalf("expected error containing %q, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestHandleProtocolVersion(t *testing.T) {
+	res := handle(context.Background(), &helperConfig{}, Request{ProtocolVersion: "2", Operation: OperationFetch})
+	if !strings.Contains(res.Error, "unsupported protocol version") {
+		t.Errorf("expected an unsupported protocol version, got %+v", res)
+	}
+}
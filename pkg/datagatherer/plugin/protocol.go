@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// ProtocolVersion is the version of the protocol between the agent and the
+// plugins. A plugin answers the requests of another version with an error.
+const ProtocolVersion = "1"
+
+const (
+	// OperationValidate validates the config, when the data gatherer is
+	// instantiated.
+	OperationValidate = "validate"
+	// OperationFetch fetches the data.
+	OperationFetch = "fetch"
+)
+
+// Request is written by the agent to the standard input of the plugin, as
+// JSON.
+type Request struct {
+	ProtocolVersion string `json:"protocol_version"`
+	Operation       string `json:"operation"`
+	// Config is the config of the data gatherer in the agent configuration.
+	Config interface{} `json:"config,omitempty"`
+}
+
+// Response is written by the plugin to its standard output, as JSON.
+type Response struct {
+	// Data is the data gathered by a fetch.
+	Data interface{} `json:"data,omitempty"`
+	// Error is set when the operation failed.
+	Error string `json:"error,omitempty"`
+	// Degraded is set when the data may be stale, as reported by the Healthy
+	// method of the data gatherer.
+	Degraded string `json:"degraded,omitempty"`
+}
+
+// Serve implements the protocol of the plugins for a data gatherer: it reads
+// a request from the standard input, decodes its config into config, runs the
+// operation with the data gatherer config returns, writes the response to the
+// standard output and exits. It is meant to be the main function of a plugin:
+//
+//	func main() {
+//		plugin.Serve(&inventory.Config{})
+//	}
+//
+// The data gatherer is run and synced before it is fetched, and deleted
+// afterwards, as the process only lives for one request.
+func Serve(config datagatherer.Config) {
+	if err := serve(context.Background(), config, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// serve answers a request read from r, the errors of the operation are
+// written in the response.
+func serve(ctx context.Context, config datagatherer.Config, r io.Reader, w io.Writer) error {
+	var req Request
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return fmt.Errorf("failed to decode the request: %v", err)
+	}
+	res := handle(ctx, config, req)
+	return json.NewEncoder(w).Encode(res)
+}
+
+func handle(ctx context.Context, config datagatherer.Config, req Request) Response {
+	if req.ProtocolVersion != ProtocolVersion {
+		return Response{Error: fmt.Sprintf("unsupported protocol version %q, the plugin supports %q", req.ProtocolVersion, ProtocolVersion)}
+	}
+	if err := datagatherer.DecodeConfig(req.Config, config); err != nil {
+		return Response{Error: fmt.Sprintf("invalid config: %v", err)}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dg, err := config.NewDataGatherer(ctx)
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+
+	switch req.Operation {
+	case OperationValidate:
+		return Response{}
+	case OperationFetch:
+	default:
+		return Response{Error: fmt.Sprintf("unsupported operation %q", req.Operation)}
+	}
+
+	if err := dg.Run(ctx.Done()); err != nil {
+		return Response{Error: err.Error()}
+	}
+	defer dg.Delete()
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		return Response{Error: err.Error()}
+	}
+	data, err := dg.Fetch()
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	res := Response{Data: data}
+	if err := dg.Healthy(); err != nil {
+		res.Degraded = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,338 @@
+// Package prometheus provides a datagatherer for Prometheus metrics, either
+// scraped from a metrics endpoint or queried from the Prometheus HTTP API.
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/httpendpoint"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Config is the configuration for a Prometheus DataGatherer.
+type Config struct {
+	// ScrapeURL is the URL of a metrics endpoint in the Prometheus text
+	// format to scrape.
+	ScrapeURL string `yaml:"scrape-url"`
+	// Metrics are the names of the metrics gathered from the scraped
+	// endpoint.
+	Metrics []string `yaml:"metrics"`
+	// PrometheusURL is the base URL of a Prometheus server to query.
+	PrometheusURL string `yaml:"prometheus-url"`
+	// Queries are the PromQL queries run against the Prometheus server, by
+	// the name of their series.
+	Queries map[string]string `yaml:"queries"`
+	// ClientConfig configures the connection to the endpoint.
+	httpendpoint.ClientConfig `yaml:",inline"`
+}
+
+// validate validates the configuration.
+func (c *Config) validate() error {
+	switch {
+	case c.ScrapeURL != "" && c.PrometheusURL != "":
+		return fmt.Errorf("invalid configuration: ScrapeURL and PrometheusURL cannot be used at the same time")
+	case c.ScrapeURL != "":
+		if err := httpendpoint.ValidateURL(c.ScrapeURL); err != nil {
+			return err
+		}
+		if len(c.Metrics) == 0 {
+			return fmt.Errorf("invalid configuration: Metrics cannot be empty when scraping")
+		}
+		if len(c.Queries) > 0 {
+			return fmt.Errorf("invalid configuration: Queries require PrometheusURL")
+		}
+	case c.PrometheusURL != "":
+		if err := httpendpoint.ValidateURL(c.PrometheusURL); err != nil {
+			return err
+		}
+		if len(c.Queries) == 0 {
+			return fmt.Errorf("invalid configuration: Queries cannot be empty when querying Prometheus")
+		}
+		if len(c.Metrics) > 0 {
+			return fmt.Errorf("invalid configuration: Metrics require ScrapeURL")
+		}
+	default:
+		return fmt.Errorf("invalid configuration: either ScrapeURL or PrometheusURL must be set")
+	}
+	return c.ClientConfig.Validate()
+}
+
+// NewDataGatherer creates a new Prometheus DataGatherer. It performs a config
+// validation.
+func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := c.ClientConfig.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataGatherer{
+		ctx:           ctx,
+		client:        client,
+		scrapeURL:     c.ScrapeURL,
+		metrics:       c.Metrics,
+		prometheusURL: strings.TrimSuffix(c.PrometheusURL, "/"),
+		queries:       c.Queries,
+	}, nil
+}
+
+// DataGatherer is a data-gatherer for Prometheus metrics.
+type DataGatherer struct {
+	ctx           context.Context
+	client        *httpendpoint.Client
+	scrapeURL     string
+	metrics       []string
+	prometheusURL string
+	queries       map[string]string
+}
+
+// Info contains the data retrieved from Prometheus.
+type Info struct {
+	// Series are the values of the selected metrics or queries.
+	Series []*Series `json:"series"`
+}
+
+// Series is a value of a metric, identified by its name and labels.
+type Series struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels"`
+	Value     float64           `json:"value"`
+	Timestamp *time.Time        `json:"timestamp,omitempty"`
+}
+
+func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Healthy() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch scrapes the metrics endpoint or runs the queries, and returns the
+// series sorted by name and labels.
+func (g *DataGatherer) Fetch() (interface{}, error) {
+	return g.FetchContext(g.ctx)
+}
+
+// FetchContext scrapes the endpoint or runs the queries as Fetch does,
+// cancelling the requests once ctx is done.
+func (g *DataGatherer) FetchContext(ctx context.Context) (interface{}, error) {
+	var series []*Series
+	var err error
+	if g.scrapeURL != "" {
+		series, err = g.scrape(ctx)
+	} else {
+		series, err = g.query(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Name != series[j].Name {
+			return series[i].Name < series[j].Name
+		}
+		return labelsKey(series[i].Labels) < labelsKey(series[j].Labels)
+	})
+	return &Info{Series: series}, nil
+}
+
+// labelsKey formats labels in a stable order, to sort series.
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var key strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&key, "%s=%q,", name, labels[name])
+	}
+	return key.String()
+}
+
+// newSeries returns a series, or nil if its value cannot be encoded in JSON.
+func newSeries(name string, labels map[string]string, value float64, timestamp *time.Time) *Series {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return &Series{Name: name, Labels: labels, Value: value, Timestamp: timestamp}
+}
+
+// scrape gets the metrics endpoint and keeps the selected metrics.
+// Histograms and summaries are gathered as their _sum and _count series.
+func (g *DataGatherer) scrape(ctx context.Context) ([]*Series, error) {
+	body, err := g.client.Get(ctx, g.scrapeURL, "text/plain;version=0.0.4")
+	if err != nil {
+		return nil, err
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metrics of %s: %v", g.scrapeURL, err)
+	}
+
+	series := []*Series{}
+	add := func(s *Series) {
+		if s != nil {
+			series = append(series, s)
+		}
+	}
+	for _, name := range g.metrics {
+		family, ok := families[name]
+		if !ok {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			var timestamp *time.Time
+			if metric.TimestampMs != nil {
+				t := time.Unix(0, metric.GetTimestampMs()*int64(time.Millisecond)).UTC()
+				timestamp = &t
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(newSeries(name, labels, metric.GetCounter().GetValue(), timestamp))
+			case dto.MetricType_GAUGE:
+				add(newSeries(name, labels, metric.GetGauge().GetValue(), timestamp))
+			case dto.MetricType_SUMMARY:
+				add(newSeries(name+"_sum", labels, metric.GetSummary().GetSampleSum(), timestamp))
+				add(newSeries(name+"_count", labels, float64(metric.GetSummary().GetSampleCount()), timestamp))
+			case dto.MetricType_HISTOGRAM:
+				add(newSeries(name+"_sum", labels, metric.GetHistogram().GetSampleSum(), timestamp))
+				add(newSeries(name+"_count", labels, float64(metric.GetHistogram().GetSampleCount()), timestamp))
+			default:
+				add(newSeries(name, labels, metric.GetUntyped().GetValue(), timestamp))
+			}
+		}
+	}
+	return series, nil
+}
+
+// queryResponse is the response of an instant query of the Prometheus HTTP
+// API.
+type queryResponse struct {
+	Status    string `json:"status"`
+	Error     string `json:"error"`
+	ErrorType string `json:"errorType"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// sample is a value of the Prometheus HTTP API, a timestamp in seconds and a
+// value formatted as a string.
+type sample [2]interface{}
+
+func (s sample) parse() (float64, *time.Time, error) {
+	seconds, ok := s[0].(float64)
+	if !ok {
+		return 0, nil, fmt.Errorf("invalid timestamp %v", s[0])
+	}
+	formatted, ok := s[1].(string)
+	if !ok {
+		return 0, nil, fmt.Errorf("invalid value %v", s[1])
+	}
+	value, err := strconv.ParseFloat(formatted, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid value %q: %v", formatted, err)
+	}
+	t := time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+	return value, &t, nil
+}
+
+// query runs the instant queries against Prometheus. Vector results are
+// gathered as one series per element, and scalar results as a single series.
+func (g *DataGatherer) query(ctx context.Context) ([]*Series, error) {
+	names := make([]string, 0, len(g.queries))
+	for name := range g.queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	series := []*Series{}
+	for _, name := range names {
+		queryURL := fmt.Sprintf("%s/api/v1/query?query=%s", g.prometheusURL, url.QueryEscape(g.queries[name]))
+		body, err := g.client.Get(ctx, queryURL, "application/json")
+		if err != nil {
+			return nil, fmt.Errorf("failed to run query %q: %v", name, err)
+		}
+
+		var response queryResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			return nil, fmt.Errorf("failed to parse result of query %q: %v", name, err)
+		}
+		if response.Status != "success" {
+			return nil, fmt.Errorf("query %q failed: %s: %s", name, response.ErrorType, response.Error)
+		}
+
+		switch response.Data.ResultType {
+		case "vector":
+			var result []struct {
+				Metric map[string]string `json:"metric"`
+				Value  sample            `json:"value"`
+			}
+			if err := json.Unmarshal(response.Data.Result, &result); err != nil {
+				return nil, fmt.Errorf("failed to parse result of query %q: %v", name, err)
+			}
+			for _, element := range result {
+				value, timestamp, err := element.Value.parse()
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse result of query %q: %v", name, err)
+				}
+				// the name of the series is the one of the query
+				delete(element.Metric, "__name__")
+				if s := newSeries(name, element.Metric, value, timestamp); s != nil {
+					series = append(series, s)
+				}
+			}
+		case "scalar":
+			var result sample
+			if err := json.Unmarshal(response.Data.Result, &result); err != nil {
+				return nil, fmt.Errorf("failed to parse result of query %q: %v", name, err)
+			}
+			value, timestamp, err := result.parse()
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse result of query %q: %v", name, err)
+			}
+			if s := newSeries(name, map[string]string{}, value, timestamp); s != nil {
+				series = append(series, s)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported result type %q of query %q, only instant vectors and scalars are supported", response.Data.ResultType, name)
+		}
+	}
+	return series, nil
+}
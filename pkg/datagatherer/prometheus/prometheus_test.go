@@ -0,0 +1,147 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		config  Config
+		wantErr bool
+	}{
+		"scrape":                 {config: Config{ScrapeURL: "http://cert-manager:9402/metrics", Metrics: []string{"certmanager_certificate_expiration_timestamp_seconds"}}},
+		"query":                  {config: Config{PrometheusURL: "http://prometheus:9090", Queries: map[string]string{"up": "up"}}},
+		"empty":                  {config: Config{}, wantErr: true},
+		"both":                   {config: Config{ScrapeURL: "http://a", PrometheusURL: "http://b", Metrics: []string{"up"}}, wantErr: true},
+		"scrape without metrics": {config: Config{ScrapeURL: "http://a"}, wantErr: true},
+		"scrape with queries":    {config: Config{ScrapeURL: "http://a", Metrics: []string{"up"}, Queries: map[string]string{"up": "up"}}, wantErr: true},
+		"query without queries":  {config: Config{PrometheusURL: "http://b"}, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.config.validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+const metrics = `# HELP certmanager_certificate_expiration_timestamp_seconds The date after which the certificate expires.
+# TYPE certmanager_certificate_expiration_timestamp_seconds gauge
+certmanager_certificate_expiration_timestamp_seconds{name="b",namespace="default"} 1.6225e+09
+certmanager_certificate_expiration_timestamp_seconds{name="a",namespace="default"} 1.6e+09
+# HELP certmanager_controller_sync_call_count The number of sync() calls made by a controller.
+# TYPE certmanager_controller_sync_call_count counter
+certmanager_controller_sync_call_count{controller="certificates"} 42
+# HELP certmanager_http_acme_client_request_duration_seconds The HTTP request latencies in seconds for the ACME client.
+# TYPE certmanager_http_acme_client_request_duration_seconds summary
+certmanager_http_acme_client_request_duration_seconds_sum{method="GET"} 1.5
+certmanager_http_acme_client_request_duration_seconds_count{method="GET"} 3
+# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 120
+`
+
+func TestFetchScrape(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, metrics)
+	}))
+	defer server.Close()
+
+	config := &Config{
+		ScrapeURL: server.URL + "/metrics",
+		Metrics: []string{
+			"certmanager_certificate_expiration_timestamp_seconds",
+			"certmanager_controller_sync_call_count",
+			"certmanager_http_acme_client_request_duration_seconds",
+			"missing",
+		},
+	}
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &Info{
+		Series: []*Series{
+			{Name: "certmanager_certificate_expiration_timestamp_seconds", Labels: map[string]string{"name": "a", "namespace": "default"}, Value: 1.6e+09},
+			{Name: "certmanager_certificate_expiration_timestamp_seconds", Labels: map[string]string{"name": "b", "namespace": "default"}, Value: 1.6225e+09},
+			{Name: "certmanager_controller_sync_call_count", Labels: map[string]string{"controller": "certificates"}, Value: 42},
+			{Name: "certmanager_http_acme_client_request_duration_seconds_count", Labels: map[string]string{"method": "GET"}, Value: 3},
+			{Name: "certmanager_http_acme_client_request_duration_seconds_sum", Labels: map[string]string{"method": "GET"}, Value: 1.5},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+}
+
+func TestFetchQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.URL.Query().Get("query") {
+		case "certmanager_certificate_expiration_timestamp_seconds - time() < 86400 * 7":
+			fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": [
+				{"metric": {"name": "a", "namespace": "default"}, "value": [1600000000, "3600"]}
+			]}}`)
+		case "count(certmanager_certificate_ready_status)":
+			fmt.Fprint(w, `{"status": "success", "data": {"resultType": "scalar", "result": [1600000000, "12"]}}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"status": "error", "errorType": "bad_data", "error": "parse error"}`)
+		}
+	}))
+	defer server.Close()
+
+	config := &Config{
+		PrometheusURL: server.URL + "/",
+		Queries: map[string]string{
+			"expiring_soon": "certmanager_certificate_expiration_timestamp_seconds - time() < 86400 * 7",
+			"certificates":  "count(certmanager_certificate_ready_status)",
+		},
+	}
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	timestamp := time.Unix(1600000000, 0).UTC()
+	expected := &Info{
+		Series: []*Series{
+			{Name: "certificates", Labels: map[string]string{}, Value: 12, Timestamp: &timestamp},
+			{Name: "expiring_soon", Labels: map[string]string{"name": "a", "namespace": "default"}, Value: 3600, Timestamp: &timestamp},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+
+	config.Queries = map[string]string{"invalid": "{"}
+	dg, err = config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := dg.Fetch(); err == nil {
+		t.Errorf("expected an error for an invalid query")
+	}
+}
@@ -0,0 +1,73 @@
+package datagatherer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// NewConfigFunc returns an empty configuration of a kind of data gatherer,
+// which the config of the data gatherers of this kind is decoded into.
+type NewConfigFunc func() Config
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]NewConfigFunc{}
+)
+
+// Register makes a kind of data gatherer available to the configuration of
+// the agent, so that an agent built with a package registering it in its init
+// function can use it without the agent being forked. The built-in kinds take
+// precedence over the registered ones. It panics if the kind is registered
+// twice.
+func Register(kind string, newConfig NewConfigFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if kind == "" || newConfig == nil {
+		panic("datagatherer: Register requires a kind and a config")
+	}
+	if _, ok := registry[kind]; ok {
+		panic(fmt.Sprintf("datagatherer: kind %q is registered twice", kind))
+	}
+	registry[kind] = newConfig
+}
+
+// NewConfig returns an empty configuration of a registered kind of data
+// gatherer.
+func NewConfig(kind string) (Config, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	newConfig, ok := registry[kind]
+	if !ok {
+		return nil, false
+	}
+	return newConfig(), true
+}
+
+// Kinds returns the registered kinds of data gatherers, sorted.
+func Kinds() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var kinds []string
+	for kind := range registry {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// DecodeConfig decodes the raw config of a data gatherer, as decoded from
+// the YAML configuration of the agent or from JSON, into config according to
+// its yaml tags. Unknown fields are an error.
+func DecodeConfig(raw interface{}, config interface{}) error {
+	if raw == nil {
+		return nil
+	}
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(data, config)
+}
@@ -0,0 +1,43 @@
+package datagatherer
+
+import (
+	"context"
+	"testing"
+)
+
+type registeredConfig struct {
+	Endpoint string `yaml:"endpoint"`
+}
+
+func (c *registeredConfig) NewDataGatherer(ctx context.Context) (DataGatherer, error) {
+	return nil, nil
+}
+
+func TestRegister(t *testing.T) {
+	Register("test-inventory", func() Config { return &registeredConfig{} })
+
+	cfg, ok := NewConfig("test-inventory")
+	if !ok {
+		t.Fatalf("expected the kind to be registered")
+	}
+	if err := DecodeConfig(map[string]interface{}{"endpoint": "https://inventory"}, cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if endpoint := cfg.(*registeredConfig).Endpoint; endpoint != "https://inventory" {
+		t.Errorf("unexpected endpoint %q", endpoint)
+	}
+	if err := DecodeConfig(map[string]interface{}{"endpiont": "https://inventory"}, cfg); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+
+	if _, ok := NewConfig("unknown"); ok {
+		t.Errorf("expected an unknown kind")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering a kind twice to panic")
+		}
+	}()
+	Register("test-inventory", func() Config { return &registeredConfig{} })
+}
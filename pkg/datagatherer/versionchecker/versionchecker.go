@@ -311,6 +311,15 @@ func (g *DataGatherer) Delete() error {
 	return nil
 }
 
+// Healthy reports whether the pods or the nodes of the dynamic data gatherers
+// may be stale.
+func (g *DataGatherer) Healthy() error {
+	if err := g.podDynamicDg.Healthy(); err != nil {
+		return err
+	}
+	return g.nodeDynamicDg.Healthy()
+}
+
 // Fetch retrieves cluster information from GKE.
 func (g *DataGatherer) Fetch() (interface{}, error) {
 	// Get nodes information to update version-checker architecture structure
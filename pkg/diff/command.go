@@ -0,0 +1,46 @@
+package diff
+
+import (
+	"os"
+
+	"github.com/jetstack/preflight/pkg/agent"
+	"github.com/jetstack/preflight/pkg/logs"
+	"github.com/spf13/cobra"
+)
+
+// EncryptionKeyFile is the key decrypting the encrypted readings bundles
+var EncryptionKeyFile string
+
+// The exit codes are the ones of diff(1).
+const (
+	exitDifferent = 1
+	exitFailed    = 2
+)
+
+// Diff prints the differences between the two readings bundles of args.
+func Diff(cmd *cobra.Command, args []string) {
+	a, err := agent.ReadBundle(args[0], EncryptionKeyFile)
+	if err != nil {
+		fail("%s", err)
+	}
+	b, err := agent.ReadBundle(args[1], EncryptionKeyFile)
+	if err != nil {
+		fail("%s", err)
+	}
+
+	report, err := Compare(a, b)
+	if err != nil {
+		fail("failed to compare the readings bundles: %v", err)
+	}
+	if err := report.Write(os.Stdout, args[0], args[1]); err != nil {
+		fail("failed to write the differences: %v", err)
+	}
+	if !report.Empty() {
+		os.Exit(exitDifferent)
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	logs.Log.Errorf(format, args...)
+	os.Exit(exitFailed)
+}
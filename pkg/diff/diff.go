@@ -0,0 +1,335 @@
+// Package diff compares readings bundles, to tell why two uploads of the
+// agent differ.
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// ChangeKind is how a resource differs between two bundles.
+type ChangeKind string
+
+const (
+	// Added resources are only in the second bundle.
+	Added ChangeKind = "+"
+	// Removed resources are only in the first bundle.
+	Removed ChangeKind = "-"
+	// Changed resources are in both bundles, with different contents.
+	Changed ChangeKind = "~"
+)
+
+// maxPaths bounds the paths listed for a changed resource.
+const maxPaths = 5
+
+// ignoredPaths change on every update of a resource, without telling anything
+// about why it changed.
+var ignoredPaths = map[string]bool{
+	"metadata.resourceVersion": true,
+	"metadata.managedFields":   true,
+	"metadata.generation":      true,
+}
+
+// Change is a resource that differs between two bundles.
+type Change struct {
+	Kind ChangeKind
+	// ResourceType is the resource type of the resource, e.g. pods.v1, or the
+	// kind of the resource if the resource type is unknown, e.g. Pod.v1.
+	ResourceType string
+	Namespace    string
+	Name         string
+	// Paths are the fields of a changed resource which differ.
+	Paths []string
+}
+
+// DataGathererDiff are the differences of the readings of a data gatherer.
+type DataGathererDiff struct {
+	DataGatherer string
+	// Changes are the resources added, removed or changed, for the data
+	// gatherers returning resources.
+	Changes []Change
+	// Paths are the fields which differ, for the other data gatherers.
+	Paths []string
+}
+
+// Report are the differences between two bundles.
+type Report struct {
+	// OnlyInA and OnlyInB are the data gatherers with a reading in one of
+	// the bundles only.
+	OnlyInA []string
+	OnlyInB []string
+	// DataGatherers are the data gatherers whose readings differ.
+	DataGatherers []DataGathererDiff
+}
+
+// Empty returns whether the bundles have no differences.
+func (r *Report) Empty() bool {
+	return len(r.OnlyInA) == 0 && len(r.OnlyInB) == 0 && len(r.DataGatherers) == 0
+}
+
+// Compare compares the readings of two bundles, data gatherer by data
+// gatherer.
+func Compare(a, b *api.DataReadingsPost) (*Report, error) {
+	readingsA, err := readingsByDataGatherer(a)
+	if err != nil {
+		return nil, err
+	}
+	readingsB, err := readingsByDataGatherer(b)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	for _, name := range sortedKeys(readingsA) {
+		if _, ok := readingsB[name]; !ok {
+			report.OnlyInA = append(report.OnlyInA, name)
+			continue
+		}
+		d := compareData(readingsA[name], readingsB[name])
+		if len(d.Changes) > 0 || len(d.Paths) > 0 {
+			d.DataGatherer = name
+			report.DataGatherers = append(report.DataGatherers, d)
+		}
+	}
+	for _, name := range sortedKeys(readingsB) {
+		if _, ok := readingsA[name]; !ok {
+			report.OnlyInB = append(report.OnlyInB, name)
+		}
+	}
+	return report, nil
+}
+
+// readingsByDataGatherer returns the data of the readings of the bundle,
+// keyed by data gatherer and cluster. The data is normalized to its JSON
+// representation, so that bundles decoded or gathered compare the same.
+func readingsByDataGatherer(bundle *api.DataReadingsPost) (map[string]interface{}, error) {
+	readings := map[string]interface{}{}
+	for _, reading := range bundle.DataReadings {
+		name := reading.DataGatherer
+		if reading.ClusterID != "" {
+			name = fmt.Sprintf("%s (cluster %s)", name, reading.ClusterID)
+		}
+		raw, err := json.Marshal(reading.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the data of %s: %v", name, err)
+		}
+		var data interface{}
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return nil, fmt.Errorf("failed to decode the data of %s: %v", name, err)
+		}
+		readings[name] = data
+	}
+	return readings, nil
+}
+
+// resourceKey identifies a resource across bundles.
+type resourceKey struct {
+	resourceType, namespace, name string
+}
+
+// compareData compares the data of two readings of a data gatherer,
+// resource by resource if they have resources.
+func compareData(a, b interface{}) DataGathererDiff {
+	resourcesA, okA := resources(a)
+	resourcesB, okB := resources(b)
+	if !okA || !okB {
+		return DataGathererDiff{Paths: changedPaths("", a, b)}
+	}
+
+	var d DataGathererDiff
+	for key, resourceA := range resourcesA {
+		resourceB, ok := resourcesB[key]
+		if !ok {
+			d.Changes = append(d.Changes, Change{Kind: Removed, ResourceType: key.resourceType, Namespace: key.namespace, Name: key.name})
+			continue
+		}
+		if paths := changedPaths("", resourceA, resourceB); len(paths) > 0 {
+			d.Changes = append(d.Changes, Change{Kind: Changed, ResourceType: key.resourceType, Namespace: key.namespace, Name: key.name, Paths: paths})
+		}
+	}
+	for key := range resourcesB {
+		if _, ok := resourcesA[key]; !ok {
+			d.Changes = append(d.Changes, Change{Kind: Added, ResourceType: key.resourceType, Namespace: key.namespace, Name: key.name})
+		}
+	}
+	sort.Slice(d.Changes, func(i, j int) bool {
+		a, b := d.Changes[i], d.Changes[j]
+		if a.ResourceType != b.ResourceType {
+			return a.ResourceType < b.ResourceType
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return d
+}
+
+// resources returns the resources of the data of a reading, as returned by
+// the Kubernetes data gatherers: a list of items, or lists of items by
+// resource type. The deleted resources are left out.
+func resources(data interface{}) (map[resourceKey]interface{}, bool) {
+	object, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	found := map[resourceKey]interface{}{}
+	items, hasItems := object["items"].([]interface{})
+	if hasItems {
+		addResources(found, "", items)
+	}
+	byType, hasTypes := object["resources"].(map[string]interface{})
+	for resourceType, list := range byType {
+		list, ok := list.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if items, ok := list["items"].([]interface{}); ok {
+			addResources(found, resourceType, items)
+		}
+	}
+	return found, hasItems || hasTypes
+}
+
+func addResources(found map[resourceKey]interface{}, resourceType string, items []interface{}) {
+	for _, item := range items {
+		item, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if deletedAt, _ := item["deleted_at"].(string); deletedAt != "" {
+			continue
+		}
+		resource, ok := item["resource"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		metadata, _ := resource["metadata"].(map[string]interface{})
+		namespace, _ := metadata["namespace"].(string)
+		name, _ := metadata["name"].(string)
+		key := resourceKey{resourceType: resourceType, namespace: namespace, name: name}
+		if key.resourceType == "" {
+			key.resourceType = kindKey(resource)
+		}
+		found[key] = resource
+	}
+}
+
+// kindKey formats the kind and the API version of a resource as
+// kind.version.group, e.g. Certificate.v1.cert-manager.io.
+func kindKey(resource map[string]interface{}) string {
+	kind, _ := resource["kind"].(string)
+	apiVersion, _ := resource["apiVersion"].(string)
+	parts := []string{kind}
+	if i := strings.Index(apiVersion, "/"); i >= 0 {
+		parts = append(parts, apiVersion[i+1:], apiVersion[:i])
+	} else if apiVersion != "" {
+		parts = append(parts, apiVersion)
+	}
+	return strings.Join(parts, ".")
+}
+
+// changedPaths returns the paths of the fields which differ between a and b,
+// down to the fields whose values are not both objects.
+func changedPaths(path string, a, b interface{}) []string {
+	if ignoredPaths[path] || reflect.DeepEqual(a, b) {
+		return nil
+	}
+	objectA, okA := a.(map[string]interface{})
+	objectB, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		if path == "" {
+			return []string{"."}
+		}
+		return []string{path}
+	}
+
+	keys := sortedKeys(objectA)
+	for key := range objectB {
+		if _, ok := objectA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var paths []string
+	for _, key := range keys {
+		child := key
+		if path != "" {
+			child = path + "." + key
+		}
+		paths = append(paths, changedPaths(child, objectA[key], objectB[key])...)
+	}
+	return paths
+}
+
+// Write prints the report in a human readable form, resources grouped by
+// data gatherer, resource type and namespace.
+func (r *Report) Write(w io.Writer, nameA, nameB string) error {
+	var b strings.Builder
+	if r.Empty() {
+		fmt.Fprintln(&b, "The bundles have the same readings")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	for _, name := range r.OnlyInA {
+		fmt.Fprintf(&b, "Only in %s: %s\n", nameA, name)
+	}
+	for _, name := range r.OnlyInB {
+		fmt.Fprintf(&b, "Only in %s: %s\n", nameB, name)
+	}
+
+	counts := map[ChangeKind]int{}
+	for _, d := range r.DataGatherers {
+		fmt.Fprintf(&b, "%s:\n", d.DataGatherer)
+		if len(d.Paths) > 0 {
+			fmt.Fprintf(&b, "  changed: %s\n", formatPaths(d.Paths))
+		}
+		var group string
+		for _, c := range d.Changes {
+			counts[c.Kind]++
+			if g := groupOf(c); g != group {
+				group = g
+				fmt.Fprintf(&b, "  %s\n", group)
+			}
+			fmt.Fprintf(&b, "    %s %s", c.Kind, c.Name)
+			if len(c.Paths) > 0 {
+				fmt.Fprintf(&b, ": %s", formatPaths(c.Paths))
+			}
+			fmt.Fprintln(&b)
+		}
+	}
+	fmt.Fprintf(&b, "%d resource(s) added, %d removed, %d changed\n", counts[Added], counts[Removed], counts[Changed])
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func groupOf(c Change) string {
+	if c.Namespace == "" {
+		return c.ResourceType
+	}
+	return fmt.Sprintf("%s in namespace %s", c.ResourceType, c.Namespace)
+}
+
+func formatPaths(paths []string) string {
+	if len(paths) > maxPaths {
+		return fmt.Sprintf("%s and %d more", strings.Join(paths[:maxPaths], ", "), len(paths)-maxPaths)
+	}
+	return strings.Join(paths, ", ")
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
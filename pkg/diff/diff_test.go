@@ -0,0 +1,144 @@
+package diff
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func secret(namespace, name, data string) map[string]interface{} {
+	return map[string]interface{}{
+		"resource": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"namespace":       namespace,
+				"name":            name,
+				"resourceVersion": data,
+			},
+			"data": map[string]interface{}{"tls.crt": data},
+		},
+	}
+}
+
+func bundle(readings ...*api.DataReading) *api.DataReadingsPost {
+	return &api.DataReadingsPost{DataReadings: readings}
+}
+
+func items(resources ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"items": resources}
+}
+
+func TestCompare(t *testing.T) {
+	deleted := secret("default", "old-tls", "a")
+	deleted["deleted_at"] = "2021-01-01T00:00:00Z"
+
+	tests := map[string]struct {
+		a, b *api.DataReadingsPost
+		want *Report
+	}{
+		"same readings": {
+			a:    bundle(&api.DataReading{DataGatherer: "k8s/secrets", Data: items(secret("default", "a", "1"))}),
+			b:    bundle(&api.DataReading{DataGatherer: "k8s/secrets", Data: items(secret("default", "a", "1"))}),
+			want: &Report{},
+		},
+		"resources added, removed and changed": {
+			a: bundle(&api.DataReading{DataGatherer: "k8s/secrets", Data: items(
+				secret("default", "old-tls", "a"),
+				secret("cert-manager", "ca", "a"),
+			)}),
+			b: bundle(&api.DataReading{DataGatherer: "k8s/secrets", Data: items(
+				secret("cert-manager", "ca", "b"),
+				secret("default", "new-tls", "a"),
+				deleted,
+			)}),
+			want: &Report{DataGatherers: []DataGathererDiff{{
+				DataGatherer: "k8s/secrets",
+				Changes: []Change{
+					{Kind: Changed, ResourceType: "Secret.v1", Namespace: "cert-manager", Name: "ca", Paths: []string{"data.tls.crt"}},
+					{Kind: Added, ResourceType: "Secret.v1", Namespace: "default", Name: "new-tls"},
+					{Kind: Removed, ResourceType: "Secret.v1", Namespace: "default", Name: "old-tls"},
+				},
+			}}},
+		},
+		"resources by resource type": {
+			a: bundle(&api.DataReading{DataGatherer: "k8s", Data: map[string]interface{}{
+				"resources": map[string]interface{}{"secrets.v1": items(secret("default", "a", "1"))},
+			}}),
+			b: bundle(&api.DataReading{DataGatherer: "k8s", Data: map[string]interface{}{
+				"resources": map[string]interface{}{},
+			}}),
+			want: &Report{DataGatherers: []DataGathererDiff{{
+				DataGatherer: "k8s",
+				Changes:      []Change{{Kind: Removed, ResourceType: "secrets.v1", Namespace: "default", Name: "a"}},
+			}}},
+		},
+		"other data": {
+			a: bundle(&api.DataReading{DataGatherer: "gke", Data: map[string]interface{}{"cluster": map[string]interface{}{"name": "a", "zone": "z"}}}),
+			b: bundle(&api.DataReading{DataGatherer: "gke", Data: map[string]interface{}{"cluster": map[string]interface{}{"name": "b", "zone": "z"}}}),
+			want: &Report{DataGatherers: []DataGathererDiff{{
+				DataGatherer: "gke",
+				Paths:        []string{"cluster.name"},
+			}}},
+		},
+		"data gatherers in one bundle only": {
+			a:    bundle(&api.DataReading{DataGatherer: "a", Data: "x"}, &api.DataReading{DataGatherer: "c", Data: "x"}),
+			b:    bundle(&api.DataReading{DataGatherer: "b", Data: "x"}, &api.DataReading{DataGatherer: "c", Data: "x"}),
+			want: &Report{OnlyInA: []string{"a"}, OnlyInB: []string{"b"}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Compare(test.a, test.b)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	report := &Report{
+		OnlyInB: []string{"k8s/certificates"},
+		DataGatherers: []DataGathererDiff{{
+			DataGatherer: "k8s/secrets",
+			Changes: []Change{
+				{Kind: Changed, ResourceType: "secrets.v1", Namespace: "cert-manager", Name: "ca", Paths: []string{"data.tls.crt"}},
+				{Kind: Added, ResourceType: "secrets.v1", Namespace: "default", Name: "new-tls"},
+				{Kind: Removed, ResourceType: "secrets.v1", Namespace: "default", Name: "old-tls"},
+			},
+		}},
+	}
+	want := `Only in b.json: k8s/certificates
+k8s/secrets:
+  secrets.v1 in namespace cert-manager
+    ~ ca: data.tls.crt
+  secrets.v1 in namespace default
+    + new-tls
+    - old-tls
+1 resource(s) added, 1 removed, 1 changed
+`
+
+	var out bytes.Buffer
+	if err := report.Write(&out, "a.json", "b.json"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	out.Reset()
+	if err := (&Report{}).Write(&out, "a.json", "b.json"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "same readings") {
+		t.Errorf("unexpected output for an empty report: %s", out.String())
+	}
+}
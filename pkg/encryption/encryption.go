@@ -0,0 +1,144 @@
+// Package encryption encrypts the readings the agent writes to disk, so that
+// the gathered metadata is never stored in plaintext on the node filesystem.
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// magic prefixes the data encrypted by a Cipher, followed by the ID of the key
+// and the nonce.
+var magic = []byte("PFENC1")
+
+const (
+	// KeySize is the size of the AES-256 keys.
+	KeySize = 32
+	// keyIDSize is the size of the key ID stored with the encrypted data.
+	keyIDSize = 8
+)
+
+// Config configures the encryption of the data written to disk.
+type Config struct {
+	// KeyFile is the path of the AES-256 key, e.g. mounted from a Secret. It
+	// holds 32 bytes, either raw or base64 or hex encoded.
+	KeyFile string `yaml:"key-file"`
+}
+
+// Validate validates the configuration.
+func (c *Config) Validate() error {
+	if c.KeyFile == "" {
+		return fmt.Errorf("key-file is required")
+	}
+	return nil
+}
+
+// NewCipher reads the key and returns the Cipher of the configuration. A nil
+// config returns a nil Cipher, which does not encrypt.
+func (c *Config) NewCipher() (*Cipher, error) {
+	if c == nil {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the encryption key: %v", err)
+	}
+	key, err := parseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key %s: %v", c.KeyFile, err)
+	}
+	return NewCipher(key)
+}
+
+// parseKey decodes a raw, base64 or hex encoded key.
+func parseKey(data []byte) ([]byte, error) {
+	if len(data) == KeySize {
+		return data, nil
+	}
+	trimmed := bytes.TrimSpace(data)
+	if key, err := base64.StdEncoding.DecodeString(string(trimmed)); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(string(trimmed)); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the key must be %d bytes, raw or base64 or hex encoded", KeySize)
+}
+
+// Cipher encrypts data with AES-256-GCM. A nil Cipher returns the data as is.
+type Cipher struct {
+	aead  cipher.AEAD
+	keyID []byte
+}
+
+// NewCipher returns a Cipher encrypting with the AES-256 key.
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("the key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(key)
+	return &Cipher{aead: aead, keyID: digest[:keyIDSize]}, nil
+}
+
+// Encrypt returns the encrypted data, prefixed by the ID of the key and a
+// random nonce.
+func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	if c == nil {
+		return plaintext, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(magic)+keyIDSize+len(nonce)+len(plaintext)+c.aead.Overhead())
+	out = append(out, magic...)
+	out = append(out, c.keyID...)
+	out = append(out, nonce...)
+	return c.aead.Seal(out, nonce, plaintext, magic), nil
+}
+
+// Decrypt returns the plaintext of data encrypted by Encrypt. It fails if the
+// data was encrypted with another key, or was modified.
+func (c *Cipher) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, fmt.Errorf("the data is not encrypted")
+	}
+	if c == nil {
+		return nil, fmt.Errorf("the data is encrypted, and no encryption key is configured")
+	}
+	data = data[len(magic):]
+	if len(data) < keyIDSize+c.aead.NonceSize() {
+		return nil, fmt.Errorf("the encrypted data is truncated")
+	}
+	if !bytes.Equal(data[:keyIDSize], c.keyID) {
+		return nil, fmt.Errorf("the data was encrypted with another key")
+	}
+	data = data[keyIDSize:]
+	nonce, ciphertext := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, magic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the data: %v", err)
+	}
+	return plaintext, nil
+}
+
+// IsEncrypted returns whether the data was encrypted by a Cipher.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
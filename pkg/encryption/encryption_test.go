@@ -0,0 +1,97 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCipher(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	plaintext := []byte(`[{"data-gatherer":"k8s/secrets"}]`)
+	encrypted, err := c.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !IsEncrypted(encrypted) || bytes.Contains(encrypted, []byte("k8s/secrets")) {
+		t.Fatalf("the data was not encrypted: %q", encrypted)
+	}
+	decrypted, err := c.Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("expected %q, got %q", plaintext, decrypted)
+	}
+
+	other, err := NewCipher(bytes.Repeat([]byte{2}, KeySize))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := other.Decrypt(encrypted); err == nil {
+		t.Fatalf("expected decrypting with another key to fail")
+	}
+
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := c.Decrypt(tampered); err == nil {
+		t.Fatalf("expected decrypting modified data to fail")
+	}
+
+	var none *Cipher
+	if out, err := none.Encrypt(plaintext); err != nil || !bytes.Equal(out, plaintext) {
+		t.Fatalf("expected a nil cipher to return the data as is, got %q %v", out, err)
+	}
+	if _, err := none.Decrypt(encrypted); err == nil {
+		t.Fatalf("expected decrypting without a key to fail")
+	}
+}
+
+func TestConfigNewCipher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := bytes.Repeat([]byte{3}, KeySize)
+	files := map[string][]byte{
+		"raw":    key,
+		"base64": []byte(base64.StdEncoding.EncodeToString(key) + "\n"),
+		"hex":    []byte(hex.EncodeToString(key) + "\n"),
+	}
+	want, err := NewCipher(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c, err := (&Config{KeyFile: path}).NewCipher()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if !bytes.Equal(c.keyID, want.keyID) {
+			t.Fatalf("%s: the key was not decoded", name)
+		}
+	}
+
+	short := filepath.Join(dir, "short")
+	if err := ioutil.WriteFile(short, []byte("too short"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := (&Config{KeyFile: short}).NewCipher(); err == nil {
+		t.Fatalf("expected an invalid key to fail")
+	}
+}
@@ -0,0 +1,74 @@
+// Package logs provides the leveled and structured logger of the agent, so
+// that the logs can be filtered by level and indexed by the log pipelines of
+// the clusters.
+package logs
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The fields identifying what a log entry is about, consistent across the
+// packages.
+const (
+	// DataGathererField is the name of the data gatherer.
+	DataGathererField = "datagatherer"
+	// ResourceField is the group, version and resource of a resource type.
+	ResourceField = "gvr"
+	// CycleField is the number of the cycle of the agent, starting at 1.
+	CycleField = "cycle"
+)
+
+const (
+	// FormatText is the human readable format, the default.
+	FormatText = "text"
+	// FormatJSON writes an object per line.
+	FormatJSON = "json"
+)
+
+// Log is the logger of the agent. It logs in the text format at the info
+// level until Setup is called.
+var Log = logrus.New()
+
+// Setup sets the level, e.g. debug or warn, and the format of the logs, text
+// or json. The logs of the standard logger, e.g. of the libraries, are
+// written by Log at the info level.
+func Setup(level, format string) error {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q, must be one of debug, info, warn or error", level)
+	}
+	switch format {
+	case FormatText:
+		Log.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case FormatJSON:
+		Log.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format %q, must be %s or %s", format, FormatText, FormatJSON)
+	}
+	Log.SetLevel(l)
+
+	log.SetFlags(0)
+	log.SetOutput(Log.WriterLevel(logrus.InfoLevel))
+	return nil
+}
+
+type contextKey struct{}
+
+// WithFields returns a context whose logger has the fields, along with the
+// ones of the parent context.
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return context.WithValue(ctx, contextKey{}, FromContext(ctx).WithFields(fields))
+}
+
+// FromContext returns the logger of the context, with the fields added by
+// WithFields, or Log.
+func FromContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(contextKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(Log)
+}
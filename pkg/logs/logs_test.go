@@ -0,0 +1,42 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSetup(t *testing.T) {
+	defer Log.SetOutput(Log.Out)
+	defer Log.SetFormatter(Log.Formatter)
+	defer Log.SetLevel(Log.Level)
+
+	if err := Setup("verbose", FormatText); err == nil {
+		t.Fatalf("expected an invalid level to fail")
+	}
+	if err := Setup("info", "xml"); err == nil {
+		t.Fatalf("expected an invalid format to fail")
+	}
+
+	if err := Setup("warn", FormatJSON); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var out bytes.Buffer
+	Log.SetOutput(&out)
+
+	ctx := WithFields(context.Background(), logrus.Fields{CycleField: 1})
+	ctx = WithFields(ctx, logrus.Fields{DataGathererField: "k8s/secrets"})
+	FromContext(ctx).Info("not logged below warn")
+	FromContext(ctx).Warn("stale data")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a single JSON entry, got %q: %v", out.String(), err)
+	}
+	if entry["msg"] != "stale data" || entry["level"] != "warning" || entry[CycleField] != float64(1) || entry[DataGathererField] != "k8s/secrets" {
+		t.Fatalf("unexpected entry %v", entry)
+	}
+}
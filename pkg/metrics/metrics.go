@@ -0,0 +1,211 @@
+// Package metrics provides the Prometheus metrics of the agent and its data
+// gatherers.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "preflight"
+
+var (
+	// DataGathererItems is the number of resources returned by the last
+	// successful fetch of each data gatherer.
+	DataGathererItems = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "datagatherer",
+		Name:      "items",
+		Help:      "Number of resources returned by the last successful fetch of the data gatherer.",
+	}, []string{"data_gatherer"})
+
+	// DataGathererFetchDuration is the duration of the fetches of each data
+	// gatherer, by result.
+	DataGathererFetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "datagatherer",
+		Name:      "fetch_duration_seconds",
+		Help:      "Duration of the fetches of the data gatherer.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+	}, []string{"data_gatherer", "result"})
+
+	// DataGathererFetchSize is the size of the data returned by the last
+	// successful fetch of each data gatherer, encoded as JSON.
+	DataGathererFetchSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "datagatherer",
+		Name:      "fetch_size_bytes",
+		Help:      "Size of the data returned by the last successful fetch of the data gatherer, encoded as JSON.",
+	}, []string{"data_gatherer"})
+
+	// DataGathererLastSuccess is the time of the last successful fetch of
+	// each data gatherer, to alert when gathering stops.
+	DataGathererLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "datagatherer",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful fetch of the data gatherer.",
+	}, []string{"data_gatherer"})
+
+	// DataGathererCacheBytes is the approximate size of the resources cached
+	// by each data gatherer, and CacheBytes their total.
+	DataGathererCacheBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "datagatherer",
+		Name:      "cache_bytes",
+		Help:      "Approximate size of the resources cached by the data gatherer.",
+	}, []string{"data_gatherer"})
+	CacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_bytes",
+		Help:      "Approximate size of the resources cached by all the data gatherers.",
+	})
+
+	// MemoryBudgetActions is the number of actions taken to bring the
+	// caches back within the memory budget, by action.
+	MemoryBudgetActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "memory_budget",
+		Name:      "actions_total",
+		Help:      "Number of actions taken as the caches exceeded the memory budget.",
+	}, []string{"action"})
+
+	// InformerResyncs is the number of periodic resyncs delivered by the
+	// informers of each resource type.
+	InformerResyncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "informer",
+		Name:      "resyncs_total",
+		Help:      "Number of resources delivered again by the periodic resyncs of the informer.",
+	}, []string{"resource_type"})
+
+	// UploadAttempts and UploadFailures count the uploads of readings to
+	// the backend, retries included.
+	UploadAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "attempts_total",
+		Help:      "Number of attempts to upload readings, retries included.",
+	})
+	UploadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upload",
+		Name:      "failures_total",
+		Help:      "Number of failed attempts to upload readings.",
+	})
+
+	// SpoolEntries is the number of uploads queued in the spool, and
+	// SpoolDropped the number of queued uploads dropped for being too old or
+	// beyond the size of the spool.
+	SpoolEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "spool",
+		Name:      "entries",
+		Help:      "Number of uploads queued in the spool.",
+	})
+	SpoolDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "spool",
+		Name:      "dropped_total",
+		Help:      "Number of queued uploads dropped for being too old or beyond the size of the spool.",
+	})
+
+	// CredentialReloads is the number of reloads of each credential of the
+	// agent once rotated, by result.
+	CredentialReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "credentials",
+		Name:      "reloads_total",
+		Help:      "Number of reloads of the credential after it changed.",
+	}, []string{"credential", "result"})
+
+	// ConfigReloads is the number of reloads of the configuration of the
+	// agent, by result.
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "config",
+		Name:      "reloads_total",
+		Help:      "Number of reloads of the configuration file.",
+	}, []string{"result"})
+)
+
+// registry holds the metrics of the agent, along with the process and Go
+// runtime metrics.
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+		DataGathererItems,
+		DataGathererFetchDuration,
+		DataGathererFetchSize,
+		DataGathererLastSuccess,
+		DataGathererCacheBytes,
+		CacheBytes,
+		MemoryBudgetActions,
+		InformerResyncs,
+		UploadAttempts,
+		UploadFailures,
+		SpoolEntries,
+		SpoolDropped,
+		CredentialReloads,
+		ConfigReloads,
+	)
+}
+
+// Handler returns the handler serving the metrics in the Prometheus text
+// format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveFetch records a fetch of the data gatherer. The items and size are
+// only recorded for successful fetches, size is ignored if negative.
+func ObserveFetch(dataGatherer string, duration time.Duration, items int, size int64, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	DataGathererFetchDuration.WithLabelValues(dataGatherer, result).Observe(duration.Seconds())
+	if err != nil {
+		return
+	}
+	DataGathererItems.WithLabelValues(dataGatherer).Set(float64(items))
+	DataGathererLastSuccess.WithLabelValues(dataGatherer).Set(float64(time.Now().Unix()))
+	if size >= 0 {
+		DataGathererFetchSize.WithLabelValues(dataGatherer).Set(float64(size))
+	}
+}
+
+// ObserveCacheSizes records the approximate size of the cache of each data
+// gatherer, and their total.
+func ObserveCacheSizes(sizes map[string]int64) {
+	var total int64
+	for dataGatherer, size := range sizes {
+		DataGathererCacheBytes.WithLabelValues(dataGatherer).Set(float64(size))
+		total += size
+	}
+	CacheBytes.Set(float64(total))
+}
+
+// ObserveCredentialReload records a reload of the credential.
+func ObserveCredentialReload(credential string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	CredentialReloads.WithLabelValues(credential, result).Inc()
+}
+
+// ObserveConfigReload records a reload of the configuration.
+func ObserveConfigReload(err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	ConfigReloads.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveFetch(t *testing.T) {
+	ObserveFetch("test/success", time.Second, 3, 1024, nil)
+	if v := testutil.ToFloat64(DataGathererItems.WithLabelValues("test/success")); v != 3 {
+		t.Errorf("expected 3 items, got %v", v)
+	}
+	if v := testutil.ToFloat64(DataGathererFetchSize.WithLabelValues("test/success")); v != 1024 {
+		t.Errorf("expected 1024 bytes, got %v", v)
+	}
+	if v := testutil.ToFloat64(DataGathererLastSuccess.WithLabelValues("test/success")); v == 0 {
+		t.Errorf("expected the last success to be set")
+	}
+
+	ObserveFetch("test/error", time.Second, 3, 1024, fmt.Errorf("boom"))
+	if n := testutil.CollectAndCount(DataGathererItems); n != 1 {
+		t.Errorf("expected the items of failed fetches not to be recorded, got %d series", n)
+	}
+
+	resp := httptest.NewRecorder()
+	Handler().ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, expected := range []string{
+		`preflight_datagatherer_fetch_duration_seconds_count{data_gatherer="test/error",result="error"} 1`,
+		`preflight_datagatherer_fetch_duration_seconds_count{data_gatherer="test/success",result="success"} 1`,
+		`preflight_datagatherer_items{data_gatherer="test/success"} 3`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", expected, body)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/transport"
+)
+
+// azureStorageScope is the scope of the tokens used for the Blob API.
+const azureStorageScope = "https://storage.azure.com/.default"
+
+// azureStorageVersion is the version of the Blob API used.
+const azureStorageVersion = "2020-04-08"
+
+// defaultAzureAuthorityHost is the Azure AD endpoint of the public cloud.
+const defaultAzureAuthorityHost = "https://login.microsoftonline.com/"
+
+// AzureBlobConfig is the configuration for an Azure Blob Output. Azure Blob
+// outputs write the data readings of every cycle as a blob of a container.
+//
+// The output authenticates with Azure AD workload identity, the federated
+// token of the pod is exchanged for an access token of the Azure AD
+// application. The client ID, tenant ID, token file and authority host
+// default to the AZURE_CLIENT_ID, AZURE_TENANT_ID, AZURE_FEDERATED_TOKEN_FILE
+// and AZURE_AUTHORITY_HOST environment variables set by the workload identity
+// webhook.
+type AzureBlobConfig struct {
+	// Account is the name of the storage account.
+	Account string `yaml:"account"`
+	// Container is the name of the container.
+	Container string `yaml:"container"`
+	// Prefix is prepended to the names of the blobs.
+	Prefix string `yaml:"prefix"`
+	// Endpoint is the Blob API endpoint of the account, it defaults to
+	// https://<account>.blob.core.windows.net.
+	Endpoint string `yaml:"endpoint"`
+	// Timeout is the maximum time a write can take, defaults to 1 minute.
+	Timeout time.Duration `yaml:"timeout"`
+	// ClientID is the client ID of the Azure AD application.
+	ClientID string `yaml:"client-id"`
+	// TenantID is the ID of the Azure AD tenant of the application.
+	TenantID string `yaml:"tenant-id"`
+	// FederatedTokenFile is the path of the federated token of the pod.
+	FederatedTokenFile string `yaml:"federated-token-file"`
+	// AuthorityHost is the Azure AD endpoint.
+	AuthorityHost string `yaml:"authority-host"`
+}
+
+// withDefaults returns the configuration with the unset workload identity
+// settings read from the environment.
+func (c AzureBlobConfig) withDefaults() AzureBlobConfig {
+	defaults := []struct {
+		value *string
+		env   string
+	}{
+		{&c.ClientID, "AZURE_CLIENT_ID"},
+		{&c.TenantID, "AZURE_TENANT_ID"},
+		{&c.FederatedTokenFile, "AZURE_FEDERATED_TOKEN_FILE"},
+		{&c.AuthorityHost, "AZURE_AUTHORITY_HOST"},
+	}
+	for _, d := range defaults {
+		if *d.value == "" {
+			*d.value = os.Getenv(d.env)
+		}
+	}
+	if c.AuthorityHost == "" {
+		c.AuthorityHost = defaultAzureAuthorityHost
+	}
+	if c.Endpoint == "" && c.Account != "" {
+		c.Endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", c.Account)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultObjectTimeout
+	}
+	return c
+}
+
+func (c *AzureBlobConfig) validate() error {
+	if c.Account == "" && c.Endpoint == "" {
+		return fmt.Errorf("invalid configuration: either account or endpoint must be set")
+	}
+	if c.Container == "" {
+		return fmt.Errorf("invalid configuration: container must be set")
+	}
+	if c.ClientID == "" || c.TenantID == "" || c.FederatedTokenFile == "" {
+		return fmt.Errorf("invalid configuration: client-id, tenant-id and federated-token-file must be set, or given by workload identity")
+	}
+	return nil
+}
+
+// NewOutput returns a new Azure Blob Output.
+func (c *AzureBlobConfig) NewOutput() (Output, error) {
+	cfg := c.withDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	client := transport.Client(0)
+	return &AzureBlob{
+		client:    client,
+		endpoint:  strings.TrimSuffix(cfg.Endpoint, "/"),
+		container: cfg.Container,
+		prefix:    cfg.Prefix,
+		timeout:   cfg.Timeout,
+		tokens: &azureTokenSource{
+			client:        client,
+			clientID:      cfg.ClientID,
+			tenantID:      cfg.TenantID,
+			tokenFile:     cfg.FederatedTokenFile,
+			authorityHost: cfg.AuthorityHost,
+		},
+	}, nil
+}
+
+// AzureBlob is an Output that writes data readings to an Azure Blob
+// container.
+type AzureBlob struct {
+	client    *http.Client
+	endpoint  string
+	container string
+	prefix    string
+	timeout   time.Duration
+	tokens    *azureTokenSource
+}
+
+// Write puts the readings in a new block blob of the container.
+func (o *AzureBlob) Write(payload *api.DataReadingsPost) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal readings: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	token, err := o.tokens.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	name := objectName(o.prefix, payload)
+	blobURL := fmt.Sprintf("%s/%s/%s", o.endpoint, url.PathEscape(o.container), name)
+	req, err := http.NewRequest(http.MethodPut, blobURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("x-ms-version", azureStorageVersion)
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", blobURL, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("failed to write %s: received response with status code %d: %s", blobURL, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// azureTokenSource exchanges the federated token of the pod for access
+// tokens of the Azure AD application, and caches them until they expire.
+type azureTokenSource struct {
+	client        *http.Client
+	clientID      string
+	tenantID      string
+	tokenFile     string
+	authorityHost string
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+// azureTokenResponse is the response of the Azure AD token endpoint.
+type azureTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// token returns a valid access token. The federated token is read on every
+// exchange, as it is rotated by the kubelet.
+func (s *azureTokenSource) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accessToken != "" && time.Now().Add(time.Minute).Before(s.expiry) {
+		return s.accessToken, nil
+	}
+
+	assertion, err := ioutil.ReadFile(s.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read federated token: %v", err)
+	}
+
+	form := url.Values{
+		"client_id":             {s.clientID},
+		"scope":                 {azureStorageScope},
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+	}
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(s.authorityHost, "/"), url.PathEscape(s.tenantID))
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Azure AD token: %v", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Azure AD token: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get Azure AD token: received response with status code %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response azureTokenResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to parse Azure AD token: %v", err)
+	}
+	if response.AccessToken == "" {
+		return "", fmt.Errorf("failed to get Azure AD token: the response has no access token")
+	}
+
+	s.accessToken = response.AccessToken
+	s.expiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	return s.accessToken, nil
+}
@@ -0,0 +1,98 @@
+package output
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestAzureBlobWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("federated-token\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exchanges := 0
+	blobs := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/tenant/oauth2/v2.0/token":
+			exchanges++
+			if err := r.ParseForm(); err != nil || r.PostForm.Get("client_assertion") != "federated-token" || r.PostForm.Get("client_id") != "client" {
+				http.Error(w, "invalid assertion", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"access_token": "access-token", "expires_in": 3600}`))
+		case r.Method == http.MethodPut:
+			if r.Header.Get("Authorization") != "Bearer access-token" || r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+				http.Error(w, "unauthorized", http.StatusForbidden)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			blobs[r.URL.Path] = string(body)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &AzureBlobConfig{
+		Endpoint:           server.URL,
+		Container:          "readings",
+		Prefix:             "agent",
+		ClientID:           "client",
+		TenantID:           "tenant",
+		FederatedTokenFile: tokenFile,
+		AuthorityHost:      server.URL,
+	}
+	out, err := cfg.NewOutput()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	gatherTime := time.Date(2021, time.March, 16, 18, 22, 15, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		err := out.Write(&api.DataReadingsPost{
+			AgentMetadata:  &api.AgentMetadata{ClusterID: "prod"},
+			DataGatherTime: gatherTime.Add(time.Duration(i) * time.Minute),
+			DataReadings:   []*api.DataReading{{DataGatherer: "dummy"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if exchanges != 1 {
+		t.Errorf("expected the access token to be cached, got %d exchanges", exchanges)
+	}
+	blob, ok := blobs["/readings/agent/prod/20210316T182215Z.json"]
+	if !ok || len(blobs) != 2 {
+		t.Fatalf("expected the readings to be written, got blobs %v", blobs)
+	}
+	if !strings.Contains(blob, `"data-gatherer":"dummy"`) {
+		t.Errorf("unexpected blob: %s", blob)
+	}
+}
+
+func TestAzureBlobConfigValidate(t *testing.T) {
+	os.Unsetenv("AZURE_CLIENT_ID")
+	if _, err := (&AzureBlobConfig{Account: "account", Container: "readings", TenantID: "tenant", FederatedTokenFile: "token"}).NewOutput(); err == nil {
+		t.Errorf("expected error for missing client ID")
+	}
+	if _, err := (&AzureBlobConfig{Account: "account"}).NewOutput(); err == nil {
+		t.Errorf("expected error for missing container")
+	}
+}
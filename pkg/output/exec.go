@@ -0,0 +1,165 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// pluginPrefix is prepended to the plugin name to find the plugin binary when
+// no command is configured.
+const pluginPrefix = "preflight-output-"
+
+// ExecConfig is the configuration for an exec Output. Exec outputs run an
+// external binary for every write, allowing third parties to ship custom
+// destinations without forking the agent.
+//
+// The plugin receives the data readings as JSON, in the same format used to
+// upload them to the backend, on its standard input. It must exit with status
+// 0 on success. It can optionally print a JSON response to its standard
+// output: {"status": "ok|error", "message": "..."}.
+type ExecConfig struct {
+	// Plugin is the name of the plugin. If Command is empty, the binary
+	// preflight-output-<plugin> is looked up in PluginDir, or in PATH if
+	// PluginDir is empty.
+	Plugin string `yaml:"plugin"`
+	// PluginDir is the directory where plugins are discovered.
+	PluginDir string `yaml:"plugin-dir"`
+	// Command is the path to the plugin binary.
+	Command string `yaml:"command"`
+	// Args are passed to the plugin binary.
+	Args []string `yaml:"args"`
+	// Env are additional environment variables for the plugin, in KEY=VALUE
+	// format.
+	Env []string `yaml:"env"`
+	// Timeout is the maximum time a write can take, defaults to 1 minute.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c *ExecConfig) validate() error {
+	if c.Command == "" && c.Plugin == "" {
+		return fmt.Errorf("invalid configuration: either command or plugin must be set")
+	}
+	if c.Plugin != "" && strings.ContainsAny(c.Plugin, `/\`) {
+		return fmt.Errorf("invalid configuration: plugin name %q cannot contain path separators", c.Plugin)
+	}
+	for _, e := range c.Env {
+		if !strings.Contains(e, "=") {
+			return fmt.Errorf("invalid configuration: env %q must be in KEY=VALUE format", e)
+		}
+	}
+	return nil
+}
+
+// resolveCommand returns the path of the plugin binary.
+func (c *ExecConfig) resolveCommand() (string, error) {
+	if c.Command != "" {
+		return c.Command, nil
+	}
+
+	name := pluginPrefix + c.Plugin
+	if c.PluginDir == "" {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to find plugin %q in PATH: %v", c.Plugin, err)
+		}
+		return path, nil
+	}
+
+	path := filepath.Join(c.PluginDir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to find plugin %q in %s: %v", c.Plugin, c.PluginDir, err)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return "", fmt.Errorf("plugin %q at %s is not executable", c.Plugin, path)
+	}
+	return path, nil
+}
+
+// NewOutput returns a new exec Output. It resolves the plugin binary.
+func (c *ExecConfig) NewOutput() (Output, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	command, err := c.resolveCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+
+	return &Exec{
+		command: command,
+		args:    c.Args,
+		env:     c.Env,
+		timeout: timeout,
+	}, nil
+}
+
+// Exec is an Output that writes data readings to an external plugin binary.
+type Exec struct {
+	command string
+	args    []string
+	env     []string
+	timeout time.Duration
+}
+
+// execResponse is the optional response printed by plugins.
+type execResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// Write runs the plugin with the readings on its standard input.
+func (o *Exec) Write(payload *api.DataReadingsPost) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal readings: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, o.command, o.args...)
+	cmd.Env = append(os.Environ(), o.env...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("plugin %s timed out after %s", o.command, o.timeout)
+		}
+		return fmt.Errorf("plugin %s failed: %v: %s", o.command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	output := bytes.TrimSpace(stdout.Bytes())
+	if len(output) == 0 {
+		return nil
+	}
+
+	var response execResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		// plugins are not required to print a response
+		return nil
+	}
+	if response.Status == "error" {
+		return fmt.Errorf("plugin %s returned an error: %s", o.command, response.Message)
+	}
+
+	return nil
+}
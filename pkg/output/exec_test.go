@@ -0,0 +1,94 @@
+package output
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestExecWrite(t *testing.T) {
+	tests := map[string]struct {
+		script      string
+		timeout     time.Duration
+		expectedErr string
+	}{
+		"plugin succeeds without a response": {
+			script: "cat > /dev/null",
+		},
+		"plugin succeeds with a response": {
+			script: `cat > /dev/null; echo '{"status": "ok"}'`,
+		},
+		"plugin reports an error": {
+			script:      `cat > /dev/null; echo '{"status": "error", "message": "ticket queue full"}'`,
+			expectedErr: "ticket queue full",
+		},
+		"plugin exits with non zero status": {
+			script:      "cat > /dev/null; echo 'boom' >&2; exit 3",
+			expectedErr: "boom",
+		},
+		"plugin times out": {
+			script:      "sleep 5",
+			timeout:     100 * time.Millisecond,
+			expectedErr: "timed out",
+		},
+		"plugin reads the readings": {
+			script: `grep -q '"data-gatherer":"dummy"'`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := &ExecConfig{
+				Command: "/bin/sh",
+				Args:    []string{"-c", tc.script},
+				Timeout: tc.timeout,
+			}
+			out, err := cfg.NewOutput()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = out.Write(&api.DataReadingsPost{
+				DataReadings: []*api.DataReading{{DataGatherer: "dummy"}},
+			})
+			if tc.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+				t.Fatalf("expected error containing %q, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestExecPluginDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, pluginPrefix+"ticketing")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\ncat > /dev/null\n"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg := &ExecConfig{Plugin: "ticketing", PluginDir: dir}
+	command, err := cfg.resolveCommand()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if command != path {
+		t.Errorf("unexpected command: got=%q want=%q", command, path)
+	}
+
+	cfg = &ExecConfig{Plugin: "missing", PluginDir: dir}
+	if _, err := cfg.resolveCommand(); err == nil {
+		t.Errorf("expected error for missing plugin")
+	}
+}
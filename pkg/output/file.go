@@ -0,0 +1,129 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/encryption"
+)
+
+// FileConfig is the configuration for a file Output. File outputs write the
+// data readings bundle to disk, so it can be transferred out-of-band from
+// air-gapped clusters and uploaded later.
+type FileConfig struct {
+	// Path is the file the readings are written to.
+	Path string `yaml:"path"`
+	// MaxBackups is the number of previous bundles kept when the file is
+	// rotated, as <path>.1 to <path>.<max-backups>, the most recent first.
+	// The file is overwritten on every write if it is 0.
+	MaxBackups int `yaml:"max-backups"`
+	// Encryption encrypts the bundles with a key, e.g. mounted from a
+	// Secret, so that the readings are not written to disk in plaintext.
+	Encryption *encryption.Config `yaml:"encryption"`
+}
+
+func (c *FileConfig) validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("invalid configuration: path must be set")
+	}
+	if c.MaxBackups < 0 {
+		return fmt.Errorf("invalid configuration: max-backups cannot be negative")
+	}
+	if c.Encryption != nil {
+		if err := c.Encryption.Validate(); err != nil {
+			return fmt.Errorf("invalid configuration: encryption.%v", err)
+		}
+	}
+	return nil
+}
+
+// NewOutput returns a new file Output.
+func (c *FileConfig) NewOutput() (Output, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	cipher, err := c.Encryption.NewCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		path:       c.Path,
+		maxBackups: c.MaxBackups,
+		cipher:     cipher,
+	}, nil
+}
+
+// File is an Output that writes data readings to a file.
+type File struct {
+	path       string
+	maxBackups int
+	// cipher encrypts the bundles, it is nil if they are not encrypted.
+	cipher *encryption.Cipher
+}
+
+// Write rotates the previous bundles and writes the readings to the file. The
+// file is replaced atomically, readers never see a partial bundle.
+func (o *File) Write(payload *api.DataReadingsPost) error {
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal readings: %v", err)
+	}
+	if data, err = o.cipher.Encrypt(data); err != nil {
+		return fmt.Errorf("failed to encrypt readings: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(o.path), filepath.Base(o.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", tmp.Name(), err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", tmp.Name(), err)
+	}
+
+	if err := o.rotate(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return fmt.Errorf("failed to write %s: %v", o.path, err)
+	}
+	return nil
+}
+
+// rotate shifts the previous bundles by one, discarding the oldest.
+func (o *File) rotate() error {
+	if o.maxBackups == 0 {
+		return nil
+	}
+	for i := o.maxBackups - 1; i >= 0; i-- {
+		from := o.backupPath(i)
+		if _, err := os.Stat(from); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(from, o.backupPath(i+1)); err != nil {
+			return fmt.Errorf("failed to rotate %s: %v", from, err)
+		}
+	}
+	return nil
+}
+
+// backupPath is the path of the i-th previous bundle, 0 being the current one.
+func (o *File) backupPath(i int) string {
+	if i == 0 {
+		return o.path
+	}
+	return fmt.Sprintf("%s.%d", o.path, i)
+}
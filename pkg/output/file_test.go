@@ -0,0 +1,120 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/encryption"
+)
+
+func TestFileWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-output")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "readings.json")
+	cfg := &FileConfig{Path: path, MaxBackups: 2}
+	out, err := cfg.NewOutput()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"first", "second", "third", "fourth"} {
+		err := out.Write(&api.DataReadingsPost{
+			DataReadings: []*api.DataReading{{DataGatherer: name}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for path, expected := range map[string]string{
+		path:        "fourth",
+		path + ".1": "third",
+		path + ".2": "second",
+	} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var payload api.DataReadingsPost
+		if err := json.Unmarshal(data, &payload); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := payload.DataReadings[0].DataGatherer; got != expected {
+			t.Errorf("unexpected readings in %s: got=%q want=%q", path, got, expected)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 3 {
+		t.Errorf("expected the oldest bundle to be discarded, got %d files", len(files))
+	}
+}
+
+func TestFileWriteEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-output")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(keyFile, bytes.Repeat([]byte{1}, encryption.KeySize), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	path := filepath.Join(dir, "readings.json")
+	cfg := &FileConfig{Path: path, Encryption: &encryption.Config{KeyFile: keyFile}}
+	out, err := cfg.NewOutput()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = out.Write(&api.DataReadingsPost{
+		DataReadings: []*api.DataReading{{DataGatherer: "k8s/secrets"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(data, []byte("k8s/secrets")) {
+		t.Fatalf("the bundle was written in plaintext")
+	}
+	cipher, err := cfg.Encryption.NewCipher()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plaintext, err := cipher.Decrypt(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var payload api.DataReadingsPost
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := payload.DataReadings[0].DataGatherer; got != "k8s/secrets" {
+		t.Errorf("unexpected readings: %q", got)
+	}
+}
+
+func TestFileConfigValidate(t *testing.T) {
+	if _, err := (&FileConfig{}).NewOutput(); err == nil {
+		t.Errorf("expected error for missing path")
+	}
+	if _, err := (&FileConfig{Path: "readings.json", MaxBackups: -1}).NewOutput(); err == nil {
+		t.Errorf("expected error for negative max-backups")
+	}
+}
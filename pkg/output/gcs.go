@@ -0,0 +1,116 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/transport"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// GCSConfig is the configuration for a GCS Output. GCS outputs write the data
+// readings of every cycle as an object of a bucket.
+type GCSConfig struct {
+	// Bucket is the name of the bucket.
+	Bucket string `yaml:"bucket"`
+	// Prefix is prepended to the names of the objects.
+	Prefix string `yaml:"prefix"`
+	// Timeout is the maximum time a write can take, defaults to 1 minute.
+	Timeout time.Duration `yaml:"timeout"`
+	// CredentialsPath is the path to the JSON file containing the
+	// credentials to authenticate against the GCS API. The default
+	// credentials are used if empty.
+	CredentialsPath string `yaml:"credentials-path"`
+	// WorkloadIdentity makes the output authenticate with the token of the
+	// GKE metadata server, given to the pod by Workload Identity, rather
+	// than looking up the default credentials.
+	WorkloadIdentity bool `yaml:"workload-identity"`
+}
+
+func (c *GCSConfig) validate() error {
+	if c.Bucket == "" {
+		return fmt.Errorf("invalid configuration: bucket must be set")
+	}
+	if c.WorkloadIdentity && c.CredentialsPath != "" {
+		return fmt.Errorf("invalid configuration: workload-identity and credentials-path cannot be used at the same time")
+	}
+	return nil
+}
+
+// NewOutput returns a new GCS Output.
+func (c *GCSConfig) NewOutput() (Output, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	var credsOpt option.ClientOption
+	switch {
+	case c.WorkloadIdentity:
+		credsOpt = option.WithTokenSource(google.ComputeTokenSource("", storage.DevstorageReadWriteScope))
+	case c.CredentialsPath != "":
+		credsOpt = option.WithCredentialsFile(c.CredentialsPath)
+	default:
+		creds, err := google.FindDefaultCredentials(context.Background(), storage.DevstorageReadWriteScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain credentials for Google Cloud Platform: %v", err)
+		}
+		credsOpt = option.WithCredentials(creds)
+	}
+
+	clientOpt, err := transport.GoogleClientOption(context.Background(), credsOpt, option.WithScopes(storage.DevstorageReadWriteScope))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Google Cloud Storage API: %v", err)
+	}
+	service, err := storage.NewService(context.Background(), clientOpt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Google Cloud Storage API: %v", err)
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultObjectTimeout
+	}
+
+	return &GCS{
+		objects: service.Objects,
+		bucket:  c.Bucket,
+		prefix:  c.Prefix,
+		timeout: timeout,
+	}, nil
+}
+
+// GCS is an Output that writes data readings to a GCS bucket.
+type GCS struct {
+	objects *storage.ObjectsService
+	bucket  string
+	prefix  string
+	timeout time.Duration
+}
+
+// Write inserts the readings as a new object of the bucket.
+func (o *GCS) Write(payload *api.DataReadingsPost) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal readings: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	name := objectName(o.prefix, payload)
+	object := &storage.Object{
+		Name:        name,
+		ContentType: "application/json",
+	}
+	_, err = o.objects.Insert(o.bucket, object).Media(bytes.NewReader(data)).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to write gs://%s/%s: %v", o.bucket, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/transport"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// defaultKafkaMaxMessageBytes is the default maximum size of the messages,
+// the default maximum of the brokers.
+const defaultKafkaMaxMessageBytes = 1024 * 1024
+
+// KafkaConfig is the configuration for a Kafka Output. Kafka outputs produce
+// every data reading, or every changed resource of the readings in
+// incremental mode, as a record of a Kafka topic, with the Kafka client.
+type KafkaConfig struct {
+	// Brokers are the addresses of the brokers the client bootstraps from,
+	// e.g. kafka-0.kafka:9092.
+	Brokers []string `yaml:"brokers"`
+	// Topic is the topic the records are produced to.
+	Topic string `yaml:"topic"`
+	// TLS connects to the brokers with TLS.
+	TLS bool `yaml:"tls"`
+	// SASL, if set, authenticates to the brokers.
+	SASL *KafkaSASL `yaml:"sasl"`
+	// Encoding is the serialization of the records: json or
+	// protobuf-struct, defaults to json.
+	Encoding string `yaml:"encoding"`
+	// MaxMessageBytes is the maximum size of a record, defaults to 1 MiB.
+	MaxMessageBytes int `yaml:"max-message-bytes"`
+	// Timeout is the maximum time a write can take, defaults to 1 minute.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// KafkaSASL is the SASL authentication to the Kafka brokers.
+type KafkaSASL struct {
+	// Mechanism is plain, scram-sha-256 or scram-sha-512.
+	Mechanism string `yaml:"mechanism"`
+	// Username is the user to authenticate as.
+	Username string `yaml:"username"`
+	// PasswordFile is the path of the file holding the password.
+	PasswordFile string `yaml:"password-file"`
+}
+
+func (c *KafkaConfig) validate() error {
+	if len(c.Brokers) == 0 {
+		return fmt.Errorf("invalid configuration: brokers must be set")
+	}
+	if c.Topic == "" {
+		return fmt.Errorf("invalid configuration: topic must be set")
+	}
+	if c.MaxMessageBytes < 0 {
+		return fmt.Errorf("invalid configuration: max-message-bytes cannot be negative")
+	}
+	if c.SASL != nil {
+		switch c.SASL.Mechanism {
+		case "plain", "scram-sha-256", "scram-sha-512":
+		default:
+			return fmt.Errorf("invalid configuration: sasl mechanism %q is not supported, use plain, scram-sha-256 or scram-sha-512", c.SASL.Mechanism)
+		}
+		if c.SASL.Username == "" || c.SASL.PasswordFile == "" {
+			return fmt.Errorf("invalid configuration: sasl username and password-file must be set")
+		}
+	}
+	return validateEncoding(c.Encoding)
+}
+
+// mechanism returns the SASL mechanism, with the password of the file.
+func (s *KafkaSASL) mechanism() (sasl.Mechanism, error) {
+	data, err := ioutil.ReadFile(s.PasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Kafka password: %v", err)
+	}
+	password := strings.TrimSpace(string(data))
+	switch s.Mechanism {
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, s.Username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, s.Username, password)
+	}
+	return plain.Mechanism{Username: s.Username, Password: password}, nil
+}
+
+// NewOutput returns a new Kafka Output.
+func (c *KafkaConfig) NewOutput() (Output, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	maxMessageBytes := c.MaxMessageBytes
+	if maxMessageBytes == 0 {
+		maxMessageBytes = defaultKafkaMaxMessageBytes
+	}
+
+	kafkaTransport := &kafka.Transport{ClientID: "preflight-agent"}
+	if c.TLS {
+		// the configured certificate authorities are trusted
+		kafkaTransport.TLS = transport.TLSConfig()
+	}
+	if c.SASL != nil {
+		mechanism, err := c.SASL.mechanism()
+		if err != nil {
+			return nil, err
+		}
+		kafkaTransport.SASL = mechanism
+	}
+
+	return &Kafka{
+		writer: &kafka.Writer{
+			Addr:  kafka.TCP(c.Brokers...),
+			Topic: c.Topic,
+			// the records of a data gatherer share a key, they are kept in
+			// order on a partition
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchBytes:   int64(maxMessageBytes),
+			// the records of a cycle are all produced at once, there is no
+			// point in waiting for more
+			BatchTimeout: 10 * time.Millisecond,
+			Transport:    kafkaTransport,
+		},
+		encoding: c.Encoding,
+		timeout:  timeout,
+	}, nil
+}
+
+// Kafka is an Output that produces data readings to a Kafka topic. The
+// client keeps its connections to the brokers across the writes, and
+// connects again to the brokers whose connection failed.
+type Kafka struct {
+	writer   *kafka.Writer
+	encoding string
+	timeout  time.Duration
+}
+
+// Write produces the readings to the topic, and waits for all the in-sync
+// replicas to acknowledge them.
+func (o *Kafka) Write(payload *api.DataReadingsPost) error {
+	records, err := streamRecords(o.encoding, payload)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	messages := make([]kafka.Message, 0, len(records))
+	for _, r := range records {
+		messages = append(messages, kafka.Message{Key: []byte(r.key), Value: r.value})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	if err := o.writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("failed to produce records: %v", err)
+	}
+	return nil
+}
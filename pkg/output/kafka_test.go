@@ -0,0 +1,71 @@
+package output
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestKafkaNewOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafka")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg := &KafkaConfig{
+		Brokers: []string{"kafka-0:9092", "kafka-1:9092"},
+		Topic:   "agent-readings",
+		TLS:     true,
+		SASL:    &KafkaSASL{Mechanism: "scram-sha-512", Username: "agent", PasswordFile: passwordFile},
+	}
+	out, err := cfg.NewOutput()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writer := out.(*Kafka).writer
+	if writer.Addr.String() != "kafka-0:9092,kafka-1:9092" || writer.Topic != "agent-readings" {
+		t.Errorf("unexpected brokers or topic: %s %s", writer.Addr, writer.Topic)
+	}
+	if _, ok := writer.Balancer.(*kafka.Hash); !ok {
+		t.Errorf("expected the records to be partitioned by key, got %T", writer.Balancer)
+	}
+	if writer.BatchBytes != defaultKafkaMaxMessageBytes {
+		t.Errorf("unexpected maximum message size: %d", writer.BatchBytes)
+	}
+	kafkaTransport := writer.Transport.(*kafka.Transport)
+	if kafkaTransport.TLS == nil {
+		t.Errorf("expected the connections to use TLS")
+	}
+	if kafkaTransport.SASL == nil || kafkaTransport.SASL.Name() != "SCRAM-SHA-512" {
+		t.Errorf("expected the SCRAM-SHA-512 authentication, got %v", kafkaTransport.SASL)
+	}
+
+	cfg.SASL.PasswordFile = filepath.Join(dir, "missing")
+	if _, err := cfg.NewOutput(); err == nil {
+		t.Errorf("expected error for a missing password file")
+	}
+}
+
+func TestKafkaConfigValidate(t *testing.T) {
+	for _, c := range []KafkaConfig{
+		{},
+		{Brokers: []string{"kafka:9092"}},
+		{Brokers: []string{"kafka:9092"}, Topic: "readings", MaxMessageBytes: -1},
+		{Brokers: []string{"kafka:9092"}, Topic: "readings", SASL: &KafkaSASL{Mechanism: "gssapi", Username: "agent", PasswordFile: "password"}},
+		{Brokers: []string{"kafka:9092"}, Topic: "readings", SASL: &KafkaSASL{Mechanism: "plain"}},
+		{Brokers: []string{"kafka:9092"}, Topic: "readings", Encoding: "avro"},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+}
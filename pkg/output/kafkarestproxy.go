@@ -0,0 +1,160 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/transport"
+)
+
+// kafkaContentType is the content type of the records produced through the
+// REST proxy, keys and values are base64 encoded bytes.
+const kafkaContentType = "application/vnd.kafka.binary.v2+json"
+
+// KafkaRESTProxyConfig is the configuration for a Kafka REST Proxy Output.
+// Kafka REST Proxy outputs publish every data reading, or every changed
+// resource of the readings in incremental mode, as a record of a Kafka topic.
+// The output does not speak the Kafka protocol: the records are produced
+// with the HTTP API of a Confluent REST Proxy (v2), which handles the
+// connections to the brokers.
+type KafkaRESTProxyConfig struct {
+	// RESTProxyURL is the URL of the REST Proxy. Credentials for basic
+	// authentication can be given in the URL.
+	RESTProxyURL string `yaml:"rest-proxy-url"`
+	// Topic is the topic the records are produced to.
+	Topic string `yaml:"topic"`
+	// Encoding is the serialization of the records: json or
+	// protobuf-struct, defaults to json.
+	Encoding string `yaml:"encoding"`
+	// Timeout is the maximum time a write can take, defaults to 1 minute.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c *KafkaRESTProxyConfig) validate() error {
+	if c.RESTProxyURL == "" {
+		return fmt.Errorf("invalid configuration: rest-proxy-url must be set")
+	}
+	if _, err := url.Parse(c.RESTProxyURL); err != nil {
+		return fmt.Errorf("invalid configuration: invalid rest-proxy-url: %v", err)
+	}
+	if c.Topic == "" {
+		return fmt.Errorf("invalid configuration: topic must be set")
+	}
+	return validateEncoding(c.Encoding)
+}
+
+// NewOutput returns a new Kafka REST Proxy Output.
+func (c *KafkaRESTProxyConfig) NewOutput() (Output, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+
+	return &KafkaRESTProxy{
+		client:   transport.Client(0),
+		url:      fmt.Sprintf("%s/topics/%s", strings.TrimSuffix(c.RESTProxyURL, "/"), url.PathEscape(c.Topic)),
+		encoding: c.Encoding,
+		timeout:  timeout,
+	}, nil
+}
+
+// KafkaRESTProxy is an Output that produces data readings to a Kafka topic
+// through a REST Proxy.
+type KafkaRESTProxy struct {
+	client   *http.Client
+	url      string
+	encoding string
+	timeout  time.Duration
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type kafkaProduceRequest struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+// kafkaProduceResponse reports the result of each record produced.
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+// Write produces the readings to the topic, in a single request.
+func (o *KafkaRESTProxy) Write(payload *api.DataReadingsPost) error {
+	records, err := streamRecords(o.encoding, payload)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	request := kafkaProduceRequest{}
+	for _, r := range records {
+		request.Records = append(request.Records, kafkaRecord{
+			Key:   base64.StdEncoding.EncodeToString([]byte(r.key)),
+			Value: base64.StdEncoding.EncodeToString(r.value),
+		})
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal records: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to produce records: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to produce records: received response with status code %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response kafkaProduceResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		// the records were accepted
+		return nil
+	}
+	failed := 0
+	lastError := ""
+	for _, offset := range response.Offsets {
+		if offset.ErrorCode != nil {
+			failed++
+			lastError = offset.Error
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to produce %d/%d records: %s", failed, len(records), lastError)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package output
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestKafkaRESTProxyWrite(t *testing.T) {
+	var produced kafkaProduceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/agent-readings" || r.Header.Get("Content-Type") != kafkaContentType {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); !ok || user != "agent" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&produced); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"offsets": [{"partition": 0, "offset": 1}]}`))
+	}))
+	defer server.Close()
+
+	cfg := &KafkaRESTProxyConfig{
+		RESTProxyURL: strings.Replace(server.URL, "http://", "http://agent:secret@", 1),
+		Topic:        "agent-readings",
+	}
+	out, err := cfg.NewOutput()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = out.Write(&api.DataReadingsPost{
+		AgentMetadata: &api.AgentMetadata{ClusterID: "prod"},
+		DataReadings:  []*api.DataReading{{DataGatherer: "dummy"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(produced.Records) != 1 {
+		t.Fatalf("expected a record, got %d", len(produced.Records))
+	}
+	key, _ := base64.StdEncoding.DecodeString(produced.Records[0].Key)
+	value, _ := base64.StdEncoding.DecodeString(produced.Records[0].Value)
+	if string(key) != "prod/dummy" || !strings.Contains(string(value), `"data-gatherer":"dummy"`) {
+		t.Errorf("unexpected record: key=%s value=%s", key, value)
+	}
+}
+
+func TestKafkaRESTProxyWriteRecordErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"offsets": [{"error_code": 50002, "error": "record too large"}]}`))
+	}))
+	defer server.Close()
+
+	out, err := (&KafkaRESTProxyConfig{RESTProxyURL: server.URL, Topic: "agent-readings"}).NewOutput()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = out.Write(&api.DataReadingsPost{
+		DataReadings: []*api.DataReading{{DataGatherer: "dummy"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "record too large") {
+		t.Errorf("expected the record error, got %v", err)
+	}
+}
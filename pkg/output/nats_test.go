@@ -0,0 +1,201 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// fakeNATSServer accepts connections and records the messages published on
+// them. It pings the client after answering its first ping, and records the
+// pongs of the client in pongs.
+type fakeNATSServer struct {
+	listener    net.Listener
+	connections int32
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newFakeNATSServer(t *testing.T, published chan<- string, pongs chan<- struct{}) *fakeNATSServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &fakeNATSServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.connections, 1)
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go serveNATS(conn, published, pongs)
+		}
+	}()
+	return s
+}
+
+// drop closes the connections of the clients.
+func (s *fakeNATSServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeNATSServer) close() {
+	s.listener.Close()
+	s.drop()
+}
+
+func serveNATS(conn net.Conn, published chan<- string, pongs chan<- struct{}) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"max_payload\": 1048576}\r\n")
+
+	pinged := false
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "CONNECT":
+			if !strings.Contains(line, `"user":"agent"`) {
+				fmt.Fprintf(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PUB":
+			var size int
+			fmt.Sscanf(fields[2], "%d", &size)
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			published <- fields[1] + " " + string(payload[:size])
+		case "PING":
+			fmt.Fprintf(conn, "PONG\r\n")
+			if !pinged {
+				fmt.Fprintf(conn, "PING\r\n")
+				pinged = true
+			}
+		case "PONG":
+			pongs <- struct{}{}
+		}
+	}
+}
+
+func TestNATSWrite(t *testing.T) {
+	published := make(chan string, 10)
+	pongs := make(chan struct{}, 10)
+	server := newFakeNATSServer(t, published, pongs)
+	defer server.close()
+
+	cfg := &NATSConfig{
+		URL:     "nats://agent:secret@" + server.listener.Addr().String(),
+		Subject: "agent.readings",
+	}
+	out, err := cfg.NewOutput()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = out.Write(&api.DataReadingsPost{
+		DataReadings: []*api.DataReading{{DataGatherer: "a"}, {DataGatherer: "b"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the messages are processed once the write returns
+	if len(published) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(published))
+	}
+	if m := <-published; !strings.HasPrefix(m, `agent.readings {"data_gatherer":"a"`) {
+		t.Errorf("unexpected message: %v", m)
+	}
+	<-published
+
+	// the pings of the server are answered between the writes
+	select {
+	case <-pongs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the ping of the server to be answered")
+	}
+
+	err = out.Write(&api.DataReadingsPost{
+		DataReadings: []*api.DataReading{{DataGatherer: "c"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m := <-published; !strings.HasPrefix(m, `agent.readings {"data_gatherer":"c"`) {
+		t.Errorf("unexpected message: %v", m)
+	}
+	if n := atomic.LoadInt32(&server.connections); n != 1 {
+		t.Errorf("expected the connection to be kept across the writes, got %d connections", n)
+	}
+}
+
+func TestNATSReconnect(t *testing.T) {
+	published := make(chan string, 10)
+	server := newFakeNATSServer(t, published, make(chan struct{}, 10))
+	defer server.close()
+
+	out, err := (&NATSConfig{URL: "nats://agent@" + server.listener.Addr().String(), Subject: "agent.readings"}).NewOutput()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	payload := &api.DataReadingsPost{DataReadings: []*api.DataReading{{DataGatherer: "a"}}}
+	if err := out.Write(payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-published
+
+	// the connection fails between the writes, the client reconnects on its
+	// own
+	server.drop()
+	deadline := time.Now().Add(10 * time.Second)
+	for atomic.LoadInt32(&server.connections) < 2 || !out.(*NATS).conn.IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the client to reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := out.Write(payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m := <-published; !strings.HasPrefix(m, `agent.readings {"data_gatherer":"a"`) {
+		t.Errorf("unexpected message: %v", m)
+	}
+}
+
+func TestNATSServerError(t *testing.T) {
+	server := newFakeNATSServer(t, make(chan string, 10), make(chan struct{}, 10))
+	defer server.close()
+
+	out, err := (&NATSConfig{URL: "nats://" + server.listener.Addr().String(), Subject: "agent.readings"}).NewOutput()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = out.Write(&api.DataReadingsPost{
+		DataReadings: []*api.DataReading{{DataGatherer: "a"}},
+	})
+	if err == nil || !strings.Contains(strings.ToLower(err.Error()), "authorization violation") {
+		t.Errorf("expected the server error, got %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+package output
+
+import (
+	"path"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// defaultObjectTimeout is the maximum time a write to an object storage can
+// take, unless a timeout is configured.
+const defaultObjectTimeout = time.Minute
+
+// objectName is the name of the object the readings are written to in a
+// bucket or container: <prefix>/<cluster id>/<data gather time>.json. The
+// objects of a cluster are listed in the order they were gathered.
+func objectName(prefix string, payload *api.DataReadingsPost) string {
+	clusterID := ""
+	if payload.AgentMetadata != nil {
+		clusterID = payload.AgentMetadata.ClusterID
+	}
+	gatherTime := payload.DataGatherTime
+	if gatherTime.IsZero() {
+		gatherTime = time.Now()
+	}
+	return path.Join(prefix, clusterID, gatherTime.UTC().Format("20060102T150405Z")+".json")
+}
@@ -0,0 +1,40 @@
+package output
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestObjectName(t *testing.T) {
+	gatherTime := time.Date(2021, time.March, 16, 18, 22, 15, 0, time.UTC)
+	tests := map[string]struct {
+		prefix   string
+		payload  *api.DataReadingsPost
+		expected string
+	}{
+		"prefix and cluster": {
+			prefix: "agent/",
+			payload: &api.DataReadingsPost{
+				AgentMetadata:  &api.AgentMetadata{ClusterID: "prod"},
+				DataGatherTime: gatherTime,
+			},
+			expected: "agent/prod/20210316T182215Z.json",
+		},
+		"no prefix nor metadata": {
+			payload: &api.DataReadingsPost{
+				DataGatherTime: gatherTime,
+			},
+			expected: "20210316T182215Z.json",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := objectName(test.prefix, test.payload); got != test.expected {
+				t.Errorf("unexpected object name: got=%q want=%q", got, test.expected)
+			}
+		})
+	}
+}
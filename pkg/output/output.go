@@ -0,0 +1,20 @@
+// Package output provides the Output interface and the built-in outputs data
+// readings can be written to in addition to the Jetstack Secure backend.
+package output
+
+import (
+	"github.com/jetstack/preflight/api"
+)
+
+// Config is the configuration of an Output.
+type Config interface {
+	// NewOutput constructs an Output with a specific configuration.
+	NewOutput() (Output, error)
+}
+
+// Output is the interface for destinations of data readings. Outputs receive
+// the readings gathered in every cycle of the agent.
+type Output interface {
+	// Write sends the data readings to the destination.
+	Write(payload *api.DataReadingsPost) error
+}
@@ -0,0 +1,91 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer/eks"
+)
+
+// S3Config is the configuration for an S3 Output. S3 outputs write the data
+// readings of every cycle as an object of a bucket.
+type S3Config struct {
+	// Bucket is the name of the bucket.
+	Bucket string `yaml:"bucket"`
+	// Prefix is prepended to the names of the objects.
+	Prefix string `yaml:"prefix"`
+	// Timeout is the maximum time a write can take, defaults to 1 minute.
+	Timeout time.Duration `yaml:"timeout"`
+	// AWSConfig are the credentials to access the bucket, as for EKS. The
+	// role of the pod is used with IRSA.
+	eks.AWSConfig `yaml:",inline"`
+}
+
+func (c *S3Config) validate() error {
+	if c.Bucket == "" {
+		return fmt.Errorf("invalid configuration: bucket must be set")
+	}
+	return c.AWSConfig.Validate()
+}
+
+// NewOutput returns a new S3 Output.
+func (c *S3Config) NewOutput() (Output, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	sess, err := c.AWSConfig.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultObjectTimeout
+	}
+
+	return &S3{
+		s3:      s3.New(sess),
+		bucket:  c.Bucket,
+		prefix:  c.Prefix,
+		timeout: timeout,
+	}, nil
+}
+
+// S3 is an Output that writes data readings to an S3 bucket.
+type S3 struct {
+	s3      s3iface.S3API
+	bucket  string
+	prefix  string
+	timeout time.Duration
+}
+
+// Write puts the readings in a new object of the bucket.
+func (o *S3) Write(payload *api.DataReadingsPost) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal readings: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	key := objectName(o.prefix, payload)
+	_, err = o.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(o.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write s3://%s/%s: %v", o.bucket, key, err)
+	}
+	return nil
+}
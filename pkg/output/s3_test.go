@@ -0,0 +1,56 @@
+package output
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jetstack/preflight/api"
+)
+
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string]string
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	data, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)] = string(data)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestS3Write(t *testing.T) {
+	fake := &fakeS3{objects: map[string]string{}}
+	out := &S3{s3: fake, bucket: "readings", prefix: "agent", timeout: time.Minute}
+
+	err := out.Write(&api.DataReadingsPost{
+		AgentMetadata:  &api.AgentMetadata{ClusterID: "prod"},
+		DataGatherTime: time.Date(2021, time.March, 16, 18, 22, 15, 0, time.UTC),
+		DataReadings:   []*api.DataReading{{DataGatherer: "dummy"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	object, ok := fake.objects["readings/agent/prod/20210316T182215Z.json"]
+	if !ok {
+		t.Fatalf("expected the readings to be written, got objects %v", fake.objects)
+	}
+	if !strings.Contains(object, `"data-gatherer":"dummy"`) {
+		t.Errorf("unexpected object: %s", object)
+	}
+}
+
+func TestS3ConfigValidate(t *testing.T) {
+	if _, err := (&S3Config{}).NewOutput(); err == nil {
+		t.Errorf("expected error for missing bucket")
+	}
+}
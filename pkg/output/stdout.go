@@ -0,0 +1,49 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// StdoutConfig is the configuration for a stdout Output. Stdout outputs print
+// the data readings as a JSON document per line, so they can be piped to
+// another tool or collected by the container runtime.
+type StdoutConfig struct {
+	// Pretty indents the JSON documents, which then span several lines.
+	Pretty bool `yaml:"pretty"`
+}
+
+// NewOutput returns a new stdout Output.
+func (c *StdoutConfig) NewOutput() (Output, error) {
+	return &Stdout{
+		writer: os.Stdout,
+		pretty: c.Pretty,
+	}, nil
+}
+
+// Stdout is an Output that prints data readings to the standard output.
+type Stdout struct {
+	mu     sync.Mutex
+	writer io.Writer
+	pretty bool
+}
+
+// Write prints the readings.
+func (o *Stdout) Write(payload *api.DataReadingsPost) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	encoder := json.NewEncoder(o.writer)
+	if o.pretty {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(payload); err != nil {
+		return fmt.Errorf("failed to write readings: %v", err)
+	}
+	return nil
+}
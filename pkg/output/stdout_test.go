@@ -0,0 +1,31 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestStdoutWrite(t *testing.T) {
+	var buf bytes.Buffer
+	out := &Stdout{writer: &buf}
+
+	for i := 0; i < 2; i++ {
+		err := out.Write(&api.DataReadingsPost{
+			DataReadings: []*api.DataReading{{DataGatherer: "dummy"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a JSON document per line, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], `"data-gatherer":"dummy"`) {
+		t.Errorf("unexpected output: %s", lines[0])
+	}
+}
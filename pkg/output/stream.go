@@ -0,0 +1,144 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jetstack/preflight/api"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Encodings of the messages published by the streaming outputs.
+const (
+	// encodingJSON encodes the messages as JSON documents.
+	encodingJSON = "json"
+	// encodingProtobufStruct encodes the messages as google.protobuf.Struct
+	// messages, the structure of the JSON documents. There is no schema
+	// specific to the messages.
+	encodingProtobufStruct = "protobuf-struct"
+)
+
+func validateEncoding(encoding string) error {
+	switch encoding {
+	case "", encodingJSON, encodingProtobufStruct:
+		return nil
+	}
+	return fmt.Errorf("invalid configuration: encoding %q is not supported, use %s or %s", encoding, encodingJSON, encodingProtobufStruct)
+}
+
+// streamMessage is a message published by the streaming outputs. Messages
+// carry either a whole data reading, or a single changed resource of a data
+// reading returned in incremental mode.
+type streamMessage struct {
+	ClusterID    string   `json:"cluster_id,omitempty"`
+	DataGatherer string   `json:"data_gatherer"`
+	Timestamp    api.Time `json:"timestamp"`
+	// Reading is the whole data reading.
+	Reading *api.DataReading `json:"reading,omitempty"`
+	// Resource is a resource that changed since the previous reading.
+	Resource json.RawMessage `json:"resource,omitempty"`
+}
+
+// key is the key of the message, so the messages of a data gatherer are kept
+// in order by the partitioning of the stream.
+func (m *streamMessage) key() string {
+	return m.ClusterID + "/" + m.DataGatherer
+}
+
+// deltaData is the part of the data of a reading describing the resources
+// that changed, when the data gatherer runs in incremental mode.
+type deltaData struct {
+	Delta     bool              `json:"delta"`
+	Items     []json.RawMessage `json:"items"`
+	Resources map[string]struct {
+		Items []json.RawMessage `json:"items"`
+	} `json:"resources"`
+}
+
+// streamMessages splits the readings into messages. Delta readings are split
+// into a message per changed resource, the other readings are published as a
+// single message.
+func streamMessages(payload *api.DataReadingsPost) ([]*streamMessage, error) {
+	clusterID := ""
+	if payload.AgentMetadata != nil {
+		clusterID = payload.AgentMetadata.ClusterID
+	}
+
+	var messages []*streamMessage
+	for _, reading := range payload.DataReadings {
+		data, err := json.Marshal(reading.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal reading of %q: %v", reading.DataGatherer, err)
+		}
+		var delta deltaData
+		if err := json.Unmarshal(data, &delta); err != nil || !delta.Delta {
+			// not a delta, or not even an object
+			messages = append(messages, &streamMessage{
+				ClusterID:    clusterID,
+				DataGatherer: reading.DataGatherer,
+				Timestamp:    reading.Timestamp,
+				Reading:      reading,
+			})
+			continue
+		}
+
+		resources := delta.Items
+		for _, resourceType := range delta.Resources {
+			resources = append(resources, resourceType.Items...)
+		}
+		for _, resource := range resources {
+			messages = append(messages, &streamMessage{
+				ClusterID:    clusterID,
+				DataGatherer: reading.DataGatherer,
+				Timestamp:    reading.Timestamp,
+				Resource:     resource,
+			})
+		}
+	}
+	return messages, nil
+}
+
+// encodeMessage serializes a message with the encoding.
+func encodeMessage(encoding string, message *streamMessage) ([]byte, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	if encoding != encodingProtobufStruct {
+		return data, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	s, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(s)
+}
+
+// streamRecord is an encoded message ready to be published.
+type streamRecord struct {
+	key   string
+	value []byte
+}
+
+// streamRecords splits the readings into messages and encodes them.
+func streamRecords(encoding string, payload *api.DataReadingsPost) ([]streamRecord, error) {
+	messages, err := streamMessages(payload)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]streamRecord, 0, len(messages))
+	for _, message := range messages {
+		value, err := encodeMessage(encoding, message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode message of %q: %v", message.DataGatherer, err)
+		}
+		records = append(records, streamRecord{key: message.key(), value: value})
+	}
+	return records, nil
+}
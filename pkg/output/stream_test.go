@@ -0,0 +1,88 @@
+package output
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestStreamMessages(t *testing.T) {
+	payload := &api.DataReadingsPost{
+		AgentMetadata: &api.AgentMetadata{ClusterID: "prod"},
+		DataReadings: []*api.DataReading{
+			{
+				DataGatherer: "k8s/nodes",
+				Data: map[string]interface{}{
+					"items": []*api.GatheredResource{{Resource: "node-a"}, {Resource: "node-b"}},
+				},
+			},
+			{
+				DataGatherer: "k8s/pods",
+				Data: map[string]interface{}{
+					"delta": true,
+					"items": []*api.GatheredResource{{Resource: "pod-a"}, {Resource: "pod-b"}},
+				},
+			},
+			{
+				DataGatherer: "k8s/workloads",
+				Data: map[string]interface{}{
+					"delta": true,
+					"resources": map[string]interface{}{
+						"apps/v1/deployments": map[string]interface{}{
+							"items": []*api.GatheredResource{{Resource: "deployment-a"}},
+						},
+					},
+				},
+			},
+			{
+				DataGatherer: "discovery",
+				Data:         "not an object",
+			},
+		},
+	}
+
+	messages, err := streamMessages(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, m := range messages {
+		if m.Reading != nil {
+			got = append(got, m.DataGatherer)
+			continue
+		}
+		var resource api.GatheredResource
+		if err := json.Unmarshal(m.Resource, &resource); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, m.DataGatherer+"/"+resource.Resource.(string))
+	}
+	expected := []string{"k8s/nodes", "k8s/pods/pod-a", "k8s/pods/pod-b", "k8s/workloads/deployment-a", "discovery"}
+	if len(got) != len(expected) {
+		t.Fatalf("unexpected messages: got=%v want=%v", got, expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("unexpected messages: got=%v want=%v", got, expected)
+		}
+	}
+	if key := messages[0].key(); key != "prod/k8s/nodes" {
+		t.Errorf("unexpected key: %q", key)
+	}
+}
+
+func TestValidateEncoding(t *testing.T) {
+	for _, encoding := range []string{"", "json", "protobuf-struct"} {
+		if err := validateEncoding(encoding); err != nil {
+			t.Errorf("unexpected error for %q: %v", encoding, err)
+		}
+	}
+	// there is no protobuf schema of the messages
+	for _, encoding := range []string{"avro", "protobuf"} {
+		if err := validateEncoding(encoding); err == nil {
+			t.Errorf("expected error for unsupported encoding %q", encoding)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/transport"
+)
+
+const (
+	// defaultSignatureHeader is the header of the HMAC signature of the
+	// requests of a webhook Output.
+	defaultSignatureHeader = "X-Preflight-Signature"
+	// timestampHeader is the header of the Unix time a request was signed
+	// at, which is part of the signature.
+	timestampHeader = "X-Preflight-Timestamp"
+)
+
+// WebhookConfig is the configuration for a webhook Output. Webhook outputs
+// POST the data readings of every cycle as JSON to a URL, e.g. to mirror them
+// into a SIEM.
+//
+// The requests can be signed with HMAC-SHA256: the signature header is set to
+// sha256=<hex digest> of <timestamp>.<body> with the secret, where timestamp
+// is the Unix time of the X-Preflight-Timestamp header.
+type WebhookConfig struct {
+	// URL is the URL the readings are posted to.
+	URL string `yaml:"url"`
+	// Headers are additional headers of the requests.
+	Headers map[string]string `yaml:"headers"`
+	// HeaderFiles are additional headers whose values are read from files on
+	// every request, e.g. for an Authorization header mounted from a Secret.
+	HeaderFiles map[string]string `yaml:"header-files"`
+	// HMACSecretFile is the path of the secret the requests are signed with.
+	// The requests are not signed if it is empty.
+	HMACSecretFile string `yaml:"hmac-secret-file"`
+	// SignatureHeader is the header of the signature, defaults to
+	// X-Preflight-Signature.
+	SignatureHeader string `yaml:"signature-header"`
+	// CABundle is the path of a PEM bundle of the certificate authorities of
+	// the URL, trusted in addition to the system ones.
+	CABundle string `yaml:"ca-bundle"`
+	// Timeout is the maximum time a write can take, defaults to 1 minute.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c *WebhookConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid configuration: url must be an http or https URL")
+	}
+	for name := range c.HeaderFiles {
+		if _, ok := c.Headers[name]; ok {
+			return fmt.Errorf("invalid configuration: header %q is set in both headers and header-files", name)
+		}
+	}
+	if c.SignatureHeader != "" && c.HMACSecretFile == "" {
+		return fmt.Errorf("invalid configuration: signature-header requires hmac-secret-file")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid configuration: timeout cannot be negative")
+	}
+	return nil
+}
+
+// NewOutput returns a new webhook Output.
+func (c *WebhookConfig) NewOutput() (Output, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	t := transport.New()
+	if c.CABundle != "" {
+		if err := transport.SetCABundle(t, c.CABundle); err != nil {
+			return nil, err
+		}
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	signatureHeader := c.SignatureHeader
+	if signatureHeader == "" {
+		signatureHeader = defaultSignatureHeader
+	}
+
+	return &Webhook{
+		client:          &http.Client{Transport: t},
+		url:             c.URL,
+		headers:         c.Headers,
+		headerFiles:     c.HeaderFiles,
+		hmacSecretFile:  c.HMACSecretFile,
+		signatureHeader: signatureHeader,
+		timeout:         timeout,
+	}, nil
+}
+
+// Webhook is an Output that posts data readings to a URL.
+type Webhook struct {
+	client          *http.Client
+	url             string
+	headers         map[string]string
+	headerFiles     map[string]string
+	hmacSecretFile  string
+	signatureHeader string
+	timeout         time.Duration
+}
+
+// Write posts the readings to the URL.
+func (o *Webhook) Write(payload *api.DataReadingsPost) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal readings: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range o.headers {
+		req.Header.Set(name, value)
+	}
+	// the files are read on every request, so that rotated secrets are used
+	for name, path := range o.headerFiles {
+		value, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read header %s: %v", name, err)
+		}
+		req.Header.Set(name, strings.TrimSpace(string(value)))
+	}
+	if o.hmacSecretFile != "" {
+		secret, err := ioutil.ReadFile(o.hmacSecretFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the HMAC secret: %v", err)
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(timestampHeader, timestamp)
+		req.Header.Set(o.signatureHeader, "sha256="+sign(bytes.TrimSpace(secret), timestamp, data))
+	}
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post readings: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("received response with status code %d. Body: %s", res.StatusCode, string(body))
+	}
+	return nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the timestamp and the body.
+func sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
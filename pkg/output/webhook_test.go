@@ -0,0 +1,90 @@
+package output
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestWebhookWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("hmac-secret\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("Bearer siem-token\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var received api.DataReadingsPost
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer siem-token" || r.Header.Get("X-Source") != "preflight" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		expected := "sha256=" + sign([]byte("hmac-secret"), r.Header.Get(timestampHeader), body)
+		if !hmac.Equal([]byte(r.Header.Get(defaultSignatureHeader)), []byte(expected)) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		if err := json.Unmarshal(body, &received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &WebhookConfig{
+		URL:            server.URL,
+		Headers:        map[string]string{"X-Source": "preflight"},
+		HeaderFiles:    map[string]string{"Authorization": tokenFile},
+		HMACSecretFile: secretFile,
+	}
+	o, err := cfg.NewOutput()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	payload := &api.DataReadingsPost{
+		AgentMetadata: &api.AgentMetadata{ClusterID: "cluster"},
+		DataReadings:  []*api.DataReading{{DataGatherer: "dummy"}},
+	}
+	if err := o.Write(payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if received.AgentMetadata == nil || received.AgentMetadata.ClusterID != "cluster" || len(received.DataReadings) != 1 {
+		t.Errorf("unexpected readings received: %+v", received)
+	}
+
+	// a wrong secret is rejected by the receiver
+	if err := ioutil.WriteFile(secretFile, []byte("other-secret"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := o.Write(payload); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestWebhookConfigValidate(t *testing.T) {
+	for _, c := range []WebhookConfig{
+		{},
+		{URL: "ftp://example.com"},
+		{URL: "https://example.com", Headers: map[string]string{"Authorization": "x"}, HeaderFiles: map[string]string{"Authorization": "token"}},
+		{URL: "https://example.com", SignatureHeader: "X-Signature"},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+}
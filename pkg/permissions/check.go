@@ -0,0 +1,199 @@
+package permissions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultServiceAccount is the service account of the agent the RBAC
+// manifests are generated for.
+const DefaultServiceAccount = agentNamespace + "/" + agentSubjectName
+
+// Problem is an issue preventing a data gatherer from reading a resource
+// type.
+type Problem struct {
+	DataGatherer string
+	Resource     string
+	Message      string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("datagatherer %q, resource %s: %s", p.DataGatherer, p.Resource, p.Message)
+}
+
+// isPattern returns true if the resource type contains wildcards.
+func isPattern(gvr schema.GroupVersionResource) bool {
+	return strings.ContainsAny(gvr.Group+gvr.Version+gvr.Resource, "*?[")
+}
+
+// CheckResources checks with the discovery API that the resource types of
+// the requirements are served by the cluster and can be listed and watched,
+// and that the patterns match at least one resource type.
+func CheckResources(cl discovery.DiscoveryInterface, requirements []ResourceRequirement) ([]Problem, error) {
+	_, lists, err := cl.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("failed to discover the resources of the cluster: %v", err)
+	}
+	served := map[schema.GroupVersion]map[string]metav1.APIResource{}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		served[gv] = map[string]metav1.APIResource{}
+		for _, resource := range list.APIResources {
+			served[gv][resource.Name] = resource
+		}
+	}
+
+	var problems []Problem
+	for _, r := range requirements {
+		gvr := r.GroupVersionResource
+		problem := Problem{DataGatherer: r.DataGatherer, Resource: k8s.ResourceTypeKey(gvr)}
+
+		if isPattern(gvr) {
+			resolved, err := k8s.ResolveResourcePatterns(cl, []schema.GroupVersionResource{gvr})
+			if err != nil {
+				return nil, err
+			}
+			if len(resolved) == 0 {
+				problem.Message = "no resource type served by the cluster matches the pattern"
+				problems = append(problems, problem)
+			}
+			continue
+		}
+
+		resources, ok := served[gvr.GroupVersion()]
+		if !ok {
+			problem.Message = fmt.Sprintf("the API %s is not served by the cluster, check the version or install its CRDs", gvr.GroupVersion())
+			problems = append(problems, problem)
+			continue
+		}
+		resource, ok := resources[gvr.Resource]
+		if !ok {
+			problem.Message = fmt.Sprintf("the API %s has no resource %q, check its name is the plural one", gvr.GroupVersion(), gvr.Resource)
+			problems = append(problems, problem)
+			continue
+		}
+		for _, verb := range []string{"list", "watch"} {
+			if !hasVerb(resource.Verbs, verb) {
+				problem.Message = fmt.Sprintf("the resource does not support %s", verb)
+				problems = append(problems, problem)
+			}
+		}
+	}
+	return problems, nil
+}
+
+func hasVerb(verbs metav1.Verbs, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAccess checks with SubjectAccessReviews that the service account, in
+// the namespace/name format, can list and watch the resource types of the
+// requirements, in their namespaces or cluster wide. If serviceAccount is
+// empty, the identity of the client is checked with SelfSubjectAccessReviews,
+// e.g. when running in the Pod of the agent.
+func CheckAccess(ctx context.Context, cl kubernetes.Interface, serviceAccount string, requirements []ResourceRequirement) ([]Problem, error) {
+	// subject is the service account, or nil for the identity of the client
+	var subject *authorizationv1.SubjectAccessReviewSpec
+	if serviceAccount != "" {
+		parts := strings.Split(serviceAccount, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("the service account %q must be in the namespace/name format", serviceAccount)
+		}
+		subject = &authorizationv1.SubjectAccessReviewSpec{
+			User:   fmt.Sprintf("system:serviceaccount:%s:%s", parts[0], parts[1]),
+			Groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + parts[0], "system:authenticated"},
+		}
+	}
+
+	var problems []Problem
+	for _, r := range requirements {
+		gvr := r.GroupVersionResource
+		// patterns are granted on all the resources of the group, as the
+		// generated manifests do
+		if strings.ContainsAny(gvr.Group, "*?[") {
+			gvr.Group = "*"
+		}
+		if strings.ContainsAny(gvr.Resource, "*?[") {
+			gvr.Resource = "*"
+		}
+		namespaces := r.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = []string{""}
+		}
+
+		for _, namespace := range namespaces {
+			for _, verb := range []string{"list", "watch"} {
+				attributes := &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      verb,
+					Group:     gvr.Group,
+					Resource:  gvr.Resource,
+				}
+				allowed, reason, err := review(ctx, cl, subject, attributes)
+				if err != nil {
+					return nil, fmt.Errorf("failed to review the access to %s: %v", k8s.ResourceTypeKey(r.GroupVersionResource), err)
+				}
+				if allowed {
+					continue
+				}
+
+				scope := "cluster wide"
+				if namespace != "" {
+					scope = fmt.Sprintf("in namespace %s", namespace)
+				}
+				who := "the agent"
+				if serviceAccount != "" {
+					who = serviceAccount
+				}
+				message := fmt.Sprintf("%s cannot %s the resources %s, grant it with the manifests of `preflight agent rbac`", who, verb, scope)
+				if reason != "" {
+					message += fmt.Sprintf(" (%s)", reason)
+				}
+				problems = append(problems, Problem{
+					DataGatherer: r.DataGatherer,
+					Resource:     k8s.ResourceTypeKey(r.GroupVersionResource),
+					Message:      message,
+				})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// review returns whether the subject, or the client if subject is nil, is
+// allowed the access, and the reason of the decision.
+func review(ctx context.Context, cl kubernetes.Interface, subject *authorizationv1.SubjectAccessReviewSpec, attributes *authorizationv1.ResourceAttributes) (bool, string, error) {
+	if subject == nil {
+		review, err := cl.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: attributes},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		return review.Status.Allowed, review.Status.Reason, nil
+	}
+
+	spec := *subject
+	spec.ResourceAttributes = attributes
+	review, err := cl.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{Spec: spec}, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	return review.Status.Allowed, review.Status.Reason, nil
+}
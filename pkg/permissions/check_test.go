@@ -0,0 +1,99 @@
+package permissions
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func requirement(group, version, resource string, namespaces ...string) ResourceRequirement {
+	return ResourceRequirement{
+		DataGatherer:         "dg",
+		GroupVersionResource: schema.GroupVersionResource{Group: group, Version: version, Resource: resource},
+		Namespaces:           namespaces,
+	}
+}
+
+func TestCheckResources(t *testing.T) {
+	cl := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}}
+	cl.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "pods", Verbs: metav1.Verbs{"get", "list", "watch"}},
+				{Name: "bindings", Verbs: metav1.Verbs{"create"}},
+			},
+		},
+		{
+			GroupVersion: "cert-manager.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "certificates", Verbs: metav1.Verbs{"get", "list", "watch"}},
+			},
+		},
+	}
+
+	problems, err := CheckResources(cl, []ResourceRequirement{
+		requirement("", "v1", "pods"),
+		requirement("cert-manager.io", "v1", "*"),
+		requirement("", "v1", "pod"),
+		requirement("", "v1", "bindings"),
+		requirement("cert-manager.io", "v1alpha2", "certificates"),
+		requirement("istio.io", "v1", "*"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.Resource)
+	}
+	expected := []string{"pod.v1", "bindings.v1", "bindings.v1", "certificates.v1alpha2.cert-manager.io", "*.v1.istio.io"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected problems: got=%v want=%v", got, expected)
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	cl := fake.NewSimpleClientset()
+	var users []string
+	cl.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		users = append(users, review.Spec.User)
+		// only the pods of the default namespace can be read
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = attributes.Resource == "pods" && attributes.Namespace == "default"
+		return true, review, nil
+	})
+
+	problems, err := CheckAccess(context.Background(), cl, "jetstack-secure/agent", []ResourceRequirement{
+		requirement("", "v1", "pods", "default"),
+		requirement("", "v1", "secrets"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("expected list and watch on secrets to be reported, got %v", problems)
+	}
+	for _, p := range problems {
+		if p.Resource != "secrets.v1" || !strings.Contains(p.Message, "cluster wide") {
+			t.Errorf("unexpected problem: %s", p)
+		}
+	}
+	if users[0] != "system:serviceaccount:jetstack-secure:agent" {
+		t.Errorf("unexpected user reviewed: %q", users[0])
+	}
+
+	if _, err := CheckAccess(context.Background(), cl, "agent", nil); err == nil {
+		t.Errorf("expected error for an invalid service account")
+	}
+}
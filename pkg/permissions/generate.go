@@ -5,9 +5,12 @@ import (
 	"strings"
 
 	"github.com/jetstack/preflight/pkg/agent"
+	"github.com/jetstack/preflight/pkg/datagatherer/certmanager"
+	"github.com/jetstack/preflight/pkg/datagatherer/istio"
 	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
 	rbac "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/yaml"
 )
 
@@ -17,6 +20,8 @@ type AgentRBACManifests struct {
 	ClusterRoles []rbac.ClusterRole
 	// ClusterRoleBindings is a list of crbs for resources which have no include/exclude ns configured
 	ClusterRoleBindings []rbac.ClusterRoleBinding
+	// Roles is a list of namespaced roles for resources the agent only collects in the included namespaces
+	Roles []rbac.Role
 	// RoleBindings is a list of namespaced bindings to grant permissions when include/exclude ns set
 	RoleBindings []rbac.RoleBinding
 }
@@ -24,96 +29,236 @@ type AgentRBACManifests struct {
 const agentNamespace = "jetstack-secure"
 const agentSubjectName = "agent"
 
-func GenerateAgentRBACManifests(dataGatherers []agent.DataGatherer) AgentRBACManifests {
-	// create a new AgentRBACManifest struct
-	var AgentRBACManifests AgentRBACManifests
+// ResourceRequirement is a resource type a data gatherer reads, in the
+// namespaces it is restricted to, or cluster wide if there are none.
+type ResourceRequirement struct {
+	// DataGatherer is the name of the data gatherer.
+	DataGatherer string
+	// GroupVersionResource is the resource type, which can be a pattern.
+	GroupVersionResource schema.GroupVersionResource
+	// Namespaces are the namespaces the resources are read from.
+	Namespaces []string
+}
 
+// ResourceRequirements returns the resource types the data gatherers read
+// from the Kubernetes API.
+func ResourceRequirements(dataGatherers []agent.DataGatherer) []ResourceRequirement {
+	var requirements []ResourceRequirement
 	for _, dg := range dataGatherers {
-		if dg.Kind != "k8s-dynamic" {
+		var dyConfig *k8s.ConfigDynamic
+		var dyConfigs []*k8s.ConfigDynamic
+		switch dg.Kind {
+		case "k8s-dynamic":
+			dyConfig = dg.Config.(*k8s.ConfigDynamic)
+		case "k8s-owners":
+			dyConfig = dg.Config.(*k8s.ConfigOwners).DynamicConfig()
+		case "k8s-tls-secrets":
+			dyConfig = dg.Config.(*k8s.ConfigTLSSecrets).DynamicConfig()
+		case "k8s-configmap-certs":
+			dyConfig = dg.Config.(*k8s.ConfigConfigMapCerts).DynamicConfig()
+		case "k8s-ingress-tls":
+			dyConfig = dg.Config.(*k8s.ConfigIngressTLS).DynamicConfig()
+		case "k8s-tls-probe":
+			dyConfigs = dg.Config.(*k8s.ConfigTLSProbe).DynamicConfigs()
+		case "k8s-images":
+			dyConfig = dg.Config.(*k8s.ConfigImages).DynamicConfig()
+		case "k8s-nodes":
+			dyConfig = dg.Config.(*k8s.ConfigNodes).DynamicConfig()
+		case "k8s-events":
+			dyConfig = dg.Config.(*k8s.ConfigEvents).DynamicConfig()
+		case "k8s-discovery":
+			if !dg.Config.(*k8s.ConfigDiscovery).DeprecatedAPIs {
+				continue
+			}
+			// the objects of the deprecated APIs are listed
+			dyConfig = &k8s.ConfigDynamic{GroupVersionResources: k8s.DeprecatedAPIResourceTypes()}
+		case "k8s-admission":
+			dyConfigs = dg.Config.(*k8s.ConfigAdmission).DynamicConfigs()
+		case "k8s-rbac":
+			dyConfig = dg.Config.(*k8s.ConfigRBAC).DynamicConfig()
+		case "k8s-openshift":
+			dyConfig = dg.Config.(*k8s.ConfigOpenShift).DynamicConfig()
+		case "cert-manager":
+			dyConfig = dg.Config.(*certmanager.Config).DynamicConfig()
+		case "cert-manager-acme":
+			dyConfig = dg.Config.(*certmanager.ACMEConfig).DynamicConfig()
+		case "istio-mesh":
+			dyConfigs = dg.Config.(*istio.MeshConfig).DynamicConfigs()
+		default:
 			continue
 		}
+		if dyConfig != nil {
+			dyConfigs = append(dyConfigs, dyConfig)
+		}
 
-		dyConfig := dg.Config.(*k8s.ConfigDynamic)
-		metadataName := fmt.Sprintf("%s-agent-%s-reader", agentNamespace, dyConfig.GroupVersionResource.Resource)
-
-		AgentRBACManifests.ClusterRoles = append(AgentRBACManifests.ClusterRoles, rbac.ClusterRole{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "ClusterRole",
-				APIVersion: "rbac.authorization.k8s.io/v1",
-			},
-			ObjectMeta: metav1.ObjectMeta{
-				Name: metadataName,
-			},
-			Rules: []rbac.PolicyRule{
-				{
-					Verbs:     []string{"get", "list", "watch"},
-					APIGroups: []string{dyConfig.GroupVersionResource.Group},
-					Resources: []string{dyConfig.GroupVersionResource.Resource},
-				},
-			},
-		})
-
-		// if dyConfig.IncludeNamespaces has more than 0 items in it
-		//   then, for each namespace create a rbac.RoleBinding in that namespace
-		if len(dyConfig.IncludeNamespaces) != 0 {
-			for _, ns := range dyConfig.IncludeNamespaces {
-				AgentRBACManifests.RoleBindings = append(AgentRBACManifests.RoleBindings, rbac.RoleBinding{
-					TypeMeta: metav1.TypeMeta{
-						Kind:       "RoleBinding",
-						APIVersion: "rbac.authorization.k8s.io/v1",
-					},
-
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      metadataName,
-						Namespace: ns,
-					},
-
-					Subjects: []rbac.Subject{
-						{
-							Kind:      "ServiceAccount",
-							Name:      agentSubjectName,
-							Namespace: agentNamespace,
-						},
-					},
-
-					RoleRef: rbac.RoleRef{
-						Kind:     "ClusterRole",
-						Name:     metadataName,
-						APIGroup: "rbac.authorization.k8s.io",
-					},
+		for _, dyConfig := range dyConfigs {
+			for _, gvr := range dyConfig.ResourceTypes() {
+				requirements = append(requirements, ResourceRequirement{
+					DataGatherer:         dg.Name,
+					GroupVersionResource: gvr,
+					Namespaces:           dyConfig.IncludeNamespaces,
+				})
+			}
+
+			// namespaces are watched to resolve the namespace label selector
+			if dyConfig.NamespaceLabelSelector != "" {
+				requirements = append(requirements, ResourceRequirement{
+					DataGatherer:         dg.Name,
+					GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "namespaces"},
 				})
 			}
-		} else {
-			// only do this if the dg does not have IncludeNamespaces set
-			AgentRBACManifests.ClusterRoleBindings = append(AgentRBACManifests.ClusterRoleBindings, rbac.ClusterRoleBinding{
+		}
+	}
+	return requirements
+}
+
+func GenerateAgentRBACManifests(dataGatherers []agent.DataGatherer) AgentRBACManifests {
+	// the default service account is valid
+	manifests, _ := GenerateAgentRBACManifestsFor(dataGatherers, DefaultServiceAccount)
+	return manifests
+}
+
+// GenerateAgentRBACManifestsFor returns the manifests granting the service
+// account, in the namespace/name format, the minimal permissions to read the
+// resources of the data gatherers. The resources of the data gatherers with
+// included namespaces are only granted in these namespaces, with Roles.
+func GenerateAgentRBACManifestsFor(dataGatherers []agent.DataGatherer, serviceAccount string) (AgentRBACManifests, error) {
+	var AgentRBACManifests AgentRBACManifests
+
+	parts := strings.Split(serviceAccount, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return AgentRBACManifests, fmt.Errorf("the service account %q must be in the namespace/name format", serviceAccount)
+	}
+	subject := rbac.Subject{
+		Kind:      "ServiceAccount",
+		Name:      parts[1],
+		Namespace: parts[0],
+	}
+
+	for _, r := range ResourceRequirements(dataGatherers) {
+		AgentRBACManifests.add(r.GroupVersionResource, r.Namespaces, subject)
+	}
+
+	return AgentRBACManifests, nil
+}
+
+// add appends the role and binding required to read a resource type in the
+// provided namespaces, or the cluster role and binding to read it cluster
+// wide if no namespaces are provided. Resource types read by several data
+// gatherers are only granted once.
+func (m *AgentRBACManifests) add(gvr schema.GroupVersionResource, includeNamespaces []string, subject rbac.Subject) {
+	// wildcard resource types are granted access to all the resources of
+	// the group, as RBAC does not support partial wildcards
+	if strings.ContainsAny(gvr.Group, "*?[") {
+		gvr.Group = "*"
+	}
+	if strings.ContainsAny(gvr.Resource, "*?[") {
+		gvr.Resource = "*"
+	}
+	metadataName := fmt.Sprintf("%s-agent-%s-reader", agentNamespace, strings.ReplaceAll(gvr.Resource, "*", "all"))
+	if gvr.Resource == "*" && gvr.Group != "*" && gvr.Group != "" {
+		metadataName = fmt.Sprintf("%s-agent-%s-reader", agentNamespace, gvr.Group)
+	}
+	rules := []rbac.PolicyRule{
+		{
+			Verbs:     []string{"get", "list", "watch"},
+			APIGroups: []string{gvr.Group},
+			Resources: []string{gvr.Resource},
+		},
+	}
+
+	// if includeNamespaces has more than 0 items in it
+	//   then, for each namespace create a rbac.Role and rbac.RoleBinding in that namespace
+	if len(includeNamespaces) != 0 {
+		for _, ns := range includeNamespaces {
+			if m.hasRole(metadataName, ns) {
+				continue
+			}
+			m.Roles = append(m.Roles, rbac.Role{
 				TypeMeta: metav1.TypeMeta{
-					Kind:       "ClusterRoleBinding",
+					Kind:       "Role",
 					APIVersion: "rbac.authorization.k8s.io/v1",
 				},
-
 				ObjectMeta: metav1.ObjectMeta{
-					Name: metadataName,
+					Name:      metadataName,
+					Namespace: ns,
+				},
+				Rules: rules,
+			})
+			m.RoleBindings = append(m.RoleBindings, rbac.RoleBinding{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "RoleBinding",
+					APIVersion: "rbac.authorization.k8s.io/v1",
 				},
 
-				Subjects: []rbac.Subject{
-					{
-						Kind:      "ServiceAccount",
-						Name:      agentSubjectName,
-						Namespace: agentNamespace,
-					},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      metadataName,
+					Namespace: ns,
 				},
 
+				Subjects: []rbac.Subject{subject},
+
 				RoleRef: rbac.RoleRef{
-					Kind:     "ClusterRole",
+					Kind:     "Role",
 					Name:     metadataName,
 					APIGroup: "rbac.authorization.k8s.io",
 				},
 			})
 		}
+		return
+	}
 
+	if m.hasRole(metadataName, "") {
+		return
 	}
+	m.ClusterRoles = append(m.ClusterRoles, rbac.ClusterRole{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ClusterRole",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: metadataName,
+		},
+		Rules: rules,
+	})
+	// only do this if the dg does not have IncludeNamespaces set
+	m.ClusterRoleBindings = append(m.ClusterRoleBindings, rbac.ClusterRoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ClusterRoleBinding",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+
+		ObjectMeta: metav1.ObjectMeta{
+			Name: metadataName,
+		},
+
+		Subjects: []rbac.Subject{subject},
 
-	return AgentRBACManifests
+		RoleRef: rbac.RoleRef{
+			Kind:     "ClusterRole",
+			Name:     metadataName,
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	})
+}
+
+// hasRole returns true if the role of the namespace, or the cluster role if
+// the namespace is empty, has already been added.
+func (m *AgentRBACManifests) hasRole(name, namespace string) bool {
+	if namespace == "" {
+		for _, r := range m.ClusterRoles {
+			if r.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	for _, r := range m.Roles {
+		if r.Name == name && r.Namespace == namespace {
+			return true
+		}
+	}
+	return false
 }
 
 func createClusterRoleString(clusterRoles []rbac.ClusterRole) string {
@@ -131,6 +276,21 @@ func createClusterRoleString(clusterRoles []rbac.ClusterRole) string {
 
 	return builder.String()
 }
+func createRoleString(roles []rbac.Role) string {
+	var builder strings.Builder
+	for _, r := range roles {
+		data, err := yaml.Marshal(r)
+		if err != nil {
+			fmt.Print("Role fails to marshal")
+		}
+
+		builder.WriteString("\n")
+		builder.Write(data)
+		builder.WriteString("---")
+	}
+
+	return builder.String()
+}
 func createRoleBindingString(roleBindings []rbac.RoleBinding) string {
 	var builder strings.Builder
 	for _, cb := range roleBindings {
@@ -163,16 +323,27 @@ func createClusterRoleBindingString(clusterRoleBindings []rbac.ClusterRoleBindin
 }
 
 func GenerateFullManifest(dataGatherers []agent.DataGatherer) string {
-	agentRBACManifestsStruct := GenerateAgentRBACManifests(dataGatherers)
+	// the default service account is valid
+	out, _ := GenerateFullManifestFor(dataGatherers, DefaultServiceAccount)
+	return out
+}
+
+// GenerateFullManifestFor returns the YAML manifests of
+// GenerateAgentRBACManifestsFor.
+func GenerateFullManifestFor(dataGatherers []agent.DataGatherer, serviceAccount string) (string, error) {
+	agentRBACManifestsStruct, err := GenerateAgentRBACManifestsFor(dataGatherers, serviceAccount)
+	if err != nil {
+		return "", err
+	}
 	agentCLR := createClusterRoleString(agentRBACManifestsStruct.ClusterRoles)
 	agentCLRB := createClusterRoleBindingString(agentRBACManifestsStruct.ClusterRoleBindings)
+	agentR := createRoleString(agentRBACManifestsStruct.Roles)
 	agentRB := createRoleBindingString(agentRBACManifestsStruct.RoleBindings)
 
-	out := fmt.Sprintf(`%s%s%s`, agentCLR, agentCLRB, agentRB)
+	out := fmt.Sprintf(`%s%s%s%s`, agentCLR, agentCLRB, agentR, agentRB)
 	out = strings.TrimPrefix(out, "\n")
 	out = strings.TrimSpace(out)
 	out = strings.ReplaceAll(out, "\n  creationTimestamp: null", "")
 
-	return out
-
+	return out, nil
 }
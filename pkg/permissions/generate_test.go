@@ -60,7 +60,7 @@ subjects:
 ---`,
 		},
 		{
-			description: "Generate ClusterRole and RoleBinding for simple pod dg with include namespace \"foobar\"",
+			description: "Generate Role and RoleBinding for simple pod dg with include namespace \"foobar\"",
 			dataGatherers: []agent.DataGatherer{
 				{
 					Name: "k8s/pods",
@@ -75,9 +75,10 @@ subjects:
 				},
 			},
 			expectedRBACManifests: `apiVersion: rbac.authorization.k8s.io/v1
-kind: ClusterRole
+kind: Role
 metadata:
   name: jetstack-secure-agent-pods-reader
+  namespace: foobar
 rules:
 - apiGroups:
   - ""
@@ -95,7 +96,7 @@ metadata:
   namespace: foobar
 roleRef:
   apiGroup: rbac.authorization.k8s.io
-  kind: ClusterRole
+  kind: Role
   name: jetstack-secure-agent-pods-reader
 subjects:
 - kind: ServiceAccount
@@ -272,14 +273,32 @@ func TestGenerateAgentRBACManifests(t *testing.T) {
 				},
 			},
 			expectedAgentRBACManifests: AgentRBACManifests{
-				ClusterRoles: []rbac.ClusterRole{
+				Roles: []rbac.Role{
 					{
 						TypeMeta: metav1.TypeMeta{
-							Kind:       "ClusterRole",
+							Kind:       "Role",
 							APIVersion: "rbac.authorization.k8s.io/v1",
 						},
 						ObjectMeta: metav1.ObjectMeta{
-							Name: "jetstack-secure-agent-pods-reader",
+							Name:      "jetstack-secure-agent-pods-reader",
+							Namespace: "example",
+						},
+						Rules: []rbac.PolicyRule{
+							{
+								Verbs:     []string{"get", "list", "watch"},
+								APIGroups: []string{""},
+								Resources: []string{"pods"},
+							},
+						},
+					},
+					{
+						TypeMeta: metav1.TypeMeta{
+							Kind:       "Role",
+							APIVersion: "rbac.authorization.k8s.io/v1",
+						},
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "jetstack-secure-agent-pods-reader",
+							Namespace: "foobar",
 						},
 						Rules: []rbac.PolicyRule{
 							{
@@ -308,7 +327,7 @@ func TestGenerateAgentRBACManifests(t *testing.T) {
 							},
 						},
 						RoleRef: rbac.RoleRef{
-							Kind:     "ClusterRole",
+							Kind:     "Role",
 							Name:     "jetstack-secure-agent-pods-reader",
 							APIGroup: "rbac.authorization.k8s.io",
 						},
@@ -330,7 +349,7 @@ func TestGenerateAgentRBACManifests(t *testing.T) {
 							},
 						},
 						RoleRef: rbac.RoleRef{
-							Kind:     "ClusterRole",
+							Kind:     "Role",
 							Name:     "jetstack-secure-agent-pods-reader",
 							APIGroup: "rbac.authorization.k8s.io",
 						},
@@ -346,3 +365,56 @@ func TestGenerateAgentRBACManifests(t *testing.T) {
 		td.Cmp(t, input.expectedAgentRBACManifests, got)
 	}
 }
+
+func TestGenerateFullManifestFor(t *testing.T) {
+	pods := &k8s.ConfigDynamic{
+		GroupVersionResource: schema.GroupVersionResource{
+			Version:  "v1",
+			Resource: "pods",
+		},
+	}
+	// the pods read by both data gatherers are only granted once
+	dataGatherers := []agent.DataGatherer{
+		{Name: "k8s/pods", Kind: "k8s-dynamic", Config: pods},
+		{Name: "k8s/owners", Kind: "k8s-dynamic", Config: pods},
+	}
+
+	got, err := GenerateFullManifestFor(dataGatherers, "security/preflight")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: jetstack-secure-agent-pods-reader
+rules:
+- apiGroups:
+  - ""
+  resources:
+  - pods
+  verbs:
+  - get
+  - list
+  - watch
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: jetstack-secure-agent-pods-reader
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: jetstack-secure-agent-pods-reader
+subjects:
+- kind: ServiceAccount
+  name: preflight
+  namespace: security
+---`
+	if got != expected {
+		t.Errorf("value mismatch, \n**********expected:******************************\n%s\n**********got:******************************\n%s", expected, got)
+	}
+
+	if _, err := GenerateFullManifestFor(dataGatherers, "preflight"); err == nil {
+		t.Errorf("expected error for an invalid service account")
+	}
+}
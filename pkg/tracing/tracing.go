@@ -0,0 +1,183 @@
+// Package tracing records spans of the agent's cycles with the OpenTelemetry
+// SDK and exports them to an OpenTelemetry collector using OTLP over HTTP.
+// Until Setup is called, the spans are not recorded and all the functions of
+// the package are no-ops.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/jetstack/preflight/pkg/transport"
+)
+
+const (
+	// defaultServiceName is the service name of the spans if none is
+	// configured.
+	defaultServiceName = "preflight-agent"
+	// instrumentationName is the name of the tracer of the agent.
+	instrumentationName = "github.com/jetstack/preflight"
+)
+
+// Config is the configuration of the OTLP exporter.
+type Config struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver of the collector,
+	// e.g. http://otel-collector:4318. Spans are posted to /v1/traces.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are added to the export requests, e.g. for authentication.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// ServiceName is the service.name of the spans, it defaults to
+	// preflight-agent.
+	ServiceName string `yaml:"service-name,omitempty"`
+	// Timeout is the timeout of the export requests, it defaults to 10s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// SampleRatio is the ratio of the cycles traced, between 0 and 1. It
+	// defaults to 1, every cycle is traced.
+	SampleRatio float64 `yaml:"sample-ratio,omitempty"`
+}
+
+// Validate validates the configuration.
+func (c *Config) Validate() error {
+	if c.Endpoint == "" {
+		return fmt.Errorf("endpoint cannot be empty")
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint %q is not a valid http(s) URL", c.Endpoint)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("sample-ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+var (
+	globalMu sync.RWMutex
+	provider *sdktrace.TracerProvider
+)
+
+// Setup enables tracing with the configuration. The attributes describe the
+// agent, they are set on the resource of all the exported spans.
+func Setup(c *Config, attributes map[string]string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	serviceName := c.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	sampleRatio := c.SampleRatio
+	if sampleRatio == 0 {
+		sampleRatio = 1
+	}
+
+	u, _ := url.Parse(c.Endpoint)
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+		otlptracehttp.WithHeaders(c.Headers),
+		otlptracehttp.WithTimeout(timeout),
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	} else if tlsConfig := transport.New().TLSClientConfig; tlsConfig != nil {
+		// the collector is trusted like the other services the agent
+		// sends requests to
+		options = append(options, otlptracehttp.WithTLSClientConfig(tlsConfig))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return fmt.Errorf("failed to create the OTLP exporter: %v", err)
+	}
+
+	resourceAttributes := []attribute.KeyValue{semconv.ServiceNameKey.String(serviceName)}
+	for k, v := range attributes {
+		resourceAttributes = append(resourceAttributes, attribute.String(k, v))
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, resourceAttributes...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	provider = tp
+	return nil
+}
+
+// Start starts a span, child of the span of the context if there is one, and
+// returns a context carrying it.
+func Start(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name)
+}
+
+// StartClient starts a span for a request made to another service.
+func StartClient(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+}
+
+// RecordError marks the span as failed if err is not nil.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Inject sets the W3C traceparent header of an outgoing request to the span
+// of the context, so the backend can attach its own spans to the trace.
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Flush exports the spans ended since the previous Flush. The spans are
+// dropped if the export fails, so a failing collector does not make the
+// agent accumulate them.
+func Flush(ctx context.Context) error {
+	globalMu.RLock()
+	tp := provider
+	globalMu.RUnlock()
+	if tp == nil {
+		return nil
+	}
+	return tp.ForceFlush(ctx)
+}
+
+// Shutdown exports the pending spans and stops the exporter.
+func Shutdown(ctx context.Context) error {
+	globalMu.Lock()
+	tp := provider
+	provider = nil
+	globalMu.Unlock()
+	if tp == nil {
+		return nil
+	}
+	return tp.Shutdown(ctx)
+}
@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		config      Config
+		expectedErr string
+	}{
+		"valid": {
+			config: Config{Endpoint: "http://otel-collector:4318"},
+		},
+		"missing endpoint": {
+			config:      Config{},
+			expectedErr: "endpoint cannot be empty",
+		},
+		"invalid endpoint": {
+			config:      Config{Endpoint: "otel-collector:4318"},
+			expectedErr: `endpoint "otel-collector:4318" is not a valid http(s) URL`,
+		},
+		"invalid sample ratio": {
+			config:      Config{Endpoint: "http://otel-collector:4318", SampleRatio: 2},
+			expectedErr: "sample-ratio must be between 0 and 1",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.config.Validate()
+			if test.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr) {
+				t.Fatalf("expected error %q, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	_, span := Start(context.Background(), "cycle")
+	if span.IsRecording() {
+		t.Fatalf("expected the spans not to be recorded when tracing is disabled")
+	}
+	span.SetAttributes(attribute.String("key", "value"))
+	RecordError(span, fmt.Errorf("boom"))
+	span.End()
+	if err := Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	received := &coltracepb.ExportTraceServiceRequest{}
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		headers = r.Header
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if err := proto.Unmarshal(body, received); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer server.Close()
+
+	err := Setup(&Config{Endpoint: server.URL, Headers: map[string]string{"X-Token": "secret"}}, map[string]string{"preflight.cluster_id": "test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer Shutdown(context.Background())
+
+	ctx, cycle := Start(context.Background(), "cycle")
+	postCtx, post := StartClient(ctx, "POST")
+	post.SetAttributes(attribute.Int("http.status_code", 500))
+	RecordError(post, fmt.Errorf("received response with status code 500"))
+	header := http.Header{}
+	Inject(postCtx, header)
+	post.End()
+	cycle.End()
+
+	if err := Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if headers.Get("X-Token") != "secret" {
+		t.Errorf("expected the configured headers to be sent")
+	}
+	if len(received.ResourceSpans) != 1 || len(received.ResourceSpans[0].InstrumentationLibrarySpans) != 1 {
+		t.Fatalf("unexpected export request: %+v", received)
+	}
+	resource := map[string]string{}
+	for _, kv := range received.ResourceSpans[0].Resource.Attributes {
+		resource[kv.Key] = kv.Value.GetStringValue()
+	}
+	if resource["service.name"] != defaultServiceName || resource["preflight.cluster_id"] != "test" {
+		t.Errorf("unexpected resource attributes: %v", resource)
+	}
+
+	spans := received.ResourceSpans[0].InstrumentationLibrarySpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	postSpan, cycleSpan := spans[0], spans[1]
+	if postSpan.Name != "POST" || cycleSpan.Name != "cycle" {
+		t.Fatalf("unexpected spans: %+v", spans)
+	}
+	if !bytes.Equal(postSpan.TraceId, cycleSpan.TraceId) || !bytes.Equal(postSpan.ParentSpanId, cycleSpan.SpanId) || len(cycleSpan.ParentSpanId) != 0 {
+		t.Errorf("expected the POST span to be a child of the cycle span: %+v", spans)
+	}
+	if postSpan.Kind != tracepb.Span_SPAN_KIND_CLIENT || postSpan.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("unexpected POST span: %+v", postSpan)
+	}
+	if len(postSpan.Attributes) != 1 || postSpan.Attributes[0].Value.GetIntValue() != 500 {
+		t.Errorf("unexpected POST span attributes: %+v", postSpan.Attributes)
+	}
+	if expected := fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(postSpan.TraceId), hex.EncodeToString(postSpan.SpanId)); header.Get("traceparent") != expected {
+		t.Errorf("expected traceparent %q, got %q", expected, header.Get("traceparent"))
+	}
+
+	// spans are only exported once
+	received = &coltracepb.ExportTraceServiceRequest{}
+	if err := Flush(context.Background()); err != nil || len(received.ResourceSpans) != 0 {
+		t.Errorf("expected nothing to be exported, got %+v %v", received, err)
+	}
+}
@@ -0,0 +1,227 @@
+// Package transport configures the proxy and the certificate authorities of
+// the outbound HTTP clients of the agent: the clients of the backend, of the
+// cloud provider APIs and of the outputs. Until Setup is called, the clients
+// use the proxy of the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment
+// variables and the system certificate authorities, as http.DefaultTransport.
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// Config is the configuration of the outbound HTTP clients.
+type Config struct {
+	// Proxy overrides the proxy of the environment variables.
+	Proxy *Proxy `yaml:"proxy,omitempty"`
+	// CABundle is the path to a PEM bundle of certificate authorities trusted
+	// in addition to the system ones, e.g. of a TLS intercepting proxy.
+	CABundle string `yaml:"ca-bundle,omitempty"`
+}
+
+// Proxy is the proxy the requests are sent through. The fields left empty
+// fall back to the environment variables.
+type Proxy struct {
+	// URL is the URL of the proxy, e.g. http://proxy.example.com:3128, used
+	// for both the http and https requests.
+	URL string `yaml:"url,omitempty"`
+	// NoProxy is a comma separated list of the hosts, domains and CIDRs
+	// reached without the proxy, as NO_PROXY.
+	NoProxy string `yaml:"no-proxy,omitempty"`
+	// Username is the user of the proxy basic authentication.
+	Username string `yaml:"username,omitempty"`
+	// PasswordFile is the path of the file holding the password of the
+	// proxy basic authentication.
+	PasswordFile string `yaml:"password-file,omitempty"`
+}
+
+// Validate validates the configuration.
+func (c *Config) Validate() error {
+	if c.Proxy == nil {
+		return nil
+	}
+	if c.Proxy.URL != "" {
+		u, err := url.Parse(c.Proxy.URL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("proxy.url %q is not a valid URL", c.Proxy.URL)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("proxy.url %q must use http, https or socks5", c.Proxy.URL)
+		}
+	}
+	if c.Proxy.PasswordFile != "" && c.Proxy.Username == "" {
+		return fmt.Errorf("proxy.username is required with proxy.password-file")
+	}
+	return nil
+}
+
+var (
+	globalMu sync.RWMutex
+	global   = http.DefaultTransport.(*http.Transport).Clone()
+)
+
+// Setup configures the transport of the clients created afterwards.
+func Setup(c Config) error {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.Proxy != nil {
+		proxy, err := c.Proxy.proxyFunc()
+		if err != nil {
+			return err
+		}
+		t.Proxy = proxy
+	}
+
+	if c.CABundle != "" {
+		pool, err := certPool(c.CABundle)
+		if err != nil {
+			return err
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	global = t
+	return nil
+}
+
+// proxyFunc returns the proxy function of http.Transport, the fields of the
+// configuration override the environment variables.
+func (p *Proxy) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	config := httpproxy.FromEnvironment()
+	if p.URL != "" {
+		config.HTTPProxy = p.URL
+		config.HTTPSProxy = p.URL
+	}
+	if p.NoProxy != "" {
+		config.NoProxy = p.NoProxy
+	}
+
+	if p.Username != "" {
+		password := ""
+		if p.PasswordFile != "" {
+			data, err := ioutil.ReadFile(p.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the proxy password: %v", err)
+			}
+			password = strings.TrimSpace(string(data))
+		}
+		// the transport sends the user info of the proxy URL in the
+		// Proxy-Authorization header, CONNECT requests included
+		var err error
+		if config.HTTPProxy, err = withUser(config.HTTPProxy, p.Username, password); err != nil {
+			return nil, err
+		}
+		if config.HTTPSProxy, err = withUser(config.HTTPSProxy, p.Username, password); err != nil {
+			return nil, err
+		}
+	}
+
+	proxy := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
+// withUser adds the credentials to the proxy URL, if one is set.
+func withUser(proxyURL, username, password string) (string, error) {
+	if proxyURL == "" {
+		return "", nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		// httpproxy accepts proxies without a scheme, as host:port
+		if u, err = url.Parse("http://" + proxyURL); err != nil {
+			return "", fmt.Errorf("invalid proxy URL %q: %v", proxyURL, err)
+		}
+	}
+	u.User = url.UserPassword(username, password)
+	return u.String(), nil
+}
+
+// certPool returns the system certificate authorities with the ones of the
+// bundle.
+func certPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA bundle: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in the CA bundle %q", path)
+	}
+	return pool, nil
+}
+
+// SetCABundle makes the transport trust the certificate authorities of the
+// bundle in addition to the system ones, for a destination served with a
+// certificate of another CA than the ones of the configuration.
+func SetCABundle(t *http.Transport, path string) error {
+	pool, err := certPool(path)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{}
+	if t.TLSClientConfig != nil {
+		tlsConfig = t.TLSClientConfig.Clone()
+	}
+	tlsConfig.RootCAs = pool
+	t.TLSClientConfig = tlsConfig
+	return nil
+}
+
+// New returns a transport with the configured proxy and certificate
+// authorities. Each call returns a new transport the caller can modify.
+func New() *http.Transport {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return global.Clone()
+}
+
+// TLSConfig returns the TLS configuration of the clients connecting without
+// HTTP, e.g. to a message broker, with the configured certificate
+// authorities.
+func TLSConfig() *tls.Config {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	if global.TLSClientConfig == nil {
+		return &tls.Config{}
+	}
+	return global.TLSClientConfig.Clone()
+}
+
+// Client returns a client using a new transport, with the timeout.
+func Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: New(), Timeout: timeout}
+}
+
+// GoogleClientOption returns the option of a client of the Google APIs
+// authenticated with the options, sending both the requests to the API and
+// those refreshing the OAuth2 token through the configured transport.
+func GoogleClientOption(ctx context.Context, opts ...option.ClientOption) (option.ClientOption, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, Client(0))
+	trans, err := htransport.NewTransport(ctx, New(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return option.WithHTTPClient(&http.Client{Transport: trans}), nil
+}
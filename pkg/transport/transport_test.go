@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := parseProxyAuthorization(r)
+		if user != "agent" || password != "secret" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		proxied = append(proxied, r.URL.Host)
+	}))
+	defer proxy.Close()
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = Setup(Config{Proxy: &Proxy{
+		URL:          proxy.URL,
+		NoProxy:      "direct.example.com",
+		Username:     "agent",
+		PasswordFile: passwordFile,
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer Setup(Config{})
+
+	client := Client(time.Second)
+	res, err := client.Get("http://backend.example.com/api")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || len(proxied) != 1 || proxied[0] != "backend.example.com" {
+		t.Errorf("expected the request to be proxied, got %d %v", res.StatusCode, proxied)
+	}
+
+	p, err := client.Transport.(*http.Transport).Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "direct.example.com"}})
+	if err != nil || p != nil {
+		t.Errorf("expected no proxy for the hosts of no-proxy, got %v %v", p, err)
+	}
+}
+
+func parseProxyAuthorization(r *http.Request) (string, string, bool) {
+	header := r.Header.Get("Proxy-Authorization")
+	if header == "" {
+		return "", "", false
+	}
+	req := &http.Request{Header: http.Header{"Authorization": {header}}}
+	return req.BasicAuth()
+}
+
+func TestCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if _, err := Client(time.Second).Get(server.URL); err == nil {
+		t.Fatalf("expected the certificate of the server not to be trusted")
+	}
+
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(bundle, certPEM, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := Setup(Config{CABundle: bundle}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer Setup(Config{})
+	res, err := Client(time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close()
+	// the clients connecting without HTTP trust the bundle too
+	if TLSConfig().RootCAs == nil {
+		t.Errorf("expected the TLS configuration to have the certificate authorities of the bundle")
+	}
+
+	if err := ioutil.WriteFile(bundle, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := Setup(Config{CABundle: bundle}); err == nil {
+		t.Errorf("expected error for a bundle without certificates")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []Config{
+		{Proxy: &Proxy{URL: "ftp://proxy:21"}},
+		{Proxy: &Proxy{URL: "http://proxy:3128", PasswordFile: "/password"}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected error for %+v", c.Proxy)
+		}
+	}
+	if err := (&Config{Proxy: &Proxy{URL: "http://proxy:3128", Username: "agent"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}